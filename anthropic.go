@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// defaultAnthropicEndpoint is the base URL of the Anthropic API.
+const defaultAnthropicEndpoint = "https://api.anthropic.com"
+
+// anthropicVersion is the API version sent in the anthropic-version header.
+const anthropicVersion = "2023-06-01"
+
+// anthropicGenerator calls the Anthropic Messages API.
+type anthropicGenerator struct {
+	Endpoint  string
+	Model     string
+	MaxTokens int
+	apiKey    string
+}
+
+type anthropicRequest struct {
+	Model     string             `json:"model"`
+	MaxTokens int                `json:"max_tokens"`
+	Messages  []anthropicMessage `json:"messages"`
+}
+
+type anthropicMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type anthropicResponse struct {
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	StopReason string `json:"stop_reason"`
+	Usage      struct {
+		InputTokens  int `json:"input_tokens"`
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+}
+
+type anthropicErrorResponse struct {
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func newAnthropicGenerator(config *providerConfig, apiKey string) *anthropicGenerator {
+	g := &anthropicGenerator{
+		Endpoint:  defaultAnthropicEndpoint,
+		Model:     config.Model,
+		MaxTokens: defaultMaxTokens,
+		apiKey:    apiKey,
+	}
+	if config.Endpoint != "" {
+		g.Endpoint = strings.TrimRight(config.Endpoint, "/")
+	}
+	if config.MaxTokens > 0 {
+		g.MaxTokens = config.MaxTokens
+	}
+	return g
+}
+
+func (g *anthropicGenerator) Name() string { return "Anthropic" }
+
+// Generate sends the prompt as a single user message and returns the concatenated text blocks.
+func (g *anthropicGenerator) Generate(ctx context.Context, prompt string) (generation, error) {
+	req := anthropicRequest{
+		Model:     g.Model,
+		MaxTokens: g.MaxTokens,
+		Messages:  []anthropicMessage{{Role: "user", Content: prompt}},
+	}
+	status, body, err := postJSON(ctx, g.Endpoint+"/v1/messages", map[string]string{
+		"x-api-key":         g.apiKey,
+		"anthropic-version": anthropicVersion,
+	}, req)
+	if err != nil {
+		return generation{}, fmt.Errorf("failed to send request to Anthropic: %w", err)
+	}
+	if status != http.StatusOK {
+		return generation{}, anthropicError(status, body)
+	}
+
+	var resp anthropicResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return generation{}, fmt.Errorf("failed to decode Anthropic response: %w", err)
+	}
+	var text strings.Builder
+	for _, block := range resp.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+	if resp.StopReason == "max_tokens" {
+		fmt.Printf("Warning: Anthropic stopped at max_tokens (%d); the summary may be cut off.\n", g.MaxTokens)
+	}
+	return generation{
+		Text:  strings.TrimSpace(text.String()),
+		Usage: tokenUsage{PromptTokens: resp.Usage.InputTokens, OutputTokens: resp.Usage.OutputTokens},
+	}, nil
+}
+
+// anthropicError maps an Anthropic error response to an error. Authentication, permission,
+// not-found and invalid-request errors are permanent, except for prompts that are too long for
+// the model, which the degradation ladder shortens; overload (529), rate limits and server
+// errors are retryable.
+func anthropicError(status int, body []byte) error {
+	var resp anthropicErrorResponse
+	errType, message := "", strings.TrimSpace(string(body))
+	if json.Unmarshal(body, &resp) == nil && resp.Error.Type != "" {
+		errType, message = resp.Error.Type, resp.Error.Message
+	}
+	err := fmt.Errorf("Anthropic API request failed with status %d (%s): %s", status, errType, message)
+	switch {
+	case errType == "authentication_error" || errType == "permission_error" || errType == "not_found_error",
+		status == http.StatusUnauthorized || status == http.StatusForbidden || status == http.StatusNotFound,
+		errType == "invalid_request_error" && !isLengthFailure(err):
+		return &permanentError{err: err, Status: status, Body: string(body)}
+	}
+	return err
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newFakeProvider starts a server answering every request with status and body, and records
+// the last request's headers and decoded body.
+func newFakeProvider(t *testing.T, status int, body string) (*httptest.Server, *http.Header, *map[string]any) {
+	t.Helper()
+	var header http.Header
+	var request map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		header.Set("X-Path", r.URL.Path)
+		json.NewDecoder(r.Body).Decode(&request)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(server.Close)
+	return server, &header, &request
+}
+
+func TestAnthropicGenerate(t *testing.T) {
+	server, header, request := newFakeProvider(t, http.StatusOK, `{
+		"id": "msg_01", "type": "message", "role": "assistant", "model": "claude-test",
+		"content": [{"type": "text", "text": "Adds the parser. "}, {"type": "tool_use", "id": "x"}, {"type": "text", "text": "No tests.\n"}],
+		"stop_reason": "end_turn",
+		"usage": {"input_tokens": 1200, "output_tokens": 35}
+	}`)
+	g := newAnthropicGenerator(&providerConfig{Model: "claude-test", MaxTokens: 300, Endpoint: server.URL + "/"}, "sk-test")
+	gen, err := g.Generate(context.Background(), "the prompt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gen.Text != "Adds the parser. No tests." || gen.Usage != (tokenUsage{PromptTokens: 1200, OutputTokens: 35}) {
+		t.Errorf("generation = %+v", gen)
+	}
+	if header.Get("X-Path") != "/v1/messages" || header.Get("x-api-key") != "sk-test" || header.Get("anthropic-version") != anthropicVersion {
+		t.Errorf("request headers = %v", *header)
+	}
+	messages, _ := (*request)["messages"].([]any)
+	if (*request)["model"] != "claude-test" || (*request)["max_tokens"] != float64(300) || len(messages) != 1 {
+		t.Errorf("request = %v", *request)
+	}
+}
+
+func TestAnthropicErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		body      string
+		permanent bool
+		length    bool
+	}{
+		{"invalid key", 401, `{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`, true, false},
+		{"permission", 403, `{"type":"error","error":{"type":"permission_error","message":"Your API key does not have permission"}}`, true, false},
+		{"unknown model", 404, `{"type":"error","error":{"type":"not_found_error","message":"model: claude-nope"}}`, true, false},
+		{"malformed request", 400, `{"type":"error","error":{"type":"invalid_request_error","message":"messages: roles must alternate"}}`, true, false},
+		{"prompt too long", 400, `{"type":"error","error":{"type":"invalid_request_error","message":"prompt is too long: 210000 tokens > 200000 maximum"}}`, false, true},
+		{"request too large", 413, `{"type":"error","error":{"type":"request_too_large","message":"Request exceeds the maximum allowed number of bytes."}}`, false, true},
+		{"rate limited", 429, `{"type":"error","error":{"type":"rate_limit_error","message":"Number of requests has exceeded your rate limit"}}`, false, false},
+		{"overloaded", 529, `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`, false, false},
+		{"server error", 500, `{"type":"error","error":{"type":"api_error","message":"Internal server error"}}`, false, false},
+		{"proxy page", 502, `<html>Bad Gateway</html>`, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, _, _ := newFakeProvider(t, tt.status, tt.body)
+			g := newAnthropicGenerator(&providerConfig{Model: "claude-test", Endpoint: server.URL}, "sk-test")
+			_, err := g.Generate(context.Background(), "the prompt")
+			if err == nil {
+				t.Fatal("Generate succeeded")
+			}
+			if isPermanent(err) != tt.permanent {
+				t.Errorf("isPermanent(%v) = %v", err, !tt.permanent)
+			}
+			if isLengthFailure(err) != tt.length {
+				t.Errorf("isLengthFailure(%v) = %v", err, !tt.length)
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Values of -format.
+const (
+	formatText     = "text"
+	formatJSON     = "json"
+	formatMbox     = "mbox"
+	formatPatchDir = "patchdir"
+)
+
+// patchFileName matches the files of a -format patchdir directory, e.g. "0003-fix-login.patch".
+var patchFileName = regexp.MustCompile(`^\d{4}-.*\.patch$`)
+
+// archivePath returns where a -format writes, derived from the report path: gitaudit.json,
+// gitaudit.mbox, or the directory gitaudit-patches.
+func archivePath(format, reportPath string) string {
+	base := strings.TrimSuffix(reportPath, filepath.Ext(reportPath))
+	switch format {
+	case formatJSON:
+		return base + ".json"
+	case formatMbox:
+		return base + ".mbox"
+	}
+	return base + "-patches"
+}
+
+// archiveMessage is one audited commit as a patch email.
+type archiveMessage struct {
+	Hash    string
+	Subject string
+	Text    string
+}
+
+// writeArchive writes the entries that `git am` can apply as patch emails whose message is
+// the generated summary: one mbox file, or a directory of numbered files like
+// `git format-patch` writes. order lists the audited hashes newest first; the emails are
+// written oldest first so that the series applies in order. Tag entries, and merges unless
+// firstParent describes them by their diff against the first parent, have no patch and are
+// counted as skipped, as are the commits -tests-and-docs skip left unaudited.
+func writeArchive(format, path string, opts *auditOptions, entries []CommitAuditData, order []string, generatedBy string) (written, skipped int, err error) {
+	position := make(map[string]int, len(order))
+	for i, hash := range order {
+		position[hash] = i
+	}
+	var kept []CommitAuditData
+	for _, entry := range entries {
+		if entry.Kind == kindTag || (entry.ParentCount > 1 && !opts.FirstParent) || entry.TestsAndDocs == testsAndDocsSkip {
+			skipped++
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	sort.SliceStable(kept, func(i, j int) bool { return position[kept[i].Hash] > position[kept[j].Hash] })
+
+	var messages []archiveMessage
+	for i, entry := range kept {
+		message, err := formatArchiveMessage(opts, entry, i+1, len(kept), generatedBy)
+		if err != nil {
+			return 0, skipped, err
+		}
+		messages = append(messages, message)
+	}
+
+	if format == formatMbox {
+		var sb strings.Builder
+		for _, message := range messages {
+			sb.WriteString(message.Text)
+		}
+		if err := writeFileAtomic(path, []byte(sb.String())); err != nil {
+			return 0, skipped, fmt.Errorf("failed to write %s: %w", path, err)
+		}
+		// writeFileAtomic uses CreateTemp's 0600; the mbox is as readable as the report.
+		os.Chmod(path, 0o644)
+		return len(messages), skipped, nil
+	}
+
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return 0, skipped, fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	current := make(map[string]bool)
+	for i, message := range messages {
+		name := fmt.Sprintf("%04d-%s.patch", i+1, patchSlug(message.Subject))
+		current[name] = true
+		file := filepath.Join(path, name)
+		if err := writeFileAtomic(file, []byte(message.Text)); err != nil {
+			return i, skipped, fmt.Errorf("failed to write %s: %w", file, err)
+		}
+		os.Chmod(file, 0o644)
+	}
+	// Patches of an earlier, longer series would otherwise be applied after this one.
+	if existing, err := os.ReadDir(path); err == nil {
+		for _, e := range existing {
+			if patchFileName.MatchString(e.Name()) && !current[e.Name()] {
+				os.Remove(filepath.Join(path, e.Name()))
+			}
+		}
+	}
+	return len(messages), skipped, nil
+}
+
+// formatArchiveMessage renders an entry as patch email n of total: mbox delimiter, From,
+// Date and Subject headers, the generated summary with a Generated-by trailer, and the
+// commit's diffstat and binary-safe patch.
+func formatArchiveMessage(opts *auditOptions, entry CommitAuditData, n, total int, generatedBy string) (archiveMessage, error) {
+	output, err := gitRun(context.Background(), opts.RepoPath, "show", "--no-patch", "--format=%an%x00%ae%x00%aD", entry.Hash)
+	if err != nil {
+		return archiveMessage{}, fmt.Errorf("failed to read the author of commit %s: %w", entry.Hash, err)
+	}
+	fields := strings.SplitN(strings.TrimSpace(string(output)), "\x00", 3)
+	if len(fields) != 3 {
+		return archiveMessage{}, fmt.Errorf("failed to read the author of commit %s: unexpected output %q", entry.Hash, output)
+	}
+	name, email, date := fields[0], fields[1], fields[2]
+
+	patch, err := getArchivePatch(opts, entry)
+	if err != nil {
+		return archiveMessage{}, err
+	}
+
+	subject, body, _ := strings.Cut(strings.TrimSpace(entry.Summary), "\n")
+	subject = strings.TrimSpace(subject)
+	body = strings.TrimSpace(body)
+	prefix := "[PATCH]"
+	if total > 1 {
+		prefix = fmt.Sprintf("[PATCH %d/%d]", n, total)
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "From %s Mon Sep 17 00:00:00 2001\n", entry.Hash)
+	fmt.Fprintf(&sb, "From: %s <%s>\n", mime.QEncoding.Encode("utf-8", name), email)
+	fmt.Fprintf(&sb, "Date: %s\n", date)
+	fmt.Fprintf(&sb, "Subject: %s\n", encodeSubject(prefix+" "+subject))
+	sb.WriteString("MIME-Version: 1.0\nContent-Type: text/plain; charset=UTF-8\nContent-Transfer-Encoding: 8bit\n\n")
+	if body != "" {
+		sb.WriteString(body + "\n\n")
+	}
+	if entry.ChangeCategory != "" && entry.ChangeCategory != categoryCode {
+		fmt.Fprintf(&sb, "Change-category: %s\n", entry.ChangeCategory)
+	}
+	fmt.Fprintf(&sb, "Generated-by: %s\n---\n%s", generatedBy, patch)
+	if !strings.HasSuffix(patch, "\n") {
+		sb.WriteString("\n")
+	}
+	sb.WriteString("\n")
+	return archiveMessage{Hash: entry.Hash, Subject: subject, Text: sb.String()}, nil
+}
+
+// getArchivePatch returns the diffstat and full-index binary patch of an entry, as
+// `git format-patch` writes them after the message. Stash entries are diffed against their
+// base and, with -first-parent, merges against their first parent. The audit's pathspec is
+// not applied, so that the patch reproduces the whole commit.
+func getArchivePatch(opts *auditOptions, entry CommitAuditData) (string, error) {
+	diffArgs := []string{"--stat", "--summary", "--binary", "--full-index"}
+	if base := opts.Targets[entry.Hash].DiffBase; base != "" {
+		args := append(append([]string{"diff"}, diffArgs...), base, entry.Hash)
+		output, err := gitRun(context.Background(), opts.RepoPath, args...)
+		if err != nil {
+			return "", fmt.Errorf("failed to execute git diff for %s: %w", entry.Ref, err)
+		}
+		return string(output), nil
+	}
+	if opts.FirstParent && entry.ParentCount > 1 {
+		diffArgs = append(diffArgs, "--diff-merges=first-parent")
+	}
+	// Nothing is withheld from the header, which is dropped: the email carries the metadata.
+	patch, err := getPatchForCommit(context.Background(), opts.RepoPath, entry.Hash, promptPrivacy{}, nil, diffArgs...)
+	if err != nil {
+		return "", err
+	}
+	_, diff, _ := strings.Cut(patch, "\n\n")
+	return diff, nil
+}
+
+// encodeSubject RFC 2047-encodes a Subject header value that is not plain ASCII, as
+// `git format-patch` does.
+func encodeSubject(subject string) string {
+	for _, r := range subject {
+		if r >= 0x80 {
+			return mime.QEncoding.Encode("utf-8", subject)
+		}
+	}
+	return subject
+}
+
+// patchSlug turns a subject into the file name part `git format-patch` would use: runs of
+// characters other than letters, digits, "." and "_" become "-", capped at 52 characters.
+func patchSlug(subject string) string {
+	var sb strings.Builder
+	dash := false
+	for _, r := range subject {
+		if r < 0x80 && (r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_') {
+			if dash && sb.Len() > 0 {
+				sb.WriteByte('-')
+			}
+			dash = false
+			sb.WriteRune(r)
+		} else {
+			dash = true
+		}
+		if sb.Len() >= 52 {
+			break
+		}
+	}
+	slug := strings.TrimRight(sb.String(), ".-")
+	if len(slug) > 52 {
+		slug = slug[:52]
+	}
+	if slug == "" {
+		return "patch"
+	}
+	return slug
+}
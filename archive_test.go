@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEncodeSubject(t *testing.T) {
+	for subject, want := range map[string]string{
+		"[PATCH] Fix the parser":     "[PATCH] Fix the parser",
+		"[PATCH] Réparer le parseur": "=?utf-8?q?[PATCH]_R=C3=A9parer_le_parseur?=",
+		"":                           "",
+	} {
+		if got := encodeSubject(subject); got != want {
+			t.Errorf("encodeSubject(%q) = %q, want %q", subject, got, want)
+		}
+	}
+}
+
+func TestPatchSlug(t *testing.T) {
+	for subject, want := range map[string]string{
+		"Add retry backoff":                  "Add-retry-backoff",
+		"Fix: the parser (again)...":         "Fix-the-parser-again",
+		"Bump v1.2.3 to v1.2.4":              "Bump-v1.2.3-to-v1.2.4",
+		"Réparer le café":                    "R-parer-le-caf",
+		"!!!":                                "patch",
+		"":                                   "patch",
+		strings.Repeat("word ", 20):          "word-word-word-word-word-word-word-word-word-word-wo",
+		"snake_case and dots. stay.  spaced": "snake_case-and-dots.-stay.-spaced",
+	} {
+		if got := patchSlug(subject); got != want {
+			t.Errorf("patchSlug(%q) = %q, want %q", subject, got, want)
+		}
+	}
+}
+
+func TestArchivePath(t *testing.T) {
+	for format, want := range map[string]string{
+		formatJSON:     "/tmp/audit.json",
+		formatMbox:     "/tmp/audit.mbox",
+		formatPatchDir: "/tmp/audit-patches",
+	} {
+		if got := archivePath(format, "/tmp/audit.txt"); got != want {
+			t.Errorf("archivePath(%s) = %q, want %q", format, got, want)
+		}
+	}
+}
+
+// newArchiveFixture creates a repository whose commits add, modify, rename and delete files,
+// one of them binary and one by another author, after a base commit. It returns the base and
+// the first commit after it, where an audit of the three commits starts.
+func newArchiveFixture(t *testing.T) (*fixtureRepo, string, string) {
+	t.Helper()
+	repo := newFixtureRepo(t)
+	base := repo.commit("Initial", map[string]string{"README": "app\n", "old.txt": "old\n"})
+	first := repo.commit("Add the parser", map[string]string{"src/parse.go": "package src\n\nfunc parse() {}\n"})
+	repo.commitEnv([]string{"GIT_AUTHOR_NAME=Zoë Müller", "GIT_AUTHOR_EMAIL=zoe@example.com"}, "Ajouter le logo",
+		map[string]string{"logo.png": "\x89PNG\r\n\x1a\n\x00\x00\x00binary\x00"})
+	repo.write(map[string]string{"docs/keep": "x\n"})
+	repo.git("mv", "old.txt", "docs/old.txt")
+	repo.commit("Move and edit", map[string]string{"README": "the app\n", "src/parse.go": ""})
+	return repo, base, first
+}
+
+// applyArchive applies patch emails with `git am` to a clone of repo checked out at base and
+// returns the clone.
+func applyArchive(t *testing.T, repo *fixtureRepo, base string, files ...string) *fixtureRepo {
+	t.Helper()
+	clone := &fixtureRepo{t: t, Dir: t.TempDir(), When: repo.When}
+	clone.git("clone", "-q", repo.Dir, clone.Dir)
+	clone.git("config", "user.name", "Archivist")
+	clone.git("config", "user.email", "archivist@example.com")
+	clone.git("checkout", "-q", "-b", "restored", base)
+	clone.git(append([]string{"am", "-q"}, files...)...)
+	return clone
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	repo, base, first := newArchiveFixture(t)
+	env := newAuditEnv(t)
+	// The binary commit's summary has a non-ASCII subject line.
+	env.Ollama.Respond = func(n int, prompt string) (int, string) {
+		if !strings.Contains(prompt, "Ajouter le logo") {
+			return 0, ""
+		}
+		body, _ := json.Marshal(map[string]any{"response": "Ajoute le logo de l'équipe\n\nLe fichier est une image PNG.", "done": true})
+		return 200, string(body)
+	}
+
+	report := filepath.Join(env.Work, "audit.txt")
+	out := env.mustRun("-repo", repo.Dir, "-commit", first, "-format", "mbox", "-output", report)
+	mbox := filepath.Join(env.Work, "audit.mbox")
+	content := readFile(t, mbox)
+	if _, err := os.Stat(report); err == nil {
+		t.Errorf("-format mbox wrote the text report\n%s", out)
+	}
+	for _, want := range []string{
+		"From: =?utf-8?q?Zo=C3=AB_M=C3=BCller?= <zoe@example.com>\n",
+		"Subject: =?utf-8?q?[PATCH_2/3]_Ajoute_le_logo_de_l'=C3=A9quipe?=\n",
+		"Le fichier est une image PNG.\n\nGenerated-by: gitaudit",
+		"GIT binary patch\n",
+		"rename from old.txt\n",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("mbox lacks %q:\n%s", want, content)
+		}
+	}
+	// The series is oldest first and the base is not part of it.
+	if strings.Count(content, "\nFrom: ") != 3 || strings.Contains(content, "From "+base+" ") || strings.Index(content, "[PATCH 1/3] Summary") > strings.Index(content, "[PATCH_2/3]") {
+		t.Errorf("mbox order:\n%s", content)
+	}
+
+	clone := applyArchive(t, repo, base, mbox)
+	if got, want := clone.git("rev-parse", "HEAD^{tree}"), repo.git("rev-parse", "HEAD^{tree}"); got != want {
+		t.Errorf("restored tree %s, want %s", got, want)
+	}
+	// The authors and dates are the original ones; the messages are the summaries.
+	if got, want := clone.git("log", "--format=%an <%ae> %aI", base+"..HEAD"), repo.git("log", "--format=%an <%ae> %aI", base+"..HEAD"); got != want {
+		t.Errorf("restored authors:\n%s\nwant:\n%s", got, want)
+	}
+	if got := clone.git("log", "-1", "--format=%B", "HEAD~1"); !strings.HasPrefix(got, "Ajoute le logo de l'équipe\n\nLe fichier est une image PNG.") {
+		t.Errorf("restored message:\n%s", got)
+	}
+
+	// The patchdir series applies the same way, and a shorter series replaces a longer one.
+	dir := filepath.Join(env.Work, "audit-patches")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	stale := filepath.Join(dir, "0004-Stale.patch")
+	if err := os.WriteFile(stale, []byte("stale"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	env.mustRun("-repo", repo.Dir, "-commit", first, "-format", "patchdir", "-output", report, "-force")
+	files, _ := filepath.Glob(filepath.Join(dir, "*.patch"))
+	var names []string
+	for _, file := range files {
+		names = append(names, filepath.Base(file))
+	}
+	if len(names) != 3 || !strings.HasPrefix(names[0], "0001-Summary-") || names[1] != "0002-Ajoute-le-logo-de-l-quipe.patch" || !strings.HasPrefix(names[2], "0003-") {
+		t.Errorf("patch files %q", names)
+	}
+	clone = applyArchive(t, repo, base, files...)
+	if got, want := clone.git("rev-parse", "HEAD^{tree}"), repo.git("rev-parse", "HEAD^{tree}"); got != want {
+		t.Errorf("tree restored from the patch directory %s, want %s", got, want)
+	}
+	if count := clone.git("rev-list", "--count", base+"..HEAD"); count != "3" {
+		t.Errorf("%s commits restored from the patch directory, want 3", count)
+	}
+}
+
+func TestArchiveSkipsMerges(t *testing.T) {
+	f := newMergeFixture(t)
+	env := newAuditEnv(t)
+	report := filepath.Join(env.Work, "audit.txt")
+	out := env.mustRun("-repo", f.Repo.Dir, "-commit", "root", "-format", "mbox", "-output", report)
+	content := readFile(t, filepath.Join(env.Work, "audit.mbox"))
+	if strings.Contains(content, "From "+f.Octopus+" ") || strings.Contains(content, "From "+f.M3+" ") {
+		t.Errorf("the mbox has a merge:\n%s\n%s", content, out)
+	}
+	if !strings.Contains(content, "From "+f.B1+" ") {
+		t.Errorf("the mbox lacks a branch commit:\n%s", content)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultRollupInputBytes bounds the summaries sent in one author rollup request when no
+// prompt budget is configured.
+const defaultRollupInputBytes = 32 * 1024
+
+// authorRollup is the per-author section of the report.
+type authorRollup struct {
+	Name      string
+	Email     string
+	Commits   []string
+	Added     int
+	Deleted   int
+	Summary   string
+	summaries []string
+}
+
+// changeStats is the mailmap-aware identity and line counts of one commit, shared by the
+// author rollup and the change heatmap.
+type changeStats struct {
+	Name    string
+	Email   string
+	Added   int
+	Deleted int
+	Files   []fileStats
+}
+
+// fileStats are the line counts of one file of a commit, under its new path for a rename.
+type fileStats struct {
+	Path    string
+	Added   int
+	Deleted int
+}
+
+// getChangeStats returns the canonical author (after .mailmap) and numstat line counts of
+// each given commit, limited to pathspec when it is set. Binary files count as zero lines,
+// and renames count only their changed lines.
+func getChangeStats(repoPath string, hashes []string, pathspec []string) (map[string]changeStats, error) {
+	args := []string{"log", "--no-walk=unsorted", "--stdin", "--numstat", "-M", "--format=@@%H%x00%aN%x00%aE"}
+	if len(pathspec) > 0 {
+		args = append(append(args, "--"), pathspec...)
+	}
+	output, err := gitRunInput(context.Background(), repoPath, strings.NewReader(strings.Join(hashes, "\n")+"\n"), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute git log --numstat: %w", err)
+	}
+
+	stats := make(map[string]changeStats)
+	var current string
+	for _, line := range strings.Split(string(output), "\n") {
+		if strings.HasPrefix(line, "@@") {
+			parts := strings.SplitN(line[2:], "\x00", 3)
+			if len(parts) == 3 {
+				current = parts[0]
+				stats[current] = changeStats{Name: parts[1], Email: parts[2]}
+			}
+			continue
+		}
+		fields := strings.SplitN(line, "\t", 3)
+		if current == "" || len(fields) < 3 {
+			continue
+		}
+		s := stats[current]
+		added, _ := strconv.Atoi(fields[0]) // "-" for binary files
+		deleted, _ := strconv.Atoi(fields[1])
+		s.Added += added
+		s.Deleted += deleted
+		s.Files = append(s.Files, fileStats{Path: numstatNewPath(fields[2]), Added: added, Deleted: deleted})
+		stats[current] = s
+	}
+	return stats, nil
+}
+
+// numstatNewPath returns the new path of a numstat path, which names a rename as
+// "old => new" or "dir/{old => new}/file".
+func numstatNewPath(path string) string {
+	open, close := strings.Index(path, "{"), strings.LastIndex(path, "}")
+	if open >= 0 && close > open {
+		if _, renamed, ok := strings.Cut(path[open+1:close], " => "); ok {
+			return strings.ReplaceAll(path[:open]+renamed+path[close+1:], "//", "/")
+		}
+	}
+	if _, renamed, ok := strings.Cut(path, " => "); ok {
+		return renamed
+	}
+	return path
+}
+
+// buildAuthorRollups groups the audited commit entries by canonical author and synthesizes a
+// paragraph per author. Authors with a single commit reuse that commit's summary. A failed
+// request only loses that author's paragraph.
+func buildAuthorRollups(opts *auditOptions, entries []CommitAuditData) ([]*authorRollup, error) {
+	var hashes []string
+	summaries := make(map[string]string)
+	for _, entry := range entries {
+		if entry.Kind == kindTag {
+			continue
+		}
+		if _, seen := summaries[entry.Hash]; !seen {
+			hashes = append(hashes, entry.Hash)
+		}
+		summaries[entry.Hash] = entry.Summary
+	}
+	if len(hashes) == 0 {
+		return nil, nil
+	}
+	stats, err := getChangeStats(opts.RepoPath, hashes, opts.Pathspec)
+	if err != nil {
+		return nil, err
+	}
+
+	byAuthor := make(map[string]*authorRollup)
+	var rollups []*authorRollup
+	for _, hash := range hashes {
+		s := stats[hash]
+		key := foldKey(s.Email)
+		rollup, ok := byAuthor[key]
+		if !ok {
+			rollup = &authorRollup{Name: s.Name, Email: s.Email}
+			byAuthor[key] = rollup
+			rollups = append(rollups, rollup)
+		}
+		rollup.Commits = append(rollup.Commits, hash)
+		rollup.Added += s.Added
+		rollup.Deleted += s.Deleted
+		rollup.summaries = append(rollup.summaries, summaries[hash])
+	}
+	sort.SliceStable(rollups, func(i, j int) bool {
+		if len(rollups[i].Commits) != len(rollups[j].Commits) {
+			return len(rollups[i].Commits) > len(rollups[j].Commits)
+		}
+		if a, b := foldKey(rollups[i].Name), foldKey(rollups[j].Name); a != b {
+			return a < b
+		}
+		return foldKey(rollups[i].Email) < foldKey(rollups[j].Email)
+	})
+
+	var counter tokenizer = heuristicTokenizer{}
+	limit := defaultRollupInputBytes / bytesPerToken
+	if opts.Budget != nil {
+		counter = opts.Budget.Tokenizer
+		limit = opts.Budget.ContextTokens * int(opts.Budget.Split.Patch) / 100
+	}
+	for _, rollup := range rollups {
+		if len(rollup.Commits) == 1 {
+			rollup.Summary = rollup.summaries[0]
+			continue
+		}
+		fmt.Printf("Generating author rollup for %s (%d commits)\n", rollup.Name, len(rollup.Commits))
+		prompt := buildAuthorRollupPrompt(rollup, opts.Privacy.filter(opts.Privacy.SendAuthor, rollup.Name), counter, limit)
+		result, err := opts.Generator.Generate(requestCtx, prompt)
+		if err != nil {
+			fmt.Printf("Warning: failed to generate the rollup for author %s: %v\n", rollup.Name, err)
+			rollup.Summary = fmt.Sprintf("(No rollup generated: %v)", err)
+			continue
+		}
+		rollup.Summary = result.Text
+	}
+	return rollups, nil
+}
+
+// buildAuthorRollupPrompt asks the model to synthesize an author's work from the summaries of
+// their commits, newest first, cut to limit tokens as counter counts them.
+func buildAuthorRollupPrompt(rollup *authorRollup, name string, counter tokenizer, limit int) string {
+	if name == "" {
+		name = "one author" // The name is withheld by send_author.
+	}
+	summaries := strings.Join(rollup.summaries, "\n\n---\n\n")
+	summaries = truncateTokens(counter, summaries, counter.Count(summaries), limit, "commit summaries")
+	return fmt.Sprintf(`The following are summaries of the %d commits made by %s in the audited range, newest first. Write one short paragraph synthesizing this person's work: the main areas they changed, the purpose of their changes, and any notable themes. Output only the paragraph itself.
+
+Commit summaries:
+%s`, len(rollup.Commits), name, summaries)
+}
+
+// writeAuthorRollup builds the author rollups once and appends them to each report. Any
+// failure is reported as a warning; the per-commit entries are already written by then.
+func writeAuthorRollup(opts *auditOptions, filenames []string, entries []CommitAuditData) {
+	rollups, err := buildAuthorRollups(opts, entries)
+	if err != nil {
+		fmt.Printf("Warning: failed to build the author rollup: %v\n", err)
+		return
+	}
+	if len(rollups) == 0 {
+		return
+	}
+	for _, filename := range filenames {
+		if err := appendAuthorSection(filename, rollups); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+			continue
+		}
+		fmt.Printf("Appended rollups for %d authors to %s\n", len(rollups), filename)
+	}
+}
+
+// appendAuthorSection appends the "Authors" section to the report.
+func appendAuthorSection(filename string, rollups []*authorRollup) error {
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open file %s: %w", filename, err)
+	}
+	defer file.Close()
+
+	var sb strings.Builder
+	sb.WriteString("\n---\n\n" + msg("section.authors") + "\n")
+	for _, rollup := range rollups {
+		line := msg("authors.line", rollup.Name, rollup.Email, msgCount("count.commits", len(rollup.Commits)), rollup.Added, rollup.Deleted)
+		fmt.Fprintf(&sb, "\n%s\n%s\n", line, rollup.Summary)
+	}
+	if _, err := file.WriteString(sb.String()); err != nil {
+		return fmt.Errorf("failed to write author section to file %s: %w", filename, err)
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// kindAutomated marks entries for commits made by automation (dependency bots, release
+// scripts, merge queues), whose summaries are generated locally from a template.
+const kindAutomated = "automated"
+
+// Summary templates an automation rule can select.
+const (
+	automationDependencyBump = "dependency-bump"
+	automationRelease        = "release"
+)
+
+// automationRule recognizes commits made by a piece of automation. Author is matched against
+// "Name <email>" and Message against the subject line; a rule matches when every pattern it
+// sets matches. Summary selects the template used instead of the model.
+type automationRule struct {
+	Name    string `json:"name"`
+	Author  string `json:"author"`
+	Message string `json:"message"`
+	Summary string `json:"summary"`
+
+	author  *regexp.Regexp
+	message *regexp.Regexp
+}
+
+// defaultAutomationRules are always checked after any configured automation_rules.
+var defaultAutomationRules = []automationRule{
+	{Name: "dependabot", Author: `(?i)dependabot`, Summary: automationDependencyBump},
+	{Name: "renovate", Author: `(?i)renovate(\[bot\])?`, Summary: automationDependencyBump},
+	{Name: "release-please", Message: `^chore(\([^)]*\))?!?: release\b`, Summary: automationRelease},
+	{Name: "merge-queue", Author: `(?i)github-merge-queue|mergify|bors\[bot\]`},
+}
+
+// compile validates the rule and compiles its patterns.
+func (r *automationRule) compile() error {
+	if r.Name == "" {
+		return errors.New("automation rule without a name")
+	}
+	if r.Author == "" && r.Message == "" {
+		return fmt.Errorf("automation rule %s must set 'author' or 'message'", r.Name)
+	}
+	switch r.Summary {
+	case "", automationDependencyBump, automationRelease:
+	default:
+		return fmt.Errorf("automation rule %s: unknown summary %q (expected %q, %q or none)", r.Name, r.Summary, automationDependencyBump, automationRelease)
+	}
+	var err error
+	if r.Author != "" {
+		if r.author, err = regexp.Compile(r.Author); err != nil {
+			return fmt.Errorf("automation rule %s: invalid author pattern: %w", r.Name, err)
+		}
+	}
+	if r.Message != "" {
+		if r.message, err = regexp.Compile(r.Message); err != nil {
+			return fmt.Errorf("automation rule %s: invalid message pattern: %w", r.Name, err)
+		}
+	}
+	return nil
+}
+
+// matches reports whether the rule applies to a commit.
+func (r *automationRule) matches(author, subject string) bool {
+	if r.author != nil && !r.author.MatchString(author) {
+		return false
+	}
+	if r.message != nil && !r.message.MatchString(subject) {
+		return false
+	}
+	return true
+}
+
+// newAutomationRules compiles the configured rules followed by the defaults.
+func newAutomationRules(configured []automationRule) ([]automationRule, error) {
+	rules := append(append([]automationRule{}, configured...), defaultAutomationRules...)
+	for i := range rules {
+		if err := rules[i].compile(); err != nil {
+			return nil, err
+		}
+	}
+	return rules, nil
+}
+
+// classifyAutomation returns the first rule matching the commit, or nil.
+func classifyAutomation(ctx context.Context, repoPath, commitHash string, rules []automationRule) (*automationRule, string, error) {
+	output, err := gitRun(ctx, repoPath, "log", "-1", "--format=%aN <%aE>%x00%s", commitHash)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to execute git log for commit %s: %w", commitHash, err)
+	}
+	author, subject, _ := strings.Cut(strings.TrimSpace(string(output)), "\x00")
+	for i := range rules {
+		if rules[i].matches(author, subject) {
+			return &rules[i], subject, nil
+		}
+	}
+	return nil, "", nil
+}
+
+// automatedSummary builds the templated summary for a commit matched by rule. When the
+// template's parser cannot make sense of the commit, a generic summary is used instead.
+func automatedSummary(ctx context.Context, repoPath, commitHash, subject string, rule *automationRule) (string, error) {
+	switch rule.Summary {
+	case automationDependencyBump:
+		bumps, err := getDependencyBumps(ctx, repoPath, commitHash)
+		if err != nil {
+			return "", err
+		}
+		if len(bumps) == 0 {
+			bumps = parseBumpSubject(subject)
+		}
+		if len(bumps) > 0 {
+			return "Dependency bump: " + strings.Join(bumps, "; "), nil
+		}
+	case automationRelease:
+		if m := releaseVersion.FindStringSubmatch(subject); m != nil {
+			return fmt.Sprintf("Release: version %s", m[1]), nil
+		}
+	}
+	return fmt.Sprintf("Automated change (%s): %s", rule.Name, subject), nil
+}
+
+// releaseVersion extracts the version from a conventional release subject such as
+// "chore(main): release 1.4.0" or "chore: release v2.0.0-rc.1".
+var releaseVersion = regexp.MustCompile(`: release (?:[\w@/.-]+ )?v?(\d+\.\d+\.\d+[\w.+-]*)`)
+
+// bumpSubject matches dependabot's "Bump lodash from 4.17.20 to 4.17.21" subjects.
+var bumpSubject = regexp.MustCompile(`(?i)\bbump (\S+) from (\S+) to (\S+)`)
+
+// parseBumpSubject reads a single bump from a commit subject.
+func parseBumpSubject(subject string) []string {
+	m := bumpSubject.FindStringSubmatch(subject)
+	if m == nil {
+		return nil
+	}
+	return []string{fmt.Sprintf("%s %s → %s", m[1], m[2], m[3])}
+}
+
+// getDependencyBumps describes the dependencies a commit bumps in its manifests as
+// "name old → new", leaving out the transitive changes of lockfiles.
+func getDependencyBumps(ctx context.Context, repoPath, commitHash string) ([]string, error) {
+	changes, err := getDependencyChanges(ctx, repoPath, commitHash)
+	if err != nil {
+		return nil, err
+	}
+	var bumps []string
+	for _, change := range changes {
+		if !change.Transitive {
+			bumps = append(bumps, change.String())
+		}
+	}
+	return bumps, nil
+}
+
+// formatAutomatedSection renders the collapsed "Automated changes" section of the text
+// report: one line per automated commit instead of a full entry.
+func formatAutomatedSection(entries []CommitAuditData) string {
+	var sb strings.Builder
+	sb.WriteString(msg("section.automated", msgCount("count.commits", len(entries))) + "\n")
+	for _, entry := range entries {
+		fmt.Fprintf(&sb, "%s %s [%s] %s", shortHash(entry.Hash), localDate(entry.Date), entry.AutomationRule, entry.Summary)
+		if len(entry.Vulnerabilities) > 0 {
+			ids := make([]string, len(entry.Vulnerabilities))
+			for i, v := range entry.Vulnerabilities {
+				ids[i] = fmt.Sprintf("%s [%s]", v.ID, v.Severity)
+			}
+			fmt.Fprintf(&sb, " (%s)", msg("section.automated_fixes", strings.Join(ids, ", ")))
+		} else if entry.VulnerabilityStatus != "" {
+			fmt.Fprintf(&sb, " (%s)", entry.VulnerabilityStatus)
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// dependabot is the identity dependabot commits with.
+var dependabot = []string{
+	"GIT_AUTHOR_NAME=dependabot[bot]",
+	"GIT_AUTHOR_EMAIL=49699333+dependabot[bot]@users.noreply.github.com",
+}
+
+func TestAutomationRuleMatches(t *testing.T) {
+	rules, err := newAutomationRules([]automationRule{{Name: "release-bot", Author: "^release-bot ", Summary: automationRelease}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		author  string
+		subject string
+		want    string
+	}{
+		{"dependabot[bot] <49699333+dependabot[bot]@users.noreply.github.com>", "Bump lodash from 4.17.20 to 4.17.21", "dependabot"},
+		{"renovate[bot] <bot@renovateapp.com>", "fix(deps): update module golang.org/x/net to v0.23.0", "renovate"},
+		{"Jane Doe <jane@example.com>", "chore(main): release 1.4.0", "release-please"},
+		{"Jane Doe <jane@example.com>", "chore!: release 2.0.0", "release-please"},
+		{"release-bot <ci@example.com>", "Cut 3.1.0", "release-bot"},
+		{"github-merge-queue[bot] <merge-queue@github.com>", "Merge pull request #12", "merge-queue"},
+		// Configured rules are checked before the defaults.
+		{"release-bot <ci@example.com>", "chore: release 3.1.0", "release-bot"},
+		{"Jane Doe <jane@example.com>", "Bump lodash from 4.17.20 to 4.17.21", ""},
+		{"Jane Doe <jane@example.com>", "docs: release notes for 1.4.0", ""},
+		{"Mr. release-bot <x@example.com>", "Cut 3.1.0", ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		for i := range rules {
+			if rules[i].matches(tt.author, tt.subject) {
+				got = rules[i].Name
+				break
+			}
+		}
+		if got != tt.want {
+			t.Errorf("%s %q matched %q, want %q", tt.author, tt.subject, got, tt.want)
+		}
+	}
+
+	for _, rule := range []automationRule{
+		{Author: "bot"},
+		{Name: "empty"},
+		{Name: "bad-summary", Author: "bot", Summary: "changelog"},
+		{Name: "bad-pattern", Message: "(unclosed"},
+	} {
+		if _, err := newAutomationRules([]automationRule{rule}); err == nil {
+			t.Errorf("rule %+v accepted", rule)
+		}
+	}
+}
+
+func TestReleaseAndBumpSubjects(t *testing.T) {
+	releases := map[string]string{
+		"chore(main): release 1.4.0":             "1.4.0",
+		"chore: release v2.0.0-rc.1":             "2.0.0-rc.1",
+		"chore(main): release gitaudit 0.9.3":    "0.9.3",
+		"chore(main): release @scope/pkg v1.2.3": "1.2.3",
+		"chore(main): release notes":             "",
+		"chore: release 1.4":                     "",
+	}
+	for subject, want := range releases {
+		got := ""
+		if m := releaseVersion.FindStringSubmatch(subject); m != nil {
+			got = m[1]
+		}
+		if got != want {
+			t.Errorf("release version of %q = %q, want %q", subject, got, want)
+		}
+	}
+
+	bumps := map[string]string{
+		"Bump lodash from 4.17.20 to 4.17.21":                               "lodash 4.17.20 → 4.17.21",
+		"build(deps): bump golang.org/x/net from 0.22.0 to 0.23.0":          "golang.org/x/net 0.22.0 → 0.23.0",
+		"chore(deps-dev): Bump @types/node from 20.11.0 to 20.12.7 in /web": "@types/node 20.11.0 → 20.12.7",
+		"Bump the npm_and_yarn group across 2 directories with 3 updates":   "",
+	}
+	for subject, want := range bumps {
+		got := strings.Join(parseBumpSubject(subject), "; ")
+		if got != want {
+			t.Errorf("parseBumpSubject(%q) = %q, want %q", subject, got, want)
+		}
+	}
+}
+
+func TestAutomatedSummary(t *testing.T) {
+	repo := newFixtureRepo(t)
+	repo.commit("Add the manifests", map[string]string{
+		"web/package.json": "{\n  \"name\": \"web\",\n  \"version\": \"1.0.0\",\n  \"dependencies\": {\n    \"express\": \"^4.18.2\",\n    \"lodash\": \"^4.17.20\"\n  }\n}\n",
+		"go.mod":           "module example.com/app\n\ngo 1.22\n\nrequire (\n\tgithub.com/google/uuid v1.5.0\n\tgolang.org/x/net v0.22.0\n)\n",
+	})
+	npm := repo.commitEnv(dependabot, "Bump lodash from 4.17.20 to 4.17.21 in /web", map[string]string{
+		"web/package.json": "{\n  \"name\": \"web\",\n  \"version\": \"1.0.0\",\n  \"dependencies\": {\n    \"express\": \"^4.18.2\",\n    \"lodash\": \"^4.17.21\"\n  }\n}\n",
+	})
+	gomod := repo.commitEnv(dependabot, "build(deps): bump the go group with 2 updates", map[string]string{
+		"go.mod": "module example.com/app\n\ngo 1.22\n\nrequire (\n\tgithub.com/google/uuid v1.6.0\n\tgolang.org/x/net v0.23.0\n)\n",
+	})
+	// A bump whose manifest change gitaudit cannot read falls back to the subject.
+	yarn := repo.commitEnv(dependabot, "Bump left-pad from 1.1.0 to 1.3.0", map[string]string{"yarn.lock": "left-pad@^1.3.0:\n  version \"1.3.0\"\n"})
+	// Nothing to parse at all: the generic template.
+	grouped := repo.commitEnv(dependabot, "Bump the actions group with 4 updates", map[string]string{".github/workflows/ci.yml": "on: push\n"})
+	release := repo.commit("chore(main): release 1.4.0", map[string]string{"CHANGELOG.md": "## 1.4.0\n"})
+	oddRelease := repo.commit("chore(main): release the hounds", map[string]string{"CHANGELOG.md": "## ?\n"})
+	human := repo.commit("Bump lodash by hand", map[string]string{"notes.txt": "lodash\n"})
+
+	rules, err := newAutomationRules(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		commit string
+		rule   string
+		want   string
+	}{
+		{npm, "dependabot", "Dependency bump: lodash 4.17.20 → 4.17.21"},
+		{gomod, "dependabot", "Dependency bump: github.com/google/uuid v1.5.0 → v1.6.0; golang.org/x/net v0.22.0 → v0.23.0"},
+		{yarn, "dependabot", "Dependency bump: left-pad 1.1.0 → 1.3.0"},
+		{grouped, "dependabot", "Automated change (dependabot): Bump the actions group with 4 updates"},
+		{release, "release-please", "Release: version 1.4.0"},
+		{oddRelease, "release-please", "Automated change (release-please): chore(main): release the hounds"},
+		{human, "", ""},
+	}
+	for _, tt := range tests {
+		rule, subject, err := classifyAutomation(context.Background(), repo.Dir, tt.commit, rules)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rule == nil {
+			if tt.rule != "" {
+				t.Errorf("%s not recognized as automated", subject)
+			}
+			continue
+		}
+		if rule.Name != tt.rule {
+			t.Errorf("%s matched rule %s, want %q", subject, rule.Name, tt.rule)
+			continue
+		}
+		got, err := automatedSummary(context.Background(), repo.Dir, tt.commit, subject, rule)
+		if err != nil || got != tt.want {
+			t.Errorf("automatedSummary(%s) = %q, %v; want %q", subject, got, err, tt.want)
+		}
+	}
+}
+
+func TestAutomatedCommitsInReport(t *testing.T) {
+	repo := newFixtureRepo(t)
+	repo.commits(2)
+	repo.commit("Add the manifest", map[string]string{"package.json": "{\n  \"dependencies\": {\n    \"lodash\": \"^4.17.20\"\n  }\n}\n"})
+	bump := repo.commitEnv(dependabot, "Bump lodash from 4.17.20 to 4.17.21", map[string]string{"package.json": "{\n  \"dependencies\": {\n    \"lodash\": \"^4.17.21\"\n  }\n}\n"})
+	repo.commit("chore(main): release 1.4.0", map[string]string{"CHANGELOG.md": "## 1.4.0\n"})
+	env := newAuditEnv(t)
+
+	report := filepath.Join(env.Work, "report.txt")
+	out := env.mustRun("-repo", repo.Dir, "-commit", "root", "-output", report)
+	if n := len(env.Ollama.Prompts()); n != 3 {
+		t.Errorf("%d model calls, want 3 for the commits not made by automation", n)
+	}
+	if !strings.Contains(out, "2 commits were recognized as automated and skipped the model call.") {
+		t.Errorf("run output:\n%s", out)
+	}
+	text := readFile(t, report)
+	section := text[strings.Index(text, "=== Automated changes (2 commits) ==="):]
+	for _, want := range []string{
+		`(?m)^` + shortHash(bump) + ` 2024-01-01 [0-9:]+ [+-]\d{4} \[dependabot\] Dependency bump: lodash 4\.17\.20 → 4\.17\.21`,
+		`(?m)^[0-9a-f]{8} 2024-01-01 [0-9:]+ [+-]\d{4} \[release-please\] Release: version 1\.4\.0`,
+	} {
+		if !regexp.MustCompile(want).MatchString(section) {
+			t.Errorf("automated section does not match %s:\n%s", want, section)
+		}
+	}
+	if n := strings.Count(text, "Commit: "); n != 3 {
+		t.Errorf("report has %d full entries, want 3:\n%s", n, text)
+	}
+
+	// -llm-bots gives every commit the full treatment.
+	report = filepath.Join(env.Work, "llm-bots.txt")
+	out = env.mustRun("-repo", repo.Dir, "-commit", "root", "-output", report, "-llm-bots")
+	if n := len(env.Ollama.Prompts()); n != 3+5 {
+		t.Errorf("%d model calls after the -llm-bots run, want 8", n)
+	}
+	if text := readFile(t, report); strings.Contains(text, "Automated changes") || strings.Count(text, "Commit: ") != 5 {
+		t.Errorf("-llm-bots report:\n%s", text)
+	}
+	if strings.Contains(out, "recognized as automated") {
+		t.Errorf("-llm-bots run still classified commits:\n%s", out)
+	}
+}
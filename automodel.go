@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultAutoModelMaxParameters caps the models chosen when ollama_model is not set and the
+// config does not set auto_model_max_parameters, so that a 70B model pulled for another use
+// does not make every audit crawl.
+const defaultAutoModelMaxParameters = "14B"
+
+// autoSelectedModel is the Ollama model chosen by selectModel when none was configured, for
+// the report header; empty when the model was configured.
+var autoSelectedModel string
+
+// selectModel chooses an installed Ollama model when neither ollama_model nor -ollama-model
+// names one, unless -require-explicit-model forbids it. The choice is made once, before any
+// commit is audited, and written into config, so every call and retry of the run uses it.
+func (p *promptFlags) selectModel(config *Config) error {
+	if (config.Provider != "" && config.Provider != providerOllama) || config.OllamaModel != "" {
+		return nil
+	}
+	if p.RequireExplicitModel {
+		return fmt.Errorf("no Ollama model is configured and -require-explicit-model forbids choosing one; set ollama_model or pass -ollama-model")
+	}
+	base, err := ollamaBaseURL(config.OllamaEndpoint)
+	if err != nil {
+		return err
+	}
+	resp, err := modelInfoClient.Get(base + "/api/tags")
+	if err != nil {
+		return fmt.Errorf("no Ollama model is configured, and listing the installed ones failed: %w; set ollama_model or pass -ollama-model", err)
+	}
+	defer resp.Body.Close()
+	var tags ollamaTagsResponse
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("no Ollama model is configured, and %s/api/tags failed with status %s; set ollama_model or pass -ollama-model", base, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return fmt.Errorf("failed to decode %s/api/tags response: %w", base, err)
+	}
+
+	maxParameters := config.AutoModelMaxParameters
+	if maxParameters == "" {
+		maxParameters = defaultAutoModelMaxParameters
+	}
+	limit, _ := parseParameterCount(maxParameters) // Validated by loadConfig.
+	model, err := pickAutoModel(tags.Models, config.AutoModelPreference, limit)
+	if err != nil {
+		return err
+	}
+	config.OllamaModel = model
+	autoSelectedModel = model
+	fmt.Printf("Warning: no model configured; using %s (set ollama_model to pin this)\n", model)
+	return nil
+}
+
+// pickAutoModel applies the auto-selection heuristic to the installed models. Models that
+// cannot generate text (embedding models) are never chosen. Of the others, the ranking
+// prefers, in order: models within maxParameters (0 for no cap), the earliest entry of
+// preference a model's name starts with, instruct-tuned models, and then the largest model;
+// names break ties so the choice does not depend on the server's listing order.
+func pickAutoModel(models []ollamaTag, preference []string, maxParameters float64) (string, error) {
+	type candidate struct {
+		name       string
+		overCap    bool
+		preference int
+		instruct   bool
+		parameters float64
+		size       int64
+	}
+	var candidates []candidate
+	for _, m := range models {
+		if isEmbeddingModel(m) {
+			continue
+		}
+		c := candidate{name: m.Name, preference: len(preference), instruct: isInstructModel(m.Name), size: m.Size}
+		c.parameters, _ = parseParameterCount(m.Details.ParameterSize)
+		c.overCap = maxParameters > 0 && c.parameters > maxParameters
+		for i, prefix := range preference {
+			if strings.HasPrefix(m.Name, prefix) {
+				c.preference = i
+				break
+			}
+		}
+		candidates = append(candidates, c)
+	}
+	if len(candidates) == 0 {
+		if len(models) == 0 {
+			return "", fmt.Errorf("no Ollama model is configured and none is installed; install one, e.g. `ollama pull llama3.1:8b`, or set ollama_model")
+		}
+		return "", fmt.Errorf("no Ollama model is configured and the installed ones only compute embeddings; install one that generates text, e.g. `ollama pull llama3.1:8b`, or set ollama_model")
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		switch {
+		case a.overCap != b.overCap:
+			return !a.overCap
+		case a.preference != b.preference:
+			return a.preference < b.preference
+		case a.instruct != b.instruct:
+			return a.instruct
+		case a.parameters != b.parameters:
+			// Over the cap, the smallest model is the one that comes closest to it.
+			return (a.parameters > b.parameters) != a.overCap
+		case a.size != b.size:
+			return (a.size > b.size) != a.overCap
+		}
+		return a.name < b.name
+	})
+	return candidates[0].name, nil
+}
+
+// isEmbeddingModel reports whether an installed model only computes embeddings.
+func isEmbeddingModel(m ollamaTag) bool {
+	family := strings.ToLower(m.Details.Family)
+	return strings.Contains(strings.ToLower(m.Name), "embed") || family == "bert" || family == "nomic-bert"
+}
+
+// isInstructModel reports whether a model name looks instruct-tuned. Ollama's default tags
+// are, so only tags marking a base model ("text", "base") count against it.
+func isInstructModel(name string) bool {
+	_, tag, _ := strings.Cut(strings.ToLower(name), ":")
+	for _, base := range []string{"text", "base"} {
+		if strings.Contains(tag, base) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseParameterCount parses a parameter count as Ollama reports it, e.g. "8.0B" or "567.72M".
+func parseParameterCount(text string) (float64, error) {
+	upper := strings.ToUpper(strings.TrimSpace(text))
+	multiplier := 1.0
+	switch {
+	case strings.HasSuffix(upper, "T"):
+		multiplier = 1e12
+	case strings.HasSuffix(upper, "B"):
+		multiplier = 1e9
+	case strings.HasSuffix(upper, "M"):
+		multiplier = 1e6
+	case strings.HasSuffix(upper, "K"):
+		multiplier = 1e3
+	}
+	if multiplier != 1 {
+		upper = upper[:len(upper)-1]
+	}
+	n, err := strconv.ParseFloat(upper, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid parameter count %q: expected e.g. \"14B\"", text)
+	}
+	return n * multiplier, nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// tagList builds an /api/tags model list from "name parameters family size" specs, where
+// "-" leaves a field out.
+func tagList(t *testing.T, specs ...string) []ollamaTag {
+	t.Helper()
+	var models []string
+	for _, spec := range specs {
+		fields := strings.Fields(spec)
+		for len(fields) < 4 {
+			fields = append(fields, "-")
+		}
+		for i, field := range fields {
+			if field == "-" {
+				fields[i] = ""
+			}
+		}
+		size := fields[3]
+		if size == "" {
+			size = "0"
+		}
+		models = append(models, fmt.Sprintf(`{"name":%q,"size":%s,"details":{"parameter_size":%q,"family":%q}}`, fields[0], size, fields[1], fields[2]))
+	}
+	var tags []ollamaTag
+	if err := json.Unmarshal([]byte("["+strings.Join(models, ",")+"]"), &tags); err != nil {
+		t.Fatal(err)
+	}
+	return tags
+}
+
+func TestPickAutoModel(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		models     []string
+		preference []string
+		cap        float64
+		want       string
+	}{
+		{"largest", []string{"qwen2.5:0.5b 0.5B", "llama3.1:8b 8.0B", "phi3:3.8b 3.8B"}, nil, 14e9, "llama3.1:8b"},
+		{"within the cap", []string{"llama3.1:70b 70.6B", "llama3.1:8b 8.0B"}, nil, 14e9, "llama3.1:8b"},
+		{"no cap", []string{"llama3.1:70b 70.6B", "llama3.1:8b 8.0B"}, nil, 0, "llama3.1:70b"},
+		// With every model over the cap, the one closest to it.
+		{"all over the cap", []string{"qwen2:72b 72.7B", "llama3.1:70b 70.6B", "llama3.1:405b 405B"}, nil, 14e9, "llama3.1:70b"},
+		{"preference", []string{"llama3.1:8b 8.0B", "mistral:7b 7.2B", "qwen2.5:3b 3.1B"}, []string{"qwen2.5", "mistral"}, 14e9, "qwen2.5:3b"},
+		{"second preference", []string{"llama3.1:8b 8.0B", "mistral:7b 7.2B"}, []string{"qwen2.5", "mistral"}, 14e9, "mistral:7b"},
+		// The cap comes before the preference.
+		{"preference over the cap", []string{"llama3.1:70b 70.6B", "mistral:7b 7.2B"}, []string{"llama3.1"}, 14e9, "mistral:7b"},
+		{"instruct over base", []string{"llama3:8b-text 8.0B", "llama3:8b-base 8.0B", "phi3:3.8b 3.8B"}, nil, 14e9, "phi3:3.8b"},
+		{"base when nothing else", []string{"llama3:8b-text 8.0B", "llama3:70b-text 70.6B"}, nil, 14e9, "llama3:8b-text"},
+		{"embeddings never", []string{"nomic-embed-text:latest 137M nomic-bert", "mxbai-large:latest 334M bert", "tinyllama:1.1b 1.1B llama"}, nil, 14e9, "tinyllama:1.1b"},
+		// A model without a parameter count ranks below those with one.
+		{"unknown size", []string{"custom:latest", "llama3.1:8b 8.0B"}, nil, 14e9, "llama3.1:8b"},
+		{"size breaks ties", []string{"a:7b 7B - 4000000000", "b:7b 7B - 4500000000"}, nil, 14e9, "b:7b"},
+		{"name breaks ties", []string{"b:7b 7B - 4000000000", "a:7b 7B - 4000000000"}, nil, 14e9, "a:7b"},
+	} {
+		got, err := pickAutoModel(tagList(t, tc.models...), tc.preference, tc.cap)
+		if err != nil || got != tc.want {
+			t.Errorf("%s: pickAutoModel = %q, %v; want %q", tc.name, got, err, tc.want)
+		}
+		// The server's listing order does not matter.
+		reversed := append([]string(nil), tc.models...)
+		for i, j := 0, len(reversed)-1; i < j; i, j = i+1, j-1 {
+			reversed[i], reversed[j] = reversed[j], reversed[i]
+		}
+		if got, _ := pickAutoModel(tagList(t, reversed...), tc.preference, tc.cap); got != tc.want {
+			t.Errorf("%s reversed: pickAutoModel = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+
+	if _, err := pickAutoModel(nil, nil, 14e9); err == nil || !strings.Contains(err.Error(), "none is installed; install one, e.g. `ollama pull llama3.1:8b`") {
+		t.Errorf("no models: %v", err)
+	}
+	if _, err := pickAutoModel(tagList(t, "nomic-embed-text:latest 137M nomic-bert"), nil, 14e9); err == nil || !strings.Contains(err.Error(), "only compute embeddings") {
+		t.Errorf("only embeddings: %v", err)
+	}
+}
+
+func TestParseParameterCount(t *testing.T) {
+	for text, want := range map[string]float64{"8.0B": 8e9, "567.72M": 567.72e6, " 14b ": 14e9, "1.2T": 1.2e12, "350K": 350e3, "1000": 1000} {
+		if got, err := parseParameterCount(text); err != nil || got != want {
+			t.Errorf("parseParameterCount(%q) = %v, %v; want %v", text, got, err, want)
+		}
+	}
+	for _, text := range []string{"", "B", "big", "-3B"} {
+		if _, err := parseParameterCount(text); err == nil {
+			t.Errorf("parseParameterCount(%q) succeeded", text)
+		}
+	}
+}
+
+func TestIsInstructModel(t *testing.T) {
+	for name, want := range map[string]bool{
+		"llama3.1:8b":               true,
+		"llama3.1:8b-instruct-q4_0": true,
+		"llama3.1":                  true,
+		"llama3:8b-text":            false,
+		"qwen2.5:7b-base-q8_0":      false,
+		"textgen:latest":            true,
+	} {
+		if got := isInstructModel(name); got != want {
+			t.Errorf("isInstructModel(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestAutoModelRun(t *testing.T) {
+	repo := newFixtureRepo(t)
+	repo.commits(2)
+	env := newAuditEnv(t)
+	delete(env.Config, "ollama_model")
+	env.Ollama.Tags = `{"models":[
+		{"name":"nomic-embed-text:latest","size":274000000,"details":{"parameter_size":"137M","family":"nomic-bert"}},
+		{"name":"llama3.1:70b","size":40000000000,"details":{"parameter_size":"70.6B"}},
+		{"name":"qwen2.5:0.5b","size":400000000,"details":{"parameter_size":"0.5B"}},
+		{"name":"llama3.1:8b","size":4900000000,"details":{"parameter_size":"8.0B"}}]}`
+	// The first request fails, so a retry must use the same model.
+	env.Ollama.Respond = func(n int, prompt string) (int, string) {
+		if n == 1 {
+			return 503, `{"error":"server busy"}`
+		}
+		return 0, ""
+	}
+	report := filepath.Join(env.Work, "report.txt")
+	out := env.mustRun("-repo", repo.Dir, "-commit", "root", "-output", report)
+	if !strings.Contains(out, "Warning: no model configured; using llama3.1:8b (set ollama_model to pin this)\n") {
+		t.Errorf("output:\n%s", out)
+	}
+	if models := env.Ollama.Models(); len(models) != 3 || strings.Count(strings.Join(models, " "), "llama3.1:8b") != 3 {
+		t.Errorf("requests went to %q", models)
+	}
+	if content := readFile(t, report); !strings.Contains(content, "Model: llama3.1:8b (auto-selected; no model was configured)\n") {
+		t.Errorf("report:\n%s", content)
+	}
+
+	env.Config["auto_model_preference"] = []string{"qwen2.5"}
+	out = env.mustRun("-repo", repo.Dir, "-commit", "root", "-output", report, "-force")
+	if !strings.Contains(out, "using qwen2.5:0.5b") {
+		t.Errorf("auto_model_preference output:\n%s", out)
+	}
+	// A model named on the command line is used as is.
+	out = env.mustRun("-repo", repo.Dir, "-commit", "root", "-output", report, "-force", "-ollama-model", "llama3.1:70b")
+	if strings.Contains(out, "no model configured") || strings.Contains(readFile(t, report), "auto-selected") {
+		t.Errorf("-ollama-model output:\n%s", out)
+	}
+
+	if out, code := env.run("-repo", repo.Dir, "-commit", "root", "-output", report, "-force", "-require-explicit-model"); code == 0 || !strings.Contains(out, "-require-explicit-model forbids choosing one") {
+		t.Errorf("-require-explicit-model: exit %d\n%s", code, out)
+	}
+	env.Ollama.Tags = `{"models":[]}`
+	if out, code := env.run("-repo", repo.Dir, "-commit", "root", "-output", report, "-force"); code == 0 || !strings.Contains(out, "ollama pull") {
+		t.Errorf("no installed model: exit %d\n%s", code, out)
+	}
+}
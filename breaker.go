@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultBreakerFailures and defaultProbeInterval are the -breaker-failures and
+// -probe-interval defaults.
+const (
+	defaultBreakerFailures = 3
+	defaultProbeInterval   = 30 * time.Second
+)
+
+// Circuit breaker settings, set from the range flags; a zero breakerFailures disables the
+// breakers, as for the commands that have no retry queue to fall back on.
+var (
+	breakerFailures int
+	probeInterval   = defaultProbeInterval
+)
+
+// circuitOpenError is returned without a request while the circuit of Endpoint is open: the
+// endpoint failed at the connection level several times in a row and is being probed.
+type circuitOpenError struct {
+	Endpoint string
+}
+
+func (e *circuitOpenError) Error() string {
+	return fmt.Sprintf("%s is unreachable (circuit open); not sending the request", e.Endpoint)
+}
+
+// circuitBreaker stops requests to one endpoint after breakerFailures consecutive
+// connection-level failures, so that an outage costs one timeout per failure rather than
+// one per queued commit. While open, a background prober sends a cheap request every
+// probeInterval and closes the circuit once the endpoint answers.
+type circuitBreaker struct {
+	Endpoint string
+
+	mu       sync.Mutex
+	failures int           // Consecutive connection-level failures.
+	closed   chan struct{} // Nil while the circuit is closed; closed when it closes again.
+	opened   time.Time
+	// Opens and Downtime total the times the circuit opened and how long it stayed open.
+	Opens    int
+	Downtime time.Duration
+}
+
+// breakers holds a circuit breaker per endpoint, i.e. per scheme and host, so that a
+// -retry-model served elsewhere is not held up by an outage of the main model's server.
+var breakers struct {
+	mu sync.Mutex
+	m  map[string]*circuitBreaker
+}
+
+// breakerFor returns the breaker of the endpoint of rawURL, or nil when breakers are off.
+func breakerFor(rawURL string) *circuitBreaker {
+	if breakerFailures <= 0 {
+		return nil
+	}
+	endpoint := rawURL
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		endpoint = u.Scheme + "://" + u.Host
+	}
+	breakers.mu.Lock()
+	defer breakers.mu.Unlock()
+	if breakers.m == nil {
+		breakers.m = make(map[string]*circuitBreaker)
+	}
+	b := breakers.m[endpoint]
+	if b == nil {
+		b = &circuitBreaker{Endpoint: endpoint}
+		breakers.m[endpoint] = b
+	}
+	return b
+}
+
+// allow returns a *circuitOpenError while the circuit is open. It is safe to call on nil.
+func (b *circuitBreaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed != nil {
+		return &circuitOpenError{Endpoint: b.Endpoint}
+	}
+	return nil
+}
+
+// record notes the outcome of a request: err is the error of the HTTP exchange, nil when
+// the endpoint answered whatever the status. Only connection-level failures count, never
+// an interrupted request. It is safe to call on nil.
+func (b *circuitBreaker) record(err error) {
+	if b == nil || errors.Is(err, errInterrupted) {
+		return
+	}
+	var urlErr *url.Error
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil || !errors.As(err, &urlErr) {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures < breakerFailures || b.closed != nil {
+		return
+	}
+	b.closed = make(chan struct{})
+	b.opened = time.Now()
+	b.Opens++
+	fmt.Printf("Circuit breaker: %s failed %d requests in a row (%v); failing requests to it at once and probing it every %s\n", b.Endpoint, b.failures, err, formatDuration(probeInterval))
+	go b.probe(b.closed)
+}
+
+// probe sends a GET to the endpoint every probeInterval until it answers, whatever the
+// status, and then closes the circuit. It gives up when the run stops.
+func (b *circuitBreaker) probe(closed chan struct{}) {
+	for {
+		select {
+		case <-time.After(probeInterval):
+		case <-runCtx.Done():
+			return
+		}
+		req, err := http.NewRequestWithContext(runCtx, http.MethodGet, b.Endpoint+"/", nil)
+		if err != nil {
+			return
+		}
+		resp, err := modelInfoClient.Do(req)
+		if err != nil {
+			debugf("circuit breaker: probe of %s failed: %v", b.Endpoint, err)
+			continue
+		}
+		resp.Body.Close()
+		b.mu.Lock()
+		down := time.Since(b.opened)
+		b.Downtime += down
+		b.failures = 0
+		b.closed = nil
+		b.mu.Unlock()
+		fmt.Printf("Circuit breaker: %s answers again after %s; closing the circuit\n", b.Endpoint, formatDuration(down.Truncate(time.Second)))
+		close(closed)
+		return
+	}
+}
+
+// waitClosed blocks while the circuit is open, or until the run stops, and returns how long
+// it waited. It is safe to call on nil.
+func (b *circuitBreaker) waitClosed() time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	closed := b.closed
+	b.mu.Unlock()
+	if closed == nil {
+		return 0
+	}
+	fmt.Printf("Waiting for %s to answer before retrying the commits it failed (probing every %s)\n", b.Endpoint, formatDuration(probeInterval))
+	start := time.Now()
+	select {
+	case <-closed:
+	case <-runCtx.Done():
+	}
+	return time.Since(start)
+}
+
+// circuitOpenEndpoint returns the endpoint whose open circuit err reports, or "".
+func circuitOpenEndpoint(err error) string {
+	var open *circuitOpenError
+	if errors.As(err, &open) {
+		return open.Endpoint
+	}
+	return ""
+}
+
+// waitForCircuits waits for the circuits of endpoints to close and returns the time waited.
+func waitForCircuits(endpoints map[string]bool) time.Duration {
+	var waited time.Duration
+	for endpoint := range endpoints {
+		waited += breakerFor(endpoint).waitClosed()
+	}
+	return waited
+}
+
+// breakerSummary describes the outages of the run, e.g. "http://gpu:11434 2 outages, 14m in
+// all", or "" when no circuit opened.
+func breakerSummary() string {
+	breakers.mu.Lock()
+	defer breakers.mu.Unlock()
+	var lines []string
+	for _, b := range breakers.m {
+		b.mu.Lock()
+		if b.Opens > 0 {
+			downtime := b.Downtime
+			if b.closed != nil {
+				downtime += time.Since(b.opened)
+			}
+			outages := "1 outage"
+			if b.Opens > 1 {
+				outages = fmt.Sprintf("%d outages", b.Opens)
+			}
+			lines = append(lines, fmt.Sprintf("%s %s, %s in all", b.Endpoint, outages, formatDuration(downtime.Truncate(time.Second))))
+		}
+		b.mu.Unlock()
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "; ")
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// withBreakers gives the test circuit breakers of its own, opening after failures and
+// probing every interval.
+func withBreakers(t *testing.T, failures int, interval time.Duration) {
+	t.Helper()
+	withFreshContexts(t)
+	savedFailures, savedInterval := breakerFailures, probeInterval
+	breakers.mu.Lock()
+	saved := breakers.m
+	breakers.m = nil
+	breakers.mu.Unlock()
+	breakerFailures, probeInterval = failures, interval
+	t.Cleanup(func() {
+		breakerFailures, probeInterval = savedFailures, savedInterval
+		breakers.mu.Lock()
+		breakers.m = saved
+		breakers.mu.Unlock()
+	})
+}
+
+// outageServer stands in front of a model server and drops every connection while Down is
+// set, as a dead host or a crashed server would. It counts the requests it dropped by path;
+// the probes are those of "/".
+type outageServer struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	down    bool
+	dropped map[string]int
+	// RecoverAfter, when positive, ends the outage once that many probes were dropped.
+	RecoverAfter int
+}
+
+func newOutageServer(t *testing.T, target string) *outageServer {
+	t.Helper()
+	u, err := url.Parse(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := &outageServer{dropped: make(map[string]int)}
+	proxy := httputil.NewSingleHostReverseProxy(u)
+	o.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		o.mu.Lock()
+		down := o.down
+		if down {
+			o.dropped[r.URL.Path]++
+			if o.RecoverAfter > 0 && o.dropped["/"] >= o.RecoverAfter {
+				o.down = false
+			}
+		}
+		o.mu.Unlock()
+		if !down {
+			proxy.ServeHTTP(w, r)
+			return
+		}
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	t.Cleanup(o.Close)
+	return o
+}
+
+// SetDown starts or ends the outage.
+func (o *outageServer) SetDown(down bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.down = down
+}
+
+// Dropped returns the number of requests for path dropped so far.
+func (o *outageServer) Dropped(path string) int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.dropped[path]
+}
+
+// connectionError returns the error of a request to a port nothing listens on.
+func connectionError(t *testing.T) error {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	_, err = http.Get("http://" + addr + "/")
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		t.Fatalf("no connection error: %v", err)
+	}
+	return err
+}
+
+func TestBreakerFor(t *testing.T) {
+	withBreakers(t, 3, time.Hour)
+	a := breakerFor("http://gpu:11434/api/generate")
+	if a == nil || a.Endpoint != "http://gpu:11434" || breakerFor("http://gpu:11434/api/chat") != a {
+		t.Errorf("one endpoint, two breakers: %+v", a)
+	}
+	if b := breakerFor("https://gpu:11434/api/generate"); b == a || breakerFor("http://cpu:11434/api/generate") == a {
+		t.Error("two endpoints share a breaker")
+	}
+	breakerFailures = 0
+	if breakerFor("http://gpu:11434/api/generate") != nil {
+		t.Error("-breaker-failures 0 has a breaker")
+	}
+	// A nil breaker allows everything.
+	var none *circuitBreaker
+	none.record(connectionError(t))
+	if none.allow() != nil || none.waitClosed() != 0 {
+		t.Error("a nil breaker is not a no-op")
+	}
+}
+
+// TestBreakerOutageAndRecovery scripts an outage: connection failures open the circuit,
+// requests then fail at once, and the probe closes it once the server answers again.
+func TestBreakerOutageAndRecovery(t *testing.T) {
+	interval := 20 * time.Millisecond
+	withBreakers(t, 3, interval)
+	ollama := newFakeOllama(t)
+	outage := newOutageServer(t, ollama.URL)
+	b := breakerFor(outage.URL + "/api/generate")
+	refused := connectionError(t)
+
+	// Failures must be consecutive and at the connection level: an answer of any status, or
+	// an error other than a *url.Error, resets the count, and an interrupt does not count.
+	var out string
+	out = captureStdout(t, func() {
+		b.record(refused)
+		b.record(refused)
+		b.record(nil)
+		b.record(refused)
+		b.record(errors.New("failed to decode Ollama response"))
+		b.record(refused)
+		b.record(refused)
+		b.record(fmt.Errorf("%w: %w", errInterrupted, refused))
+	})
+	if err := b.allow(); err != nil || out != "" {
+		t.Fatalf("the circuit opened early: %v\n%s", err, out)
+	}
+
+	outage.SetDown(true)
+	start := time.Now()
+	out = captureStdout(t, func() { b.record(refused) })
+	err := b.allow()
+	if circuitOpenEndpoint(err) != outage.URL || b.Opens != 1 {
+		t.Fatalf("the circuit did not open: %v, %d opens", err, b.Opens)
+	}
+	if !strings.HasPrefix(out, "Circuit breaker: "+outage.URL+" failed 3 requests in a row (") || !strings.HasSuffix(out, "failing requests to it at once and probing it every 20ms\n") {
+		t.Errorf("opening printed %q", out)
+	}
+	// More failures while open do not open it again.
+	captureStdout(t, func() { b.record(refused) })
+
+	// The probes fail while the outage lasts, then one closes the circuit.
+	for outage.Dropped("/") < 3 {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("the probe did not run")
+		}
+		time.Sleep(interval / 4)
+	}
+	if b.allow() == nil {
+		t.Fatal("a failed probe closed the circuit")
+	}
+	out = captureStdout(t, func() {
+		outage.SetDown(false)
+		if waited := b.waitClosed(); waited <= 0 {
+			t.Errorf("waited %s", waited)
+		}
+	})
+	elapsed := time.Since(start)
+	if err := b.allow(); err != nil {
+		t.Errorf("the circuit stayed open: %v", err)
+	}
+	if !strings.Contains(out, "Waiting for "+outage.URL+" to answer") || !strings.Contains(out, "Circuit breaker: "+outage.URL+" answers again after ") {
+		t.Errorf("recovery printed %q", out)
+	}
+	// The outage costs the probes it took, not a timeout per request.
+	if b.Downtime <= 0 || b.Downtime > elapsed || elapsed > 2*time.Second {
+		t.Errorf("downtime %s, elapsed %s", b.Downtime, elapsed)
+	}
+	if got := breakerSummary(); got != outage.URL+" 1 outage, 0s in all" {
+		t.Errorf("breakerSummary = %q", got)
+	}
+
+	// A second outage is counted too.
+	captureStdout(t, func() {
+		for i := 0; i < 3; i++ {
+			b.record(refused)
+		}
+		b.waitClosed()
+	})
+	if b.Opens != 2 || !strings.HasSuffix(breakerSummary(), " 2 outages, 0s in all") {
+		t.Errorf("after a second outage: %d opens, %q", b.Opens, breakerSummary())
+	}
+}
+
+// TestBreakerRun takes the model server down for the first requests of a run: the circuit
+// opens after -breaker-failures, the other commits skip the request, and the retry pass
+// audits them all once a probe finds the server again.
+func TestBreakerRun(t *testing.T) {
+	repo := newFixtureRepo(t)
+	repo.commits(6)
+	env := newAuditEnv(t)
+	outage := newOutageServer(t, env.Ollama.URL)
+	outage.RecoverAfter = 2
+	outage.SetDown(true)
+	env.Config["ollama_endpoint"] = outage.URL + "/api/generate"
+	report := filepath.Join(env.Work, "report.txt")
+
+	start := time.Now()
+	out := env.mustRun("-repo", repo.Dir, "-commit", "root", "-output", report, "-breaker-failures", "2", "-probe-interval", "100ms")
+	elapsed := time.Since(start)
+	for _, want := range []string{
+		"Circuit breaker: " + outage.URL + " failed 2 requests in a row (",
+		"is unreachable (circuit open); not sending the request. Adding to retry queue.\n",
+		"Waiting for " + outage.URL + " to answer before retrying the commits it failed (probing every 100ms)\n",
+		"Circuit breaker: " + outage.URL + " answers again after ",
+		"Model server outages: " + outage.URL + " 1 outage, ",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+	// Two requests failed before the circuit opened; the other four commits were not sent.
+	if n := outage.Dropped("/api/generate"); n != 2 {
+		t.Errorf("%d requests reached the dead server, want 2", n)
+	}
+	if n := strings.Count(out, "(circuit open); not sending the request"); n != 4 {
+		t.Errorf("%d commits skipped the request, want 4:\n%s", n, out)
+	}
+	if n := len(env.Ollama.Prompts()); n != 6 {
+		t.Errorf("the server got %d prompts after recovering, want 6", n)
+	}
+	if n := strings.Count(readFile(t, report), "Commit: "); n != 6 {
+		t.Errorf("the report has %d entries", n)
+	}
+	// Bounded by the retry cool-down and the probes, not by a timeout per queued commit.
+	if elapsed > 15*time.Second {
+		t.Errorf("the run took %s", elapsed)
+	}
+
+	// With the breaker off, every commit of the first pass reaches the dead server.
+	outage.RecoverAfter = 0
+	outage.SetDown(true)
+	before := outage.Dropped("/api/generate")
+	if out, code := env.run("-repo", repo.Dir, "-commit", "root", "-output", report, "-force", "-breaker-failures", "0", "-retry-passes", "1"); code == 0 || strings.Contains(out, "Circuit breaker") {
+		t.Errorf("-breaker-failures 0: exit %d\n%s", code, out)
+	}
+	if n := outage.Dropped("/api/generate") - before; n < 6 {
+		t.Errorf("without the breaker %d requests reached the dead server, want at least 6", n)
+	}
+	if out, code := env.run("-repo", repo.Dir, "-commit", "root", "-output", report, "-force", "-breaker-failures", "-1"); code == 0 || !strings.Contains(out, "-breaker-failures must not be negative") {
+		t.Errorf("-breaker-failures -1: exit %d\n%s", code, out)
+	}
+}
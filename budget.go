@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Budget components, also the valid names for -trim-order.
+const (
+	budgetInstructions = "instructions"
+	budgetContext      = "context"
+	budgetPatch        = "patch"
+)
+
+// bytesPerToken is the ratio heuristicTokenizer, the default tokenizer, assumes.
+const bytesPerToken = 4
+
+// budgetSplit is the percentage of the context window reserved for each prompt component.
+type budgetSplit struct {
+	Instructions float64 `json:"instructions"`
+	Context      float64 `json:"context"`
+	Patch        float64 `json:"patch"`
+}
+
+// defaultBudgetSplit reserves 10% for instructions, 20% for injected context and 70% for the patch.
+var defaultBudgetSplit = budgetSplit{Instructions: 10, Context: 20, Patch: 70}
+
+// validate checks that the split uses non-negative percentages adding up to at most 100.
+func (s budgetSplit) validate() error {
+	if s.Instructions < 0 || s.Context < 0 || s.Patch < 0 {
+		return fmt.Errorf("budget_split percentages must not be negative")
+	}
+	if total := s.Instructions + s.Context + s.Patch; total <= 0 || total > 100 {
+		return fmt.Errorf("budget_split percentages must add up to more than 0 and at most 100, got %g", total)
+	}
+	return nil
+}
+
+// budgetComponent records how many bytes and tokens of a prompt component were available
+// and kept.
+type budgetComponent struct {
+	Size       int `json:"size"`
+	Kept       int `json:"kept"`
+	SizeTokens int `json:"size_tokens"`
+	KeptTokens int `json:"kept_tokens"`
+}
+
+// budgetReport is the allocator's decision for one commit, stored on the entry for tuning.
+type budgetReport struct {
+	ContextTokens int             `json:"context_tokens"`
+	Tokenizer     string          `json:"tokenizer"`
+	Instructions  budgetComponent `json:"instructions"`
+	Context       budgetComponent `json:"context"`
+	Patch         budgetComponent `json:"patch"`
+}
+
+// budgetAllocator splits a model's context window between the prompt's instructions, the
+// injected context (hints, other observations and the glossary) and the patch.
+type budgetAllocator struct {
+	ContextTokens int
+	Split         budgetSplit
+	// TrimOrder lists the trimmable components, first trimmed first. A component trimmed
+	// later receives unused budget from the other components first.
+	TrimOrder []string
+	// Tokenizer measures the components; the heuristic unless the tokenizers config key
+	// selects another for the model.
+	Tokenizer tokenizer
+}
+
+// newBudgetAllocator builds the allocator for a context window of contextTokens using the
+// configured split and the -trim-order flag value.
+func newBudgetAllocator(contextTokens int, config *Config, trimOrder string) (*budgetAllocator, error) {
+	order, err := parseTrimOrder(trimOrder)
+	if err != nil {
+		return nil, err
+	}
+	allocator := &budgetAllocator{ContextTokens: contextTokens, Split: defaultBudgetSplit, TrimOrder: order, Tokenizer: heuristicTokenizer{}}
+	if config.BudgetSplit != nil {
+		allocator.Split = *config.BudgetSplit
+	}
+	return allocator, nil
+}
+
+// parseTrimOrder parses the -trim-order flag value, e.g. "context,patch".
+func parseTrimOrder(value string) ([]string, error) {
+	var order []string
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part != budgetContext && part != budgetPatch {
+			return nil, fmt.Errorf("invalid -trim-order component %q: expected %q or %q", part, budgetContext, budgetPatch)
+		}
+		if containsString(order, part) {
+			return nil, fmt.Errorf("duplicate -trim-order component %q", part)
+		}
+		order = append(order, part)
+	}
+	for _, component := range []string{budgetContext, budgetPatch} {
+		if !containsString(order, component) {
+			order = append(order, component)
+		}
+	}
+	return order, nil
+}
+
+// Allocate returns how many tokens of each component may be kept given their sizes in tokens.
+// Instructions are never trimmed; their unused reservation, like that of any component
+// smaller than its share, is handed to over-budget components in reverse trim order.
+func (a *budgetAllocator) Allocate(sizes map[string]int) map[string]int {
+	total := a.ContextTokens
+	shares := map[string]float64{
+		budgetInstructions: a.Split.Instructions,
+		budgetContext:      a.Split.Context,
+		budgetPatch:        a.Split.Patch,
+	}
+
+	kept := make(map[string]int, len(sizes))
+	surplus := 0
+	for name, share := range shares {
+		reserved := int(float64(total) * share / 100)
+		if sizes[name] <= reserved {
+			kept[name] = sizes[name]
+			surplus += reserved - sizes[name]
+		} else {
+			kept[name] = reserved
+		}
+	}
+	// Instructions must fit whole; anything they take beyond their share comes out of the surplus.
+	if over := sizes[budgetInstructions] - kept[budgetInstructions]; over > 0 {
+		kept[budgetInstructions] = sizes[budgetInstructions]
+		surplus -= over
+	}
+
+	for i := len(a.TrimOrder) - 1; i >= 0 && surplus > 0; i-- {
+		name := a.TrimOrder[i]
+		if missing := sizes[name] - kept[name]; missing > 0 {
+			extra := min(missing, surplus)
+			kept[name] += extra
+			surplus -= extra
+		}
+	}
+	// A negative surplus means the instructions alone overran the budget; take it from the
+	// trimmable components in trim order.
+	for _, name := range a.TrimOrder {
+		if surplus >= 0 {
+			break
+		}
+		take := min(kept[name], -surplus)
+		kept[name] -= take
+		surplus += take
+	}
+	return kept
+}
+
+// budgetAmount renders a component size for -debug, e.g. "12.5 kB (3,120 tokens)".
+func budgetAmount(bytes, tokens int) string {
+	return fmt.Sprintf("%s (%s)", formatBytes(int64(bytes)), formatTokens(tokens))
+}
+
+// truncateText cuts text to at most limit bytes at a line boundary and appends a marker
+// naming what was removed. It returns text unchanged when it already fits.
+func truncateText(text string, limit int, what string) string {
+	if len(text) <= limit {
+		return text
+	}
+	marker := fmt.Sprintf("\n[... %s truncated by %d bytes to fit the context budget ...]\n", what, len(text)-limit)
+	cut := limit - len(marker)
+	if cut <= 0 {
+		return strings.TrimSpace(marker)
+	}
+	if idx := strings.LastIndex(text[:cut], "\n"); idx > 0 {
+		cut = idx
+	}
+	return text[:cut] + marker
+}
+
+// trimContext drops glossary entries, then hints, from the end until the rendered context
+// fits into limit tokens. The glossary goes first: it helps with wording, while the hints are
+// facts about the commit.
+func trimContext(t tokenizer, extras promptExtras, limit int) promptExtras {
+	context := promptExtras{Hints: extras.Hints, Glossary: extras.Glossary}
+	for len(context.Glossary) > 0 && t.Count(context.render()) > limit {
+		context.Glossary = context.Glossary[:len(context.Glossary)-1]
+	}
+	for len(context.Hints) > 0 && t.Count(context.render()) > limit {
+		context.Hints = context.Hints[:len(context.Hints)-1]
+	}
+	extras.Hints, extras.Glossary = context.Hints, context.Glossary
+	return extras
+}
+
+// applyBudget trims a commit's prompt components to the allocator's budget and returns the
+// trimmed patch and extras along with the allocation report.
+func (a *budgetAllocator) applyBudget(patch string, extras promptExtras) (string, promptExtras, *budgetReport) {
+	instructions := buildPrompt("", promptExtras{Instructions: extras.Instructions})
+	context := promptExtras{Hints: extras.Hints, Glossary: extras.Glossary}.render()
+	sizes := map[string]int{
+		budgetInstructions: a.Tokenizer.Count(instructions),
+		budgetContext:      a.Tokenizer.Count(context),
+		budgetPatch:        a.Tokenizer.Count(patch),
+	}
+	kept := a.Allocate(sizes)
+
+	patchSize := len(patch)
+	extras = trimContext(a.Tokenizer, extras, kept[budgetContext])
+	patch = truncateTokens(a.Tokenizer, patch, sizes[budgetPatch], kept[budgetPatch], "patch")
+	keptContext := promptExtras{Hints: extras.Hints, Glossary: extras.Glossary}.render()
+
+	return patch, extras, &budgetReport{
+		ContextTokens: a.ContextTokens,
+		Tokenizer:     a.Tokenizer.Name(),
+		Instructions:  budgetComponent{Size: len(instructions), Kept: len(instructions), SizeTokens: sizes[budgetInstructions], KeptTokens: sizes[budgetInstructions]},
+		Context:       budgetComponent{Size: len(context), Kept: len(keptContext), SizeTokens: sizes[budgetContext], KeptTokens: a.Tokenizer.Count(keptContext)},
+		Patch:         budgetComponent{Size: patchSize, Kept: len(patch), SizeTokens: sizes[budgetPatch], KeptTokens: a.Tokenizer.Count(patch)},
+	}
+}
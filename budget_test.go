@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBudgetAllocate(t *testing.T) {
+	contextFirst := []string{budgetContext, budgetPatch}
+	patchFirst := []string{budgetPatch, budgetContext}
+	tests := []struct {
+		name          string
+		contextTokens int
+		order         []string
+		instructions  int
+		context       int
+		patch         int
+		want          [3]int // Kept instructions, context and patch.
+	}{
+		{"everything fits", 1000, contextFirst, 50, 100, 500, [3]int{50, 100, 500}},
+		{"large patch takes the surplus", 1000, contextFirst, 50, 100, 2000, [3]int{50, 100, 850}},
+		{"surplus to the patch, trimmed last", 1000, contextFirst, 50, 1000, 2000, [3]int{50, 200, 750}},
+		{"surplus to the context, trimmed last", 1000, patchFirst, 50, 1000, 2000, [3]int{50, 250, 700}},
+		{"instructions overrun their share", 1000, contextFirst, 300, 300, 900, [3]int{300, 0, 700}},
+		{"instructions overrun, patch trimmed first", 1000, patchFirst, 300, 300, 900, [3]int{300, 200, 500}},
+		{"tiny window", 100, contextFirst, 5, 0, 10000, [3]int{5, 0, 95}},
+		{"large window", 128000, contextFirst, 500, 3000, 50000, [3]int{500, 3000, 50000}},
+		{"empty patch", 1000, contextFirst, 50, 5000, 0, [3]int{50, 950, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &budgetAllocator{ContextTokens: tt.contextTokens, Split: defaultBudgetSplit, TrimOrder: tt.order}
+			kept := a.Allocate(map[string]int{budgetInstructions: tt.instructions, budgetContext: tt.context, budgetPatch: tt.patch})
+			got := [3]int{kept[budgetInstructions], kept[budgetContext], kept[budgetPatch]}
+			if got != tt.want {
+				t.Errorf("kept = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBudgetAllocateInvariants(t *testing.T) {
+	splits := []budgetSplit{defaultBudgetSplit, {Instructions: 5, Context: 5, Patch: 90}, {Instructions: 20, Context: 40, Patch: 20}}
+	sizes := []int{0, 1, 37, 400, 3000, 90000}
+	for _, split := range splits {
+		for _, contextTokens := range []int{256, 2048, 8192, 131072} {
+			for _, order := range [][]string{{budgetContext, budgetPatch}, {budgetPatch, budgetContext}} {
+				a := &budgetAllocator{ContextTokens: contextTokens, Split: split, TrimOrder: order}
+				for _, i := range sizes {
+					for _, c := range sizes {
+						for _, p := range sizes {
+							size := map[string]int{budgetInstructions: i, budgetContext: c, budgetPatch: p}
+							kept := a.Allocate(size)
+							label := fmt.Sprintf("split %v, window %d, order %v, sizes %v", split, contextTokens, order, size)
+							if kept[budgetInstructions] != i && i <= contextTokens {
+								t.Fatalf("%s: instructions kept %d", label, kept[budgetInstructions])
+							}
+							total := 0
+							for name, n := range kept {
+								if n < 0 || n > size[name] {
+									t.Fatalf("%s: kept %d of %s", label, n, name)
+								}
+								total += n
+							}
+							if total > max(contextTokens, i) {
+								t.Fatalf("%s: kept %d tokens in all", label, total)
+							}
+							// Nothing is trimmed while the whole prompt fits the used share.
+							if used := int(float64(contextTokens) * (split.Instructions + split.Context + split.Patch) / 100); i+c+p <= used-3 && total != i+c+p {
+								t.Fatalf("%s: trimmed %d tokens of a prompt that fits", label, i+c+p-total)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestParseTrimOrder(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+		err   bool
+	}{
+		{"context,patch", []string{budgetContext, budgetPatch}, false},
+		{"patch, context", []string{budgetPatch, budgetContext}, false},
+		{"patch", []string{budgetPatch, budgetContext}, false},
+		{"instructions,patch", nil, true},
+		{"patch,patch", nil, true},
+		{"", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseTrimOrder(tt.value)
+		if (err != nil) != tt.err || (!tt.err && !reflect.DeepEqual(got, tt.want)) {
+			t.Errorf("parseTrimOrder(%q) = %v, %v", tt.value, got, err)
+		}
+	}
+}
+
+func TestBudgetSplitValidate(t *testing.T) {
+	for split, ok := range map[budgetSplit]bool{
+		defaultBudgetSplit:                             true,
+		{Instructions: 10, Context: 10, Patch: 50}:     true,
+		{Instructions: 0, Context: 0, Patch: 100}:      true,
+		{Instructions: 10, Context: 20, Patch: 71}:     false,
+		{Instructions: -1, Context: 20, Patch: 70}:     false,
+		{Instructions: 0, Context: 0, Patch: 0}:        false,
+		{Instructions: 50.5, Context: 20, Patch: 29.5}: true,
+	} {
+		if err := split.validate(); (err == nil) != ok {
+			t.Errorf("%+v.validate() = %v", split, err)
+		}
+	}
+}
+
+func TestTruncateText(t *testing.T) {
+	text := strings.Repeat("line of patch text\n", 100)
+	if got := truncateText(text, len(text), "patch"); got != text {
+		t.Errorf("text that fits was changed")
+	}
+	for _, limit := range []int{1000, 500, 120} {
+		got := truncateText(text, limit, "patch")
+		if len(got) > limit {
+			t.Errorf("limit %d: got %d bytes", limit, len(got))
+		}
+		if !strings.Contains(got, "patch truncated by") {
+			t.Errorf("limit %d: no truncation marker in %q", limit, got)
+		}
+		if head := got[:strings.Index(got, "\n[...")]; !strings.HasSuffix(head, "text") {
+			t.Errorf("limit %d: cut inside a line: %q", limit, head)
+		}
+	}
+	if got := truncateText(text, 10, "patch"); len(got) > 80 || !strings.Contains(got, "truncated") {
+		t.Errorf("tiny limit: %q", got)
+	}
+}
+
+func TestTrimContextDropsGlossaryFirst(t *testing.T) {
+	extras := promptExtras{
+		Hints:        []string{"hint one about the commit", "hint two about the commit"},
+		Glossary:     []string{"Nimbus: the billing service", "Atlas: the map tiles"},
+		Instructions: []string{"instructions are never trimmed"},
+	}
+	var tok heuristicTokenizer
+	full := tok.Count(promptExtras{Hints: extras.Hints, Glossary: extras.Glossary}.render())
+	hintsOnly := tok.Count(promptExtras{Hints: extras.Hints}.render())
+	tests := []struct {
+		limit    int
+		hints    int
+		glossary int
+	}{
+		{full, 2, 2},
+		{full - 1, 2, 1},
+		{hintsOnly, 2, 0},
+		{hintsOnly - 1, 1, 0},
+		{0, 0, 0},
+	}
+	for _, tt := range tests {
+		got := trimContext(tok, extras, tt.limit)
+		if len(got.Hints) != tt.hints || len(got.Glossary) != tt.glossary || len(got.Instructions) != 1 {
+			t.Errorf("limit %d: %d hints, %d glossary entries, %d instructions; want %d, %d, 1", tt.limit, len(got.Hints), len(got.Glossary), len(got.Instructions), tt.hints, tt.glossary)
+		}
+	}
+}
+
+func TestApplyBudget(t *testing.T) {
+	patch := strings.Repeat("+added line of code\n", 2000)
+	extras := promptExtras{Hints: []string{strings.Repeat("h", 400)}}
+	for _, contextTokens := range []int{2000, 8000, 64000} {
+		a := &budgetAllocator{ContextTokens: contextTokens, Split: defaultBudgetSplit, TrimOrder: []string{budgetContext, budgetPatch}, Tokenizer: heuristicTokenizer{}}
+		trimmed, gotExtras, report := a.applyBudget(patch, extras)
+		prompt := buildPrompt(trimmed, gotExtras)
+		if tokens := (heuristicTokenizer{}).Count(prompt); tokens > contextTokens+10 {
+			t.Errorf("window %d: prompt has %d tokens", contextTokens, tokens)
+		}
+		if report.Patch.Size != len(patch) || report.Patch.Kept != len(trimmed) || report.ContextTokens != contextTokens {
+			t.Errorf("window %d: report %+v does not describe the patch", contextTokens, report.Patch)
+		}
+		if fits := report.Patch.SizeTokens <= report.Patch.KeptTokens; fits != (trimmed == patch) {
+			t.Errorf("window %d: report says fits=%v", contextTokens, fits)
+		}
+	}
+}
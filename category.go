@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Change categories, read from the paths a commit touches.
+const (
+	categoryCode     = "code"
+	categoryTestOnly = "test-only"
+	categoryDocsOnly = "docs-only"
+	categoryMixed    = "mixed"
+)
+
+// Treatments of test-only and docs-only commits, selected by -tests-and-docs.
+const (
+	testsAndDocsSummarize = "summarize"
+	testsAndDocsGroup     = "group"
+	testsAndDocsSkip      = "skip"
+)
+
+// defaultTestPaths are the paths of tests, fixtures and test data in the common layouts. The
+// test_paths config key adds to these.
+var defaultTestPaths = []string{
+	"*_test.go",
+	"testdata/",
+	"test/",
+	"tests/",
+	"__tests__/",
+	"spec/",
+	"test_*.py",
+	"*_test.py",
+	"conftest.py",
+	"*.test.js",
+	"*.test.jsx",
+	"*.test.ts",
+	"*.test.tsx",
+	"*.spec.js",
+	"*.spec.jsx",
+	"*.spec.ts",
+	"*.spec.tsx",
+	"*_spec.rb",
+	"src/test/",
+	"*Test.java",
+	"*Tests.cs",
+}
+
+// defaultDocPaths are the paths of documentation: documentation directories and markup files.
+// The doc_paths config key adds to these.
+var defaultDocPaths = []string{
+	"docs/",
+	"doc/",
+	"*.md",
+	"*.markdown",
+	"*.rst",
+	"*.adoc",
+	"*.asciidoc",
+}
+
+// changeCategories holds the globs that make a path a test or a documentation path.
+type changeCategories struct {
+	Tests []string
+	Docs  []string
+}
+
+// newChangeCategories returns the built-in test and documentation paths followed by the
+// configured ones.
+func newChangeCategories(config *Config) changeCategories {
+	return changeCategories{
+		Tests: append(append([]string{}, defaultTestPaths...), config.TestPaths...),
+		Docs:  append(append([]string{}, defaultDocPaths...), config.DocPaths...),
+	}
+}
+
+// validateCategoryPaths checks the test_paths or doc_paths config key.
+func validateCategoryPaths(key string, globs []string) error {
+	for i, glob := range globs {
+		if strings.TrimSpace(glob) == "" {
+			return fmt.Errorf("%s entry %d is empty", key, i+1)
+		}
+	}
+	return nil
+}
+
+// classify returns the category of a commit touching paths: test-only or docs-only when every
+// path is a test or documentation path, code when none is, and mixed otherwise, including a
+// commit that changes only tests and documentation. A path matching both lists is a test
+// path, e.g. testdata/README.md. A commit without paths is code.
+func (c changeCategories) classify(paths []string) string {
+	var tests, docs, code bool
+	for _, p := range paths {
+		if _, ok := matchAnyGlob(c.Tests, p); ok {
+			tests = true
+		} else if _, ok := matchAnyGlob(c.Docs, p); ok {
+			docs = true
+		} else {
+			code = true
+		}
+	}
+	switch {
+	case code && (tests || docs), tests && docs:
+		return categoryMixed
+	case tests:
+		return categoryTestOnly
+	case docs:
+		return categoryDocsOnly
+	default:
+		return categoryCode
+	}
+}
+
+// isTestsOrDocs reports whether category is one -tests-and-docs applies to.
+func isTestsOrDocs(category string) bool {
+	return category == categoryTestOnly || category == categoryDocsOnly
+}
+
+// categoryTemplated reports whether -tests-and-docs replaced the model summary of an entry.
+func (d *CommitAuditData) categoryTemplated() bool {
+	return d.TestsAndDocs == testsAndDocsSummarize || d.TestsAndDocs == testsAndDocsSkip
+}
+
+// categorySummary builds the templated summary of a test-only or docs-only commit under
+// -tests-and-docs summarize, from its paths and subject.
+func categorySummary(ctx context.Context, repoPath, commitHash, category string, paths []string) (string, error) {
+	message, err := getCommitMessage(ctx, repoPath, commitHash)
+	if err != nil {
+		return "", err
+	}
+	var unique []string
+	for _, p := range paths {
+		if !containsString(unique, p) {
+			unique = append(unique, p)
+		}
+	}
+	kind := "Test-only"
+	if category == categoryDocsOnly {
+		kind = "Documentation-only"
+	}
+	fileWord := "files"
+	if len(unique) == 1 {
+		fileWord = "file"
+	}
+	listed := unique
+	if len(listed) > 5 {
+		listed = listed[:5]
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s change across %d %s.\n\n", kind, len(unique), fileWord)
+	fmt.Fprintf(&sb, "Changed: %s", strings.Join(listed, ", "))
+	if len(unique) > len(listed) {
+		fmt.Fprintf(&sb, " and %d more", len(unique)-len(listed))
+	}
+	sb.WriteString(".")
+	if subject := strings.TrimSpace(strings.SplitN(message, "\n", 2)[0]); subject != "" {
+		fmt.Fprintf(&sb, "\n\nOriginal commit message: %s", subject)
+	}
+	return sb.String(), nil
+}
+
+// formatCategoryGroup renders the test-only and docs-only entries of -tests-and-docs group as
+// a section of full entries.
+func formatCategoryGroup(entries []CommitAuditData) string {
+	var sb strings.Builder
+	sb.WriteString(msg("section.tests_and_docs", msgCount("count.commits", len(entries))) + "\n\n")
+	for i, entry := range entries {
+		if i > 0 {
+			sb.WriteString("\n---\n\n")
+		}
+		sb.WriteString(formatCommitEntry(entry))
+	}
+	return sb.String()
+}
+
+// formatCategorySkipped lists the commits -tests-and-docs skip left unaudited, so that the
+// report still accounts for every commit of the range.
+func formatCategorySkipped(entries []CommitAuditData) string {
+	var sb strings.Builder
+	sb.WriteString(msg("section.tests_and_docs_skipped", msgCount("count.commits", len(entries))) + "\n")
+	for _, entry := range entries {
+		fmt.Fprintf(&sb, "%s %s [%s] %s\n", shortHash(entry.Hash), localDate(entry.Date), entry.ChangeCategory, entry.Summary)
+	}
+	return sb.String()
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	categories := newChangeCategories(&Config{TestPaths: []string{"qa/"}, DocPaths: []string{"*.txt"}})
+	for _, tc := range []struct {
+		paths []string
+		want  string
+	}{
+		{nil, categoryCode},
+		{[]string{"main.go"}, categoryCode},
+		{[]string{"main_test.go"}, categoryTestOnly},
+		{[]string{"pkg/testdata/golden.json", "web/app.spec.ts", "tests/test_api.py"}, categoryTestOnly},
+		{[]string{"README.md", "docs/guide.html"}, categoryDocsOnly},
+		// The boundary: one test file and one source file is mixed, either way round.
+		{[]string{"main_test.go", "main.go"}, categoryMixed},
+		{[]string{"main.go", "main_test.go"}, categoryMixed},
+		{[]string{"README.md", "main.go"}, categoryMixed},
+		// Tests and documentation without code is mixed too.
+		{[]string{"main_test.go", "README.md"}, categoryMixed},
+		// A path matching both lists is a test path.
+		{[]string{"testdata/README.md"}, categoryTestOnly},
+		// The config adds to the built-in paths.
+		{[]string{"qa/smoke.sh"}, categoryTestOnly},
+		{[]string{"NOTES.txt"}, categoryDocsOnly},
+		// Paths that only look like tests or documentation are code.
+		{[]string{"contest.go", "testing/helpers.go", "mdparser.go"}, categoryCode},
+	} {
+		if got := categories.classify(tc.paths); got != tc.want {
+			t.Errorf("classify(%q) = %q, want %q", tc.paths, got, tc.want)
+		}
+	}
+	// Without config the extra globs do not apply, and the defaults are not modified.
+	defaults := newChangeCategories(&Config{})
+	if got := defaults.classify([]string{"qa/smoke.sh"}); got != categoryCode {
+		t.Errorf("qa/smoke.sh without test_paths is %q", got)
+	}
+	if len(defaults.Tests) != len(defaultTestPaths) || len(defaults.Docs) != len(defaultDocPaths) {
+		t.Errorf("the defaults grew: %d tests, %d docs", len(defaults.Tests), len(defaults.Docs))
+	}
+}
+
+func TestValidateCategoryPaths(t *testing.T) {
+	if err := validateCategoryPaths("test_paths", []string{"qa/", "*.check"}); err != nil {
+		t.Error(err)
+	}
+	if err := validateCategoryPaths("doc_paths", []string{"*.txt", "  "}); err == nil || err.Error() != "doc_paths entry 2 is empty" {
+		t.Errorf("empty entry: %v", err)
+	}
+}
+
+func TestCategorySummary(t *testing.T) {
+	repo := newFixtureRepo(t)
+	paths := []string{"a_test.go", "b_test.go", "a_test.go", "c_test.go", "d_test.go", "e_test.go", "f_test.go", "g_test.go"}
+	hash := repo.commit("Add more tests\n\nWith a body.", map[string]string{"a_test.go": "package a\n"})
+	got, err := categorySummary(context.Background(), repo.Dir, hash, categoryTestOnly, paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Test-only change across 7 files.\n\nChanged: a_test.go, b_test.go, c_test.go, d_test.go, e_test.go and 2 more.\n\nOriginal commit message: Add more tests"
+	if got != want {
+		t.Errorf("summary:\n%s\nwant:\n%s", got, want)
+	}
+	got, err = categorySummary(context.Background(), repo.Dir, hash, categoryDocsOnly, []string{"README.md"})
+	if err != nil || !strings.HasPrefix(got, "Documentation-only change across 1 file.\n\nChanged: README.md.\n\n") {
+		t.Errorf("docs summary %q, %v", got, err)
+	}
+}
+
+// newCategoryFixture creates a repository with a commit of each category, including both
+// kinds of mixed commit and a commit under qa/, which is code unless test_paths lists it. It
+// returns the commits, oldest first.
+func newCategoryFixture(t *testing.T) (*fixtureRepo, []string) {
+	t.Helper()
+	repo := newFixtureRepo(t)
+	return repo, []string{
+		repo.commit("Code", map[string]string{"src/a.go": "package src\n"}),
+		repo.commit("Tests", map[string]string{"src/a_test.go": "package src\n"}),
+		repo.commit("Docs", map[string]string{"docs/guide.md": "# Guide\n", "README.md": "# Readme\n"}),
+		repo.commit("Boundary", map[string]string{"src/b.go": "package src\n", "src/b_test.go": "package src\n"}),
+		repo.commit("Tests and docs", map[string]string{"tests/test_x.py": "pass\n", "docs/more.md": "# More\n"}),
+		repo.commit("Smoke", map[string]string{"qa/smoke.sh": "true\n"}),
+	}
+}
+
+func TestTestsAndDocsRun(t *testing.T) {
+	repo, hashes := newCategoryFixture(t)
+	env := newAuditEnv(t)
+
+	report := filepath.Join(env.Work, "report.json")
+	out := env.mustRun("-repo", repo.Dir, "-commit", "root", "-format", "json", "-output", report)
+	if !strings.Contains(out, "By changed paths, 2 commits are code, 1 test-only, 1 docs-only and 2 mixed.\n") {
+		t.Errorf("output:\n%s", out)
+	}
+	var document jsonReport
+	if err := json.Unmarshal([]byte(readFile(t, report)), &document); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		hashes[0]: categoryCode, hashes[1]: categoryTestOnly, hashes[2]: categoryDocsOnly,
+		hashes[3]: categoryMixed, hashes[4]: categoryMixed, hashes[5]: categoryCode,
+	}
+	for _, entry := range document.Commits {
+		if entry.ChangeCategory != want[entry.Hash] || entry.TestsAndDocs != "" {
+			t.Errorf("%s: change_category %q, tests_and_docs %q, want %q", shortHash(entry.Hash), entry.ChangeCategory, entry.TestsAndDocs, want[entry.Hash])
+		}
+	}
+
+	env.Config["test_paths"] = []string{"qa/"}
+	prompts := len(env.Ollama.Prompts())
+	text := filepath.Join(env.Work, "summarize.txt")
+	out = env.mustRun("-repo", repo.Dir, "-commit", "root", "-output", text, "-tests-and-docs", "summarize")
+	if !strings.Contains(out, "By changed paths, 1 commits are code, 2 test-only, 1 docs-only and 2 mixed.\n") ||
+		!strings.Contains(out, "3 test-only and docs-only commits got a templated summary and skipped the model call.\n") {
+		t.Errorf("summarize output:\n%s", out)
+	}
+	if n := len(env.Ollama.Prompts()) - prompts; n != 3 {
+		t.Errorf("summarize sent %d prompts, want 3", n)
+	}
+	content := readFile(t, text)
+	for _, want := range []string{
+		"Test-only change across 1 file.\n\nChanged: src/a_test.go.\n\nOriginal commit message: Tests\n",
+		"Documentation-only change across 2 files.\n\nChanged: README.md, docs/guide.md.\n\nOriginal commit message: Docs\n",
+		"Change category: test-only (summary generated without the model)\n",
+		"Change category: mixed\n",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("summarize report lacks %q:\n%s", want, content)
+		}
+	}
+	if strings.Contains(content, "Change category: code") {
+		t.Errorf("code entries carry a category note:\n%s", content)
+	}
+
+	prompts = len(env.Ollama.Prompts())
+	text = filepath.Join(env.Work, "group.txt")
+	env.mustRun("-repo", repo.Dir, "-commit", "root", "-output", text, "-tests-and-docs", "group")
+	if n := len(env.Ollama.Prompts()) - prompts; n != 6 {
+		t.Errorf("group sent %d prompts, want 6", n)
+	}
+	content = readFile(t, text)
+	section := strings.Index(content, "=== Test and documentation changes (3 commits) ===\n\n")
+	if section < 0 {
+		t.Fatalf("group report lacks the section:\n%s", content)
+	}
+	for i, hash := range hashes {
+		grouped := i == 1 || i == 2 || i == 5
+		if at := strings.Index(content, "Commit: "+hash); at < 0 || (at > section) != grouped {
+			t.Errorf("%s (grouped %v) is at %d, the section at %d:\n%s", shortHash(hash), grouped, at, section, content)
+		}
+	}
+
+	prompts = len(env.Ollama.Prompts())
+	text = filepath.Join(env.Work, "skip.txt")
+	out = env.mustRun("-repo", repo.Dir, "-commit", "root", "-output", text, "-tests-and-docs", "skip")
+	if !strings.Contains(out, "3 test-only and docs-only commits were skipped and are listed at the end of the report.\n") {
+		t.Errorf("skip output:\n%s", out)
+	}
+	if n := len(env.Ollama.Prompts()) - prompts; n != 3 {
+		t.Errorf("skip sent %d prompts, want 3", n)
+	}
+	content = readFile(t, text)
+	skipped := content[strings.Index(content, "=== Test and documentation changes not audited (3 commits) ===\n"):]
+	for i, subject := range map[int]string{1: "Tests", 2: "Docs", 5: "Smoke"} {
+		if !strings.Contains(skipped, shortHash(hashes[i])+" ") || !strings.Contains(skipped, "] "+subject+"\n") {
+			t.Errorf("the skipped list lacks %s %s:\n%s", shortHash(hashes[i]), subject, skipped)
+		}
+		if strings.Contains(content, "Commit: "+hashes[i]) {
+			t.Errorf("skipped %s has a full entry", shortHash(hashes[i]))
+		}
+	}
+	if strings.Count(content, "Commit: ") != 3 {
+		t.Errorf("skip report:\n%s", content)
+	}
+
+	if out, code := env.run("-repo", repo.Dir, "-commit", "root", "-output", text, "-force", "-tests-and-docs", "drop"); code == 0 || !strings.Contains(out, `invalid -tests-and-docs value "drop"`) {
+		t.Errorf("-tests-and-docs drop: exit %d\n%s", code, out)
+	}
+	env.Config["doc_paths"] = []string{""}
+	if out, code := env.run("-repo", repo.Dir, "-commit", "root", "-output", text, "-force"); code == 0 || !strings.Contains(out, "doc_paths entry 1 is empty") {
+		t.Errorf("empty doc_paths entry: exit %d\n%s", code, out)
+	}
+}
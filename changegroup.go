@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// groupByChangeID is the -group-by value that groups the patch sets of a Gerrit change.
+const groupByChangeID = "change-id"
+
+// changeIDPattern is the form of a Gerrit Change-Id: "I" and 40 hex digits.
+var changeIDPattern = regexp.MustCompile(`^I[0-9a-f]{40}$`)
+
+// changeGroup records the commits of a -group-by change-id entry.
+type changeGroup struct {
+	ChangeID string `json:"change_id"`
+	// Commits are the patch-set commits of the change in the range, oldest first; the entry
+	// itself is the last one.
+	Commits []string `json:"commits"`
+}
+
+// getChangeIDs reads the Change-Id trailer of each commit, parsed the way git
+// interpret-trailers parses them: only the trailer block at the end of the message counts.
+// Commits without a usable Change-Id are left out; a malformed value or several different
+// ones are reported with a warning.
+func getChangeIDs(repoPath string, hashes []string) (map[string]string, error) {
+	ids := make(map[string]string)
+	if len(hashes) == 0 {
+		return ids, nil
+	}
+	input := strings.NewReader(strings.Join(hashes, "\n") + "\n")
+	output, err := gitRunInput(context.Background(), repoPath, input, "log", "--no-walk=unsorted", "--stdin",
+		"--format=%H%x00%(trailers:key=Change-Id,valueonly,separator=%x01)%x00")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the Change-Id trailers: %w", err)
+	}
+	fields := strings.Split(string(output), "\x00")
+	for i := 0; i+1 < len(fields); i += 2 {
+		hash := strings.TrimSpace(fields[i])
+		id, err := parseChangeID(fields[i+1])
+		if err != nil {
+			fmt.Printf("Warning: commit %s is audited on its own: %v\n", shortHash(hash), err)
+			continue
+		}
+		if id != "" {
+			ids[hash] = id
+		}
+	}
+	return ids, nil
+}
+
+// parseChangeID returns the Change-Id among the \x01-separated trailer values, "" when there
+// is none. Repeating the same value is allowed.
+func parseChangeID(values string) (string, error) {
+	var id string
+	for _, value := range strings.Split(values, "\x01") {
+		value = strings.TrimSpace(value)
+		switch {
+		case value == "" || value == id:
+		case !changeIDPattern.MatchString(value):
+			return "", fmt.Errorf("malformed Change-Id %q", value)
+		case id != "":
+			return "", fmt.Errorf("several Change-Ids (%s, %s)", id, value)
+		default:
+			id = value
+		}
+	}
+	return id, nil
+}
+
+// groupByChange folds the commits of hashes (newest first) that share a Change-Id into
+// their newest commit, which stands for the change in the report. It returns the remaining
+// hashes in their order and the groups by the hash of that commit. Commits without a
+// Change-Id, or alone with theirs in the range, stay individual entries.
+func groupByChange(hashes []string, ids map[string]string) ([]string, map[string]changeGroup) {
+	members := make(map[string][]string)
+	for _, hash := range hashes {
+		if id := ids[hash]; id != "" {
+			members[id] = append(members[id], hash)
+		}
+	}
+	groups := make(map[string]changeGroup)
+	var kept []string
+	for _, hash := range hashes {
+		id := ids[hash]
+		commits := members[id]
+		if id == "" || len(commits) < 2 {
+			kept = append(kept, hash)
+			continue
+		}
+		if commits[0] != hash {
+			continue // Folded into the newest patch set.
+		}
+		group := changeGroup{ChangeID: id}
+		for i := len(commits) - 1; i >= 0; i-- {
+			group.Commits = append(group.Commits, commits[i])
+		}
+		groups[hash] = group
+		kept = append(kept, hash)
+	}
+	return kept, groups
+}
+
+// getGroupPatch concatenates the patches of the patch sets of a change, oldest first, each
+// under a header naming it.
+func getGroupPatch(ctx context.Context, repoPath string, group *changeGroup, privacy promptPrivacy, pathspec []string, diffArgs ...string) (string, error) {
+	var sb strings.Builder
+	for i, hash := range group.Commits {
+		patch, err := getPatchForCommit(ctx, repoPath, hash, privacy, pathspec, diffArgs...)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&sb, "=== Patch set %d of %d ===\n%s\n", i+1, len(group.Commits), patch)
+	}
+	return sb.String(), nil
+}
+
+// changeGroupHint tells the model that the entry covers the patch sets of one change.
+func changeGroupHint(group *changeGroup) string {
+	hashes := make([]string, len(group.Commits))
+	for i, hash := range group.Commits {
+		hashes[i] = shortHash(hash)
+	}
+	return fmt.Sprintf("This entry stands for %d commits that are patch sets of the same code review change (Change-Id %s): %s, oldest first. Describe the change as a whole and note what the later patch sets revised.", len(group.Commits), group.ChangeID, strings.Join(hashes, ", "))
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	changeA = "I1111111111111111111111111111111111111111"
+	changeB = "I2222222222222222222222222222222222222222"
+)
+
+func TestParseChangeID(t *testing.T) {
+	tests := []struct {
+		values, id, err string
+	}{
+		{"", "", ""},
+		{changeA, changeA, ""},
+		{" " + changeA + " ", changeA, ""},
+		{changeA + "\x01" + changeA, changeA, ""},
+		{changeA + "\x01\x01", changeA, ""},
+		{"I123", "", `malformed Change-Id "I123"`},
+		{"I" + strings.Repeat("AB", 20), "", "malformed Change-Id"},
+		{changeA + "\x01" + changeB, "", "several Change-Ids (" + changeA + ", " + changeB + ")"},
+	}
+	for _, tt := range tests {
+		id, err := parseChangeID(tt.values)
+		if id != tt.id || (tt.err == "") != (err == nil) || err != nil && !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("parseChangeID(%q) = %q, %v; want %q, %q", tt.values, id, err, tt.id, tt.err)
+		}
+	}
+}
+
+func TestGetChangeIDs(t *testing.T) {
+	repo := newFixtureRepo(t)
+	commit := func(message string) string {
+		return repo.commit(message, map[string]string{"src/a.go": "package src // " + message + "\n"})
+	}
+	hashes := map[string]string{
+		"plain":    commit("Fix the parser\n\nChange-Id: " + changeA),
+		"trailers": commit("Fix the parser\n\nSigned-off-by: Ada <ada@example.com>\nChange-Id: " + changeA + "\nReviewed-on: https://review.example.com/1"),
+		"repeated": commit("Fix the parser\n\nChange-Id: " + changeA + "\nChange-Id: " + changeA),
+		// A Change-Id line in the body, followed by more text, is not a trailer.
+		"body":      commit("Fix the parser\n\nChange-Id: " + changeA + "\n\nMore text after it."),
+		"none":      commit("Touch the code"),
+		"malformed": commit("Fix the parser\n\nChange-Id: I123"),
+		"several":   commit("Fix the parser\n\nChange-Id: " + changeA + "\nChange-Id: " + changeB),
+		"subject":   commit("Change-Id: " + changeA),
+	}
+	var all []string
+	for _, hash := range hashes {
+		all = append(all, hash)
+	}
+	var ids map[string]string
+	out := captureStdout(t, func() {
+		var err error
+		if ids, err = getChangeIDs(repo.Dir, all); err != nil {
+			t.Fatal(err)
+		}
+	})
+	want := map[string]string{hashes["plain"]: changeA, hashes["trailers"]: changeA, hashes["repeated"]: changeA}
+	if !reflect.DeepEqual(ids, want) {
+		for name, hash := range hashes {
+			if ids[hash] != want[hash] {
+				t.Errorf("%s: Change-Id %q, want %q", name, ids[hash], want[hash])
+			}
+		}
+	}
+	for _, warning := range []string{
+		"Warning: commit " + shortHash(hashes["malformed"]) + ` is audited on its own: malformed Change-Id "I123"`,
+		"Warning: commit " + shortHash(hashes["several"]) + " is audited on its own: several Change-Ids",
+	} {
+		if !strings.Contains(out, warning) {
+			t.Errorf("output lacks %q:\n%s", warning, out)
+		}
+	}
+	if ids, err := getChangeIDs(repo.Dir, nil); err != nil || len(ids) != 0 {
+		t.Errorf("no commits: %v, %v", ids, err)
+	}
+}
+
+func TestGroupByChange(t *testing.T) {
+	// Newest first: c5 and c2 are patch sets of change A, c4 and c3 of B; c6 is alone with
+	// its Change-Id in the range and c1 has none, so both stay individual entries.
+	hashes := []string{"c6", "c5", "c4", "c3", "c2", "c1"}
+	ids := map[string]string{"c5": changeA, "c2": changeA, "c4": changeB, "c3": changeB, "c6": "Ic"}
+	kept, groups := groupByChange(hashes, ids)
+	if !reflect.DeepEqual(kept, []string{"c6", "c5", "c4", "c1"}) {
+		t.Errorf("kept %q", kept)
+	}
+	want := map[string]changeGroup{
+		"c5": {ChangeID: changeA, Commits: []string{"c2", "c5"}},
+		"c4": {ChangeID: changeB, Commits: []string{"c3", "c4"}},
+	}
+	if !reflect.DeepEqual(groups, want) {
+		t.Errorf("groups %+v", groups)
+	}
+	if kept, groups := groupByChange(hashes, nil); !reflect.DeepEqual(kept, hashes) || len(groups) != 0 {
+		t.Errorf("without ids: %q, %v", kept, groups)
+	}
+}
+
+func TestChangeGroupRun(t *testing.T) {
+	repo := newFixtureRepo(t)
+	base := repo.commit("Initial", map[string]string{"README": "app\n"})
+	first := repo.commit("Add the retry loop\n\nChange-Id: "+changeA, map[string]string{"src/retry.go": "package src\n\nfunc retry() {}\n"})
+	alone := repo.commit("Fix a typo", map[string]string{"README": "the app\n"})
+	second := repo.commit("Add the retry loop\n\nBound the attempts.\n\nChange-Id: "+changeA, map[string]string{"src/retry.go": "package src\n\nfunc retry() { for i := 0; i < 3; i++ {} }\n"})
+	env := newAuditEnv(t)
+
+	report := filepath.Join(env.Work, "report.json")
+	env.mustRun("-repo", repo.Dir, "-commit", base, "-group-by", "change-id", "-format", "json", "-output", report)
+	prompts := env.Ollama.Prompts()
+	if len(prompts) != 3 {
+		t.Fatalf("%d prompts, want one per entry (3)", len(prompts))
+	}
+	var grouped string
+	for _, prompt := range prompts {
+		if strings.Contains(prompt, "=== Patch set") {
+			grouped = prompt
+		}
+	}
+	for _, want := range []string{
+		"This entry stands for 2 commits that are patch sets of the same code review change (Change-Id " + changeA + "): " + shortHash(first) + ", " + shortHash(second) + ", oldest first.",
+		"=== Patch set 1 of 2 ===\n",
+		"=== Patch set 2 of 2 ===\n",
+		"func retry() {}",
+		"i < 3",
+	} {
+		if !strings.Contains(grouped, want) {
+			t.Errorf("grouped prompt lacks %q:\n%s", want, grouped)
+		}
+	}
+	if strings.Index(grouped, "func retry() {}") > strings.Index(grouped, "=== Patch set 2 of 2") {
+		t.Error("patch sets are not oldest first")
+	}
+
+	var document jsonReport
+	if err := json.Unmarshal([]byte(readFile(t, report)), &document); err != nil {
+		t.Fatal(err)
+	}
+	var entries []string
+	for _, entry := range document.Commits {
+		entries = append(entries, entry.Hash)
+		if entry.Hash == second {
+			if entry.ChangeGroup == nil || entry.ChangeGroup.ChangeID != changeA || !reflect.DeepEqual(entry.ChangeGroup.Commits, []string{first, second}) {
+				t.Errorf("group entry: %+v", entry.ChangeGroup)
+			}
+		} else if entry.ChangeGroup != nil {
+			t.Errorf("%s has a change group", shortHash(entry.Hash))
+		}
+	}
+	if !reflect.DeepEqual(entries, []string{second, alone, base}) {
+		t.Errorf("entries %q", entries)
+	}
+
+	text := filepath.Join(env.Work, "report.txt")
+	env.mustRun("-repo", repo.Dir, "-commit", base, "-group-by", "change-id", "-output", text)
+	if content := readFile(t, text); !strings.Contains(content, "Change-Id: "+changeA+" (patch sets "+shortHash(first)+", "+shortHash(second)+")") {
+		t.Errorf("text report:\n%s", content)
+	}
+}
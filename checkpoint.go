@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// checkpointPolicy says how often a partial report is written during a run: after every
+// Commits newly audited commits, or once Interval has passed since the last checkpoint.
+type checkpointPolicy struct {
+	Commits  int
+	Interval time.Duration
+}
+
+// parseCheckpointPolicy parses -checkpoint-every, either a commit count ("25") or a Go
+// duration ("30m"). An empty value disables checkpoints.
+func parseCheckpointPolicy(value string) (checkpointPolicy, error) {
+	if value == "" {
+		return checkpointPolicy{}, nil
+	}
+	if n, err := strconv.Atoi(value); err == nil {
+		if n <= 0 {
+			return checkpointPolicy{}, fmt.Errorf("-checkpoint-every must be a positive commit count or a duration, got %q", value)
+		}
+		return checkpointPolicy{Commits: n}, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return checkpointPolicy{}, fmt.Errorf("-checkpoint-every must be a positive commit count or a duration such as 30m, got %q", value)
+	}
+	return checkpointPolicy{Interval: d}, nil
+}
+
+// checkpointer writes partial reports while the audit runs. Each checkpoint renders a
+// snapshot of the entries in the background, so a slow disk does not hold up the next
+// commit; a checkpoint that comes due while the previous one is still being written is
+// skipped.
+type checkpointer struct {
+	Policy   checkpointPolicy
+	Filename string
+	Split    splitPolicy
+	Total    int    // Commits queued for the run, for the partial header.
+	Settings string // The report's settings header, repeated below the partial header.
+
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	writing bool
+	pending int // Commits audited since the last checkpoint.
+	last    time.Time
+}
+
+// newCheckpointer returns a checkpointer for the report at filename, or nil when policy
+// disables checkpoints. All methods are safe to call on nil.
+func newCheckpointer(policy checkpointPolicy, filename string, split splitPolicy, total int, settings string) *checkpointer {
+	if policy.Commits == 0 && policy.Interval == 0 {
+		return nil
+	}
+	return &checkpointer{Policy: policy, Filename: filename, Split: split, Total: total, Settings: settings, last: time.Now()}
+}
+
+// Record notes that an entry was added to entries and writes a checkpoint if one is due.
+func (c *checkpointer) Record(entries []CommitAuditData) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending++
+	due := (c.Policy.Commits > 0 && c.pending >= c.Policy.Commits) ||
+		(c.Policy.Interval > 0 && time.Since(c.last) >= c.Policy.Interval)
+	if !due || c.writing {
+		return
+	}
+	c.pending = 0
+	c.last = time.Now()
+	c.writing = true
+
+	snapshot := make([]CommitAuditData, len(entries))
+	copy(snapshot, entries)
+	header := msg("header.partial", len(snapshot), c.Total, c.last.Format(time.RFC3339)) + "\n\n" + c.Settings
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		if written, err := writeReport(c.Filename, snapshot, header, c.Split); err != nil {
+			fmt.Printf("Warning: failed to write checkpoint: %v\n", err)
+		} else {
+			fmt.Printf("Checkpoint: wrote %d audited commit entries to %s\n", len(snapshot), written)
+		}
+		c.mu.Lock()
+		c.writing = false
+		c.mu.Unlock()
+	}()
+}
+
+// Wait blocks until a checkpoint in progress is written, so that it cannot replace the final
+// report.
+func (c *checkpointer) Wait() {
+	if c == nil {
+		return
+	}
+	c.wg.Wait()
+}
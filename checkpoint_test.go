@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseCheckpointPolicy(t *testing.T) {
+	tests := []struct {
+		value string
+		want  checkpointPolicy
+		err   bool
+	}{
+		{"", checkpointPolicy{}, false},
+		{"25", checkpointPolicy{Commits: 25}, false},
+		{"30m", checkpointPolicy{Interval: 30 * time.Minute}, false},
+		{"1h30m", checkpointPolicy{Interval: 90 * time.Minute}, false},
+		{"0", checkpointPolicy{}, true},
+		{"-5", checkpointPolicy{}, true},
+		{"0s", checkpointPolicy{}, true},
+		{"often", checkpointPolicy{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseCheckpointPolicy(tt.value)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("parseCheckpointPolicy(%q) = %+v, %v", tt.value, got, err)
+		}
+	}
+}
+
+// checkpointHeader matches the partial marker of a checkpoint.
+var checkpointHeader = regexp.MustCompile(`^=== Partial report: (\d+) of (\d+) commits audited \(checkpoint at [^)]+\) ===\n`)
+
+// reportCommits returns the commits of the entries of a text report, in report order.
+func reportCommits(text string) []string {
+	var hashes []string
+	for _, m := range regexp.MustCompile(`(?m)^Commit: ([0-9a-f]+)$`).FindAllStringSubmatch(text, -1) {
+		hashes = append(hashes, m[1])
+	}
+	return hashes
+}
+
+// checkStrictPrefixes checks that the commit set of each checkpoint strictly contains the one
+// before it and that the checkpoints' entries are those their headers count.
+func checkStrictPrefixes(t *testing.T, checkpoints []string) {
+	t.Helper()
+	var previous map[string]bool
+	for i, text := range checkpoints {
+		m := checkpointHeader.FindStringSubmatch(text)
+		if m == nil {
+			t.Fatalf("checkpoint %d has no partial header:\n%s", i, text)
+		}
+		commits := reportCommits(text)
+		if m[1] != fmt.Sprint(len(commits)) {
+			t.Errorf("checkpoint %d counts %s entries but has %d", i, m[1], len(commits))
+		}
+		set := make(map[string]bool)
+		for _, hash := range commits {
+			if set[hash] {
+				t.Errorf("checkpoint %d lists %s twice", i, hash)
+			}
+			set[hash] = true
+		}
+		for hash := range previous {
+			if !set[hash] {
+				t.Errorf("checkpoint %d lost %s", i, hash)
+			}
+		}
+		if len(set) <= len(previous) {
+			t.Errorf("checkpoint %d has %d commits, no more than the %d before it", i, len(set), len(previous))
+		}
+		previous = set
+	}
+}
+
+func TestCheckpointerWritesEveryN(t *testing.T) {
+	report := filepath.Join(t.TempDir(), "gitaudit.txt")
+	c := newCheckpointer(checkpointPolicy{Commits: 10}, report, splitPolicy{}, 50, "Settings: test\n")
+	var entries []CommitAuditData
+	var checkpoints []string
+	for i := 0; i < 50; i++ {
+		entries = append(entries, CommitAuditData{Kind: kindCommit, Hash: fmt.Sprintf("%040x", i+1), Summary: fmt.Sprintf("Change %d.", i)})
+		c.Record(entries)
+		c.Wait()
+		data, err := os.ReadFile(report)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if len(checkpoints) == 0 || checkpoints[len(checkpoints)-1] != string(data) {
+			checkpoints = append(checkpoints, string(data))
+		}
+	}
+	if len(checkpoints) != 5 {
+		t.Fatalf("%d checkpoints for 50 commits every 10, want 5", len(checkpoints))
+	}
+	for i, text := range checkpoints {
+		if want := fmt.Sprintf("=== Partial report: %d of 50 commits audited", 10*(i+1)); !strings.HasPrefix(text, want) {
+			t.Errorf("checkpoint %d starts %q, want %q", i, text[:min(len(text), 60)], want)
+		}
+		if !strings.Contains(text, "Settings: test") {
+			t.Errorf("checkpoint %d lacks the settings header", i)
+		}
+	}
+	checkStrictPrefixes(t, checkpoints)
+}
+
+func TestCheckpointerNil(t *testing.T) {
+	c := newCheckpointer(checkpointPolicy{}, "unused", splitPolicy{}, 1, "")
+	if c != nil {
+		t.Fatal("a checkpointer without a policy was created")
+	}
+	c.Record([]CommitAuditData{{Hash: "x"}})
+	c.Wait()
+}
+
+func TestCheckpointAudit(t *testing.T) {
+	repo := newFixtureRepo(t)
+	hashes := repo.commits(50)
+	env := newAuditEnv(t)
+	report := filepath.Join(env.Work, "gitaudit.txt")
+
+	// Every generate request takes a look at the report, which the checkpoints replace
+	// atomically; any version seen must parse.
+	var mu sync.Mutex
+	var checkpoints []string
+	env.Ollama.Delay = 20 * time.Millisecond
+	env.Ollama.Respond = func(n int, prompt string) (int, string) {
+		data, err := os.ReadFile(report)
+		if err != nil {
+			return 0, ""
+		}
+		mu.Lock()
+		if len(checkpoints) == 0 || checkpoints[len(checkpoints)-1] != string(data) {
+			checkpoints = append(checkpoints, string(data))
+		}
+		mu.Unlock()
+		return 0, ""
+	}
+	out := env.mustRun("-repo", repo.Dir, "-commit", "root", "-output", report, "-checkpoint-every", "10")
+	if n := strings.Count(out, "Checkpoint: wrote "); n < 4 {
+		t.Errorf("%d checkpoints written, want at least 4:\n%s", n, out)
+	}
+	if len(checkpoints) < 3 {
+		t.Fatalf("saw %d checkpoints during the run", len(checkpoints))
+	}
+	for _, text := range checkpoints {
+		if m := checkpointHeader.FindStringSubmatch(text); m == nil || m[2] != "50" || len(reportCommits(text))%10 != 0 {
+			t.Errorf("checkpoint is not a partial report of multiples of 10 commits:\n%s", text)
+		}
+	}
+	checkStrictPrefixes(t, checkpoints)
+
+	final := readFile(t, report)
+	if strings.Contains(final, "Partial report") {
+		t.Errorf("final report keeps the partial marker:\n%s", final[:200])
+	}
+	if got := reportCommits(final); len(got) != len(hashes) {
+		t.Errorf("final report has %d entries, want %d", len(got), len(hashes))
+	}
+	last := reportCommits(checkpoints[len(checkpoints)-1])
+	set := make(map[string]bool)
+	for _, hash := range reportCommits(final) {
+		set[hash] = true
+	}
+	for _, hash := range last {
+		if !set[hash] {
+			t.Errorf("final report lacks %s of the last checkpoint", hash)
+		}
+	}
+}
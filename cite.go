@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Citation statuses recorded on CommitAuditData.CitationStatus in -cite mode.
+const (
+	citationsVerified   = "verified"
+	citationsUnverified = "unverified"
+)
+
+// citationInstruction is added to the prompt in -cite mode.
+const citationInstruction = `Follow each major claim with a bracketed citation of the file and hunk header it is based on, copied exactly from the patch, for example [src/auth/login.go @@ -42,7 +42,9 @@].`
+
+// citationPattern matches citations of the form [path @@ -a,b +c,d @@].
+var citationPattern = regexp.MustCompile(`\[([^\[\]]+?) (@@ -\d+(?:,\d+)? \+\d+(?:,\d+)? @@)\]`)
+
+// hunkHeaderPattern extracts the range part of a hunk header, without the trailing function context.
+var hunkHeaderPattern = regexp.MustCompile(`^(@@ -\d+(?:,\d+)? \+\d+(?:,\d+)? @@)`)
+
+// patchHunks returns the set of "path @@ ... @@" keys for every hunk in a unified diff.
+// Hunks of deleted files are keyed by their old path.
+func patchHunks(patch string) map[string]bool {
+	hunks := make(map[string]bool)
+	var oldPath, newPath string
+	for _, line := range strings.Split(patch, "\n") {
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			oldPath, newPath = "", ""
+		case strings.HasPrefix(line, "--- "):
+			oldPath = strings.TrimPrefix(strings.TrimPrefix(line, "--- "), "a/")
+		case strings.HasPrefix(line, "+++ "):
+			newPath = strings.TrimPrefix(strings.TrimPrefix(line, "+++ "), "b/")
+		case strings.HasPrefix(line, "@@ "):
+			m := hunkHeaderPattern.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			path := newPath
+			if path == "" || path == "/dev/null" {
+				path = oldPath
+			}
+			hunks[path+" "+m[1]] = true
+		}
+	}
+	return hunks
+}
+
+// validateCitations checks every citation in summary against the hunks of patch and
+// returns the citations that were found and those that do not exist in the patch.
+func validateCitations(summary, patch string) (valid, invalid []string) {
+	hunks := patchHunks(patch)
+	for _, m := range citationPattern.FindAllStringSubmatch(summary, -1) {
+		key := strings.TrimSpace(m[1]) + " " + m[2]
+		if hunks[key] {
+			valid = append(valid, m[0])
+		} else {
+			invalid = append(invalid, m[0])
+		}
+	}
+	return valid, invalid
+}
+
+// citationRetryInstruction builds the stronger instruction used when regenerating an entry
+// whose citations could not be verified.
+func citationRetryInstruction(invalid []string) string {
+	if len(invalid) == 0 {
+		return "Your previous answer contained no citations. " + citationInstruction
+	}
+	return fmt.Sprintf("Your previous answer cited hunks that do not exist in the patch (%s). Only cite file paths and hunk headers that appear verbatim in the patch below.",
+		strings.Join(invalid, ", "))
+}
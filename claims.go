@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// claimMarker follows each claim of a summary that nothing in the model's input supports.
+const claimMarker = "[unverified]"
+
+// claimRetryInstruction is added when a summary is regenerated because it made unsupported
+// claims. The claims are the model's own, so naming them sends nothing new.
+const claimRetryInstruction = "Your previous answer mentioned specifics that appear nowhere in the patch, commit message or context below: %s. Describe the change again without mentioning any issue or pull request number, URL, file path or identifier that does not appear in the input."
+
+// Kinds of claims.
+const (
+	claimIssue      = "issue"
+	claimURL        = "url"
+	claimPath       = "path"
+	claimIdentifier = "identifier"
+)
+
+// claimPatterns find the claims of a summary. The first group, or the whole match without
+// one, is the claim. Generic prose must not match: a slash alone, as in "and/or", does not
+// make a path, and camelCase words are identifiers only in code spans or calls.
+var claimPatterns = []struct {
+	Kind    string
+	Pattern *regexp.Regexp
+}{
+	{claimURL, regexp.MustCompile("https?://[^\\s<>()\\[\\]\"'`]+")},
+	{claimIssue, regexp.MustCompile(`(?:^|[^\w&/#])(#\d+|GH-\d+)\b`)},
+	{claimPath, regexp.MustCompile(`(?:^|[^\w./-])((?:[\w.-]+/)*[\w-][\w.-]*\.(?:go|mod|sum|py|js|jsx|ts|tsx|mjs|rb|java|kt|scala|swift|c|h|cc|cpp|hpp|cs|rs|php|sh|bash|ps1|pl|lua|sql|proto|ya?ml|json|toml|ini|cfg|conf|xml|html|css|scss|md|rst|txt|lock|gradle|tf))\b`)},
+	{claimIdentifier, regexp.MustCompile("`([^`\\s]+)`")},
+	{claimIdentifier, regexp.MustCompile(`\b([A-Za-z_]\w*(?:\.[A-Za-z_]\w*)*)\((?:[^s]|s[^)]|$)`)},
+	{claimIdentifier, regexp.MustCompile(`\b[a-z][a-z0-9]*(?:_[a-z0-9]+)+\b`)},
+}
+
+// claim is a concrete reference made by a summary.
+type claim struct {
+	Text string
+	Kind string
+}
+
+// extractClaims returns the distinct claims of summary in order of appearance. Anything
+// within a URL is part of the URL's claim.
+func extractClaims(summary string) []claim {
+	type found struct {
+		claim
+		start, end int
+	}
+	var all, urls []found
+	for _, p := range claimPatterns {
+		for _, loc := range p.Pattern.FindAllStringSubmatchIndex(summary, -1) {
+			start, end := loc[0], loc[1]
+			if len(loc) > 2 {
+				start, end = loc[2], loc[3]
+			}
+			text := strings.TrimRight(summary[start:end], ".,;:!?")
+			f := found{claim{text, p.Kind}, start, start + len(text)}
+			if p.Kind == claimURL {
+				urls = append(urls, f)
+			}
+			all = append(all, f)
+		}
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].start < all[j].start })
+
+	seen := make(map[string]bool)
+	var claims []claim
+	for _, f := range all {
+		inURL := false
+		for _, u := range urls {
+			if f.Kind != claimURL && f.start >= u.start && f.start < u.end {
+				inURL = true
+				break
+			}
+		}
+		// A code span holding a call, `f()`, is the call's claim.
+		f.Text = strings.TrimSuffix(f.Text, "()")
+		if inURL || f.Text == "" || seen[f.Text] {
+			continue
+		}
+		seen[f.Text] = true
+		claims = append(claims, f.claim)
+	}
+	return claims
+}
+
+// supported reports whether the model's input supports c. Issue numbers and URLs must appear
+// exactly: an issue as #N, GH-N or a /N URL segment. Paths and identifiers need only appear
+// as a substring, or by their last component, such as the method of "client.Retry".
+func (c claim) supported(input string) bool {
+	switch c.Kind {
+	case claimIssue:
+		number := strings.TrimLeft(strings.TrimPrefix(c.Text, "GH-"), "#")
+		return regexp.MustCompile(`(?:#|GH-|/)` + number + `\b`).MatchString(input)
+	case claimURL:
+		return strings.Contains(input, c.Text)
+	}
+	if strings.Contains(input, c.Text) {
+		return true
+	}
+	separator := "."
+	if c.Kind == claimPath {
+		separator = "/"
+	}
+	if i := strings.LastIndex(c.Text, separator); i >= 0 && len(c.Text)-i > 2 {
+		return strings.Contains(input, c.Text[i+1:])
+	}
+	return false
+}
+
+// findUnsupportedClaims returns the claims of summary that input does not support.
+func findUnsupportedClaims(summary, input string) []string {
+	var unsupported []string
+	for _, c := range extractClaims(summary) {
+		if !c.supported(input) {
+			unsupported = append(unsupported, c.Text)
+		}
+	}
+	return unsupported
+}
+
+// markClaims follows every occurrence of each claim in summary with claimMarker, after the
+// closing backtick of a code span. Occurrences within a longer word or number, such as #48
+// within #482, are left alone.
+func markClaims(summary string, claims []string) string {
+	sorted := append([]string{}, claims...)
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	marked := make([]bool, len(summary)+1) // Positions after which a marker goes.
+	for _, text := range sorted {
+		for from := 0; ; {
+			i := strings.Index(summary[from:], text)
+			if i < 0 {
+				break
+			}
+			end := from + i + len(text)
+			from = end
+			if end < len(summary) && isWordByte(summary[end]) {
+				continue
+			}
+			if strings.HasPrefix(summary[end:], "()") {
+				end += 2
+			}
+			if end < len(summary) && summary[end] == '`' {
+				end++
+			}
+			marked[end] = true
+		}
+	}
+	var sb strings.Builder
+	for i := 0; i <= len(summary); i++ {
+		if marked[i] {
+			sb.WriteString(" " + claimMarker)
+		}
+		if i < len(summary) {
+			sb.WriteByte(summary[i])
+		}
+	}
+	return sb.String()
+}
+
+// isWordByte reports whether b can continue an identifier or number.
+func isWordByte(b byte) bool {
+	return b == '_' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
+
+// checkClaims implements -check-claims: a summary making claims its input does not support
+// is regenerated once with claimRetryInstruction and, if unsupported claims remain, they are
+// marked in the summary and returned. input is the prompt the summary was generated from,
+// which holds the patch, the commit message and the injected context as the model saw them.
+func checkClaims(commitHash, summary, input string, regenerate func(instruction string) (generation, error)) (string, tokenUsage, []string, error) {
+	unsupported := findUnsupportedClaims(summary, input)
+	if len(unsupported) == 0 {
+		return summary, tokenUsage{}, nil, nil
+	}
+	fmt.Printf("Commit %s: summary mentions %s, found nowhere in its input; regenerating once.\n", commitHash, strings.Join(unsupported, ", "))
+	regenerated, err := regenerate(fmt.Sprintf(claimRetryInstruction, strings.Join(unsupported, ", ")))
+	if err != nil {
+		return summary, tokenUsage{}, nil, fmt.Errorf("failed to regenerate a summary making unsupported claims: %w", err)
+	}
+	summary = regenerated.Text
+	unsupported = findUnsupportedClaims(summary, input)
+	if len(unsupported) == 0 {
+		return summary, regenerated.Usage, nil, nil
+	}
+	fmt.Printf("Warning: commit %s: regenerated summary still mentions %s, found nowhere in its input; marking them %s.\n", commitHash, strings.Join(unsupported, ", "), claimMarker)
+	return markClaims(summary, unsupported), regenerated.Usage, unsupported, nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExtractClaims(t *testing.T) {
+	for _, tc := range []struct {
+		summary string
+		want    []claim
+	}{
+		{
+			"Fixes issue #482 by retrying in `retryLater` (see GH-17).",
+			[]claim{{"#482", claimIssue}, {"retryLater", claimIdentifier}, {"GH-17", claimIssue}},
+		},
+		{
+			"Follows https://example.com/docs/retry#backoff, as described in RFC 7231.",
+			[]claim{{"https://example.com/docs/retry#backoff", claimURL}},
+		},
+		{
+			"Moves the parser from internal/parse/lexer.go to pkg/lex.go and updates go.mod.",
+			[]claim{{"internal/parse/lexer.go", claimPath}, {"pkg/lex.go", claimPath}, {"go.mod", claimPath}},
+		},
+		{
+			"Calls client.Retry() and sets max_retries; `parseConfig()` now returns an error.",
+			[]claim{{"client.Retry", claimIdentifier}, {"max_retries", claimIdentifier}, {"parseConfig", claimIdentifier}},
+		},
+		{
+			// A repeated claim is listed once.
+			"Closes #9. Before #9 the cache leaked.",
+			[]claim{{"#9", claimIssue}},
+		},
+	} {
+		if got := extractClaims(tc.summary); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("extractClaims(%q) = %+v, want %+v", tc.summary, got, tc.want)
+		}
+	}
+
+	// Benign generic phrases are not claims.
+	for _, summary := range []string{
+		"Updates the retry logic and/or the timeout handling for the client.",
+		"Changes one or more file(s) in the test suite, e.g. the fixtures, i.e. the data.",
+		"Improves error handling (see the commit message) and input/output buffering.",
+		"Refactors the HTTP client to use a shared transport; no behavior change.",
+		"Bumps the version to 1.2.3 and rewrites the README in plain English.",
+		"The change is 100% backwards compatible & reduces latency by 3x.",
+		"Uses the CamelCase convention for the new types in this package.",
+		"Handles the case where the value is nil (previously it would panic).",
+	} {
+		if got := extractClaims(summary); len(got) != 0 {
+			t.Errorf("extractClaims(%q) = %+v, want none", summary, got)
+		}
+	}
+}
+
+func TestClaimSupported(t *testing.T) {
+	input := "Fix the retry loop (#48)\n\nSee https://example.com/issues/77 for context.\n\n" +
+		"diff --git a/internal/retry/client.go b/internal/retry/client.go\n" +
+		"+func (c *Client) Retry(ctx context.Context) error {\n+\tmaxRetries := 3\n"
+	for _, tc := range []struct {
+		claim claim
+		want  bool
+	}{
+		{claim{"#48", claimIssue}, true},
+		{claim{"GH-48", claimIssue}, true},
+		{claim{"#77", claimIssue}, true},
+		// An issue number must appear exactly, not as a prefix of a longer number.
+		{claim{"#4", claimIssue}, false},
+		{claim{"#482", claimIssue}, false},
+		// Nor as a bare number in the patch.
+		{claim{"#3", claimIssue}, false},
+		{claim{"https://example.com/issues/77", claimURL}, true},
+		{claim{"https://example.com/issues", claimURL}, true},
+		{claim{"https://example.com/issues/78", claimURL}, false},
+		{claim{"internal/retry/client.go", claimPath}, true},
+		// Paths are checked by their file name too, so a shortened directory is not flagged.
+		{claim{"retry/client.go", claimPath}, true},
+		{claim{"pkg/client.go", claimPath}, true},
+		{claim{"internal/retry/server.go", claimPath}, false},
+		{claim{"Retry", claimIdentifier}, true},
+		{claim{"client.Retry", claimIdentifier}, true},
+		{claim{"maxRetries", claimIdentifier}, true},
+		{claim{"max_retries", claimIdentifier}, false},
+		{claim{"client.Backoff", claimIdentifier}, false},
+	} {
+		if got := tc.claim.supported(input); got != tc.want {
+			t.Errorf("%+v supported = %v, want %v", tc.claim, got, tc.want)
+		}
+	}
+}
+
+func TestFindUnsupportedClaims(t *testing.T) {
+	input := "Retry on timeouts\n\ndiff --git a/retry.go b/retry.go\n+func retryLater() {}\n"
+	got := findUnsupportedClaims("Adds `retryLater` to retry.go, fixing issue #482 reported at https://bugs.example.com/482.", input)
+	if want := []string{"#482", "https://bugs.example.com/482"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("findUnsupportedClaims = %q, want %q", got, want)
+	}
+	if got := findUnsupportedClaims("Adds a retry on timeouts to retry.go and/or its callers.", input); got != nil {
+		t.Errorf("a supported summary: %q", got)
+	}
+}
+
+func TestMarkClaims(t *testing.T) {
+	for _, tc := range []struct {
+		summary string
+		claims  []string
+		want    string
+	}{
+		{"Fixes #482.", []string{"#482"}, "Fixes #482 [unverified]."},
+		// #48 within #482 is not an occurrence of #48.
+		{"Fixes #482 and #48.", []string{"#48"}, "Fixes #482 and #48 [unverified]."},
+		{"Fixes #482 and #48.", []string{"#48", "#482"}, "Fixes #482 [unverified] and #48 [unverified]."},
+		// The marker follows a call's parentheses and a code span's closing backtick.
+		{"Calls `parseConfig()` and retryLater() twice.", []string{"parseConfig", "retryLater"}, "Calls `parseConfig()` [unverified] and retryLater() [unverified] twice."},
+		{"See pkg/lex.go; pkg/lex.go moved.", []string{"pkg/lex.go"}, "See pkg/lex.go [unverified]; pkg/lex.go [unverified] moved."},
+		{"Nothing to mark.", nil, "Nothing to mark."},
+	} {
+		if got := markClaims(tc.summary, tc.claims); got != tc.want {
+			t.Errorf("markClaims(%q, %q) = %q, want %q", tc.summary, tc.claims, got, tc.want)
+		}
+	}
+}
+
+func TestCheckClaims(t *testing.T) {
+	input := "Retry on timeouts\n\n+func retryLater() {}\n"
+	fail := func(string) (generation, error) {
+		t.Fatal("a supported summary was regenerated")
+		return generation{}, nil
+	}
+	summary, usage, unverified, err := checkClaims("abc", "Adds `retryLater`.", input, fail)
+	if err != nil || summary != "Adds `retryLater`." || usage != (tokenUsage{}) || unverified != nil {
+		t.Errorf("supported summary: %q, %+v, %q, %v", summary, usage, unverified, err)
+	}
+
+	var instructions []string
+	regenerate := func(text string) func(string) (generation, error) {
+		return func(instruction string) (generation, error) {
+			instructions = append(instructions, instruction)
+			return generation{Text: text, Usage: tokenUsage{PromptTokens: 10, OutputTokens: 2}}, nil
+		}
+	}
+	summary, usage, unverified, err = checkClaims("abc", "Fixes #482 in `retryLater`.", input, regenerate("Adds `retryLater`."))
+	if err != nil || summary != "Adds `retryLater`." || usage.PromptTokens != 10 || unverified != nil {
+		t.Errorf("regenerated summary: %q, %+v, %q, %v", summary, usage, unverified, err)
+	}
+	if len(instructions) != 1 || !strings.Contains(instructions[0], ": #482. Describe the change again") {
+		t.Errorf("instructions %q", instructions)
+	}
+
+	summary, _, unverified, err = checkClaims("abc", "Fixes #482.", input, regenerate("Fixes #483 via `backoff()`."))
+	if err != nil || summary != "Fixes #483 [unverified] via `backoff()` [unverified]." || !reflect.DeepEqual(unverified, []string{"#483", "backoff"}) {
+		t.Errorf("still unsupported: %q, %q, %v", summary, unverified, err)
+	}
+
+	failed := errors.New("model unavailable")
+	summary, _, _, err = checkClaims("abc", "Fixes #482.", input, func(string) (generation, error) { return generation{}, failed })
+	if !errors.Is(err, failed) || summary != "Fixes #482." {
+		t.Errorf("failed regeneration: %q, %v", summary, err)
+	}
+}
+
+func TestCheckClaimsRun(t *testing.T) {
+	repo := newFixtureRepo(t)
+	fixed := repo.commit("Retry on timeouts", map[string]string{"retry.go": "package retry\n\nfunc retryLater() {}\n"})
+	stubborn := repo.commit("Tidy up", map[string]string{"tidy.go": "package retry\n"})
+	env := newAuditEnv(t)
+	env.Ollama.Respond = func(n int, prompt string) (int, string) {
+		switch {
+		case strings.Contains(prompt, "Retry on timeouts") && strings.Contains(prompt, "Describe the change again"):
+			return 200, `{"response":"Adds retryLater() for timeouts.","done":true}`
+		case strings.Contains(prompt, "Retry on timeouts"):
+			return 200, `{"response":"Adds retryLater() and fixes issue #482.","done":true}`
+		case strings.Contains(prompt, "Tidy up"):
+			return 200, `{"response":"Tidies up per https://wiki.example.com/style.","done":true}`
+		}
+		return 0, ""
+	}
+	report := filepath.Join(env.Work, "report.txt")
+	out := env.mustRun("-repo", repo.Dir, "-commit", fixed, "-output", report, "-check-claims")
+	if !strings.Contains(out, "Commit "+fixed+": summary mentions #482, found nowhere in its input; regenerating once.\n") ||
+		!strings.Contains(out, "1 summaries still made unsupported claims after regenerating and were marked [unverified].\n") {
+		t.Errorf("output:\n%s", out)
+	}
+	content := readFile(t, report)
+	for _, want := range []string{
+		"Adds retryLater() for timeouts.\n",
+		"Tidies up per https://wiki.example.com/style [unverified].\n",
+		"Unverified claims: https://wiki.example.com/style (marked [unverified] in the summary; not found in the patch, message or context)\n",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("report lacks %q:\n%s", want, content)
+		}
+	}
+	if strings.Contains(content, "#482") || strings.Count(content, "Unverified claims: ") != 1 {
+		t.Errorf("report:\n%s", content)
+	}
+	if _, code := env.run("-repo", repo.Dir, "-commit", stubborn, "-output", report, "-force", "-check-claims", "-fail-on", "unverified_claim_entries > 0"); code != exitFailOn {
+		t.Errorf("-fail-on unverified_claim_entries: exit %d", code)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Values of CommitAuditData.DateSuspect.
+const (
+	dateSuspectFuture     = "future"
+	dateSuspectBeforeRoot = "before_root"
+)
+
+// dateSkewTolerance is how far in the future a date may lie before it is suspect, so that a
+// commit made on a machine whose clock is a little ahead is not flagged.
+const dateSkewTolerance = 24 * time.Hour
+
+// dateCheck flags entry dates that cannot be right: later than the start of the run, or
+// earlier than the root commit of the audited history. Such dates come from machines with
+// a wrong clock, and would put their entries in the wrong -split-by file.
+type dateCheck struct {
+	Now time.Time
+	// Root is the earlier of the root commit's author and commit dates.
+	Root     time.Time
+	RootHash string
+}
+
+// newDateCheck reads the dates of the root commit of tip's history.
+func newDateCheck(repoPath, tip string) (*dateCheck, error) {
+	root, err := getRootCommit(repoPath, tip)
+	if err != nil {
+		return nil, err
+	}
+	output, err := gitRun(context.Background(), repoPath, "show", "--no-patch", "--format=%at %ct", root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the dates of root commit %s: %w", root, err)
+	}
+	fields := strings.Fields(string(output))
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("unexpected dates %q for root commit %s", output, root)
+	}
+	check := &dateCheck{Now: time.Now(), RootHash: root}
+	for _, field := range fields {
+		seconds, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected date %q for root commit %s", field, root)
+		}
+		if t := time.Unix(seconds, 0); check.Root.IsZero() || t.Before(check.Root) {
+			check.Root = t
+		}
+	}
+	return check, nil
+}
+
+// classify returns why date is suspect, or "" when it is plausible or cannot be parsed.
+func (c *dateCheck) classify(date string) string {
+	if c == nil {
+		return ""
+	}
+	t, err := time.Parse(entryDateLayout, strings.TrimSpace(date))
+	if err != nil {
+		return ""
+	}
+	switch {
+	case t.After(c.Now.Add(dateSkewTolerance)):
+		return dateSuspectFuture
+	case t.Before(c.Root):
+		return dateSuspectBeforeRoot
+	}
+	return ""
+}
+
+// describeDateSuspect renders a DateSuspect value in the locale of the report.
+func describeDateSuspect(reason string) string {
+	if reason == dateSuspectBeforeRoot {
+		return msg("date.before_root")
+	}
+	return msg("date.future")
+}
+
+// formatSuspectDatesSection lists the entries whose date is suspect, with both their dates,
+// or returns "" when there are none.
+func formatSuspectDatesSection(entries []CommitAuditData) string {
+	var sb strings.Builder
+	count := 0
+	for _, entry := range entries {
+		if entry.DateSuspect == "" {
+			continue
+		}
+		count++
+		fmt.Fprintf(&sb, "%s %s\n", shortHash(entry.Hash), msg("section.suspect_dates_line", localDate(entry.AuthorDate), localDate(entry.CommitDate), describeDateSuspect(entry.DateSuspect)))
+	}
+	if count == 0 {
+		return ""
+	}
+	return msg("section.suspect_dates", msgCount("count.commits", count)) + "\n" + sb.String()
+}
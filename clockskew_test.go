@@ -0,0 +1,147 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDateCheckClassify(t *testing.T) {
+	check := &dateCheck{
+		Now:  time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC),
+		Root: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	for date, want := range map[string]string{
+		"2024-05-31 09:00:00 +0200": "",
+		"2020-01-01 00:00:00 +0000": "",
+		// A clock a little ahead is tolerated for a day.
+		"2024-06-02 11:00:00 +0000": "",
+		"2024-06-02 13:00:00 +0000": dateSuspectFuture,
+		"2099-01-01 00:00:00 +0000": dateSuspectFuture,
+		"2019-12-31 23:59:59 +0000": dateSuspectBeforeRoot,
+		"2020-01-01 00:30:00 +0100": dateSuspectBeforeRoot,
+		"1970-01-01 00:00:00 +0000": dateSuspectBeforeRoot,
+		"":                          "",
+		"not a date":                "",
+	} {
+		if got := check.classify(date); got != want {
+			t.Errorf("classify(%q) = %q, want %q", date, got, want)
+		}
+	}
+	var none *dateCheck
+	if got := none.classify("1970-01-01 00:00:00 +0000"); got != "" {
+		t.Errorf("a nil check classified a date as %q", got)
+	}
+}
+
+func TestNewDateCheck(t *testing.T) {
+	repo := newFixtureRepo(t)
+	// The root commit was committed before it was authored; the earlier date counts.
+	root := repo.commitEnv([]string{"GIT_AUTHOR_DATE=2024-01-01T12:00:00Z", "GIT_COMMITTER_DATE=2023-12-31T08:00:00Z"}, "Initial", map[string]string{"README": "app\n"})
+	repo.commits(2)
+	check, err := newDateCheck(repo.Dir, "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if check.RootHash != root || !check.Root.Equal(time.Date(2023, 12, 31, 8, 0, 0, 0, time.UTC)) || time.Since(check.Now) > time.Minute {
+		t.Errorf("dateCheck %+v", check)
+	}
+	if _, err := newDateCheck(repo.Dir, "no-such-ref"); err == nil {
+		t.Error("a check of an unknown tip succeeded")
+	}
+}
+
+func TestShardReportSuspectDates(t *testing.T) {
+	entries := []CommitAuditData{
+		{Hash: "e5", Date: "2099-03-01 10:00:00 +0000", DateSuspect: dateSuspectFuture},
+		{Hash: "e4", Date: "2024-02-03 10:00:00 +0000"},
+		{Hash: "e3", Date: "1970-01-01 00:00:00 +0000", DateSuspect: dateSuspectBeforeRoot},
+		{Hash: "e2", Date: "2024-01-20 10:00:00 +0000"},
+		{Hash: "e1", Date: "2024-01-02 10:00:00 +0000"},
+	}
+	shards := shardReport(entries, splitPolicy{Mode: splitByMonth}, "gitaudit.txt")
+	got := make(map[string][]string)
+	var labels []string
+	for _, shard := range shards {
+		labels = append(labels, shard.Label)
+		for _, entry := range shard.Entries {
+			got[shard.Label] = append(got[shard.Label], entry.Hash)
+		}
+	}
+	if strings.Join(labels, " ") != "undated 2024-02 2024-01" || strings.Join(got["undated"], " ") != "e5 e3" || strings.Join(got["2024-01"], " ") != "e2 e1" {
+		t.Errorf("shards %q: %v", labels, got)
+	}
+	// Splitting by count ignores dates altogether.
+	if shards := shardReport(entries, splitPolicy{Mode: splitByCount, Count: 5}, "gitaudit.txt"); len(shards) != 1 || shards[0].Label != "part-001" {
+		t.Errorf("count shards %+v", shards)
+	}
+}
+
+func TestFormatSuspectDatesSection(t *testing.T) {
+	if got := formatSuspectDatesSection([]CommitAuditData{{Hash: "0123456789"}}); got != "" {
+		t.Errorf("section without suspect dates: %q", got)
+	}
+	entries := []CommitAuditData{
+		{Hash: "0123456789", AuthorDate: "2099-03-01 10:00:00 +0000", CommitDate: "2024-01-02 10:00:00 +0000", DateSuspect: dateSuspectFuture},
+		{Hash: "abcdef0123"},
+		{Hash: "fedcba9876", AuthorDate: "1970-01-01 00:00:00 +0000", CommitDate: "1970-01-01 00:00:00 +0000", DateSuspect: dateSuspectBeforeRoot},
+	}
+	want := "=== Suspect dates (2 commits) ===\n" +
+		"01234567 author date 2099-03-01 10:00:00 +0000, commit date 2024-01-02 10:00:00 +0000: in the future\n" +
+		"fedcba98 author date 1970-01-01 00:00:00 +0000, commit date 1970-01-01 00:00:00 +0000: before the root commit\n"
+	if got := formatSuspectDatesSection(entries); got != want {
+		t.Errorf("section:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestSuspectDatesRun(t *testing.T) {
+	repo := newFixtureRepo(t)
+	repo.commits(2)
+	future := repo.commitEnv([]string{"GIT_AUTHOR_DATE=2099-03-01T10:00:00Z"}, "Bad CI clock", map[string]string{"src/ci.go": "package src\n"})
+	epoch := repo.commitEnv([]string{"GIT_AUTHOR_DATE=1970-01-01T00:00:00Z"}, "Dead battery", map[string]string{"src/epoch.go": "package src\n"})
+	repo.commits(1)
+	env := newAuditEnv(t)
+
+	report := filepath.Join(env.Work, "audit.txt")
+	out := env.mustRun("-repo", repo.Dir, "-commit", "root", "-output", report, "-split-by", "month")
+	if !strings.Contains(out, "Warning: 2 commits are dated in the future or before the root commit; see the report's Suspect dates section.") {
+		t.Errorf("output:\n%s", out)
+	}
+	undated := readFile(t, filepath.Join(env.Work, "audit-undated.txt"))
+	if strings.Count(undated, "Commit: ") != 2 || !strings.Contains(undated, "Commit: "+future) || !strings.Contains(undated, "Commit: "+epoch) {
+		t.Errorf("undated file:\n%s", undated)
+	}
+	// The entries keep their raw dates.
+	for _, want := range []string{"Date warning: in the future\n", "Date warning: before the root commit\n", "2099-03-01 10:00:00 +0000", "1970-01-01 00:00:00 +0000"} {
+		if !strings.Contains(undated, want) {
+			t.Errorf("undated file lacks %q:\n%s", want, undated)
+		}
+	}
+	if month := readFile(t, filepath.Join(env.Work, "audit-2024-01.txt")); strings.Count(month, "Commit: ") != 3 || strings.Contains(month, "Date warning") {
+		t.Errorf("month file:\n%s", month)
+	}
+	for _, path := range []string{"audit-2099-03.txt", "audit-1970-01.txt"} {
+		if _, err := os.Stat(filepath.Join(env.Work, path)); err == nil {
+			t.Errorf("%s was written", path)
+		}
+	}
+
+	// Unsplit, the section lists both commits with both their dates.
+	env.mustRun("-repo", repo.Dir, "-commit", "root", "-output", report, "-force")
+	content := readFile(t, report)
+	for _, want := range []string{
+		"=== Suspect dates (2 commits) ===\n",
+		shortHash(future) + " author date 2099-03-01 10:00:00 +0000, commit date 2024-01-01 14:00:00 +0000: in the future\n",
+		shortHash(epoch) + " author date 1970-01-01 00:00:00 +0000, commit date 2024-01-01 15:00:00 +0000: before the root commit\n",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("report lacks %q:\n%s", want, content)
+		}
+	}
+
+	if out, code := env.run("-repo", repo.Dir, "-commit", "root", "-output", report, "-force", "-fail-on", "date_suspect = future"); code != exitFailOn || !strings.Contains(out, "  "+future+"\n") {
+		t.Errorf("-fail-on date_suspect: exit %d\n%s", code, out)
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Kinds of positional arguments, which decide how the shell completes them.
+const (
+	argRevision = "revision"
+	argFile     = "file"
+	argProfile  = "profile"
+	argShell    = "shell"
+)
+
+// commandExample is an example invocation shown in the man page.
+type commandExample struct {
+	Command     string
+	Description string
+}
+
+// commandSpec describes a gitaudit command. The registry of specs is the single source of
+// the usage text, the flag interaction rules, the shell completions and the man page.
+type commandSpec struct {
+	// Name is the command as typed after gitaudit, e.g. "cache gc"; "" is the range audit.
+	Name string
+	// Synopsis lists the forms of the command's arguments, one per usage line.
+	Synopsis []string
+	Summary  string
+	// Flags defines the command's flags on fs, with the same functions the command uses.
+	Flags func(fs *flag.FlagSet)
+	// Rules are the interactions among the flags, checked by validateFlags.
+	Rules []flagRule
+	// Args is the kind of the positional arguments.
+	Args     string
+	Examples []commandExample
+}
+
+// commandRegistry lists every command, the range audit first.
+var commandRegistry = []commandSpec{
+	{
+		Synopsis: []string{"-commit <commit> [flags]", "-stashes [flags]", "-reflog <ref> [flags]", "-url <compare-url> [flags]"},
+		Summary:  "Audit the commits from HEAD down to -commit, inclusive, and write a model-generated summary of each to gitaudit.txt",
+		Flags: func(fs *flag.FlagSet) {
+			registerRangeFlags(fs)
+			registerPromptFlags(fs)
+		},
+		Rules: append(rangeFlagRules, promptFlagRules...),
+		Examples: []commandExample{
+			{"gitaudit -repo ~/src/billing -commit v1.4.0", "Audit the commits of ~/src/billing from HEAD down to the tag v1.4.0."},
+			{"gitaudit -since 2026-01-01 -until 2026-03-31 -controls", "Audit the first quarter of 2026 and map each commit to audit control categories."},
+			{"gitaudit -profile monthly-compliance", "Run with the settings of a profile from the config file."},
+			{"gitaudit -commit root -shard 2/3", "Audit the second of three shards of the whole history, for gitaudit merge-shards."},
+			{"gitaudit -url https://github.com/org/repo/compare/v1.2.0...main", "Audit the commits of main since v1.2.0, as the compare page lists them, in a clone of org/repo."},
+		},
+	},
+	{
+		Name:     "explain",
+		Synopsis: []string{"[flags] <commit>"},
+		Summary:  "Audit one commit and print its entry and diffstat to the terminal instead of writing a report",
+		Flags: func(fs *flag.FlagSet) {
+			registerExplainFlags(fs)
+			registerPromptFlags(fs)
+		},
+		Rules: promptFlagRules,
+		Args:  argRevision,
+		Examples: []commandExample{
+			{"gitaudit explain -full HEAD~1", "Summarize the parent of HEAD and print its diff."},
+		},
+	},
+	{
+		Name:     "file",
+		Synopsis: []string{"[flags] <path>"},
+		Summary:  "Audit the latest commits touching one file, following renames, and write the story of the file with its entries to gitaudit-file.md",
+		Flags: func(fs *flag.FlagSet) {
+			registerFileFlags(fs)
+			registerPromptFlags(fs)
+		},
+		Rules: append([]flagRule{
+			{Set: []string{"force"}, Unset: []string{"output"}, Message: "-force has no effect without -output; the default document is always replaced"},
+		}, promptFlagRules...),
+		Args: argFile,
+		Examples: []commandExample{
+			{"gitaudit file src/auth/session.go -n 30", "Tell the story of session.go over its 30 latest commits, including those made under earlier names."},
+		},
+	},
+	{
+		Name:     "patch",
+		Synopsis: []string{"[flags] < file.patch"},
+		Summary:  "Summarize the patches read from stdin, e.g. git format-patch output, and print the entries",
+		Flags: func(fs *flag.FlagSet) {
+			registerPatchFlags(fs)
+			registerPromptFlags(fs)
+		},
+		Rules: append(patchFlagRules, promptFlagRules...),
+		Examples: []commandExample{
+			{"git format-patch --stdout v1.0.. | gitaudit patch", "Summarize every commit since v1.0 without writing a report."},
+		},
+	},
+	{
+		Name:     "eval",
+		Synopsis: []string{"[-dir testdata/eval] [-judge-model model] [-update-baseline]"},
+		Summary:  "Score the summaries of the eval fixture patches against a rubric and compare them with the baseline",
+		Flags: func(fs *flag.FlagSet) {
+			registerEvalFlags(fs)
+			registerPromptFlags(fs)
+		},
+		Rules: append(evalFlagRules, promptFlagRules...),
+		Examples: []commandExample{
+			{"gitaudit eval -judge-model claude-sonnet-4-5", "Score the current prompt with a second model as the judge."},
+			{"gitaudit eval -replay testdata/eval/recordings -no-judge", "Run the fixtures through the pipeline from recorded responses, as in CI."},
+		},
+	},
+	{
+		Name:     "merge-shards",
+		Synopsis: []string{"[flags] gitaudit-shard-1-of-N.json ..."},
+		Summary:  "Combine the manifests of a -shard audit into one report",
+		Flags:    func(fs *flag.FlagSet) { registerMergeShardsFlags(fs) },
+		Args:     argFile,
+		Examples: []commandExample{
+			{"gitaudit merge-shards -output gitaudit.txt gitaudit-shard-*.json", "Merge the shards of a range into one report."},
+		},
+	},
+	{
+		Name:     "verify",
+		Synopsis: []string{"[-output gitaudit.txt]"},
+		Summary:  "Check the retention trailer of a report: present, its digest matching the report and its range the header's",
+		Flags:    func(fs *flag.FlagSet) { registerVerifyFlags(fs) },
+		Examples: []commandExample{
+			{"gitaudit verify -output archive/2026-q1.txt", "Check that an archived report is complete and unchanged since it was written."},
+		},
+	},
+	{
+		Name:     "serve",
+		Synopsis: []string{"[-addr :8080] [-workdir dir] [-workers 1]"},
+		Summary:  "Serve an HTTP API to request audits of registered repositories and fetch their reports",
+		Flags:    func(fs *flag.FlagSet) { registerServeFlags(fs) },
+		Examples: []commandExample{
+			{"gitaudit serve -addr :8080 -workdir /var/lib/gitaudit", "Serve audits, keeping runs and reports under /var/lib/gitaudit."},
+		},
+	},
+	{
+		Name:    "cache stats",
+		Summary: "Print the entries, size and recent hit rate of the cache store",
+	},
+	{
+		Name:     "cache gc",
+		Synopsis: []string{"[-max-size 2GB] [-max-age 90d]"},
+		Summary:  "Evict entries from the cache store by age, then by size",
+		Flags:    func(fs *flag.FlagSet) { registerCacheGCFlags(fs) },
+	},
+	{
+		Name:    "profiles list",
+		Summary: "List the profiles of the config file with their own settings",
+	},
+	{
+		Name:     "profiles show",
+		Synopsis: []string{"<name>"},
+		Summary:  "Print the resolved settings of a profile and the profile each comes from",
+		Args:     argProfile,
+	},
+	{
+		Name:     "completion",
+		Synopsis: []string{"bash|zsh|fish"},
+		Summary:  "Print the shell completion script for bash, zsh or fish",
+		Args:     argShell,
+		Examples: []commandExample{
+			{"source <(gitaudit completion bash)", "Enable completions in the current bash session."},
+		},
+	},
+	{
+		Name:    "man",
+		Summary: "Print this manual page in roff format",
+		Examples: []commandExample{
+			{"gitaudit man > gitaudit.1", "Write the manual page, e.g. for /usr/local/share/man/man1."},
+		},
+	},
+}
+
+// lookupCommand returns the spec of the named command; it panics for a name the registry
+// does not have, which is a programming error.
+func lookupCommand(name string) *commandSpec {
+	for i := range commandRegistry {
+		if commandRegistry[i].Name == name {
+			return &commandRegistry[i]
+		}
+	}
+	panic("gitaudit: unregistered command " + name)
+}
+
+// flagSet returns a new FlagSet with the command's flags defined on it.
+func (c *commandSpec) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet(c.title(), flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	if c.Flags != nil {
+		c.Flags(fs)
+	}
+	return fs
+}
+
+// title is the command as typed, e.g. "gitaudit cache gc".
+func (c *commandSpec) title() string {
+	return strings.TrimSpace("gitaudit " + c.Name)
+}
+
+// newCommandFlagSet returns the FlagSet a subcommand parses its arguments with, with the
+// usage text of its spec. The subcommand defines its flags on it.
+func newCommandFlagSet(name string) *flag.FlagSet {
+	spec := lookupCommand(name)
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() { spec.printUsage(fs) }
+	return fs
+}
+
+// printUsage prints the synopsis and the flags of fs. The range audit also lists the
+// other commands.
+func (c *commandSpec) printUsage(fs *flag.FlagSet) {
+	w := fs.Output()
+	if c.Name != "" {
+		printSynopsis(w, c.Name)
+	} else {
+		var names []string
+		for _, spec := range commandRegistry {
+			names = append(names, spec.Name)
+		}
+		printSynopsis(w, names...)
+	}
+	fmt.Fprintf(w, "\n%s.\n", c.Summary)
+	if c.Name == "" {
+		fmt.Fprintln(w, "\nRun gitaudit <command> -h for the flags of a command, or gitaudit man for the manual.")
+	}
+	if c.Flags != nil {
+		fmt.Fprintln(w, "\nFlags:")
+		fs.PrintDefaults()
+	}
+}
+
+// printSynopsis prints the usage lines of the named commands.
+func printSynopsis(w io.Writer, names ...string) {
+	prefix := "Usage:"
+	for _, name := range names {
+		spec := lookupCommand(name)
+		forms := spec.Synopsis
+		if len(forms) == 0 {
+			forms = []string{""}
+		}
+		for _, form := range forms {
+			fmt.Fprintln(w, strings.TrimRight(fmt.Sprintf("%-6s %s %s", prefix, spec.title(), form), " "))
+			prefix = ""
+		}
+	}
+}
+
+// runCompletion implements `gitaudit completion bash|zsh|fish`.
+func runCompletion(args []string) {
+	if len(args) != 1 || completionScripts[args[0]] == "" {
+		printSynopsis(os.Stdout, "completion")
+		os.Exit(1)
+	}
+	fmt.Print(completionScripts[args[0]])
+}
+
+// runMan implements `gitaudit man`.
+func runMan(args []string) {
+	if len(args) > 0 {
+		printSynopsis(os.Stdout, "man")
+		os.Exit(1)
+	}
+	fmt.Print(manPage())
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// compareURLShapes lists the URLs -url accepts, for its error messages.
+const compareURLShapes = `  https://<host>/<owner>/<repo>/compare/<base>...<head>
+  https://<host>/<owner>/<repo>/commit/<sha>
+  https://<host>/<owner>/<repo>/pull/<n>/commits/<sha>
+  https://<host>/<group>/<project>/-/compare/<base>...<head>
+  https://<host>/<group>/<project>/-/compare?from=<base>&to=<head>
+  https://<host>/<group>/<project>/-/commit/<sha>`
+
+// commitSHA matches the commit ids that commit URLs carry, abbreviated or full.
+var commitSHA = regexp.MustCompile(`^[0-9a-fA-F]{7,64}$`)
+
+// compareTarget is what a GitHub or GitLab compare or commit URL names: a project on a host
+// and either a range, base...head, or a single commit, Head with an empty Base.
+type compareTarget struct {
+	URL     string
+	Host    string
+	Project string // e.g. "org/repo" or "group/subgroup/project"
+	Base    string
+	Head    string
+}
+
+func (t *compareTarget) String() string {
+	if t.Base == "" {
+		return fmt.Sprintf("commit %s of %s/%s", t.Head, t.Host, t.Project)
+	}
+	return fmt.Sprintf("%s...%s of %s/%s", t.Base, t.Head, t.Host, t.Project)
+}
+
+// parseCompareURL parses a GitHub or GitLab compare or commit URL, on any host so that
+// enterprise installations work; the shape of the path tells them apart. Fragments such as
+// #diff-... and a .diff or .patch suffix are ignored.
+func parseCompareURL(raw string) (*compareTarget, error) {
+	unsupported := fmt.Errorf("unsupported URL %q: expected one of\n%s", raw, compareURLShapes)
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Hostname() == "" {
+		return nil, unsupported
+	}
+	path := strings.Trim(u.Path, "/")
+	for _, suffix := range []string{".diff", ".patch"} {
+		path = strings.TrimSuffix(path, suffix)
+	}
+	target := &compareTarget{URL: raw, Host: strings.ToLower(u.Hostname())}
+
+	var project, kind, rest string
+	if before, after, ok := strings.Cut(path, "/-/"); ok {
+		// GitLab: the project path, which may have subgroups, ends at "/-/".
+		project = before
+		kind, rest, _ = strings.Cut(after, "/")
+	} else {
+		// GitHub, and GitLab URLs from before "/-/": owner and repository, then the route.
+		segments := strings.Split(path, "/")
+		i := 2
+		for i < len(segments) && segments[i] != "compare" && segments[i] != "commit" && segments[i] != "pull" {
+			i++
+		}
+		if i >= len(segments) {
+			return nil, unsupported
+		}
+		project, kind, rest = strings.Join(segments[:i], "/"), segments[i], strings.Join(segments[i+1:], "/")
+	}
+	if strings.Count(project, "/") < 1 {
+		return nil, unsupported
+	}
+	target.Project = strings.TrimSuffix(project, ".git")
+
+	switch kind {
+	case "compare":
+		spec := rest
+		if spec == "" {
+			// GitLab's compare form, /-/compare?from=<base>&to=<head>.
+			if from, to := u.Query().Get("from"), u.Query().Get("to"); from != "" && to != "" {
+				spec = from + "..." + to
+			}
+		}
+		base, head, ok := strings.Cut(spec, "...")
+		if !ok {
+			base, head, ok = strings.Cut(spec, "..")
+		}
+		if !ok || base == "" || head == "" {
+			return nil, fmt.Errorf("URL %q names no <base>...<head> range: expected one of\n%s", raw, compareURLShapes)
+		}
+		if strings.Contains(base, ":") || strings.Contains(head, ":") {
+			return nil, fmt.Errorf("compare URL %q compares across forks, which is not supported; compare two refs of one repository", raw)
+		}
+		target.Base, target.Head = base, head
+	case "commit":
+		target.Head, _, _ = strings.Cut(rest, "/")
+	case "pull":
+		// GitHub's view of one commit of a pull request, /pull/<n>/commits/<sha>.
+		number, after, _ := strings.Cut(rest, "/")
+		sha, ok := strings.CutPrefix(after, "commits/")
+		if number == "" || !ok {
+			return nil, unsupported
+		}
+		target.Head = sha
+	default:
+		return nil, unsupported
+	}
+	if target.Base == "" && !commitSHA.MatchString(target.Head) {
+		return nil, fmt.Errorf("commit URL %q does not end in a commit id", raw)
+	}
+	return target, nil
+}
+
+// findRemote returns the name of the remote of repoPath that fetches from the target's
+// project, comparing hosts and paths so that https, ssh and scp-style remote URLs all match.
+func (t *compareTarget) findRemote(repoPath string) (string, error) {
+	// git config exits with status 1 when no remote is configured; that is reported below.
+	output, _ := gitRun(context.Background(), repoPath, "config", "--get-regexp", `^remote\..*\.url$`)
+	want := strings.ToLower(t.Host + "/" + t.Project)
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		key, value, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		if remoteLocation(value) == want {
+			return strings.TrimSuffix(strings.TrimPrefix(key, "remote."), ".url"), nil
+		}
+	}
+	return "", fmt.Errorf("repository %s has no remote for %s/%s; clone it with `git clone https://%s/%s` and pass the clone with -repo", repoPath, t.Host, t.Project, t.Host, t.Project)
+}
+
+// remoteLocation reduces a remote URL to "host/path" in lower case, without user, port or
+// .git suffix, or "" for a local path.
+func remoteLocation(remote string) string {
+	var host, path string
+	if u, err := url.Parse(remote); err == nil && strings.Contains(remote, "://") {
+		if u.Host == "" {
+			return "" // file:///path
+		}
+		host, path = u.Hostname(), u.Path
+	} else if before, after, ok := strings.Cut(remote, ":"); ok && !strings.Contains(before, "/") {
+		// scp-like syntax, [user@]host:path.
+		_, host, _ = strings.Cut(before, "@")
+		if host == "" {
+			host = before
+		}
+		path = after
+	} else {
+		return ""
+	}
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+	return strings.ToLower(host + "/" + path)
+}
+
+// resolve resolves the target's refs in repoPath and lists the commits to audit, newest
+// first: the commits of head not reachable from base, as the compare page shows them, or the
+// single commit. Branch names resolve to the remote-tracking branch of the project's remote
+// when there is one, since the URL names the branch as the host has it.
+func (t *compareTarget) resolve(repoPath string, firstParent bool) (string, []string, error) {
+	remote, err := t.findRemote(repoPath)
+	if err != nil {
+		return "", nil, err
+	}
+	head, err := resolveRemoteRef(repoPath, remote, t.Head)
+	if err != nil {
+		return "", nil, err
+	}
+	if t.Base == "" {
+		return head, []string{head}, nil
+	}
+	base, err := resolveRemoteRef(repoPath, remote, t.Base)
+	if err != nil {
+		return "", nil, err
+	}
+	args := []string{"rev-list", head, "^" + base}
+	if firstParent {
+		args = append(args, "--first-parent")
+	}
+	output, err := gitRun(context.Background(), repoPath, args...)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to execute git rev-list %s..%s: %w", t.Base, t.Head, err)
+	}
+	hashes := strings.Fields(string(output))
+	if len(hashes) == 0 {
+		return "", nil, fmt.Errorf("%s contains no commits; is %s already merged into %s, or is the clone out of date?", t, t.Head, t.Base)
+	}
+	return head, hashes, nil
+}
+
+// resolveRemoteRef resolves ref as the remote-tracking branch remote/ref when it exists, and
+// as a local commit-ish otherwise.
+func resolveRemoteRef(repoPath, remote, ref string) (string, error) {
+	if output, err := gitRun(context.Background(), repoPath, "rev-parse", "--verify", "--quiet", "--end-of-options", "refs/remotes/"+remote+"/"+ref+"^{commit}"); err == nil {
+		return strings.TrimSpace(string(output)), nil
+	}
+	hash, err := resolveCommit(repoPath, ref)
+	if err != nil {
+		return "", fmt.Errorf("%w; fetch %s from %s first", err, ref, remote)
+	}
+	return hash, nil
+}
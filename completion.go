@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// completeFiles is printed by `gitaudit __complete` instead of candidates when the word is
+// a path, so that the shell completes it with its own file completion.
+const completeFiles = ":files"
+
+// completionTimeout bounds the git and Ollama lookups of a completion, which the user waits
+// for at the prompt.
+const completionTimeout = time.Second
+
+// completion is one candidate printed by `gitaudit __complete`.
+type completion struct {
+	Value       string
+	Description string
+}
+
+// completionRequest is the command line being completed.
+type completionRequest struct {
+	// Repo is the -repo given earlier on the line, "." without one.
+	Repo string
+}
+
+// valueCompleter lists the values of a flag.
+type valueCompleter func(req completionRequest) []completion
+
+// fileFlags take a path and are completed by the shell.
+var fileFlags = map[string]bool{
+	"repo": true, "record": true, "replay": true, "locale-file": true, "output": true, "o": true, "dir": true,
+}
+
+// flagValues complete the values of the flags that take one of a known set.
+var flagValues = map[string]valueCompleter{
+	"commit":         func(req completionRequest) []completion { return completeRefs(req.Repo, true) },
+	"reflog":         func(req completionRequest) []completion { return completeRefs(req.Repo, false) },
+	"ollama-model":   func(completionRequest) []completion { return completeModels() },
+	"retry-model":    func(completionRequest) []completion { return completeModels() },
+	"judge-model":    func(completionRequest) []completion { return completeModels() },
+	"profile":        func(completionRequest) []completion { return completeProfiles() },
+	"date-source":    fixedValues(dateSourceAuthor, dateSourceCommit),
+	"replay-missing": fixedValues(replayMissingError, replayMissingPlaceholder),
+	"trim-order":     fixedValues("context,patch", "patch,context"),
+	"notify":         fixedValues(notifyDesktop),
+	"split-by":       fixedValues("month", "week", "count:"),
+	"color":          fixedValues("auto", "always", "never"),
+	"locale":         func(req completionRequest) []completion { return fixedValues(availableLocales()...)(req) },
+	"group-by":       fixedValues(groupByChangeID),
+	"format":         fixedValues(formatText, formatJSON, formatMbox, formatPatchDir),
+	"fail-on":        func(completionRequest) []completion { return completeFailOnFields() },
+}
+
+// fixedValues completes one of values.
+func fixedValues(values ...string) valueCompleter {
+	return func(completionRequest) []completion {
+		candidates := make([]completion, len(values))
+		for i, v := range values {
+			candidates[i] = completion{Value: v}
+		}
+		return candidates
+	}
+}
+
+// completeRefs lists the branches, tags and remote-tracking branches of repoPath with their
+// subjects, plus HEAD and, for -commit, root. git gets completionTimeout to answer.
+func completeRefs(repoPath string, withRoot bool) []completion {
+	candidates := []completion{{Value: "HEAD"}}
+	if withRoot {
+		candidates = append(candidates, completion{Value: rootRevision, Description: "the first commit of the history"})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), completionTimeout)
+	defer cancel()
+	output, err := gitRun(ctx, repoPath, "for-each-ref", "--format=%(refname:short)\t%(subject)", "refs/heads", "refs/tags", "refs/remotes")
+	if err != nil {
+		return candidates
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		name, subject, _ := strings.Cut(line, "\t")
+		if name != "" {
+			candidates = append(candidates, completion{Value: name, Description: subject})
+		}
+	}
+	return candidates
+}
+
+// completeModels lists the models of the configured Ollama server from /api/tags, or
+// nothing when the server does not answer within completionTimeout.
+func completeModels() []completion {
+	config, err := loadConfig()
+	if err != nil {
+		return nil
+	}
+	base, err := ollamaBaseURL(config.OllamaEndpoint)
+	if err != nil {
+		return nil
+	}
+	resp, err := completionClient.Get(base + "/api/tags")
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+	var tags ollamaTagsResponse
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&tags) != nil {
+		return nil
+	}
+	candidates := make([]completion, len(tags.Models))
+	for i, m := range tags.Models {
+		candidates[i] = completion{Value: m.Name}
+	}
+	return candidates
+}
+
+// completeProfiles lists the profiles of the config file.
+func completeProfiles() []completion {
+	config, err := loadConfig()
+	if err != nil {
+		return nil
+	}
+	var candidates []completion
+	for _, name := range profileNames(config.Profiles) {
+		candidates = append(candidates, completion{Value: name, Description: profileSummary(config.Profiles[name])})
+	}
+	return candidates
+}
+
+// completeFailOnFields lists the fields a -fail-on condition starts with.
+func completeFailOnFields() []completion {
+	var candidates []completion
+	for name, field := range failOnFields {
+		candidates = append(candidates, completion{Value: name, Description: field.Description})
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Value < candidates[j].Value })
+	return candidates
+}
+
+// flagSummary is the first sentence of a flag's usage, to describe it next to its name.
+func flagSummary(usage string) string {
+	for i := 0; i+1 < len(usage); i++ {
+		if (usage[i] == '.' || usage[i] == ';') && usage[i+1] == ' ' &&
+			!strings.HasSuffix(usage[:i], "e.g") && !strings.HasSuffix(usage[:i], "i.e") {
+			return usage[:i]
+		}
+	}
+	return usage
+}
+
+// commandCompletions completes the first word after gitaudit with the commands, grouping
+// "cache stats" and "cache gc" under "cache".
+func commandCompletions() []completion {
+	var candidates []completion
+	seen := make(map[string]int)
+	for _, spec := range commandRegistry {
+		if spec.Name == "" {
+			continue
+		}
+		first, rest, nested := strings.Cut(spec.Name, " ")
+		if i, ok := seen[first]; ok {
+			candidates[i].Description += ", " + rest
+			continue
+		}
+		seen[first] = len(candidates)
+		description := spec.Summary
+		if nested {
+			description = "Commands: " + rest
+		}
+		candidates = append(candidates, completion{Value: first, Description: description})
+	}
+	return candidates
+}
+
+// completeWords implements `gitaudit __complete`: words are the words of the command line
+// after gitaudit, the last being the (possibly empty) word to complete. It returns the
+// candidates starting with that word, or files set when the word is a path.
+func completeWords(words []string) (candidates []completion, files bool) {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	current, done := words[len(words)-1], words[:len(words)-1]
+
+	// Find the command: its name is one or two words that do not start with a dash.
+	spec := lookupCommand("")
+	if len(done) == 0 && !strings.HasPrefix(current, "-") {
+		return filterCompletions(commandCompletions(), current), false
+	}
+	if len(done) > 0 && !strings.HasPrefix(done[0], "-") {
+		var group []completion
+		spec = nil
+		for i := range commandRegistry {
+			name := commandRegistry[i].Name
+			if name == done[0] || (len(done) > 1 && name == done[0]+" "+done[1]) {
+				spec = &commandRegistry[i]
+			}
+			if first, rest, ok := strings.Cut(name, " "); ok && first == done[0] {
+				group = append(group, completion{Value: rest, Description: commandRegistry[i].Summary})
+			}
+		}
+		if spec == nil {
+			if len(done) == 1 {
+				return filterCompletions(group, current), false
+			}
+			return nil, false
+		}
+		done = done[len(strings.Fields(spec.Name)):]
+	}
+
+	fs := spec.flagSet()
+	req := completionRequest{Repo: "."}
+	var pending *flag.Flag
+	for _, word := range done {
+		if pending != nil {
+			if pending.Name == "repo" {
+				req.Repo = word
+			}
+			pending = nil
+			continue
+		}
+		if !strings.HasPrefix(word, "-") {
+			continue
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(word, "-"), "=")
+		f := fs.Lookup(name)
+		switch {
+		case f == nil:
+		case hasValue && name == "repo":
+			req.Repo = value
+		case !hasValue && !isBoolFlag(f):
+			pending = f
+		}
+	}
+
+	if pending != nil {
+		return completeValue(pending.Name, "", current, req)
+	}
+	if strings.HasPrefix(current, "-") {
+		if name, value, ok := strings.Cut(strings.TrimLeft(current, "-"), "="); ok {
+			dashes := current[:len(current)-len(strings.TrimLeft(current, "-"))]
+			return completeValue(name, dashes+name+"=", value, req)
+		}
+		fs.VisitAll(func(f *flag.Flag) {
+			candidates = append(candidates, completion{Value: "-" + f.Name, Description: flagSummary(f.Usage)})
+		})
+		return filterCompletions(candidates, current), false
+	}
+	switch spec.Args {
+	case argRevision:
+		return filterCompletions(completeRefs(req.Repo, false), current), false
+	case argProfile:
+		return filterCompletions(completeProfiles(), current), false
+	case argShell:
+		return filterCompletions(fixedValues("bash", "zsh", "fish")(req), current), false
+	case argFile:
+		return nil, true
+	}
+	return nil, false
+}
+
+// completeValue completes the value of flag name; prefix is prepended to each candidate
+// for the -flag=value form.
+func completeValue(name, prefix, current string, req completionRequest) ([]completion, bool) {
+	if fileFlags[name] {
+		return nil, true
+	}
+	values, ok := flagValues[name]
+	if !ok {
+		return nil, false
+	}
+	candidates := filterCompletions(values(req), current)
+	for i := range candidates {
+		candidates[i].Value = prefix + candidates[i].Value
+	}
+	return candidates, false
+}
+
+// filterCompletions keeps the candidates that start with current.
+func filterCompletions(candidates []completion, current string) []completion {
+	var kept []completion
+	for _, c := range candidates {
+		if strings.HasPrefix(c.Value, current) {
+			kept = append(kept, c)
+		}
+	}
+	return kept
+}
+
+// isBoolFlag reports whether f takes no value, like the flag package decides.
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// runComplete implements the hidden `gitaudit __complete <words>` that the completion
+// scripts call: it prints one "value<TAB>description" line per candidate.
+func runComplete(words []string) {
+	candidates, files := completeWords(words)
+	if files {
+		fmt.Println(completeFiles)
+		return
+	}
+	for _, c := range candidates {
+		fmt.Printf("%s\t%s\n", c.Value, strings.ReplaceAll(c.Description, "\n", " "))
+	}
+}
+
+// completionScripts are the scripts printed by `gitaudit completion`. Each passes the words
+// of the command line to `gitaudit __complete` and falls back to the shell's own file
+// completion when it prints completeFiles.
+var completionScripts = map[string]string{
+	"bash": `# bash completion for gitaudit. Load it with: source <(gitaudit completion bash)
+_gitaudit() {
+	local line=${COMP_LINE:0:COMP_POINT}
+	local -a words
+	read -ra words <<<"$line"
+	[[ $line == *[[:space:]] ]] && words+=("")
+	local cur=${words[${#words[@]}-1]}
+	local out
+	out=$("${words[0]}" __complete "${words[@]:1}" 2>/dev/null) || return
+	if [[ $out == ":files" ]]; then
+		compopt -o filenames 2>/dev/null
+		COMPREPLY=($(compgen -f -- "${COMP_WORDS[COMP_CWORD]}"))
+		return
+	fi
+	local IFS=$'\n'
+	COMPREPLY=($(cut -f1 <<<"$out"))
+	# bash splits words at : and =, so only the part after the last one is replaced.
+	if [[ $cur == *[:=]* ]]; then
+		local prefix=${cur%"${cur##*[:=]}"}
+		COMPREPLY=("${COMPREPLY[@]#"$prefix"}")
+	fi
+}
+complete -F _gitaudit gitaudit
+`,
+	"zsh": `#compdef gitaudit
+# zsh completion for gitaudit. Load it with: source <(gitaudit completion zsh)
+_gitaudit() {
+	local -a lines candidates
+	local line
+	lines=("${(@f)$("${words[1]}" __complete "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+	if [[ ${lines[1]} == ":files" ]]; then
+		_files
+		return
+	fi
+	for line in $lines; do
+		[[ -n $line ]] || continue
+		candidates+=("${${line%%$'\t'*}//:/\\:}:${line#*$'\t'}")
+	done
+	_describe -t values gitaudit candidates
+}
+if [[ $funcstack[1] == _gitaudit ]]; then
+	_gitaudit "$@"
+else
+	compdef _gitaudit gitaudit
+fi
+`,
+	"fish": `# fish completion for gitaudit. Load it with: gitaudit completion fish | source
+function __gitaudit_complete
+	set -l words (commandline -opc)
+	set -l current (commandline -ct)
+	set -q current[1]; or set current ""
+	set -l out ($words[1] __complete $words[2..-1] $current 2>/dev/null)
+	if test "$out[1]" = ":files"
+		__fish_complete_path $current
+		return
+	end
+	printf '%s\n' $out
+end
+complete -c gitaudit -f -a '(__gitaudit_complete)'
+`,
+}
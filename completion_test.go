@@ -0,0 +1,128 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestManPageGolden(t *testing.T) {
+	page := manPage()
+	checkGolden(t, "completion/gitaudit.1", page)
+
+	// Every flag of every command is documented, so the page cannot fall behind the registry.
+	auditFlags := lookupCommand("").flagSet()
+	for i := range commandRegistry {
+		spec := &commandRegistry[i]
+		if spec.Flags == nil {
+			continue
+		}
+		spec.flagSet().VisitAll(func(f *flag.Flag) {
+			name := roffEscape("-" + f.Name)
+			if !strings.Contains(page, "\n.B "+name+"\n") && !strings.Contains(page, "\n.BI "+name+" ") && (spec.Name == "" || auditFlags.Lookup(f.Name) == nil) {
+				t.Errorf("%s: -%s is not in the man page", spec.title(), f.Name)
+			}
+		})
+	}
+	for _, rule := range lookupCommand("").Rules {
+		if !strings.Contains(page, roffEscape(rule.Message)) {
+			t.Errorf("the man page lacks the rule %q", rule.Message)
+		}
+	}
+	if strings.Contains(page, "\n'") || strings.Contains(page, "\n.\n") {
+		t.Error("the man page has a line roff would misread")
+	}
+}
+
+func TestCompletionScriptsGolden(t *testing.T) {
+	env := newAuditEnv(t)
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		script := env.mustRun("completion", shell)
+		checkGolden(t, "completion/gitaudit."+shell, script)
+		if !strings.Contains(script, "__complete") || !strings.Contains(script, completeFiles) {
+			t.Errorf("%s script does not call __complete:\n%s", shell, script)
+		}
+	}
+	if out, code := env.run("completion", "powershell"); code == 0 || !strings.Contains(out, "gitaudit completion bash|zsh|fish") {
+		t.Errorf("completion powershell: exit %d\n%s", code, out)
+	}
+}
+
+// completions runs `gitaudit __complete words...` and returns its lines, which are golden.
+func completions(t *testing.T, env *auditEnv, words ...string) string {
+	t.Helper()
+	out, code := env.run(append([]string{"__complete"}, words...)...)
+	if code != 0 {
+		t.Fatalf("__complete %q: exit %d\n%s", words, code, out)
+	}
+	return out
+}
+
+func TestCompleteGolden(t *testing.T) {
+	repo := newFixtureRepo(t)
+	repo.commits(2)
+	repo.git("tag", "-a", "v1.0", "-m", "First release")
+	repo.git("branch", "topic")
+	env := newAuditEnv(t)
+	env.Config["profiles"] = map[string]any{"nightly": map[string]any{"ollama_model": "tiny:0.5b"}}
+
+	var sb strings.Builder
+	for _, words := range [][]string{
+		{""},
+		{"ca"},
+		{"cache", ""},
+		{"-no-m"},
+		{"-format", ""},
+		{"-format=j"},
+		{"-repo", repo.Dir, "-commit", ""},
+		{"-repo=" + repo.Dir, "-reflog", "t"},
+		{"-ollama-model", ""},
+		{"-retry-model=t"},
+		{"-profile", ""},
+		{"-output", "rep"},
+		{"completion", ""},
+		{"explain", "-repo", repo.Dir, "v"},
+		{"file", ""},
+		{"-fail-on", ""},
+		{"unknown", "sub", ""},
+	} {
+		fmt.Fprintf(&sb, "$ gitaudit %s<TAB>\n", strings.ReplaceAll(strings.Join(words, " "), repo.Dir, "<repo>"))
+		sb.WriteString(completions(t, env, words...))
+	}
+	checkGolden(t, "completion/complete.txt", sb.String())
+}
+
+func TestCompleteModelsTimeout(t *testing.T) {
+	env := newAuditEnv(t)
+	// An Ollama server that does not answer in time completes nothing, and quickly.
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-release }))
+	defer slow.Close()
+	defer close(release)
+	env.Config["ollama_endpoint"] = slow.URL + "/api/generate"
+	start := time.Now()
+	if out := completions(t, env, "-ollama-model", ""); out != "" {
+		t.Errorf("models of a server that does not answer:\n%s", out)
+	}
+	if elapsed := time.Since(start); elapsed > completionTimeout+2*time.Second {
+		t.Errorf("completion took %s", elapsed)
+	}
+}
+
+func TestFlagSummary(t *testing.T) {
+	for usage, want := range map[string]string{
+		"Audit commits, e.g. main. More text":       "Audit commits, e.g. main",
+		"Skip merges; their branches are audited":   "Skip merges",
+		"Use the model, i.e. the configured one. X": "Use the model, i.e. the configured one",
+		"No sentence end":                           "No sentence end",
+		"Version 1.2 is used":                       "Version 1.2 is used",
+	} {
+		if got := flagSummary(usage); got != want {
+			t.Errorf("flagSummary(%q) = %q, want %q", usage, got, want)
+		}
+	}
+}
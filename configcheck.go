@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// laxConfig is set by -lax-config: unknown config file keys are ignored instead of rejected,
+// so that an older binary can read a config file written for a newer one.
+var laxConfig bool
+
+// utf8BOM is the byte order mark some editors put at the start of a UTF-8 file.
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// decodeConfigJSON decodes a config file, or part of one, into v. A UTF-8 byte order mark is
+// skipped. Unless lax is set, an unknown key is an error naming the closest known key. Syntax
+// and type errors are located by line and column.
+func decodeConfigJSON(data []byte, v any, lax bool) error {
+	data = bytes.TrimPrefix(data, utf8BOM)
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if !lax {
+		decoder.DisallowUnknownFields()
+	}
+	err := decoder.Decode(v)
+	if err == nil {
+		return nil
+	}
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return fmt.Errorf("%s: %v", jsonPosition(data, syntaxErr.Offset), err)
+	case errors.As(err, &typeErr):
+		field := typeErr.Field
+		if field == "" {
+			field = "the file"
+		}
+		return fmt.Errorf("%s: %s must be %s, not a JSON %s", jsonPosition(data, typeErr.Offset), field, describeJSONType(typeErr.Type), typeErr.Value)
+	case errors.Is(err, io.EOF):
+		return errors.New("the file is empty; it must contain a JSON object")
+	}
+	if key, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		key = strings.Trim(key, `"`)
+		// The decoder reports unknown keys once the whole value is decoded, so its offset is past
+		// the key; the key's first occurrence is a better guess.
+		offset := decoder.InputOffset()
+		if i := bytes.Index(data, []byte(`"`+key+`"`)); i >= 0 {
+			offset = int64(i)
+		}
+		message := fmt.Sprintf("%s: unknown key %q", jsonPosition(data, offset), key)
+		if suggestion := closestConfigKey(key); suggestion != "" {
+			message += fmt.Sprintf("; did you mean %q?", suggestion)
+		}
+		return errors.New(message + " (-lax-config ignores unknown keys)")
+	}
+	return err
+}
+
+// jsonPosition describes a byte offset of data as "line L, column C".
+func jsonPosition(data []byte, offset int64) string {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndexByte(before, '\n')
+	return fmt.Sprintf("line %d, column %d", line, column)
+}
+
+// describeJSONType names the JSON value a Go type is decoded from.
+func describeJSONType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "a whole number"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Slice, reflect.Array:
+		return "a list"
+	case reflect.Pointer:
+		return describeJSONType(t.Elem())
+	}
+	return "an object"
+}
+
+// configKeys lists every key of the config file, including those of nested objects.
+func configKeys() []string {
+	seen := make(map[string]bool)
+	var walk func(t reflect.Type)
+	walk = func(t reflect.Type) {
+		for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Map {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			return
+		}
+		for i := 0; i < t.NumField(); i++ {
+			key, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+			if key == "" || key == "-" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			walk(t.Field(i).Type)
+		}
+	}
+	walk(reflect.TypeOf(Config{}))
+	keys := make([]string, 0, len(seen))
+	for key := range seen {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// closestConfigKey returns the config key nearest to key by edit distance, or "" when none
+// is close enough to be a likely typo.
+func closestConfigKey(key string) string {
+	best, bestDistance := "", len(key)/3+2
+	for _, candidate := range configKeys() {
+		if d := editDistance(strings.ToLower(key), candidate); d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
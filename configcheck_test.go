@@ -0,0 +1,112 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDecodeConfigJSON(t *testing.T) {
+	tests := []struct {
+		name, data string
+		lax        bool
+		want       string
+	}{
+		{"valid", `{"ollama_endpoint": "http://localhost:11434/api/generate", "ollama_model": "llama3"}`, false, ""},
+		{"byte order mark", "\xEF\xBB\xBF" + `{"ollama_model": "llama3"}`, false, ""},
+		{"typo", "{\n  \"ollama_endpoint\": \"http://localhost:11434/api/generate\",\n  \"olama_model\": \"llama3\"\n}",
+			false, `line 3, column 3: unknown key "olama_model"; did you mean "ollama_model"? (-lax-config ignores unknown keys)`},
+		{"typo in a nested object", `{"anthropic": {"api_key_env": "KEY", "modle": "claude"}}`, false, `unknown key "modle"; did you mean "model"?`},
+		{"unknown key unlike any", `{"frobnicate_everything": true}`, false, `line 1, column 2: unknown key "frobnicate_everything" (-lax-config ignores unknown keys)`},
+		{"case of a typo", `{"Ollama_Modle": "llama3"}`, false, `did you mean "ollama_model"?`},
+		{"lax", `{"olama_model": "llama3", "ollama_model": "llama3"}`, true, ""},
+		// Type and syntax errors are located just past the offending value, as encoding/json
+		// reports them.
+		{"string for a number", "{\n  \"context_size\": \"8192\"\n}", false, "line 2, column 25: context_size must be a whole number, not a JSON string"},
+		{"number for a string", `{"ollama_model": 3}`, false, "ollama_model must be a string, not a JSON number"},
+		{"object for a list", `{"never_send": {"a": 1}}`, false, "never_send must be a list, not a JSON object"},
+		{"string for a bool", `{"auto_context_size": "yes"}`, false, "auto_context_size must be true or false, not a JSON string"},
+		{"nested type", `{"anthropic": {"max_tokens": 1.5}}`, false, "anthropic.max_tokens must be a whole number, not a JSON number"},
+		{"list for the file", `["ollama_model"]`, false, "the file must be an object, not a JSON array"},
+		{"trailing comma", "{\n  \"ollama_model\": \"llama3\",\n}", false, "line 3, column 2: invalid character '}' looking for beginning of object key string"},
+		{"missing quote", `{"ollama_model: "llama3"}`, false, "line 1, column 19: invalid character"},
+		{"empty", "", false, "the file is empty; it must contain a JSON object"},
+		{"only a byte order mark", "\xEF\xBB\xBF", false, "the file is empty"},
+		{"truncated", `{"ollama_model": "llama3"`, false, "unexpected EOF"},
+	}
+	for _, tt := range tests {
+		var config Config
+		err := decodeConfigJSON([]byte(tt.data), &config, tt.lax)
+		if tt.want == "" && err != nil || tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+			t.Errorf("%s: %v, want %q", tt.name, err, tt.want)
+		}
+	}
+	var config Config
+	if err := decodeConfigJSON([]byte("\xEF\xBB\xBF"+`{"ollama_model": "llama3"}`), &config, false); err != nil || config.OllamaModel != "llama3" {
+		t.Errorf("BOM-prefixed file read as %q, %v", config.OllamaModel, err)
+	}
+}
+
+func TestConfigKeysAndSuggestions(t *testing.T) {
+	keys := configKeys()
+	for _, want := range []string{"ollama_model", "never_send", "api_key_env", "max_tokens", "pricing"} {
+		if !strings.Contains(" "+strings.Join(keys, " ")+" ", " "+want+" ") {
+			t.Errorf("configKeys lacks %q", want)
+		}
+	}
+	for key, want := range map[string]string{
+		"olama_model":      "ollama_model",
+		"ollama_endpiont":  "ollama_endpoint",
+		"neversend":        "never_send",
+		"NEVER_SEND":       "never_send",
+		"x":                "",
+		"completely_wrong": "",
+	} {
+		if got := closestConfigKey(key); got != want {
+			t.Errorf("closestConfigKey(%q) = %q, want %q", key, got, want)
+		}
+	}
+	for _, tt := range []struct {
+		a, b string
+		want int
+	}{{"", "", 0}, {"abc", "", 3}, {"", "ab", 2}, {"kitten", "sitting", 3}, {"olama", "ollama", 1}, {"ab", "ba", 2}} {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+	if got := jsonPosition([]byte("{\n  \"a\": 1\n}"), 100); got != "line 3, column 2" {
+		t.Errorf("jsonPosition past the end = %q", got)
+	}
+}
+
+func TestConfigErrorsRun(t *testing.T) {
+	repo := newFixtureRepo(t)
+	repo.commits(1)
+	env := newAuditEnv(t)
+	// runWithConfig runs gitaudit with data as the config file.
+	runWithConfig := func(data string, args ...string) (string, int) {
+		t.Helper()
+		cmd := env.command(append([]string{"-repo", repo.Dir, "-commit", "root", "-output", filepath.Join(env.Work, "report.txt"), "-force"}, args...)...)
+		if err := os.WriteFile(filepath.Join(env.Home, ".gitaudit"), []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			return string(out), 1
+		}
+		return string(out), 0
+	}
+	typo := "{\n  \"ollama_endpoint\": \"" + env.Ollama.Endpoint() + "\",\n  \"ollama_model\": \"tiny:0.5b\",\n  \"olama_model\": \"tiny:0.5b\"\n}\n"
+	out, code := runWithConfig(typo)
+	configPath := filepath.Join(env.Home, ".gitaudit")
+	if code == 0 || !strings.Contains(out, "failed to decode config file "+configPath+`: line 4, column 3: unknown key "olama_model"; did you mean "ollama_model"?`) {
+		t.Errorf("typo'd key: exit %d\n%s", code, out)
+	}
+	if out, code := runWithConfig(typo, "-lax-config"); code != 0 {
+		t.Errorf("-lax-config: exit %d\n%s", code, out)
+	}
+	if out, code := runWithConfig("\xEF\xBB\xBF{\"ollama_endpoint\": \"" + env.Ollama.Endpoint() + "\", \"ollama_model\": \"tiny:0.5b\",}"); code == 0 || !strings.Contains(out, "line 1, column") {
+		t.Errorf("trailing comma: exit %d\n%s", code, out)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// defaultControlWatchlist are the paths that define the control environment: CI pipelines,
+// release tooling, code ownership and gitaudit's own configuration. A commit touching one is
+// a control environment change. The control_watchlist config key adds to these.
+var defaultControlWatchlist = []string{
+	".github/workflows/",
+	".github/actions/",
+	".gitlab-ci.yml",
+	".gitlab/ci/",
+	"Jenkinsfile",
+	".circleci/",
+	"azure-pipelines.yml",
+	".buildkite/",
+	".goreleaser.yml",
+	".goreleaser.yaml",
+	"release.sh",
+	"CODEOWNERS",
+	".gitaudit",
+	".gitaudit/",
+}
+
+// controlWatchlist returns the built-in watchlist followed by the configured paths.
+func controlWatchlist(config *Config) []string {
+	return append(append([]string{}, defaultControlWatchlist...), config.ControlWatchlist...)
+}
+
+// validateControlWatchlist checks the control_watchlist config key.
+func validateControlWatchlist(globs []string) error {
+	for i, glob := range globs {
+		if strings.TrimSpace(glob) == "" {
+			return fmt.Errorf("control_watchlist entry %d is empty", i+1)
+		}
+	}
+	return nil
+}
+
+// getTouchedPaths lists the paths a commit adds, modifies, deletes or renames, both sides of
+// a rename included, compared with base when it is set. A merge compared with all its
+// parents lists only the paths it changed relative to every one of them, i.e. its conflict
+// resolutions and evil changes. Only the tree entries are read, never file contents.
+func getTouchedPaths(ctx context.Context, repoPath, commitHash, base string, merge bool) ([]string, error) {
+	args := []string{"diff-tree", "-r", "--no-commit-id", "-z"}
+	switch {
+	case base != "":
+		args = append(args, "--name-status", "-M", base, commitHash)
+	case merge:
+		args = append(args, "-c", "--name-only", commitHash)
+	default:
+		args = append(args, "--name-status", "-M", "--root", commitHash)
+	}
+	output, err := gitRun(ctx, repoPath, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the changed paths: %w", err)
+	}
+	fields := strings.Split(strings.TrimSuffix(string(output), "\x00"), "\x00")
+	if merge && base == "" {
+		return nonEmpty(fields), nil
+	}
+	// Entries are "<status>\x00<path>", or "<status>\x00<old>\x00<new>" for renames and copies.
+	var paths []string
+	for i := 0; i < len(fields); i++ {
+		status := fields[i]
+		if status == "" {
+			continue
+		}
+		count := 1
+		if status[0] == 'R' || status[0] == 'C' {
+			count = 2
+		}
+		for ; count > 0 && i+1 < len(fields); count-- {
+			i++
+			paths = append(paths, fields[i])
+		}
+	}
+	return paths, nil
+}
+
+// nonEmpty drops the empty strings of values.
+func nonEmpty(values []string) []string {
+	var kept []string
+	for _, v := range values {
+		if v != "" {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
+
+// patchHeaderPaths lists the paths named by the "diff --git a/<old> b/<new>" headers of a
+// patch, both sides of a rename included.
+func patchHeaderPaths(patch string) []string {
+	var paths []string
+	for _, line := range strings.Split(patch, "\n") {
+		if !strings.HasPrefix(line, "diff --git ") {
+			continue
+		}
+		newPath := diffSectionPath(line)
+		paths = append(paths, newPath)
+		rest := strings.TrimPrefix(line, "diff --git a/")
+		if idx := strings.LastIndex(rest, " b/"); idx >= 0 && rest[:idx] != newPath {
+			paths = append(paths, rest[:idx])
+		}
+	}
+	return paths
+}
+
+// matchControlPaths returns the paths that match the watchlist, sorted and without
+// duplicates.
+func matchControlPaths(watchlist, paths []string) []string {
+	seen := make(map[string]bool)
+	var matched []string
+	for _, path := range paths {
+		if _, ok := matchAnyGlob(watchlist, path); ok && !seen[path] {
+			seen[path] = true
+			matched = append(matched, path)
+		}
+	}
+	sort.Strings(matched)
+	return matched
+}
+
+// applyControlWatchlist flags auditData as a control environment change when paths touch
+// the watchlist, and asks the model to describe the governance impact.
+func applyControlWatchlist(watchlist, paths []string, extras promptExtras, auditData *CommitAuditData) promptExtras {
+	matched := matchControlPaths(watchlist, paths)
+	if len(matched) == 0 {
+		return extras
+	}
+	auditData.ControlChange = true
+	auditData.ControlPaths = matched
+	extras.Instructions = append(extras.Instructions, "This commit changes files that define the control environment (CI pipelines, release tooling, code ownership or audit configuration): "+strings.Join(matched, ", ")+". Describe its governance impact: which review, build, release or audit behavior changes, and whether any check is added, weakened, bypassed or removed.")
+	return extras
+}
+
+// formatControlChangeSection lists the control environment changes among entries for the
+// top of the report, or returns "" when there are none.
+func formatControlChangeSection(entries []CommitAuditData) string {
+	var changes []CommitAuditData
+	for _, entry := range entries {
+		if entry.ControlChange {
+			changes = append(changes, entry)
+		}
+	}
+	if len(changes) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString(msg("section.control_changes", msgCount("count.commits", len(changes))) + "\n")
+	for _, entry := range changes {
+		summary, _, _ := strings.Cut(strings.TrimSpace(entry.Summary), "\n")
+		fmt.Fprintf(&sb, "%s %s [%s] %s\n", shortHash(entry.Hash), localDate(entry.Date), strings.Join(entry.ControlPaths, ", "), summary)
+	}
+	return sb.String()
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPatchHeaderPaths(t *testing.T) {
+	// Only the headers are read: the hunks below them are not needed.
+	patch := "From abc Mon Sep 17 00:00:00 2001\nSubject: [PATCH] Rework CI\n---\n" +
+		"diff --git a/.github/workflows/ci.yml b/.github/workflows/ci.yml\n" +
+		"diff --git a/OWNERS b/CODEOWNERS\nsimilarity index 100%\nrename from OWNERS\nrename to CODEOWNERS\n" +
+		"diff --git a/src/main.go b/src/main.go\n+diff --git a/quoted/in/a/hunk b/quoted/in/a/hunk\n"
+	want := []string{".github/workflows/ci.yml", "CODEOWNERS", "OWNERS", "src/main.go"}
+	if got := patchHeaderPaths(patch); !reflect.DeepEqual(got, want) {
+		t.Errorf("patchHeaderPaths = %q, want %q", got, want)
+	}
+	if got := patchHeaderPaths("no headers at all\n"); got != nil {
+		t.Errorf("patchHeaderPaths of a patch without headers = %q", got)
+	}
+}
+
+func TestMatchControlPaths(t *testing.T) {
+	watchlist := controlWatchlist(&Config{ControlWatchlist: []string{"deploy/", "scripts/release-*.sh"}})
+	tests := []struct {
+		paths, want []string
+	}{
+		{[]string{".github/workflows/ci.yml", "src/main.go"}, []string{".github/workflows/ci.yml"}},
+		{[]string{"Jenkinsfile", "docs/CODEOWNERS", "CODEOWNERS"}, []string{"CODEOWNERS", "Jenkinsfile", "docs/CODEOWNERS"}},
+		{[]string{".gitaudit", ".gitaudit/prompts/summary.txt"}, []string{".gitaudit", ".gitaudit/prompts/summary.txt"}},
+		{[]string{"deploy/prod.yaml", "scripts/release-1.2.sh", "scripts/build.sh"}, []string{"deploy/prod.yaml", "scripts/release-1.2.sh"}},
+		// A path touched twice, e.g. by both sides of a rename, is listed once.
+		{[]string{"CODEOWNERS", "CODEOWNERS"}, []string{"CODEOWNERS"}},
+		{[]string{"src/workflows/ci.yml", "README.md"}, nil},
+		{nil, nil},
+	}
+	for _, tt := range tests {
+		if got := matchControlPaths(watchlist, tt.paths); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("matchControlPaths(%q) = %q, want %q", tt.paths, got, tt.want)
+		}
+	}
+	if got := matchControlPaths(controlWatchlist(&Config{}), []string{"deploy/prod.yaml"}); got != nil {
+		t.Errorf("deploy/ matched without being configured: %q", got)
+	}
+
+	if err := validateControlWatchlist([]string{"deploy/", " "}); err == nil || err.Error() != "control_watchlist entry 2 is empty" {
+		t.Errorf("validateControlWatchlist = %v", err)
+	}
+}
+
+func TestApplyControlWatchlist(t *testing.T) {
+	base := promptExtras{Instructions: []string{"Mention the ticket."}}
+	var data CommitAuditData
+	extras := applyControlWatchlist(defaultControlWatchlist, []string{"src/main.go"}, base, &data)
+	if data.ControlChange || len(extras.Instructions) != 1 {
+		t.Errorf("ordinary commit: %+v, %+v", data, extras)
+	}
+	extras = applyControlWatchlist(defaultControlWatchlist, []string{"src/main.go", "CODEOWNERS"}, base, &data)
+	if !data.ControlChange || !reflect.DeepEqual(data.ControlPaths, []string{"CODEOWNERS"}) || len(extras.Instructions) != 2 ||
+		!strings.Contains(extras.Instructions[1], "the control environment (CI pipelines, release tooling, code ownership or audit configuration): CODEOWNERS. Describe its governance impact") {
+		t.Errorf("control change: %+v, %q", data, extras.Instructions)
+	}
+}
+
+func TestGetTouchedPaths(t *testing.T) {
+	repo := newFixtureRepo(t)
+	root := repo.commit("Initial", map[string]string{"OWNERS": "@ada\n", "src/main.go": "package main\n", "old.txt": "x\n"})
+	repo.git("mv", "OWNERS", "CODEOWNERS")
+	rename := repo.commit("Rename OWNERS", nil)
+	deletion := repo.commit("Delete old.txt", map[string]string{"old.txt": ""})
+
+	repo.git("checkout", "-q", "-b", "topic", deletion)
+	repo.commit("Add CI", map[string]string{".github/workflows/ci.yml": "on: push\n"})
+	repo.git("checkout", "-q", "main")
+	repo.commit("Edit main", map[string]string{"src/main.go": "package main\n\nfunc main() {}\n"})
+	merge := repo.merge("Merge topic", "topic")
+
+	tests := []struct {
+		name, hash, base string
+		merge            bool
+		want             []string
+	}{
+		{"root", root, "", false, []string{"OWNERS", "old.txt", "src/main.go"}},
+		{"rename", rename, "", false, []string{"OWNERS", "CODEOWNERS"}},
+		{"deletion", deletion, "", false, []string{"old.txt"}},
+		// A clean merge changed nothing relative to all its parents.
+		{"merge", merge, "", true, nil},
+		{"merge against its first parent", merge, merge + "^1", false, []string{".github/workflows/ci.yml"}},
+	}
+	for _, tt := range tests {
+		got, err := getTouchedPaths(context.Background(), repo.Dir, tt.hash, tt.base, tt.merge)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %q, %v; want %q", tt.name, got, err, tt.want)
+		}
+	}
+	if _, err := getTouchedPaths(context.Background(), repo.Dir, "0000000000000000000000000000000000000000", "", false); err == nil || !strings.HasPrefix(err.Error(), "failed to list the changed paths: ") {
+		t.Errorf("unknown commit: %v", err)
+	}
+}
+
+func TestControlChangeRun(t *testing.T) {
+	repo := newFixtureRepo(t)
+	base := repo.commit("Initial", map[string]string{"src/main.go": "package main\n"})
+	ci := repo.commit("Skip the lint job", map[string]string{".github/workflows/ci.yml": "jobs:\n  test: {}\n"})
+	repo.commit("Edit main", map[string]string{"src/main.go": "package main\n\nfunc main() {}\n"})
+	owners := repo.commit("Add owners", map[string]string{"CODEOWNERS": "* @ada\n"})
+	env := newAuditEnv(t)
+
+	text := filepath.Join(env.Work, "report.txt")
+	out := env.mustRun("-repo", repo.Dir, "-commit", base, "-output", text)
+	content := readFile(t, text)
+	section := "=== Control environment changes (2 commits) ===\n"
+	if idx := strings.Index(content, section); idx < 0 || idx > strings.Index(content, "Commit: ") {
+		t.Errorf("the section is not before the entries:\n%s", content)
+	}
+	for _, want := range []string{
+		shortHash(ci) + " ", " [.github/workflows/ci.yml] Summary ",
+		shortHash(owners) + " ", " [CODEOWNERS] Summary ",
+		"Control environment: .github/workflows/ci.yml\n",
+		"Control environment: CODEOWNERS\n",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("report lacks %q:\n%s", want, content)
+		}
+	}
+	if strings.Count(content, "Control environment: ") != 2 {
+		t.Errorf("report flags other commits:\n%s", content)
+	}
+	var instructed int
+	for _, prompt := range env.Ollama.Prompts() {
+		if strings.Contains(prompt, "Describe its governance impact") {
+			instructed++
+		}
+	}
+	if instructed != 2 {
+		t.Errorf("%d prompts ask for the governance impact, want 2\n%s", instructed, out)
+	}
+
+	report := filepath.Join(env.Work, "report.json")
+	env.mustRun("-repo", repo.Dir, "-commit", base, "-format", "json", "-output", report)
+	var document jsonReport
+	if err := json.Unmarshal([]byte(readFile(t, report)), &document); err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range document.Commits {
+		if want := entry.Hash == ci || entry.Hash == owners; entry.ControlChange != want {
+			t.Errorf("%s: control_change %v", shortHash(entry.Hash), entry.ControlChange)
+		}
+	}
+
+	if out, code := env.run("-repo", repo.Dir, "-commit", base, "-output", text, "-force", "-fail-on", "control_changes >= 2"); code != exitFailOn {
+		t.Errorf("-fail-on control_changes: exit %d\n%s", code, out)
+	}
+
+	// gitaudit patch flags a control change from the diff headers alone.
+	cmd := env.command("patch")
+	cmd.Stdin = strings.NewReader(repo.git("format-patch", "-1", "--stdout", ci) + "\n")
+	patchOut, err := cmd.CombinedOutput()
+	if err != nil || !strings.Contains(string(patchOut), "Control environment: .github/workflows/ci.yml") {
+		t.Errorf("gitaudit patch: %v\n%s", err, patchOut)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// controlUncategorized replaces a control category the model names but the taxonomy does
+// not define, and is used when the model names none at all.
+const controlUncategorized = "uncategorized"
+
+// controlCategory is one category of the -controls taxonomy. The description is given to
+// the model to explain what belongs in the category.
+type controlCategory struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// defaultControlTaxonomy is a generic SOC 2 style set of change-related control categories,
+// used when the config file does not define control_taxonomy.
+var defaultControlTaxonomy = []controlCategory{
+	{Name: "change-management", Description: "How changes are made, reviewed, tested and released: build and deployment pipelines, CI configuration, release and versioning processes."},
+	{Name: "access-control", Description: "Authentication, authorization, permissions, roles, credentials, session handling and identity management."},
+	{Name: "logging-monitoring", Description: "Audit logs, application logging, metrics, alerting, tracing and monitoring of system behaviour."},
+	{Name: "data-handling", Description: "Storage, retention, deletion, encryption, classification, import or export of data, including personal or customer data."},
+	{Name: "availability", Description: "Resilience, backups, capacity, failover, rate limiting and recovery from failures."},
+	{Name: "vendor-management", Description: "Third-party dependencies, external services and their versions or configuration."},
+}
+
+// controlMapper asks the model to assign control categories to each commit and reads them
+// back from the generated message.
+type controlMapper struct {
+	Taxonomy []controlCategory
+	known    map[string]bool
+}
+
+// newControlMapper validates a configured taxonomy, falling back to the default one.
+func newControlMapper(taxonomy []controlCategory) (*controlMapper, error) {
+	if len(taxonomy) == 0 {
+		taxonomy = defaultControlTaxonomy
+	}
+	m := &controlMapper{known: make(map[string]bool)}
+	for i, category := range taxonomy {
+		name := strings.ToLower(strings.TrimSpace(category.Name))
+		if name == "" {
+			return nil, fmt.Errorf("control_taxonomy entry %d has no name", i+1)
+		}
+		if strings.ContainsAny(name, ",\n") {
+			return nil, fmt.Errorf("control_taxonomy name %q must not contain commas or newlines", category.Name)
+		}
+		if name == controlUncategorized {
+			return nil, fmt.Errorf("control_taxonomy name %q is reserved for categories outside the taxonomy", controlUncategorized)
+		}
+		if m.known[name] {
+			return nil, fmt.Errorf("control_taxonomy name %q is defined twice", category.Name)
+		}
+		m.known[name] = true
+		m.Taxonomy = append(m.Taxonomy, controlCategory{Name: name, Description: category.Description})
+	}
+	return m, nil
+}
+
+// Instruction is the prompt requirement asking for the control categories.
+func (m *controlMapper) Instruction() string {
+	var sb strings.Builder
+	sb.WriteString("After the message, add a final line of the form \"Controls: <category>, <category>\" naming the audit control categories this change is relevant to, or \"Controls: none\". Use only these category names:")
+	for _, category := range m.Taxonomy {
+		fmt.Fprintf(&sb, "\n  - %s: %s", category.Name, category.Description)
+	}
+	return sb.String()
+}
+
+// controlsLine matches the "Controls:" line, tolerating markdown emphasis around the label.
+var controlsLine = regexp.MustCompile(`(?i)^[\s*_]*controls?[\s*_]*:[\s*_]*(.*?)[\s*_]*$`)
+
+// Extract removes the last "Controls:" line from a generated message and returns the
+// message and the categories it named. Names outside the taxonomy, and a message without a
+// Controls line, become "uncategorized"; "none" yields no categories.
+func (m *controlMapper) Extract(message string) (string, []string) {
+	lines := strings.Split(strings.TrimRight(message, "\n"), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		match := controlsLine.FindStringSubmatch(lines[i])
+		if match == nil {
+			continue
+		}
+		rest := append(lines[:i:i], lines[i+1:]...)
+		return strings.TrimRight(strings.Join(rest, "\n"), "\n"), m.normalize(match[1])
+	}
+	return message, []string{controlUncategorized}
+}
+
+// normalize maps the model's comma-separated category list onto the taxonomy.
+func (m *controlMapper) normalize(list string) []string {
+	var controls []string
+	seen := make(map[string]bool)
+	for _, item := range strings.Split(list, ",") {
+		name := strings.ToLower(strings.Trim(item, " \t`'\"[]*_."))
+		if name == "" || name == "none" {
+			continue
+		}
+		if !m.known[name] {
+			name = controlUncategorized
+		}
+		if !seen[name] {
+			seen[name] = true
+			controls = append(controls, name)
+		}
+	}
+	return controls
+}
+
+// formatControlMatrix renders the control matrix section of the report: for each control
+// category, alphabetically with uncategorized last, the number of commits assigned to it and
+// their short hashes. It is empty when no entry has controls.
+func formatControlMatrix(entries []CommitAuditData) string {
+	commits := make(map[string][]string)
+	var order []string
+	for _, entry := range entries {
+		for _, control := range entry.Controls {
+			if _, ok := commits[control]; !ok {
+				order = append(order, control)
+			}
+			commits[control] = append(commits[control], shortHash(entry.Hash))
+		}
+	}
+	if len(order) == 0 {
+		return ""
+	}
+	sort.Slice(order, func(i, j int) bool {
+		if (order[i] == controlUncategorized) != (order[j] == controlUncategorized) {
+			return order[j] == controlUncategorized
+		}
+		return order[i] < order[j]
+	})
+
+	width := 0
+	for _, control := range order {
+		width = max(width, len(control))
+	}
+	var sb strings.Builder
+	sb.WriteString(msg("section.controls") + "\n")
+	for _, control := range order {
+		fmt.Fprintf(&sb, "%-*s  %3d  %s\n", width, control, len(commits[control]), strings.Join(commits[control], ", "))
+	}
+	return sb.String()
+}
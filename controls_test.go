@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewControlMapper(t *testing.T) {
+	m, err := newControlMapper(nil)
+	if err != nil || !reflect.DeepEqual(m.Taxonomy, defaultControlTaxonomy) {
+		t.Fatalf("default taxonomy = %v, %v", m, err)
+	}
+	m, err = newControlMapper([]controlCategory{{Name: " Access-Control ", Description: "Logins."}})
+	if err != nil || m.Taxonomy[0].Name != "access-control" || !m.known["access-control"] {
+		t.Errorf("configured names are not normalized: %+v, %v", m, err)
+	}
+	for _, tt := range []struct {
+		taxonomy []controlCategory
+		want     string
+	}{
+		{[]controlCategory{{Name: "a"}, {Name: " "}}, "control_taxonomy entry 2 has no name"},
+		{[]controlCategory{{Name: "a, b"}}, "must not contain commas"},
+		{[]controlCategory{{Name: "Uncategorized"}}, "is reserved"},
+		{[]controlCategory{{Name: "a"}, {Name: "A"}}, "is defined twice"},
+	} {
+		if _, err := newControlMapper(tt.taxonomy); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("newControlMapper(%v) = %v, want %q", tt.taxonomy, err, tt.want)
+		}
+	}
+}
+
+func TestControlMapperInstruction(t *testing.T) {
+	m, _ := newControlMapper([]controlCategory{{Name: "access-control", Description: "Logins and permissions."}, {Name: "data-handling", Description: "Customer data."}})
+	instruction := m.Instruction()
+	if !strings.Contains(instruction, `"Controls: none"`) || !strings.HasSuffix(instruction, "\n  - access-control: Logins and permissions.\n  - data-handling: Customer data.") {
+		t.Errorf("instruction:\n%s", instruction)
+	}
+}
+
+func TestControlMapperExtract(t *testing.T) {
+	m, _ := newControlMapper(nil)
+	tests := []struct {
+		message  string
+		want     string
+		controls []string
+	}{
+		{"Add login throttling.\n\nControls: access-control, Availability", "Add login throttling.", []string{"access-control", "availability"}},
+		// Emphasis, quoting and repeats are tolerated; the last Controls line wins.
+		{"Rotate keys.\n**Controls:** `access-control`, access-control.", "Rotate keys.", []string{"access-control"}},
+		{"Controls: data-handling\nMove the exporter.\nControls: change-management\n", "Controls: data-handling\nMove the exporter.", []string{"change-management"}},
+		// Categories outside the taxonomy are not invented.
+		{"Tidy up.\nControls: physical-security, change-management, made-up", "Tidy up.", []string{controlUncategorized, "change-management"}},
+		{"Fix a typo.\nControls: none", "Fix a typo.", nil},
+		{"No controls line.", "No controls line.", []string{controlUncategorized}},
+	}
+	for _, tt := range tests {
+		message, controls := m.Extract(tt.message)
+		if message != tt.want || !reflect.DeepEqual(controls, tt.controls) {
+			t.Errorf("Extract(%q) = %q, %q; want %q, %q", tt.message, message, controls, tt.want, tt.controls)
+		}
+	}
+}
+
+func TestFormatControlMatrix(t *testing.T) {
+	if got := formatControlMatrix([]CommitAuditData{{Hash: "1111111111"}}); got != "" {
+		t.Errorf("matrix without controls = %q", got)
+	}
+	entries := []CommitAuditData{
+		{Hash: "aaaaaaaaaaaa", Controls: []string{controlUncategorized, "data-handling"}},
+		{Hash: "bbbbbbbbbbbb", Controls: []string{"data-handling", "access-control"}},
+		{Hash: "cccccccccccc"},
+	}
+	want := msg("section.controls") + "\n" +
+		"access-control    1  bbbbbbbb\n" +
+		"data-handling     2  aaaaaaaa, bbbbbbbb\n" +
+		"uncategorized     1  aaaaaaaa\n"
+	if got := formatControlMatrix(entries); got != want {
+		t.Errorf("matrix:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestControlsRun(t *testing.T) {
+	repo := newFixtureRepo(t)
+	hashes := repo.commits(4)
+	env := newAuditEnv(t)
+	env.Config["control_taxonomy"] = []controlCategory{
+		{Name: "access-control", Description: "Logins, roles and permissions."},
+		{Name: "data-handling", Description: "Storage and export of customer data."},
+	}
+	answers := map[string]string{
+		"Change 0": "Add the session store.\n\nControls: access-control, Data-Handling",
+		"Change 1": "Add a metrics endpoint.\n\n**Controls:** logging-monitoring",
+		"Change 2": "Refactor the parser.",
+		"Change 3": "Fix a typo.\nControls: none",
+	}
+	env.Ollama.Respond = func(n int, prompt string) (int, string) {
+		for change, answer := range answers {
+			if strings.Contains(prompt, change+"\n") {
+				body, _ := json.Marshal(map[string]any{"response": answer, "done": true})
+				return 200, string(body)
+			}
+		}
+		return 0, ""
+	}
+
+	report := filepath.Join(env.Work, "report.json")
+	env.mustRun("-repo", repo.Dir, "-commit", "root", "-controls", "-format", "json", "-output", report)
+	// The configured taxonomy, descriptions included, is what the model is asked to use.
+	for _, prompt := range env.Ollama.Prompts() {
+		if !strings.Contains(prompt, "  - access-control: Logins, roles and permissions.\n  - data-handling: Storage and export of customer data.") || strings.Contains(prompt, "vendor-management") {
+			t.Errorf("prompt does not carry the configured taxonomy:\n%s", prompt)
+		}
+	}
+	var document jsonReport
+	if err := json.Unmarshal([]byte(readFile(t, report)), &document); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		hashes[0]: {"access-control", "data-handling"},
+		// logging-monitoring is in the default taxonomy, not the configured one.
+		hashes[1]: {controlUncategorized},
+		hashes[2]: {controlUncategorized},
+		hashes[3]: nil,
+	}
+	for _, entry := range document.Commits {
+		if !reflect.DeepEqual(entry.Controls, want[entry.Hash]) {
+			t.Errorf("%s: controls %q, want %q", entry.Hash, entry.Controls, want[entry.Hash])
+		}
+		if strings.Contains(entry.Summary, "Controls") {
+			t.Errorf("%s: summary keeps the Controls line: %q", entry.Hash, entry.Summary)
+		}
+	}
+
+	// The text report tags each entry and ends with the matrix.
+	report = filepath.Join(env.Work, "report.txt")
+	env.mustRun("-repo", repo.Dir, "-commit", "root", "-controls", "-output", report)
+	text := readFile(t, report)
+	entry := text[strings.Index(text, "Commit: "+hashes[0]):]
+	entry, _, _ = strings.Cut(entry, "=== Control matrix ===")
+	if !strings.Contains(entry, "Controls: [access-control] [data-handling]") {
+		t.Errorf("entry of %s:\n%s", hashes[0], entry)
+	}
+	matrix := text[strings.Index(text, "=== Control matrix ==="):]
+	short := func(i int) string { return shortHash(hashes[i]) }
+	for _, line := range []string{
+		"access-control    1  " + short(0),
+		"data-handling     1  " + short(0),
+		"uncategorized     2  " + short(2) + ", " + short(1),
+	} {
+		if !strings.Contains(matrix, line+"\n") {
+			t.Errorf("matrix lacks %q:\n%s", line, matrix)
+		}
+	}
+
+	// Without -controls the model is not asked and the report has no matrix.
+	before := len(env.Ollama.Prompts())
+	env.mustRun("-repo", repo.Dir, "-commit", "root", "-output", report, "-force")
+	for _, prompt := range env.Ollama.Prompts()[before:] {
+		if strings.Contains(prompt, "Controls:") {
+			t.Errorf("prompt asks for controls without -controls:\n%s", prompt)
+		}
+	}
+	if text := readFile(t, report); strings.Contains(text, "Control matrix") || strings.Contains(text, "Controls: [") {
+		t.Errorf("report has controls without -controls:\n%s", text)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+)
+
+// modelPrice is a hosted model's price in US dollars per million tokens.
+type modelPrice struct {
+	InputPerMillion  float64 `json:"input_per_million"`
+	OutputPerMillion float64 `json:"output_per_million"`
+}
+
+// pricingTable maps model names to their prices (the "pricing" config key).
+type pricingTable map[string]modelPrice
+
+// lookup returns the price of model, or nil when the table does not list it.
+func (t pricingTable) lookup(model string) *modelPrice {
+	price, ok := t[model]
+	if !ok {
+		return nil
+	}
+	return &price
+}
+
+// validate rejects negative prices.
+func (t pricingTable) validate() error {
+	for model, price := range t {
+		if price.InputPerMillion < 0 || price.OutputPerMillion < 0 {
+			return fmt.Errorf("pricing for model %s must not be negative", model)
+		}
+	}
+	return nil
+}
+
+// cost returns the price of usage in US dollars; an unpriced (nil) model costs nothing.
+func (p *modelPrice) cost(usage tokenUsage) float64 {
+	if p == nil {
+		return 0
+	}
+	return (float64(usage.PromptTokens)*p.InputPerMillion + float64(usage.OutputTokens)*p.OutputPerMillion) / 1e6
+}
+
+// spendLimit stops a run whose projected cost exceeds the -budget flag. The projection is
+// the amount spent so far plus the average cost of each audited commit times the number of
+// commits still to audit.
+type spendLimit struct {
+	LimitUSD float64
+	Total    int // Commits queued for the run.
+}
+
+// check returns an error when the run should stop, given the amount spent so far and the
+// number of commits audited.
+func (s *spendLimit) check(spent float64, audited int) error {
+	if s == nil {
+		return nil
+	}
+	if spent > s.LimitUSD {
+		return fmt.Errorf("spent %s, more than the budget of %s", formatUSD(spent), formatUSD(s.LimitUSD))
+	}
+	if audited == 0 {
+		return nil
+	}
+	remaining := s.Total - audited
+	projected := spent + spent/float64(audited)*float64(remaining)
+	if projected > s.LimitUSD {
+		return fmt.Errorf("projected cost %s for %d commits exceeds the budget of %s (spent %s on %d commits so far)",
+			formatUSD(projected), s.Total, formatUSD(s.LimitUSD), formatUSD(spent), audited)
+	}
+	return nil
+}
+
+// formatUSD renders a dollar amount with enough precision for per-commit costs.
+func formatUSD(amount float64) string {
+	return fmt.Sprintf("$%.4f", amount)
+}
+
+// stopOverBudget stops the run, like a permanent error, once limit reports that the run is
+// over budget. The entries audited so far are still written.
+func stopOverBudget(limit *spendLimit, g *meteredGenerator, audited int, fatalErr *error) {
+	if *fatalErr != nil {
+		return
+	}
+	if err := limit.check(g.Spent(), audited); err != nil {
+		fmt.Printf("Error: %v; stopping after writing the commits audited so far.\n", err)
+		*fatalErr = err
+		stopRun(err)
+	}
+}
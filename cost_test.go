@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestModelPriceCost(t *testing.T) {
+	price := &modelPrice{InputPerMillion: 3, OutputPerMillion: 15}
+	if got := price.cost(tokenUsage{PromptTokens: 2_000_000, OutputTokens: 100_000}); math.Abs(got-7.5) > 1e-9 {
+		t.Errorf("cost = %v, want 7.5", got)
+	}
+	var unpriced *modelPrice
+	if got := unpriced.cost(tokenUsage{PromptTokens: 1000, OutputTokens: 1000}); got != 0 {
+		t.Errorf("unpriced cost = %v", got)
+	}
+	table := pricingTable{"claude-test": {InputPerMillion: 3, OutputPerMillion: 15}}
+	if table.lookup("claude-test") == nil || table.lookup("other") != nil {
+		t.Error("lookup does not follow the table")
+	}
+	if err := (pricingTable{"m": {InputPerMillion: -1}}).validate(); err == nil {
+		t.Error("negative price accepted")
+	}
+}
+
+func TestSpendLimitCheck(t *testing.T) {
+	limit := &spendLimit{LimitUSD: 1, Total: 10}
+	tests := []struct {
+		spent   float64
+		audited int
+		stop    bool
+	}{
+		{0, 0, false},
+		{0.05, 1, false},   // Projected 0.50.
+		{0.1, 1, false},    // Projected exactly the budget.
+		{0.11, 1, true},    // Projected 1.10.
+		{0.5, 9, false},    // Projected 0.56.
+		{0.95, 9, true},    // Projected 1.06.
+		{1.01, 10, true},   // Over the budget outright.
+		{1.5, 0, true},     // Spent on a commit still in flight.
+		{0.999, 10, false}, // Nothing left to audit.
+	}
+	for _, tt := range tests {
+		if err := limit.check(tt.spent, tt.audited); (err != nil) != tt.stop {
+			t.Errorf("check(%v, %d) = %v, want stop %v", tt.spent, tt.audited, err, tt.stop)
+		}
+	}
+	var none *spendLimit
+	if err := none.check(100, 1); err != nil {
+		t.Errorf("nil limit stopped the run: %v", err)
+	}
+}
+
+// fakeAnthropic is an Anthropic Messages API whose nth call reports the usage Usage returns.
+type fakeAnthropic struct {
+	*httptest.Server
+	mu    sync.Mutex
+	calls int
+	Usage func(n int) tokenUsage
+}
+
+func newFakeAnthropic(t *testing.T, usage func(n int) tokenUsage) *fakeAnthropic {
+	t.Helper()
+	f := &fakeAnthropic{Usage: usage}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		f.calls++
+		n := f.calls
+		f.mu.Unlock()
+		u := f.Usage(n)
+		fmt.Fprintf(w, `{"type":"message","content":[{"type":"text","text":"Summary of call %d."}],"stop_reason":"end_turn","usage":{"input_tokens":%d,"output_tokens":%d}}`, n, u.PromptTokens, u.OutputTokens)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// useAnthropic points env at a fake Anthropic server with claude-test priced at $3 and $15
+// per million input and output tokens.
+func useAnthropic(env *auditEnv, server *fakeAnthropic) {
+	env.Config["provider"] = "anthropic"
+	env.Config["anthropic"] = map[string]any{"api_key_env": "TEST_ANTHROPIC_KEY", "model": "claude-test", "endpoint": server.URL}
+	env.Config["pricing"] = map[string]any{"claude-test": map[string]float64{"input_per_million": 3, "output_per_million": 15}}
+	env.Env = append(env.Env, "TEST_ANTHROPIC_KEY=sk-test")
+}
+
+func TestBudgetAbortsMidRun(t *testing.T) {
+	repo := newFixtureRepo(t)
+	repo.commits(10)
+	// The first three commits are cheap ($0.0018 each); from the fourth on, each costs $0.3015.
+	server := newFakeAnthropic(t, func(n int) tokenUsage {
+		if n <= 3 {
+			return tokenUsage{PromptTokens: 100, OutputTokens: 100}
+		}
+		return tokenUsage{PromptTokens: 100_000, OutputTokens: 100}
+	})
+	env := newAuditEnv(t)
+	useAnthropic(env, server)
+	report := filepath.Join(env.Work, "gitaudit.json")
+
+	out, code := env.run("-repo", repo.Dir, "-commit", "root", "-format", "json", "-output", report, "-budget", "0.5")
+	if code == 0 {
+		t.Errorf("over-budget run exited with 0:\n%s", out)
+	}
+	if !strings.Contains(out, "Spending Limit: $0.5000") || !strings.Contains(out, "Error: projected cost") {
+		t.Errorf("run did not stop for the budget:\n%s", out)
+	}
+	// After the fourth commit, $0.3069 spent projects to $0.7673 for ten.
+	if !strings.Contains(out, "spent $0.3069 on 4 commits so far") {
+		t.Errorf("run stopped at the wrong commit:\n%s", out)
+	}
+
+	var document jsonReport
+	if err := json.Unmarshal([]byte(readFile(t, report)), &document); err != nil {
+		t.Fatal(err)
+	}
+	if len(document.Commits) != 4 || !document.Partial {
+		t.Fatalf("report has %d entries (partial %v), want the 4 audited before the stop", len(document.Commits), document.Partial)
+	}
+	// Whichever commit got the fourth call is the expensive one.
+	var sum float64
+	expensive := 0
+	for _, entry := range document.Commits {
+		switch {
+		case entry.Usage == nil:
+			t.Errorf("entry %s has no usage", entry.Hash)
+		case math.Abs(entry.CostUSD-0.3015) < 1e-9:
+			expensive++
+		case math.Abs(entry.CostUSD-0.0018) > 1e-9:
+			t.Errorf("entry %s costs %v", entry.Hash, entry.CostUSD)
+		}
+		sum += entry.CostUSD
+	}
+	if expensive != 1 {
+		t.Errorf("%d entries at $0.3015, want 1", expensive)
+	}
+	if math.Abs(document.CostUSD-sum) > 1e-9 || document.Usage == nil || document.Usage.PromptTokens != 100_300 {
+		t.Errorf("report total %v (usage %v), want %v", document.CostUSD, document.Usage, sum)
+	}
+	if server.calls != 4 {
+		t.Errorf("%d calls to the provider, want 4", server.calls)
+	}
+}
+
+func TestBudgetNotReached(t *testing.T) {
+	repo := newFixtureRepo(t)
+	repo.commits(5)
+	server := newFakeAnthropic(t, func(n int) tokenUsage { return tokenUsage{PromptTokens: 1000, OutputTokens: 200} })
+	env := newAuditEnv(t)
+	useAnthropic(env, server)
+	out := env.mustRun("-repo", repo.Dir, "-commit", "root", "-output", filepath.Join(env.Work, "report.txt"), "-budget", "1")
+	// Each commit costs $0.006.
+	if !strings.Contains(out, "Cost: $0.0300") || !strings.Contains(out, "Token usage: 5,000 prompt + 1,000 output tokens over 5 Anthropic calls") {
+		t.Errorf("run did not account for its cost:\n%s", out)
+	}
+}
+
+func TestOllamaRunTracksTokensAtNoCost(t *testing.T) {
+	repo := newFixtureRepo(t)
+	repo.commits(3)
+	env := newAuditEnv(t)
+	report := filepath.Join(env.Work, "gitaudit.json")
+	out := env.mustRun("-repo", repo.Dir, "-commit", "root", "-format", "json", "-output", report, "-budget", "0.01")
+	if !strings.Contains(out, "Token usage: ") || strings.Contains(out, "Cost: ") || strings.Contains(out, "Spending Limit") {
+		t.Errorf("local run output:\n%s", out)
+	}
+	var document jsonReport
+	if err := json.Unmarshal([]byte(readFile(t, report)), &document); err != nil {
+		t.Fatal(err)
+	}
+	if document.Usage == nil || document.Usage.OutputTokens != 30 || document.CostUSD != 0 {
+		t.Errorf("report usage %v, cost %v; want 30 output tokens at no cost", document.Usage, document.CostUSD)
+	}
+	for _, entry := range document.Commits {
+		if entry.CostUSD != 0 || entry.Usage == nil || entry.Usage.OutputTokens != 10 {
+			t.Errorf("entry %s: cost %v, usage %v", entry.Hash, entry.CostUSD, entry.Usage)
+		}
+	}
+}
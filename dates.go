@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Values of -date-source.
+const (
+	dateSourceAuthor = "author"
+	dateSourceCommit = "commit"
+)
+
+// commitMetadata is the identifying information of a commit shown in its entry. The two
+// dates differ for rebased, amended and cherry-picked commits: AuthorDate is when the change
+// was first written, CommitDate when it was last committed (and so entered the history).
+type commitMetadata struct {
+	Hash       string
+	Author     string
+	AuthorDate string
+	CommitDate string
+	// Parents are the commit's parent hashes, first parent first; none for a root commit.
+	Parents []string
+}
+
+// date returns the date selected by -date-source.
+func (m commitMetadata) date(source string) string {
+	if source == dateSourceCommit {
+		return m.CommitDate
+	}
+	return m.AuthorDate
+}
+
+// dateRange is the -since/-until window as Unix times; a zero bound is open.
+type dateRange struct {
+	Since int64
+	Until int64
+}
+
+// parseDateRange converts -since and -until to Unix times with git's own date parser, so
+// they accept everything git log does ("2024-03-01", "2 weeks ago", "yesterday").
+func parseDateRange(repoPath, since, until string) (dateRange, error) {
+	var r dateRange
+	var args []string
+	if since != "" {
+		args = append(args, "--since="+since)
+	}
+	if until != "" {
+		args = append(args, "--until="+until)
+	}
+	if len(args) == 0 {
+		return r, nil
+	}
+	output, err := gitRun(context.Background(), repoPath, append([]string{"rev-parse"}, args...)...)
+	if err != nil {
+		return r, fmt.Errorf("failed to parse -since/-until: %w", err)
+	}
+	for _, field := range strings.Fields(string(output)) {
+		name, value, _ := strings.Cut(field, "=")
+		t, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return r, fmt.Errorf("unexpected output from git rev-parse for -since/-until: %q", field)
+		}
+		switch name {
+		case "--max-age":
+			r.Since = t
+		case "--min-age":
+			r.Until = t
+		}
+	}
+	return r, nil
+}
+
+// filterByDate keeps the commits whose -date-source date falls inside r, in their original
+// order. Every commit is checked, so a commit whose dates are out of order with its parents'
+// is neither lost nor lets older commits through.
+func filterByDate(repoPath string, hashes []string, r dateRange, source string) ([]string, error) {
+	if r == (dateRange{}) || len(hashes) == 0 {
+		return hashes, nil
+	}
+	input := strings.NewReader(strings.Join(hashes, "\n") + "\n")
+	output, err := gitRunInput(context.Background(), repoPath, input, "log", "--no-walk=unsorted", "--stdin", "--format=%H %at %ct")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read commit dates: %w", err)
+	}
+
+	var kept []string
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		timestamp := fields[1]
+		if source == dateSourceCommit {
+			timestamp = fields[2]
+		}
+		t, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected date %q for commit %s", timestamp, fields[0])
+		}
+		if (r.Since != 0 && t < r.Since) || (r.Until != 0 && t > r.Until) {
+			continue
+		}
+		kept = append(kept, fields[0])
+	}
+	return kept, nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rebasedRepo is a history with a commit written on February 10 that was rebased onto main,
+// and so landed, on March 20.
+type rebasedRepo struct {
+	*fixtureRepo
+	Root, March1, March5, Rebased, March25 string
+}
+
+func newRebasedRepo(t *testing.T) *rebasedRepo {
+	t.Helper()
+	repo := &rebasedRepo{fixtureRepo: newFixtureRepo(t)}
+	day := func(month time.Month, d int) time.Time { return time.Date(2024, month, d, 12, 0, 0, 0, time.UTC) }
+	repo.When = day(time.February, 1)
+	repo.Root = repo.commit("Start the project", map[string]string{"README.md": "app\n"})
+	repo.git("checkout", "-q", "-b", "exporter")
+	repo.When = day(time.February, 10)
+	repo.commit("Add the CSV exporter", map[string]string{"export.go": "package app\n"})
+	repo.git("checkout", "-q", "main")
+	repo.When = day(time.March, 1)
+	repo.March1 = repo.commit("Add the importer", map[string]string{"import.go": "package app\n"})
+	repo.When = day(time.March, 5)
+	repo.March5 = repo.commit("Fix the importer", map[string]string{"import.go": "package app\n\n// Fixed.\n"})
+	// The rebase keeps the author date and commits at When.
+	repo.When = day(time.March, 20)
+	repo.git("checkout", "-q", "exporter")
+	repo.git("rebase", "-q", "main")
+	repo.Rebased = repo.git("rev-parse", "HEAD")
+	repo.git("checkout", "-q", "main")
+	repo.git("merge", "-q", "--ff-only", "exporter")
+	repo.When = day(time.March, 25)
+	repo.March25 = repo.commit("Document the exporter", map[string]string{"README.md": "app\n\nExports CSV.\n"})
+	return repo
+}
+
+func TestCommitMetadataDates(t *testing.T) {
+	repo := newRebasedRepo(t)
+	metadata, err := getCommitMetadata(context.Background(), repo.Dir, repo.Rebased)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.AuthorDate != "2024-02-10 12:00:00 +0000" || metadata.CommitDate != "2024-03-20 12:00:00 +0000" {
+		t.Fatalf("dates of the rebased commit: author %s, commit %s", metadata.AuthorDate, metadata.CommitDate)
+	}
+	if metadata.date(dateSourceAuthor) != metadata.AuthorDate || metadata.date(dateSourceCommit) != metadata.CommitDate {
+		t.Error("date does not follow the source")
+	}
+	if !reflect.DeepEqual(metadata.Parents, []string{repo.March5}) {
+		t.Errorf("parents %v, want the rebased-onto %s", metadata.Parents, repo.March5)
+	}
+}
+
+func TestFilterByDate(t *testing.T) {
+	repo := newRebasedRepo(t)
+	all, err := getCommitHashes(context.Background(), repo.Dir, repo.March25, repo.Root, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		since, until string
+		source       string
+		want         []string
+	}{
+		// What entered the repository in the second half of March.
+		{"2024-03-15", "", dateSourceCommit, []string{repo.March25, repo.Rebased}},
+		{"2024-03-15", "", dateSourceAuthor, []string{repo.March25}},
+		// What was written in February.
+		{"2024-02-05", "2024-02-28", dateSourceAuthor, []string{repo.Rebased}},
+		{"2024-02-05", "2024-02-28", dateSourceCommit, nil},
+		{"", "2024-03-02", dateSourceCommit, []string{repo.March1, repo.Root}},
+		{"", "2024-03-02", dateSourceAuthor, []string{repo.Rebased, repo.March1, repo.Root}},
+	}
+	for _, tt := range tests {
+		window, err := parseDateRange(repo.Dir, tt.since, tt.until)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := filterByDate(repo.Dir, all, window, tt.source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("-since %q -until %q by %s date: %v, want %v", tt.since, tt.until, tt.source, got, tt.want)
+		}
+		// By commit date the window is the one git rev-list applies.
+		if tt.source == dateSourceCommit {
+			args := []string{"rev-list", repo.March25}
+			if tt.since != "" {
+				args = append(args, "--since="+tt.since)
+			}
+			if tt.until != "" {
+				args = append(args, "--until="+tt.until)
+			}
+			// rev-list stops at the first commit older than --since; this history's commit
+			// dates are in order, so it sees the same commits.
+			if revList := strings.Fields(repo.git(args...)); len(revList)+len(tt.want) > 0 && !reflect.DeepEqual(revList, tt.want) {
+				t.Errorf("git %s = %v, want %v", strings.Join(args, " "), revList, tt.want)
+			}
+		}
+	}
+	if got, err := filterByDate(repo.Dir, all, dateRange{}, dateSourceAuthor); err != nil || !reflect.DeepEqual(got, all) {
+		t.Errorf("open window dropped commits: %v, %v", got, err)
+	}
+}
+
+func TestDateSourceRun(t *testing.T) {
+	repo := newRebasedRepo(t)
+	env := newAuditEnv(t)
+
+	// By commit date, the rebased commit landed in the second half of March.
+	report := filepath.Join(env.Work, "march.json")
+	out := env.mustRun("-repo", repo.Dir, "-since", "2024-03-15", "-date-source", "commit", "-format", "json", "-output", report)
+	if !strings.Contains(out, "2 of 5 commits fall within -since/-until by commit date") {
+		t.Errorf("run output:\n%s", out)
+	}
+	var document jsonReport
+	if err := json.Unmarshal([]byte(readFile(t, report)), &document); err != nil {
+		t.Fatal(err)
+	}
+	if len(document.Commits) != 2 {
+		t.Fatalf("report has %d entries, want 2", len(document.Commits))
+	}
+	rebased := document.Commits[1]
+	if rebased.Hash != repo.Rebased || rebased.Date != "2024-03-20 12:00:00 +0000" ||
+		rebased.AuthorDate != "2024-02-10 12:00:00 +0000" || rebased.CommitDate != "2024-03-20 12:00:00 +0000" {
+		t.Errorf("rebased entry: %s date %s, author date %s, commit date %s", rebased.Hash, rebased.Date, rebased.AuthorDate, rebased.CommitDate)
+	}
+	// Both dates are always in the JSON, whichever drives the date.
+	data := readFile(t, report)
+	if strings.Count(data, `"author_date"`) != 2 || strings.Count(data, `"commit_date"`) != 2 {
+		t.Errorf("JSON lacks a date field:\n%s", data)
+	}
+
+	// By author date, it was written in February; the text entry shows its other date.
+	report = filepath.Join(env.Work, "february.txt")
+	out = env.mustRun("-repo", repo.Dir, "-since", "2024-02-05", "-until", "2024-02-28", "-output", report)
+	if !strings.Contains(out, "1 of 5 commits fall within -since/-until by author date") {
+		t.Errorf("run output:\n%s", out)
+	}
+	text := readFile(t, report)
+	if got := reportCommits(text); !reflect.DeepEqual(got, []string{repo.Rebased}) {
+		t.Errorf("February report has %v", got)
+	}
+	if !strings.Contains(text, "Date: 2024-02-10") || !strings.Contains(text, "Commit date: 2024-03-20") {
+		t.Errorf("rebased entry does not show both dates:\n%s", text)
+	}
+
+	// Monthly shards follow the source: the rebased commit is March's by commit date.
+	split := filepath.Join(env.Work, "split.txt")
+	env.mustRun("-repo", repo.Dir, "-commit", "root", "-date-source", "commit", "-split-by", "month", "-output", split)
+	march := readFile(t, filepath.Join(env.Work, "split-2024-03.txt"))
+	if !strings.Contains(march, "Commit: "+repo.Rebased) || !strings.Contains(march, "Author date: 2024-02-10") {
+		t.Errorf("March shard lacks the rebased commit:\n%s", march)
+	}
+	if february, err := os.ReadFile(filepath.Join(env.Work, "split-2024-02.txt")); err != nil || strings.Contains(string(february), repo.Rebased) {
+		t.Errorf("February shard: %v\n%s", err, february)
+	}
+}
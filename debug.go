@@ -0,0 +1,13 @@
+package main
+
+import "fmt"
+
+// debugEnabled is set by the -debug flag.
+var debugEnabled bool
+
+// debugf prints a diagnostic line when -debug is set.
+func debugf(format string, args ...any) {
+	if debugEnabled {
+		fmt.Printf("[debug] "+format+"\n", args...)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Detail levels for the adaptive retry ladder. detailFull is the normal, undegraded prompt.
+const (
+	detailFull             = "full"
+	detailReducedContext   = "reduced-context"
+	detailElideLowPriority = "elide-low-priority"
+	detailStatsOnly        = "stats-only"
+)
+
+// defaultDegradationLadder is used when the config does not set degradation_ladder.
+var defaultDegradationLadder = []string{detailReducedContext, detailElideLowPriority, detailStatsOnly}
+
+// lowPriorityGlobs are files whose diffs add bulk but rarely matter to a summary; they are
+// elided first when a prompt has to shrink.
+var lowPriorityGlobs = []string{
+	"go.sum", "package-lock.json", "yarn.lock", "pnpm-lock.yaml", "Cargo.lock", "poetry.lock",
+	"composer.lock", "Gemfile.lock", "vendor/", "node_modules/", "third_party/", "testdata/",
+	"*.min.js", "*.min.css", "*.map", "*.svg", "*.pb.go", "*_generated.go", "*.generated.*",
+}
+
+// reducedContextLines is the number of context lines kept around each hunk by the
+// reduced-context step, instead of git's default of three.
+const reducedContextLines = 1
+
+// validateDegradationLadder checks that every step of a configured ladder is known.
+func validateDegradationLadder(ladder []string) error {
+	for _, step := range ladder {
+		switch step {
+		case detailReducedContext, detailElideLowPriority, detailStatsOnly:
+		default:
+			return fmt.Errorf("unknown degradation_ladder step %q (valid steps: %s, %s, %s)",
+				step, detailReducedContext, detailElideLowPriority, detailStatsOnly)
+		}
+	}
+	return nil
+}
+
+// commitState tracks the processing history of a single commit across retry passes.
+type commitState struct {
+	// Failures counts every failed attempt.
+	Failures int
+	// LengthFailures counts consecutive failures that suggest the prompt is too large.
+	LengthFailures int
+	// Level is the number of degradation ladder steps applied to the next attempt.
+	Level int
+	// LastError is the error of the latest failed attempt.
+	LastError error
+	// LastPass is the pass the latest failure happened in; 0 is the initial pass.
+	LastPass int
+	// RetryAt is the earliest time of the next attempt, after the cool-down of the last failure.
+	RetryAt time.Time
+	// Retries counts the failed retries with the current model, which -max-retries bounds.
+	Retries int
+}
+
+// handOff prepares the state for the -retry-model. Its first attempt starts one step down the
+// ladder, for a shorter prompt, and without the cool-down of the configured model's failure.
+func (s *commitState) handOff(ladder []string) {
+	if s.Level == 0 && len(ladder) > 0 {
+		s.Level = 1
+	}
+	s.LengthFailures = 0
+	s.RetryAt = time.Time{}
+	s.Retries = 0
+}
+
+// detailLevel returns the ladder step the next attempt should use.
+func (s *commitState) detailLevel(ladder []string) string {
+	if s == nil || s.Level == 0 || len(ladder) == 0 {
+		return detailFull
+	}
+	return ladder[min(s.Level, len(ladder))-1]
+}
+
+// recordFailure updates the state after a failed attempt and moves the commit one step down
+// the ladder once it has failed degradeAfter times in a row for length-related reasons.
+func (s *commitState) recordFailure(commitHash string, err error, ladder []string, degradeAfter int) {
+	s.Failures++
+	if !isLengthFailure(err) {
+		s.LengthFailures = 0
+		return
+	}
+	s.LengthFailures++
+	if degradeAfter > 0 && s.LengthFailures >= degradeAfter && s.Level < len(ladder) {
+		s.Level++
+		s.LengthFailures = 0
+		fmt.Printf("Commit %s failed %d times with errors suggesting the prompt is too large; degrading the next attempt to %q.\n",
+			commitHash, degradeAfter, ladder[s.Level-1])
+	}
+}
+
+// isLengthFailure reports whether err suggests that the prompt was too large for the model:
+// timeouts, context-length errors from Ollama, or an empty generation.
+func isLengthFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, errEmptyResponse) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	if strings.Contains(msg, "context canceled") {
+		return false
+	}
+	for _, needle := range []string{"context", "too long", "too large", "exceeds", "deadline exceeded"} {
+		if strings.Contains(msg, needle) {
+			return true
+		}
+	}
+	return false
+}
+
+// detailSettings are the cumulative effects of the ladder steps up to and including a level.
+type detailSettings struct {
+	ReducedContext   bool
+	ElideLowPriority bool
+	StatsOnly        bool
+}
+
+// detailSettingsFor returns the cumulative settings for a named ladder step, so that e.g.
+// elide-low-priority also keeps the reduced context of the step before it.
+func detailSettingsFor(ladder []string, level string) detailSettings {
+	var settings detailSettings
+	if level == detailFull {
+		return settings
+	}
+	for _, step := range ladder {
+		switch step {
+		case detailReducedContext:
+			settings.ReducedContext = true
+		case detailElideLowPriority:
+			settings.ElideLowPriority = true
+		case detailStatsOnly:
+			settings.StatsOnly = true
+		}
+		if step == level {
+			break
+		}
+	}
+	return settings
+}
+
+// elisionNote is the prompt hint used when low-priority files were elided.
+func elisionNote(count int) string {
+	if count == 1 {
+		return "1 low-priority file (lockfile, generated or vendored code) was elided from the patch to keep it small."
+	}
+	return fmt.Sprintf("%d low-priority files (lockfiles, generated or vendored code) were elided from the patch to keep it small.", count)
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Ecosystems of the dependency files gitaudit understands, named as OSV.dev names them.
+const (
+	ecosystemGo    = "Go"
+	ecosystemNPM   = "npm"
+	ecosystemPyPI  = "PyPI"
+	ecosystemCargo = "crates.io"
+)
+
+// dependencyFiles are the pathspecs of the manifests and lockfiles read for dependency changes.
+// go.sum is not read: every version change it records is also a go.mod change.
+var dependencyFiles = []string{
+	":(glob)**/go.mod",
+	":(glob)**/package.json",
+	":(glob)**/package-lock.json",
+	":(glob)**/requirements*.txt",
+	":(glob)**/Cargo.toml",
+}
+
+// dependencyChange is one dependency whose version a commit changed. Old is empty for a
+// dependency the commit adds, and New for one it removes.
+type dependencyChange struct {
+	Ecosystem string `json:"ecosystem"`
+	Name      string `json:"name"`
+	Old       string `json:"old,omitempty"`
+	New       string `json:"new,omitempty"`
+	// Transitive is true for changes found only in a lockfile, i.e. not made to a manifest.
+	Transitive bool `json:"transitive,omitempty"`
+}
+
+func (c dependencyChange) String() string {
+	return fmt.Sprintf("%s %s → %s", c.Name, c.Old, c.New)
+}
+
+// packageJSONDependency matches a dependency line of a package.json diff.
+var packageJSONDependency = regexp.MustCompile(`^([-+])\s*"([^"]+)":\s*"([^"]+)",?\s*$`)
+
+// goModRequirement matches a requirement line of a go.mod diff, inside or outside a
+// require block.
+var goModRequirement = regexp.MustCompile(`^([-+])\s*(?:require\s+)?([^\s()]+)\s+(v\S+)`)
+
+// requirementsPin matches a pinned requirement line of a requirements.txt diff, e.g.
+// "+requests[socks]==2.31.0 ; python_version >= '3.8'". Unpinned requirements carry no version.
+var requirementsPin = regexp.MustCompile(`^([-+])\s*([A-Za-z0-9][A-Za-z0-9._-]*)(?:\[[^\]]*\])?\s*==\s*([^\s;#]+)`)
+
+// diffFileHeader matches the first line of each file in a diff.
+var diffFileHeader = regexp.MustCompile(`^diff --git a/(.+) b/(.+)$`)
+
+// parseDependencyLine reads the sign, name and version from a changed line of the given
+// manifest file. ok is false for lines that are not dependency versions.
+func parseDependencyLine(file, line string) (sign, ecosystem, name, version string, ok bool) {
+	switch base := path.Base(file); {
+	case base == "package.json":
+		m := packageJSONDependency.FindStringSubmatch(line)
+		if m == nil || m[2] == "version" || m[2] == "name" {
+			return "", "", "", "", false
+		}
+		return m[1], ecosystemNPM, m[2], strings.TrimLeft(m[3], "^~="), true
+	case base == "go.mod":
+		m := goModRequirement.FindStringSubmatch(line)
+		if m == nil || m[2] == "module" || m[2] == "go" || m[2] == "toolchain" {
+			return "", "", "", "", false
+		}
+		return m[1], ecosystemGo, m[2], m[3], true
+	case strings.HasPrefix(base, "requirements") && strings.HasSuffix(base, ".txt"):
+		m := requirementsPin.FindStringSubmatch(line)
+		if m == nil {
+			return "", "", "", "", false
+		}
+		return m[1], ecosystemPyPI, m[2], m[3], true
+	}
+	return "", "", "", "", false
+}
+
+// getDependencyChanges returns the dependency version changes a commit makes to go.mod,
+// package.json, package-lock.json, requirements*.txt and Cargo.toml files, in the order the
+// dependencies appear in the diff. Added and removed dependencies are not changes. Where a
+// lockfile pins the exact versions of a manifest change, those versions are used.
+func getDependencyChanges(ctx context.Context, repoPath, commitHash string) ([]dependencyChange, error) {
+	diff, err := getDependencyDiff(ctx, repoPath, commitHash, false)
+	if err != nil {
+		return nil, err
+	}
+	var changes []dependencyChange
+	for _, change := range diff {
+		if change.Old != "" && change.New != "" {
+			changes = append(changes, change)
+		}
+	}
+	return changes, nil
+}
+
+// getDependencyDiff is getDependencyChanges including the dependencies the commit adds to or
+// removes from a manifest. With firstParent, a merge is compared with its first parent;
+// otherwise `git show` prints a combined diff for it, which has no changes to read.
+func getDependencyDiff(ctx context.Context, repoPath, commitHash string, firstParent bool) ([]dependencyChange, error) {
+	args := []string{"show", "--format=", "--unified=0", "--no-color"}
+	if firstParent {
+		args = append(args, "-m", "--first-parent")
+	}
+	args = append(append(args, commitHash, "--"), dependencyFiles...)
+	output, err := gitRun(ctx, repoPath, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute git show for dependency files of commit %s: %w", commitHash, err)
+	}
+
+	var order []string
+	var lockfiles, cargoFiles []string
+	before := make(map[string]string)
+	after := make(map[string]string)
+	ecosystems := make(map[string]string)
+	files := make(map[string]string)
+	var file string
+	for _, line := range strings.Split(string(output), "\n") {
+		if m := diffFileHeader.FindStringSubmatch(line); m != nil {
+			file = m[2]
+			switch path.Base(file) {
+			case "package-lock.json":
+				lockfiles = append(lockfiles, file)
+			case "Cargo.toml":
+				cargoFiles = append(cargoFiles, file)
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "---") || strings.HasPrefix(line, "+++") {
+			continue
+		}
+		sign, ecosystem, name, version, ok := parseDependencyLine(file, line)
+		if !ok {
+			continue
+		}
+		key := ecosystem + "\x00" + name
+		if _, seen := ecosystems[key]; !seen {
+			ecosystems[key] = ecosystem
+			files[key] = file
+			order = append(order, key)
+		}
+		if sign == "-" {
+			before[key] = version
+		} else {
+			after[key] = version
+		}
+	}
+
+	var changes []dependencyChange
+	index := make(map[string]int)
+	declared := make(map[string]map[string]bool)
+	for _, key := range order {
+		old, hadOld := before[key]
+		updated, hasNew := after[key]
+		if hadOld && hasNew && old == updated {
+			continue
+		}
+		_, name, _ := strings.Cut(key, "\x00")
+		if ecosystems[key] == ecosystemNPM {
+			// Without context lines, a script or config entry of package.json looks like a
+			// dependency; only names the file declares as dependencies count.
+			object := commitHash + ":" + files[key]
+			if !hasNew {
+				object = commitHash + "^:" + files[key]
+			}
+			if _, ok := declared[object]; !ok {
+				declared[object] = readPackageDependencies(ctx, repoPath, object)
+			}
+			if !declared[object][name] {
+				continue
+			}
+		}
+		index[key] = len(changes)
+		changes = append(changes, dependencyChange{Ecosystem: ecosystems[key], Name: name, Old: old, New: updated})
+	}
+
+	for _, lockfile := range lockfiles {
+		locked, err := getLockfileChanges(ctx, repoPath, commitHash, lockfile)
+		if err != nil {
+			return nil, err
+		}
+		for _, change := range locked {
+			key := change.Ecosystem + "\x00" + change.Name
+			if i, ok := index[key]; ok {
+				if changes[i].Old != "" && changes[i].New != "" {
+					changes[i].Old, changes[i].New = change.Old, change.New
+				}
+				continue
+			}
+			index[key] = len(changes)
+			change.Transitive = true
+			changes = append(changes, change)
+		}
+	}
+
+	for _, file := range cargoFiles {
+		cargo, err := getCargoChanges(ctx, repoPath, commitHash, file)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, cargo...)
+	}
+	return changes, nil
+}
+
+// packageManifest is the part of a package.json that declares dependencies.
+type packageManifest struct {
+	Dependencies         map[string]string `json:"dependencies"`
+	DevDependencies      map[string]string `json:"devDependencies"`
+	PeerDependencies     map[string]string `json:"peerDependencies"`
+	OptionalDependencies map[string]string `json:"optionalDependencies"`
+}
+
+// readPackageDependencies returns the names a package.json blob declares as dependencies of
+// any kind, or nil when the blob cannot be read.
+func readPackageDependencies(ctx context.Context, repoPath, object string) map[string]bool {
+	output, err := gitRun(ctx, repoPath, "cat-file", "blob", object)
+	if err != nil {
+		return nil
+	}
+	var manifest packageManifest
+	if json.Unmarshal(output, &manifest) != nil {
+		return nil
+	}
+	names := make(map[string]bool)
+	for _, deps := range []map[string]string{manifest.Dependencies, manifest.DevDependencies, manifest.PeerDependencies, manifest.OptionalDependencies} {
+		for name := range deps {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// cargoSection matches a TOML table header, e.g. "[dev-dependencies]" or "[dependencies.serde]".
+var cargoSection = regexp.MustCompile(`^\[([^\[\]]+)\]\s*(?:#.*)?$`)
+
+// cargoKeyValue matches a "key = value" line of a TOML table.
+var cargoKeyValue = regexp.MustCompile(`^("[^"]+"|[A-Za-z0-9_-]+)\s*=\s*(.+)$`)
+
+// cargoVersion matches the version of an inline dependency table, e.g.
+// serde = { version = "1.0", features = ["derive"] }.
+var cargoVersion = regexp.MustCompile(`\bversion\s*=\s*"([^"]*)"`)
+
+// isCargoDependencyTable reports whether a TOML table lists dependencies: [dependencies],
+// [dev-dependencies], [build-dependencies], and their [target.'cfg(...)'] and [workspace] forms.
+func isCargoDependencyTable(table string) bool {
+	for _, kind := range []string{"dependencies", "dev-dependencies", "build-dependencies"} {
+		if table == kind || strings.HasSuffix(table, "."+kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// cargoDependencies maps the dependencies a Cargo.toml declares with a version to that
+// version. Dependencies given only by path or git carry no version and are left out.
+func cargoDependencies(manifest string) map[string]string {
+	deps := make(map[string]string)
+	inTable := false
+	crate := "" // The dependency of a [dependencies.<crate>] table.
+	for _, line := range strings.Split(manifest, "\n") {
+		line = strings.TrimSpace(line)
+		if m := cargoSection.FindStringSubmatch(line); m != nil {
+			table := strings.TrimSpace(m[1])
+			inTable, crate = isCargoDependencyTable(table), ""
+			if i := strings.LastIndex(table, "."); !inTable && i > 0 && isCargoDependencyTable(table[:i]) {
+				crate = strings.Trim(table[i+1:], `"`)
+			}
+			continue
+		}
+		m := cargoKeyValue.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		key, value := strings.Trim(m[1], `"`), strings.TrimSpace(m[2])
+		switch {
+		case crate != "" && key == "version":
+			deps[crate] = strings.TrimLeft(strings.Trim(value, `"`), "^~=")
+		case inTable && strings.HasPrefix(value, `"`):
+			deps[key] = strings.TrimLeft(strings.Trim(strings.SplitN(value, "#", 2)[0], `" `), "^~=")
+		case inTable && strings.HasPrefix(value, "{"):
+			if v := cargoVersion.FindStringSubmatch(value); v != nil {
+				deps[key] = strings.TrimLeft(v[1], "^~=")
+			}
+		}
+	}
+	return deps
+}
+
+// getCargoChanges compares the dependencies of the Cargo.toml at file before and after a
+// commit. A line diff cannot tell which table a "name = version" line is in, so both
+// versions of the file are read whole.
+func getCargoChanges(ctx context.Context, repoPath, commitHash, file string) ([]dependencyChange, error) {
+	read := func(object string) (map[string]string, error) {
+		if !objectExists(ctx, repoPath, object) {
+			return nil, nil
+		}
+		output, err := gitRun(ctx, repoPath, "cat-file", "blob", object)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", object, err)
+		}
+		return cargoDependencies(string(output)), nil
+	}
+	oldDeps, err := read(commitHash + "^:" + file)
+	if err != nil {
+		return nil, err
+	}
+	newDeps, err := read(commitHash + ":" + file)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	for name := range oldDeps {
+		names[name] = true
+	}
+	for name := range newDeps {
+		names[name] = true
+	}
+	var changes []dependencyChange
+	for name := range names {
+		if oldDeps[name] != newDeps[name] {
+			changes = append(changes, dependencyChange{Ecosystem: ecosystemCargo, Name: name, Old: oldDeps[name], New: newDeps[name]})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+	return changes, nil
+}
+
+// packageLock is the part of a package-lock.json that records installed versions: the
+// "packages" map of lockfile versions 2 and 3, or the "dependencies" map of version 1.
+type packageLock struct {
+	Packages map[string]struct {
+		Version string `json:"version"`
+	} `json:"packages"`
+	Dependencies map[string]struct {
+		Version string `json:"version"`
+	} `json:"dependencies"`
+}
+
+// versions maps each installed package to its version. A package installed at several
+// versions (nested under different parents) keeps the one closest to the root.
+func (l packageLock) versions() map[string]string {
+	versions := make(map[string]string)
+	depth := make(map[string]int)
+	for location, pkg := range l.Packages {
+		i := strings.LastIndex(location, "node_modules/")
+		if i < 0 || pkg.Version == "" {
+			continue // The root project itself, or a workspace link.
+		}
+		name := location[i+len("node_modules/"):]
+		d := strings.Count(location, "node_modules/")
+		if current, ok := depth[name]; !ok || d < current {
+			versions[name], depth[name] = pkg.Version, d
+		}
+	}
+	if len(l.Packages) == 0 {
+		for name, dep := range l.Dependencies {
+			versions[name] = dep.Version
+		}
+	}
+	return versions
+}
+
+// getLockfileChanges compares the package-lock.json at file before and after a commit. A
+// lockfile the commit adds or deletes has nothing to compare and yields no changes.
+func getLockfileChanges(ctx context.Context, repoPath, commitHash, file string) ([]dependencyChange, error) {
+	if !objectExists(ctx, repoPath, commitHash+":"+file) || !objectExists(ctx, repoPath, commitHash+"^:"+file) {
+		return nil, nil
+	}
+	newLock, err := readPackageLock(ctx, repoPath, commitHash+":"+file)
+	if err != nil {
+		return nil, err
+	}
+	oldLock, err := readPackageLock(ctx, repoPath, commitHash+"^:"+file)
+	if err != nil {
+		return nil, err
+	}
+
+	oldVersions := oldLock.versions()
+	newVersions := newLock.versions()
+	var changes []dependencyChange
+	for name, updated := range newVersions {
+		if old, ok := oldVersions[name]; ok && old != updated {
+			changes = append(changes, dependencyChange{Ecosystem: ecosystemNPM, Name: name, Old: old, New: updated})
+		}
+	}
+	// Lockfile maps have no order of their own.
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+	return changes, nil
+}
+
+// readPackageLock reads and decodes a package-lock.json blob named by object, e.g. "HEAD:package-lock.json".
+func readPackageLock(ctx context.Context, repoPath, object string) (packageLock, error) {
+	output, err := gitRun(ctx, repoPath, "cat-file", "blob", object)
+	if err != nil {
+		return packageLock{}, fmt.Errorf("failed to read %s: %w", object, err)
+	}
+	var lock packageLock
+	if err := json.Unmarshal(output, &lock); err != nil {
+		return packageLock{}, fmt.Errorf("failed to decode %s: %w", object, err)
+	}
+	return lock, nil
+}
+
+// objectExists reports whether object, e.g. "HEAD^:package-lock.json", names an existing blob.
+func objectExists(ctx context.Context, repoPath, object string) bool {
+	_, err := gitRun(ctx, repoPath, "cat-file", "-e", object)
+	return err == nil
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDependencyLines(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    []string // "sign ecosystem name version" of each dependency line.
+	}{
+		{"gomod.diff", []string{
+			"- Go github.com/google/uuid v1.5.0",
+			"- Go golang.org/x/net v0.22.0",
+			"+ Go github.com/google/uuid v1.6.0",
+			"+ Go golang.org/x/net v0.23.0",
+			"- Go golang.org/x/text v0.14.0",
+			"+ Go golang.org/x/text v0.15.0",
+			"- Go github.com/pkg/errors v0.9.0",
+			"+ Go github.com/pkg/errors v0.9.1",
+		}},
+		{"package-json.diff", []string{
+			"- npm lodash 4.17.20",
+			"+ npm lodash 4.17.21",
+			"- npm @types/node 20.11.0",
+			"+ npm @types/node 20.12.7",
+			"- npm express 4.18.2",
+			"+ npm express 4.19.2",
+		}},
+		{"requirements.diff", []string{
+			"- PyPI requests 2.31.0",
+			"- PyPI Jinja2 3.1.3",
+			"+ PyPI requests 2.32.0",
+			"+ PyPI Jinja2 3.1.4",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			diff, err := os.ReadFile(filepath.Join("testdata", "dependencies", tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			var file string
+			for _, line := range strings.Split(string(diff), "\n") {
+				if m := diffFileHeader.FindStringSubmatch(line); m != nil {
+					file = m[2]
+					continue
+				}
+				if strings.HasPrefix(line, "---") || strings.HasPrefix(line, "+++") {
+					continue
+				}
+				if sign, ecosystem, name, version, ok := parseDependencyLine(file, line); ok {
+					got = append(got, strings.Join([]string{sign, ecosystem, name, version}, " "))
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("dependency lines:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+
+	// Files that are not manifests have no dependency lines.
+	if _, _, _, _, ok := parseDependencyLine("docs/package.json.md", `+    "lodash": "^4.17.21",`); ok {
+		t.Error("dependency read from a file that is not a manifest")
+	}
+}
+
+// changeStrings renders dependency changes as "ecosystem name old → new", marking the
+// transitive ones.
+func changeStrings(changes []dependencyChange) []string {
+	var out []string
+	for _, c := range changes {
+		s := c.Ecosystem + " " + c.String()
+		if c.Transitive {
+			s += " (transitive)"
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// packageLockJSON is a lockfile version 3 installing the given packages at the root.
+func packageLockJSON(versions map[string]string) string {
+	var sb strings.Builder
+	sb.WriteString("{\n  \"lockfileVersion\": 3,\n  \"packages\": {\n    \"\": {\"name\": \"app\"}")
+	for _, name := range []string{"express", "lodash", "qs"} {
+		if v, ok := versions[name]; ok {
+			fmt.Fprintf(&sb, ",\n    \"node_modules/%s\": {\"version\": %q}", name, v)
+		}
+	}
+	sb.WriteString("\n  }\n}\n")
+	return sb.String()
+}
+
+func TestGetDependencyChanges(t *testing.T) {
+	repo := newFixtureRepo(t)
+	repo.commit("Add the manifests", map[string]string{
+		"go.mod":            "module example.com/app\n\ngo 1.22\n\nrequire (\n\tgithub.com/google/uuid v1.5.0\n\tgolang.org/x/net v0.22.0\n)\n",
+		"package.json":      "{\n  \"name\": \"app\",\n  \"scripts\": {\n    \"test\": \"jest\"\n  },\n  \"dependencies\": {\n    \"express\": \"^4.18.2\",\n    \"lodash\": \"^4.17.20\"\n  }\n}\n",
+		"package-lock.json": packageLockJSON(map[string]string{"express": "4.18.2", "lodash": "4.17.20", "qs": "6.11.0"}),
+		"requirements.txt":  "requests==2.31.0\nflask>=2.0\n",
+		"Cargo.toml":        "[package]\nname = \"app\"\nversion = \"0.1.0\"\n\n[dependencies]\nserde = { version = \"1.0.195\", features = [\"derive\"] }\nlocal = { path = \"../local\" }\n\n[dependencies.tokio]\nversion = \"1.35.0\"\n",
+	})
+	gomod := repo.commit("Bump golang.org/x/net", map[string]string{
+		"go.mod": "module example.com/app\n\ngo 1.22\n\nrequire (\n\tgithub.com/google/uuid v1.5.0\n\tgolang.org/x/net v0.23.0\n\tgithub.com/pkg/errors v0.9.1\n)\n",
+	})
+	// The lockfile pins the exact versions of the manifest's ranges and a transitive bump.
+	npm := repo.commit("Bump lodash", map[string]string{
+		"package.json":      "{\n  \"name\": \"app\",\n  \"scripts\": {\n    \"test\": \"vitest\"\n  },\n  \"dependencies\": {\n    \"express\": \"^4.18.2\",\n    \"lodash\": \"^4.17.21\"\n  }\n}\n",
+		"package-lock.json": packageLockJSON(map[string]string{"express": "4.18.2", "lodash": "4.17.21", "qs": "6.11.2"}),
+	})
+	pip := repo.commit("Bump requests", map[string]string{"requirements.txt": "requests==2.32.0\nflask>=2.2\n"})
+	cargo := repo.commit("Bump serde and tokio", map[string]string{
+		"Cargo.toml": "[package]\nname = \"app\"\nversion = \"0.2.0\"\n\n[dependencies]\nserde = { version = \"1.0.196\", features = [\"derive\"] }\nlocal = { path = \"../local\" }\n\n[dependencies.tokio]\nversion = \"1.36.0\"\n",
+	})
+	none := repo.commit("Touch the code", map[string]string{"main.go": "package main\n"})
+
+	tests := []struct {
+		name   string
+		commit string
+		want   []string
+	}{
+		// An added requirement is not a change.
+		{"go.mod", gomod, []string{"Go golang.org/x/net v0.22.0 → v0.23.0"}},
+		// The script line looks like a dependency line but is not declared as one.
+		{"package.json", npm, []string{"npm lodash 4.17.20 → 4.17.21", "npm qs 6.11.0 → 6.11.2 (transitive)"}},
+		{"requirements.txt", pip, []string{"PyPI requests 2.31.0 → 2.32.0"}},
+		{"Cargo.toml", cargo, []string{"crates.io serde 1.0.195 → 1.0.196", "crates.io tokio 1.35.0 → 1.36.0"}},
+		{"no manifests", none, nil},
+	}
+	for _, tt := range tests {
+		changes, err := getDependencyChanges(context.Background(), repo.Dir, tt.commit)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := changeStrings(changes); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: changes %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// A bump only counts its manifest changes; the transitive ones are left out.
+	if bumps, err := getDependencyBumps(context.Background(), repo.Dir, npm); err != nil || !reflect.DeepEqual(bumps, []string{"lodash 4.17.20 → 4.17.21"}) {
+		t.Errorf("getDependencyBumps = %q, %v", bumps, err)
+	}
+}
+
+func TestFoldDependencyChanges(t *testing.T) {
+	// Entries are newest first; the trail is folded oldest first.
+	entries := []CommitAuditData{
+		{Hash: "c4", Dependencies: []dependencyChange{{Ecosystem: ecosystemNPM, Name: "lodash", Old: "4.17.22", New: "4.17.20"}}},
+		{Hash: "c3", Dependencies: []dependencyChange{{Ecosystem: ecosystemNPM, Name: "lodash", Old: "4.17.21", New: "4.17.22"}, {Ecosystem: ecosystemCargo, Name: "cc", New: "1.0"}}},
+		{Hash: "c2"},
+		{Hash: "c1", Dependencies: []dependencyChange{{Ecosystem: ecosystemNPM, Name: "lodash", Old: "4.17.20", New: "4.17.21"}, {Ecosystem: ecosystemNPM, Name: "left-pad", Old: "1.3.0"}}},
+	}
+	want := []dependencyTransition{
+		{Ecosystem: ecosystemCargo, Name: "cc", Versions: []string{"", "1.0"}, Commits: []string{"c3"}},
+		{Ecosystem: ecosystemNPM, Name: "left-pad", Versions: []string{"1.3.0", ""}, Commits: []string{"c1"}},
+		{Ecosystem: ecosystemNPM, Name: "lodash", Versions: []string{"4.17.20", "4.17.21", "4.17.22", "4.17.20"}, Commits: []string{"c1", "c3", "c4"}},
+	}
+	if got := foldDependencyChanges(entries); !reflect.DeepEqual(got, want) {
+		t.Errorf("folded %+v, want %+v", got, want)
+	}
+	if got := formatDependencyDigest(entries[2:3]); got != "" {
+		t.Errorf("digest without dependency changes: %q", got)
+	}
+}
+
+func TestDependencyDigestRun(t *testing.T) {
+	repo := newFixtureRepo(t)
+	gomod := func(requires ...string) map[string]string {
+		return map[string]string{"go.mod": "module example.com/app\n\ngo 1.22\n\nrequire (\n\t" + strings.Join(requires, "\n\t") + "\n)\n"}
+	}
+	repo.commit("Add go.mod", gomod("github.com/google/uuid v1.5.0", "golang.org/x/net v0.22.0", "github.com/pkg/errors v0.9.1"))
+	// uuid is bumped three times and then reverted: no net change, but its trail is kept.
+	var trail []string
+	for _, version := range []string{"v1.6.0", "v1.6.1", "v1.6.2", "v1.5.0"} {
+		trail = append(trail, repo.commit("Move uuid to "+version, gomod("github.com/google/uuid "+version, "golang.org/x/net v0.22.0", "github.com/pkg/errors v0.9.1")))
+	}
+	net := repo.commit("Bump x/net and drop pkg/errors", gomod("github.com/google/uuid v1.5.0", "golang.org/x/net v0.23.0"))
+	added := repo.commit("Add x/sync", gomod("github.com/google/uuid v1.5.0", "golang.org/x/net v0.23.0", "golang.org/x/sync v0.7.0"))
+	env := newAuditEnv(t)
+
+	// The range starts after go.mod was added, at the first uuid bump.
+	report := filepath.Join(env.Work, "report.txt")
+	env.mustRun("-repo", repo.Dir, "-commit", trail[0], "-dependency-digest", "-no-osv", "-output", report)
+	text := readFile(t, report)
+	short := func(hashes ...string) string {
+		for i, hash := range hashes {
+			hashes[i] = shortHash(hash)
+		}
+		return strings.Join(hashes, ", ")
+	}
+	want := "=== Dependency changes ===\n" +
+		"Changed:\n  golang.org/x/net (Go): v0.22.0 → v0.23.0 (" + short(net) + ")\n" +
+		"Added:\n  golang.org/x/sync (Go): v0.7.0 (" + short(added) + ")\n" +
+		"Removed:\n  github.com/pkg/errors (Go): v0.9.1 (" + short(net) + ")\n" +
+		"No net change:\n  github.com/google/uuid (Go): v1.5.0 → v1.6.0 → v1.6.1 → v1.6.2 → v1.5.0 (" + short(append([]string(nil), trail...)...) + ")\n"
+	if !strings.Contains(text, want) {
+		t.Errorf("report lacks\n%s\n%s", want, text)
+	}
+
+	document := filepath.Join(env.Work, "report.json")
+	env.mustRun("-repo", repo.Dir, "-commit", trail[0], "-dependency-digest", "-no-osv", "-format", "json", "-output", document)
+	var parsed jsonReport
+	if err := json.Unmarshal([]byte(readFile(t, document)), &parsed); err != nil {
+		t.Fatal(err)
+	}
+	var uuid *dependencyTransition
+	for i := range parsed.Dependencies {
+		if parsed.Dependencies[i].Name == "github.com/google/uuid" {
+			uuid = &parsed.Dependencies[i]
+		}
+	}
+	if len(parsed.Dependencies) != 4 || uuid == nil || uuid.Old() != uuid.New() || !reflect.DeepEqual(uuid.Commits, trail) {
+		t.Errorf("JSON dependencies: %+v", parsed.Dependencies)
+	}
+	for _, entry := range parsed.Commits {
+		if entry.Hash == trail[3] && (len(entry.Dependencies) != 1 || entry.Dependencies[0].String() != "github.com/google/uuid v1.6.2 → v1.5.0") {
+			t.Errorf("revert entry dependencies: %v", entry.Dependencies)
+		}
+	}
+
+	// Without the flag the report has no section and the JSON no array.
+	env.mustRun("-repo", repo.Dir, "-commit", trail[0], "-no-osv", "-format", "json", "-output", document, "-force")
+	if strings.Contains(readFile(t, document), `"dependencies"`) {
+		t.Error("dependencies recorded without -dependency-digest")
+	}
+}
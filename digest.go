@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// dependencyTransition is the net change of one dependency over the audited range
+// (-dependency-digest). Versions is its trail, oldest first, with "" where the dependency
+// was absent, so Versions[0] is the version before the range and the last one the version
+// after it.
+type dependencyTransition struct {
+	Ecosystem string   `json:"ecosystem"`
+	Name      string   `json:"name"`
+	Versions  []string `json:"versions"`
+	// Commits are the commits that changed the dependency, oldest first.
+	Commits []string `json:"commits"`
+}
+
+// Old is the version before the range, "" for a dependency the range added.
+func (t dependencyTransition) Old() string { return t.Versions[0] }
+
+// New is the version after the range, "" for a dependency the range removed.
+func (t dependencyTransition) New() string { return t.Versions[len(t.Versions)-1] }
+
+// foldDependencyChanges folds the dependency changes recorded on entries, which are in
+// report order (newest first), into one net transition per dependency, sorted by ecosystem
+// and name.
+func foldDependencyChanges(entries []CommitAuditData) []dependencyTransition {
+	transitions := make(map[string]*dependencyTransition)
+	for i := len(entries) - 1; i >= 0; i-- {
+		for _, change := range entries[i].Dependencies {
+			key := change.Ecosystem + "\x00" + change.Name
+			t, ok := transitions[key]
+			if !ok {
+				t = &dependencyTransition{Ecosystem: change.Ecosystem, Name: change.Name, Versions: []string{change.Old}}
+				transitions[key] = t
+			}
+			t.Versions = append(t.Versions, change.New)
+			t.Commits = append(t.Commits, entries[i].Hash)
+		}
+	}
+	folded := make([]dependencyTransition, 0, len(transitions))
+	for _, t := range transitions {
+		folded = append(folded, *t)
+	}
+	sort.Slice(folded, func(i, j int) bool {
+		if folded[i].Ecosystem != folded[j].Ecosystem {
+			return folded[i].Ecosystem < folded[j].Ecosystem
+		}
+		return folded[i].Name < folded[j].Name
+	})
+	return folded
+}
+
+// formatDependencyDigest renders the "Dependency changes" section of the report: the net
+// version changes, additions and removals over the range, and the dependencies that changed
+// and came back to where they started, each with the commits involved. It is empty when no
+// entry recorded dependency changes.
+func formatDependencyDigest(entries []CommitAuditData) string {
+	transitions := foldDependencyChanges(entries)
+	if len(transitions) == 0 {
+		return ""
+	}
+	version := func(v string) string {
+		if v == "" {
+			return msg("section.dependencies_none")
+		}
+		return v
+	}
+	line := func(t dependencyTransition, versions ...string) string {
+		hashes := make([]string, len(t.Commits))
+		for i, hash := range t.Commits {
+			hashes[i] = shortHash(hash)
+		}
+		rendered := make([]string, len(versions))
+		for i, v := range versions {
+			rendered[i] = version(v)
+		}
+		return fmt.Sprintf("  %s (%s): %s (%s)\n", t.Name, t.Ecosystem, strings.Join(rendered, " → "), strings.Join(hashes, ", "))
+	}
+
+	var changed, added, removed, unchanged strings.Builder
+	for _, t := range transitions {
+		switch {
+		case t.Old() == t.New():
+			unchanged.WriteString(line(t, t.Versions...))
+		case t.Old() == "":
+			added.WriteString(line(t, t.New()))
+		case t.New() == "":
+			removed.WriteString(line(t, t.Old()))
+		default:
+			changed.WriteString(line(t, t.Old(), t.New()))
+		}
+	}
+	var sb strings.Builder
+	sb.WriteString(msg("section.dependencies") + "\n")
+	for _, part := range []struct {
+		key   string
+		lines *strings.Builder
+	}{
+		{"section.dependencies_changed", &changed},
+		{"section.dependencies_added", &added},
+		{"section.dependencies_removed", &removed},
+		{"section.dependencies_unchanged", &unchanged},
+	} {
+		if part.lines.Len() > 0 {
+			sb.WriteString(msg(part.key) + "\n" + part.lines.String())
+		}
+	}
+	return sb.String()
+}
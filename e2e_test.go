@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The end-to-end suite runs gitaudit against a real Ollama server, to catch what the fake
+// server cannot: header quirks, keep-alive behavior and the real response framing. It runs
+// only with GITAUDIT_E2E=1, and skips when the server or the model is missing.
+// GITAUDIT_E2E_OLLAMA and GITAUDIT_E2E_MODEL override the default server and model.
+const (
+	e2eDefaultOllama = "http://localhost:11434"
+	e2eDefaultModel  = "qwen2.5:0.5b"
+)
+
+// e2eOllama returns the base URL and model of the local Ollama server, skipping the test
+// unless the suite is enabled and the server has the model.
+func e2eOllama(t *testing.T) (string, string) {
+	t.Helper()
+	if os.Getenv("GITAUDIT_E2E") != "1" {
+		t.Skip("set GITAUDIT_E2E=1 to run the end-to-end suite against a local Ollama")
+	}
+	base, model := os.Getenv("GITAUDIT_E2E_OLLAMA"), os.Getenv("GITAUDIT_E2E_MODEL")
+	if base == "" {
+		base = e2eDefaultOllama
+	}
+	if model == "" {
+		model = e2eDefaultModel
+	}
+	base = strings.TrimSuffix(base, "/")
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(base + "/api/tags")
+	if err != nil {
+		t.Skipf("no Ollama server at %s: %v", base, err)
+	}
+	defer resp.Body.Close()
+	var tags struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&tags) != nil {
+		t.Skipf("%s/api/tags answered %s; is it an Ollama server?", base, resp.Status)
+	}
+	for _, m := range tags.Models {
+		if m.Name == model || m.Name == model+":latest" {
+			return base, model
+		}
+	}
+	t.Skipf("the Ollama server at %s has no model %s; run ollama pull %s", base, model, model)
+	return "", ""
+}
+
+// e2eCheckReport checks the structure of a JSON report, not its prose: each entry is a commit
+// of the range, once, with a summary.
+func e2eCheckReport(t *testing.T, path string) jsonReport {
+	t.Helper()
+	var document jsonReport
+	if err := json.Unmarshal([]byte(readFile(t, path)), &document); err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, entry := range document.Commits {
+		if !containsString(document.Range, entry.Hash) || seen[entry.Hash] {
+			t.Errorf("entry %s is outside the range or repeated", shortHash(entry.Hash))
+		}
+		seen[entry.Hash] = true
+		if strings.TrimSpace(entry.Summary) == "" {
+			t.Errorf("entry %s has an empty summary", shortHash(entry.Hash))
+		}
+	}
+	return document
+}
+
+// TestEndToEndOllama audits a fixture repository with a real model: two commits at a time,
+// one commit too large for the configured context_size, an interrupt after the first entry
+// and a -resume of the rest.
+func TestEndToEndOllama(t *testing.T) {
+	base, model := e2eOllama(t)
+	t.Logf("running against %s with %s", base, model)
+
+	repo := newFixtureRepo(t)
+	repo.commits(5)
+	var oversized strings.Builder
+	for i := 0; i < 3000; i++ {
+		fmt.Fprintf(&oversized, "var generated%d = %d\n", i, i)
+	}
+	large := repo.commit("Add generated tables", map[string]string{"src/tables.go": "package src\n\n" + oversized.String()})
+	repo.commits(2)
+
+	env := newAuditEnv(t)
+	env.Config["ollama_endpoint"] = base + "/api/generate"
+	env.Config["ollama_model"] = model
+	env.Config["context_size"] = 2048
+	text, report := filepath.Join(env.Work, "report.txt"), filepath.Join(env.Work, "report.json")
+	args := []string{"-repo", repo.Dir, "-commit", "root", "-concurrency", "2", "-out", "text=" + text, "-out", "json=" + report}
+
+	out := env.mustRun(args...)
+	document := e2eCheckReport(t, report)
+	if len(document.Range) != 8 || len(document.Commits) != 8 || document.Partial || document.Model != model {
+		t.Fatalf("%d of %d commits, partial %v, model %q:\n%s", len(document.Commits), len(document.Range), document.Partial, document.Model, out)
+	}
+	if n := strings.Count(readFile(t, text), "Commit: "); n != 8 {
+		t.Errorf("the text report has %d entries", n)
+	}
+	for _, entry := range document.Commits {
+		truncated := entry.Budget != nil && entry.Budget.Patch.KeptTokens < entry.Budget.Patch.SizeTokens
+		if truncated != (entry.Hash == large) {
+			t.Errorf("entry %s: patch budget %+v", shortHash(entry.Hash), entry.Budget)
+		}
+	}
+
+	// An interrupt after the first entry finishes the commits in flight and leaves the rest
+	// pending; the entries and the pending commits cover the range.
+	os.Remove(text)
+	os.Remove(report)
+	cmd := env.command(args...)
+	var console strings.Builder
+	cmd.Stdout, cmd.Stderr = &console, &console
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cmd.Process.Kill() })
+	for deadline := time.Now().Add(2 * time.Minute); ; time.Sleep(50 * time.Millisecond) {
+		if state, err := loadRunState(statePathFor(text)); err == nil && len(state.Entries) > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no commit was audited in time")
+		}
+	}
+	cmd.Process.Signal(os.Interrupt)
+	if code := waitExit(t, cmd); code != exitInterrupted {
+		t.Skipf("the run finished before the interrupt took effect (exit %d)", code)
+	}
+	document = e2eCheckReport(t, report)
+	pending := console.String()[strings.Index(console.String(), "commits were pending processing or retry:\n"):]
+	covered := len(document.Commits)
+	for _, hash := range document.Range {
+		if strings.Contains(pending, hash) {
+			covered++
+		}
+	}
+	if !document.Partial || len(document.Commits) == 0 || len(document.Commits) == 8 || covered != 8 {
+		t.Fatalf("interrupted run: %d entries, %d covered, partial %v:\n%s", len(document.Commits), covered, document.Partial, console.String())
+	}
+
+	// -resume audits only the pending commits.
+	audited := len(document.Commits)
+	out = env.mustRun(append(args, "-resume")...)
+	if n := strings.Count(out, "Successfully processed commit "); n != 8-audited {
+		t.Errorf("the resumed run audited %d commits, want %d:\n%s", n, 8-audited, out)
+	}
+	if document = e2eCheckReport(t, report); len(document.Commits) != 8 || document.Partial {
+		t.Errorf("resumed run: %d entries, partial %v", len(document.Commits), document.Partial)
+	}
+}
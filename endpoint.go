@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ollamaGeneratePath is the path of Ollama's generate API, which gitaudit uses.
+const ollamaGeneratePath = "/api/generate"
+
+// ollamaStatusError is an unsuccessful HTTP status from the Ollama endpoint.
+type ollamaStatusError struct {
+	Status     string
+	StatusCode int
+	Body       string
+}
+
+func (e *ollamaStatusError) Error() string {
+	return fmt.Sprintf("Ollama API request failed with status %s: %s", e.Status, e.Body)
+}
+
+// normalizeOllamaEndpoint fixes the misconfigurations of ollama_endpoint that are evident
+// from the URL alone, without a request: a trailing slash after /api/generate, and the chat
+// API, which does not accept generate requests. It returns the endpoint to use and, when it
+// changed, a suggestion for the config file.
+func normalizeOllamaEndpoint(endpoint string) (string, string) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return endpoint, "" // Reported by the first request.
+	}
+	path := strings.TrimRight(u.Path, "/")
+	switch {
+	case strings.HasSuffix(path, "/api/chat"):
+		u.Path = strings.TrimSuffix(path, "/api/chat") + ollamaGeneratePath
+		return u.String(), fmt.Sprintf("ollama_endpoint %s is Ollama's chat API, but gitaudit sends generate requests; using %s. Set ollama_endpoint to it in the config file.", endpoint, u)
+	case strings.HasSuffix(path, ollamaGeneratePath) && path != u.Path:
+		u.Path = path
+		return u.String(), fmt.Sprintf("ollama_endpoint %s has a trailing slash, which Ollama answers with 404; using %s. Remove the slash in the config file.", endpoint, u)
+	}
+	return endpoint, ""
+}
+
+// diagnoseOllamaFailure looks for a misconfigured endpoint behind a failed request. When the
+// endpoint answered 404 or 405 but is the base URL of an Ollama server, which answers
+// /api/version, it returns the generate endpoint of that server to use instead. For a scheme
+// mismatch it returns a permanent error saying which scheme to use; otherwise it returns err.
+func diagnoseOllamaFailure(endpoint string, err error) (string, error) {
+	u, parseErr := url.Parse(endpoint)
+	if parseErr != nil {
+		return "", err
+	}
+
+	var status *ollamaStatusError
+	if errors.As(err, &status) {
+		switch {
+		case status.StatusCode == http.StatusBadRequest && u.Scheme == "http" &&
+			(strings.Contains(status.Body, "HTTP request to an HTTPS server") || strings.Contains(status.Body, "plain HTTP request was sent to HTTPS port")):
+			return "", &permanentError{err: fmt.Errorf("%w (the server at %s expects HTTPS; change ollama_endpoint to https://)", err, u.Host)}
+		case status.StatusCode == http.StatusNotFound || status.StatusCode == http.StatusMethodNotAllowed:
+			if strings.HasSuffix(u.Path, ollamaGeneratePath) {
+				return "", err // A model that has not been pulled.
+			}
+			base := strings.TrimRight(endpoint, "/")
+			resp, probeErr := modelInfoClient.Get(base + "/api/version")
+			if probeErr != nil {
+				return "", err
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return "", err
+			}
+			return base + ollamaGeneratePath, nil
+		}
+		return "", err
+	}
+
+	msg := err.Error()
+	if u.Scheme == "https" && (strings.Contains(msg, "server gave HTTP response to HTTPS client") || strings.Contains(msg, "first record does not look like a TLS handshake")) {
+		return "", &permanentError{err: fmt.Errorf("%w (the server at %s speaks plain HTTP; change ollama_endpoint to http://)", err, u.Host)}
+	}
+	return "", err
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestNormalizeOllamaEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint, want string
+		suggestion     string
+	}{
+		{"http://localhost:11434/api/generate", "http://localhost:11434/api/generate", ""},
+		{"http://localhost:11434/api/generate/", "http://localhost:11434/api/generate", "has a trailing slash"},
+		{"http://localhost:11434/api/chat", "http://localhost:11434/api/generate", "is Ollama's chat API"},
+		{"http://gpu-box/ollama/api/chat/", "http://gpu-box/ollama/api/generate", "is Ollama's chat API"},
+		// A base URL needs a request to tell; so does a URL that does not parse.
+		{"http://localhost:11434", "http://localhost:11434", ""},
+		{"localhost:11434", "localhost:11434", ""},
+	}
+	for _, tt := range tests {
+		got, suggestion := normalizeOllamaEndpoint(tt.endpoint)
+		if got != tt.want || (tt.suggestion == "") != (suggestion == "") || !strings.Contains(suggestion, tt.suggestion) {
+			t.Errorf("normalizeOllamaEndpoint(%s) = %s, %q; want %s and a suggestion containing %q", tt.endpoint, got, suggestion, tt.want, tt.suggestion)
+		}
+		if suggestion != "" && !strings.Contains(suggestion, tt.want) {
+			t.Errorf("suggestion %q does not name the endpoint to use", suggestion)
+		}
+	}
+}
+
+// ollamaBaseServer is an Ollama server that counts the requests to each path. Unless
+// versionless, it answers /api/version like Ollama, and any path but /api/generate with 404.
+type ollamaBaseServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests map[string]int
+}
+
+func newOllamaBaseServer(t *testing.T, versionless bool) *ollamaBaseServer {
+	t.Helper()
+	s := &ollamaBaseServer{requests: make(map[string]int)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests[r.Method+" "+r.URL.Path]++
+		s.mu.Unlock()
+		switch {
+		case r.URL.Path == "/api/version" && !versionless:
+			io.WriteString(w, `{"version":"0.5.7"}`)
+		case r.URL.Path == "/api/generate":
+			io.WriteString(w, `{"response":"A summary.","done":true}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *ollamaBaseServer) Requests(key string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[key]
+}
+
+func TestDiagnoseBaseURL(t *testing.T) {
+	server := newOllamaBaseServer(t, false)
+	for _, endpoint := range []string{server.URL, server.URL + "/"} {
+		_, err := callOllama(context.Background(), endpoint, "m", "p", nil)
+		var status *ollamaStatusError
+		if !errors.As(err, &status) || status.StatusCode != http.StatusNotFound {
+			t.Fatalf("call to the base URL: %v", err)
+		}
+		fixed, err := diagnoseOllamaFailure(endpoint, err)
+		if err != nil || fixed != server.URL+ollamaGeneratePath {
+			t.Errorf("diagnoseOllamaFailure(%s) = %q, %v; want the generate endpoint", endpoint, fixed, err)
+		}
+	}
+
+	// A 404 from /api/generate is a model that has not been pulled: no probe.
+	probes := server.Requests("GET /api/version")
+	notFound := &ollamaStatusError{Status: "404 Not Found", StatusCode: http.StatusNotFound, Body: `{"error":"model 'm' not found"}`}
+	if fixed, err := diagnoseOllamaFailure(server.URL+ollamaGeneratePath, notFound); fixed != "" || err != notFound {
+		t.Errorf("missing model diagnosed as %q, %v", fixed, err)
+	}
+	if server.Requests("GET /api/version") != probes {
+		t.Error("a missing model probed the server")
+	}
+
+	// Something that is not Ollama keeps its 404.
+	other := newOllamaBaseServer(t, true)
+	if fixed, err := diagnoseOllamaFailure(other.URL+"/v1", notFound); fixed != "" || err != notFound {
+		t.Errorf("a server without /api/version diagnosed as %q, %v", fixed, err)
+	}
+}
+
+func TestDiagnoseSchemeMismatch(t *testing.T) {
+	// https:// configured for a plain HTTP server.
+	plain := newOllamaBaseServer(t, false)
+	endpoint := "https://" + strings.TrimPrefix(plain.URL, "http://") + ollamaGeneratePath
+	_, err := callOllama(context.Background(), endpoint, "m", "p", nil)
+	if err == nil {
+		t.Fatal("TLS to a plain server succeeded")
+	}
+	fixed, err := diagnoseOllamaFailure(endpoint, err)
+	if fixed != "" || !isPermanent(err) || !strings.Contains(err.Error(), "speaks plain HTTP; change ollama_endpoint to http://") {
+		t.Errorf("https to http: %q, %v", fixed, err)
+	}
+
+	// http:// configured for a TLS server.
+	tls := httptest.NewTLSServer(http.NotFoundHandler())
+	defer tls.Close()
+	endpoint = "http://" + strings.TrimPrefix(tls.URL, "https://") + ollamaGeneratePath
+	_, err = callOllama(context.Background(), endpoint, "m", "p", nil)
+	fixed, err = diagnoseOllamaFailure(endpoint, err)
+	if fixed != "" || !isPermanent(err) || !strings.Contains(err.Error(), "expects HTTPS; change ollama_endpoint to https://") {
+		t.Errorf("http to https: %q, %v", fixed, err)
+	}
+
+	// Other failures are left alone.
+	busy := &ollamaStatusError{Status: "503 Service Unavailable", StatusCode: http.StatusServiceUnavailable}
+	if fixed, err := diagnoseOllamaFailure("http://localhost:11434", busy); fixed != "" || err != busy {
+		t.Errorf("503 diagnosed as %q, %v", fixed, err)
+	}
+}
+
+func TestOllamaGeneratorAdaptsOnce(t *testing.T) {
+	server := newOllamaBaseServer(t, false)
+	g := newOllamaGenerator(server.URL, "m")
+	out := captureStdout(t, func() {
+		for i := 0; i < 3; i++ {
+			if result, err := g.Generate(context.Background(), "p"); err != nil || result.Text != "A summary." {
+				t.Errorf("call %d: %+v, %v", i, result, err)
+			}
+		}
+	})
+	if strings.Count(out, "Warning: ollama_endpoint "+server.URL+" is not Ollama's generate API") != 1 {
+		t.Errorf("warnings:\n%s", out)
+	}
+	// Only the first call pays for the probe.
+	if server.Requests("POST /") != 1 || server.Requests("GET /api/version") != 1 || server.Requests("POST /api/generate") != 3 {
+		t.Errorf("requests %v", server.requests)
+	}
+
+	// An endpoint that cannot be fixed is diagnosed once, not on every failure.
+	other := newOllamaBaseServer(t, true)
+	g = newOllamaGenerator(other.URL+"/v1", "m")
+	for i := 0; i < 3; i++ {
+		if _, err := g.Generate(context.Background(), "p"); err == nil {
+			t.Fatal("call to a server without Ollama succeeded")
+		}
+	}
+	if other.Requests("GET /v1/api/version") != 1 {
+		t.Errorf("%d probes for three failures, want 1", other.Requests("GET /v1/api/version"))
+	}
+}
+
+func TestMisconfiguredEndpointRun(t *testing.T) {
+	repo := newFixtureRepo(t)
+	repo.commits(3)
+	env := newAuditEnv(t)
+	for _, tt := range []struct {
+		endpoint string
+		warning  string
+	}{
+		{env.Ollama.URL, "is not Ollama's generate API, but the server answers at " + env.Ollama.Endpoint() + "; using it for the rest of the run."},
+		{env.Ollama.Endpoint() + "/", "has a trailing slash, which Ollama answers with 404; using " + env.Ollama.Endpoint() + "."},
+		{env.Ollama.URL + "/api/chat", "is Ollama's chat API, but gitaudit sends generate requests; using " + env.Ollama.Endpoint() + "."},
+	} {
+		env.Config["ollama_endpoint"] = tt.endpoint
+		before := len(env.Ollama.Prompts())
+		out := env.mustRun("-repo", repo.Dir, "-commit", "root", "-output", filepath.Join(env.Work, "report.txt"), "-force")
+		if strings.Count(out, tt.warning) != 1 {
+			t.Errorf("%s: warning %q not shown once:\n%s", tt.endpoint, tt.warning, out)
+		}
+		if n := len(env.Ollama.Prompts()) - before; n != 3 || !strings.Contains(out, "Successfully wrote 3 audited commit entries") {
+			t.Errorf("%s: %d prompts reached the server:\n%s", tt.endpoint, n, out)
+		}
+	}
+}
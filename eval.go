@@ -0,0 +1,494 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Score bounds of the eval rubric.
+const (
+	evalMinScore = 1
+	evalMaxScore = 5
+)
+
+// evalCriterionName is the form of a rubric criterion name.
+var evalCriterionName = regexp.MustCompile(`^[a-z_]+$`)
+
+// evalScoreLine matches a "criterion: score" line of the judge's answer.
+var evalScoreLine = regexp.MustCompile(`(?m)^[\s*-]*([A-Za-z_]+)\s*:\s*([1-5])\b`)
+
+// evalFlags are the flags of `gitaudit eval` besides the prompt flags.
+type evalFlags struct {
+	Dir            string
+	JudgeModel     string
+	NoJudge        bool
+	UpdateBaseline bool
+	Retries        int
+}
+
+// registerEvalFlags defines the flags of `gitaudit eval` besides the prompt flags on fs.
+func registerEvalFlags(fs *flag.FlagSet) *evalFlags {
+	e := &evalFlags{}
+	fs.StringVar(&e.Dir, "dir", filepath.Join("testdata", "eval"), "Directory holding rubric.json, baseline.json and the cases/*.patch fixtures")
+	fs.StringVar(&e.JudgeModel, "judge-model", "", "Model of the configured provider that scores the summaries (default: the configured model)")
+	fs.BoolVar(&e.NoJudge, "no-judge", false, "Only generate and print the summaries, without scoring them")
+	fs.BoolVar(&e.UpdateBaseline, "update-baseline", false, "Accept the scores of this run as the new baseline.json")
+	fs.IntVar(&e.Retries, "retries", 2, "Number of additional attempts after a failed generation or an unreadable score before giving up")
+	return e
+}
+
+// evalFlagRules are the interactions specific to `gitaudit eval`.
+var evalFlagRules = []flagRule{
+	{Set: []string{"message-only"}, Conflict: true, Message: "-message-only needs a repository to compute commit stats and cannot be used with gitaudit eval"},
+	{Set: []string{"no-judge", "update-baseline"}, Conflict: true, Message: "-update-baseline needs scores and cannot be used with -no-judge"},
+	{Set: []string{"no-judge", "judge-model"}, Message: "-judge-model has no effect with -no-judge"},
+}
+
+// evalCriterion is one rubric criterion the judge scores from 1 to 5.
+type evalCriterion struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// evalRubric is <dir>/rubric.json: the criteria every summary is scored against and, per
+// case, the points a good summary of that fixture makes.
+type evalRubric struct {
+	Criteria []evalCriterion `json:"criteria"`
+	Cases    map[string]struct {
+		Expect []string `json:"expect"`
+	} `json:"cases"`
+}
+
+// evalBaseline is <dir>/baseline.json, the accepted scores that a run is compared with.
+type evalBaseline struct {
+	Model      string                    `json:"model"`
+	JudgeModel string                    `json:"judge_model"`
+	UpdatedAt  time.Time                 `json:"updated_at"`
+	Scores     map[string]map[string]int `json:"scores"`
+}
+
+// evalCase is one fixture patch of <dir>/cases, named after its file.
+type evalCase struct {
+	Name  string
+	Patch stdinPatch
+}
+
+// runEval implements `gitaudit eval`: it summarizes the fixture patches of the eval directory
+// with the current prompt pipeline, has a judge model score each summary against the
+// rubric, and compares the scores with the baseline.
+func runEval(args []string) {
+	fs := newCommandFlagSet("eval")
+	eval := registerEvalFlags(fs)
+	prompt := registerPromptFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fmt.Printf("Error: unexpected arguments: %s\n", strings.Join(fs.Args(), " "))
+		fs.Usage()
+		os.Exit(1)
+	}
+	if err := applyProfile(fs, prompt.Profile); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	prompt.setConfigOverrides()
+	validateFlags(fs, lookupCommand("eval").Rules)
+	if prompt.ExplainFlags {
+		explainFlags(fs)
+		return
+	}
+
+	rubric, err := loadEvalRubric(eval.Dir)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	cases, err := loadEvalCases(eval.Dir)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Printf("Error loading configuration: %v\n", err)
+		os.Exit(1)
+	}
+	if err := prompt.selectModel(config); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	opts, err := prompt.auditOptions("", config)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	// There is no repository to keep the caches and recordings out of.
+	opts.Cache = openCacheStore("", false)
+	if err := prompt.discoverModelInfo(opts); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := prompt.prepareRecordDir("", false); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Without -judge-model the summaries' generator judges too, so -record and -replay cover
+	// the scores as well.
+	judge, judgeModel := opts.Generator, configuredModel(config)
+	if eval.JudgeModel != "" {
+		judgeModel = eval.JudgeModel
+		judge, err = newGenerator(withModel(config, eval.JudgeModel))
+		if err != nil {
+			fmt.Printf("Error: invalid configuration: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	scores := make(map[string]map[string]int)
+	for _, c := range cases {
+		summary, err := evalSummarize(opts, c, eval.Retries)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("=== %s ===\n%s\n", c.Name, summary)
+		if eval.NoJudge {
+			continue
+		}
+		scores[c.Name], err = evalJudge(judge, rubric, c, summary, eval.Retries)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	opts.Cache.RecordRun("eval")
+	if eval.NoJudge {
+		return
+	}
+
+	baselinePath := filepath.Join(eval.Dir, "baseline.json")
+	baseline, err := loadEvalBaseline(baselinePath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	printEvalReport(rubric, cases, scores, baseline)
+
+	if eval.UpdateBaseline {
+		updated := evalBaseline{
+			Model:      configuredModel(config),
+			JudgeModel: judgeModel,
+			UpdatedAt:  time.Now().UTC().Truncate(time.Second),
+			Scores:     scores,
+		}
+		if err := writeEvalBaseline(baselinePath, updated); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Baseline updated: %s\n", baselinePath)
+	}
+}
+
+// withModel returns a copy of config that selects model for the configured provider.
+func withModel(config *Config, model string) *Config {
+	copied := *config
+	switch copied.Provider {
+	case providerAnthropic:
+		copied.Anthropic.Model = model
+	case providerGemini:
+		copied.Gemini.Model = model
+	default:
+		copied.OllamaModel = model
+	}
+	return &copied
+}
+
+// loadEvalRubric reads and checks <dir>/rubric.json.
+func loadEvalRubric(dir string) (*evalRubric, error) {
+	path := filepath.Join(dir, "rubric.json")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the eval rubric: %w", err)
+	}
+	var rubric evalRubric
+	if err := decodeConfigJSON(data, &rubric, true); err != nil {
+		return nil, fmt.Errorf("invalid eval rubric %s: %w", path, err)
+	}
+	if len(rubric.Criteria) == 0 {
+		return nil, fmt.Errorf("invalid eval rubric %s: no criteria", path)
+	}
+	seen := make(map[string]bool)
+	for i, c := range rubric.Criteria {
+		switch {
+		case !evalCriterionName.MatchString(c.Name):
+			return nil, fmt.Errorf("invalid eval rubric %s: criterion %d must be named with lowercase letters and underscores, got %q", path, i+1, c.Name)
+		case seen[c.Name]:
+			return nil, fmt.Errorf("invalid eval rubric %s: criterion %q is defined twice", path, c.Name)
+		case strings.TrimSpace(c.Description) == "":
+			return nil, fmt.Errorf("invalid eval rubric %s: criterion %q has no description", path, c.Name)
+		}
+		seen[c.Name] = true
+	}
+	return &rubric, nil
+}
+
+// loadEvalCases reads the fixture patches <dir>/cases/*.patch, in name order. Each file
+// holds one patch, as written by `git format-patch -1`.
+func loadEvalCases(dir string) ([]evalCase, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "cases", "*.patch"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no eval cases in %s", filepath.Join(dir, "cases"))
+	}
+	sort.Strings(paths)
+	var cases []evalCase
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read eval case: %w", err)
+		}
+		patches, err := parsePatches(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid eval case %s: %w", path, err)
+		}
+		if len(patches) != 1 {
+			return nil, fmt.Errorf("invalid eval case %s: it holds %d patches; put each in a file of its own", path, len(patches))
+		}
+		cases = append(cases, evalCase{Name: strings.TrimSuffix(filepath.Base(path), ".patch"), Patch: patches[0]})
+	}
+	return cases, nil
+}
+
+// evalSummarize summarizes a case the way `gitaudit patch` would and returns the formatted
+// entry, notes included, as an auditor would read it.
+func evalSummarize(opts *auditOptions, c evalCase, retries int) (string, error) {
+	label := "eval case " + c.Name
+	target := auditTarget{Ref: label, Message: c.Patch.Subject, Patch: c.Patch.promptText(opts.Privacy)}
+	state := &commitState{}
+	for attempt := 0; ; attempt++ {
+		auditData := CommitAuditData{}
+		extras := applyControlWatchlist(controlWatchlist(opts.Config), patchHeaderPaths(c.Patch.Text), promptExtras{}, &auditData)
+		extras = applyLicenseCheck(patchHeaderPaths(c.Patch.Text), scanLicenseHeaders(c.Patch.Text), extras, &auditData)
+		auditData.ChangeCategory = opts.Categories.classify(patchHeaderPaths(c.Patch.Text))
+		err := generateSummary(requestCtx, opts, label, target, nil, state.detailLevel(opts.Ladder), extras, &auditData)
+		if err == nil {
+			auditData.Kind = kindPatch
+			auditData.Hash = c.Patch.Hash
+			auditData.Author = c.Patch.Author
+			auditData.Date = c.Patch.Date
+			auditData.Ref = label
+			opts.Hook.Enrich(&auditData)
+			return formatCommitEntry(auditData), nil
+		}
+		exitOnPolicyViolation(err)
+		if attempt >= retries || isPermanent(err) {
+			return "", fmt.Errorf("failed to summarize %s after %d attempts: %w", label, attempt+1, err)
+		}
+		fmt.Printf("Attempt %d for %s failed: %v. Retrying.\n", attempt+1, label, err)
+		state.recordFailure(label, err, opts.Ladder, opts.DegradeAfter)
+	}
+}
+
+// evalJudgePrompt asks the judge to score summary against every criterion of the rubric.
+func evalJudgePrompt(rubric *evalRubric, c evalCase, summary string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "You are reviewing the quality of an audit log entry written for a code change. Score the entry against each criterion below from %d (poor) to %d (excellent), judging only what the entry says, not the change itself.\n\nCriteria:\n", evalMinScore, evalMaxScore)
+	for _, criterion := range rubric.Criteria {
+		fmt.Fprintf(&sb, "- %s: %s\n", criterion.Name, criterion.Description)
+	}
+	if expect := rubric.Cases[c.Name].Expect; len(expect) > 0 {
+		sb.WriteString("\nA good entry for this change makes these points:\n")
+		for _, point := range expect {
+			fmt.Fprintf(&sb, "- %s\n", point)
+		}
+	}
+	fmt.Fprintf(&sb, "\nThe change:\n%s\nThe audit log entry:\n%s\n", c.Patch.Text, summary)
+	sb.WriteString("\nAnswer with one line per criterion, in the form \"name: score\", followed by a short reason on the same line, and nothing else.")
+	return sb.String()
+}
+
+// evalJudge has judge score a summary and returns the score of each criterion. An answer
+// that misses a criterion is asked for again.
+func evalJudge(judge generator, rubric *evalRubric, c evalCase, summary string, retries int) (map[string]int, error) {
+	prompt := evalJudgePrompt(rubric, c, summary)
+	for attempt := 0; ; attempt++ {
+		result, err := judge.Generate(requestCtx, prompt)
+		if err == nil {
+			var scores map[string]int
+			scores, err = parseEvalScores(rubric, result.Text)
+			if err == nil {
+				return scores, nil
+			}
+		}
+		if attempt >= retries || isPermanent(err) {
+			return nil, fmt.Errorf("failed to score eval case %s after %d attempts: %w", c.Name, attempt+1, err)
+		}
+		fmt.Printf("Attempt %d to score %s failed: %v. Retrying.\n", attempt+1, c.Name, err)
+	}
+}
+
+// parseEvalScores reads the "criterion: score" lines of a judge's answer. Lines for unknown
+// criteria are ignored; the first score given for a criterion counts.
+func parseEvalScores(rubric *evalRubric, answer string) (map[string]int, error) {
+	scores := make(map[string]int)
+	for _, m := range evalScoreLine.FindAllStringSubmatch(answer, -1) {
+		name := strings.ToLower(m[1])
+		if _, ok := scores[name]; !ok {
+			scores[name] = int(m[2][0] - '0')
+		}
+	}
+	kept := make(map[string]int)
+	var missing []string
+	for _, criterion := range rubric.Criteria {
+		score, ok := scores[criterion.Name]
+		if !ok {
+			missing = append(missing, criterion.Name)
+		}
+		kept[criterion.Name] = score
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("the judge gave no score for %s", strings.Join(missing, ", "))
+	}
+	return kept, nil
+}
+
+// loadEvalBaseline reads the baseline at path; a missing baseline is empty.
+func loadEvalBaseline(path string) (*evalBaseline, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &evalBaseline{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the eval baseline: %w", err)
+	}
+	var baseline evalBaseline
+	if err := decodeConfigJSON(data, &baseline, true); err != nil {
+		return nil, fmt.Errorf("invalid eval baseline %s: %w", path, err)
+	}
+	return &baseline, nil
+}
+
+// writeEvalBaseline saves baseline to path.
+func writeEvalBaseline(path string, baseline evalBaseline) error {
+	data, err := json.MarshalIndent(baseline, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode the eval baseline: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write the eval baseline: %w", err)
+	}
+	return nil
+}
+
+// printEvalReport prints the score of every case and criterion, each with its change since
+// the baseline, and the mean of each criterion and of the whole run.
+func printEvalReport(rubric *evalRubric, cases []evalCase, scores map[string]map[string]int, baseline *evalBaseline) {
+	if baseline.Scores == nil {
+		fmt.Println("\nNo baseline recorded yet; pass -update-baseline to accept these scores.")
+	} else {
+		fmt.Printf("\nBaseline: model %s, judged by %s, updated %s\n", baseline.Model, baseline.JudgeModel, baseline.UpdatedAt.Format(time.DateOnly))
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	header := []string{"case"}
+	for _, criterion := range rubric.Criteria {
+		header = append(header, criterion.Name)
+	}
+	fmt.Fprintln(w, strings.Join(append(header, "mean"), "\t"))
+
+	// A mean is only compared when the baseline scored every case it covers, so that a new
+	// case does not read as a regression.
+	comparable := baseline.Scores != nil
+	means := make([]evalMean, len(rubric.Criteria)+1)
+	for _, c := range cases {
+		_, inBaseline := baseline.Scores[c.Name]
+		comparable = comparable && inBaseline
+		row := []string{c.Name}
+		var caseMean evalMean
+		for i, criterion := range rubric.Criteria {
+			score, previous := float64(scores[c.Name][criterion.Name]), float64(baseline.Scores[c.Name][criterion.Name])
+			row = append(row, formatEvalScore(score, previous, baseline.Scores != nil, inBaseline))
+			caseMean.add(score, previous)
+			means[i].add(score, previous)
+			means[len(means)-1].add(score, previous)
+		}
+		row = append(row, caseMean.format(baseline.Scores != nil, inBaseline))
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	row := []string{"mean"}
+	for _, mean := range means {
+		row = append(row, mean.format(baseline.Scores != nil, comparable))
+	}
+	fmt.Fprintln(w, strings.Join(row, "\t"))
+	w.Flush()
+
+	var dropped []string
+	for name := range baseline.Scores {
+		if _, ok := scores[name]; !ok {
+			dropped = append(dropped, name)
+		}
+	}
+	sort.Strings(dropped)
+	for _, name := range dropped {
+		fmt.Printf("Warning: baseline case %s has no fixture any more; -update-baseline drops it\n", name)
+	}
+}
+
+// evalMean averages scores and their baseline counterparts.
+type evalMean struct {
+	Score, Baseline float64
+	Count           int
+}
+
+func (m *evalMean) add(score, previous float64) {
+	m.Score += score
+	m.Baseline += previous
+	m.Count++
+}
+
+// format renders the mean like formatEvalScore, with two decimals.
+func (m evalMean) format(hasBaseline, inBaseline bool) string {
+	if m.Count == 0 {
+		return "-"
+	}
+	n := float64(m.Count)
+	text := fmt.Sprintf("%.2f", m.Score/n)
+	switch delta := (m.Score - m.Baseline) / n; {
+	case !hasBaseline:
+		return text
+	case !inBaseline:
+		return text + " (new)"
+	case math.Abs(delta) < 0.005:
+		return text
+	default:
+		return fmt.Sprintf("%s (%+.2f)", text, delta)
+	}
+}
+
+// formatEvalScore renders a score with its change since the baseline, e.g. "3 (-1)", or
+// "(new)" for a case the baseline does not cover.
+func formatEvalScore(score, previous float64, hasBaseline, inBaseline bool) string {
+	text := fmt.Sprintf("%d", int(score))
+	switch {
+	case !hasBaseline || (inBaseline && score == previous):
+		return text
+	case !inBaseline:
+		return text + " (new)"
+	default:
+		return fmt.Sprintf("%s (%+d)", text, int(score-previous))
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newEvalDir copies the committed rubric and fixture patches to a directory of their own, so
+// that a run can write its baseline there.
+func newEvalDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "cases"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	paths, _ := filepath.Glob(filepath.Join("testdata", "eval", "cases", "*.patch"))
+	for _, path := range append(paths, filepath.Join("testdata", "eval", "rubric.json")) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rel, _ := filepath.Rel(filepath.Join("testdata", "eval"), path)
+		if err := os.WriteFile(filepath.Join(dir, rel), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadEvalRubric(t *testing.T) {
+	rubric, err := loadEvalRubric(filepath.Join("testdata", "eval"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, criterion := range rubric.Criteria {
+		names = append(names, criterion.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"what", "why", "impact"}) || len(rubric.Cases["upload-limits"].Expect) != 3 {
+		t.Errorf("rubric %+v", rubric)
+	}
+
+	for want, data := range map[string]string{
+		"no criteria": `{"criteria": []}`,
+		`criterion 1 must be named with lowercase letters and underscores, got "What"`: `{"criteria": [{"name": "What", "description": "x"}]}`,
+		`criterion "what" is defined twice`:                                            `{"criteria": [{"name": "what", "description": "x"}, {"name": "what", "description": "y"}]}`,
+		`criterion "why" has no description`:                                           `{"criteria": [{"name": "why", "description": " "}]}`,
+		"unexpected EOF":                                                               `{"criteria": [`,
+	} {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "rubric.json"), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadEvalRubric(dir); err == nil || !strings.Contains(err.Error(), want) || !strings.HasPrefix(err.Error(), "invalid eval rubric ") {
+			t.Errorf("%s: %v, want %q", data, err, want)
+		}
+	}
+	if _, err := loadEvalRubric(t.TempDir()); err == nil || !strings.HasPrefix(err.Error(), "failed to read the eval rubric: ") {
+		t.Errorf("missing rubric: %v", err)
+	}
+}
+
+func TestLoadEvalCases(t *testing.T) {
+	cases, err := loadEvalCases(filepath.Join("testdata", "eval"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, c := range cases {
+		names = append(names, c.Name)
+		if c.Patch.Subject == "" || !strings.Contains(c.Patch.Text, "diff --git ") {
+			t.Errorf("%s: subject %q, patch:\n%s", c.Name, c.Patch.Subject, c.Patch.Text)
+		}
+	}
+	if !reflect.DeepEqual(names, []string{"retry-backoff", "session-cookie", "upload-limits"}) {
+		t.Errorf("cases %q", names)
+	}
+
+	dir := t.TempDir()
+	if _, err := loadEvalCases(dir); err == nil || !strings.HasPrefix(err.Error(), "no eval cases in ") {
+		t.Errorf("no cases: %v", err)
+	}
+	patch, err := os.ReadFile(filepath.Join("testdata", "eval", "cases", "retry-backoff.patch"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Mkdir(filepath.Join(dir, "cases"), 0o755)
+	if err := os.WriteFile(filepath.Join(dir, "cases", "two.patch"), append(append([]byte(nil), patch...), patch...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadEvalCases(dir); err == nil || !strings.Contains(err.Error(), "it holds 2 patches; put each in a file of its own") {
+		t.Errorf("two patches in a case: %v", err)
+	}
+}
+
+func TestParseEvalScores(t *testing.T) {
+	rubric, err := loadEvalRubric(filepath.Join("testdata", "eval"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		answer string
+		want   map[string]int
+		err    string
+	}{
+		{"what: 4 - names the limits\nwhy: 2 - no reason\nimpact: 5 - flags the risk", map[string]int{"what": 4, "why": 2, "impact": 5}, ""},
+		// Bullets, case and extra criteria are tolerated; the first score of a criterion counts.
+		{"- What: 3\n* WHY : 1 because\nclarity: 4\nImpact: 2\nimpact: 5", map[string]int{"what": 3, "why": 1, "impact": 2}, ""},
+		{"what: 4\nimpact: 6\n", nil, "the judge gave no score for why, impact"},
+		{"The entry is fine.", nil, "the judge gave no score for what, why, impact"},
+	}
+	for _, tt := range tests {
+		got, err := parseEvalScores(rubric, tt.answer)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%q: %v, want %q", tt.answer, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: %v, %v; want %v", tt.answer, got, err, tt.want)
+		}
+	}
+}
+
+func TestFormatEvalScore(t *testing.T) {
+	tests := []struct {
+		score, previous         float64
+		hasBaseline, inBaseline bool
+		want                    string
+	}{
+		{4, 0, false, false, "4"},
+		{4, 0, true, false, "4 (new)"},
+		{4, 4, true, true, "4"},
+		{3, 5, true, true, "3 (-2)"},
+		{5, 4, true, true, "5 (+1)"},
+	}
+	for _, tt := range tests {
+		if got := formatEvalScore(tt.score, tt.previous, tt.hasBaseline, tt.inBaseline); got != tt.want {
+			t.Errorf("formatEvalScore(%v, %v, %v, %v) = %q, want %q", tt.score, tt.previous, tt.hasBaseline, tt.inBaseline, got, tt.want)
+		}
+	}
+	var mean evalMean
+	if got := mean.format(true, true); got != "-" {
+		t.Errorf("empty mean = %q", got)
+	}
+	mean.add(4, 5)
+	mean.add(5, 5)
+	mean.add(3, 3)
+	if got := mean.format(true, true); got != "4.00 (-0.33)" {
+		t.Errorf("mean = %q", got)
+	}
+	if got := mean.format(true, false); got != "4.00 (new)" {
+		t.Errorf("mean of a new case = %q", got)
+	}
+	mean.add(5, 4)
+	if got := mean.format(true, true); got != "4.25" {
+		t.Errorf("unchanged mean = %q", got)
+	}
+}
+
+func TestEvalRun(t *testing.T) {
+	dir := newEvalDir(t)
+	env := newAuditEnv(t)
+	// The judge scores every case 4/3/5, except for the impact of upload-limits once impact
+	// is set lower.
+	impact := "5"
+	env.Ollama.Respond = func(n int, prompt string) (int, string) {
+		if !strings.HasPrefix(prompt, "You are reviewing the quality of an audit log entry") {
+			return 0, ""
+		}
+		answer := "what: 4 - specific\nwhy: 3 - partly\nimpact: 5 - clear"
+		if strings.Contains(prompt, "The upload size limit goes from 50 MB to 200 MB.") {
+			answer = "what: 4 - specific\nwhy: 3 - partly\nimpact: " + impact + " - see above"
+		}
+		body, _ := json.Marshal(map[string]any{"response": answer, "done": true})
+		return 200, string(body)
+	}
+
+	out := env.mustRun("eval", "-dir", dir)
+	if prompts := env.Ollama.Prompts(); len(prompts) != 6 {
+		t.Errorf("%d prompts, want a summary and a score for each of the 3 cases", len(prompts))
+	}
+	for _, want := range []string{
+		"=== retry-backoff ===\n",
+		"=== upload-limits ===\n",
+		"No baseline recorded yet; pass -update-baseline to accept these scores.",
+		"case            what  why   impact  mean\n" +
+			"retry-backoff   4     3     5       4.00\n" +
+			"session-cookie  4     3     5       4.00\n" +
+			"upload-limits   4     3     5       4.00\n" +
+			"mean            4.00  3.00  5.00    4.00\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+	// The judge is shown the fixture, the rubric and the points expected of the case.
+	for _, prompt := range env.Ollama.Prompts() {
+		if strings.HasPrefix(prompt, "You are reviewing") && strings.Contains(prompt, "Zip archives are now accepted.") {
+			if !strings.Contains(prompt, "- impact: The entry tells an auditor") || !strings.Contains(prompt, "The audit log entry:\n") {
+				t.Errorf("judge prompt:\n%s", prompt)
+			}
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "baseline.json")); err == nil {
+		t.Error("a run without -update-baseline wrote the baseline")
+	}
+
+	out = env.mustRun("eval", "-dir", dir, "-update-baseline")
+	if !strings.Contains(out, "Baseline updated: "+filepath.Join(dir, "baseline.json")) {
+		t.Errorf("output:\n%s", out)
+	}
+	var baseline evalBaseline
+	if err := json.Unmarshal([]byte(readFile(t, filepath.Join(dir, "baseline.json"))), &baseline); err != nil {
+		t.Fatal(err)
+	}
+	if baseline.Model != "tiny:0.5b" || baseline.JudgeModel != "tiny:0.5b" || time.Since(baseline.UpdatedAt) > time.Hour ||
+		!reflect.DeepEqual(baseline.Scores["upload-limits"], map[string]int{"what": 4, "why": 3, "impact": 5}) || len(baseline.Scores) != 3 {
+		t.Errorf("baseline %+v", baseline)
+	}
+
+	// A regression is reported against the baseline.
+	impact = "2"
+	out = env.mustRun("eval", "-dir", dir)
+	for _, want := range []string{
+		"Baseline: model tiny:0.5b, judged by tiny:0.5b, updated " + baseline.UpdatedAt.Format(time.DateOnly),
+		"upload-limits   4     3     2 (-3)        3.00 (-1.00)\n" +
+			"mean            4.00  3.00  4.00 (-1.00)  3.67 (-0.33)\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+
+	// A case the baseline lacks is new, and a baseline case without a fixture is named.
+	if err := os.Rename(filepath.Join(dir, "cases", "retry-backoff.patch"), filepath.Join(dir, "cases", "retry-backoff-2.patch")); err != nil {
+		t.Fatal(err)
+	}
+	out = env.mustRun("eval", "-dir", dir)
+	if !strings.Contains(out, "(new)") || !strings.Contains(out, "Warning: baseline case retry-backoff has no fixture any more; -update-baseline drops it") {
+		t.Errorf("renamed case:\n%s", out)
+	}
+
+	if out, code := env.run("eval", "-dir", dir, "-no-judge", "-update-baseline"); code == 0 || !strings.Contains(out, "-update-baseline needs scores and cannot be used with -no-judge") {
+		t.Errorf("-no-judge -update-baseline: exit %d\n%s", code, out)
+	}
+}
+
+func TestEvalReplay(t *testing.T) {
+	dir := newEvalDir(t)
+	env := newAuditEnv(t)
+	env.Ollama.Respond = func(n int, prompt string) (int, string) {
+		if !strings.HasPrefix(prompt, "You are reviewing") {
+			return 0, ""
+		}
+		body, _ := json.Marshal(map[string]any{"response": "what: 5\nwhy: 4\nimpact: 3", "done": true})
+		return 200, string(body)
+	}
+	recording := filepath.Join(env.Work, "recording")
+	recorded := env.mustRun("eval", "-dir", dir, "-record", recording)
+
+	// Replayed, the summaries and the scores come from the recordings, without the model;
+	// only the model's build line, which needs the server, is missing.
+	before := len(env.Ollama.Prompts())
+	replayed := env.mustRun("eval", "-dir", dir, "-replay", recording)
+	if len(env.Ollama.Prompts()) != before {
+		t.Error("a replayed eval called the model")
+	}
+	if _, rest, _ := strings.Cut(recorded, "\n"); replayed != rest {
+		t.Errorf("replayed output differs:\n%s\nrecorded:\n%s", replayed, recorded)
+	}
+
+	// -no-judge only needs the summaries.
+	out := env.mustRun("eval", "-dir", dir, "-no-judge", "-replay", recording)
+	if !strings.Contains(out, "=== session-cookie ===\n") || strings.Contains(out, "mean") {
+		t.Errorf("-no-judge:\n%s", out)
+	}
+}
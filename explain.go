@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// explainCommandFlags are the flags of `gitaudit explain` besides the prompt flags.
+type explainCommandFlags struct {
+	Repo    string
+	Full    bool
+	Retries int
+	Color   string
+}
+
+// registerExplainFlags defines the flags of `gitaudit explain` besides the prompt flags on fs.
+func registerExplainFlags(fs *flag.FlagSet) *explainCommandFlags {
+	e := &explainCommandFlags{}
+	fs.StringVar(&e.Repo, "repo", ".", "Path to the Git repository")
+	fs.BoolVar(&e.Full, "full", false, "Also print the unified diff below the diffstat")
+	fs.IntVar(&e.Retries, "retries", 2, "Number of additional attempts after a failed generation before giving up")
+	fs.StringVar(&e.Color, "color", "auto", "Colorize the diffstat and diff: \"auto\", \"always\" or \"never\"")
+	return e
+}
+
+// runExplain implements `gitaudit explain [flags] <commit>`: it audits a single commit and
+// prints the entry and its diffstat to the terminal instead of writing a report.
+func runExplain(args []string) {
+	fs := newCommandFlagSet("explain")
+	explain := registerExplainFlags(fs)
+	prompt := registerPromptFlags(fs)
+
+	// Accept flags both before and after the commit, e.g. `explain HEAD~1 -full`.
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fmt.Println("Error: a commit is required.")
+		fs.Usage()
+		os.Exit(1)
+	}
+	commitish := fs.Arg(0)
+	fs.Parse(fs.Args()[1:])
+	if fs.NArg() > 0 {
+		fmt.Printf("Error: unexpected arguments after the commit: %s\n", strings.Join(fs.Args(), " "))
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	if err := applyProfile(fs, prompt.Profile); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	prompt.setConfigOverrides()
+	validateFlags(fs, lookupCommand("explain").Rules)
+	if prompt.ExplainFlags {
+		explainFlags(fs)
+		return
+	}
+
+	useColor, err := resolveColor(explain.Color)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	commitHash, err := resolveCommit(explain.Repo, commitish)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Printf("Error loading configuration: %v\n", err)
+		os.Exit(1)
+	}
+	if err := prompt.selectModel(config); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	opts, err := prompt.auditOptions(explain.Repo, config)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	repoRoot, err := getRepoTopLevel(requestCtx, explain.Repo)
+	if err != nil {
+		fmt.Printf("Error resolving repository root: %v\n", err)
+		os.Exit(1)
+	}
+	// explain never writes into the repository, and neither should the cache store.
+	opts.Cache = openCacheStore(repoRoot, true)
+	if err := prompt.discoverModelInfo(opts); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := prompt.prepareRecordDir(repoRoot, true); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	opts.OSV = prompt.newOSVClient(opts.Config, opts.Cache)
+
+	state := &commitState{}
+	var auditData CommitAuditData
+	for attempt := 0; ; attempt++ {
+		auditData, err = auditCommit(requestCtx, opts, commitHash, state.detailLevel(opts.Ladder))
+		if err == nil {
+			break
+		}
+		exitOnPolicyViolation(err)
+		if attempt >= explain.Retries || isPermanent(err) {
+			fmt.Printf("Error: failed to explain commit %s after %d attempts: %v\n", commitHash, attempt+1, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Attempt %d for commit %s failed: %v. Retrying.\n", attempt+1, commitHash, err)
+		state.recordFailure(commitHash, err, opts.Ladder, opts.DegradeAfter)
+	}
+	opts.Cache.RecordRun("explain")
+
+	stat, err := getCommitDisplay(explain.Repo, commitHash, useColor, "--stat")
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Print(formatCommitEntry(auditData))
+	fmt.Printf("\n%s", stat)
+	if explain.Full {
+		diff, err := getCommitDisplay(explain.Repo, commitHash, useColor, "--patch")
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("\n%s", diff)
+	}
+}
+
+// resolveColor decides whether terminal output should be colorized. "auto" colorizes only
+// when stdout is a terminal and NO_COLOR is not set.
+func resolveColor(mode string) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		if os.Getenv("NO_COLOR") != "" {
+			return false, nil
+		}
+		info, err := os.Stdout.Stat()
+		return err == nil && info.Mode()&os.ModeCharDevice != 0, nil
+	}
+	return false, fmt.Errorf("invalid -color value %q: expected \"auto\", \"always\" or \"never\"", mode)
+}
+
+// getCommitDisplay returns git's own rendering of a commit's changes for the terminal, e.g.
+// the diffstat table (--stat) or the unified diff (--patch), without the commit header.
+func getCommitDisplay(repoPath, commitHash string, useColor bool, format string) (string, error) {
+	colorArg := "--color=never"
+	if useColor {
+		colorArg = "--color=always"
+	}
+	output, err := gitRun(context.Background(), repoPath, "show", "--format=", colorArg, format, commitHash)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute git show %s for commit %s: %w", format, commitHash, err)
+	}
+	return strings.TrimLeft(string(output), "\n"), nil
+}
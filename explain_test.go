@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	repo := newFixtureRepo(t)
+	repo.commits(2)
+	hash := repo.commit("Add the parser", map[string]string{"parser.go": "package parser\n\nfunc Parse() {}\n"})
+	env := newAuditEnv(t)
+
+	out := env.mustRun("explain", "-repo", repo.Dir, "HEAD")
+	prompts := env.Ollama.Prompts()
+	if len(prompts) != 1 {
+		t.Fatalf("explain sent %d prompts, want 1", len(prompts))
+	}
+	for _, want := range []string{hash, "Fixture Author", fakeSummary(prompts[0]), "parser.go | 3 +++", "1 file changed, 3 insertions(+)"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("explain output lacks %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "+func Parse() {}") || strings.Contains(out, "\x1b[") {
+		t.Errorf("explain printed the diff or colors without being asked:\n%s", out)
+	}
+
+	// Flags may follow the commit.
+	out = env.mustRun("explain", "-repo", repo.Dir, hash[:10], "-full", "-color", "always")
+	if !strings.Contains(out, "func Parse() {}") || !strings.Contains(out, "\x1b[32m") {
+		t.Errorf("-full -color always output lacks the colored diff:\n%s", out)
+	}
+	if strings.Count(out, "parser.go") < 2 {
+		t.Errorf("-full output lacks the diffstat or the diff header:\n%s", out)
+	}
+}
+
+func TestExplainFailsAfterRetries(t *testing.T) {
+	repo := newFixtureRepo(t)
+	repo.commits(1)
+	env := newAuditEnv(t)
+	env.Ollama.Respond = func(n int, prompt string) (int, string) {
+		return 503, `{"error":"server busy"}`
+	}
+	out, code := env.run("explain", "-repo", repo.Dir, "-retries", "2", "HEAD")
+	if code == 0 || !strings.Contains(out, "after 3 attempts") {
+		t.Errorf("exit %d, want a failure after 3 attempts:\n%s", code, out)
+	}
+	if n := len(env.Ollama.Prompts()); n != 3 {
+		t.Errorf("%d generate requests, want 3", n)
+	}
+
+	// A missing model is permanent, so it is not retried.
+	env.Ollama.Respond = func(n int, prompt string) (int, string) {
+		return 404, `{"error":"model 'tiny:0.5b' not found"}`
+	}
+	before := len(env.Ollama.Prompts())
+	out, code = env.run("explain", "-repo", repo.Dir, "-retries", "2", "HEAD")
+	if code == 0 || !strings.Contains(out, "after 1 attempts") || len(env.Ollama.Prompts()) != before+1 {
+		t.Errorf("exit %d after %d requests, want one attempt:\n%s", code, len(env.Ollama.Prompts())-before, out)
+	}
+}
+
+func TestExplainUsage(t *testing.T) {
+	repo := newFixtureRepo(t)
+	repo.commits(1)
+	env := newAuditEnv(t)
+	for _, args := range [][]string{
+		{"explain", "-repo", repo.Dir},
+		{"explain", "-repo", repo.Dir, "HEAD", "extra"},
+		{"explain", "-repo", repo.Dir, "-color", "sometimes", "HEAD"},
+		{"explain", "-repo", repo.Dir, "no-such-commit"},
+	} {
+		if out, code := env.run(args...); code == 0 || !strings.Contains(out, "Error:") {
+			t.Errorf("%v: exit %d, want an error:\n%s", args, code, out)
+		}
+	}
+	if len(env.Ollama.Prompts()) != 0 {
+		t.Errorf("invalid invocations sent %d prompts", len(env.Ollama.Prompts()))
+	}
+}
+
+func TestResolveColor(t *testing.T) {
+	t.Setenv("NO_COLOR", "1")
+	for mode, want := range map[string]bool{"always": true, "never": false, "auto": false} {
+		if got, err := resolveColor(mode); err != nil || got != want {
+			t.Errorf("resolveColor(%q) = %v, %v; want %v", mode, got, err, want)
+		}
+	}
+	if _, err := resolveColor("yes"); err == nil {
+		t.Error("resolveColor accepted \"yes\"")
+	}
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// exitFailOn is the exit status of a run that completed but met a -fail-on condition, so
+// that CI can tell a gated audit from a failed one (status 1).
+const exitFailOn = 3
+
+// Types of the fields a -fail-on condition can test.
+const (
+	fieldNumber = "number"
+	fieldString = "string"
+	fieldBool   = "bool"
+	// fieldList is a list of strings; = tests that it contains the value, != that it does not.
+	fieldList = "list"
+)
+
+// runOutcome is what -fail-on conditions are evaluated against once the run is over.
+type runOutcome struct {
+	Entries []CommitAuditData
+	// Failed counts the commits that could not be audited: still failing, pending after an
+	// interrupt, or unauditable.
+	Failed int
+	// Cost is the run's cost in US dollars, 0 without pricing.
+	Cost float64
+}
+
+// failOnField is a field of the -fail-on language. Run fields are aggregates over the run;
+// entry fields are tested against each entry.
+type failOnField struct {
+	Type        string
+	Description string
+	Run         func(r *runOutcome) any
+	Entry       func(e *CommitAuditData) any
+}
+
+// countEntries counts the entries of r for which match is true.
+func countEntries(r *runOutcome, match func(e *CommitAuditData) bool) any {
+	n := 0
+	for i := range r.Entries {
+		if match(&r.Entries[i]) {
+			n++
+		}
+	}
+	return float64(n)
+}
+
+// failOnFields are the fields -fail-on conditions can test.
+var failOnFields = map[string]failOnField{
+	"failed": {Type: fieldNumber, Description: "commits that could not be audited",
+		Run: func(r *runOutcome) any { return float64(r.Failed) }},
+	"audited": {Type: fieldNumber, Description: "commit entries in the report",
+		Run: func(r *runOutcome) any {
+			return countEntries(r, func(e *CommitAuditData) bool { return e.Kind != kindTag })
+		}},
+	"cost": {Type: fieldNumber, Description: "cost of the run in US dollars",
+		Run: func(r *runOutcome) any { return r.Cost }},
+	"unverified_citations": {Type: fieldNumber, Description: "entries whose -cite citations could not be verified",
+		Run: func(r *runOutcome) any {
+			return countEntries(r, func(e *CommitAuditData) bool { return e.CitationStatus == citationsUnverified })
+		}},
+	"verification_discrepancies": {Type: fieldNumber, Description: "entries whose -verify-critical summaries disagreed",
+		Run: func(r *runOutcome) any {
+			return countEntries(r, func(e *CommitAuditData) bool { return e.Verification == verificationDiscrepancies })
+		}},
+	"degraded_retries": {Type: fieldNumber, Description: "entries produced by -retry-model after the configured model kept failing",
+		Run: func(r *runOutcome) any {
+			return countEntries(r, func(e *CommitAuditData) bool { return e.RetryModel != "" })
+		}},
+	"unverified_claim_entries": {Type: fieldNumber, Description: "entries whose summary makes claims its input does not support, with -check-claims",
+		Run: func(r *runOutcome) any {
+			return countEntries(r, func(e *CommitAuditData) bool { return len(e.UnverifiedClaims) > 0 })
+		}},
+	"masked_leaks": {Type: fieldNumber, Description: "summaries masked for naming withheld files",
+		Run: func(r *runOutcome) any { return countEntries(r, func(e *CommitAuditData) bool { return e.LeakMasked }) }},
+	"control_changes": {Type: fieldNumber, Description: "entries that touch a control_watchlist path",
+		Run: func(r *runOutcome) any {
+			return countEntries(r, func(e *CommitAuditData) bool { return e.ControlChange })
+		}},
+	"secret_findings": {Type: fieldNumber, Description: "secrets committed in the range, with -secret-report",
+		Run: func(r *runOutcome) any {
+			n := 0
+			for _, e := range r.Entries {
+				n += len(e.Secrets)
+			}
+			return float64(n)
+		}},
+	"unremoved_secrets": {Type: fieldNumber, Description: "secrets committed in the range and not removed later in it, with -secret-report",
+		Run: func(r *runOutcome) any {
+			n := 0
+			for _, e := range r.Entries {
+				for _, f := range e.Secrets {
+					if f.RemovedIn == "" {
+						n++
+					}
+				}
+			}
+			return float64(n)
+		}},
+	"license_changes": {Type: fieldNumber, Description: "entries that change license files or copyright or SPDX headers",
+		Run: func(r *runOutcome) any {
+			return countEntries(r, func(e *CommitAuditData) bool { return e.LicenseChange })
+		}},
+
+	"kind": {Type: fieldString, Description: "commit, merge, automated or tag",
+		Entry: func(e *CommitAuditData) any { return e.Kind }},
+	"tests_touched": {Type: fieldBool, Description: "the commit changes a test path, with -check-tests",
+		Entry: func(e *CommitAuditData) any { return e.TestCheck != nil && e.TestCheck.TestsTouched }},
+	"test_ratio": {Type: fieldNumber, Description: "test lines changed per source line changed, with -check-tests; infinite when no source line changed",
+		Entry: func(e *CommitAuditData) any { return e.TestCheck.ratio() }},
+	"untested_additions": {Type: fieldNumber, Description: "exported Go functions and types added without test changes, with -check-tests",
+		Entry: func(e *CommitAuditData) any {
+			if e.TestCheck == nil {
+				return float64(0)
+			}
+			return float64(len(e.TestCheck.UntestedAdditions))
+		}},
+	"change_category": {Type: fieldString, Description: "code, test-only, docs-only or mixed, by the paths the commit touches",
+		Entry: func(e *CommitAuditData) any { return e.ChangeCategory }},
+	"author": {Type: fieldString, Description: "author of the commit",
+		Entry: func(e *CommitAuditData) any { return e.Author }},
+	"citations": {Type: fieldString, Description: "verified or unverified, with -cite",
+		Entry: func(e *CommitAuditData) any { return e.CitationStatus }},
+	"verification": {Type: fieldString, Description: "agreed, discrepancies or unconfirmed, with -verify-critical",
+		Entry: func(e *CommitAuditData) any { return e.Verification }},
+	"date_suspect": {Type: fieldString, Description: "future or before_root when the commit's date cannot be right",
+		Entry: func(e *CommitAuditData) any { return e.DateSuspect }},
+	"detail_level": {Type: fieldString, Description: "the degradation ladder step that produced the summary",
+		Entry: func(e *CommitAuditData) any { return e.DetailLevel }},
+	"withheld_files": {Type: fieldNumber, Description: "changed files withheld by never_send",
+		Entry: func(e *CommitAuditData) any { return float64(e.WithheldFiles) }},
+	"vulnerabilities": {Type: fieldNumber, Description: "known vulnerabilities the commit's dependency bumps fix",
+		Entry: func(e *CommitAuditData) any { return float64(len(e.Vulnerabilities)) }},
+	"dependencies": {Type: fieldNumber, Description: "dependency changes, with -dependency-digest",
+		Entry: func(e *CommitAuditData) any { return float64(len(e.Dependencies)) }},
+	"unverified_claims": {Type: fieldNumber, Description: "claims of the summary its input does not support, with -check-claims",
+		Entry: func(e *CommitAuditData) any { return float64(len(e.UnverifiedClaims)) }},
+	"degraded_retry": {Type: fieldBool, Description: "the summary was produced by -retry-model",
+		Entry: func(e *CommitAuditData) any { return e.RetryModel != "" }},
+	"leak_masked": {Type: fieldBool, Description: "the summary was masked for naming withheld files",
+		Entry: func(e *CommitAuditData) any { return e.LeakMasked }},
+	"formatting_only": {Type: fieldBool, Description: "the commit was classified as formatting-only",
+		Entry: func(e *CommitAuditData) any { return e.FormattingOnly }},
+	"message_only": {Type: fieldBool, Description: "the summary was generated without the diff",
+		Entry: func(e *CommitAuditData) any { return e.MessageOnly }},
+	"policy_skipped": {Type: fieldBool, Description: "every changed file was withheld by never_send",
+		Entry: func(e *CommitAuditData) any { return e.PolicySkipped }},
+	"control_change": {Type: fieldBool, Description: "the commit touches a control_watchlist path, e.g. a CI pipeline or CODEOWNERS",
+		Entry: func(e *CommitAuditData) any { return e.ControlChange }},
+	"secrets": {Type: fieldNumber, Description: "secrets the commit adds, with -secret-report",
+		Entry: func(e *CommitAuditData) any { return float64(len(e.Secrets)) }},
+	"secret_severity": {Type: fieldList, Description: "the severities of the secrets the commit adds: critical, high, medium or low",
+		Entry: func(e *CommitAuditData) any { return secretSeverities(e.Secrets) }},
+	"license_change": {Type: fieldBool, Description: "the commit changes a license, copying or NOTICE file or a copyright or SPDX header",
+		Entry: func(e *CommitAuditData) any { return e.LicenseChange }},
+	"control": {Type: fieldList, Description: "the -controls categories of the commit",
+		Entry: func(e *CommitAuditData) any { return e.Controls }},
+	"severity": {Type: fieldList, Description: "the severities of the vulnerabilities the commit fixes",
+		Entry: func(e *CommitAuditData) any {
+			severities := make([]string, len(e.Vulnerabilities))
+			for i, v := range e.Vulnerabilities {
+				severities[i] = v.Severity
+			}
+			return severities
+		}},
+}
+
+// failOnComparison is one "field op value" of a -fail-on condition.
+type failOnComparison struct {
+	Field string
+	Op    string
+	Value string
+}
+
+// failOnCondition is a parsed -fail-on condition: comparisons combined with AND, and those
+// groups with OR. AND binds tighter than OR, and there are no parentheses.
+type failOnCondition struct {
+	Text  string
+	AnyOf [][]failOnComparison
+	// PerEntry is set when a comparison tests an entry field. The condition is then met by
+	// the entries for which it is true, and run fields keep their run-wide value.
+	PerEntry bool
+}
+
+// failOnOperators are the comparison operators, longest first for tokenizing.
+var failOnOperators = []string{"<=", ">=", "!=", "==", "=", "<", ">"}
+
+// tokenizeFailOn splits a condition into words, operators and quoted strings.
+func tokenizeFailOn(text string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(text); {
+		switch c := text[i]; {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(text[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote")
+			}
+			tokens = append(tokens, text[i:i+end+2])
+			i += end + 2
+		default:
+			if op := operatorAt(text, i); op != "" {
+				tokens = append(tokens, op)
+				i += len(op)
+				continue
+			}
+			start := i
+			for i < len(text) && text[i] != ' ' && text[i] != '\t' && operatorAt(text, i) == "" {
+				i++
+			}
+			tokens = append(tokens, text[start:i])
+		}
+	}
+	return tokens, nil
+}
+
+// operatorAt returns the operator starting at text[i], or "".
+func operatorAt(text string, i int) string {
+	for _, op := range failOnOperators {
+		if strings.HasPrefix(text[i:], op) {
+			return op
+		}
+	}
+	return ""
+}
+
+// parseFailOn parses a -fail-on condition such as
+// "unverified_citations > 5 OR kind = merge AND withheld_files > 0".
+func parseFailOn(text string) (failOnCondition, error) {
+	cond := failOnCondition{Text: strings.TrimSpace(text)}
+	tokens, err := tokenizeFailOn(text)
+	if err != nil {
+		return cond, fmt.Errorf("invalid -fail-on %q: %w", text, err)
+	}
+	var group []failOnComparison
+	for i := 0; ; i += 4 {
+		if len(tokens)-i < 3 {
+			return cond, fmt.Errorf("invalid -fail-on %q: expected \"field op value\", combined with AND or OR", text)
+		}
+		cmp := failOnComparison{Field: strings.ToLower(tokens[i]), Op: tokens[i+1], Value: tokens[i+2]}
+		if err := cmp.validate(); err != nil {
+			return cond, fmt.Errorf("invalid -fail-on %q: %w", text, err)
+		}
+		if failOnFields[cmp.Field].Entry != nil {
+			cond.PerEntry = true
+		}
+		group = append(group, cmp)
+		if i+3 == len(tokens) {
+			break
+		}
+		switch strings.ToUpper(tokens[i+3]) {
+		case "AND":
+		case "OR":
+			cond.AnyOf = append(cond.AnyOf, group)
+			group = nil
+		default:
+			return cond, fmt.Errorf("invalid -fail-on %q: expected AND or OR, found %q", text, tokens[i+3])
+		}
+	}
+	cond.AnyOf = append(cond.AnyOf, group)
+	return cond, nil
+}
+
+// validate checks the field, and that the operator and value suit its type. Quotes around
+// the value are removed.
+func (c *failOnComparison) validate() error {
+	field, ok := failOnFields[c.Field]
+	if !ok {
+		names := make([]string, 0, len(failOnFields))
+		for name := range failOnFields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown field %q; fields are %s", c.Field, strings.Join(names, ", "))
+	}
+	if operatorAt(c.Op, 0) != c.Op {
+		return fmt.Errorf("expected an operator after %s, found %q", c.Field, c.Op)
+	}
+	if c.Op == "==" {
+		c.Op = "="
+	}
+	if len(c.Value) >= 2 && (c.Value[0] == '"' || c.Value[0] == '\'') {
+		c.Value = c.Value[1 : len(c.Value)-1]
+	}
+	switch field.Type {
+	case fieldNumber:
+		if _, err := strconv.ParseFloat(c.Value, 64); err != nil {
+			return fmt.Errorf("%s is a number, but %q is not", c.Field, c.Value)
+		}
+	case fieldBool:
+		if _, err := strconv.ParseBool(c.Value); err != nil {
+			return fmt.Errorf("%s is true or false, but %q is not", c.Field, c.Value)
+		}
+		fallthrough
+	default:
+		if c.Op != "=" && c.Op != "!=" {
+			return fmt.Errorf("%s can only be compared with = or !=", c.Field)
+		}
+	}
+	return nil
+}
+
+// test evaluates the comparison against the run, and against entry for entry fields.
+func (c failOnComparison) test(run *runOutcome, entry *CommitAuditData) bool {
+	field := failOnFields[c.Field]
+	var value any
+	if field.Entry != nil {
+		value = field.Entry(entry)
+	} else {
+		value = field.Run(run)
+	}
+	equal := false
+	switch v := value.(type) {
+	case float64:
+		want, _ := strconv.ParseFloat(c.Value, 64)
+		switch c.Op {
+		case "<":
+			return v < want
+		case "<=":
+			return v <= want
+		case ">":
+			return v > want
+		case ">=":
+			return v >= want
+		}
+		equal = v == want
+	case bool:
+		want, _ := strconv.ParseBool(c.Value)
+		equal = v == want
+	case string:
+		equal = strings.EqualFold(v, c.Value)
+	case []string:
+		for _, item := range v {
+			equal = equal || strings.EqualFold(item, c.Value)
+		}
+	}
+	return equal == (c.Op == "=")
+}
+
+// holds evaluates the condition for one entry (nil for a run-wide condition).
+func (c failOnCondition) holds(run *runOutcome, entry *CommitAuditData) bool {
+	for _, group := range c.AnyOf {
+		all := true
+		for _, cmp := range group {
+			all = all && cmp.test(run, entry)
+		}
+		if all {
+			return true
+		}
+	}
+	return false
+}
+
+// failOnResult is the outcome of one -fail-on condition.
+type failOnResult struct {
+	Condition failOnCondition
+	Met       bool
+	// Commits are the entries that met a per-entry condition.
+	Commits []string
+}
+
+// evaluate tests the condition against the run.
+func (c failOnCondition) evaluate(run *runOutcome) failOnResult {
+	result := failOnResult{Condition: c}
+	if !c.PerEntry {
+		result.Met = c.holds(run, nil)
+		return result
+	}
+	for i := range run.Entries {
+		if c.holds(run, &run.Entries[i]) {
+			result.Commits = append(result.Commits, run.Entries[i].Hash)
+		}
+	}
+	result.Met = len(result.Commits) > 0
+	return result
+}
+
+// failOnFlag collects the repeatable -fail-on flag; the run fails if any condition is met.
+type failOnFlag []failOnCondition
+
+func (f *failOnFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	texts := make([]string, len(*f))
+	for i, c := range *f {
+		texts[i] = c.Text
+	}
+	return strings.Join(texts, "; ")
+}
+
+func (f *failOnFlag) Set(value string) error {
+	c, err := parseFailOn(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, c)
+	return nil
+}
+
+// evaluateFailOn evaluates every -fail-on condition, prints those that are met with the
+// commits that met them, and returns the results and the report header lines recording them.
+func evaluateFailOn(conditions failOnFlag, run *runOutcome) (results []failOnResult, header string) {
+	var lines []string
+	for _, c := range conditions {
+		result := c.evaluate(run)
+		results = append(results, result)
+		if !result.Met {
+			lines = append(lines, msg("header.fail_on_clear", c.Text))
+			continue
+		}
+		hashes := make([]string, len(result.Commits))
+		for i, hash := range result.Commits {
+			hashes[i] = shortHash(hash)
+		}
+		if len(hashes) > 0 {
+			fmt.Printf("Fail-on condition met: %s (%d commits):\n", c.Text, len(hashes))
+			for _, hash := range result.Commits {
+				fmt.Printf("  %s\n", hash)
+			}
+			lines = append(lines, msg("header.fail_on_met_by", c.Text, strings.Join(hashes, ", ")))
+		} else {
+			fmt.Printf("Fail-on condition met: %s\n", c.Text)
+			lines = append(lines, msg("header.fail_on_met", c.Text))
+		}
+	}
+	return results, strings.Join(lines, "\n")
+}
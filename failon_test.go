@@ -0,0 +1,168 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenizeFailOn(t *testing.T) {
+	tests := map[string][]string{
+		"failed > 0":                        {"failed", ">", "0"},
+		"failed>=2":                         {"failed", ">=", "2"},
+		"kind=merge AND withheld_files!=0":  {"kind", "=", "merge", "AND", "withheld_files", "!=", "0"},
+		`author = "Ada Lovelace" OR cost<1`: {"author", "=", `"Ada Lovelace"`, "OR", "cost", "<", "1"},
+		"author = 'O''Brien'":               {"author", "=", "'O'", "'Brien'"},
+		"  audited  ==\t3 ":                 {"audited", "==", "3"},
+	}
+	for text, want := range tests {
+		if got, err := tokenizeFailOn(text); err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("tokenizeFailOn(%q) = %q, %v; want %q", text, got, err, want)
+		}
+	}
+	if _, err := tokenizeFailOn(`author = "Ada`); err == nil {
+		t.Error("an unterminated quote was accepted")
+	}
+}
+
+func TestParseFailOn(t *testing.T) {
+	cond, err := parseFailOn(`failed > 0 OR kind == merge and withheld_files >= 1 OR author = "Ada Lovelace"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// AND binds tighter than OR; == is =, and the quotes are removed.
+	want := [][]failOnComparison{
+		{{"failed", ">", "0"}},
+		{{"kind", "=", "merge"}, {"withheld_files", ">=", "1"}},
+		{{"author", "=", "Ada Lovelace"}},
+	}
+	if !reflect.DeepEqual(cond.AnyOf, want) || !cond.PerEntry {
+		t.Errorf("parsed %+v, per entry %v", cond.AnyOf, cond.PerEntry)
+	}
+	if cond, err := parseFailOn("FAILED > 0"); err != nil || cond.PerEntry || cond.AnyOf[0][0].Field != "failed" {
+		t.Errorf("run-wide condition: %+v, %v", cond, err)
+	}
+
+	for text, want := range map[string]string{
+		"":                           `expected "field op value"`,
+		"failed >":                   `expected "field op value"`,
+		"failed > 0 AND":             `expected "field op value"`,
+		"failed > 0 XOR audited > 1": `expected AND or OR, found "XOR"`,
+		"risk = high":                `unknown field "risk"; fields are `,
+		"failed 0 1":                 `expected an operator after failed, found "0"`,
+		"failed > many":              `failed is a number, but "many" is not`,
+		"kind > merge":               "kind can only be compared with = or !=",
+		"leak_masked = maybe":        `leak_masked is true or false, but "maybe" is not`,
+		"leak_masked < true":         "leak_masked can only be compared with = or !=",
+		"severity >= high":           "severity can only be compared with = or !=",
+		`author = "Ada`:              "unterminated quote",
+	} {
+		if _, err := parseFailOn(text); err == nil || !strings.Contains(err.Error(), want) || !strings.HasPrefix(err.Error(), "invalid -fail-on ") {
+			t.Errorf("parseFailOn(%q) = %v, want %q", text, err, want)
+		}
+	}
+}
+
+func TestFailOnEvaluate(t *testing.T) {
+	run := &runOutcome{
+		Failed: 1,
+		Cost:   0.25,
+		Entries: []CommitAuditData{
+			{Hash: "aaa", Kind: kindCommit, Author: "Ada Lovelace", WithheldFiles: 2, LeakMasked: true},
+			{Hash: "bbb", Kind: kindMerge, Author: "Grace Hopper", Vulnerabilities: []vulnerability{{ID: "GHSA-1", Severity: "HIGH"}, {ID: "GHSA-2", Severity: "low"}}},
+			{Hash: "ccc", Kind: kindMerge, Author: "Ada Lovelace", WithheldFiles: 1, CitationStatus: citationsUnverified},
+			{Hash: "ddd", Kind: kindTag},
+		},
+	}
+	tests := []struct {
+		text    string
+		met     bool
+		commits []string
+	}{
+		{"failed > 0", true, nil},
+		{"failed >= 2", false, nil},
+		{"audited = 3", true, nil},
+		{"cost > 0.2 AND cost < 0.3", true, nil},
+		{"cost > 1 OR failed = 0", false, nil},
+		{"unverified_citations >= 1", true, nil},
+		{"masked_leaks > 1", false, nil},
+		{"kind = merge", true, []string{"bbb", "ccc"}},
+		{"kind = MERGE AND withheld_files > 0", true, []string{"ccc"}},
+		{"kind != commit AND kind != tag AND withheld_files = 0", true, []string{"bbb"}},
+		{`author = "ada lovelace"`, true, []string{"aaa", "ccc"}},
+		{"leak_masked = true OR withheld_files > 1", true, []string{"aaa"}},
+		{"severity = high", true, []string{"bbb"}},
+		{"severity != critical AND kind = merge", true, []string{"bbb", "ccc"}},
+		{"vulnerabilities > 2", false, nil},
+		// A run field keeps its run-wide value in a per-entry condition.
+		{"failed > 0 AND kind = tag", true, []string{"ddd"}},
+		{"failed = 0 AND kind = tag", false, nil},
+	}
+	for _, tt := range tests {
+		cond, err := parseFailOn(tt.text)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.text, err)
+		}
+		result := cond.evaluate(run)
+		if result.Met != tt.met || !reflect.DeepEqual(result.Commits, tt.commits) {
+			t.Errorf("%s: met %v by %q, want %v by %q", tt.text, result.Met, result.Commits, tt.met, tt.commits)
+		}
+	}
+}
+
+func TestEvaluateFailOnHeader(t *testing.T) {
+	var conditions failOnFlag
+	for _, text := range []string{"failed > 0", "kind = merge", "cost > 5"} {
+		if err := conditions.Set(text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := conditions.String(); got != "failed > 0; kind = merge; cost > 5" {
+		t.Errorf("String() = %q", got)
+	}
+	run := &runOutcome{Failed: 2, Entries: []CommitAuditData{{Hash: "0123456789abcdef", Kind: kindMerge}, {Hash: "fedcba9876543210", Kind: kindCommit}}}
+	var results []failOnResult
+	var header string
+	out := captureStdout(t, func() { results, header = evaluateFailOn(conditions, run) })
+	if len(results) != 3 || !results[0].Met || !results[1].Met || results[2].Met {
+		t.Errorf("results %+v", results)
+	}
+	wantHeader := "Fail-on condition met: failed > 0\nFail-on condition met: kind = merge (01234567)\nFail-on condition not met: cost > 5"
+	if header != wantHeader {
+		t.Errorf("header:\n%s\nwant:\n%s", header, wantHeader)
+	}
+	if out != "Fail-on condition met: failed > 0\nFail-on condition met: kind = merge (1 commits):\n  0123456789abcdef\n" {
+		t.Errorf("output:\n%s", out)
+	}
+}
+
+func TestFailOnRun(t *testing.T) {
+	f := newMergeFixture(t)
+	env := newAuditEnv(t)
+	report := filepath.Join(env.Work, "report.txt")
+
+	// A met condition exits with 3, prints the commits and records the outcome in the header.
+	out, code := env.run("-repo", f.Repo.Dir, "-commit", "root", "-output", report, "-fail-on", "kind = merge", "-fail-on", "failed > 0")
+	if code != exitFailOn || !strings.Contains(out, "Fail-on condition met: kind = merge (") || !strings.Contains(out, "  "+f.Octopus+"\n") {
+		t.Errorf("exit %d:\n%s", code, out)
+	}
+	text := readFile(t, report)
+	if !strings.Contains(text, "Fail-on condition met: kind = merge (") || !strings.Contains(text, shortHash(f.Octopus)) || !strings.Contains(text, "Fail-on condition not met: failed > 0\n") {
+		t.Errorf("report header:\n%s", text)
+	}
+
+	// Conditions that are not met leave the exit status alone.
+	if out, code := env.run("-repo", f.Repo.Dir, "-commit", "root", "-output", report, "-force", "-fail-on", "failed > 0"); code != 0 {
+		t.Errorf("unmet condition: exit %d\n%s", code, out)
+	}
+
+	// A condition that does not parse fails before any commit is audited.
+	before := len(env.Ollama.Prompts())
+	if out, code := env.run("-repo", f.Repo.Dir, "-commit", "root", "-output", report, "-force", "-fail-on", "risk = high"); code == 0 || code == exitFailOn || !strings.Contains(out, `unknown field "risk"`) {
+		t.Errorf("invalid condition: exit %d\n%s", code, out)
+	}
+	if len(env.Ollama.Prompts()) != before {
+		t.Error("an invalid -fail-on audited commits")
+	}
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultFileHistoryName is the document `gitaudit file` writes when -output is not given.
+const defaultFileHistoryName = "gitaudit-file.md"
+
+// fileCommandFlags are the flags of `gitaudit file` besides the prompt flags.
+type fileCommandFlags struct {
+	Repo         string
+	Count        int
+	Output       string
+	Force        bool
+	NoRepoWrites bool
+	Retries      int
+}
+
+// registerFileFlags defines the flags of `gitaudit file` besides the prompt flags on fs.
+func registerFileFlags(fs *flag.FlagSet) *fileCommandFlags {
+	f := &fileCommandFlags{}
+	fs.StringVar(&f.Repo, "repo", ".", "Path to the Git repository; the file's path is relative to it")
+	fs.IntVar(&f.Count, "n", 20, "Number of the latest commits touching the file to audit")
+	fs.StringVar(&f.Output, "output", "", "Path of the markdown document (default \""+defaultFileHistoryName+"\", which is always replaced)")
+	fs.BoolVar(&f.Force, "force", false, "Replace the -output file if it exists")
+	fs.BoolVar(&f.NoRepoWrites, "no-repo-writes", false, "Refuse an -output inside the repository working tree")
+	fs.IntVar(&f.Retries, "retries", 2, "Number of additional attempts after a failed generation before giving up")
+	return f
+}
+
+// fileRevision is one commit of a file's history, as git log --follow lists it.
+type fileRevision struct {
+	Hash string
+	// Date is the author date, YYYY-MM-DD.
+	Date string
+	// Status is the name-status letter of the file in the commit: A, M, D or R (renamed from
+	// OldPath), or "" for a merge git shows no change for.
+	Status  string
+	Path    string
+	OldPath string
+	Added   int
+	Deleted int
+	// Binary is set when git counts no lines for the file's change.
+	Binary bool
+}
+
+// fileRename records that a file was known as From until the commit that renamed it to To.
+type fileRename struct {
+	From, To string
+	Hash     string
+	Date     string
+}
+
+// getFileHistory lists the latest count commits touching path, newest first, following
+// renames. It is an error for a path no commit ever touched; a file deleted at HEAD has the
+// history that ends with its deletion.
+func getFileHistory(repoPath, path string, count int) ([]fileRevision, error) {
+	output, err := gitRun(context.Background(), repoPath, "log", "--follow", "-M", "-n", fmt.Sprint(count),
+		"--format=@@%H%x00%ad", "--date=short", "--name-status", "--", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute git log --follow for %s: %w", path, err)
+	}
+	var history []fileRevision
+	for _, line := range strings.Split(string(output), "\n") {
+		if rest, ok := strings.CutPrefix(line, "@@"); ok {
+			hash, date, _ := strings.Cut(rest, "\x00")
+			history = append(history, fileRevision{Hash: hash, Date: date})
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(history) == 0 || len(fields) < 2 || fields[0] == "" {
+			continue
+		}
+		rev := &history[len(history)-1]
+		rev.Status = fields[0][:1]
+		rev.Path = fields[len(fields)-1]
+		if rev.Status == "R" && len(fields) == 3 {
+			rev.OldPath = fields[1]
+		}
+	}
+	if len(history) == 0 {
+		return nil, fmt.Errorf("%s has no history in %s: no commit ever touched that path", path, repoPath)
+	}
+	// Merges git shows no change for keep the path of the next newer commit.
+	for i := range history {
+		if history[i].Path == "" && i > 0 {
+			history[i].Path = history[i-1].Path
+		}
+	}
+
+	output, err = gitRun(context.Background(), repoPath, "log", "--follow", "-M", "-n", fmt.Sprint(count),
+		"--format=@@%H", "--numstat", "--", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute git log --follow --numstat for %s: %w", path, err)
+	}
+	byHash := make(map[string]*fileRevision, len(history))
+	for i := range history {
+		byHash[history[i].Hash] = &history[i]
+	}
+	var current *fileRevision
+	for _, line := range strings.Split(string(output), "\n") {
+		if hash, ok := strings.CutPrefix(line, "@@"); ok {
+			current = byHash[hash]
+			continue
+		}
+		fields := strings.SplitN(line, "\t", 3)
+		if current == nil || len(fields) < 3 {
+			continue
+		}
+		if fields[0] == "-" {
+			current.Binary = true
+			continue
+		}
+		current.Added, _ = strconv.Atoi(fields[0])
+		current.Deleted, _ = strconv.Atoi(fields[1])
+	}
+	return history, nil
+}
+
+// fileRenames lists the renames of a history, newest first.
+func fileRenames(history []fileRevision) []fileRename {
+	var renames []fileRename
+	for _, rev := range history {
+		if rev.Status == "R" && rev.OldPath != "" && rev.OldPath != rev.Path {
+			renames = append(renames, fileRename{From: rev.OldPath, To: rev.Path, Hash: rev.Hash, Date: rev.Date})
+		}
+	}
+	return renames
+}
+
+// filePathspec returns the pathspec that scopes the audited patches to the file under every
+// name the history gives it, so that each commit's prompt holds only that file's hunks.
+func filePathspec(history []fileRevision) []string {
+	var pathspec []string
+	seen := make(map[string]bool)
+	for _, rev := range history {
+		for _, path := range []string{rev.Path, rev.OldPath} {
+			if path != "" && !seen[path] {
+				seen[path] = true
+				pathspec = append(pathspec, ":(top,literal)"+path)
+			}
+		}
+	}
+	return pathspec
+}
+
+// runFile implements `gitaudit file [flags] <path>`: it audits the latest commits touching
+// one file, scoped to its hunks, and writes a markdown document (gitaudit-file.md) with a
+// narrative of how the file evolved followed by the per-commit entries.
+func runFile(args []string) {
+	fs := newCommandFlagSet("file")
+	file := registerFileFlags(fs)
+	prompt := registerPromptFlags(fs)
+
+	// Accept flags both before and after the path, e.g. `file src/auth/session.go -n 30`.
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fmt.Println("Error: a file path is required.")
+		fs.Usage()
+		os.Exit(1)
+	}
+	path := fs.Arg(0)
+	fs.Parse(fs.Args()[1:])
+	if fs.NArg() > 0 {
+		fmt.Printf("Error: unexpected arguments after the path: %s\n", strings.Join(fs.Args(), " "))
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	if err := applyProfile(fs, prompt.Profile); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	prompt.setConfigOverrides()
+	validateFlags(fs, lookupCommand("file").Rules)
+	if prompt.ExplainFlags {
+		explainFlags(fs)
+		return
+	}
+	if file.Count < 1 {
+		fmt.Println("Error: -n must be at least 1")
+		os.Exit(1)
+	}
+
+	repoRoot, err := getRepoTopLevel(requestCtx, file.Repo)
+	if err != nil {
+		fmt.Printf("Error resolving repository root: %v\n", err)
+		os.Exit(1)
+	}
+	output := file.Output
+	if output == "" {
+		output = defaultFileHistoryName
+	}
+	outputFile, err := outputPath(output, repoRoot, file.NoRepoWrites)
+	if err == nil && file.Output != "" && !file.Force {
+		err = checkNoClobber(outputFile)
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	history, err := getFileHistory(file.Repo, path, file.Count)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Printf("Error loading configuration: %v\n", err)
+		os.Exit(1)
+	}
+	if err := prompt.selectModel(config); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	opts, err := prompt.auditOptions(file.Repo, config)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	opts.Pathspec = filePathspec(history)
+	// Only the document is written, and not into the repository under -no-repo-writes; the
+	// cache store stays out of it either way.
+	opts.Cache = openCacheStore(repoRoot, true)
+	if err := prompt.discoverModelInfo(opts); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := prompt.prepareRecordDir(repoRoot, true); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	opts.OSV = prompt.newOSVClient(opts.Config, opts.Cache)
+
+	fmt.Printf("File: %s (%s)\n", path, msgCount("count.commits", len(history)))
+	entries := make([]CommitAuditData, len(history))
+	for i, rev := range history {
+		state := &commitState{}
+		for attempt := 0; ; attempt++ {
+			detail := state.detailLevel(opts.Ladder)
+			if rev.Binary {
+				// A binary diff tells the model nothing the stats do not.
+				detail = detailStatsOnly
+			}
+			entries[i], err = auditCommit(requestCtx, opts, rev.Hash, detail)
+			if err == nil {
+				break
+			}
+			exitOnPolicyViolation(err)
+			if attempt >= file.Retries || isPermanent(err) {
+				fmt.Printf("Error: failed to audit commit %s after %d attempts: %v\n", rev.Hash, attempt+1, err)
+				os.Exit(1)
+			}
+			fmt.Printf("Attempt %d for commit %s failed: %v. Retrying.\n", attempt+1, rev.Hash, err)
+			state.recordFailure(rev.Hash, err, opts.Ladder, opts.DegradeAfter)
+		}
+		fmt.Printf("Successfully processed commit %s (%d of %d)\n", rev.Hash, i+1, len(history))
+	}
+	opts.Cache.RecordRun("file")
+
+	fmt.Printf("Generating the narrative of %s\n", path)
+	narrative, err := opts.Generator.Generate(requestCtx, buildFileNarrativePrompt(opts, path, history, entries))
+	if err != nil {
+		fmt.Printf("Warning: failed to generate the narrative: %v\n", err)
+		narrative.Text = fmt.Sprintf("(No narrative generated: %v)", err)
+	}
+
+	document := formatFileHistory(path, history, entries, narrative.Text, file.Count)
+	if err := writeFileAtomic(outputFile, []byte(document)); err != nil {
+		fmt.Printf("Error: failed to write %s: %v\n", outputFile, err)
+		os.Exit(1)
+	}
+	// writeFileAtomic uses CreateTemp's 0600; the document is as readable as a report.
+	os.Chmod(outputFile, 0o644)
+	fmt.Printf("\nSuccessfully wrote the history of %s to %s\n", path, outputFile)
+}
+
+// buildFileNarrativePrompt asks the model for the story of a file from the summaries of the
+// commits that touched it, oldest first, cut to the prompt budget as the author rollup is.
+func buildFileNarrativePrompt(opts *auditOptions, path string, history []fileRevision, entries []CommitAuditData) string {
+	var counter tokenizer = heuristicTokenizer{}
+	limit := defaultRollupInputBytes / bytesPerToken
+	if opts.Budget != nil {
+		counter = opts.Budget.Tokenizer
+		limit = opts.Budget.ContextTokens * int(opts.Budget.Split.Patch) / 100
+	}
+	var sb strings.Builder
+	for i := len(history) - 1; i >= 0; i-- {
+		rev := history[i]
+		line := rev.Date
+		if author := opts.Privacy.filter(opts.Privacy.SendAuthor, entries[i].Author); author != "" {
+			line += ", " + author
+		}
+		line += fmt.Sprintf(", %s, +%d -%d", describeFileStatus(rev), rev.Added, rev.Deleted)
+		fmt.Fprintf(&sb, "%s:\n%s\n\n---\n\n", line, entries[i].Summary)
+	}
+	summaries := strings.TrimSuffix(sb.String(), "\n\n---\n\n")
+	summaries = truncateTokens(counter, summaries, counter.Count(summaries), limit, "commit summaries")
+
+	var renames []string
+	for _, r := range fileRenames(history) {
+		renames = append(renames, fmt.Sprintf("%s was renamed to %s on %s", r.From, r.To, r.Date))
+	}
+	renameText := "It was not renamed in these commits."
+	if len(renames) > 0 {
+		renameText = strings.Join(renames, "; ") + "."
+	}
+	return fmt.Sprintf(`The following are summaries of the %d latest commits that touched the file %s, oldest first, each scoped to that file's changes and headed by its date, author, kind of change and lines added and deleted. %s
+
+Write a short narrative, a few paragraphs, of how this file evolved: its major rewrites, changes of ownership between authors, recurring areas of bug fixes, and how its purpose changed. Refer to commits by date. Output only the narrative itself.
+
+Commit summaries:
+%s`, len(history), path, renameText, summaries)
+}
+
+// describeFileStatus names the change a commit made to the file, for the narrative prompt.
+func describeFileStatus(rev fileRevision) string {
+	switch rev.Status {
+	case "A":
+		return "added"
+	case "D":
+		return "deleted"
+	case "R":
+		return "renamed from " + rev.OldPath
+	case "":
+		return "merged"
+	}
+	if rev.Binary {
+		return "modified (binary)"
+	}
+	return "modified"
+}
+
+// formatFileHistory renders the markdown document of `gitaudit file`: the file's rename
+// history, deletion and span first, then the narrative and the per-commit entries, newest
+// first.
+func formatFileHistory(path string, history []fileRevision, entries []CommitAuditData, narrative string, count int) string {
+	var sb strings.Builder
+	sb.WriteString(msg("file.title", path) + "\n\n")
+	newest, oldest := history[0], history[len(history)-1]
+	if len(history) < count {
+		sb.WriteString("- " + msg("file.span_all", msgCount("count.commits", len(history)), oldest.Date, newest.Date) + "\n")
+	} else {
+		sb.WriteString("- " + msg("file.span_latest", msgCount("count.commits", len(history)), oldest.Date, newest.Date) + "\n")
+	}
+	for _, r := range fileRenames(history) {
+		sb.WriteString("- " + msg("file.renamed", r.From, monthOf(r.Date), shortHash(r.Hash)) + "\n")
+	}
+	if newest.Status == "D" {
+		sb.WriteString("- " + msg("file.deleted", shortHash(newest.Hash), newest.Date) + "\n")
+	}
+	if newest.Binary {
+		sb.WriteString("- " + msg("file.binary") + "\n")
+	}
+	sb.WriteString("\n" + msg("file.narrative") + "\n\n" + strings.TrimSpace(narrative) + "\n\n" + msg("file.commits") + "\n")
+	for i, rev := range history {
+		entry := entries[i]
+		heading := fmt.Sprintf("### `%s` %s", shortHash(rev.Hash), rev.Date)
+		if entry.Author != "" {
+			heading += ", " + entry.Author
+		}
+		sb.WriteString("\n" + heading + "\n\n")
+		switch {
+		case rev.Status == "R":
+			sb.WriteString(msg("file.entry_renamed", rev.OldPath, rev.Path) + "\n")
+		case rev.Path != path:
+			sb.WriteString(msg("file.entry_path", rev.Path) + "\n")
+		}
+		if rev.Binary {
+			sb.WriteString(msg("file.entry_binary") + "\n")
+		} else if rev.Status != "" {
+			sb.WriteString(fmt.Sprintf("+%d -%d\n", rev.Added, rev.Deleted))
+		}
+		sb.WriteString("\n" + strings.TrimSpace(entry.Summary) + "\n")
+	}
+	return sb.String()
+}
+
+// monthOf renders a YYYY-MM-DD date as its month, YYYY-MM.
+func monthOf(date string) string {
+	t, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return date
+	}
+	return t.Format("2006-01")
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fileHistoryRepo builds a repository whose files have the histories `gitaudit file` must
+// handle: src/session.go, renamed from src/auth.go, with a neighbor changed in the same
+// commits; logo.png, a binary file; and notes.txt, deleted at HEAD.
+func fileHistoryRepo(t *testing.T) *fixtureRepo {
+	t.Helper()
+	repo := newFixtureRepo(t)
+	repo.commit("Add auth", map[string]string{"src/auth.go": "package src\n\nfunc Login() {}\n"})
+	repo.commit("Add logout", map[string]string{
+		"src/auth.go":  "package src\n\nfunc Login() {}\n\nfunc Logout() {}\n",
+		"src/other.go": "package src\n",
+	})
+	repo.git("mv", "src/auth.go", "src/session.go")
+	repo.commit("Rename auth to session", nil)
+	repo.commit("Add refresh", map[string]string{
+		"src/session.go": "package src\n\nfunc Login() {}\n\nfunc Logout() {}\n\nfunc Refresh() {}\n",
+		"src/other.go":   "package src\n\nvar other = 1\n",
+	})
+	repo.commit("Add the logo", map[string]string{"logo.png": "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x01"})
+	repo.commit("Redraw the logo", map[string]string{"logo.png": "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x02"})
+	repo.commit("Add notes", map[string]string{"notes.txt": "one\ntwo\n"})
+	repo.commit("Drop the notes", map[string]string{"notes.txt": ""})
+	return repo
+}
+
+func TestGetFileHistory(t *testing.T) {
+	repo := fileHistoryRepo(t)
+	for _, tt := range []struct {
+		name, path string
+		count      int
+		// want lists status, path and old path of each revision, newest first.
+		want    []string
+		added   []int
+		binary  bool
+		wantErr string
+	}{
+		{
+			name: "renamed", path: "src/session.go", count: 20,
+			want:  []string{"M src/session.go", "R src/session.go src/auth.go", "M src/auth.go", "A src/auth.go"},
+			added: []int{2, 0, 2, 3},
+		},
+		{name: "latest only", path: "src/session.go", count: 2, want: []string{"M src/session.go", "R src/session.go src/auth.go"}, added: []int{2, 0}},
+		{name: "deleted at HEAD", path: "notes.txt", count: 20, want: []string{"D notes.txt", "A notes.txt"}, added: []int{0, 2}},
+		{name: "binary", path: "logo.png", count: 20, want: []string{"M logo.png", "A logo.png"}, added: []int{0, 0}, binary: true},
+		{name: "never existed", path: "src/missing.go", count: 20, wantErr: "src/missing.go has no history in " + repo.Dir + ": no commit ever touched that path"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			history, err := getFileHistory(repo.Dir, tt.path, tt.count)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			var added []int
+			for _, rev := range history {
+				got = append(got, strings.TrimSpace(rev.Status+" "+rev.Path+" "+rev.OldPath))
+				added = append(added, rev.Added)
+				if rev.Binary != tt.binary || len(rev.Hash) != 40 || len(rev.Date) != len("2006-01-02") {
+					t.Errorf("revision %+v", rev)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) || !reflect.DeepEqual(added, tt.added) {
+				t.Errorf("history %q, added %v; want %q, %v", got, added, tt.want, tt.added)
+			}
+		})
+	}
+}
+
+func TestFilePathspec(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		history []fileRevision
+		want    []string
+	}{
+		{name: "one name", history: []fileRevision{{Status: "M", Path: "a.go"}, {Status: "A", Path: "a.go"}}, want: []string{":(top,literal)a.go"}},
+		{
+			name:    "renamed twice",
+			history: []fileRevision{{Status: "R", Path: "c.go", OldPath: "b.go"}, {Status: "M", Path: "b.go"}, {Status: "R", Path: "b.go", OldPath: "a.go"}, {Status: "A", Path: "a.go"}},
+			want:    []string{":(top,literal)c.go", ":(top,literal)b.go", ":(top,literal)a.go"},
+		},
+		// Literal: a name with glob characters matches only itself.
+		{name: "glob characters", history: []fileRevision{{Status: "A", Path: "docs/[draft]*.md"}}, want: []string{":(top,literal)docs/[draft]*.md"}},
+	} {
+		if got := filePathspec(tt.history); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRunFile(t *testing.T) {
+	repo := fileHistoryRepo(t)
+	env := newAuditEnv(t)
+	document := filepath.Join(env.Work, "history.md")
+	for _, tt := range []struct {
+		name, path string
+		// commits is the number of commits audited; the narrative is one more prompt.
+		commits int
+		want    []string
+		// scoped is the text of another file each commit's prompt must not hold.
+		scoped string
+	}{
+		{
+			name: "renamed", path: "src/session.go", commits: 4, scoped: "src/other.go",
+			want: []string{"All 4 commits touching the file", "Previously known as `src/auth.go` until ", "Renamed from `src/auth.go` to `src/session.go`", "Path: `src/auth.go`"},
+		},
+		{name: "deleted at HEAD", path: "notes.txt", commits: 2, want: []string{"Deleted in " + shortHash(repo.git("rev-parse", "HEAD")) + " on ", "+0 -2\n"}},
+		{name: "binary", path: "logo.png", commits: 2, want: []string{"Binary file: each entry was generated from the commit's stats only", "Binary change, summarized from its stats only"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(env.Ollama.Prompts())
+			env.mustRun("file", "-repo", repo.Dir, "-output", document, "-force", tt.path)
+			text := readFile(t, document)
+			if n := strings.Count(text, "\n### `"); n != tt.commits {
+				t.Errorf("%d entries, want %d:\n%s", n, tt.commits, text)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(text, want) {
+					t.Errorf("the document lacks %q:\n%s", want, text)
+				}
+			}
+			prompts := env.Ollama.Prompts()[before:]
+			if len(prompts) != tt.commits+1 {
+				t.Fatalf("%d prompts, want %d", len(prompts), tt.commits+1)
+			}
+			for _, prompt := range prompts[:tt.commits] {
+				if tt.scoped != "" && (strings.Contains(prompt, tt.scoped) || !strings.Contains(prompt, "diff --git a/src/")) {
+					t.Errorf("prompt not scoped to %s:\n%s", tt.path, prompt)
+				}
+			}
+		})
+	}
+
+	// A path no commit touched is an error, and writes nothing.
+	missing := filepath.Join(env.Work, "missing.md")
+	if out, code := env.run("file", "-repo", repo.Dir, "-output", missing, "src/missing.go"); code == 0 || !strings.Contains(out, "src/missing.go has no history in ") {
+		t.Errorf("a path that never existed: exit %d\n%s", code, out)
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Errorf("a document was written for a path that never existed: %v", err)
+	}
+}
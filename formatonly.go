@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// formattingToolHints maps the words of a commit message to the formatter they imply. They
+// are matched as whole words, ignoring case, so "black" does not fire on "blacklist".
+var formattingToolHints = []struct {
+	pattern *regexp.Regexp
+	tool    string
+}{
+	{toolHintPattern("gofmt"), "gofmt"},
+	{toolHintPattern("goimports"), "goimports"},
+	{toolHintPattern("prettier"), "prettier"},
+	{toolHintPattern("black"), "black"},
+	{toolHintPattern("rustfmt"), "rustfmt"},
+	{toolHintPattern("cargo fmt"), "rustfmt"},
+	{toolHintPattern("clang-format"), "clang-format"},
+	{toolHintPattern("eslint"), "eslint"},
+	{toolHintPattern("autopep8"), "autopep8"},
+	{toolHintPattern("isort"), "isort"},
+	{toolHintPattern("ruff format"), "ruff"},
+	{toolHintPattern("swiftformat"), "swiftformat"},
+	{toolHintPattern("ktlint"), "ktlint"},
+}
+
+// toolHintPattern matches word as a whole word, ignoring case.
+func toolHintPattern(word string) *regexp.Regexp {
+	return regexp.MustCompile(`(?i)\b` + regexp.QuoteMeta(word) + `\b`)
+}
+
+// formattingTools returns the formatters a commit message names, in formattingToolHints order.
+func formattingTools(message string) []string {
+	var tools []string
+	for _, hint := range formattingToolHints {
+		if hint.pattern.MatchString(message) && !containsString(tools, hint.tool) {
+			tools = append(tools, hint.tool)
+		}
+	}
+	return tools
+}
+
+// formattingClassification is the result of comparing a commit's diff with and without whitespace changes.
+type formattingClassification struct {
+	OriginalChangedLines int
+	IgnoredChangedLines  int
+	// FormattingOnly is true when ignoring whitespace leaves an empty diff. A diff with any
+	// line left is Borderline at most: a logic change hidden in a mass reformat must still
+	// reach the model.
+	FormattingOnly bool
+	// Borderline is true when the whitespace-ignored diff is below the hint threshold
+	// but not small enough to skip the model entirely.
+	Borderline bool
+}
+
+// Hint returns the prompt hint used for borderline formatting commits.
+func (c formattingClassification) Hint() string {
+	return fmt.Sprintf("Ignoring whitespace, only %d of the %d changed lines remain; most of this commit appears to be reformatting. Focus the description on the substantive changes.",
+		c.IgnoredChangedLines, c.OriginalChangedLines)
+}
+
+// classifyFormatting compares `git show` with and without `-w --ignore-blank-lines` for a commit to decide whether
+// the commit is formatting-only. hintThreshold is a percentage of the original diff size.
+func classifyFormatting(ctx context.Context, repoPath, commitHash string, hintThreshold float64) (formattingClassification, error) {
+	original, err := gitDiffBody(ctx, repoPath, commitHash, false)
+	if err != nil {
+		return formattingClassification{}, err
+	}
+	ignored, err := gitDiffBody(ctx, repoPath, commitHash, true)
+	if err != nil {
+		return formattingClassification{}, err
+	}
+
+	c := formattingClassification{
+		OriginalChangedLines: countChangedLines(original),
+		IgnoredChangedLines:  countChangedLines(ignored),
+	}
+	if c.OriginalChangedLines == 0 {
+		// Nothing to compare against (e.g. an empty commit or a pure mode/rename change).
+		return c, nil
+	}
+
+	ratio := float64(c.IgnoredChangedLines) / float64(c.OriginalChangedLines)
+	switch {
+	case c.IgnoredChangedLines == 0:
+		c.FormattingOnly = true
+	case ratio*100 < hintThreshold:
+		c.Borderline = true
+	}
+	return c, nil
+}
+
+// gitDiffBody returns the diff of a commit without the commit header, optionally ignoring whitespace.
+func gitDiffBody(ctx context.Context, repoPath, commitHash string, ignoreWhitespace bool) (string, error) {
+	args := []string{"show", "--format=", "--patch"}
+	if ignoreWhitespace {
+		// -w alone still reports inserted or removed blank lines, which formatters produce constantly.
+		args = append(args, "-w", "--ignore-blank-lines")
+	}
+	args = append(args, commitHash)
+
+	output, err := gitRun(ctx, repoPath, args...)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute git show for diff of commit %s: %w", commitHash, err)
+	}
+	return string(output), nil
+}
+
+// countChangedLines counts added and removed lines in a unified diff, ignoring file headers.
+func countChangedLines(diff string) int {
+	count := 0
+	for _, line := range strings.Split(diff, "\n") {
+		if strings.HasPrefix(line, "+++") || strings.HasPrefix(line, "---") {
+			continue
+		}
+		if strings.HasPrefix(line, "+") || strings.HasPrefix(line, "-") {
+			count++
+		}
+	}
+	return count
+}
+
+// formattingOnlySummary builds the templated summary used instead of an Ollama call for
+// formatting-only commits. It mentions the number of files touched and any formatter named
+// in the original commit message.
+func formattingOnlySummary(ctx context.Context, repoPath, commitHash string, c formattingClassification) (string, error) {
+	message, err := getCommitMessage(ctx, repoPath, commitHash)
+	if err != nil {
+		return "", err
+	}
+	files, err := getChangedFiles(ctx, repoPath, commitHash)
+	if err != nil {
+		return "", err
+	}
+
+	tools := formattingTools(message)
+
+	var sb strings.Builder
+	fileWord := "files"
+	if len(files) == 1 {
+		fileWord = "file"
+	}
+	fmt.Fprintf(&sb, "Formatting-only change across %d %s.\n\n", len(files), fileWord)
+	fmt.Fprintf(&sb, "Ignoring whitespace, the diff is empty (none of %d changed lines remain), so this commit reformats code without changing its behaviour.",
+		c.OriginalChangedLines)
+	if len(tools) > 0 {
+		fmt.Fprintf(&sb, " The commit message suggests it was produced by %s.", strings.Join(tools, ", "))
+	}
+	if subject := strings.TrimSpace(strings.SplitN(message, "\n", 2)[0]); subject != "" {
+		fmt.Fprintf(&sb, "\n\nOriginal commit message: %s", subject)
+	}
+	return sb.String(), nil
+}
+
+// containsString reports whether s is present in list.
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFormattingTools(t *testing.T) {
+	tests := []struct {
+		message string
+		want    []string
+	}{
+		{"Run black over the package", []string{"black"}},
+		{"Reformat with Black", []string{"black"}},
+		{"style: black + isort", []string{"black", "isort"}},
+		{"Add the IP blacklist", nil},
+		{"Blackbox tests for the parser", nil},
+		{"gofmt -s and goimports", []string{"gofmt", "goimports"}},
+		{"cargo fmt; rustfmt.toml tweak", []string{"rustfmt"}},
+		{"Apply clang-format", []string{"clang-format"}},
+		{"Fix the eslintrc path", nil},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		if got := formattingTools(tt.message); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("formattingTools(%q) = %q, want %q", tt.message, got, tt.want)
+		}
+	}
+}
+
+func TestClassifyFormatting(t *testing.T) {
+	repo := newFixtureRepo(t)
+	var flat, indented strings.Builder
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&flat, "x%d := %d\n", i, i)
+		fmt.Fprintf(&indented, "\tx%d := %d\n", i, i)
+	}
+	repo.commit("Add the table", map[string]string{"table.go": flat.String()})
+	for _, tc := range []struct {
+		name, content string
+		threshold     float64
+		want          formattingClassification
+	}{
+		{"reindent", indented.String(), 20, formattingClassification{OriginalChangedLines: 400, FormattingOnly: true}},
+		// One changed value in 400 changed lines is a logic change: it goes to the model, hinted.
+		{"reindent hiding a change", strings.Replace(indented.String(), "x7 := 7", "x7 := 8", 1), 20, formattingClassification{OriginalChangedLines: 400, IgnoredChangedLines: 2, Borderline: true}},
+		{"reindent hiding a change without hints", strings.Replace(indented.String(), "x7 := 7", "x7 := 8", 1), 0, formattingClassification{OriginalChangedLines: 400, IgnoredChangedLines: 2}},
+		{"substantive", strings.ReplaceAll(flat.String(), ":=", "="), 20, formattingClassification{OriginalChangedLines: 400, IgnoredChangedLines: 400}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			hash := repo.commit(tc.name, map[string]string{"table.go": tc.content})
+			repo.git("reset", "--hard", "HEAD~1")
+			got, err := classifyFormatting(context.Background(), repo.Dir, hash, tc.threshold)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("classifyFormatting = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// defaultGeminiEndpoint is the base URL of the Gemini API.
+const defaultGeminiEndpoint = "https://generativelanguage.googleapis.com"
+
+// geminiGenerator calls the Gemini generateContent API.
+type geminiGenerator struct {
+	Endpoint  string
+	Model     string
+	MaxTokens int
+	apiKey    string
+}
+
+type geminiRequest struct {
+	Contents         []geminiContent `json:"contents"`
+	GenerationConfig struct {
+		MaxOutputTokens int  `json:"maxOutputTokens"`
+		Seed            *int `json:"seed,omitempty"`
+	} `json:"generationConfig"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiPart struct {
+	Text string `json:"text"`
+}
+
+type geminiResponse struct {
+	Candidates []struct {
+		Content      geminiContent `json:"content"`
+		FinishReason string        `json:"finishReason"`
+	} `json:"candidates"`
+	PromptFeedback struct {
+		BlockReason string `json:"blockReason"`
+	} `json:"promptFeedback"`
+	UsageMetadata struct {
+		PromptTokenCount     int `json:"promptTokenCount"`
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+	} `json:"usageMetadata"`
+}
+
+type geminiErrorResponse struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+	} `json:"error"`
+}
+
+func newGeminiGenerator(config *providerConfig, apiKey string) *geminiGenerator {
+	g := &geminiGenerator{
+		Endpoint:  defaultGeminiEndpoint,
+		Model:     config.Model,
+		MaxTokens: defaultMaxTokens,
+		apiKey:    apiKey,
+	}
+	if config.Endpoint != "" {
+		g.Endpoint = strings.TrimRight(config.Endpoint, "/")
+	}
+	if config.MaxTokens > 0 {
+		g.MaxTokens = config.MaxTokens
+	}
+	return g
+}
+
+func (g *geminiGenerator) Name() string { return "Gemini" }
+
+// Generate sends the prompt as a single user turn and returns the first candidate's text.
+func (g *geminiGenerator) Generate(ctx context.Context, prompt string) (generation, error) {
+	return g.generate(ctx, prompt, nil)
+}
+
+// GenerateSeeded is Generate with the generationConfig seed set.
+func (g *geminiGenerator) GenerateSeeded(ctx context.Context, prompt string, seed int) (generation, error) {
+	return g.generate(ctx, prompt, &seed)
+}
+
+func (g *geminiGenerator) generate(ctx context.Context, prompt string, seed *int) (generation, error) {
+	var req geminiRequest
+	req.Contents = []geminiContent{{Role: "user", Parts: []geminiPart{{Text: prompt}}}}
+	req.GenerationConfig.MaxOutputTokens = g.MaxTokens
+	req.GenerationConfig.Seed = seed
+
+	endpoint := fmt.Sprintf("%s/v1beta/models/%s:generateContent", g.Endpoint, url.PathEscape(g.Model))
+	status, body, err := postJSON(ctx, endpoint, map[string]string{"x-goog-api-key": g.apiKey}, req)
+	if err != nil {
+		return generation{}, fmt.Errorf("failed to send request to Gemini: %w", err)
+	}
+	if status != http.StatusOK {
+		return generation{}, geminiError(status, body)
+	}
+
+	var resp geminiResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return generation{}, fmt.Errorf("failed to decode Gemini response: %w", err)
+	}
+	usage := tokenUsage{PromptTokens: resp.UsageMetadata.PromptTokenCount, OutputTokens: resp.UsageMetadata.CandidatesTokenCount}
+	if resp.PromptFeedback.BlockReason != "" {
+		return generation{Usage: usage}, fmt.Errorf("Gemini blocked the prompt: %s", resp.PromptFeedback.BlockReason)
+	}
+	if len(resp.Candidates) == 0 {
+		return generation{Usage: usage}, nil
+	}
+	var text strings.Builder
+	for _, part := range resp.Candidates[0].Content.Parts {
+		text.WriteString(part.Text)
+	}
+	if resp.Candidates[0].FinishReason == "MAX_TOKENS" {
+		fmt.Printf("Warning: Gemini stopped at max_tokens (%d); the summary may be cut off.\n", g.MaxTokens)
+	}
+	return generation{Text: strings.TrimSpace(text.String()), Usage: usage}, nil
+}
+
+// geminiError maps a Gemini error response to an error. Invalid API keys, authentication,
+// permission and not-found errors are permanent; RESOURCE_EXHAUSTED, UNAVAILABLE and other
+// server-side statuses are retryable.
+func geminiError(status int, body []byte) error {
+	var resp geminiErrorResponse
+	errStatus, message := "", strings.TrimSpace(string(body))
+	if json.Unmarshal(body, &resp) == nil && resp.Error.Status != "" {
+		errStatus, message = resp.Error.Status, resp.Error.Message
+	}
+	err := fmt.Errorf("Gemini API request failed with status %d (%s): %s", status, errStatus, message)
+	switch {
+	case errStatus == "UNAUTHENTICATED" || errStatus == "PERMISSION_DENIED" || errStatus == "NOT_FOUND",
+		// An invalid key is reported as INVALID_ARGUMENT rather than UNAUTHENTICATED.
+		strings.Contains(message, "API key not valid"),
+		status == http.StatusUnauthorized || status == http.StatusForbidden || status == http.StatusNotFound:
+		return &permanentError{err: err, Status: status, Body: string(body)}
+	}
+	return err
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestGeminiGenerate(t *testing.T) {
+	server, header, request := newFakeProvider(t, http.StatusOK, `{
+		"candidates": [{"content": {"role": "model", "parts": [{"text": "Adds the parser. "}, {"text": "No tests."}]}, "finishReason": "STOP"}],
+		"usageMetadata": {"promptTokenCount": 900, "candidatesTokenCount": 20, "totalTokenCount": 920}
+	}`)
+	g := newGeminiGenerator(&providerConfig{Model: "gemini-test", MaxTokens: 256, Endpoint: server.URL}, "key-test")
+	gen, err := g.GenerateSeeded(context.Background(), "the prompt", 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gen.Text != "Adds the parser. No tests." || gen.Usage != (tokenUsage{PromptTokens: 900, OutputTokens: 20}) {
+		t.Errorf("generation = %+v", gen)
+	}
+	if header.Get("X-Path") != "/v1beta/models/gemini-test:generateContent" || header.Get("x-goog-api-key") != "key-test" {
+		t.Errorf("request headers = %v", *header)
+	}
+	config, _ := (*request)["generationConfig"].(map[string]any)
+	if config["maxOutputTokens"] != float64(256) || config["seed"] != float64(7) {
+		t.Errorf("generationConfig = %v", config)
+	}
+}
+
+func TestGeminiBlockedAndEmpty(t *testing.T) {
+	server, _, _ := newFakeProvider(t, http.StatusOK, `{"promptFeedback": {"blockReason": "SAFETY"}, "usageMetadata": {"promptTokenCount": 50}}`)
+	g := newGeminiGenerator(&providerConfig{Model: "gemini-test", Endpoint: server.URL}, "key-test")
+	gen, err := g.Generate(context.Background(), "the prompt")
+	if err == nil || isPermanent(err) || gen.Usage.PromptTokens != 50 {
+		t.Errorf("blocked prompt: %+v, %v", gen, err)
+	}
+
+	server, _, _ = newFakeProvider(t, http.StatusOK, `{"candidates": []}`)
+	g = newGeminiGenerator(&providerConfig{Model: "gemini-test", Endpoint: server.URL}, "key-test")
+	if gen, err := g.Generate(context.Background(), "the prompt"); err != nil || gen.Text != "" {
+		t.Errorf("no candidates: %+v, %v", gen, err)
+	}
+}
+
+func TestGeminiErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		body      string
+		permanent bool
+	}{
+		{"invalid key", 400, `{"error":{"code":400,"message":"API key not valid. Please pass a valid API key.","status":"INVALID_ARGUMENT"}}`, true},
+		{"unauthenticated", 401, `{"error":{"code":401,"message":"Request had invalid authentication credentials.","status":"UNAUTHENTICATED"}}`, true},
+		{"permission", 403, `{"error":{"code":403,"message":"Permission denied.","status":"PERMISSION_DENIED"}}`, true},
+		{"unknown model", 404, `{"error":{"code":404,"message":"models/gemini-nope is not found","status":"NOT_FOUND"}}`, true},
+		{"quota", 429, `{"error":{"code":429,"message":"Resource has been exhausted","status":"RESOURCE_EXHAUSTED"}}`, false},
+		{"unavailable", 503, `{"error":{"code":503,"message":"The model is overloaded.","status":"UNAVAILABLE"}}`, false},
+		{"proxy page", 502, `<html>Bad Gateway</html>`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, _, _ := newFakeProvider(t, tt.status, tt.body)
+			g := newGeminiGenerator(&providerConfig{Model: "gemini-test", Endpoint: server.URL}, "key-test")
+			_, err := g.Generate(context.Background(), "the prompt")
+			if err == nil {
+				t.Fatal("Generate succeeded")
+			}
+			if isPermanent(err) != tt.permanent {
+				t.Errorf("isPermanent(%v) = %v", err, !tt.permanent)
+			}
+		})
+	}
+}
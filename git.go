@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// defaultGitTimeout bounds a single git command when git_timeout is not configured.
+const defaultGitTimeout = 5 * time.Minute
+
+// gitTimeout is the per-command timeout applied by gitRun; 0 disables it.
+var gitTimeout = defaultGitTimeout
+
+// gitSafetyConfig is passed to every git command. gitaudit only reads history, so it refuses
+// the ext:: transport (which runs arbitrary commands, e.g. for a lazy fetch in a partial
+// clone) and never starts the audited repository's fsmonitor hook.
+var gitSafetyConfig = []string{"-c", "protocol.ext.allow=never", "-c", "core.fsmonitor=false"}
+
+// gitRunner executes git with the given arguments and returns its stdout. It is a variable
+// so that tests can substitute a fake git.
+type gitRunner func(ctx context.Context, stdin io.Reader, args []string) ([]byte, error)
+
+var runGit gitRunner = execGit
+
+// gitError is a failed git command. Its message is the process error followed by git's
+// stderr, which callers wrap with what they were trying to do.
+type gitError struct {
+	Args   []string
+	Err    error
+	Stderr string
+	// Timeout is set when the command was killed for running longer than gitTimeout.
+	Timeout time.Duration
+}
+
+func (e *gitError) Error() string {
+	if e.Timeout > 0 {
+		return "timed out after " + formatDuration(e.Timeout) + " (raise git_timeout if the repository is slow)"
+	}
+	if e.Stderr == "" {
+		return e.Err.Error()
+	}
+	return e.Err.Error() + ". Stderr: " + e.Stderr
+}
+
+func (e *gitError) Unwrap() error { return e.Err }
+
+// gitRun runs a git command in repoPath and returns its stdout.
+func gitRun(ctx context.Context, repoPath string, args ...string) ([]byte, error) {
+	return gitRunInput(ctx, repoPath, nil, args...)
+}
+
+// gitRunInput runs a git command in repoPath with stdin as its standard input. The command
+// is killed after gitTimeout, and a failure is returned as a *gitError carrying git's stderr.
+func gitRunInput(ctx context.Context, repoPath string, stdin io.Reader, args ...string) ([]byte, error) {
+	if gitTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, gitTimeout)
+		defer cancel()
+	}
+	fullArgs := append(append([]string{"-C", repoPath}, gitSafetyConfig...), args...)
+	output, err := runGit(ctx, stdin, fullArgs)
+	if err != nil {
+		gitErr := &gitError{Args: args, Err: err}
+		if ee, ok := err.(*exec.ExitError); ok {
+			gitErr.Stderr = strings.TrimSpace(string(ee.Stderr))
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			gitErr.Timeout = gitTimeout
+		}
+		if ctx.Err() == context.Canceled {
+			return output, fmt.Errorf("%w: %w", errInterrupted, gitErr)
+		}
+		return output, gitErr
+	}
+	return output, nil
+}
+
+// execGit is the real gitRunner. Credential prompts are disabled, since nobody is there to
+// answer them, and WaitDelay keeps a killed command's children (e.g. a smudge filter) from
+// holding the output pipes open.
+func execGit(ctx context.Context, stdin io.Reader, args []string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Stdin = stdin
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if gitNoLazyFetch {
+		cmd.Env = append(cmd.Env, "GIT_NO_LAZY_FETCH=1")
+	}
+	cmd.WaitDelay = time.Second
+	return cmd.Output()
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeGit replaces runGit for the duration of a test.
+func fakeGit(t *testing.T, runner gitRunner) {
+	t.Helper()
+	saved := runGit
+	runGit = runner
+	t.Cleanup(func() { runGit = saved })
+}
+
+func TestGitRunArgs(t *testing.T) {
+	var got []string
+	fakeGit(t, func(ctx context.Context, stdin io.Reader, args []string) ([]byte, error) {
+		got = args
+		return []byte("ok\n"), nil
+	})
+	out, err := gitRun(context.Background(), "/repo", "log", "-1")
+	if err != nil || string(out) != "ok\n" {
+		t.Fatalf("gitRun = %q, %v", out, err)
+	}
+	want := "-C /repo -c protocol.ext.allow=never -c core.fsmonitor=false log -1"
+	if strings.Join(got, " ") != want {
+		t.Errorf("git run with %q, want %q", strings.Join(got, " "), want)
+	}
+}
+
+func TestGitRunCapturesStderr(t *testing.T) {
+	repo := newFixtureRepo(t)
+	repo.commits(1)
+	_, err := gitRun(context.Background(), repo.Dir, "show", "no-such-revision")
+	var gitErr *gitError
+	if !errors.As(err, &gitErr) {
+		t.Fatalf("err = %v, want a *gitError", err)
+	}
+	if !strings.Contains(gitErr.Stderr, "no-such-revision") || !strings.Contains(err.Error(), "exit status 128. Stderr: fatal: ") {
+		t.Errorf("error lacks git's stderr: %v", err)
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		t.Errorf("error does not unwrap to the exit error: %v", err)
+	}
+	wrapped := fmt.Errorf("failed to execute git show for commit x: %w", err)
+	if !strings.HasSuffix(wrapped.Error(), gitErr.Stderr) {
+		t.Errorf("wrapped error lost the stderr: %v", wrapped)
+	}
+}
+
+func TestGitRunTimeout(t *testing.T) {
+	saved := gitTimeout
+	t.Cleanup(func() { gitTimeout = saved })
+	gitTimeout = 50 * time.Millisecond
+	fakeGit(t, func(ctx context.Context, stdin io.Reader, args []string) ([]byte, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	start := time.Now()
+	_, err := gitRun(context.Background(), "/repo", "show", "abc")
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("gitRun returned after %v", elapsed)
+	}
+	var gitErr *gitError
+	if !errors.As(err, &gitErr) || gitErr.Timeout != gitTimeout {
+		t.Fatalf("err = %#v, want a timeout", err)
+	}
+	if !strings.Contains(err.Error(), "timed out after") || !strings.Contains(err.Error(), "git_timeout") || !isTimeout(err) {
+		t.Errorf("timeout error = %v", err)
+	}
+
+	// A cancelled run is an interruption, not a timeout.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = gitRun(ctx, "/repo", "show", "abc")
+	if !errors.Is(err, errInterrupted) || errors.As(err, &gitErr) && gitErr.Timeout != 0 {
+		t.Errorf("cancelled err = %v, want errInterrupted", err)
+	}
+
+	// Without a timeout the command runs as long as it takes.
+	gitTimeout = 0
+	fakeGit(t, func(ctx context.Context, stdin io.Reader, args []string) ([]byte, error) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+			return nil, nil
+		}
+	})
+	if _, err := gitRun(context.Background(), "/repo", "show", "abc"); err != nil {
+		t.Errorf("untimed command failed: %v", err)
+	}
+}
+
+func TestHangingGitDoesNotHangTheAudit(t *testing.T) {
+	repo := newFixtureRepo(t)
+	hashes := repo.commits(3)
+	realGit, err := exec.LookPath("git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A git on PATH that hangs, with a child holding its output open, when asked for the patch
+	// of the middle commit, and is the real git otherwise.
+	bin := t.TempDir()
+	script := fmt.Sprintf("#!/bin/sh\ncase \"$*\" in\n*--patch*%s*) sleep 30; exit 0 ;;\nesac\nexec %s \"$@\"\n", hashes[1], realGit)
+	if err := os.WriteFile(filepath.Join(bin, "git"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	env := newAuditEnv(t)
+	env.Config["git_timeout"] = "1s"
+	env.Env = append(env.Env, "PATH="+bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	report := filepath.Join(env.Work, "report.txt")
+	logPath := filepath.Join(env.Work, "run.log")
+	log, err := os.Create(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	cmd := env.command("-repo", repo.Dir, "-commit", "root", "-output", report)
+	cmd.Stdout, cmd.Stderr = log, log
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The hanging commit goes to the retry queue; its retry is cooling down, so the run is
+	// stopped there.
+	queued := "Error processing commit " + hashes[1]
+	for !strings.Contains(readFile(t, logPath), queued) {
+		if time.Since(start) > 20*time.Second {
+			cmd.Process.Kill()
+			cmd.Wait()
+			t.Fatalf("commit not queued after 20s:\n%s", readFile(t, logPath))
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	cmd.Process.Signal(syscall.SIGINT)
+	cmd.Wait()
+	if elapsed := time.Since(start); elapsed > 20*time.Second {
+		t.Errorf("run took %v", elapsed)
+	}
+
+	out := readFile(t, logPath)
+	if !strings.Contains(out, hashes[1]+" (1 failed attempts, last error: failed to generate patch: failed to execute git show for commit "+hashes[1]+": timed out after 1s") {
+		t.Errorf("hanging commit not listed as failed with a timeout:\n%s", out)
+	}
+	if got := reportCommits(readFile(t, report)); len(got) != 2 {
+		t.Errorf("report has entries %v, want the 2 other commits", got)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// globCache memoizes compiled glob patterns; the same handful of patterns is matched against
+// every path of every commit.
+var globCache sync.Map
+
+// matchGlob reports whether a slash-separated repository path matches a gitignore-style glob:
+//   - "*" matches within a path segment and "?" matches one non-slash character,
+//   - "**" matches across segments, e.g. "docs/**" or "**/fixtures/*.json",
+//   - a pattern without a slash matches at any depth ("*.pem"),
+//   - a leading "/" anchors the pattern at the repository root,
+//   - a trailing "/" matches everything below that directory.
+func matchGlob(pattern, path string) bool {
+	re, ok := globCache.Load(pattern)
+	if !ok {
+		re = compileGlob(pattern)
+		globCache.Store(pattern, re)
+	}
+	return re.(*regexp.Regexp).MatchString(strings.TrimPrefix(path, "/"))
+}
+
+// matchAnyGlob returns the first pattern in globs that matches path, if any.
+func matchAnyGlob(globs []string, path string) (string, bool) {
+	for _, glob := range globs {
+		if matchGlob(glob, path) {
+			return glob, true
+		}
+	}
+	return "", false
+}
+
+// compileGlob translates a glob as described by matchGlob into an anchored regular expression.
+func compileGlob(pattern string) *regexp.Regexp {
+	p := pattern
+	anchored := strings.HasPrefix(p, "/")
+	p = strings.TrimPrefix(p, "/")
+	// Like gitignore, only a slash at the start or in the middle anchors the pattern.
+	if !anchored && !strings.Contains(strings.TrimSuffix(p, "/"), "/") {
+		p = "**/" + p
+	}
+	if strings.HasSuffix(p, "/") {
+		p += "**"
+	}
+
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i := 0; i < len(p); i++ {
+		switch c := p[i]; c {
+		case '*':
+			if i+1 < len(p) && p[i+1] == '*' {
+				i++
+				if i+1 < len(p) && p[i+1] == '/' {
+					// "**/" matches zero or more leading directories.
+					i++
+					sb.WriteString("(?:.*/)?")
+				} else {
+					sb.WriteString(".*")
+				}
+			} else {
+				sb.WriteString("[^/]*")
+			}
+		case '?':
+			sb.WriteString("[^/]")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.MustCompile(sb.String())
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// glossaryTerm is one entry of the glossary: a term in its canonical spelling and a short
+// definition, which may be empty for a term listed only for its spelling.
+type glossaryTerm struct {
+	Term       string
+	Definition string
+}
+
+// glossary holds the internal terms and codenames the model should know: the glossary_file
+// entries overlaid with the glossary config key. Matching ignores case.
+type glossary struct {
+	Terms []glossaryTerm // Sorted by term.
+	// Digest identifies the terms and definitions, so that settings that must agree, such as
+	// the shard parameters, change with the glossary.
+	Digest string
+
+	pattern   *regexp.Regexp
+	canonical map[string]string // Folded term -> canonical spelling.
+}
+
+// newGlossary builds the glossary of config, reading glossary_file first. A relative
+// glossary_file is resolved against the directory of the config file, so that every
+// repository finds the same shared file. It returns nil when no term is configured.
+func newGlossary(config *Config) (*glossary, error) {
+	entries := make(map[string]glossaryTerm)
+	add := func(source string, terms map[string]string) error {
+		for term, definition := range terms {
+			term = strings.Join(strings.Fields(term), " ")
+			if !strings.ContainsFunc(term, unicode.IsLetter) {
+				return fmt.Errorf("%s: term %q must contain a letter", source, term)
+			}
+			entries[strings.ToLower(term)] = glossaryTerm{Term: term, Definition: strings.TrimSpace(definition)}
+		}
+		return nil
+	}
+	if config.GlossaryFile != "" {
+		path, err := glossaryFilePath(config.GlossaryFile)
+		if err != nil {
+			return nil, err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read glossary_file: %w", err)
+		}
+		var terms map[string]string
+		if err := decodeConfigJSON(data, &terms, false); err != nil {
+			return nil, fmt.Errorf("failed to decode glossary_file %s: %w", path, err)
+		}
+		if err := add("glossary_file "+path, terms); err != nil {
+			return nil, err
+		}
+	}
+	if err := add("glossary", config.Glossary); err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	g := &glossary{canonical: make(map[string]string, len(entries))}
+	for folded, entry := range entries {
+		g.Terms = append(g.Terms, entry)
+		g.canonical[folded] = entry.Term
+	}
+	sort.Slice(g.Terms, func(i, j int) bool { return g.Terms[i].Term < g.Terms[j].Term })
+
+	// Longer terms come first in the alternation, so "Project Nimbus" wins over "Nimbus".
+	byLength := append([]glossaryTerm{}, g.Terms...)
+	sort.SliceStable(byLength, func(i, j int) bool { return len(byLength[i].Term) > len(byLength[j].Term) })
+	alternatives := make([]string, len(byLength))
+	sum := sha256.New()
+	for i, t := range byLength {
+		alternatives[i] = strings.ReplaceAll(regexp.QuoteMeta(t.Term), " ", `[ \t]+`)
+	}
+	for _, t := range g.Terms {
+		fmt.Fprintf(sum, "%s\x00%s\n", t.Term, t.Definition)
+	}
+	g.pattern = regexp.MustCompile(`(?i)(?:` + strings.Join(alternatives, "|") + `)`)
+	g.Digest = hex.EncodeToString(sum.Sum(nil))
+	return g, nil
+}
+
+// glossaryFilePath resolves the glossary_file config key: "~/" is the home directory, and a
+// relative path is relative to the directory of the config file.
+func glossaryFilePath(path string) (string, error) {
+	configPath, err := configFilePath()
+	if err != nil {
+		return "", err
+	}
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		return filepath.Join(filepath.Dir(configPath), rest), nil
+	}
+	if filepath.IsAbs(path) {
+		return path, nil
+	}
+	return filepath.Join(filepath.Dir(configPath), path), nil
+}
+
+// Entries returns the prompt lines of the terms that occur in material, ignoring case, the
+// most frequent first so that the context budget trims the least used terms first.
+func (g *glossary) Entries(material string) []string {
+	if g == nil {
+		return nil
+	}
+	material = strings.ToLower(material)
+	type use struct {
+		term  glossaryTerm
+		count int
+	}
+	var used []use
+	for _, t := range g.Terms {
+		if n := strings.Count(material, strings.ToLower(t.Term)); n > 0 {
+			used = append(used, use{t, n})
+		}
+	}
+	sort.SliceStable(used, func(i, j int) bool { return used[i].count > used[j].count })
+	entries := make([]string, len(used))
+	for i, u := range used {
+		entries[i] = u.term.Term
+		if u.term.Definition != "" {
+			entries[i] += ": " + u.term.Definition
+		}
+	}
+	return entries
+}
+
+// fencePattern matches the opening or closing line of a fenced code block.
+var fencePattern = regexp.MustCompile("(?m)^[ \t]*(?:```|~~~)")
+
+// protectedSpans returns the byte ranges of text the casing correction leaves alone: fenced
+// code blocks, inline code spans and -cite citations, whose paths must stay as in the patch.
+func protectedSpans(text string) [][2]int {
+	var spans [][2]int
+	fences := fencePattern.FindAllStringIndex(text, -1)
+	for i := 0; i < len(fences); i += 2 {
+		end := len(text)
+		if i+1 < len(fences) {
+			end = fences[i+1][1]
+		}
+		spans = append(spans, [2]int{fences[i][0], end})
+	}
+	inFence := func(pos int) bool {
+		for _, s := range spans {
+			if pos >= s[0] && pos < s[1] {
+				return true
+			}
+		}
+		return false
+	}
+	// Inline code spans open and close with backtick runs of the same length.
+	for i := 0; i < len(text); {
+		if text[i] != '`' || inFence(i) {
+			i++
+			continue
+		}
+		run := i
+		for run < len(text) && text[run] == '`' {
+			run++
+		}
+		delimiter := text[i:run]
+		closing := strings.Index(text[run:], delimiter)
+		if closing < 0 {
+			i = run
+			continue
+		}
+		end := run + closing + len(delimiter)
+		spans = append(spans, [2]int{i, end})
+		i = end
+	}
+	for _, m := range citationPattern.FindAllStringIndex(text, -1) {
+		spans = append(spans, [2]int{m[0], m[1]})
+	}
+	return spans
+}
+
+// isTermJoiner reports whether r, next to a match, makes it part of a longer token such as an
+// identifier, path, flag or file name rather than the term itself.
+func isTermJoiner(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_/\\-.@#$", r)
+}
+
+// Correct rewrites the occurrences of known terms in text with their canonical spelling
+// (nimbus -> Nimbus) and returns the number of occurrences changed. Terms inside code spans
+// and citations, and terms that are part of a longer token, are left as they are.
+func (g *glossary) Correct(text string) (string, int) {
+	if g == nil {
+		return text, 0
+	}
+	spans := protectedSpans(text)
+	var sb strings.Builder
+	last, changed := 0, 0
+	for _, m := range g.pattern.FindAllStringIndex(text, -1) {
+		start, end := m[0], m[1]
+		match := text[start:end]
+		canonical := g.canonical[strings.ToLower(strings.Join(strings.Fields(match), " "))]
+		if canonical == "" || match == canonical {
+			continue
+		}
+		if before, _ := utf8.DecodeLastRuneInString(text[:start]); start > 0 && isTermJoiner(before) {
+			continue
+		}
+		if after, size := utf8.DecodeRuneInString(text[end:]); end < len(text) && isTermJoiner(after) {
+			// A sentence may end right after the term.
+			next, _ := utf8.DecodeRuneInString(text[end+size:])
+			if after != '.' || (end+size < len(text) && !unicode.IsSpace(next)) {
+				continue
+			}
+		}
+		protected := false
+		for _, s := range spans {
+			if start < s[1] && end > s[0] {
+				protected = true
+				break
+			}
+		}
+		if protected {
+			continue
+		}
+		sb.WriteString(text[last:start])
+		sb.WriteString(canonical)
+		last = end
+		changed++
+	}
+	if changed == 0 {
+		return text, 0
+	}
+	sb.WriteString(text[last:])
+	return sb.String(), changed
+}
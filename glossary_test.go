@@ -0,0 +1,194 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewGlossary(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	if err := os.WriteFile(filepath.Join(home, "terms.json"), []byte(`{"Project Nimbus": "the billing rewrite", "Atlas": "map tiles", "SRE": ""}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, err := newGlossary(&Config{}); g != nil || err != nil {
+		t.Errorf("no terms: %+v, %v", g, err)
+	}
+
+	// A relative glossary_file is read from the config file's directory; the glossary key
+	// takes precedence over it, whatever the case, and spaces in terms are normalized.
+	g, err := newGlossary(&Config{GlossaryFile: "terms.json", Glossary: map[string]string{"atlas": " the tile server ", "Big  Table": "storage"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []glossaryTerm{{"Big Table", "storage"}, {"Project Nimbus", "the billing rewrite"}, {"SRE", ""}, {"atlas", "the tile server"}}
+	if !reflect.DeepEqual(g.Terms, want) {
+		t.Errorf("terms %+v, want %+v", g.Terms, want)
+	}
+	for _, path := range []string{"~/terms.json", filepath.Join(home, "terms.json")} {
+		if other, err := newGlossary(&Config{GlossaryFile: path}); err != nil || len(other.Terms) != 3 {
+			t.Errorf("glossary_file %s: %+v, %v", path, other, err)
+		}
+	}
+
+	// The digest changes with a definition, not with the order of the keys.
+	digest := func(terms map[string]string) string {
+		g, err := newGlossary(&Config{Glossary: terms})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return g.Digest
+	}
+	if digest(map[string]string{"A1": "x", "B2": "y"}) != digest(map[string]string{"B2": "y", "A1": "x"}) ||
+		digest(map[string]string{"A1": "x"}) == digest(map[string]string{"A1": "z"}) {
+		t.Error("the digest does not follow the terms")
+	}
+
+	for _, tc := range []struct {
+		config *Config
+		want   string
+	}{
+		{&Config{Glossary: map[string]string{"42": "the answer"}}, `glossary: term "42" must contain a letter`},
+		{&Config{GlossaryFile: "missing.json"}, "failed to read glossary_file"},
+		{&Config{GlossaryFile: "terms.json", Glossary: map[string]string{"-": ""}}, `term "-" must contain a letter`},
+	} {
+		if _, err := newGlossary(tc.config); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%+v: error %v, want %q", tc.config, err, tc.want)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(home, "bad.json"), []byte(`["Nimbus"]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newGlossary(&Config{GlossaryFile: "bad.json"}); err == nil || !strings.Contains(err.Error(), "failed to decode glossary_file") {
+		t.Errorf("bad glossary_file: %v", err)
+	}
+}
+
+func TestGlossaryEntries(t *testing.T) {
+	g, err := newGlossary(&Config{Glossary: map[string]string{"Nimbus": "the billing service", "Atlas": "map tiles", "SRE": "", "Orion": "unused"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The most frequent first, matching ignoring case; terms absent from the material are
+	// left out, and a term without a definition is listed alone.
+	got := g.Entries("+// atlas calls nimbus\n+nimbus.Charge()\n+NIMBUS_URL = sre.example.com\n")
+	want := []string{"Nimbus: the billing service", "Atlas: map tiles", "SRE"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Entries = %q, want %q", got, want)
+	}
+	var none *glossary
+	if none.Entries("nimbus") != nil {
+		t.Error("a nil glossary has entries")
+	}
+}
+
+func TestGlossaryCorrect(t *testing.T) {
+	g, err := newGlossary(&Config{Glossary: map[string]string{"Nimbus": "", "Project Nimbus": "", "gRPC": "", "SRE": ""}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		text, want string
+		changed    int
+	}{
+		{"Moves nimbus to GRPC.", "Moves Nimbus to gRPC.", 2},
+		// The longest term wins, across any run of spaces.
+		{"Part of project  nimbus.", "Part of Project Nimbus.", 1},
+		{"Already Nimbus and gRPC.", "Already Nimbus and gRPC.", 0},
+		// Code spans, fenced blocks and citations are left alone.
+		{"Renames `nimbus` and ``nimbus.Client``.", "Renames `nimbus` and ``nimbus.Client``.", 0},
+		{"Adds:\n```\nnimbus.Start()\n```\nfor nimbus.", "Adds:\n```\nnimbus.Start()\n```\nfor Nimbus.", 1},
+		{"Changes the client [nimbus/client.go @@ -1,2 +1,3 @@] of nimbus.", "Changes the client [nimbus/client.go @@ -1,2 +1,3 @@] of Nimbus.", 1},
+		// So are terms within paths, identifiers, flags, hosts and file names.
+		{"Edits internal/nimbus/api.go, nimbus_client, -nimbus-url, nimbus.example.com, nimbus.go and sre2.", "Edits internal/nimbus/api.go, nimbus_client, -nimbus-url, nimbus.example.com, nimbus.go and sre2.", 0},
+		{"Mentions #nimbus and @nimbus and $nimbus.", "Mentions #nimbus and @nimbus and $nimbus.", 0},
+		// A sentence may end right after a term, and punctuation is no joiner.
+		{"Ends with nimbus.\nThen sre, (grpc) and nimbus!", "Ends with Nimbus.\nThen SRE, (gRPC) and Nimbus!", 4},
+		// An unclosed backtick protects nothing.
+		{"A stray ` before nimbus.", "A stray ` before Nimbus.", 1},
+	} {
+		got, changed := g.Correct(tc.text)
+		if got != tc.want || changed != tc.changed {
+			t.Errorf("Correct(%q) = %q, %d; want %q, %d", tc.text, got, changed, tc.want, tc.changed)
+		}
+	}
+	var none *glossary
+	if got, n := none.Correct("nimbus"); got != "nimbus" || n != 0 {
+		t.Errorf("a nil glossary corrected %q", got)
+	}
+}
+
+func TestGlossaryPromptSizing(t *testing.T) {
+	terms := map[string]string{}
+	var material strings.Builder
+	for _, name := range []string{"Nimbus", "Atlas", "Orion", "Vega", "Lyra", "Draco"} {
+		terms[name] = "the " + strings.ToLower(name) + " service" + strings.Repeat(", which handles one part of billing", 5)
+		material.WriteString("+" + name + ".Call()\n")
+	}
+	g, err := newGlossary(&Config{Glossary: terms})
+	if err != nil {
+		t.Fatal(err)
+	}
+	patch := material.String() + strings.Repeat("+added line of code\n", 2000)
+	extras := promptExtras{Hints: []string{strings.Repeat("the commit touches a CI pipeline; ", 30)}, Glossary: g.Entries(patch)}
+	if len(extras.Glossary) != 6 {
+		t.Fatalf("entries %q", extras.Glossary)
+	}
+	// The context component gets a fifth of the window, which the instructions fit into a
+	// tenth of, and the patch takes any surplus.
+	var tok heuristicTokenizer
+	full := tok.Count(promptExtras{Hints: extras.Hints, Glossary: extras.Glossary}.render())
+	hintsOnly := tok.Count(promptExtras{Hints: extras.Hints}.render())
+	for _, tc := range []struct {
+		contextTokens int
+		// glossary is the number of entries kept, -1 for some but not all.
+		glossary, hints int
+	}{
+		{64000, 6, 1},
+		{full * 5, 6, 1},
+		{(full + hintsOnly) / 2 * 5, -1, 1},
+		{hintsOnly * 5, 0, 1},
+	} {
+		a := &budgetAllocator{ContextTokens: tc.contextTokens, Split: defaultBudgetSplit, TrimOrder: []string{budgetContext, budgetPatch}, Tokenizer: tok}
+		_, got, report := a.applyBudget(patch, extras)
+		if kept := len(got.Glossary); (tc.glossary >= 0 && kept != tc.glossary) || (tc.glossary < 0 && (kept == 0 || kept == 6)) || len(got.Hints) != tc.hints {
+			t.Errorf("window %d: kept %d glossary entries and %d hints", tc.contextTokens, kept, len(got.Hints))
+		}
+		// The glossary counts in the context component, and what is kept fits it.
+		kept := tok.Count(promptExtras{Hints: got.Hints, Glossary: got.Glossary}.render())
+		if report.Context.SizeTokens != full || report.Context.KeptTokens != kept {
+			t.Errorf("window %d: context report %+v, want %d of %d tokens", tc.contextTokens, report.Context, kept, full)
+		}
+		// The kept entries are the most frequent, in order.
+		if !reflect.DeepEqual(got.Glossary, extras.Glossary[:len(got.Glossary)]) {
+			t.Errorf("window %d: kept %q", tc.contextTokens, got.Glossary)
+		}
+	}
+}
+
+func TestGlossaryRun(t *testing.T) {
+	repo := newFixtureRepo(t)
+	repo.commit("Move billing to nimbus", map[string]string{"billing/nimbus.go": "package billing\n\n// Charge goes through Nimbus.\nfunc Charge() {}\n"})
+	env := newAuditEnv(t)
+	env.Config["glossary"] = map[string]string{"Nimbus": "the billing service"}
+	env.Ollama.Respond = func(n int, prompt string) (int, string) {
+		return 200, `{"response":"Routes billing through nimbus in billing/nimbus.go and ` + "`nimbus`" + `.","done":true}`
+	}
+	report := filepath.Join(env.Work, "report.txt")
+	env.mustRun("-repo", repo.Dir, "-commit", "root", "-output", report)
+	if prompts := env.Ollama.Prompts(); len(prompts) != 1 || !strings.Contains(prompts[0], "\nGlossary of project terms (use these spellings and meanings):\n- Nimbus: the billing service\n") {
+		t.Errorf("prompts %q", prompts)
+	}
+	if content := readFile(t, report); !strings.Contains(content, "Routes billing through Nimbus in billing/nimbus.go and `nimbus`.\n") {
+		t.Errorf("report:\n%s", content)
+	}
+
+	env.Config["glossary"] = map[string]string{"1.0": ""}
+	if out, code := env.run("-repo", repo.Dir, "-commit", "root", "-output", report, "-force"); code == 0 || !strings.Contains(out, `term "1.0" must contain a letter`) {
+		t.Errorf("invalid term: exit %d\n%s", code, out)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// heatmapWidth is the length, in cells, of the bar of the directory with the most churn.
+const heatmapWidth = 30
+
+// heatmapEighths are the partial block characters of a bar, one to seven eighths of a cell.
+var heatmapEighths = []string{"", "▏", "▎", "▍", "▌", "▋", "▊", "▉"}
+
+// heatmapRow is the churn of one directory, or of the Dirs directories folded into "other".
+type heatmapRow struct {
+	Label   string
+	Added   int
+	Deleted int
+	Commits int
+	Dirs    int
+}
+
+// heatmapDir returns the directory of path cut to depth components, or "" for a file at the
+// top of the repository.
+func heatmapDir(path string, depth int) string {
+	parts := strings.Split(path, "/")
+	parts = parts[:len(parts)-1]
+	if len(parts) > depth {
+		parts = parts[:depth]
+	}
+	return strings.Join(parts, "/")
+}
+
+// buildHeatmap sums the churn of entries by directory and keeps the top directories, folding
+// the rest into one "other" row. Files matching never_send count under a single withheld row
+// when never_send_confidential keeps their names out of the report.
+func buildHeatmap(opts *auditOptions, entries []CommitAuditData, depth, top int) ([]heatmapRow, error) {
+	var hashes []string
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.Kind != kindTag && !seen[entry.Hash] {
+			seen[entry.Hash] = true
+			hashes = append(hashes, entry.Hash)
+		}
+	}
+	if len(hashes) == 0 {
+		return nil, nil
+	}
+	stats, err := getChangeStats(opts.RepoPath, hashes, opts.Pathspec)
+	if err != nil {
+		return nil, err
+	}
+
+	byDir := make(map[string]*heatmapRow)
+	var rows []*heatmapRow
+	for _, hash := range hashes {
+		counted := make(map[string]bool)
+		for _, file := range stats[hash].Files {
+			label := heatmapDir(file.Path, depth)
+			if label == "" {
+				label = msg("heatmap.root")
+			}
+			if _, ok := matchAnyGlob(opts.Config.NeverSend, file.Path); ok && opts.Config.NeverSendConfidential {
+				label = msg("heatmap.withheld")
+			}
+			row, ok := byDir[label]
+			if !ok {
+				row = &heatmapRow{Label: label}
+				byDir[label] = row
+				rows = append(rows, row)
+			}
+			row.Added += file.Added
+			row.Deleted += file.Deleted
+			if !counted[label] {
+				counted[label] = true
+				row.Commits++
+			}
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		if a, b := rows[i].Added+rows[i].Deleted, rows[j].Added+rows[j].Deleted; a != b {
+			return a > b
+		}
+		return rows[i].Label < rows[j].Label
+	})
+
+	if len(rows) <= top {
+		result := make([]heatmapRow, len(rows))
+		for i, row := range rows {
+			result[i] = *row
+		}
+		return result, nil
+	}
+	result := make([]heatmapRow, top, top+1)
+	for i, row := range rows[:top] {
+		result[i] = *row
+	}
+	// A commit may touch several of the folded directories, so "other" counts directories.
+	other := heatmapRow{Dirs: len(rows) - top}
+	for _, row := range rows[top:] {
+		other.Added += row.Added
+		other.Deleted += row.Deleted
+	}
+	other.Label = msg("heatmap.other", other.Dirs)
+	return append(result, other), nil
+}
+
+// heatmapBar draws churn as a bar of block characters, scaled so that peak fills heatmapWidth
+// cells. Any churn gets at least an eighth of a cell, so that no changed directory looks idle.
+func heatmapBar(churn, peak int) string {
+	if churn == 0 || peak == 0 {
+		return ""
+	}
+	eighths := churn * heatmapWidth * 8 / peak
+	if eighths == 0 {
+		eighths = 1
+	}
+	return strings.Repeat("█", eighths/8) + heatmapEighths[eighths%8]
+}
+
+// formatHeatmapSection renders the rows as the report's heatmap: one line per directory with
+// its bar, churn and commit count, labels and bars aligned.
+func formatHeatmapSection(rows []heatmapRow, depth int) string {
+	labelWidth, peak := 0, 0
+	for _, row := range rows {
+		labelWidth = max(labelWidth, len([]rune(row.Label)))
+		peak = max(peak, row.Added+row.Deleted)
+	}
+	var sb strings.Builder
+	sb.WriteString("\n---\n\n" + msg("section.heatmap", depth) + "\n\n")
+	for _, row := range rows {
+		bar := heatmapBar(row.Added+row.Deleted, peak)
+		// Widths count runes, so block characters and accented labels align.
+		line := fmt.Sprintf("%-*s  %-*s  +%d -%d", labelWidth, row.Label, heatmapWidth, bar, row.Added, row.Deleted)
+		if row.Dirs == 0 {
+			line += ", " + msgCount("count.commits", row.Commits)
+		}
+		sb.WriteString(strings.TrimRight(line, " ") + "\n")
+	}
+	return sb.String()
+}
+
+// writeHeatmap builds the change heatmap once and appends it to each report. Any failure is
+// reported as a warning; the per-commit entries are already written by then.
+func writeHeatmap(opts *auditOptions, filenames []string, entries []CommitAuditData, depth, top int) {
+	rows, err := buildHeatmap(opts, entries, depth, top)
+	if err != nil {
+		fmt.Printf("Warning: failed to build the change heatmap: %v\n", err)
+		return
+	}
+	if len(rows) == 0 {
+		return
+	}
+	section := formatHeatmapSection(rows, depth)
+	for _, filename := range filenames {
+		if err := appendHeatmapSection(filename, section); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+			continue
+		}
+		fmt.Printf("Appended the change heatmap to %s\n", filename)
+	}
+}
+
+// appendHeatmapSection appends the rendered heatmap to the report.
+func appendHeatmapSection(filename, section string) error {
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open file %s: %w", filename, err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(section); err != nil {
+		return fmt.Errorf("failed to write the change heatmap to file %s: %w", filename, err)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHeatmapDir(t *testing.T) {
+	for _, tc := range []struct {
+		path  string
+		depth int
+		want  string
+	}{
+		{"README.md", 2, ""},
+		{"src/main.go", 2, "src"},
+		{"src/pkg/a.go", 2, "src/pkg"},
+		{"src/pkg/deep/er/a.go", 2, "src/pkg"},
+		{"src/pkg/deep/er/a.go", 1, "src"},
+		{"src/pkg/deep/er/a.go", 9, "src/pkg/deep/er"},
+	} {
+		if got := heatmapDir(tc.path, tc.depth); got != tc.want {
+			t.Errorf("heatmapDir(%q, %d) = %q, want %q", tc.path, tc.depth, got, tc.want)
+		}
+	}
+}
+
+func TestHeatmapBar(t *testing.T) {
+	for _, tc := range []struct {
+		churn, peak int
+		want        string
+	}{
+		{0, 10, ""},
+		{10, 10, strings.Repeat("█", heatmapWidth)},
+		{5, 10, strings.Repeat("█", heatmapWidth/2)},
+		// 1/16 of 30 cells is 15 eighths: one cell and seven eighths.
+		{1, 16, "█▉"},
+		// Any churn shows.
+		{1, 100000, "▏"},
+	} {
+		if got := heatmapBar(tc.churn, tc.peak); got != tc.want {
+			t.Errorf("heatmapBar(%d, %d) = %q, want %q", tc.churn, tc.peak, got, tc.want)
+		}
+	}
+}
+
+// heatmapLines returns a file of n numbered lines.
+func heatmapLines(n int) string {
+	var sb strings.Builder
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&sb, "line %d\n", i)
+	}
+	return sb.String()
+}
+
+// heatmapGolden builds the heatmap of hashes in repo and compares it with testdata/heatmap/name.
+func heatmapGolden(t *testing.T, repo *fixtureRepo, config *Config, hashes []string, depth, top int, name string) {
+	t.Helper()
+	var entries []CommitAuditData
+	for _, hash := range hashes {
+		entries = append(entries, CommitAuditData{Hash: hash})
+	}
+	rows, err := buildHeatmap(&auditOptions{RepoPath: repo.Dir, Config: config}, entries, depth, top)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "heatmap/"+name, formatHeatmapSection(rows, depth))
+}
+
+func TestHeatmapGolden(t *testing.T) {
+	deep := newFixtureRepo(t)
+	deepHashes := []string{
+		deep.commit("Deep", map[string]string{
+			"a/b/c/d/deep.go": heatmapLines(40),
+			"a/b/x.go":        heatmapLines(6),
+			"a/y.go":          heatmapLines(3),
+			"README.md":       heatmapLines(2),
+		}),
+		deep.commit("Docs", map[string]string{
+			"a/b/c/other.go":      heatmapLines(10),
+			"docs/guide/intro.md": heatmapLines(8),
+			"docs/index.md":       heatmapLines(4),
+		}),
+		deep.commit("Libraries", map[string]string{
+			"a/b/c/d/deep.go": heatmapLines(30),
+			"lib/one/1.go":    heatmapLines(5),
+			"lib/two/2.go":    heatmapLines(4),
+			"lib/three/3.go":  heatmapLines(3),
+			"tools/t.go":      heatmapLines(1),
+		}),
+	}
+	config := &Config{}
+	heatmapGolden(t, deep, config, deepHashes, 2, 10, "deep-depth2.txt")
+	heatmapGolden(t, deep, config, deepHashes, 1, 10, "deep-depth1.txt")
+	heatmapGolden(t, deep, config, deepHashes, 4, 3, "deep-top3.txt")
+	// never_send files count under one row when their names are confidential.
+	heatmapGolden(t, deep, &Config{NeverSend: []string{"lib/**"}, NeverSendConfidential: true}, deepHashes, 2, 10, "deep-withheld.txt")
+
+	single := newFixtureRepo(t)
+	var singleHashes []string
+	for i := 1; i <= 3; i++ {
+		singleHashes = append(singleHashes, single.commit(fmt.Sprintf("Edit %d", i), map[string]string{"README": heatmapLines(i * 5)}))
+	}
+	heatmapGolden(t, single, config, singleHashes, 2, 10, "single-file.txt")
+
+	// Renamed files count under their new path, whether the directory or the name changes.
+	renames := newFixtureRepo(t)
+	created := renames.commit("Create", map[string]string{
+		"old/pkg/file.go":    heatmapLines(20),
+		"src/a/kept.go":      heatmapLines(12),
+		"src/a/moved.go":     heatmapLines(16),
+		"tools/rename.go":    heatmapLines(8),
+		"tools/unchanged.go": heatmapLines(2),
+	})
+	renamed := renames.commit("Move", map[string]string{
+		"old/pkg/file.go":  "",
+		"new/pkg/file.go":  heatmapLines(20) + "line 21\n",
+		"src/a/moved.go":   "",
+		"src/b/moved.go":   heatmapLines(16),
+		"tools/rename.go":  "",
+		"tools/renamed.go": heatmapLines(8),
+	})
+	if stat := renames.git("show", "--numstat", "-M", "--format=", renamed); !strings.Contains(stat, "=>") {
+		t.Fatalf("git saw no renames:\n%s", stat)
+	}
+	heatmapGolden(t, renames, config, []string{renamed}, 2, 10, "renames.txt")
+	heatmapGolden(t, renames, config, []string{created, renamed}, 2, 10, "renames-range.txt")
+}
+
+func TestHeatmapRun(t *testing.T) {
+	repo := newFixtureRepo(t)
+	repo.commit("Initial", map[string]string{"src/pkg/a.go": heatmapLines(3), "docs/b.md": heatmapLines(1)})
+	env := newAuditEnv(t)
+	report := filepath.Join(env.Work, "report.txt")
+	out := env.mustRun("-repo", repo.Dir, "-commit", "root", "-output", report, "-heatmap", "-heatmap-depth", "1")
+	if !strings.Contains(out, "Appended the change heatmap to "+report) {
+		t.Errorf("output:\n%s", out)
+	}
+	content := readFile(t, report)
+	section := "=== Change heatmap (lines added and deleted by directory, depth 1) ===\n\n"
+	if idx := strings.Index(content, section); idx < 0 || idx < strings.LastIndex(content, "Commit: ") {
+		t.Fatalf("the heatmap is not after the entries:\n%s", content)
+	}
+	for _, want := range []string{"src   " + strings.Repeat("█", heatmapWidth) + "  +3 -0, 1 commit\n", "docs  " + strings.Repeat("█", 10) + "  "} {
+		if !strings.Contains(content, want) {
+			t.Errorf("report lacks %q:\n%s", want, content)
+		}
+	}
+
+	if out, code := env.run("-repo", repo.Dir, "-commit", "root", "-output", report, "-force", "-heatmap", "-heatmap-top", "0"); code == 0 || !strings.Contains(out, "-heatmap-depth and -heatmap-top must be at least 1") {
+		t.Errorf("-heatmap-top 0: exit %d\n%s", code, out)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultHookTimeout bounds a single post_process_hook invocation when the config does not
+// set post_process_hook_timeout.
+const defaultHookTimeout = 10 * time.Second
+
+// hookRunner invokes the configured post_process_hook for each audited commit. The hook
+// receives the entry as JSON on stdin and prints a JSON object whose keys are merged into
+// the entry's Extras. Invocations are serialized unless the hook is declared concurrent-safe.
+type hookRunner struct {
+	Command    string
+	Timeout    time.Duration
+	Concurrent bool
+
+	serial   sync.Mutex
+	mu       sync.Mutex
+	failures int
+}
+
+// newHookRunner builds a runner from the config, or returns nil when no hook is configured.
+func newHookRunner(config *Config) (*hookRunner, error) {
+	if config.PostProcessHook == "" {
+		return nil, nil
+	}
+	timeout := defaultHookTimeout
+	if config.PostProcessHookTimeout != "" {
+		var err error
+		timeout, err = time.ParseDuration(config.PostProcessHookTimeout)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid post_process_hook_timeout %q: must be a positive duration such as \"10s\"", config.PostProcessHookTimeout)
+		}
+	}
+	return &hookRunner{
+		Command:    config.PostProcessHook,
+		Timeout:    timeout,
+		Concurrent: config.PostProcessHookConcurrent,
+	}, nil
+}
+
+// Enrich runs the hook for an entry and merges its output into data.Extras. Failures are
+// logged and counted but never returned: a broken hook must not fail the commit.
+func (h *hookRunner) Enrich(data *CommitAuditData) {
+	if h == nil {
+		return
+	}
+	if !h.Concurrent {
+		h.serial.Lock()
+		defer h.serial.Unlock()
+	}
+
+	extras, err := h.run(data)
+	if err != nil {
+		h.mu.Lock()
+		h.failures++
+		h.mu.Unlock()
+		fmt.Printf("Warning: post_process_hook failed for commit %s: %v\n", data.Hash, err)
+		return
+	}
+	if len(extras) == 0 {
+		return
+	}
+	if data.Extras == nil {
+		data.Extras = make(map[string]any, len(extras))
+	}
+	for key, value := range extras {
+		data.Extras[key] = value
+	}
+}
+
+// run executes the hook once with a timeout and decodes its stdout.
+func (h *hookRunner) run(data *CommitAuditData) (map[string]any, error) {
+	input, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal entry: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.Timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, h.Command)
+	cmd.Stdin = bytes.NewReader(input)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	// Do not wait for grandchildren holding stdout open once the hook itself has been killed.
+	cmd.WaitDelay = time.Second
+
+	output, err := cmd.Output()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil, fmt.Errorf("timed out after %s", formatDuration(h.Timeout))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w. Stderr: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if len(bytes.TrimSpace(output)) == 0 {
+		return nil, nil
+	}
+
+	var extras map[string]any
+	if err := json.Unmarshal(output, &extras); err != nil {
+		return nil, fmt.Errorf("hook output is not a JSON object: %w", err)
+	}
+	return extras, nil
+}
+
+// Failures returns how many hook invocations failed during the run.
+func (h *hookRunner) Failures() int {
+	if h == nil {
+		return 0
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.failures
+}
+
+// formatExtras renders an entry's Extras as "Key: value" lines in key order.
+func formatExtras(extras map[string]any) string {
+	keys := make([]string, 0, len(extras))
+	for key := range extras {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for _, key := range keys {
+		value := extras[key]
+		var text string
+		switch v := value.(type) {
+		case string:
+			text = v
+		default:
+			encoded, err := json.Marshal(v)
+			if err != nil {
+				text = fmt.Sprint(v)
+			} else {
+				text = string(encoded)
+			}
+		}
+		fmt.Fprintf(&sb, "%s: %s\n", key, text)
+	}
+	return sb.String()
+}
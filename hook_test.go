@@ -0,0 +1,135 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeHook writes a shell script hook into a temporary directory and returns its path.
+func writeHook(t *testing.T, script string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "hook.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestHookRunnerEnrich(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   string
+		timeout  time.Duration
+		extras   map[string]any
+		failures int
+	}{
+		{"merges output", `cat >/dev/null; echo '{"deployment":"prod-7","incidents":2}'`, time.Second, map[string]any{"deployment": "prod-7", "incidents": float64(2), "ticket": "T-1"}, 0},
+		{"empty output adds nothing", `cat >/dev/null`, time.Second, map[string]any{"ticket": "T-1"}, 0},
+		{"non-zero exit", `cat >/dev/null; echo oops >&2; exit 3`, time.Second, map[string]any{"ticket": "T-1"}, 1},
+		{"not a JSON object", `cat >/dev/null; echo '[1, 2]'`, time.Second, map[string]any{"ticket": "T-1"}, 1},
+		{"timeout", `sleep 5`, 200 * time.Millisecond, map[string]any{"ticket": "T-1"}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &hookRunner{Command: writeHook(t, tt.script), Timeout: tt.timeout}
+			data := CommitAuditData{Kind: kindCommit, Hash: "abc123", Extras: map[string]any{"ticket": "T-1"}}
+			start := time.Now()
+			h.Enrich(&data)
+			if elapsed := time.Since(start); elapsed > tt.timeout+2*time.Second {
+				t.Errorf("Enrich took %s", elapsed)
+			}
+			if len(data.Extras) != len(tt.extras) {
+				t.Errorf("extras = %v, want %v", data.Extras, tt.extras)
+			}
+			for key, want := range tt.extras {
+				if data.Extras[key] != want {
+					t.Errorf("extras[%s] = %v, want %v", key, data.Extras[key], want)
+				}
+			}
+			if h.Failures() != tt.failures {
+				t.Errorf("failures = %d, want %d", h.Failures(), tt.failures)
+			}
+		})
+	}
+}
+
+func TestHookRunnerReceivesEntry(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "input.json")
+	h := &hookRunner{Command: writeHook(t, "cat > "+out+"\n"), Timeout: time.Second}
+	h.Enrich(&CommitAuditData{Kind: kindCommit, Hash: "abc123", Author: "A <a@example.com>", Summary: "Did a thing."})
+	input := readFile(t, out)
+	for _, want := range []string{`"kind":"commit"`, `"hash":"abc123"`, `"summary":"Did a thing."`} {
+		if !strings.Contains(input, want) {
+			t.Errorf("hook input %s lacks %s", input, want)
+		}
+	}
+}
+
+func TestHookRunnerSerializes(t *testing.T) {
+	// The hook fails if another invocation holds the lock directory.
+	lock := filepath.Join(t.TempDir(), "lock")
+	script := "cat >/dev/null\nmkdir " + lock + " || exit 1\nsleep 0.1\nrmdir " + lock + "\n"
+	for _, concurrent := range []bool{false, true} {
+		h := &hookRunner{Command: writeHook(t, script), Timeout: 5 * time.Second, Concurrent: concurrent}
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				h.Enrich(&CommitAuditData{Hash: "abc"})
+			}()
+		}
+		wg.Wait()
+		os.Remove(lock)
+		if !concurrent && h.Failures() != 0 {
+			t.Errorf("serialized hook overlapped %d times", h.Failures())
+		}
+		if concurrent && h.Failures() == 0 {
+			t.Errorf("concurrent-safe hook never overlapped; the test cannot tell serialization apart")
+		}
+	}
+}
+
+func TestFormatExtras(t *testing.T) {
+	got := formatExtras(map[string]any{"zeta": "last", "alpha": float64(3), "list": []any{"a", "b"}})
+	want := "alpha: 3\nlist: [\"a\",\"b\"]\nzeta: last\n"
+	if got != want {
+		t.Errorf("formatExtras = %q, want %q", got, want)
+	}
+}
+
+func TestPostProcessHookIntegration(t *testing.T) {
+	example, err := filepath.Abs("testdata/hooks/deployment-info.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := newFixtureRepo(t)
+	hashes := repo.commits(3)
+	env := newAuditEnv(t)
+	env.Config["post_process_hook"] = example
+	report := filepath.Join(env.Work, "report.txt")
+	env.mustRun("-repo", repo.Dir, "-commit", "root", "-output", report)
+	text := readFile(t, report)
+	for _, hash := range hashes {
+		if !strings.Contains(text, "deployment: release-"+hash[:7]) {
+			t.Errorf("report lacks the hook's extras for %s:\n%s", hash, text)
+		}
+	}
+
+	jsonReport := filepath.Join(env.Work, "report.json")
+	env.mustRun("-repo", repo.Dir, "-commit", "root", "-out", "json="+jsonReport)
+	if text := readFile(t, jsonReport); !strings.Contains(text, `"deployment": "release-`+hashes[0][:7]) {
+		t.Errorf("JSON report lacks the hook's extras:\n%s", text)
+	}
+
+	// A failing hook is counted but fails no commit.
+	env.Config["post_process_hook"] = writeHook(t, "exit 1\n")
+	out := env.mustRun("-repo", repo.Dir, "-commit", "root", "-output", report, "-force")
+	if !strings.Contains(out, "post_process_hook failures: 3") || !strings.Contains(out, "Successfully wrote 3 audited commit entries") {
+		t.Errorf("failing hook run:\n%s", out)
+	}
+}
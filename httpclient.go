@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync/atomic"
+	"time"
+)
+
+// sharedTransport is the connection pool behind every model request, so that consecutive
+// requests to the same host reuse a kept-alive connection instead of dialing again.
+var sharedTransport = &http.Transport{
+	Proxy: http.ProxyFromEnvironment,
+	DialContext: (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).DialContext,
+	ForceAttemptHTTP2:     true,
+	MaxIdleConns:          16,
+	MaxIdleConnsPerHost:   4,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ExpectContinueTimeout: time.Second,
+}
+
+// The clients differ only in their timeouts; they share sharedTransport.
+var (
+	ollamaClient    = &http.Client{Transport: sharedTransport, Timeout: 60 * time.Second}
+	hostedClient    = &http.Client{Transport: sharedTransport, Timeout: hostedProviderTimeout}
+	modelInfoClient = &http.Client{Transport: sharedTransport, Timeout: 10 * time.Second}
+	lookupClient    = &http.Client{Transport: sharedTransport, Timeout: 15 * time.Second}
+	// completionClient asks Ollama for its models while a shell completion waits.
+	completionClient = &http.Client{Transport: sharedTransport, Timeout: completionTimeout}
+)
+
+// compressRequests gzips request bodies (-compress-requests). gzipRejected is set once a
+// server has refused a compressed body, after which bodies are sent uncompressed.
+var (
+	compressRequests bool
+	gzipRejected     atomic.Bool
+)
+
+// connStats counts the connections used by model requests, for -debug.
+var connStats struct {
+	New    atomic.Int64
+	Reused atomic.Int64
+}
+
+// connectionSummary describes connection reuse so far, e.g. "3 new, 41 reused".
+func connectionSummary() string {
+	return fmt.Sprintf("%d new, %d reused", connStats.New.Load(), connStats.Reused.Load())
+}
+
+// postBody POSTs body to url with the given headers. With -compress-requests the body is
+// gzipped; if the server answers 415 or 400 to a compressed body, the request is repeated
+// uncompressed and, when that works, compression is dropped for the rest of the run. While
+// the circuit breaker of url's endpoint is open, it fails at once with a *circuitOpenError.
+// Cancelling ctx fails the request, with errInterrupted.
+func postBody(ctx context.Context, client *http.Client, url string, headers map[string]string, body []byte) (*http.Response, error) {
+	breaker := breakerFor(url)
+	if err := breaker.allow(); err != nil {
+		return nil, err
+	}
+	resp, err := postBodyOnce(ctx, client, url, headers, body)
+	breaker.record(err)
+	return resp, err
+}
+
+// postBodyOnce is postBody without the circuit breaker.
+func postBodyOnce(ctx context.Context, client *http.Client, url string, headers map[string]string, body []byte) (*http.Response, error) {
+	if !compressRequests || gzipRejected.Load() {
+		return sendBody(ctx, client, url, headers, body, false)
+	}
+	resp, err := sendBody(ctx, client, url, headers, body, true)
+	if err != nil || (resp.StatusCode != http.StatusUnsupportedMediaType && resp.StatusCode != http.StatusBadRequest) {
+		return resp, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	resp, err = sendBody(ctx, client, url, headers, body, false)
+	if err == nil && resp.StatusCode != http.StatusUnsupportedMediaType && resp.StatusCode != http.StatusBadRequest {
+		if !gzipRejected.Swap(true) {
+			fmt.Printf("Warning: %s does not accept compressed requests; sending them uncompressed from now on.\n", url)
+		}
+	}
+	return resp, err
+}
+
+// sendBody sends one POST request, recording whether its connection was reused.
+func sendBody(ctx context.Context, client *http.Client, url string, headers map[string]string, body []byte, compress bool) (*http.Response, error) {
+	if compress {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(body); err != nil {
+			return nil, fmt.Errorf("failed to compress request: %w", err)
+		}
+		if err := zw.Close(); err != nil {
+			return nil, fmt.Errorf("failed to compress request: %w", err)
+		}
+		debugf("compressed request to %s from %s to %s", url, formatBytes(int64(len(body))), formatBytes(int64(buf.Len())))
+		body = buf.Bytes()
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				connStats.Reused.Add(1)
+			} else {
+				connStats.New.Add(1)
+			}
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	resp, err := client.Do(req)
+	if err != nil && ctx.Err() == context.Canceled {
+		return nil, fmt.Errorf("%w: %w", errInterrupted, err)
+	}
+	return resp, err
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// gzipServer records the bodies it receives, decompressed, and whether they were compressed.
+// Unless it accepts gzip, it answers compressed bodies with reject.
+type gzipServer struct {
+	*httptest.Server
+	mu         sync.Mutex
+	bodies     []string
+	compressed []bool
+}
+
+func newGzipServer(t *testing.T, acceptGzip bool, reject int) *gzipServer {
+	t.Helper()
+	s := &gzipServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		compressed := r.Header.Get("Content-Encoding") == "gzip"
+		var body io.Reader = r.Body
+		if compressed {
+			if !acceptGzip {
+				w.WriteHeader(reject)
+				io.WriteString(w, `{"error":"unsupported content encoding"}`)
+				return
+			}
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			body = zr
+		}
+		data, err := io.ReadAll(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.bodies = append(s.bodies, string(data))
+		s.compressed = append(s.compressed, compressed)
+		s.mu.Unlock()
+		io.WriteString(w, `{"ok":true}`)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// withCompression turns -compress-requests on for the duration of a test.
+func withCompression(t *testing.T) {
+	compressRequests = true
+	gzipRejected.Store(false)
+	t.Cleanup(func() {
+		compressRequests = false
+		gzipRejected.Store(false)
+	})
+}
+
+// post sends body through postBody and checks that it succeeds.
+func post(t *testing.T, url, body string) {
+	t.Helper()
+	resp, err := postBody(context.Background(), ollamaClient, url, map[string]string{"X-Test": "1"}, []byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+}
+
+func TestPostBodyCompressed(t *testing.T) {
+	withCompression(t)
+	server := newGzipServer(t, true, 0)
+	large := `{"prompt":"` + strings.Repeat("diff --git a/x b/x\n", 10000) + `"}`
+	post(t, server.URL, large)
+	post(t, server.URL, `{"prompt":"small"}`)
+	if len(server.bodies) != 2 || server.bodies[0] != large || !server.compressed[0] || !server.compressed[1] {
+		t.Errorf("server received %d bodies, compressed %v", len(server.bodies), server.compressed)
+	}
+	if gzipRejected.Load() {
+		t.Error("compression dropped although the server accepts it")
+	}
+
+	// Without -compress-requests nothing is compressed.
+	compressRequests = false
+	post(t, server.URL, `{"prompt":"plain"}`)
+	if server.compressed[2] {
+		t.Error("body compressed without -compress-requests")
+	}
+}
+
+func TestPostBodyFallsBackUncompressed(t *testing.T) {
+	for _, status := range []int{http.StatusUnsupportedMediaType, http.StatusBadRequest} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			withCompression(t)
+			server := newGzipServer(t, false, status)
+			for _, body := range []string{`{"n":1}`, `{"n":2}`, `{"n":3}`} {
+				post(t, server.URL, body)
+			}
+			// The rejected first request is repeated uncompressed; the rest are never compressed.
+			if strings.Join(server.bodies, " ") != `{"n":1} {"n":2} {"n":3}` {
+				t.Errorf("server received %q", server.bodies)
+			}
+			for i, compressed := range server.compressed {
+				if compressed {
+					t.Errorf("request %d was accepted compressed", i)
+				}
+			}
+			if !gzipRejected.Load() {
+				t.Error("rejection not remembered")
+			}
+		})
+	}
+}
+
+func TestPostBodyReusesConnections(t *testing.T) {
+	server := newGzipServer(t, true, 0)
+	newBefore, reusedBefore := connStats.New.Load(), connStats.Reused.Load()
+	for i := 0; i < 5; i++ {
+		post(t, server.URL, `{"n":1}`)
+	}
+	if n := connStats.New.Load() - newBefore; n != 1 {
+		t.Errorf("%d new connections for 5 sequential requests, want 1", n)
+	}
+	if n := connStats.Reused.Load() - reusedBefore; n != 4 {
+		t.Errorf("%d reused connections, want 4", n)
+	}
+}
+
+func TestCompressRequestsRun(t *testing.T) {
+	repo := newFixtureRepo(t)
+	repo.commits(3)
+	env := newAuditEnv(t)
+	// The fake server cannot read gzipped bodies and answers them 400.
+	out := env.mustRun("-repo", repo.Dir, "-commit", "root", "-output", filepath.Join(env.Work, "report.txt"), "-compress-requests", "-debug")
+	if n := strings.Count(out, "does not accept compressed requests; sending them uncompressed from now on."); n != 1 {
+		t.Errorf("fallback warned %d times, want once:\n%s", n, out)
+	}
+	if len(env.Ollama.Prompts()) != 3 || !strings.Contains(out, "Successfully wrote 3 audited commit entries") {
+		t.Errorf("run did not complete uncompressed:\n%s", out)
+	}
+	// One compressed attempt and three uncompressed requests share one connection, which the
+	// model info lookup may already have opened.
+	m := regexp.MustCompile(`\[debug\] model connections: (\d+) new, (\d+) reused`).FindStringSubmatch(out)
+	if m == nil || m[1]+m[2] != "13" && m[1]+m[2] != "04" {
+		t.Errorf("connection reuse not reported as one connection for 4 requests:\n%s", out)
+	}
+}
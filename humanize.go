@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The helpers below render durations, sizes, counts and rates for people reading the
+// console and the report. Every surface goes through them so the formats stay consistent;
+// the output does not depend on the locale.
+
+// formatDuration renders d with its two most significant units, e.g. "1h 13m", "4m 2s",
+// "2d 3h", "4.2s" or "350ms". Smaller units are truncated, not rounded.
+func formatDuration(d time.Duration) string {
+	if d < 0 {
+		return "-" + formatDuration(-d)
+	}
+	const day = 24 * time.Hour
+	switch {
+	case d == 0:
+		return "0s"
+	case d < time.Millisecond:
+		return "<1ms"
+	case d < time.Second:
+		return fmt.Sprintf("%dms", d/time.Millisecond)
+	case d < 10*time.Second:
+		return trimZeroDecimal(fmt.Sprintf("%.1f", float64(d.Truncate(100*time.Millisecond))/float64(time.Second))) + "s"
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", d/time.Second)
+	case d < time.Hour:
+		return joinUnits(int64(d/time.Minute), "m", int64(d%time.Minute/time.Second), "s")
+	case d < day:
+		return joinUnits(int64(d/time.Hour), "h", int64(d%time.Hour/time.Minute), "m")
+	default:
+		return joinUnits(int64(d/day), "d", int64(d%day/time.Hour), "h")
+	}
+}
+
+// joinUnits renders a major and minor unit, leaving out a zero minor unit ("5m", not "5m 0s").
+func joinUnits(major int64, majorUnit string, minor int64, minorUnit string) string {
+	if minor == 0 {
+		return fmt.Sprintf("%d%s", major, majorUnit)
+	}
+	return fmt.Sprintf("%d%s %d%s", major, majorUnit, minor, minorUnit)
+}
+
+// byteUnits are the decimal (SI) size units, as used by disk and network tooling.
+var byteUnits = []string{"B", "KB", "MB", "GB", "TB", "PB", "EB"}
+
+// formatBytes renders a size in decimal units with up to three significant digits, e.g.
+// "512 B", "5.2 MB" or "140 GB".
+func formatBytes(n int64) string {
+	if n < 0 {
+		return "-" + formatBytes(-n)
+	}
+	if n < 1000 {
+		return fmt.Sprintf("%d B", n)
+	}
+	value, unit := float64(n), 0
+	// Move up a unit as long as the rounded value would not fit in three digits, so that
+	// 999,999 bytes is "1 MB" rather than "1000 KB".
+	for unit < len(byteUnits)-1 && value >= 999.5 {
+		value /= 1000
+		unit++
+	}
+	if value < 9.95 {
+		return trimZeroDecimal(fmt.Sprintf("%.1f", value)) + " " + byteUnits[unit]
+	}
+	return fmt.Sprintf("%.0f %s", value, byteUnits[unit])
+}
+
+// formatCount renders an integer with comma thousands separators, e.g. "1,234,567".
+func formatCount(n int64) string {
+	digits := strconv.FormatInt(n, 10)
+	sign := ""
+	if n < 0 {
+		sign, digits = "-", digits[1:]
+	}
+	var sb strings.Builder
+	for i, digit := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteRune(digit)
+	}
+	return sign + sb.String()
+}
+
+// formatTokens renders a token count, e.g. "12,480 tokens".
+func formatTokens(n int) string {
+	return formatCount(int64(n)) + " tokens"
+}
+
+// formatRate renders count units per second over d, e.g. "12.3 tok/s". A zero duration
+// has no meaningful rate.
+func formatRate(count int64, unit string, d time.Duration) string {
+	if d <= 0 {
+		return "n/a"
+	}
+	rate := float64(count) / d.Seconds()
+	if rate >= 1000 {
+		return formatCount(int64(rate+0.5)) + " " + unit + "/s"
+	}
+	return fmt.Sprintf("%.1f %s/s", rate, unit)
+}
+
+// trimZeroDecimal drops a trailing ".0", so that exact values read "1s" and "5 MB".
+func trimZeroDecimal(s string) string {
+	return strings.TrimSuffix(s, ".0")
+}
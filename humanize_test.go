@@ -0,0 +1,130 @@
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestFormatDuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "0s"},
+		{500 * time.Microsecond, "<1ms"},
+		{time.Millisecond, "1ms"},
+		{999 * time.Millisecond, "999ms"},
+		{time.Second, "1s"},
+		{1250 * time.Millisecond, "1.2s"},
+		{9999 * time.Millisecond, "9.9s"},
+		{10 * time.Second, "10s"},
+		{59999 * time.Millisecond, "59s"},
+		{time.Minute, "1m"},
+		{61 * time.Second, "1m 1s"},
+		{time.Hour - time.Nanosecond, "59m 59s"},
+		{time.Hour, "1h"},
+		{time.Hour + 13*time.Minute + 42918273645*time.Nanosecond, "1h 13m"},
+		{24 * time.Hour, "1d"},
+		{26 * time.Hour, "1d 2h"},
+		{1000 * 24 * time.Hour, "1000d"},
+		{math.MaxInt64, "106751d 23h"},
+		{-90 * time.Second, "-1m 30s"},
+	}
+	for _, tt := range tests {
+		if got := formatDuration(tt.d); got != tt.want {
+			t.Errorf("formatDuration(%s) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{999, "999 B"},
+		{1000, "1 KB"},
+		{1500, "1.5 KB"},
+		{9949, "9.9 KB"},
+		{9950, "10 KB"},
+		{999499, "999 KB"},
+		{999500, "1 MB"},
+		{5242880, "5.2 MB"},
+		{140e9, "140 GB"},
+		{1e18, "1 EB"},
+		{math.MaxInt64, "9.2 EB"},
+		{-1500, "-1.5 KB"},
+	}
+	for _, tt := range tests {
+		if got := formatBytes(tt.n); got != tt.want {
+			t.Errorf("formatBytes(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestFormatCount(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0"},
+		{999, "999"},
+		{1000, "1,000"},
+		{100000, "100,000"},
+		{-1234567, "-1,234,567"},
+		{math.MinInt64, "-9,223,372,036,854,775,808"},
+	}
+	for _, tt := range tests {
+		if got := formatCount(tt.n); got != tt.want {
+			t.Errorf("formatCount(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+	if got := formatTokens(12480); got != "12,480 tokens" {
+		t.Errorf("formatTokens = %q", got)
+	}
+}
+
+func TestFormatRate(t *testing.T) {
+	tests := []struct {
+		count int64
+		d     time.Duration
+		want  string
+	}{
+		{123, 10 * time.Second, "12.3 tok/s"},
+		{1, 3 * time.Second, "0.3 tok/s"},
+		{9999, 10 * time.Second, "999.9 tok/s"},
+		{12345, time.Second, "12,345 tok/s"},
+		{100, 0, "n/a"},
+	}
+	for _, tt := range tests {
+		if got := formatRate(tt.count, "tok", tt.d); got != tt.want {
+			t.Errorf("formatRate(%d, %s) = %q, want %q", tt.count, tt.d, got, tt.want)
+		}
+	}
+}
+
+// rawValue matches durations and sizes printed as Go renders them, e.g. "1m13.918273645s",
+// "12.345678ms" or "5242880 bytes".
+var rawValue = regexp.MustCompile(`\b\d+m\d+(\.\d+)?s\b|\b\d+\.\d{3,}(ns|µs|ms|s)\b|\b\d{4,} bytes\b`)
+
+func TestRunOutputIsHumanized(t *testing.T) {
+	repo := newFixtureRepo(t)
+	repo.commits(3)
+	env := newAuditEnv(t)
+	env.Ollama.Delay = 20 * time.Millisecond
+	report := filepath.Join(env.Work, "report.txt")
+	out := env.mustRun("-repo", repo.Dir, "-commit", "root", "-output", report, "-debug")
+	if !regexp.MustCompile(`Run time: (\d+ms|\d+(\.\d)?s)\n`).MatchString(out) || !regexp.MustCompile(`Token usage: \d+ prompt \+ 30 output tokens over 3 Ollama calls \(\d[\d,]*\.\d tok/s\)`).MatchString(out) {
+		t.Errorf("run time or token rate not humanized:\n%s", out)
+	}
+	if m := rawValue.FindString(out); m != "" {
+		t.Errorf("console shows the raw value %q:\n%s", m, out)
+	}
+	if m := rawValue.FindString(readFile(t, report)); m != "" {
+		t.Errorf("report shows the raw value %q", m)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// exitInterrupted is the exit status of a run stopped by Ctrl+C or SIGTERM, the status a
+// shell reports for a process killed by SIGINT.
+const exitInterrupted = 130
+
+// Interrupt stages. Each Ctrl+C moves the run to the next one; SIGTERM goes straight to the
+// last.
+const (
+	// stageFinish stops starting commits; the commit in flight completes.
+	stageFinish = 1
+	// stageAbort also cancels the model request in flight; its commit is left pending.
+	stageAbort = 2
+	// stageExit exits at once after writing the commits audited so far, best effort.
+	stageExit = 3
+)
+
+// hardExitDeadline bounds the write of the commits audited so far before a hard exit.
+const hardExitDeadline = 5 * time.Second
+
+// requestCtx is the context of every model request and of the git commands of the commits
+// in flight. The second Ctrl+C cancels it, which fails the request in flight at once and
+// every later one.
+var requestCtx, abortRequests = context.WithCancel(context.Background())
+
+// runCtx is cancelled once the run must start no further commit: by the first Ctrl+C, a
+// permanent provider error, -budget, -retry-passes or an -exit-on-pause window, its cause
+// saying which. It derives from requestCtx, so aborting the requests stops the run too.
+var runCtx, stopRun = context.WithCancelCause(requestCtx)
+
+// stopping reports whether the run must start no further commit.
+func stopping() bool {
+	return runCtx.Err() != nil
+}
+
+// interruptHandler turns the signals received during a run into interrupt stages.
+type interruptHandler struct {
+	mu      sync.Mutex
+	stage   int
+	sigterm bool
+	entries []CommitAuditData
+	flush   func(entries []CommitAuditData)
+}
+
+// interrupts is the run's handler, fed by watchSignals.
+var interrupts = &interruptHandler{}
+
+// watchSignals passes SIGINT and SIGTERM to interrupts for the rest of the process.
+func watchSignals(sigChan <-chan os.Signal) {
+	for sig := range sigChan {
+		interrupts.handle(sig)
+	}
+}
+
+// Track records a copy of the entries audited so far, for a hard exit to write. The run
+// keeps appending to and finally sorts its own slice, which the signal goroutine must not
+// read.
+func (h *interruptHandler) Track(entries []CommitAuditData) {
+	snapshot := make([]CommitAuditData, len(entries))
+	copy(snapshot, entries)
+	h.mu.Lock()
+	h.entries = snapshot
+	h.mu.Unlock()
+}
+
+// SetFlush sets how a hard exit writes the commits audited so far. flush runs on the signal
+// goroutine while the run goes on, so it must render only the entries it is given. Until it
+// is set, a hard exit writes nothing.
+func (h *interruptHandler) SetFlush(flush func(entries []CommitAuditData)) {
+	h.mu.Lock()
+	h.flush = flush
+	h.mu.Unlock()
+}
+
+// Stage returns the stage the run is in, 0 when it was not interrupted by a signal.
+func (h *interruptHandler) Stage() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.stage
+}
+
+// handle moves the run to the next stage for sig and acts on it.
+func (h *interruptHandler) handle(sig os.Signal) {
+	h.mu.Lock()
+	if sig == syscall.SIGTERM {
+		h.stage = stageExit
+		h.sigterm = true
+	} else if h.stage < stageExit {
+		h.stage++
+	}
+	stage := h.stage
+	h.mu.Unlock()
+
+	stopRun(errInterrupted)
+	switch stage {
+	case stageFinish:
+		fmt.Println("\nCtrl+C received: finishing the current commit, Ctrl+C again to abort it.")
+	case stageAbort:
+		fmt.Println("\nCtrl+C received again: aborting the current commit and writing the commits audited so far. Ctrl+C once more to exit at once.")
+		abortRequests()
+	default:
+		abortRequests()
+		h.hardExit()
+	}
+}
+
+// hardExit writes the commits audited so far, giving up after hardExitDeadline, and exits.
+func (h *interruptHandler) hardExit() {
+	h.mu.Lock()
+	entries, flush, cause := h.entries, h.flush, h.describe()
+	h.mu.Unlock()
+	fmt.Printf("\n%s received: exiting after writing the commits audited so far.\n", cause)
+	if flush != nil && len(entries) > 0 {
+		done := make(chan struct{})
+		go func() {
+			flush(entries)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(hardExitDeadline):
+			fmt.Printf("Warning: writing the %d audited commits did not finish within %s; the report may be incomplete.\n", len(entries), formatDuration(hardExitDeadline))
+		}
+	}
+	fmt.Printf("\nProcess was terminated by %s.\n", cause)
+	exitProcess(exitInterrupted)
+}
+
+// describe names what ended the run, for the final console output. The caller holds h.mu.
+func (h *interruptHandler) describe() string {
+	switch {
+	case h.sigterm:
+		return "SIGTERM"
+	case h.stage == stageFinish:
+		return "Ctrl+C"
+	case h.stage == stageAbort:
+		return "second Ctrl+C"
+	}
+	return "third Ctrl+C"
+}
+
+// aborted reports whether the second stage cancelled the model requests, in which case a
+// commit that just failed was aborted rather than failing on its own.
+func aborted() bool {
+	return requestCtx.Err() != nil
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// withFreshContexts gives the test run and request contexts of its own, so that the
+// interrupts it delivers do not stop the rest of the tests.
+func withFreshContexts(t *testing.T) {
+	t.Helper()
+	savedRequest, savedAbort, savedRun, savedStop := requestCtx, abortRequests, runCtx, stopRun
+	requestCtx, abortRequests = context.WithCancel(context.Background())
+	runCtx, stopRun = context.WithCancelCause(requestCtx)
+	t.Cleanup(func() {
+		abortRequests()
+		requestCtx, abortRequests, runCtx, stopRun = savedRequest, savedAbort, savedRun, savedStop
+	})
+}
+
+func TestInterruptStages(t *testing.T) {
+	withFreshContexts(t)
+	h := &interruptHandler{}
+	if h.Stage() != 0 || stopping() || aborted() {
+		t.Fatal("the run starts interrupted")
+	}
+
+	// The first Ctrl+C stops new work; the request in flight keeps its context.
+	out := captureStdout(t, func() { h.handle(os.Interrupt) })
+	if h.Stage() != stageFinish || !stopping() || aborted() || !errors.Is(context.Cause(runCtx), errInterrupted) {
+		t.Errorf("after one Ctrl+C: stage %d, stopping %v, aborted %v", h.Stage(), stopping(), aborted())
+	}
+	if out != "\nCtrl+C received: finishing the current commit, Ctrl+C again to abort it.\n" {
+		t.Errorf("first Ctrl+C printed %q", out)
+	}
+	if h.describe() != "Ctrl+C" {
+		t.Errorf("describe = %q", h.describe())
+	}
+
+	// The second cancels the request in flight.
+	out = captureStdout(t, func() { h.handle(os.Interrupt) })
+	if h.Stage() != stageAbort || !aborted() || requestCtx.Err() == nil {
+		t.Errorf("after two: stage %d, aborted %v", h.Stage(), aborted())
+	}
+	if !strings.Contains(out, "Ctrl+C received again: aborting the current commit") || h.describe() != "second Ctrl+C" {
+		t.Errorf("second Ctrl+C printed %q, describes %q", out, h.describe())
+	}
+}
+
+// TestInterruptAbortsSlowRequest delivers the second Ctrl+C while a request to a server
+// that never answers is in flight: the request fails at once instead of waiting it out.
+func TestInterruptAbortsSlowRequest(t *testing.T) {
+	withFreshContexts(t)
+	env := newAuditEnv(t)
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	env.Ollama.Respond = func(n int, prompt string) (int, string) {
+		<-release
+		return 0, ""
+	}
+	generator, err := newGenerator(&Config{OllamaEndpoint: env.Ollama.Endpoint(), OllamaModel: "tiny:0.5b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &interruptHandler{}
+	done := make(chan error, 1)
+	go func() {
+		_, err := generator.Generate(requestCtx, "Summarize this change.")
+		done <- err
+	}()
+	for len(env.Ollama.Prompts()) == 0 {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	captureStdout(t, func() { h.handle(os.Interrupt) })
+	select {
+	case err := <-done:
+		t.Fatalf("the first Ctrl+C ended the request in flight: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	start := time.Now()
+	captureStdout(t, func() { h.handle(os.Interrupt) })
+	select {
+	case err := <-done:
+		if !errors.Is(err, errInterrupted) || !errors.Is(err, context.Canceled) {
+			t.Errorf("aborted request returned %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("the request took %s to abort", elapsed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the second Ctrl+C did not abort the request in flight")
+	}
+}
+
+// TestInterruptTrack checks that the handler keeps a copy of the tracked entries: the run
+// appends to and sorts its slice while a hard exit may be writing the copy.
+func TestInterruptTrack(t *testing.T) {
+	h := &interruptHandler{}
+	entries := make([]CommitAuditData, 0, 8)
+	entries = append(entries, CommitAuditData{Hash: "c1"}, CommitAuditData{Hash: "c2"})
+	h.Track(entries)
+	entries = append(entries, CommitAuditData{Hash: "c3"})
+	sortByRange(entries, []string{"c3", "c2", "c1"})
+	entries[0].Summary = "changed"
+
+	h.mu.Lock()
+	tracked := h.entries
+	h.mu.Unlock()
+	if len(tracked) != 2 || tracked[0].Hash != "c1" || tracked[1].Hash != "c2" || tracked[0].Summary != "" {
+		t.Errorf("tracked %+v", tracked)
+	}
+
+	// Tracking while the signal goroutine reads is not a race (go test -race).
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			h.mu.Lock()
+			snapshot := h.entries
+			h.mu.Unlock()
+			for _, entry := range snapshot {
+				_ = entry.Hash
+			}
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		entries = append(entries, CommitAuditData{Hash: fmt.Sprint(i)})
+		h.Track(entries)
+	}
+	wg.Wait()
+}
+
+// TestInterruptHardExit runs the third stage, which exits the process, in a child: the test
+// binary re-runs this test with GITAUDIT_TEST_HARD_EXIT naming the signals to deliver.
+func TestInterruptHardExit(t *testing.T) {
+	if signals := os.Getenv("GITAUDIT_TEST_HARD_EXIT"); signals != "" {
+		h := &interruptHandler{}
+		h.Track([]CommitAuditData{{Hash: "aaa"}, {Hash: "bbb"}})
+		h.SetFlush(func(entries []CommitAuditData) { fmt.Printf("flushed %d entries\n", len(entries)) })
+		for _, name := range strings.Split(signals, ",") {
+			if name == "TERM" {
+				h.handle(syscall.SIGTERM)
+			} else {
+				h.handle(os.Interrupt)
+			}
+		}
+		fmt.Println("still running")
+		os.Exit(0)
+	}
+
+	for signals, cause := range map[string]string{
+		"INT,INT,INT": "third Ctrl+C",
+		"TERM":        "SIGTERM",
+		"INT,TERM":    "SIGTERM",
+	} {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestInterruptHardExit$")
+		cmd.Env = append(os.Environ(), "GITAUDIT_TEST_HARD_EXIT="+signals)
+		out, err := cmd.CombinedOutput()
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != exitInterrupted {
+			t.Errorf("%s: %v\n%s", signals, err, out)
+		}
+		for _, want := range []string{
+			"\n" + cause + " received: exiting after writing the commits audited so far.\n",
+			"flushed 2 entries\n",
+			"\nProcess was terminated by " + cause + ".\n",
+		} {
+			if !strings.Contains(string(out), want) {
+				t.Errorf("%s: output lacks %q:\n%s", signals, want, out)
+			}
+		}
+		if strings.Contains(string(out), "still running") {
+			t.Errorf("%s: the process kept running:\n%s", signals, out)
+		}
+	}
+}
+
+// startSlowRun starts gitaudit on a fixture of three commits whose first commit is answered
+// at once and whose later ones wait until release is called or the test ends, and returns
+// once the second commit's request is in flight.
+func startSlowRun(t *testing.T) (env *auditEnv, cmd *exec.Cmd, out *strings.Builder, hashes []string, release func()) {
+	t.Helper()
+	repo := newFixtureRepo(t)
+	hashes = repo.commits(3)
+	env = newAuditEnv(t)
+	released := make(chan struct{})
+	var once sync.Once
+	release = func() { once.Do(func() { close(released) }) }
+	t.Cleanup(release)
+	env.Ollama.Respond = func(n int, prompt string) (int, string) {
+		if n > 1 {
+			select {
+			case <-released:
+			case <-time.After(30 * time.Second):
+			}
+		}
+		return 0, ""
+	}
+	cmd = env.command("-repo", repo.Dir, "-commit", "root", "-output", filepath.Join(env.Work, "report.txt"), "-max-retries", "1")
+	out = &strings.Builder{}
+	cmd.Stdout, cmd.Stderr = out, out
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cmd.Process.Kill() })
+	deadline := time.Now().Add(10 * time.Second)
+	for len(env.Ollama.Prompts()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("the second request never arrived")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return env, cmd, out, hashes, release
+}
+
+// waitExit waits for cmd and returns its exit status, failing the test after a deadline.
+func waitExit(t *testing.T, cmd *exec.Cmd) int {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return exitErr.ExitCode()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return 0
+	case <-time.After(15 * time.Second):
+		cmd.Process.Kill()
+		t.Fatal("the run did not exit")
+	}
+	return -1
+}
+
+func TestInterruptRun(t *testing.T) {
+	t.Run("finish", func(t *testing.T) {
+		env, cmd, out, hashes, release := startSlowRun(t)
+		cmd.Process.Signal(os.Interrupt)
+		time.Sleep(200 * time.Millisecond)
+		release()
+		if code := waitExit(t, cmd); code != exitInterrupted {
+			t.Errorf("exit %d\n%s", code, out)
+		}
+		for _, want := range []string{
+			"Ctrl+C received: finishing the current commit, Ctrl+C again to abort it.",
+			"Successfully processed commit " + hashes[1],
+			"\nProcess was interrupted by Ctrl+C after finishing the commit in flight.\n",
+			"The following 1 commits were pending processing or retry:\n" + hashes[0] + "\n",
+		} {
+			if !strings.Contains(out.String(), want) {
+				t.Errorf("output lacks %q:\n%s", want, out)
+			}
+		}
+		if report := readFile(t, filepath.Join(env.Work, "report.txt")); strings.Count(report, "Commit: ") != 2 {
+			t.Errorf("report:\n%s", report)
+		}
+	})
+
+	t.Run("abort", func(t *testing.T) {
+		env, cmd, out, hashes, _ := startSlowRun(t)
+		cmd.Process.Signal(os.Interrupt)
+		time.Sleep(200 * time.Millisecond)
+		cmd.Process.Signal(os.Interrupt)
+		if code := waitExit(t, cmd); code != exitInterrupted {
+			t.Errorf("exit %d\n%s", code, out)
+		}
+		for _, want := range []string{
+			"Ctrl+C received: finishing the current commit, Ctrl+C again to abort it.",
+			"Ctrl+C received again: aborting the current commit",
+			"\nProcess was interrupted by a second Ctrl+C; the commit in flight was aborted and is pending.\n",
+			"The following 2 commits were pending processing or retry:\n" + hashes[1] + "\n" + hashes[0] + "\n",
+		} {
+			if !strings.Contains(out.String(), want) {
+				t.Errorf("output lacks %q:\n%s", want, out)
+			}
+		}
+		// The commit audited before the interrupt is written.
+		if report := readFile(t, filepath.Join(env.Work, "report.txt")); strings.Count(report, "Commit: ") != 1 || !strings.Contains(report, "Commit: "+hashes[2]) {
+			t.Errorf("report:\n%s", report)
+		}
+	})
+
+	t.Run("sigterm", func(t *testing.T) {
+		env, cmd, out, hashes, _ := startSlowRun(t)
+		cmd.Process.Signal(syscall.SIGTERM)
+		if code := waitExit(t, cmd); code != exitInterrupted {
+			t.Errorf("exit %d\n%s", code, out)
+		}
+		if !strings.Contains(out.String(), "\nSIGTERM received: exiting after writing the commits audited so far.\n") ||
+			!strings.HasSuffix(out.String(), "\nProcess was terminated by SIGTERM.\n") {
+			t.Errorf("output:\n%s", out)
+		}
+		if report := readFile(t, filepath.Join(env.Work, "report.txt")); !strings.Contains(report, "Commit: "+hashes[2]) {
+			t.Errorf("report:\n%s", report)
+		}
+	})
+}
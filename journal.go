@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// reportJournal keeps every entry on disk as soon as its commit is audited, so that a crash
+// or power loss loses at most the commits in flight. Entries are appended in the order they
+// complete and synced one by one; the report written at the end of the run, in range order
+// and with its sections, replaces the journal.
+type reportJournal struct {
+	Path string
+
+	file   *os.File
+	writer *entryWriter
+}
+
+// journalPathFor returns the journal kept next to the given report path.
+func journalPathFor(outputPath string) string {
+	return outputPath + ".journal"
+}
+
+// openReportJournal starts the journal of the report at outputPath, headed by header. The
+// journal of an earlier run that did not get to write its report is moved aside rather than
+// overwritten, as it may hold the only copy of its entries.
+func openReportJournal(outputPath, header string) (*reportJournal, error) {
+	path := journalPathFor(outputPath)
+	if _, err := os.Stat(path); err == nil {
+		previous := path + "." + time.Now().Format("20060102-150405")
+		if err := os.Rename(path, previous); err != nil {
+			return nil, fmt.Errorf("failed to move aside the journal %s of an earlier run: %w", path, err)
+		}
+		fmt.Printf("Warning: %s is the journal of an earlier run that did not finish; moved it to %s\n", path, previous)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create journal %s: %w", path, err)
+	}
+	if _, err := file.WriteString(msg("header.journal", time.Now().Format(time.RFC3339), outputPath) + "\n\n" + header + "\n\n"); err != nil {
+		file.Close()
+		os.Remove(path)
+		return nil, fmt.Errorf("failed to write journal %s: %w", path, err)
+	}
+	return &reportJournal{Path: path, file: file, writer: &entryWriter{w: file}}, nil
+}
+
+// Append writes the entry of an audited commit to the journal and syncs it to disk. A failed
+// write is reported once and ends journaling; the run itself goes on. It is safe to call on nil.
+func (j *reportJournal) Append(data CommitAuditData) {
+	if j == nil || j.file == nil {
+		return
+	}
+	err := j.writer.WriteEntry(formatCommitEntry(data), 0)
+	if err == nil {
+		err = j.file.Sync()
+	}
+	if err != nil {
+		fmt.Printf("Warning: failed to append commit %s to the journal %s; journaling stopped: %v\n", data.Hash, j.Path, err)
+		j.file.Close()
+		j.file = nil
+	}
+}
+
+// Finish closes the journal. Once the report holds every entry, written is set and the
+// journal is removed; otherwise it is kept, with its path printed, as the record of the run.
+func (j *reportJournal) Finish(written bool) {
+	if j == nil {
+		return
+	}
+	if j.file != nil {
+		j.file.Close()
+		j.file = nil
+	}
+	if written {
+		os.Remove(j.Path)
+		return
+	}
+	fmt.Printf("The audited entries are kept in the journal %s\n", j.Path)
+}
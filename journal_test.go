@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// shortWriter writes the first n bytes it is given and then fails, as a full disk or a
+// crash partway through a write would leave the journal.
+type shortWriter struct {
+	w io.Writer
+	n int
+}
+
+func (s *shortWriter) Write(p []byte) (int, error) {
+	if len(p) <= s.n {
+		s.n -= len(p)
+		return s.w.Write(p)
+	}
+	written, _ := s.w.Write(p[:s.n])
+	s.n = 0
+	return written, errors.New("no space left on device")
+}
+
+// journalEntries returns the entries of the journal at path, after its header.
+func journalEntries(t *testing.T, path, header string) []string {
+	t.Helper()
+	content := readFile(t, path)
+	_, body, ok := strings.Cut(content, "\n\n"+header+"\n\n")
+	if !ok {
+		t.Fatalf("the journal does not start with its header:\n%s", content)
+	}
+	return strings.Split(body, entrySeparator)
+}
+
+func TestReportJournalAppend(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "gitaudit.txt")
+	header := "Repository: /src/app"
+	one, two := CommitAuditData{Hash: "c1", Summary: "One."}, CommitAuditData{Hash: "c2", Summary: "Two."}
+	three, four := CommitAuditData{Hash: "c3", Summary: "Three."}, CommitAuditData{Hash: "c4", Summary: "Four."}
+
+	for _, tc := range []struct {
+		name string
+		// fail, when positive, makes the write of the third entry fail after that many bytes.
+		fail    int
+		want    []string
+		warning string
+	}{
+		{name: "entries", want: []string{formatCommitEntry(one), formatCommitEntry(two), formatCommitEntry(three), formatCommitEntry(four)}},
+		// The separator is written before an entry, so a write cut short leaves the entries
+		// before it whole and only the last record partial.
+		{name: "partial last record", fail: len(entrySeparator) + 5, want: []string{formatCommitEntry(one), formatCommitEntry(two), formatCommitEntry(three)[:5]}, warning: "Warning: failed to append commit c3 to the journal "},
+		{name: "separator cut short", fail: 2, warning: "journaling stopped: failed to write separator: no space left on device"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var j *reportJournal
+			printed := captureStdout(t, func() {
+				var err error
+				if j, err = openReportJournal(out, header); err != nil {
+					t.Fatal(err)
+				}
+				j.Append(one)
+				j.Append(two)
+				if tc.fail > 0 {
+					j.writer.w = &shortWriter{w: j.file, n: tc.fail}
+				}
+				j.Append(three)
+				// Once a write failed, journaling has stopped.
+				j.Append(four)
+			})
+			defer j.Finish(true)
+			if tc.warning == "" && printed != "" || !strings.Contains(printed, tc.warning) || strings.Count(printed, "Warning") > 1 {
+				t.Errorf("printed %q, want %q", printed, tc.warning)
+			}
+			entries := journalEntries(t, j.Path, header)
+			if tc.want == nil {
+				// The separator was cut short: the two entries before it are whole.
+				if len(entries) != 2 || entries[0] != formatCommitEntry(one) || !strings.HasPrefix(entries[1], formatCommitEntry(two)+entrySeparator[:2]) {
+					t.Errorf("entries %q", entries)
+				}
+				return
+			}
+			if len(entries) != len(tc.want) {
+				t.Fatalf("%d entries, want %d:\n%s", len(entries), len(tc.want), readFile(t, j.Path))
+			}
+			for i := range entries {
+				if entries[i] != tc.want[i] {
+					t.Errorf("entry %d is %q, want %q", i, entries[i], tc.want[i])
+				}
+			}
+		})
+	}
+
+	// Nil journals ignore updates.
+	var none *reportJournal
+	none.Append(one)
+	none.Finish(false)
+}
+
+func TestReportJournalMovesEarlierAside(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "gitaudit.txt")
+	path := journalPathFor(out)
+	if err := os.WriteFile(path, []byte("the entries of an earlier run\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var j *reportJournal
+	printed := captureStdout(t, func() {
+		var err error
+		if j, err = openReportJournal(out, "Repository: /src/app"); err != nil {
+			t.Fatal(err)
+		}
+	})
+	defer j.Finish(true)
+	aside, _ := filepath.Glob(path + ".*")
+	if len(aside) != 1 || printed != "Warning: "+path+" is the journal of an earlier run that did not finish; moved it to "+aside[0]+"\n" {
+		t.Fatalf("moved aside %q; printed %q", aside, printed)
+	}
+	if got := readFile(t, aside[0]); got != "the entries of an earlier run\n" {
+		t.Errorf("the earlier journal holds %q", got)
+	}
+	if !strings.Contains(readFile(t, path), "\n\nRepository: /src/app\n\n") {
+		t.Errorf("the new journal:\n%s", readFile(t, path))
+	}
+}
+
+func TestReportJournalFinish(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		written bool
+		printed string // Printed after the journal's path; empty when nothing is.
+		kept    bool
+	}{
+		{name: "report written", written: true},
+		{name: "report failed", written: false, printed: "The audited entries are kept in the journal ", kept: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			out := filepath.Join(t.TempDir(), "gitaudit.txt")
+			j, err := openReportJournal(out, "Repository: /src/app")
+			if err != nil {
+				t.Fatal(err)
+			}
+			captureStdout(t, func() { j.Append(CommitAuditData{Hash: "c1", Summary: "One."}) })
+			printed := captureStdout(t, func() { j.Finish(tc.written) })
+			want := ""
+			if tc.printed != "" {
+				want = tc.printed + j.Path + "\n"
+			}
+			if printed != want {
+				t.Errorf("printed %q, want %q", printed, want)
+			}
+			_, err = os.Stat(j.Path)
+			if kept := err == nil; kept != tc.kept {
+				t.Errorf("journal kept %v, want %v", kept, tc.kept)
+			}
+			// Finish closes the journal: later entries are not written.
+			j.Append(CommitAuditData{Hash: "c2"})
+			if tc.kept && strings.Contains(readFile(t, j.Path), "c2") {
+				t.Error("an entry was appended after Finish")
+			}
+		})
+	}
+}
+
+// TestReportJournalRun checks that a run keeps no journal once its report is written.
+func TestReportJournalRun(t *testing.T) {
+	repo := newFixtureRepo(t)
+	repo.commits(2)
+	env := newAuditEnv(t)
+	report := filepath.Join(env.Work, "report.txt")
+	env.mustRun("-repo", repo.Dir, "-commit", "root", "-output", report)
+	if _, err := os.Stat(journalPathFor(report)); !os.IsNotExist(err) {
+		t.Errorf("the journal of a written report is left: %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// jsonReportVersion is the version of the -format json document; it changes only when a field
+// is removed or changes meaning.
+const jsonReportVersion = 1
+
+// jsonReport is the -format json document: the run's metadata wrapping its entries. Entries
+// have the fields of the post_process_hook JSON and the shard manifests.
+type jsonReport struct {
+	Version   int    `json:"version"`
+	Generated string `json:"generated"`
+	Repo      string `json:"repo"`
+	// Head and Boundary are the resolved tip and the commit the range stops at, empty for
+	// stash and reflog audits. Range lists the commits to audit, newest first.
+	Head     string   `json:"head,omitempty"`
+	Boundary string   `json:"boundary,omitempty"`
+	Range    []string `json:"range"`
+	Provider string   `json:"provider"`
+	Model    string   `json:"model"`
+	// ModelAutoSelected is set when no model was configured and gitaudit chose Model.
+	ModelAutoSelected bool `json:"model_auto_selected,omitempty"`
+	// Partial is set when the run was interrupted or stopped early; Commits then holds the
+	// entries audited so far.
+	Partial bool `json:"partial,omitempty"`
+	// Outputs lists every -out target of the run, this one included, as format=path.
+	Outputs []string `json:"outputs,omitempty"`
+	// Timeline is the -timeline series, empty without it.
+	Timeline *activityTimeline `json:"timeline,omitempty"`
+	// Dependencies is the -dependency-digest: the net change of each dependency over the
+	// range, empty without it.
+	Dependencies []dependencyTransition `json:"dependencies,omitempty"`
+	// Usage totals the token usage of the run's model calls, and CostUSD its price under the
+	// configured pricing (zero for local models).
+	Usage   *tokenUsage `json:"usage,omitempty"`
+	CostUSD float64     `json:"cost_usd,omitempty"`
+	// Failed lists the commits given up after -max-retries retries.
+	Failed  []failedCommit    `json:"failed,omitempty"`
+	Commits []CommitAuditData `json:"commits"`
+}
+
+// failedCommit is a commit given up after -max-retries retries, with its number of attempts
+// and the error of the last one.
+type failedCommit struct {
+	Hash     string `json:"hash"`
+	Attempts int    `json:"attempts"`
+	Error    string `json:"error"`
+}
+
+// writeJSONReport writes report to path as indented JSON, atomically like the text report.
+func writeJSONReport(path string, report jsonReport) error {
+	report.Version = jsonReportVersion
+	report.Generated = time.Now().UTC().Format(time.RFC3339)
+	if report.Commits == nil {
+		report.Commits = []CommitAuditData{}
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode JSON report: %w", err)
+	}
+	if err := writeFileAtomic(path, append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write JSON report %s: %w", path, err)
+	}
+	// writeFileAtomic uses CreateTemp's 0600; the report is as readable as the text one.
+	os.Chmod(path, 0o644)
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// leakMask replaces a withheld path the model named despite the policy.
+const leakMask = "[withheld]"
+
+// leakRetryInstruction is added when a summary is regenerated because it named a withheld
+// file. It deliberately does not repeat the names, which would send them to the model.
+const leakRetryInstruction = "Your previous answer named a file that is withheld from this audit. Describe the change without naming any file or path that does not appear in the patch or file list below."
+
+// findLeaks returns the withheld paths, and their file names, that summary mentions, longest
+// first so that masking a path does not leave part of it behind. The never_send globs are the
+// one source of the withheld files, for the prompt and for this check alike.
+func findLeaks(summary string, withheld []withheldFile) []string {
+	seen := make(map[string]bool)
+	var leaks []string
+	for _, w := range withheld {
+		for _, name := range []string{w.Path, path.Base(w.Path)} {
+			if name == "" || name == "." || seen[name] || !strings.Contains(summary, name) {
+				continue
+			}
+			seen[name] = true
+			leaks = append(leaks, name)
+		}
+	}
+	sort.Slice(leaks, func(i, j int) bool {
+		if len(leaks[i]) != len(leaks[j]) {
+			return len(leaks[i]) > len(leaks[j])
+		}
+		return leaks[i] < leaks[j]
+	})
+	return leaks
+}
+
+// maskLeaks replaces every leaked name in summary with leakMask.
+func maskLeaks(summary string, leaks []string) string {
+	for _, name := range leaks {
+		summary = strings.ReplaceAll(summary, name, leakMask)
+	}
+	return summary
+}
+
+// checkLeaks implements never_send_confidential: a summary that names a withheld file is
+// regenerated once with leakRetryInstruction and, if it still names one, the names are masked
+// and the entry is flagged. regenerate builds and sends the prompt with the extra instruction.
+func checkLeaks(commitHash string, summary string, withheld []withheldFile, regenerate func(instruction string) (generation, error)) (string, tokenUsage, bool, error) {
+	leaks := findLeaks(summary, withheld)
+	if len(leaks) == 0 {
+		return summary, tokenUsage{}, false, nil
+	}
+	fmt.Printf("Commit %s: summary names a withheld file; regenerating once.\n", commitHash)
+	regenerated, err := regenerate(leakRetryInstruction)
+	if err != nil {
+		return summary, tokenUsage{}, false, fmt.Errorf("failed to regenerate a summary naming withheld files: %w", err)
+	}
+	summary = regenerated.Text
+	leaks = findLeaks(summary, withheld)
+	if len(leaks) == 0 {
+		return summary, regenerated.Usage, false, nil
+	}
+	fmt.Printf("Commit %s: regenerated summary still names a withheld file; masking %s.\n", commitHash, strings.Join(leaks, ", "))
+	return maskLeaks(summary, leaks), regenerated.Usage, true, nil
+}
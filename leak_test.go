@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFindAndMaskLeaks(t *testing.T) {
+	withheld := []withheldFile{{Path: "secrets/prod.env", Glob: "secrets/*"}, {Path: "deploy/keys/ca.pem", Glob: "**/*.pem"}}
+	tests := []struct {
+		summary string
+		leaks   []string
+		masked  string
+	}{
+		{"Refactors the request handler.", nil, "Refactors the request handler."},
+		{"Rotates the token in secrets/prod.env.", []string{"secrets/prod.env", "prod.env"}, "Rotates the token in [withheld]."},
+		// The file name alone, quoted, and a path the model rebuilt around it.
+		{"Edits `prod.env` to add STRIPE_KEY.", []string{"prod.env"}, "Edits `[withheld]` to add STRIPE_KEY."},
+		{"Moves config to ./secrets/prod.env.bak", []string{"secrets/prod.env", "prod.env"}, "Moves config to ./[withheld].bak"},
+		// Both files, several times: every occurrence goes, and no part of a path is left behind.
+		{"ca.pem and deploy/keys/ca.pem, then prod.env and ca.pem again", []string{"deploy/keys/ca.pem", "prod.env", "ca.pem"}, "[withheld] and [withheld], then [withheld] and [withheld] again"},
+	}
+	for _, tt := range tests {
+		leaks := findLeaks(tt.summary, withheld)
+		if !reflect.DeepEqual(leaks, tt.leaks) {
+			t.Errorf("findLeaks(%q) = %q, want %q", tt.summary, leaks, tt.leaks)
+		}
+		if masked := maskLeaks(tt.summary, leaks); masked != tt.masked {
+			t.Errorf("maskLeaks(%q) = %q, want %q", tt.summary, masked, tt.masked)
+		}
+		if len(leaks) > 0 && len(findLeaks(maskLeaks(tt.summary, leaks), withheld)) != 0 {
+			t.Errorf("masked %q still names a withheld file", tt.summary)
+		}
+	}
+	// A file at the root has no separate base name; "." is never a leak.
+	if leaks := findLeaks("Updates .env.", []withheldFile{{Path: ".env"}, {Path: "."}}); !reflect.DeepEqual(leaks, []string{".env"}) {
+		t.Errorf("leaks of a root file: %q", leaks)
+	}
+}
+
+func TestCheckLeaks(t *testing.T) {
+	withheld := []withheldFile{{Path: "secrets/prod.env", Glob: "secrets/*"}}
+	var instructions []string
+	regenerateWith := func(text string, err error) func(string) (generation, error) {
+		return func(instruction string) (generation, error) {
+			instructions = append(instructions, instruction)
+			return generation{Text: text, Usage: tokenUsage{PromptTokens: 100, OutputTokens: 10}}, err
+		}
+	}
+
+	var summary string
+	var usage tokenUsage
+	var masked bool
+	var err error
+	out := captureStdout(t, func() {
+		// A clean summary is kept without a second call.
+		summary, usage, masked, err = checkLeaks("abc1234", "Refactors the handler.", withheld, regenerateWith("unused", nil))
+	})
+	if summary != "Refactors the handler." || masked || err != nil || usage.OutputTokens != 0 || len(instructions) != 0 || out != "" {
+		t.Errorf("clean summary: %q, %v, %v, %+v, %q", summary, masked, err, usage, out)
+	}
+
+	// Regenerating fixes it: the new summary is used and nothing is masked.
+	out = captureStdout(t, func() {
+		summary, usage, masked, err = checkLeaks("abc1234", "Rotates prod.env.", withheld, regenerateWith("Rotates a credential.", nil))
+	})
+	if summary != "Rotates a credential." || masked || err != nil || usage.OutputTokens != 10 {
+		t.Errorf("regenerated summary: %q, %v, %v, %+v", summary, masked, err, usage)
+	}
+	if len(instructions) != 1 || instructions[0] != leakRetryInstruction || strings.Contains(instructions[0], "prod.env") {
+		t.Errorf("regeneration instructions %q", instructions)
+	}
+	if out != "Commit abc1234: summary names a withheld file; regenerating once.\n" {
+		t.Errorf("output %q", out)
+	}
+
+	// The model repeats the name: it is masked and the entry flagged, after one retry only.
+	instructions = nil
+	out = captureStdout(t, func() {
+		summary, usage, masked, err = checkLeaks("abc1234", "Rotates prod.env.", withheld, regenerateWith("Rotates the key in secrets/prod.env.", nil))
+	})
+	if summary != "Rotates the key in [withheld]." || !masked || err != nil || usage.OutputTokens != 10 || len(instructions) != 1 {
+		t.Errorf("repeated leak: %q, %v, %v, %+v", summary, masked, err, usage)
+	}
+	if !strings.Contains(out, "regenerated summary still names a withheld file; masking secrets/prod.env, prod.env.\n") {
+		t.Errorf("output %q", out)
+	}
+
+	// A failed regeneration fails the commit; the leaking summary is not stored unmasked.
+	captureStdout(t, func() {
+		_, _, masked, err = checkLeaks("abc1234", "Rotates prod.env.", withheld, regenerateWith("", errors.New("connection refused")))
+	})
+	if err == nil || masked || !strings.Contains(err.Error(), "failed to regenerate a summary naming withheld files: connection refused") {
+		t.Errorf("failed regeneration: %v", err)
+	}
+}
+
+func TestLeakScannerRun(t *testing.T) {
+	repo := newFixtureRepo(t)
+	repo.commits(1)
+	stubborn := repo.commit("Rotate the payment key", map[string]string{"src/pay.go": "package pay\n", "secrets/prod.env": "STRIPE_KEY=sk_live_1\n"})
+	fixed := repo.commit("Rotate the CA", map[string]string{"src/tls.go": "package tls\n", "secrets/ca.pem": "-----BEGIN CERTIFICATE-----\n"})
+	env := newAuditEnv(t)
+	env.Config["never_send"] = []string{"secrets/*"}
+	env.Config["never_send_confidential"] = true
+	// Adversarial answers: the model names the withheld files it was never shown; for pay.go
+	// it does so again when asked not to.
+	env.Ollama.Respond = func(n int, prompt string) (int, string) {
+		retry := strings.Contains(prompt, leakRetryInstruction)
+		switch {
+		case strings.Contains(prompt, "src/pay.go") && !retry:
+			return 200, `{"response":"Updates pay.go and the key in secrets/prod.env.","done":true}`
+		case strings.Contains(prompt, "src/pay.go"):
+			return 200, `{"response":"Updates pay.go; prod.env gets the new key.","done":true}`
+		case strings.Contains(prompt, "src/tls.go") && !retry:
+			return 200, `{"response":"Replaces ca.pem.","done":true}`
+		case strings.Contains(prompt, "src/tls.go"):
+			return 200, `{"response":"Replaces the certificate used by tls.go.","done":true}`
+		}
+		return 0, ""
+	}
+
+	report := filepath.Join(env.Work, "report.json")
+	out := env.mustRun("-repo", repo.Dir, "-commit", "root", "-format", "json", "-output", report)
+	for _, prompt := range env.Ollama.Prompts() {
+		if strings.Contains(prompt, "sk_live_1") || strings.Contains(prompt, "BEGIN CERTIFICATE") {
+			t.Errorf("prompt carries a withheld file:\n%s", prompt)
+		}
+	}
+	if n := len(env.Ollama.Prompts()); n != 5 {
+		t.Errorf("%d model calls, want one per commit and one retry for each leak", n)
+	}
+	if !strings.Contains(out, "1 summaries named withheld files after regenerating and were masked.\n") {
+		t.Errorf("final summary lacks the masked count:\n%s", out)
+	}
+
+	var document jsonReport
+	if err := json.Unmarshal([]byte(readFile(t, report)), &document); err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range document.Commits {
+		switch entry.Hash {
+		case stubborn:
+			if entry.Summary != "Updates pay.go; [withheld] gets the new key." || !entry.LeakMasked {
+				t.Errorf("stubborn leak: %q, masked %v", entry.Summary, entry.LeakMasked)
+			}
+		case fixed:
+			if entry.Summary != "Replaces the certificate used by tls.go." || entry.LeakMasked {
+				t.Errorf("fixed leak: %q, masked %v", entry.Summary, entry.LeakMasked)
+			}
+		default:
+			if entry.LeakMasked {
+				t.Errorf("%s masked without withheld files", entry.Hash)
+			}
+		}
+	}
+}
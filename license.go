@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// licenseFilePrefixes are the base names, compared case-insensitively and followed by
+// anything (LICENSE.md, LICENSE-MIT, COPYING.LESSER), of files holding licensing terms.
+var licenseFilePrefixes = []string{"license", "licence", "copying", "notice"}
+
+// copyrightLine matches a copyright header line: comment markers only, then "Copyright"
+// with a year, (c) or ©, or (c) or © with a year. The word in code or prose, such as a
+// string "copyright notice missing", does not match.
+var copyrightLine = regexp.MustCompile(`(?i)^[\s#/*;!%'-]*(?:<!--|\{-|\(\*|--|rem\b)?\s*(?:copyright\b.*(?:\(c\)|©|\b(?:19|20)\d{2}\b)|(?:\(c\)|©)\s*(?:19|20)\d{2})`)
+
+// spdxLine matches an SPDX-License-Identifier tag and captures its license expression.
+var spdxLine = regexp.MustCompile(`SPDX-License-Identifier:\s*([^\s*][^*]*?)\s*(?:\*/|-->|-\}|\*\))?\s*$`)
+
+// licenseHeaders are the copyright and SPDX lines a patch adds and removes in one file.
+type licenseHeaders struct {
+	Path      string
+	Copyright bool // A copyright line was added or removed.
+	Removed   []string
+	Added     []string // SPDX license expressions, in patch order.
+}
+
+// licenseSourceExtensions are the extensions of source files whose names merely start like a
+// license file's, such as license.go or notice_test.py; they are not licensing material.
+var licenseSourceExtensions = map[string]bool{
+	".go": true, ".py": true, ".js": true, ".ts": true, ".jsx": true, ".tsx": true, ".rb": true, ".java": true,
+	".kt": true, ".scala": true, ".c": true, ".h": true, ".cc": true, ".cpp": true, ".hpp": true, ".cs": true,
+	".rs": true, ".php": true, ".swift": true, ".sh": true, ".pl": true, ".lua": true,
+}
+
+// isLicenseFile reports whether p is a license, copying or NOTICE file, or lies in a
+// LICENSES directory as the REUSE layout has it.
+func isLicenseFile(p string) bool {
+	for _, dir := range strings.Split(path.Dir(p), "/") {
+		if strings.EqualFold(dir, "licenses") || strings.EqualFold(dir, "licences") {
+			return true
+		}
+	}
+	base := strings.ToLower(path.Base(p))
+	if licenseSourceExtensions[path.Ext(base)] {
+		return false
+	}
+	for _, prefix := range licenseFilePrefixes {
+		if strings.HasPrefix(base, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// getLicenseHeaders reads the copyright and SPDX lines a commit changes, compared with base
+// when it is set. Merges compared with all their parents are left to their paths.
+func getLicenseHeaders(ctx context.Context, repoPath, commitHash, base string, merge bool) ([]licenseHeaders, error) {
+	if merge && base == "" {
+		return nil, nil
+	}
+	args := []string{"diff-tree", "-p", "-U0", "--no-color", "--no-ext-diff", "--no-commit-id", "-M"}
+	if base != "" {
+		args = append(args, base, commitHash)
+	} else {
+		args = append(args, "--root", commitHash)
+	}
+	output, err := gitRun(ctx, repoPath, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the diff for license headers: %w", err)
+	}
+	return scanLicenseHeaders(string(output)), nil
+}
+
+// scanLicenseHeaders finds the copyright and SPDX lines added and removed in each file of a
+// patch, by path. Only changed lines count; a file whose header is merely context does not.
+func scanLicenseHeaders(patch string) []licenseHeaders {
+	var files []licenseHeaders
+	current := -1
+	for _, line := range strings.Split(patch, "\n") {
+		if strings.HasPrefix(line, "diff --git ") {
+			files = append(files, licenseHeaders{Path: diffSectionPath(line)})
+			current = len(files) - 1
+			continue
+		}
+		if current < 0 || len(line) == 0 || (line[0] != '+' && line[0] != '-') || strings.HasPrefix(line, "+++ ") || strings.HasPrefix(line, "--- ") {
+			continue
+		}
+		text := line[1:]
+		if m := spdxLine.FindStringSubmatch(text); m != nil {
+			if line[0] == '+' {
+				files[current].Added = append(files[current].Added, m[1])
+			} else {
+				files[current].Removed = append(files[current].Removed, m[1])
+			}
+		} else if copyrightLine.MatchString(text) {
+			files[current].Copyright = true
+		}
+	}
+	var kept []licenseHeaders
+	for _, file := range files {
+		if file.Copyright || len(file.Added) > 0 || len(file.Removed) > 0 {
+			kept = append(kept, file)
+		}
+	}
+	return kept
+}
+
+// spdxChanges lists the SPDX identifier changes of headers as "path: old → new", with
+// "none" for a tag added or removed. A tag removed and added unchanged, e.g. when a header
+// moves, is no change.
+func spdxChanges(headers []licenseHeaders) []string {
+	var changes []string
+	for _, h := range headers {
+		removed, added := strings.Join(h.Removed, ", "), strings.Join(h.Added, ", ")
+		if removed == added {
+			continue
+		}
+		if removed == "" {
+			removed = "none"
+		}
+		if added == "" {
+			added = "none"
+		}
+		changes = append(changes, fmt.Sprintf("%s: %s → %s", h.Path, removed, added))
+	}
+	sort.Strings(changes)
+	return changes
+}
+
+// applyLicenseCheck flags auditData as a license change when paths include a license file or
+// headers change a copyright or SPDX line, records the SPDX changes read from the diff, and
+// asks the model to describe the licensing impact.
+func applyLicenseCheck(paths []string, headers []licenseHeaders, extras promptExtras, auditData *CommitAuditData) promptExtras {
+	seen := make(map[string]bool)
+	var flagged []string
+	for _, p := range paths {
+		if isLicenseFile(p) && !seen[p] {
+			seen[p] = true
+			flagged = append(flagged, p)
+		}
+	}
+	for _, h := range headers {
+		if !seen[h.Path] {
+			seen[h.Path] = true
+			flagged = append(flagged, h.Path)
+		}
+	}
+	if len(flagged) == 0 {
+		return extras
+	}
+	sort.Strings(flagged)
+	auditData.LicenseChange = true
+	auditData.LicensePaths = flagged
+	auditData.SPDXChanges = spdxChanges(headers)
+	instruction := "This commit changes licensing material (license, copying or NOTICE files, copyright or SPDX headers): " + strings.Join(flagged, ", ") + ". Describe its licensing impact explicitly: which license, copyright holder or notice changes, for which files, and whether the terms under which the code may be used or distributed change."
+	if len(auditData.SPDXChanges) > 0 {
+		instruction += " The SPDX identifiers change as follows: " + strings.Join(auditData.SPDXChanges, "; ") + "."
+	}
+	extras.Instructions = append(extras.Instructions, instruction)
+	return extras
+}
+
+// formatLicenseChangeSection lists the license changes among entries for the top of the
+// report, or returns "" when there are none.
+func formatLicenseChangeSection(entries []CommitAuditData) string {
+	var changes []CommitAuditData
+	for _, entry := range entries {
+		if entry.LicenseChange {
+			changes = append(changes, entry)
+		}
+	}
+	if len(changes) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString(msg("section.license_changes", msgCount("count.commits", len(changes))) + "\n")
+	for _, entry := range changes {
+		summary, _, _ := strings.Cut(strings.TrimSpace(entry.Summary), "\n")
+		fmt.Fprintf(&sb, "%s %s [%s] %s\n", shortHash(entry.Hash), localDate(entry.Date), strings.Join(entry.LicensePaths, ", "), summary)
+		for _, change := range entry.SPDXChanges {
+			sb.WriteString("    " + msg("entry.spdx", change) + "\n")
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIsLicenseFile(t *testing.T) {
+	for p, want := range map[string]bool{
+		"LICENSE":                       true,
+		"LICENSE.md":                    true,
+		"licence-MIT":                   true,
+		"vendor/lib/COPYING.LESSER":     true,
+		"NOTICE":                        true,
+		"LICENSES/Apache-2.0.txt":       true,
+		"third_party/licenses/x/README": true,
+		"src/license.go":                false,
+		"notice_test.py":                false,
+		"docs/licensing.md":             false,
+		"src/main.go":                   false,
+		"README":                        false,
+	} {
+		if got := isLicenseFile(p); got != want {
+			t.Errorf("isLicenseFile(%q) = %v, want %v", p, got, want)
+		}
+	}
+}
+
+func TestCopyrightLine(t *testing.T) {
+	for _, line := range []string{
+		"// Copyright 2024 The Authors",
+		"# Copyright (c) Example Corp.",
+		" * Copyright © Ada Lovelace",
+		"/* Copyright 1999-2024 Example */",
+		"-- Copyright 2020 Example",
+		"<!-- Copyright 2021 Example -->",
+		"(c) 2019 Example",
+		"Copyright 2024 Example",
+	} {
+		if !copyrightLine.MatchString(line) {
+			t.Errorf("%q is not a copyright line", line)
+		}
+	}
+	// The word in ordinary code, strings and prose is not a header.
+	for _, line := range []string{
+		`	return errors.New("copyright notice missing")`,
+		`log.Printf("Copyright %d %s", year, holder)`,
+		"// check the copyright field before saving",
+		"// Copyright holders are listed in AUTHORS.",
+		"copyrightYear := 2024",
+		`msg := "(c) 2024"`,
+		"The copyright of this document belongs to its authors.",
+	} {
+		if copyrightLine.MatchString(line) {
+			t.Errorf("%q is taken for a copyright line", line)
+		}
+	}
+}
+
+func TestSPDXLine(t *testing.T) {
+	for line, want := range map[string]string{
+		"// SPDX-License-Identifier: MIT":                    "MIT",
+		"# SPDX-License-Identifier: Apache-2.0 OR MIT":       "Apache-2.0 OR MIT",
+		"/* SPDX-License-Identifier: GPL-2.0-only */":        "GPL-2.0-only",
+		"<!-- SPDX-License-Identifier: CC-BY-4.0 -->":        "CC-BY-4.0",
+		"(* SPDX-License-Identifier: BSD-3-Clause *)":        "BSD-3-Clause",
+		"SPDX-License-Identifier:   LGPL-2.1-or-later   ":    "LGPL-2.1-or-later",
+		"// SPDX-License-Identifier: (MIT AND Apache-2.0)  ": "(MIT AND Apache-2.0)",
+	} {
+		m := spdxLine.FindStringSubmatch(line)
+		if m == nil || m[1] != want {
+			t.Errorf("spdxLine(%q) = %q, want %q", line, m, want)
+		}
+	}
+	if m := spdxLine.FindStringSubmatch("// SPDX-License-Identifier:"); m != nil {
+		t.Errorf("an empty tag matched: %q", m)
+	}
+}
+
+func TestScanLicenseHeaders(t *testing.T) {
+	patch := `diff --git a/src/a.go b/src/a.go
+--- a/src/a.go
++++ b/src/a.go
+@@ -1,2 +1,2 @@
+-// SPDX-License-Identifier: MIT
+-// Copyright 2023 Example
++// SPDX-License-Identifier: Apache-2.0
++// Copyright 2024 Example
+diff --git a/src/b.go b/src/b.go
+--- a/src/b.go
++++ b/src/b.go
+@@ -10 +10 @@ func check() error {
+-	return nil
++	return errors.New("copyright notice missing")
+diff --git a/src/c.go b/src/c.go
+--- a/src/c.go
++++ b/src/c.go
+@@ -1 +1,2 @@
++// Copyright 2024 Example
+ package src
+diff --git a/src/moved.go b/src/moved.go
+--- a/src/moved.go
++++ b/src/moved.go
+@@ -1 +0,0 @@
+-// SPDX-License-Identifier: MIT
+@@ -3,0 +3 @@
++// SPDX-License-Identifier: MIT
+`
+	got := scanLicenseHeaders(patch)
+	want := []licenseHeaders{
+		{Path: "src/a.go", Copyright: true, Removed: []string{"MIT"}, Added: []string{"Apache-2.0"}},
+		{Path: "src/c.go", Copyright: true},
+		{Path: "src/moved.go", Removed: []string{"MIT"}, Added: []string{"MIT"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("scanLicenseHeaders = %+v, want %+v", got, want)
+	}
+	// A header that is only context is no change.
+	context := "diff --git a/x.go b/x.go\n--- a/x.go\n+++ b/x.go\n@@ -1,3 +1,3 @@\n // Copyright 2024 Example\n // SPDX-License-Identifier: MIT\n-var a = 1\n+var a = 2\n"
+	if got := scanLicenseHeaders(context); got != nil {
+		t.Errorf("context headers: %+v", got)
+	}
+}
+
+func TestSPDXChanges(t *testing.T) {
+	got := spdxChanges([]licenseHeaders{
+		{Path: "z.go", Removed: []string{"MIT"}, Added: []string{"Apache-2.0"}},
+		{Path: "moved.go", Removed: []string{"MIT"}, Added: []string{"MIT"}},
+		{Path: "new.go", Added: []string{"MIT"}},
+		{Path: "gone.go", Removed: []string{"GPL-2.0-only"}},
+		{Path: "header.go", Copyright: true},
+	})
+	want := []string{"gone.go: GPL-2.0-only → none", "new.go: none → MIT", "z.go: MIT → Apache-2.0"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("spdxChanges = %q, want %q", got, want)
+	}
+}
+
+func TestApplyLicenseCheck(t *testing.T) {
+	var data CommitAuditData
+	extras := applyLicenseCheck([]string{"src/main.go", "README"}, nil, promptExtras{}, &data)
+	if data.LicenseChange || len(extras.Instructions) != 0 {
+		t.Errorf("an ordinary commit was flagged: %+v", data)
+	}
+
+	headers := []licenseHeaders{{Path: "src/a.go", Copyright: true, Removed: []string{"MIT"}, Added: []string{"Apache-2.0"}}}
+	extras = applyLicenseCheck([]string{"src/a.go", "NOTICE", "src/main.go"}, headers, promptExtras{}, &data)
+	if !data.LicenseChange || !reflect.DeepEqual(data.LicensePaths, []string{"NOTICE", "src/a.go"}) || !reflect.DeepEqual(data.SPDXChanges, []string{"src/a.go: MIT → Apache-2.0"}) {
+		t.Errorf("flagged entry %+v", data)
+	}
+	if len(extras.Instructions) != 1 || !strings.Contains(extras.Instructions[0], ": NOTICE, src/a.go. Describe its licensing impact explicitly") ||
+		!strings.HasSuffix(extras.Instructions[0], " The SPDX identifiers change as follows: src/a.go: MIT → Apache-2.0.") {
+		t.Errorf("instructions %q", extras.Instructions)
+	}
+}
+
+func TestFormatLicenseChangeSection(t *testing.T) {
+	if got := formatLicenseChangeSection([]CommitAuditData{{Hash: "0123456789"}}); got != "" {
+		t.Errorf("section without license changes: %q", got)
+	}
+	entries := []CommitAuditData{
+		{Hash: "0123456789", Date: "2024-01-02 10:00:00 +0000", Summary: "Relicense under Apache-2.0.\n\nDetails.", LicenseChange: true,
+			LicensePaths: []string{"LICENSE", "src/a.go"}, SPDXChanges: []string{"src/a.go: MIT → Apache-2.0"}},
+		{Hash: "abcdef0123", Date: "2024-01-01 10:00:00 +0000", Summary: "Other."},
+	}
+	want := "=== License and copyright changes (1 commit) ===\n" +
+		"01234567 " + localDate("2024-01-02 10:00:00 +0000") + " [LICENSE, src/a.go] Relicense under Apache-2.0.\n" +
+		"    SPDX: src/a.go: MIT → Apache-2.0\n"
+	if got := formatLicenseChangeSection(entries); got != want {
+		t.Errorf("section:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestLicenseChangeRun(t *testing.T) {
+	repo := newFixtureRepo(t)
+	repo.commit("Initial", map[string]string{
+		"LICENSE":  "MIT License\n\nCopyright (c) 2023 Example\n\nPermission is hereby granted...\n",
+		"src/a.go": "// SPDX-License-Identifier: MIT\n// Copyright 2023 Example\n\npackage src\n",
+	})
+	header := repo.commit("Update the header", map[string]string{
+		"src/a.go": "// SPDX-License-Identifier: Apache-2.0\n// Copyright 2024 Example\n\npackage src\n",
+	})
+	relicense := repo.commit("Relicense", map[string]string{
+		"LICENSE": "Apache License\nVersion 2.0, January 2004\n\nTERMS AND CONDITIONS FOR USE...\n",
+	})
+	ordinary := repo.commit("Check for a notice", map[string]string{
+		"src/check.go": "package src\n\nimport \"errors\"\n\nfunc check() error {\n\treturn errors.New(\"copyright notice missing\")\n}\n",
+	})
+	env := newAuditEnv(t)
+
+	text := filepath.Join(env.Work, "report.txt")
+	out := env.mustRun("-repo", repo.Dir, "-commit", header, "-output", text)
+	if !strings.Contains(out, "2 commits change license, copying or NOTICE files or copyright headers") {
+		t.Errorf("output:\n%s", out)
+	}
+	content := readFile(t, text)
+	section := "=== License and copyright changes (2 commits) ===\n"
+	if idx := strings.Index(content, section); idx < 0 || idx > strings.Index(content, "Commit: ") {
+		t.Errorf("the section is not before the entries:\n%s", content)
+	}
+	for _, want := range []string{
+		shortHash(header) + " ", " [src/a.go] Summary ",
+		shortHash(relicense) + " ", " [LICENSE] Summary ",
+		"    SPDX: src/a.go: MIT → Apache-2.0\n",
+		"License change: src/a.go\n",
+		"License change: LICENSE\n",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("report lacks %q:\n%s", want, content)
+		}
+	}
+	if strings.Count(content, "License change: ") != 2 {
+		t.Errorf("report flags other commits:\n%s", content)
+	}
+	var instructed []string
+	for _, prompt := range env.Ollama.Prompts() {
+		if strings.Contains(prompt, "Describe its licensing impact explicitly") {
+			instructed = append(instructed, prompt)
+		}
+	}
+	if len(instructed) != 2 || strings.Count(strings.Join(instructed, "\n"), "The SPDX identifiers change as follows: src/a.go: MIT → Apache-2.0.") != 1 {
+		t.Errorf("%d prompts ask for the licensing impact:\n%s", len(instructed), strings.Join(instructed, "\n---\n"))
+	}
+
+	report := filepath.Join(env.Work, "report.json")
+	env.mustRun("-repo", repo.Dir, "-commit", header, "-format", "json", "-output", report)
+	var document jsonReport
+	if err := json.Unmarshal([]byte(readFile(t, report)), &document); err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range document.Commits {
+		want := entry.Hash == header || entry.Hash == relicense
+		if entry.LicenseChange != want || (entry.Hash == header) != (len(entry.SPDXChanges) == 1) {
+			t.Errorf("%s: license_change %v, spdx_changes %q", shortHash(entry.Hash), entry.LicenseChange, entry.SPDXChanges)
+		}
+	}
+
+	if _, code := env.run("-repo", repo.Dir, "-commit", ordinary, "-output", text, "-force", "-fail-on", "license_change = true"); code != 0 {
+		t.Errorf("-fail-on license_change failed on an ordinary commit: exit %d", code)
+	}
+	if out, code := env.run("-repo", repo.Dir, "-commit", header, "-output", text, "-force", "-fail-on", "license_changes >= 2"); code != exitFailOn {
+		t.Errorf("-fail-on license_changes: exit %d\n%s", code, out)
+	}
+}
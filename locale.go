@@ -0,0 +1,172 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultLocale is the locale of the report unless -locale selects another.
+const defaultLocale = "en"
+
+// dateLayoutKey is the catalog entry holding the Go time layout for dates in the report.
+// It is a layout rather than a format string and is not checked for placeholders.
+const dateLayoutKey = "date.layout"
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// formatVerb matches the fmt placeholders of a message, which a translation must keep.
+var formatVerb = regexp.MustCompile(`%(\[\d+\])?[-+# 0]*\d*(\.\d+)?[a-zA-Z]`)
+
+// messageCatalog holds the fixed strings of the report (labels, section headings) in one
+// locale. A key the locale lacks falls back to English.
+type messageCatalog struct {
+	Locale   string
+	messages map[string]string
+	english  map[string]string
+
+	mu     sync.Mutex
+	missed map[string]bool
+}
+
+// reportMessages is the catalog every report writer uses; -locale replaces it.
+var reportMessages = mustLoadCatalog(defaultLocale)
+
+// mustLoadCatalog loads an embedded locale, which cannot fail for the locales gitaudit ships.
+func mustLoadCatalog(locale string) *messageCatalog {
+	catalog, err := loadCatalog(locale, "")
+	if err != nil {
+		panic(err)
+	}
+	return catalog
+}
+
+// readLocale reads the embedded catalog of locale, or nil when gitaudit has none.
+func readLocale(locale string) (map[string]string, error) {
+	data, err := localeFiles.ReadFile("locales/" + locale + ".json")
+	if err != nil {
+		return nil, nil
+	}
+	var messages map[string]string
+	if err := json.Unmarshal(data, &messages); err != nil {
+		return nil, fmt.Errorf("failed to parse the built-in %s messages: %w", locale, err)
+	}
+	return messages, nil
+}
+
+// availableLocales lists the embedded locales.
+func availableLocales() []string {
+	entries, _ := localeFiles.ReadDir("locales")
+	var locales []string
+	for _, entry := range entries {
+		locales = append(locales, strings.TrimSuffix(entry.Name(), ".json"))
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// loadCatalog builds the catalog of locale, with the messages of file (-locale-file), a JSON
+// object of message keys to strings, layered on top. file may add a locale gitaudit does not
+// ship. Keys English does not have, and translations that change the placeholders of the
+// English message, are errors.
+func loadCatalog(locale, file string) (*messageCatalog, error) {
+	english, err := readLocale(defaultLocale)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := readLocale(locale)
+	if err != nil {
+		return nil, err
+	}
+	if messages == nil && file == "" {
+		return nil, fmt.Errorf("unknown -locale %q: built-in locales are %s; use -locale-file to add one", locale, strings.Join(availableLocales(), ", "))
+	}
+	if messages == nil {
+		messages = make(map[string]string)
+	}
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read -locale-file: %w", err)
+		}
+		var extra map[string]string
+		if err := json.Unmarshal(data, &extra); err != nil {
+			return nil, fmt.Errorf("failed to parse -locale-file %s: expected a JSON object of message keys to strings: %w", file, err)
+		}
+		for key, text := range extra {
+			messages[key] = text
+		}
+	}
+	for key, text := range messages {
+		source, ok := english[key]
+		if !ok {
+			return nil, fmt.Errorf("the %s messages define the unknown key %q", locale, key)
+		}
+		if key == dateLayoutKey {
+			continue
+		}
+		if want, got := formatVerb.FindAllString(source, -1), formatVerb.FindAllString(text, -1); len(want) != len(got) {
+			return nil, fmt.Errorf("the %s message %q has %d placeholders, but the English message %q has %d", locale, key, len(got), source, len(want))
+		}
+	}
+	return &messageCatalog{Locale: locale, messages: messages, english: english, missed: make(map[string]bool)}, nil
+}
+
+// setReportLocale implements -locale and -locale-file.
+func setReportLocale(locale, file string) error {
+	if locale == defaultLocale && file == "" {
+		return nil
+	}
+	catalog, err := loadCatalog(locale, file)
+	if err != nil {
+		return err
+	}
+	reportMessages = catalog
+	return nil
+}
+
+// lookup returns the message for key, falling back to English with a debug line the first
+// time a key is missing.
+func (c *messageCatalog) lookup(key string) string {
+	if text, ok := c.messages[key]; ok {
+		return text
+	}
+	c.mu.Lock()
+	if !c.missed[key] {
+		c.missed[key] = true
+		debugf("locale %s has no message %q; using English", c.Locale, key)
+	}
+	c.mu.Unlock()
+	return c.english[key]
+}
+
+// msg renders the report message key with args.
+func msg(key string, args ...any) string {
+	return fmt.Sprintf(reportMessages.lookup(key), args...)
+}
+
+// msgCount renders the singular or plural form of a counted noun, e.g. "1 commit" or
+// "1,234 commits", from the key's ".one" and ".other" messages.
+func msgCount(key string, n int) string {
+	if n == 1 {
+		return reportMessages.lookup(key + ".one")
+	}
+	return fmt.Sprintf(reportMessages.lookup(key+".other"), formatCount(int64(n)))
+}
+
+// localDate renders a git date (entryDateLayout) in the layout of the report's locale. Dates
+// in another form are returned unchanged.
+func localDate(date string) string {
+	t, err := time.Parse(entryDateLayout, strings.TrimSpace(date))
+	if err != nil {
+		return date
+	}
+	return t.Format(reportMessages.lookup(dateLayoutKey))
+}
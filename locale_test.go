@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// updateGolden rewrites the golden files of the tests instead of comparing against them:
+// GITAUDIT_UPDATE_GOLDEN=1 go test -run Golden.
+var updateGolden = os.Getenv("GITAUDIT_UPDATE_GOLDEN") == "1"
+
+// checkGolden compares got with the golden file testdata/<name>, or rewrites it.
+func checkGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if updateGolden {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (GITAUDIT_UPDATE_GOLDEN=1 writes it)", err)
+	}
+	if got != string(want) {
+		gotLines, wantLines := strings.Split(got, "\n"), strings.Split(string(want), "\n")
+		for i := 0; i < len(gotLines) || i < len(wantLines); i++ {
+			var g, w string
+			if i < len(gotLines) {
+				g = gotLines[i]
+			}
+			if i < len(wantLines) {
+				w = wantLines[i]
+			}
+			if g != w {
+				t.Errorf("%s differs from line %d:\ngot:  %q\nwant: %q\n(GITAUDIT_UPDATE_GOLDEN=1 rewrites it)", path, i+1, g, w)
+				return
+			}
+		}
+	}
+}
+
+func TestBuiltinLocalesAreComplete(t *testing.T) {
+	english, _ := readLocale(defaultLocale)
+	for _, locale := range availableLocales() {
+		catalog, err := loadCatalog(locale, "")
+		if err != nil {
+			t.Errorf("%s: %v", locale, err)
+			continue
+		}
+		// Every built-in locale translates every message, so none falls back to English.
+		for key := range english {
+			if _, ok := catalog.messages[key]; !ok {
+				t.Errorf("%s lacks the message %q", locale, key)
+			}
+		}
+	}
+	if got := availableLocales(); !reflect.DeepEqual(got, []string{"en", "fr"}) {
+		t.Errorf("built-in locales %q", got)
+	}
+}
+
+func TestLoadCatalogErrors(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, messages map[string]string) string {
+		data, _ := json.Marshal(messages)
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, data, 0o644)
+		return path
+	}
+	tests := []struct {
+		locale, file, want string
+	}{
+		{"de", "", `unknown -locale "de": built-in locales are en, fr; use -locale-file to add one`},
+		{"fr", filepath.Join(dir, "missing.json"), "failed to read -locale-file"},
+		{"fr", write("list.json", nil) + "x", "failed to read -locale-file"},
+		{"fr", write("unknown.json", map[string]string{"entry.sha": "SHA : %s"}), `the fr messages define the unknown key "entry.sha"`},
+		{"de", write("placeholders.json", map[string]string{"entry.commit": "Commit"}), `the de message "entry.commit" has 0 placeholders, but the English message "Commit: %s" has 1`},
+	}
+	for _, tt := range tests {
+		if _, err := loadCatalog(tt.locale, tt.file); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("loadCatalog(%s, %s) = %v, want %q", tt.locale, filepath.Base(tt.file), err, tt.want)
+		}
+	}
+	bad := filepath.Join(dir, "bad.json")
+	os.WriteFile(bad, []byte(`["Commit"]`), 0o644)
+	if _, err := loadCatalog("fr", bad); err == nil || !strings.Contains(err.Error(), "expected a JSON object of message keys to strings") {
+		t.Errorf("loadCatalog of a JSON list = %v", err)
+	}
+}
+
+func TestCatalogFallsBackToEnglish(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "de.json")
+	os.WriteFile(file, []byte(`{"entry.commit": "Commit: %s", "entry.author": "Autor: %s", "date.layout": "02.01.2006 15:04"}`), 0o644)
+	catalog, err := loadCatalog("de", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, savedDebug := reportMessages, debugEnabled
+	defer func() { reportMessages, debugEnabled = saved, savedDebug }()
+	reportMessages, debugEnabled = catalog, true
+
+	out := captureStdout(t, func() {
+		if got := msg("entry.author", "Ada"); got != "Autor: Ada" {
+			t.Errorf("translated message = %q", got)
+		}
+		for i := 0; i < 2; i++ {
+			if got := msg("entry.date", "today"); got != "Date: today" {
+				t.Errorf("missing message = %q, want the English one", got)
+			}
+		}
+		if got := msgCount("count.commits", 2); got != "2 commits" {
+			t.Errorf("missing count = %q", got)
+		}
+		if got := localDate("2024-06-30 10:00:00 +0000"); got != "30.06.2024 10:00" {
+			t.Errorf("localDate = %q", got)
+		}
+	})
+	// -debug names each missing key once.
+	if strings.Count(out, `[debug] locale de has no message "entry.date"; using English`) != 1 || !strings.Contains(out, `"count.commits.other"`) {
+		t.Errorf("debug output:\n%s", out)
+	}
+
+	// A -locale-file layered on a built-in locale rewords only its own keys.
+	os.WriteFile(file, []byte(`{"entry.author": "Autrice : %s"}`), 0o644)
+	if catalog, err = loadCatalog("fr", file); err != nil || catalog.lookup("entry.author") != "Autrice : %s" || catalog.lookup("entry.commit") != "Commit : %s" {
+		t.Errorf("layered catalog: %v", err)
+	}
+}
+
+func TestLocalDate(t *testing.T) {
+	saved := reportMessages
+	defer func() { reportMessages = saved }()
+	for locale, want := range map[string]string{"en": "2024-06-30 10:00:00 +0200", "fr": "30/06/2024 10:00:00 +0200"} {
+		reportMessages = mustLoadCatalog(locale)
+		if got := localDate("2024-06-30 10:00:00 +0200"); got != want {
+			t.Errorf("%s: localDate = %q, want %q", locale, got, want)
+		}
+		// Dates gitaudit did not format, e.g. of stdin patches, are kept as they are.
+		if got := localDate("Sun, 30 Jun 2024 10:00:00 +0200"); got != "Sun, 30 Jun 2024 10:00:00 +0200" {
+			t.Errorf("%s: localDate of another layout = %q", locale, got)
+		}
+	}
+	reportMessages = mustLoadCatalog("fr")
+	if got := msgCount("count.commits", 1234); got != "1,234 commits" {
+		t.Errorf("fr count = %q", got)
+	}
+}
+
+// runReportInLocale audits a small history with a failed commit, a tag and the heatmap, and
+// returns the text report with the paths and ports of the test replaced, and the padding of
+// the settings, which depends on the length of those paths, collapsed.
+// settingLine matches a line of the settings header: name, value and source.
+var settingLine = regexp.MustCompile(`(?m)^(  \S+) +(.*?) +\(([^()]*)\)$`)
+
+func runReportInLocale(t *testing.T, repo *fixtureRepo, env *auditEnv, locale string) string {
+	t.Helper()
+	report := filepath.Join(env.Work, "report-"+locale+".txt")
+	env.run("-repo", repo.Dir, "-commit", "root", "-locale", locale, "-heatmap", "-author-rollup", "-max-retries", "1", "-output", report)
+	text := readFile(t, report)
+	for old, placeholder := range map[string]string{repo.Dir: "<repo>", env.Work: "<work>", env.Home: "<home>", env.Ollama.URL: "<ollama>"} {
+		text = strings.ReplaceAll(text, old, placeholder)
+	}
+	return settingLine.ReplaceAllString(text, "$1 $2 ($3)")
+}
+
+func TestLocaleGolden(t *testing.T) {
+	repo := newFixtureRepo(t)
+	repo.commits(3)
+	repo.git("tag", "-a", "v1.0", "-m", "First release")
+	failing := repo.commit("Break the build", map[string]string{"src/broken.go": "package broken\n"})
+	repo.commit("Add the docs", map[string]string{"docs/guide.md": "# Guide\n"})
+	env := newAuditEnv(t)
+	env.Ollama.Respond = func(n int, prompt string) (int, string) {
+		if strings.Contains(prompt, "src/broken.go") {
+			return 400, `{"error":"invalid request"}`
+		}
+		return 0, ""
+	}
+
+	english := runReportInLocale(t, repo, env, "en")
+	french := runReportInLocale(t, repo, env, "fr")
+	checkGolden(t, "locale/report.en.txt", english)
+	checkGolden(t, "locale/report.fr.txt", french)
+	if !strings.Contains(french, shortHash(failing)) {
+		t.Fatalf("the failed commit is not in the report:\n%s", french)
+	}
+
+	// No English label of the writers leaks into the French report: the fixed part of each
+	// message that French translates differently is absent. Summaries are the model's own
+	// text and are left out of the check.
+	englishMessages, _ := readLocale("en")
+	frenchMessages, _ := readLocale("fr")
+	var chrome []string
+	for _, line := range strings.Split(french, "\n") {
+		if !strings.HasPrefix(line, "Summary ") && !strings.Contains(line, "this commit changes the code") {
+			chrome = append(chrome, line)
+		}
+	}
+	text := strings.Join(chrome, "\n")
+	placeholder := regexp.MustCompile(`%(\[\d+\])?[-+# 0]*\d*(\.\d+)?[a-zA-Z]`)
+	for key, message := range englishMessages {
+		if key == dateLayoutKey || frenchMessages[key] == message {
+			continue
+		}
+		for _, literal := range placeholder.Split(message, -1) {
+			literal = strings.TrimSpace(literal)
+			if len(literal) >= 6 && strings.Contains(text, literal) && !strings.Contains(frenchMessages[key], literal) {
+				t.Errorf("the French report carries the English %q of %s", literal, key)
+			}
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// staleLockAge is the age after which a lock whose holder cannot be checked (it was taken on
+// another host, or the platform lacks the means) is considered abandoned.
+const staleLockAge = 24 * time.Hour
+
+// lockPollInterval is how often -wait-for-lock retries a held lock.
+const lockPollInterval = 2 * time.Second
+
+// lockInfo is written into the lockfile to identify its holder.
+type lockInfo struct {
+	PID      int       `json:"pid"`
+	Hostname string    `json:"hostname"`
+	Started  time.Time `json:"started"`
+}
+
+func (l lockInfo) String() string {
+	return fmt.Sprintf("pid %d on %s, started %s (%s ago)", l.PID, l.Hostname, l.Started.Format(time.RFC3339), formatDuration(time.Since(l.Started).Truncate(time.Second)))
+}
+
+// runLock is an advisory lock guarding a report path against concurrent runs. It is a
+// lockfile created with O_EXCL and, where the platform supports it, additionally flock'ed so
+// that a crashed holder is detected immediately.
+type runLock struct {
+	Path string
+	file *os.File
+}
+
+// heldLock is the lock of the current run, released by exitProcess.
+var heldLock *runLock
+
+// lockPathFor returns the lockfile guarding the given report path.
+func lockPathFor(outputPath string) string {
+	return outputPath + ".lock"
+}
+
+// acquireRunLock takes the lock for outputPath. When the lock is held by a live process it
+// fails with an error naming the holder, or waits for it to be released if wait is set.
+func acquireRunLock(outputPath string, wait bool) (*runLock, error) {
+	path := lockPathFor(outputPath)
+	waiting := false
+	for {
+		lock, holder, err := tryLock(path)
+		if err != nil {
+			return nil, err
+		}
+		if lock != nil {
+			heldLock = lock
+			return lock, nil
+		}
+		if !wait {
+			return nil, fmt.Errorf("another gitaudit run holds the lock %s (%s); pass -wait-for-lock to wait for it", path, holder)
+		}
+		if !waiting {
+			fmt.Printf("Waiting for the lock %s held by %s...\n", path, holder)
+			waiting = true
+		}
+		if stopping() {
+			return nil, fmt.Errorf("interrupted while waiting for the lock %s", path)
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// tryLock makes one attempt at taking the lock. It returns the lock on success, or a
+// description of the live holder when the lock is taken. Stale locks are broken on the way.
+func tryLock(path string) (*runLock, string, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err == nil {
+			return newRunLock(path, file)
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, "", fmt.Errorf("failed to create lockfile %s: %w", path, err)
+		}
+
+		holder, stale, reason := inspectLock(path)
+		if !stale {
+			return nil, holder.String(), nil
+		}
+		fmt.Printf("Warning: breaking stale lock %s (%s): %s\n", path, holder, reason)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, "", fmt.Errorf("failed to remove stale lockfile %s: %w", path, err)
+		}
+	}
+	return nil, "", fmt.Errorf("failed to acquire lockfile %s: it keeps reappearing", path)
+}
+
+// newRunLock records the current process in a freshly created lockfile.
+func newRunLock(path string, file *os.File) (*runLock, string, error) {
+	if err := flockExclusive(file); err != nil {
+		file.Close()
+		os.Remove(path)
+		return nil, "", fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+	hostname, _ := os.Hostname()
+	info := lockInfo{PID: os.Getpid(), Hostname: hostname, Started: time.Now()}
+	if err := json.NewEncoder(file).Encode(info); err != nil {
+		file.Close()
+		os.Remove(path)
+		return nil, "", fmt.Errorf("failed to write lockfile %s: %w", path, err)
+	}
+	return &runLock{Path: path, file: file}, "", nil
+}
+
+// inspectLock reads an existing lockfile and decides whether its holder is gone.
+func inspectLock(path string) (lockInfo, bool, string) {
+	var info lockInfo
+	data, err := os.ReadFile(path)
+	if err != nil {
+		// The holder may have just released it; retrying is the right call.
+		return info, true, "it could not be read"
+	}
+	if err := json.Unmarshal(data, &info); err != nil || info.PID == 0 {
+		// A holder writes its info right after creating the file; give it a moment.
+		if stat, statErr := os.Stat(path); statErr == nil && time.Since(stat.ModTime()) > lockPollInterval {
+			return info, true, "it is malformed"
+		}
+		return info, false, ""
+	}
+
+	hostname, _ := os.Hostname()
+	if canCheckHolder && info.Hostname == hostname {
+		if !processAlive(info.PID) {
+			return info, true, "the process no longer exists"
+		}
+		if probeFlock(path) {
+			return info, true, "no process holds its file lock"
+		}
+		return info, false, ""
+	}
+	if time.Since(info.Started) > staleLockAge {
+		return info, true, fmt.Sprintf("its holder cannot be checked and it is more than %s old", staleLockAge)
+	}
+	return info, false, ""
+}
+
+// Release removes the lockfile. It is safe to call more than once and on a nil lock.
+func (l *runLock) Release() {
+	if l == nil || l.file == nil {
+		return
+	}
+	os.Remove(l.Path)
+	l.file.Close()
+	l.file = nil
+}
+
+// exitProcess releases the run lock, if any, and exits. Use it instead of os.Exit once the
+// lock has been taken, since os.Exit skips deferred calls.
+func exitProcess(code int) {
+	heldLock.Release()
+	os.Exit(code)
+}
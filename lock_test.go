@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// writeLockfile writes a lockfile for outputPath as another holder would have.
+func writeLockfile(t *testing.T, outputPath string, info lockInfo) string {
+	t.Helper()
+	data, err := json.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := lockPathFor(outputPath)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// exitedPID returns the pid of a process that has already exited.
+func exitedPID(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skip("cannot run true: ", err)
+	}
+	return cmd.Process.Pid
+}
+
+func TestRunLockExcludes(t *testing.T) {
+	t.Cleanup(func() { heldLock = nil })
+	output := filepath.Join(t.TempDir(), "gitaudit.txt")
+	first, err := acquireRunLock(output, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = acquireRunLock(output, false)
+	if err == nil {
+		t.Fatal("a second lock was granted while the first is held")
+	}
+	if !strings.Contains(err.Error(), "pid ") || !strings.Contains(err.Error(), "-wait-for-lock") {
+		t.Errorf("error does not name the holder: %v", err)
+	}
+
+	first.Release()
+	first.Release()
+	if _, err := os.Stat(lockPathFor(output)); !os.IsNotExist(err) {
+		t.Errorf("lockfile left after release: %v", err)
+	}
+	second, err := acquireRunLock(output, false)
+	if err != nil {
+		t.Fatalf("lock not granted after release: %v", err)
+	}
+	second.Release()
+}
+
+func TestRunLockStale(t *testing.T) {
+	t.Cleanup(func() { heldLock = nil })
+	hostname, _ := os.Hostname()
+	type lockCase struct {
+		name  string
+		info  lockInfo
+		age   time.Duration // How long ago the lockfile was last written.
+		stale bool
+	}
+	tests := []lockCase{
+		{"other host, recent", lockInfo{PID: 1, Hostname: "elsewhere.example", Started: time.Now().Add(-time.Hour)}, 0, false},
+		{"other host, old", lockInfo{PID: 1, Hostname: "elsewhere.example", Started: time.Now().Add(-2 * staleLockAge)}, 0, true},
+		{"malformed, just written", lockInfo{}, 0, false},
+		{"malformed, old", lockInfo{}, time.Minute, true},
+	}
+	if canCheckHolder {
+		tests = append(tests,
+			lockCase{"holder exited", lockInfo{PID: exitedPID(t), Hostname: hostname, Started: time.Now()}, 0, true},
+			// The holder is alive but no longer holds the file lock, as after a crash.
+			lockCase{"file lock released", lockInfo{PID: os.Getpid(), Hostname: hostname, Started: time.Now()}, 0, true})
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := filepath.Join(t.TempDir(), "gitaudit.txt")
+			path := writeLockfile(t, output, tt.info)
+			if tt.age > 0 {
+				old := time.Now().Add(-tt.age)
+				os.Chtimes(path, old, old)
+			}
+			lock, err := acquireRunLock(output, false)
+			if tt.stale {
+				if err != nil {
+					t.Fatalf("stale lock not broken: %v", err)
+				}
+				lock.Release()
+			} else if err == nil {
+				lock.Release()
+				t.Fatal("held lock was broken")
+			}
+		})
+	}
+}
+
+func TestRunLockLiveHolder(t *testing.T) {
+	if !canCheckHolder {
+		t.Skip("holders cannot be checked on this platform")
+	}
+	t.Cleanup(func() { heldLock = nil })
+	hostname, _ := os.Hostname()
+	output := filepath.Join(t.TempDir(), "gitaudit.txt")
+	path := writeLockfile(t, output, lockInfo{PID: os.Getpid(), Hostname: hostname, Started: time.Now()})
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := flockExclusive(file); err != nil {
+		t.Fatal(err)
+	}
+	if lock, err := acquireRunLock(output, false); err == nil {
+		lock.Release()
+		t.Fatal("lock of a live holder was broken")
+	}
+}
+
+func TestRunLockTwoRuns(t *testing.T) {
+	repo := newFixtureRepo(t)
+	repo.commits(3)
+	env := newAuditEnv(t)
+	env.Ollama.Delay = 300 * time.Millisecond
+	report := filepath.Join(env.Work, "gitaudit.txt")
+	args := []string{"-repo", repo.Dir, "-commit", "root", "-output", report}
+
+	first := env.command(args...)
+	var firstOut strings.Builder
+	first.Stdout, first.Stderr = &firstOut, &firstOut
+	if err := first.Start(); err != nil {
+		t.Fatal(err)
+	}
+	waitForFile(t, lockPathFor(report))
+
+	out, code := env.run(args...)
+	if code == 0 || !strings.Contains(out, "another gitaudit run holds the lock") || !strings.Contains(out, "-wait-for-lock") {
+		t.Errorf("second run: exit %d, want a lock error:\n%s", code, out)
+	}
+
+	// A waiting run starts once the first is done, and appends nothing twice.
+	out = env.mustRun(append(args, "-wait-for-lock")...)
+	if err := first.Wait(); err != nil {
+		t.Fatalf("first run: %v\n%s", err, firstOut.String())
+	}
+	if !strings.Contains(out, "Waiting for the lock") {
+		t.Errorf("waiting run did not wait:\n%s", out)
+	}
+	if _, err := os.Stat(lockPathFor(report)); !os.IsNotExist(err) {
+		t.Errorf("lockfile left after the runs: %v", err)
+	}
+	text := readFile(t, report)
+	for _, hash := range strings.Fields(repo.git("rev-list", "HEAD")) {
+		if n := strings.Count(text, "Commit: "+hash); n != 1 {
+			t.Errorf("commit %s appears %d times in the report", hash, n)
+		}
+	}
+}
+
+func TestRunLockReleasedOnInterrupt(t *testing.T) {
+	repo := newFixtureRepo(t)
+	repo.commits(3)
+	env := newAuditEnv(t)
+	env.Ollama.Delay = 2 * time.Second
+	report := filepath.Join(env.Work, "gitaudit.txt")
+	cmd := env.command("-repo", repo.Dir, "-commit", "root", "-output", report)
+	var out strings.Builder
+	cmd.Stdout, cmd.Stderr = &out, &out
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	waitForFile(t, lockPathFor(report))
+	// Two interrupts abort the requests in flight.
+	cmd.Process.Signal(syscall.SIGINT)
+	time.Sleep(100 * time.Millisecond)
+	cmd.Process.Signal(syscall.SIGINT)
+	cmd.Wait()
+	if _, err := os.Stat(lockPathFor(report)); !os.IsNotExist(err) {
+		t.Errorf("lockfile left after an interrupted run: %v\n%s", err, out.String())
+	}
+}
+
+// waitForFile waits up to ten seconds for path to exist.
+func waitForFile(t *testing.T, path string) {
+	t.Helper()
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if _, err := os.Stat(path); err == nil {
+			return
+		}
+	}
+	t.Fatalf("%s was not created", path)
+}
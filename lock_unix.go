@@ -0,0 +1,38 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// canCheckHolder is true where a lock holder's liveness can be checked directly.
+const canCheckHolder = true
+
+// flockExclusive takes a non-blocking exclusive flock on file, held until it is closed.
+func flockExclusive(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
+
+// probeFlock reports whether the lockfile at path can be flock'ed, i.e. its holder has
+// exited without removing it. The probe lock is released immediately.
+func probeFlock(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		return false
+	}
+	syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+	return true
+}
+
+// processAlive reports whether a process with the given pid exists on this host.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
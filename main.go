@@ -1,29 +1,29 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
-	"os/exec"
 	"os/signal"
+	"path/filepath"
 	"strings"
-	"sync"
 	"syscall"
 	"time"
 )
 
-var interrupted bool = false
-var mu sync.Mutex // To protect access to `interrupted` if needed, though current usage is simple
-
 // OllamaRequest defines the structure for requests to the Ollama API.
 type OllamaRequest struct {
 	Model  string `json:"model"`
 	Prompt string `json:"prompt"`
 	Stream bool   `json:"stream"` // Set to false for a single response
+	// Options are model parameters such as the sampling seed.
+	Options map[string]any `json:"options,omitempty"`
 }
 
 // OllamaResponse defines the structure for responses from the Ollama API.
@@ -33,313 +33,2562 @@ type OllamaResponse struct {
 	CreatedAt time.Time `json:"created_at"`
 	Response  string    `json:"response"`
 	Done      bool      `json:"done"`
+	// PromptEvalCount and EvalCount are the prompt and generated token counts.
+	PromptEvalCount int `json:"prompt_eval_count"`
+	EvalCount       int `json:"eval_count"`
+	// Error is set instead of Response when the request failed.
+	Error string `json:"error"`
 	// Other fields might be present depending on the response, like context, total_duration, etc.
 }
 
 // CommitAuditData holds the Git metadata and the generated summary for a commit.
+// The JSON field names are part of the post_process_hook contract and must stay stable.
 type CommitAuditData struct {
-	Hash    string
-	Author  string
-	Date    string
-	Summary string
+	// Kind is "commit" for ordinary entries, "merge" for commits with more than one parent,
+	// "automated" for commits made by bots and release tooling, and "tag" for release tag
+	// entries (see getRangeTags).
+	Kind   string `json:"kind"`
+	Hash   string `json:"hash"`
+	Author string `json:"author"`
+	// Date is the date selected by -date-source, and is rendered as the entry's Date line.
+	// Deprecated in the JSON: read author_date or commit_date, which are always both present.
+	Date       string `json:"date"`
+	AuthorDate string `json:"author_date"`
+	CommitDate string `json:"commit_date"`
+	Summary    string `json:"summary"`
+	// AutomationRule names the automation rule that matched an "automated" entry.
+	AutomationRule string `json:"automation_rule,omitempty"`
+	// FormattingOnly is set when the commit was classified as a whitespace/reformatting
+	// change and its summary was generated from a template instead of the model.
+	FormattingOnly bool `json:"formatting_only,omitempty"`
+	// ChangeCategory is code, test-only, docs-only or mixed, read from the paths the commit
+	// touches. Merges compared with all their parents have none.
+	ChangeCategory string `json:"change_category,omitempty"`
+	// TestsAndDocs is the -tests-and-docs treatment a test-only or docs-only commit received:
+	// summarize, group or skip.
+	TestsAndDocs string `json:"tests_and_docs,omitempty"`
+	// Ref labels entries that are not ordinary commits in the range, e.g. "stash@{0}".
+	Ref string `json:"ref,omitempty"`
+	// Unreachable is set for reflog-only commits that no branch points at.
+	Unreachable bool `json:"unreachable,omitempty"`
+	// MessageOnly is set when the summary was generated from the original message and
+	// diffstat only, without the diff (see -message-only).
+	MessageOnly bool `json:"message_only,omitempty"`
+	// CitationStatus records the -cite validation result: "verified" or "unverified".
+	// It is empty when citations were not requested.
+	CitationStatus string `json:"citation_status,omitempty"`
+	// WithheldFiles counts the changed files excluded from the prompt by the never_send policy.
+	WithheldFiles int `json:"withheld_files,omitempty"`
+	// LeakMasked is set when the summary named a withheld file even after regenerating, and
+	// the names were masked (never_send_confidential).
+	LeakMasked bool `json:"leak_masked,omitempty"`
+	// UnverifiedClaims are the issue numbers, URLs, paths and identifiers the summary names
+	// that its input does not, even after regenerating; they are marked in the summary
+	// (-check-claims).
+	UnverifiedClaims []string `json:"unverified_claims,omitempty"`
+	// RetryModel is the -retry-model that produced the summary after the configured model
+	// kept failing on the commit.
+	RetryModel string `json:"retry_model,omitempty"`
+	// PolicySkipped is set when every changed file was withheld and no model call was made.
+	PolicySkipped bool `json:"policy_skipped,omitempty"`
+	// DetailLevel records which step of the degradation ladder produced the summary
+	// ("full" unless repeated length-related failures forced a smaller prompt).
+	DetailLevel string `json:"detail_level,omitempty"`
+	// Parents lists the commit's parent hashes, first parent first (range audits only).
+	Parents []string `json:"parents,omitempty"`
+	// ParentCount is the number of parents of a merge; more than two for an octopus merge,
+	// whose summary is built from its message and a diffstat against each parent.
+	ParentCount int `json:"parent_count,omitempty"`
+	// MergeGroup is the hash of the merge commit in the range that brought this commit in,
+	// empty for commits on the first-parent line.
+	MergeGroup string `json:"merge_group,omitempty"`
+	// Budget records how many bytes of each prompt component survived the context budget
+	// allocator. It is nil when context_size is not configured.
+	Budget *budgetReport `json:"budget,omitempty"`
+	// Usage is the token usage reported for the model calls that produced the summary, and
+	// CostUSD its price under the configured pricing (zero for local models).
+	Usage   *tokenUsage `json:"usage,omitempty"`
+	CostUSD float64     `json:"cost_usd,omitempty"`
+	// Controls are the audit control categories the model assigned with -controls.
+	Controls []string `json:"controls,omitempty"`
+	// Verification is the -verify-critical result of a commit that was summarized twice:
+	// "agreed", "discrepancies" or "unconfirmed". Discrepancies lists the points on which
+	// the two summaries disagreed, and VerificationUsage the tokens of the two extra calls,
+	// which Usage includes.
+	Verification      string      `json:"verification,omitempty"`
+	Discrepancies     []string    `json:"discrepancies,omitempty"`
+	VerificationUsage *tokenUsage `json:"verification_usage,omitempty"`
+	// Vulnerabilities lists the known vulnerabilities fixed by the commit's dependency
+	// changes, per OSV.dev. VulnerabilityStatus is "osv lookup failed" when they are unknown.
+	Vulnerabilities     []vulnerability `json:"vulnerabilities,omitempty"`
+	VulnerabilityStatus string          `json:"vulnerability_status,omitempty"`
+	// Dependencies lists the dependencies the commit adds, removes or changes the version of
+	// in its manifests, recorded with -dependency-digest.
+	Dependencies []dependencyChange `json:"dependencies,omitempty"`
+	// ChangeGroup lists the patch sets the entry stands for with -group-by change-id.
+	ChangeGroup *changeGroup `json:"change_group,omitempty"`
+	// PromptProfile names the prompt_overrides entry whose instructions were added to the
+	// prompt, empty when the default prompt was used.
+	PromptProfile string `json:"prompt_profile,omitempty"`
+	// ControlChange is set when the commit touches a path of the control watchlist (CI
+	// pipelines, release tooling, code ownership, gitaudit configuration), listed in
+	// ControlPaths.
+	ControlChange bool     `json:"control_change,omitempty"`
+	ControlPaths  []string `json:"control_paths,omitempty"`
+	// LicenseChange is set when the commit touches a license, copying or NOTICE file or
+	// changes a copyright or SPDX header line, in the files of LicensePaths. SPDXChanges
+	// lists the SPDX identifier changes read from the diff, "path: old → new".
+	LicenseChange bool     `json:"license_change,omitempty"`
+	LicensePaths  []string `json:"license_paths,omitempty"`
+	SPDXChanges   []string `json:"spdx_changes,omitempty"`
+	// Secrets are the secrets the commit adds, found by -secret-report. They are masked and
+	// hold no byte of the secrets themselves.
+	Secrets []secretFinding `json:"secrets,omitempty"`
+	// DateSuspect is "future" or "before_root" when Date is later than the run or earlier
+	// than the root commit, e.g. from a machine with a wrong clock. Date keeps the raw value.
+	DateSuspect string `json:"date_suspect,omitempty"`
+	// TestCheck tells whether the commit came with tests (-check-tests).
+	TestCheck *testCheck `json:"test_check,omitempty"`
+	// SuggestedReviewers are the earlier authors of the lines the commit changes, most lines
+	// first, as "Name <email>" (-suggest-reviewers).
+	SuggestedReviewers []string `json:"suggested_reviewers,omitempty"`
+	// Extras holds fields merged in from the post_process_hook's output.
+	Extras map[string]any `json:"extras,omitempty"`
+}
+
+// auditOptions carries the per-run settings that influence how each commit is audited.
+type auditOptions struct {
+	RepoPath string
+	Config   *Config
+	// FormatDetection enables the cheap whitespace-only pre-classification.
+	FormatDetection bool
+	// FormatHintThreshold is the percentage (0-100) of the original diff size below which
+	// the whitespace-ignored diff is considered borderline and a hint is added to the prompt.
+	FormatHintThreshold float64
+	// Targets holds extra information for hashes that come from the stash or reflog modes.
+	Targets map[string]auditTarget
+	// MessageOnly builds prompts from the commit message and diffstat instead of the full patch.
+	MessageOnly bool
+	// Cite asks the model to cite hunks for its claims and validates those citations.
+	Cite bool
+	// CheckClaims checks the issue numbers, URLs, paths and identifiers of each summary
+	// against the prompt it was generated from.
+	CheckClaims bool
+	// StrictPolicy turns any never_send match into a fatal error instead of excising the files.
+	StrictPolicy bool
+	// Ladder is the sequence of degradation steps applied to commits that keep failing with
+	// length-related errors, and DegradeAfter is how many such failures trigger the next step.
+	Ladder       []string
+	DegradeAfter int
+	// Hook enriches every audited entry via the post_process_hook; nil when none is configured.
+	Hook *hookRunner
+	// Budget trims prompts to the model's context window; nil when context_size is not configured.
+	Budget *budgetAllocator
+	// Generator is the configured LLM provider, wrapped to total its token usage.
+	Generator *meteredGenerator
+	// Topology is the merge structure of the audited range; nil in the stash and reflog modes.
+	Topology *mergeTopology
+	// Automation recognizes bot and release commits; nil with -llm-bots.
+	Automation []automationRule
+	// OSV looks up the vulnerabilities fixed by dependency bumps; nil with -no-osv.
+	OSV *osvClient
+	// DependencyDigest records each commit's dependency changes for the report's
+	// "Dependency changes" section (-dependency-digest).
+	DependencyDigest bool
+	// Pathspec limits the patches and stats sent to the model to a subtree of the repository;
+	// nil audits whole commits.
+	Pathspec []string
+	// Cache keeps model info and OSV answers between runs; nil when no cache directory is usable.
+	Cache *cacheStore
+	// DateSource selects the date of each entry: "author" or "commit".
+	DateSource string
+	// FirstParent describes merges by their diff against the first parent (-first-parent), as
+	// the commits they brought in are not audited on their own.
+	FirstParent bool
+	// Controls assigns audit control categories to each summary; nil without -controls.
+	Controls *controlMapper
+	// Glossary explains internal terms in the prompts and fixes their spelling in the
+	// summaries; nil when none is configured.
+	Glossary *glossary
+	// Verify selects the commits summarized twice and reconciled; nil without -verify-critical.
+	Verify *criticalPredicate
+	// Privacy selects the commit metadata sent to the model along with the diff.
+	Privacy promptPrivacy
+	// DateCheck flags implausible entry dates; nil when the root commit could not be read.
+	DateCheck *dateCheck
+	// Reviewers suggests reviewers from blame (-suggest-reviewers); nil when off.
+	Reviewers *reviewerPolicy
+	// Categories classifies commits as code, test-only, docs-only or mixed by their paths.
+	Categories changeCategories
+	// TestsAndDocs is how test-only and docs-only commits are treated (-tests-and-docs); ""
+	// audits them like any other commit.
+	TestsAndDocs string
+	// CheckTests annotates each commit with whether it came with tests (-check-tests).
+	CheckTests bool
+}
+
+// promptFlags are the command-line flags that shape how each commit's summary is generated.
+// They are shared by the range audit and the explain subcommand.
+type promptFlags struct {
+	OllamaEndpoint       string
+	OllamaModel          string
+	RequireExplicitModel bool
+	NoFormatDetection    bool
+	FormatHintThreshold  float64
+	MessageOnly          bool
+	Cite                 bool
+	CheckClaims          bool
+	StrictPolicy         bool
+	DegradeAfter         int
+	TrimOrder            string
+	LLMBots              bool
+	Record               string
+	Replay               string
+	ReplayMissing        string
+	NoOSV                bool
+	DateSource           string
+	ExplainFlags         bool
+	Controls             bool
+	VerifyCritical       bool
+	Profile              string
+	Locale               string
+	LocaleFile           string
+}
+
+// registerPromptFlags defines the prompt flags (and -debug and -explain-flags) on fs.
+func registerPromptFlags(fs *flag.FlagSet) *promptFlags {
+	p := &promptFlags{}
+	fs.StringVar(&p.OllamaEndpoint, "ollama-endpoint", "", "Ollama generate endpoint, overriding ollama_endpoint of the config file; with -ollama-model, no config file is needed")
+	fs.StringVar(&p.OllamaModel, "ollama-model", "", "Ollama model, overriding ollama_model of the config file; with -ollama-endpoint, no config file is needed")
+	fs.BoolVar(&p.RequireExplicitModel, "require-explicit-model", false, "Fail when no Ollama model is configured instead of choosing an installed one")
+	fs.BoolVar(&p.NoFormatDetection, "no-format-detection", false, "Disable the whitespace-only pre-classification and send every commit to the model")
+	fs.Float64Var(&p.FormatHintThreshold, "format-hint-threshold", 20, "Percentage of the original diff below which a whitespace-ignored diff is treated as mostly reformatting and the prompt is hinted accordingly")
+	fs.BoolVar(&p.MessageOnly, "message-only", false, "Generate summaries from the original commit message, diffstat and file list only, without sending diffs")
+	fs.BoolVar(&p.StrictPolicy, "strict-policy", false, "Fail the run when a commit touches a never_send path instead of withholding those files")
+	fs.IntVar(&p.DegradeAfter, "degrade-after", 2, "Number of consecutive length-related failures (timeouts, context errors, empty responses) after which a commit's next attempt uses a smaller prompt; 0 disables degradation")
+	fs.BoolVar(&p.Cite, "cite", false, "Ask the model to cite the file and hunk header behind each major claim, and verify the citations against the patch")
+	fs.BoolVar(&p.CheckClaims, "check-claims", false, "Check the issue numbers, URLs, file paths and identifiers each summary mentions against the patch, message and context sent; regenerate once, then mark the unsupported ones [unverified]")
+	fs.StringVar(&p.TrimOrder, "trim-order", "context,patch", "Order in which prompt components are trimmed when context_size is configured and the prompt exceeds it: \"context,patch\" or \"patch,context\"")
+	fs.BoolVar(&p.LLMBots, "llm-bots", false, "Send commits made by bots and release tooling to the model instead of summarizing them from a template")
+	fs.StringVar(&p.Record, "record", "", "Save every model request and response as a JSON file named after the prompt digest in this directory")
+	fs.StringVar(&p.Replay, "replay", "", "Answer prompts from the recordings in this directory (see -record) instead of calling the model")
+	fs.StringVar(&p.ReplayMissing, "replay-missing", replayMissingError, "With -replay, what to do about a prompt without a recording: \"error\" stops the run, \"placeholder\" uses a placeholder summary")
+	fs.BoolVar(&p.NoOSV, "no-osv", false, "Do not look up the vulnerabilities fixed by dependency bumps on OSV.dev (for air-gapped machines)")
+	fs.BoolVar(&p.Controls, "controls", false, "Ask the model to assign audit control categories (control_taxonomy, or a default SOC 2 style set) to each commit, and add a control matrix to the report")
+	fs.BoolVar(&p.VerifyCritical, "verify-critical", false, "Summarize the commits selected by verify_critical twice with different seeds, have the model reconcile the two, and record any discrepancies (roughly triples their token cost)")
+	fs.StringVar(&p.DateSource, "date-source", dateSourceAuthor, "Date shown on each entry and compared by -since/-until: \"author\" (when the change was written) or \"commit\" (when it was last committed, e.g. by a rebase). The post_process_hook's JSON \"date\" field follows this choice and is deprecated; use \"author_date\" and \"commit_date\"")
+	fs.BoolVar(&compressRequests, "compress-requests", false, "Gzip model request bodies (Content-Encoding: gzip); falls back to uncompressed requests if the server rejects them")
+	fs.StringVar(&p.Profile, "profile", "", "Apply the named profile from the config file's \"profiles\"; flags given on the command line override it")
+	fs.StringVar(&p.Locale, "locale", defaultLocale, "Language of the report's fixed labels and headings (\"en\" or \"fr\"); dates follow its format")
+	fs.StringVar(&p.LocaleFile, "locale-file", "", "JSON file of report messages layered on -locale, to adjust a built-in locale or add one")
+	fs.BoolVar(&p.ExplainFlags, "explain-flags", false, "Print every flag, config file key and environment variable with its effective value and source, then exit")
+	fs.BoolVar(&laxConfig, "lax-config", false, "Ignore unknown keys in the config file instead of failing, e.g. when an older gitaudit reads a config file written for a newer one")
+	fs.BoolVar(&debugEnabled, "debug", false, "Print diagnostic details such as per-commit prompt budget decisions")
+	return p
+}
+
+// auditOptions combines the prompt flags with the loaded config into the per-run options.
+func (p *promptFlags) auditOptions(repoPath string, config *Config) (*auditOptions, error) {
+	if p.DateSource != dateSourceAuthor && p.DateSource != dateSourceCommit {
+		return nil, fmt.Errorf("-date-source must be %q or %q, got %q", dateSourceAuthor, dateSourceCommit, p.DateSource)
+	}
+	if err := setReportLocale(p.Locale, p.LocaleFile); err != nil {
+		return nil, err
+	}
+	opts := &auditOptions{
+		RepoPath: repoPath,
+		Privacy:  config.privacy(),
+		Config:   config,
+		// The pre-classification reads two full diffs per commit, which would defeat the point of -message-only.
+		FormatDetection:     !p.NoFormatDetection && !p.MessageOnly,
+		FormatHintThreshold: p.FormatHintThreshold,
+		MessageOnly:         p.MessageOnly,
+		Cite:                p.Cite && !p.MessageOnly,
+		CheckClaims:         p.CheckClaims,
+		StrictPolicy:        p.StrictPolicy,
+		Ladder:              config.DegradationLadder,
+		DegradeAfter:        p.DegradeAfter,
+		DateSource:          p.DateSource,
+		Categories:          newChangeCategories(config),
+	}
+	if opts.Ladder == nil {
+		opts.Ladder = defaultDegradationLadder
+	}
+	if config.GitTimeout != "" {
+		gitTimeout, _ = time.ParseDuration(config.GitTimeout) // Validated by loadConfig.
+	}
+	var err error
+	opts.Hook, err = newHookRunner(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	opts.Generator, err = p.newGenerator(config)
+	if err != nil {
+		return nil, err
+	}
+	if !p.LLMBots {
+		opts.Automation, err = newAutomationRules(config.AutomationRules)
+		if err != nil {
+			return nil, fmt.Errorf("invalid configuration: %w", err)
+		}
+	}
+	if p.Controls {
+		opts.Controls, err = newControlMapper(config.ControlTaxonomy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid configuration: %w", err)
+		}
+	}
+	opts.Glossary, err = newGlossary(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	if p.VerifyCritical {
+		if err := config.VerifyCritical.validate(opts.Controls); err != nil {
+			return nil, fmt.Errorf("invalid configuration: %w", err)
+		}
+		opts.Verify = config.VerifyCritical
+	}
+	if config.ContextSize > 0 {
+		opts.Budget, err = newBudgetAllocator(config.ContextSize, config, p.TrimOrder)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return opts, nil
+}
+
+// discoverModelInfo looks up the Ollama model build for the run header and, with
+// auto_context_size, sizes the prompt budget from the model's context window. Failing to
+// discover the model is never fatal. The budget then gets the tokenizer configured for the
+// model.
+func (p *promptFlags) discoverModelInfo(opts *auditOptions) error {
+	if err := p.discoverContextSize(opts); err != nil || opts.Budget == nil {
+		return err
+	}
+	t, err := newTokenizer(opts.Config, opts.Cache)
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	opts.Budget.Tokenizer = t
+	fmt.Printf("Tokenizer: %s\n", t.Name())
+	return nil
+}
+
+// discoverContextSize prints the Ollama model build and applies auto_context_size.
+func (p *promptFlags) discoverContextSize(opts *auditOptions) error {
+	config := opts.Config
+	if (config.Provider != "" && config.Provider != providerOllama) || p.Replay != "" {
+		return nil
+	}
+	ttl := defaultModelInfoTTL
+	if config.ModelInfoTTL != "" {
+		ttl, _ = time.ParseDuration(config.ModelInfoTTL) // Validated by loadConfig.
+	}
+	info, err := getModelInfo(config.OllamaEndpoint, config.OllamaModel, ttl, opts.Cache)
+	if err != nil {
+		fmt.Printf("Warning: failed to look up model info: %v\n", err)
+	} else {
+		fmt.Printf("Model Build: %s\n", info)
+	}
+
+	if !config.AutoContextSize || opts.Budget != nil {
+		return nil
+	}
+	size := info.ContextLength
+	if size == 0 {
+		size = config.DefaultContextSize
+		if size == 0 {
+			size = defaultContextSize
+		}
+		fmt.Printf("Warning: the context size of model %s is unknown; assuming %s (default_context_size).\n", config.OllamaModel, formatTokens(size))
+	}
+	opts.Budget, err = newBudgetAllocator(size, config, p.TrimOrder)
+	return err
+}
+
+// rangeFlags are the command-line flags of a range audit, the default command.
+type rangeFlags struct {
+	Repo               string
+	URL                string
+	Commit             string
+	Stashes            bool
+	Reflog             string
+	NoRepoWrites       bool
+	Output             string
+	Out                outputTargetsFlag
+	Force              bool
+	IncludeTags        bool
+	TagContext         bool
+	AuthorRollup       bool
+	Heatmap            bool
+	HeatmapDepth       int
+	HeatmapTop         int
+	Timeline           bool
+	TimelineBucket     string
+	WaitForLock        bool
+	Budget             float64
+	RetryModel         string
+	RetryPasses        int
+	MaxRetries         int
+	BreakerFailures    int
+	ProbeInterval      time.Duration
+	Concurrency        int
+	Since              string
+	Until              string
+	Notify             string
+	NotifyStall        time.Duration
+	NoImplicitPathspec bool
+	CheckpointEvery    string
+	Resume             bool
+	PauseWindows       pauseWindowsFlag
+	ExitOnPause        bool
+	NoMerges           bool
+	FirstParent        bool
+	Shard              string
+	SplitBy            string
+	DependencyDigest   bool
+	FailOn             failOnFlag
+	GroupBy            string
+	Format             string
+	SuggestReviewers   bool
+	CheckTests         bool
+	SecretReport       bool
+	TestsAndDocs       string
+	BlameMaxFiles      int
+	BlameMaxLines      int
+}
+
+// registerRangeFlags defines the flags of a range audit on fs; the prompt flags are
+// registered separately.
+func registerRangeFlags(fs *flag.FlagSet) *rangeFlags {
+	r := &rangeFlags{}
+	fs.StringVar(&r.Repo, "repo", ".", "Path to the Git repository")
+	fs.StringVar(&r.Commit, "commit", "", "The oldest commit ID to audit to")
+	fs.StringVar(&r.URL, "url", "", "Audit the range of a GitHub or GitLab compare URL (.../compare/base...head), or the commit of a commit URL, in the -repo clone of that project (no -commit needed)")
+	fs.BoolVar(&r.Stashes, "stashes", false, "Audit the stash entries instead of a commit range (no -commit needed)")
+	fs.StringVar(&r.Reflog, "reflog", "", "Audit commits reachable only from the reflog of this ref, not from any branch (no -commit needed)")
+	fs.BoolVar(&r.NoRepoWrites, "no-repo-writes", false, "Refuse to write any file inside the repository working tree (default: on when -repo is not \".\")")
+	fs.BoolVar(&r.IncludeTags, "include-tags", false, "Also add a marker line for every lightweight tag pointing into the audited range; annotated tags always get an entry with their message")
+	fs.BoolVar(&r.TagContext, "tag-context", false, "Ask the model to relate each annotated tag in the audited range to the commits since the previous tag")
+	fs.BoolVar(&r.AuthorRollup, "author-rollup", false, "Append an Authors section with each author's commit count, lines touched and a model-generated synthesis of their work")
+	fs.BoolVar(&r.Heatmap, "heatmap", false, "Append a Change heatmap section: the lines added and deleted per directory over the range, drawn as bars")
+	fs.IntVar(&r.HeatmapDepth, "heatmap-depth", 2, "With -heatmap, how many path components name a directory")
+	fs.IntVar(&r.HeatmapTop, "heatmap-top", 10, "With -heatmap, how many directories to show; the rest are summed as one \"other\" row")
+	fs.BoolVar(&r.Timeline, "timeline", false, "Append an Activity timeline section: commits and changed lines per day, week or month of the range as sparklines, with tags and flagged commits marked; -format json gets the series")
+	fs.StringVar(&r.TimelineBucket, "timeline-bucket", timelineAuto, "With -timeline, the bucket size: \"day\", \"week\", \"month\" or \"auto\" (days up to two months, weeks up to two years, months beyond)")
+	fs.StringVar(&r.Output, "output", "", "Path of the report, its directories created as needed (default: gitaudit.txt in the current directory); an existing file is not replaced without -force")
+	fs.StringVar(&r.Output, "o", "", "Shorthand for -output")
+	fs.Var(&r.Out, "out", "Write the report as format=path, e.g. json=audit.json, in place of -format and -output; repeat to write several formats from one run. Formats are text, json, mbox and patchdir")
+	fs.BoolVar(&r.Force, "force", false, "With -output or -out, replace an existing report")
+	fs.BoolVar(&r.WaitForLock, "wait-for-lock", false, "Wait for another gitaudit run writing the same report to finish instead of failing immediately")
+	fs.StringVar(&r.RetryModel, "retry-model", "", "Model of the configured provider that takes over the commits still failing after -retry-passes retry passes, starting one step down the degradation ladder; its entries are marked as degraded retries")
+	fs.IntVar(&r.RetryPasses, "retry-passes", 0, "Number of retry passes after which failing commits are handed to -retry-model, or left pending without it (default: unlimited, or 3 with -retry-model)")
+	fs.IntVar(&r.Concurrency, "concurrency", 1, "Number of commits audited at the same time; entries keep the order of the range. Raise it only as far as the provider serves requests in parallel (e.g. OLLAMA_NUM_PARALLEL)")
+	fs.IntVar(&r.MaxRetries, "max-retries", defaultMaxRetries, "Number of retries of a failing commit, with each model, before it is given up and listed as failed; 0 retries until it succeeds. The cool-down between attempts doubles with each retry")
+	fs.IntVar(&r.BreakerFailures, "breaker-failures", defaultBreakerFailures, "Number of consecutive connection failures after which requests to a model server fail at once, sending their commits to the retry queue, until a probe finds it answering again; 0 disables the circuit breaker")
+	fs.DurationVar(&r.ProbeInterval, "probe-interval", defaultProbeInterval, "How often a model server whose circuit breaker is open is probed")
+	fs.Float64Var(&r.Budget, "budget", 0, "Stop the run, writing the commits audited so far, once its projected cost in US dollars exceeds this amount (needs a pricing entry for the model)")
+	fs.StringVar(&r.Since, "since", "", "Only audit the commits of the range dated at or after this date (anything git log --since accepts), per -date-source; -commit defaults to root")
+	fs.StringVar(&r.Until, "until", "", "Only audit the commits of the range dated at or before this date (anything git log --until accepts), per -date-source")
+	fs.StringVar(&r.Notify, "notify", "", "Send a desktop notification when the run finishes, stops on a permanent error or stalls: \"desktop\" (notify-send on Linux, osascript on macOS)")
+	fs.DurationVar(&r.NotifyStall, "notify-stall", 15*time.Minute, "With -notify, how long no commit may complete before a stall notification; 0 disables it")
+	fs.BoolVar(&r.NoImplicitPathspec, "no-implicit-pathspec", false, "When -repo is a subdirectory of the repository, audit whole commits of the repository instead of scoping the audit to that subdirectory")
+	fs.StringVar(&r.CheckpointEvery, "checkpoint-every", "", "Rewrite the report with the entries completed so far every N audited commits (e.g. 25) or every interval (e.g. 30m), marked as partial")
+	fs.BoolVar(&r.Resume, "resume", false, "Continue the audit an interrupted or crashed run left in the state file next to the report: the commits it audited are not summarized again, and their entries are carried into the report")
+	fs.Var(&r.PauseWindows, "pause-window", "Start no commit during this daily window, \"HH:MM-HH:MM\" optionally followed by a time zone (e.g. \"02:55-03:30 Europe/Berlin\"); the run sleeps until it ends. Repeatable")
+	fs.BoolVar(&r.ExitOnPause, "exit-on-pause", false, "With -pause-window, stop the run at a window, writing the commits audited so far, instead of sleeping through it")
+	fs.BoolVar(&r.NoMerges, "no-merges", false, "Skip merge commits, including octopus merges; the commits they brought in are still audited on their own")
+	fs.BoolVar(&r.FirstParent, "first-parent", false, "Audit only the first-parent line of HEAD; each merge on it is described by its diff against the first parent, which covers the commits it brought in")
+	fs.StringVar(&r.Shard, "shard", "", "Audit only shard i of N of the range, e.g. 2/3, assigning commits by a hash of their id, and write a shard report plus a manifest for gitaudit merge-shards")
+	fs.BoolVar(&r.DependencyDigest, "dependency-digest", false, "Append a Dependency changes section listing the net version change, addition or removal of each dependency over the range, with the commits involved")
+	fs.StringVar(&r.GroupBy, "group-by", "", "\"change-id\": summarize the commits of the range that share a Gerrit Change-Id trailer (patch sets of one change) as one entry")
+	fs.Var(&r.FailOn, "fail-on", "Exit with status 3 when this condition holds after the run, e.g. \"failed > 0\" or \"kind = merge AND withheld_files > 0\", and record the outcome in the report header. Repeatable")
+	fs.StringVar(&r.Format, "format", formatText, "Report format: \"text\" (gitaudit.txt), \"json\" (gitaudit.json, the entries with the run's metadata), \"mbox\" (gitaudit.mbox) or \"patchdir\" (numbered files in gitaudit-patches/), the last two being patch emails for git am whose message is the generated summary")
+	fs.BoolVar(&r.CheckTests, "check-tests", false, "Note whether each commit changes tests (test_paths), its test lines per source line changed, and the exported Go functions and types it adds without test changes; read from the diff, nothing is built or run")
+	fs.BoolVar(&r.SuggestReviewers, "suggest-reviewers", false, "Blame the lines each commit changes and name up to 3 of their earlier authors, other than the commit's, as suggested reviewers")
+	fs.StringVar(&r.TestsAndDocs, "tests-and-docs", "", "How to treat commits touching only tests or only documentation: \"summarize\" (templated summary, no model call), \"group\" (full entries in a section of their own) or \"skip\" (listed, not audited)")
+	fs.BoolVar(&r.SecretReport, "secret-report", false, "Scan every commit's patch for committed secrets, whatever the model sees, and append a Secret findings section with masked excerpts and whether a later commit removed each")
+	fs.IntVar(&r.BlameMaxFiles, "blame-max-files", 10, "With -suggest-reviewers, blame at most this many files per commit")
+	fs.IntVar(&r.BlameMaxLines, "blame-max-lines", 400, "With -suggest-reviewers, blame at most this many lines per commit")
+	fs.StringVar(&r.SplitBy, "split-by", "", "Split the report into one file per \"month\" or \"week\" of entry dates, or per \"count:N\" entries, plus an index file listing them")
+	return r
 }
 
 func main() {
-	repoPath := flag.String("repo", ".", "Path to the Git repository")
-	commitID := flag.String("commit", "", "The oldest commit ID to audit to")
+	runStarted := time.Now()
+	if len(os.Args) > 1 && os.Args[1] == "explain" {
+		runExplain(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "file" {
+		runFile(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "cache" {
+		runCache(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "profiles" {
+		runProfiles(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "patch" {
+		runPatch(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "eval" {
+		runEval(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "merge-shards" {
+		runMergeShards(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		runVerify(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		runServe(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "completion" {
+		runCompletion(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "man" {
+		runMan(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "__complete" {
+		runComplete(os.Args[2:])
+		return
+	}
+
+	flag.Usage = func() { lookupCommand("").printUsage(flag.CommandLine) }
+	audit := registerRangeFlags(flag.CommandLine)
+	prompt := registerPromptFlags(flag.CommandLine)
 
 	flag.Parse()
+	if err := applyProfile(flag.CommandLine, prompt.Profile); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	prompt.setConfigOverrides()
+	validateFlags(flag.CommandLine, lookupCommand("").Rules)
+	if prompt.ExplainFlags {
+		explainFlags(flag.CommandLine)
+		return
+	}
 
-	if *commitID == "" {
+	recoveryMode := audit.Stashes || audit.Reflog != ""
+	if !isFlagSet("no-repo-writes") {
+		audit.NoRepoWrites = audit.Repo != "."
+	}
+	if audit.NoRepoWrites {
+		// Keep git from taking optional locks or refreshing the index in the audited repository.
+		os.Setenv("GIT_OPTIONAL_LOCKS", "0")
+	}
+	dateFiltered := audit.Since != "" || audit.Until != ""
+	if audit.Commit == "" && dateFiltered {
+		audit.Commit = rootRevision
+	}
+	var compare *compareTarget
+	if audit.URL != "" {
+		var err error
+		if compare, err = parseCompareURL(audit.URL); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if audit.Commit == "" && !recoveryMode && compare == nil {
 		fmt.Println("Error: commit ID is required.")
 		flag.Usage()
 		os.Exit(1)
 	}
+	if audit.Notify != "" && audit.Notify != notifyDesktop {
+		fmt.Printf("Error: invalid -notify value %q: expected %q\n", audit.Notify, notifyDesktop)
+		os.Exit(1)
+	}
+	checkpoints, err := parseCheckpointPolicy(audit.CheckpointEvery)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	split, err := parseSplitPolicy(audit.SplitBy)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	switch audit.Format {
+	case formatText:
+	case formatMbox, formatPatchDir, formatJSON:
+		// The patch emails or the JSON document are written once, at the end of the run, in
+		// place of the text report.
+		for _, name := range []string{"split-by", "shard", "checkpoint-every", "author-rollup", "heatmap"} {
+			if isFlagSet(name) {
+				fmt.Printf("Error: -%s writes a text report and cannot be combined with -format %s\n", name, audit.Format)
+				os.Exit(1)
+			}
+		}
+	default:
+		fmt.Printf("Error: invalid -format value %q: expected %q, %q, %q or %q\n", audit.Format, formatText, formatJSON, formatMbox, formatPatchDir)
+		flag.Usage()
+		os.Exit(1)
+	}
+	textTarget := len(audit.Out) == 0
+	for _, t := range audit.Out {
+		textTarget = textTarget || t.Format == formatText
+	}
+	timelineTarget := len(audit.Out) == 0 && (audit.Format == formatText || audit.Format == formatJSON)
+	for _, t := range audit.Out {
+		timelineTarget = timelineTarget || t.Format == formatText || t.Format == formatJSON
+	}
+	if audit.Timeline && !timelineTarget {
+		fmt.Println("Warning: -timeline has no effect without a text or json output; patch emails get no timeline.")
+	}
+	if !textTarget {
+		for _, name := range []string{"author-rollup", "heatmap"} {
+			if isFlagSet(name) {
+				fmt.Printf("Error: -%s writes a text report and needs a text -out target\n", name)
+				os.Exit(1)
+			}
+		}
+	}
+	switch audit.TestsAndDocs {
+	case "", testsAndDocsSummarize, testsAndDocsGroup, testsAndDocsSkip:
+	default:
+		fmt.Printf("Error: invalid -tests-and-docs value %q: expected %q, %q or %q\n", audit.TestsAndDocs, testsAndDocsSummarize, testsAndDocsGroup, testsAndDocsSkip)
+		os.Exit(1)
+	}
+	if audit.HeatmapDepth < 1 || audit.HeatmapTop < 1 {
+		fmt.Println("Error: -heatmap-depth and -heatmap-top must be at least 1")
+		os.Exit(1)
+	}
+	if !validTimelineBucket(audit.TimelineBucket) {
+		fmt.Printf("Error: invalid -timeline-bucket value %q: expected %q, %q, %q or %q\n", audit.TimelineBucket, timelineAuto, timelineDay, timelineWeek, timelineMonth)
+		os.Exit(1)
+	}
+	if audit.GroupBy != "" && audit.GroupBy != groupByChangeID {
+		fmt.Printf("Error: invalid -group-by value %q: expected %q\n", audit.GroupBy, groupByChangeID)
+		os.Exit(1)
+	}
+	shard, err := parseShardSpec(audit.Shard)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
 
-	fmt.Printf("Repository Path: %s\n", *repoPath)
-	fmt.Printf("Commit ID: %s\n", *commitID)
+	if activeProfile != nil {
+		fmt.Printf("Profile: %s\n", activeProfile)
+	}
+	fmt.Printf("Repository Path: %s\n", audit.Repo)
+	if compare != nil {
+		fmt.Printf("URL: %s\n", compare.URL)
+	} else if audit.Commit != "" {
+		fmt.Printf("Commit ID: %s\n", audit.Commit)
+	}
+	if audit.Stashes {
+		fmt.Println("Mode: stash entries")
+	}
+	if audit.Reflog != "" {
+		fmt.Printf("Mode: reflog-only commits of %s\n", audit.Reflog)
+	}
 
 	config, err := loadConfig()
 	if err != nil {
 		fmt.Printf("Error loading configuration: %v\n", err)
 		os.Exit(1)
 	}
+	if err := prompt.selectModel(config); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
 
-	fmt.Printf("Ollama Endpoint: %s\n", config.OllamaEndpoint)
-	fmt.Printf("Ollama Model: %s\n", config.OllamaModel)
+	switch config.Provider {
+	case "", providerOllama:
+		fmt.Printf("Ollama Endpoint: %s\n", config.OllamaEndpoint)
+		if autoSelectedModel != "" {
+			fmt.Printf("Ollama Model: %s (auto-selected)\n", config.OllamaModel)
+		} else {
+			fmt.Printf("Ollama Model: %s\n", config.OllamaModel)
+		}
+	case providerAnthropic:
+		fmt.Printf("Provider: Anthropic (model %s)\n", config.Anthropic.Model)
+	case providerGemini:
+		fmt.Printf("Provider: Gemini (model %s)\n", config.Gemini.Model)
+	}
+	if prompt.MessageOnly {
+		fmt.Println("Prompt Source: commit messages and stats only (-message-only, diffs are not sent)")
+	}
+	fmt.Printf("Prompt Metadata: %s\n", config.privacy())
 
-	// Setup signal handling for Ctrl+C
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		<-sigChan
-		fmt.Println("\nCtrl+C received. Shutting down gracefully...")
-		mu.Lock()
-		interrupted = true
-		mu.Unlock()
-	}()
-
-	commitHashes, err := getCommitHashes(*repoPath, *commitID)
+	opts, err := prompt.auditOptions(audit.Repo, config)
 	if err != nil {
-		fmt.Printf("Error getting commit hashes: %v\n", err)
+		fmt.Printf("Error: %v\n", err)
 		os.Exit(1)
 	}
+	opts.FirstParent = audit.FirstParent
+	opts.TestsAndDocs = audit.TestsAndDocs
+	if audit.SuggestReviewers {
+		if audit.BlameMaxFiles <= 0 || audit.BlameMaxLines <= 0 {
+			fmt.Println("Error: -blame-max-files and -blame-max-lines must be positive.")
+			os.Exit(1)
+		}
+		opts.Reviewers = &reviewerPolicy{MaxFiles: audit.BlameMaxFiles, MaxLines: audit.BlameMaxLines}
+	}
+	opts.DependencyDigest = audit.DependencyDigest && !recoveryMode
+	opts.CheckTests = audit.CheckTests
 
-	fmt.Println("Commit hashes to process:")
-	for _, hash := range commitHashes {
-		fmt.Println(hash)
+	if audit.Budget < 0 {
+		fmt.Println("Error: -budget must not be negative.")
+		os.Exit(1)
+	}
+	if audit.Budget > 0 && opts.Generator.Price == nil {
+		if config.Provider == "" || config.Provider == providerOllama {
+			fmt.Println("Warning: -budget has no effect for Ollama; local models cost nothing.")
+		} else {
+			fmt.Printf("Error: -budget requires a 'pricing' entry for the configured %s model.\n", opts.Generator.Name())
+			os.Exit(1)
+		}
 	}
 
-	var allAuditedCommits []CommitAuditData // Slice to store all successfully audited commits
-	var retryQueueCommits []string          // Slice to store commit hashes that need retrying
+	if audit.RetryPasses < 0 {
+		fmt.Println("Error: -retry-passes must not be negative.")
+		os.Exit(1)
+	}
+	if audit.MaxRetries < 0 {
+		fmt.Println("Error: -max-retries must not be negative.")
+		os.Exit(1)
+	}
+	if audit.BreakerFailures < 0 {
+		fmt.Println("Error: -breaker-failures must not be negative.")
+		os.Exit(1)
+	}
+	if audit.ProbeInterval <= 0 {
+		fmt.Println("Error: -probe-interval must be positive.")
+		os.Exit(1)
+	}
+	breakerFailures, probeInterval = audit.BreakerFailures, audit.ProbeInterval
+	if audit.Concurrency < 1 {
+		fmt.Println("Error: -concurrency must be at least 1.")
+		os.Exit(1)
+	}
+	var retryGenerator *meteredGenerator
+	if audit.RetryModel != "" {
+		retryGenerator, err = prompt.newGenerator(withModel(config, audit.RetryModel))
+		if err != nil {
+			fmt.Printf("Error: -retry-model: %v\n", err)
+			os.Exit(1)
+		}
+		if audit.Budget > 0 && retryGenerator.Price == nil && opts.Generator.Price != nil {
+			fmt.Printf("Error: -budget requires a 'pricing' entry for the -retry-model %s model.\n", audit.RetryModel)
+			os.Exit(1)
+		}
+		retryGenerator.Previous = opts.Generator
+		fmt.Printf("Retry Model: %s\n", audit.RetryModel)
+	}
 
-	// Initial processing loop
-	fmt.Println("--- Initial Processing Pass ---")
-	for _, commitHash := range commitHashes {
-		mu.Lock()
-		if interrupted {
-			mu.Unlock()
-			fmt.Println("Interrupted during initial processing pass.")
-			// Add remaining initial commits to retryQueue so they are reported as pending
-			// Find current commitHash in commitHashes and add the rest
-			for i, h := range commitHashes {
-				if h == commitHash {
-					retryQueueCommits = append(retryQueueCommits, commitHashes[i:]...)
-					break
+	// Ctrl+C stops the run in stages; see interruptHandler.
+	sigChan := make(chan os.Signal, 3)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go watchSignals(sigChan)
+
+	var commitHashes []string
+	var shardRange []string // The whole range of a -shard run, for its manifest.
+	var head string
+	var boundaryHash string
+	if recoveryMode {
+		opts.Targets = make(map[string]auditTarget)
+		if audit.Stashes {
+			hashes, targets, err := getStashTargets(audit.Repo)
+			if err != nil {
+				fmt.Printf("Error listing stash entries: %v\n", err)
+				os.Exit(1)
+			}
+			if len(hashes) == 0 {
+				fmt.Println("No stash entries found.")
+			}
+			commitHashes = append(commitHashes, hashes...)
+			for hash, target := range targets {
+				opts.Targets[hash] = target
+			}
+		}
+		if audit.Reflog != "" {
+			hashes, targets, err := getReflogTargets(audit.Repo, audit.Reflog)
+			if err != nil {
+				fmt.Printf("Error listing reflog commits: %v\n", err)
+				os.Exit(1)
+			}
+			if len(hashes) == 0 {
+				fmt.Printf("No commits reachable only from the reflog of %s were found.\n", audit.Reflog)
+			}
+			for _, hash := range hashes {
+				if _, ok := opts.Targets[hash]; ok {
+					continue // Already queued as a stash entry.
 				}
+				commitHashes = append(commitHashes, hash)
+				opts.Targets[hash] = targets[hash]
 			}
-			break // Exit initial processing loop
 		}
-		mu.Unlock()
-
-		fmt.Printf("Processing commit: %s\n", commitHash)
-		patch, err := getPatchForCommit(*repoPath, commitHash)
+	} else {
+		// Every later git command uses the pinned hash, so commits made during a long run
+		// neither enter the range nor shift it.
+		var err error
+		var boundary boundaryCommit
+		if compare != nil {
+			// The range is the URL's, whatever HEAD is; its oldest commit stands in for
+			// -commit in the console output and shard manifests.
+			head, commitHashes, err = compare.resolve(audit.Repo, audit.FirstParent)
+			if err == nil {
+				boundary, err = resolveBoundary(audit.Repo, head, commitHashes[len(commitHashes)-1])
+			}
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Auditing %s: %s back to %s\n", compare, shortHash(head), boundary)
+		} else {
+			head, err = resolveCommit(audit.Repo, "HEAD")
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			boundary, err = resolveBoundary(audit.Repo, head, audit.Commit)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Auditing %s back to %s\n", shortHash(head), boundary)
+			commitHashes, err = getCommitHashes(runCtx, audit.Repo, head, boundary.Hash, audit.FirstParent)
+			if err != nil {
+				fmt.Printf("Error getting commit hashes: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		prefix, err := getRepoPrefix(audit.Repo)
 		if err != nil {
-			errMsg := fmt.Sprintf("Error generating patch for commit %s: %v. Adding to retry queue.", commitHash, err)
-			fmt.Println(errMsg)
-			retryQueueCommits = append(retryQueueCommits, commitHash)
-			continue
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		if prefix != "" && !audit.NoImplicitPathspec {
+			opts.Pathspec = subtreePathspec(prefix)
+			inRange := len(commitHashes)
+			commitHashes, err = filterByPathspec(audit.Repo, head, commitHashes, opts.Pathspec)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Scope: %s only, because -repo is a subdirectory of the repository (-no-implicit-pathspec audits whole commits); %d of %d commits touch it\n", prefix, len(commitHashes), inRange)
+		}
+		if audit.NoMerges {
+			inRange := len(commitHashes)
+			commitHashes, err = dropMerges(audit.Repo, commitHashes)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Skipping %d merge commits (-no-merges)\n", inRange-len(commitHashes))
+		}
+		if dateFiltered {
+			window, err := parseDateRange(audit.Repo, audit.Since, audit.Until)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			inRange := len(commitHashes)
+			commitHashes, err = filterByDate(audit.Repo, commitHashes, window, opts.DateSource)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("%d of %d commits fall within -since/-until by %s date\n", len(commitHashes), inRange, opts.DateSource)
+		}
+		if audit.GroupBy == groupByChangeID {
+			ids, err := getChangeIDs(audit.Repo, commitHashes)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			var groups map[string]changeGroup
+			inRange := len(commitHashes)
+			commitHashes, groups = groupByChange(commitHashes, ids)
+			opts.Targets = make(map[string]auditTarget)
+			for hash, group := range groups {
+				opts.Targets[hash] = auditTarget{Group: &group}
+			}
+			fmt.Printf("Grouped %d commits into %d changes by Change-Id (-group-by change-id)\n", inRange-len(commitHashes)+len(groups), len(groups))
+		}
+		opts.Topology, err = getMergeTopology(audit.Repo, commitHashes)
+		if err != nil {
+			fmt.Printf("Error reading merge topology: %v\n", err)
+			os.Exit(1)
+		}
+		boundaryHash = boundary.Hash
+		if shard.Count > 0 {
+			// The topology covers the whole range, so entries are grouped under their merges
+			// alike in every shard.
+			shardRange = commitHashes
+			commitHashes = shard.filter(commitHashes)
+			fmt.Printf("Shard %s: %d of %d commits in the range\n", shard, len(commitHashes), len(shardRange))
 		}
+	}
 
-		prompt := fmt.Sprintf(`Given the following Git patch, please generate a highly detailed and descriptive Git commit message. The message should cover:
-1. A summary of the changes.
-2. The reasoning behind the changes (why they were made).
-3. Any problems that were encountered (if apparent from the patch or commit message).
-4. The intended purpose or goal of the commit.
+	dateTip := head
+	if dateTip == "" {
+		dateTip = "HEAD"
+	}
+	if opts.DateCheck, err = newDateCheck(audit.Repo, dateTip); err != nil {
+		fmt.Printf("Warning: commit dates are not checked for clock skew: %v\n", err)
+	}
 
-Do not include the "Patch:" prefix or any introductory phrases like "Here's a commit message:". Output only the commit message itself.
+	fmt.Println("Commit hashes to process:")
+	for _, hash := range commitHashes {
+		fmt.Println(hash)
+	}
+	prefetchTime := prefetchPartialClone(audit.Repo, commitHashes, opts.Pathspec, audit.NoRepoWrites)
 
-Patch:
-%s`, patch)
+	repoRoot, err := getRepoTopLevel(runCtx, audit.Repo)
+	if err != nil {
+		fmt.Printf("Error resolving repository root: %v\n", err)
+		os.Exit(1)
+	}
+	opts.Cache = openCacheStore(repoRoot, audit.NoRepoWrites)
+	if err := prompt.discoverModelInfo(opts); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := prompt.prepareRecordDir(repoRoot, audit.NoRepoWrites); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	opts.OSV = prompt.newOSVClient(config, opts.Cache)
+	if opts.Budget != nil {
+		fmt.Printf("Prompt Budget: %s (instructions %g%%, context %g%%, patch %g%%; trim order %s)\n",
+			formatTokens(opts.Budget.ContextTokens), opts.Budget.Split.Instructions, opts.Budget.Split.Context, opts.Budget.Split.Patch, strings.Join(opts.Budget.TrimOrder, ","))
+	}
+	var outputFileName string
+	var targets []outputTarget
+	if len(audit.Out) > 0 {
+		if targets, err = resolveOutputTargets(audit.Out, repoRoot, audit.NoRepoWrites, audit.Force || audit.Resume); err != nil {
+			fmt.Printf("Error choosing output path: %v\n", err)
+			os.Exit(1)
+		}
+		// The first target's lock guards the run, as the report's does without -out.
+		outputFileName = targets[0].Path
+	} else {
+		if outputFileName, err = outputPath(audit.Output, repoRoot, audit.NoRepoWrites); err != nil {
+			fmt.Printf("Error choosing output path: %v\n", err)
+			os.Exit(1)
+		}
+		if shard.Count > 0 {
+			outputFileName = shard.reportPath(outputFileName)
+		}
+		target := outputTarget{Format: audit.Format, Path: outputFileName}
+		if audit.Format != formatText {
+			target.Path = archivePath(audit.Format, outputFileName)
+		}
+		targets = []outputTarget{target}
+		if audit.Output != "" && !audit.Force && !audit.Resume {
+			// The default report is replaced by every run, as it always has been; a report
+			// named with -output is only replaced on request, or by the run resuming it.
+			existing := target.Path
+			if audit.Format == formatText && split.Mode != "" {
+				existing = splitIndexPath(outputFileName)
+			}
+			if err := checkNoClobber(existing); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+	}
+	lock, err := acquireRunLock(outputFileName, audit.WaitForLock)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	// Deferred calls also run when a panic unwinds main; os.Exit paths use exitProcess.
+	defer lock.Release()
 
-		generatedMessage, err := callOllama(config.OllamaEndpoint, config.OllamaModel, prompt)
-		if err != nil {
-			errMsg := fmt.Sprintf("Error calling Ollama for commit %s: %v. Adding to retry queue.", commitHash, err)
-			fmt.Println(errMsg)
-			retryQueueCommits = append(retryQueueCommits, commitHash)
-			continue
+	var allAuditedCommits []CommitAuditData // Slice to store all successfully audited commits
+	var retryQueueCommits []string          // Slice to store commit hashes that need retrying
+	circuitOpen := make(map[string]bool)    // Endpoints whose open circuit breaker skipped a commit
+	var outageWait time.Duration            // Time spent waiting for those endpoints to answer again
+	var unauditableCommits []string         // Commits whose objects a partial clone cannot fetch
+	var givenUpCommits []string             // Commits that failed -max-retries retries
+	commitStates := make(map[string]*commitState)
+	for _, hash := range commitHashes {
+		commitStates[hash] = &commitState{}
+	}
+	var fatalErr error // A permanent provider error (e.g. an invalid API key) stops the run.
+	settingsHeader := reportSettingsHeader(flag.CommandLine, config)
+	reportHeader := settingsHeader
+	if compare != nil {
+		reportHeader += "\n" + msg("header.url", compare.URL)
+	}
+	if head != "" {
+		reportHeader += "\n" + msg("header.tip", head)
+	}
+	if config.RetentionClass != "" {
+		reportHeader += "\n" + retentionRangeHeader + retentionRange(commitHashes)
+	}
+	if len(audit.Out) > 0 {
+		reportHeader += "\n" + msg("header.outputs", strings.Join(formatOutputTargets(targets), ", "))
+	}
+	if shard.Count > 0 {
+		reportHeader = msg("header.shard", shard.Index, shard.Count, len(commitHashes), len(shardRange)) + "\n\n" + reportHeader
+	}
+	// A range audit keeps its state for -resume; the commits an earlier run audited are
+	// carried over rather than summarized again.
+	var stateFile *runState
+	toAudit := commitHashes
+	if !recoveryMode {
+		statePath := statePathFor(outputFileName)
+		if audit.NoRepoWrites {
+			if err := checkOutsideRepo(statePath, repoRoot, "state file"); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				exitProcess(1)
+			}
+		}
+		parameters := shardParameters(flag.CommandLine, config)
+		var carried []CommitAuditData
+		if _, err := os.Stat(statePath); audit.Resume && err != nil {
+			fmt.Printf("No state file %s to resume; auditing the whole range.\n", statePath)
+		} else if audit.Resume {
+			resumed, err := loadRunState(statePath)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				exitProcess(1)
+			}
+			warnings, err := resumed.check(statePath, repoRoot, boundaryHash, parameters)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				exitProcess(1)
+			}
+			for _, warning := range warnings {
+				fmt.Printf("Warning: resuming with other settings: %s\n", warning)
+			}
+			carried, toAudit = resumed.Carry(commitHashes)
+			allAuditedCommits = append(allAuditedCommits, carried...)
+			started := resumed.Header.Started.Local().Format(time.RFC3339)
+			fmt.Printf("Resuming the run started %s: %d of %d commits were audited then; %d left\n", started, len(carried), len(commitHashes), len(toAudit))
+			reportHeader += "\n" + msg("header.resumed", len(carried), started)
+		}
+		header := runStateHeader{
+			Version: runStateVersion, Repo: repoRoot, Head: head, Boundary: boundaryHash, Range: commitHashes,
+			Started: time.Now(), Parameters: parameters,
+		}
+		if stateFile, err = openRunState(statePath, header, carried, audit.Resume); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			exitProcess(1)
+		}
+	}
+	checkpoint := newCheckpointer(checkpoints, outputFileName, split, len(commitHashes), reportHeader)
+	if audit.NoRepoWrites {
+		if err := checkOutsideRepo(journalPathFor(outputFileName), repoRoot, "journal"); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			exitProcess(1)
+		}
+	}
+	journal, err := openReportJournal(outputFileName, reportHeader)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		exitProcess(1)
+	}
+	// writeOutputs writes entries to every target, each on its own, so that one failing
+	// writer leaves the others complete. The text targets get the author rollup, the heatmap,
+	// the timeline and the retention trailer when final. It returns the targets written and those that
+	// failed.
+	writeOutputs := func(entries []CommitAuditData, final bool) (completed, failed []string) {
+		header := reportHeader
+		// A partial write may run on the signal goroutine (interrupts.SetFlush), so only a final
+		// one reads the run's retry state.
+		partial := true
+		if final {
+			partial = stopping() || len(retryQueueCommits) > 0
+		}
+		if !final {
+			header = msg("header.partial", len(entries), len(commitHashes), time.Now().Format(time.RFC3339)) + "\n\n" + reportHeader
+		}
+		verb := "Wrote"
+		if final {
+			verb = "\nSuccessfully wrote"
+		}
+		var timeline *activityTimeline
+		if final && audit.Timeline {
+			var err error
+			if timeline, err = buildTimeline(opts, entries, head, audit.TimelineBucket); err != nil {
+				fmt.Printf("Warning: failed to build the activity timeline: %v\n", err)
+			}
+		}
+		var reports []string
+		for _, t := range targets {
+			switch t.Format {
+			case formatJSON:
+				document := jsonReport{
+					Repo: repoRoot, Head: head, Boundary: boundaryHash, Range: commitHashes,
+					Provider: opts.Generator.Name(), Model: configuredModel(config), ModelAutoSelected: autoSelectedModel != "",
+					Partial: partial, Timeline: timeline, Commits: entries,
+				}
+				if final {
+					document.Failed = failedCommits(commitHashes, givenUpCommits, commitStates)
+				}
+				if opts.DependencyDigest {
+					document.Dependencies = foldDependencyChanges(entries)
+				}
+				if _, usage := opts.Generator.Usage(); usage.PromptTokens+usage.OutputTokens > 0 {
+					document.Usage = &usage
+					document.CostUSD = opts.Generator.Spent()
+				}
+				if len(audit.Out) > 0 {
+					document.Outputs = formatOutputTargets(targets)
+				}
+				if err := writeJSONReport(t.Path, document); err != nil {
+					fmt.Printf("Error: %v\n", err)
+					failed = append(failed, t.String())
+					continue
+				}
+				fmt.Printf("%s %d audited commit entries to %s\n", verb, len(entries), t.Path)
+			case formatMbox, formatPatchDir:
+				generatedBy := fmt.Sprintf("gitaudit (%s %s)", opts.Generator.Name(), configuredModel(config))
+				written, skipped, err := writeArchive(t.Format, t.Path, opts, entries, commitHashes, generatedBy)
+				if err != nil {
+					fmt.Printf("Error writing patch emails to %s: %v\n", t.Path, err)
+					failed = append(failed, t.String())
+					continue
+				}
+				fmt.Printf("%s %d audited commits as patch emails to %s\n", verb, written, t.Path)
+				if final && skipped > 0 {
+					if opts.TestsAndDocs == testsAndDocsSkip {
+						fmt.Printf("%d tag, merge and skipped test or documentation entries were left out.\n", skipped)
+					} else {
+						fmt.Printf("%d tag and merge entries have no patch and were left out, as git format-patch leaves them out.\n", skipped)
+					}
+				}
+			default:
+				written, err := writeReport(t.Path, entries, header, split)
+				if err != nil {
+					fmt.Printf("Error writing audited commit data to file %s: %v\n", written, err)
+					failed = append(failed, t.String())
+					continue
+				}
+				fmt.Printf("%s %d audited commit entries to %s\n", verb, len(entries), written)
+				reports = append(reports, written)
+			}
+			completed = append(completed, t.String())
+		}
+		if final && len(reports) > 0 {
+			if audit.AuthorRollup {
+				writeAuthorRollup(opts, reports, entries)
+			}
+			if audit.Heatmap {
+				writeHeatmap(opts, reports, entries, audit.HeatmapDepth, audit.HeatmapTop)
+			}
+			if timeline != nil {
+				writeTimeline(reports, timeline)
+			}
+			if len(givenUpCommits) > 0 {
+				writeFailedSection(reports, failedCommits(commitHashes, givenUpCommits, commitStates))
+			}
+		}
+		if config.RetentionClass != "" {
+			// Last, so that the digest covers the appended sections.
+			for _, t := range targets {
+				if t.Format == formatText && containsString(completed, t.String()) {
+					sealReports(reportFiles(t.Path, entries, split), config, retentionRange(commitHashes))
+				}
+			}
+		}
+		return completed, failed
+	}
+	interrupts.SetFlush(func(entries []CommitAuditData) {
+		checkpoint.Wait()
+		writeOutputs(entries, false)
+	})
+	progress, err := newProgressReporter(audit.Notify, filepath.Base(repoRoot), len(commitHashes), audit.NotifyStall)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		exitProcess(1)
+	}
+	var limit *spendLimit
+	if audit.Budget > 0 && opts.Generator.Price != nil {
+		limit = &spendLimit{LimitUSD: audit.Budget, Total: len(commitHashes)}
+		fmt.Printf("Spending Limit: %s\n", formatUSD(limit.LimitUSD))
+	}
+	var pauser *pauseScheduler
+	if len(audit.PauseWindows) > 0 {
+		pauser = &pauseScheduler{Windows: audit.PauseWindows, ExitOnPause: audit.ExitOnPause, Progress: progress}
+		action := "sleeping through them"
+		if audit.ExitOnPause {
+			action = "stopping at the first"
+		}
+		windows := make([]string, len(audit.PauseWindows))
+		for i, w := range audit.PauseWindows {
+			windows[i] = w.String()
+		}
+		fmt.Printf("Pause Windows: %s; %s\n", strings.Join(windows, ", "), action)
+	}
+
+	// Initial processing loop
+	fmt.Println("--- Initial Processing Pass ---")
+	pool := &auditPool{Workers: audit.Concurrency}
+	pool.Start = func(commitHash string) bool {
+		pauser.Wait()
+		if stopping() {
+			return false
+		}
+		fmt.Printf("Processing commit: %s\n", commitHash)
+		return true
+	}
+	pool.Audit = func(commitHash string) (CommitAuditData, error) {
+		return auditCommit(requestCtx, opts, commitHash, detailFull)
+	}
+	pool.Done = func(commitHash string, auditData CommitAuditData, err error) {
+		if err != nil && aborted() {
+			fmt.Printf("Aborted commit %s; it is pending.\n", commitHash)
+			retryQueueCommits = append(retryQueueCommits, commitHash)
+			return
+		}
+		if endpoint := circuitOpenEndpoint(err); endpoint != "" {
+			// Not an attempt: the commit was not sent, so it keeps its detail level and backoff.
+			fmt.Printf("Skipped commit %s: %v. Adding to retry queue.\n", commitHash, err)
+			circuitOpen[endpoint] = true
+			retryQueueCommits = append(retryQueueCommits, commitHash)
+			progress.Update(len(allAuditedCommits), fatalErr)
+			return
+		}
+		if err != nil {
+			exitOnPolicyViolation(err)
+			stopOnPermanentError(err, &fatalErr)
+			if isMissingObjects(err) {
+				fmt.Printf("Error processing commit %s: %s; not retrying: %v\n", commitHash, missingObjectsReason, err)
+				unauditableCommits = append(unauditableCommits, commitHash)
+				commitStates[commitHash].LastError = newPermanentGitError(commitHash, err)
+				stateFile.Failed(commitHash, commitStates[commitHash].LastError)
+				progress.Update(len(allAuditedCommits), fatalErr)
+				return
+			}
+			fmt.Printf("Error processing commit %s: %v. Adding to retry queue.\n", commitHash, err)
+			commitStates[commitHash].recordFailure(commitHash, err, opts.Ladder, opts.DegradeAfter)
+			commitStates[commitHash].recordRetry(err, 0, time.Now())
+			retryQueueCommits = append(retryQueueCommits, commitHash)
+			stopOverBudget(limit, opts.Generator, len(allAuditedCommits), &fatalErr)
+			progress.Update(len(allAuditedCommits), fatalErr)
+			return
+		}
+
+		fmt.Printf("Successfully processed commit %s (%s)\n", commitHash, describeAuditSource(auditData))
+		allAuditedCommits = append(allAuditedCommits, auditData)
+		journal.Append(auditData)
+		stateFile.Audited(auditData)
+		checkpoint.Record(allAuditedCommits)
+		interrupts.Track(allAuditedCommits)
+		stopOverBudget(limit, opts.Generator, len(allAuditedCommits), &fatalErr)
+		progress.Update(len(allAuditedCommits), fatalErr)
+	}
+	if unstarted := pool.Run(toAudit); len(unstarted) > 0 {
+		fmt.Println("Interrupted during initial processing pass.")
+		// The remaining initial commits are reported as pending.
+		retryQueueCommits = append(retryQueueCommits, unstarted...)
+	}
+
+	// Retry loop
+	if len(retryQueueCommits) > 0 && !stopping() {
+		fmt.Println("\n--- Starting Retry Processing ---")
+	}
+	// -retry-passes bounds the retry passes of each model. The -retry-model takes over the
+	// commits still failing after the configured model's passes; each of its requests is
+	// retried by the provider like any other.
+	passLimit := audit.RetryPasses
+	if passLimit == 0 && retryGenerator != nil {
+		passLimit = defaultRetryModelPasses
+	}
+	handedOff, passBase := false, 0
+	for pass := 1; len(retryQueueCommits) > 0; pass++ {
+		if stopping() {
+			fmt.Println("Interrupted during retry processing.")
+			break // Exit retry loop
+		}
+
+		if passLimit > 0 && pass-passBase > passLimit {
+			if retryGenerator == nil || handedOff {
+				limitErr := &retryLimitError{Passes: pass - 1}
+				for _, hash := range retryQueueCommits {
+					state := commitStates[hash]
+					limitErr.Commits = append(limitErr.Commits, &retriesExhaustedError{Hash: hash, Attempts: state.Failures, LastErr: state.LastError})
+				}
+				fatalErr = limitErr
+				stopRun(limitErr)
+				break
+			}
+			fmt.Printf("\n--- Handing %d commits over to the retry model %s ---\n", len(retryQueueCommits), audit.RetryModel)
+			opts.Generator = retryGenerator
+			for _, hash := range retryQueueCommits {
+				commitStates[hash].handOff(opts.Ladder)
+			}
+			handedOff, passBase = true, pass-1
+			// The retry model may be served elsewhere; its own breaker stops it if not.
+			clear(circuitOpen)
+		}
+
+		if len(circuitOpen) > 0 {
+			outageWait += waitForCircuits(circuitOpen)
+			clear(circuitOpen)
+			if stopping() {
+				continue
+			}
+		}
+
+		due, deferred := planRetryPass(retryQueueCommits, commitStates, pass, time.Now())
+		if len(due) == 0 {
+			// Every queued commit is still cooling down; wait for the first to be due.
+			waitUntil(nextRetryAt(deferred, commitStates))
+			pass--
+			continue
+		}
+		if len(deferred) > 0 {
+			fmt.Printf("Commits in retry queue: %d (%d cooling down until a later pass)\n", len(retryQueueCommits), len(deferred))
+		} else {
+			fmt.Printf("Commits in retry queue: %d\n", len(retryQueueCommits))
+		}
+		currentFailures := 0 // To detect if all attempts in a retry pass fail
+		skipped := 0         // Commits not sent because a circuit breaker was open
+
+		var nextRetryQueue []string
+		pool.Start = func(commitHash string) bool {
+			pauser.Wait()
+			if stopping() {
+				return false
+			}
+			state := commitStates[commitHash]
+			if level := state.detailLevel(opts.Ladder); level != detailFull {
+				fmt.Printf("Retrying commit: %s (attempt %d, detail level: %s)\n", commitHash, state.Failures+1, level)
+			} else {
+				fmt.Printf("Retrying commit: %s (attempt %d)\n", commitHash, state.Failures+1)
+			}
+			return true
+		}
+		pool.Audit = func(commitHash string) (CommitAuditData, error) {
+			// The state of a commit changes only once its outcome is handled.
+			return auditCommit(requestCtx, opts, commitHash, commitStates[commitHash].detailLevel(opts.Ladder))
+		}
+		pool.Done = func(commitHash string, auditData CommitAuditData, err error) {
+			state := commitStates[commitHash]
+			if err != nil && aborted() {
+				fmt.Printf("Aborted commit %s; it is pending.\n", commitHash)
+				nextRetryQueue = append(nextRetryQueue, commitHash)
+				return
+			}
+			if endpoint := circuitOpenEndpoint(err); endpoint != "" {
+				fmt.Printf("Skipped commit %s during retry: %v. Will retry again.\n", commitHash, err)
+				circuitOpen[endpoint] = true
+				nextRetryQueue = append(nextRetryQueue, commitHash)
+				skipped++
+				return
+			}
+			if err != nil {
+				exitOnPolicyViolation(err)
+				stopOnPermanentError(err, &fatalErr)
+				if isMissingObjects(err) {
+					fmt.Printf("Error processing commit %s during retry: %s; not retrying: %v\n", commitHash, missingObjectsReason, err)
+					unauditableCommits = append(unauditableCommits, commitHash)
+					state.LastError = newPermanentGitError(commitHash, err)
+					stateFile.Failed(commitHash, state.LastError)
+					progress.Update(len(allAuditedCommits), fatalErr)
+					return
+				}
+				state.recordFailure(commitHash, err, opts.Ladder, opts.DegradeAfter)
+				state.recordRetry(err, pass, time.Now())
+				// Before the hand-over to -retry-model, -retry-passes bounds the retries instead.
+				if state.exhausted(audit.MaxRetries) && (retryGenerator == nil || handedOff) {
+					fmt.Printf("Error processing commit %s during retry: %v. Giving up after %d retries (-max-retries).\n", commitHash, err, state.Retries)
+					givenUpCommits = append(givenUpCommits, commitHash)
+					stateFile.Failed(commitHash, err)
+				} else {
+					fmt.Printf("Error processing commit %s during retry: %v. Will retry again.\n", commitHash, err)
+					nextRetryQueue = append(nextRetryQueue, commitHash)
+					currentFailures++
+				}
+				stopOverBudget(limit, opts.Generator, len(allAuditedCommits), &fatalErr)
+				progress.Update(len(allAuditedCommits), fatalErr)
+				return
+			}
+			if handedOff {
+				auditData.RetryModel = audit.RetryModel
+			}
+			fmt.Printf("Successfully processed commit %s on retry (%s)\n", commitHash, describeAuditSource(auditData))
+			allAuditedCommits = append(allAuditedCommits, auditData) // Add to the main list
+			journal.Append(auditData)
+			stateFile.Audited(auditData)
+			checkpoint.Record(allAuditedCommits)
+			interrupts.Track(allAuditedCommits)
+			stopOverBudget(limit, opts.Generator, len(allAuditedCommits), &fatalErr)
+			progress.Update(len(allAuditedCommits), fatalErr)
+		}
+		// The current and remaining commits of an interrupted pass are reported as pending.
+		nextRetryQueue = append(nextRetryQueue, pool.Run(due)...)
+		retryQueueCommits = append(nextRetryQueue, deferred...)
+
+		if len(nextRetryQueue) > 0 && currentFailures == len(due) && !stopping() {
+			fmt.Printf("All %d commits in the current retry pass failed. Retrying them again in the next pass.\n", currentFailures)
+		}
+		if skipped == len(due) {
+			// A pass that sent nothing does not count towards -retry-passes.
+			pass--
+		}
+	}
+
+	sortByRange(allAuditedCommits, commitHashes)
+
+	if audit.SecretReport {
+		fmt.Println("Scanning the patches of the range for committed secrets (-secret-report)")
+		// A shard scans the whole range, so that a secret added in its commits and removed in
+		// another shard's is known to be removed; findings go only to its own entries.
+		scanned := commitHashes
+		if shard.Count > 0 {
+			scanned = shardRange
+		}
+		if failures := scanSecrets(requestCtx, audit.Repo, scanned, opts.Targets, allAuditedCommits); failures > 0 {
+			fmt.Printf("Warning: %d patches could not be scanned for secrets.\n", failures)
+		}
+	}
+
+	if !recoveryMode {
+		tags, err := getRangeTags(audit.Repo, head, commitHashes)
+		if !audit.IncludeTags {
+			// Lightweight tags carry no message; only -include-tags asks for their markers.
+			tags = annotatedTags(tags)
+		}
+		if err != nil {
+			fmt.Printf("Warning: failed to list tags in the audited range: %v\n", err)
+		} else if len(tags) > 0 {
+			fmt.Printf("Adding %d tag entries to the report.\n", len(tags))
+			allAuditedCommits = insertTagEntries(allAuditedCommits, buildTagEntries(opts, tags, audit.TagContext))
+		}
+	}
+
+	if head != "" && compare == nil {
+		// Commits made to the repository during the run are reported, not audited.
+		if moved, err := getTipMovement(audit.Repo, head); err != nil {
+			fmt.Printf("Warning: failed to check whether HEAD moved during the audit: %v\n", err)
+		} else if moved != nil {
+			note := msg("header.tip_advanced", moved.Added, shortHash(moved.Head))
+			if moved.Dropped > 0 {
+				note = msg("header.tip_rewritten", shortHash(moved.Head), moved.Added, moved.Dropped)
+			}
+			fmt.Printf("Warning: %s\n", note)
+			reportHeader += "\n" + note
+		}
+	}
+
+	var failOnMet bool
+	if len(audit.FailOn) > 0 {
+		run := runOutcome{Entries: allAuditedCommits, Failed: len(unauditableCommits) + len(givenUpCommits)}
+		failed := make(map[string]bool)
+		for _, hash := range retryQueueCommits {
+			if !failed[hash] {
+				failed[hash] = true
+				run.Failed++
+			}
+		}
+		if opts.Generator.Priced() {
+			run.Cost = opts.Generator.Spent()
+		}
+		results, header := evaluateFailOn(audit.FailOn, &run)
+		for _, result := range results {
+			failOnMet = failOnMet || result.Met
+		}
+		reportHeader += "\n" + header
+	}
+
+	// Write all successful audit data to the report, replacing any partial checkpoint
+	checkpoint.Wait()
+	outputFailed := false
+	if len(allAuditedCommits) > 0 {
+		completed, failed := writeOutputs(allAuditedCommits, true)
+		if len(failed) > 0 {
+			outputFailed = true
+			if len(completed) > 0 {
+				fmt.Printf("Warning: partial output: wrote %s; failed to write %s\n", strings.Join(completed, ", "), strings.Join(failed, ", "))
+			}
+		}
+	} else {
+		fmt.Println("\nNo audited commit data was successfully generated to write to file.")
+	}
+	// The report now holds every entry in range order; the journal is kept only if it does not.
+	journal.Finish(!outputFailed)
+	if shard.Count > 0 {
+		// Written even without entries: an empty shard still covers its part of the range.
+		pending := make(map[string]bool)
+		manifest := shardManifest{
+			Version: shardManifestVersion, Shard: shard.Index, Shards: shard.Count,
+			Head: head, Boundary: boundaryHash, Range: shardRange, Assigned: commitHashes,
+			Parameters: shardParameters(flag.CommandLine, config), Settings: settingsHeader, Entries: allAuditedCommits,
+		}
+		for _, hash := range append(append(retryQueueCommits, unauditableCommits...), givenUpCommits...) {
+			if !pending[hash] {
+				pending[hash] = true
+				manifest.Pending = append(manifest.Pending, hash)
+			}
+		}
+		manifestFile := shard.manifestPath(outputFileName)
+		if err := writeShardManifest(manifestFile, manifest); err != nil {
+			fmt.Printf("Error: %v\n", err)
+		} else {
+			fmt.Printf("Wrote the shard manifest to %s\n", manifestFile)
+		}
+	}
+
+	formattingOnly, automated, leakMasked, suspectDates, licenseChanges, unverifiedClaims := 0, 0, 0, 0, 0, 0
+	degradedRetries := 0
+	categories := make(map[string]int)
+	treatedTestsAndDocs := 0
+	verified, disagreed := 0, 0
+	var verificationUsage tokenUsage
+	securityFixes, vulnerabilities, lookupFailures := 0, 0, 0
+	for _, data := range allAuditedCommits {
+		if len(data.Vulnerabilities) > 0 {
+			securityFixes++
+			vulnerabilities += len(data.Vulnerabilities)
+		}
+		if data.VulnerabilityStatus == osvLookupFailed {
+			lookupFailures++
+		}
+		if data.FormattingOnly {
+			formattingOnly++
+		}
+		if data.Kind == kindAutomated {
+			automated++
+		}
+		if data.ChangeCategory != "" {
+			categories[data.ChangeCategory]++
+		}
+		if data.TestsAndDocs != "" {
+			treatedTestsAndDocs++
+		}
+		if data.LeakMasked {
+			leakMasked++
+		}
+		if len(data.UnverifiedClaims) > 0 {
+			unverifiedClaims++
+		}
+		if data.RetryModel != "" {
+			degradedRetries++
+		}
+		if data.LicenseChange {
+			licenseChanges++
+		}
+		if data.DateSuspect != "" {
+			suspectDates++
+		}
+		if data.VerificationUsage != nil {
+			verified++
+			verificationUsage = verificationUsage.add(*data.VerificationUsage)
+			if data.Verification == verificationDiscrepancies {
+				disagreed++
+			}
+		}
+	}
+	if opts.Hook != nil {
+		fmt.Printf("post_process_hook failures: %d\n", opts.Hook.Failures())
+	}
+	if opts.FormatDetection {
+		fmt.Printf("%d commits were classified as formatting-only and skipped the model call.\n", formattingOnly)
+	}
+	if opts.Automation != nil {
+		fmt.Printf("%d commits were recognized as automated and skipped the model call.\n", automated)
+	}
+	fmt.Printf("By changed paths, %d commits are code, %d test-only, %d docs-only and %d mixed.\n", categories[categoryCode], categories[categoryTestOnly], categories[categoryDocsOnly], categories[categoryMixed])
+	switch opts.TestsAndDocs {
+	case testsAndDocsSummarize:
+		fmt.Printf("%d test-only and docs-only commits got a templated summary and skipped the model call.\n", treatedTestsAndDocs)
+	case testsAndDocsSkip:
+		fmt.Printf("%d test-only and docs-only commits were skipped and are listed at the end of the report.\n", treatedTestsAndDocs)
+	}
+	if config.NeverSendConfidential {
+		fmt.Printf("%d summaries named withheld files after regenerating and were masked.\n", leakMasked)
+	}
+	if retryGenerator != nil {
+		fmt.Printf("%d entries were produced by the retry model %s after the configured model kept failing.\n", degradedRetries, audit.RetryModel)
+	}
+	if opts.CheckClaims {
+		fmt.Printf("%d summaries still made unsupported claims after regenerating and were marked %s.\n", unverifiedClaims, claimMarker)
+	}
+	if opts.OSV != nil {
+		fmt.Printf("%d commits fix %d known vulnerabilities in dependencies (OSV lookups failed for %d commits).\n", securityFixes, vulnerabilities, lookupFailures)
+	}
+	if audit.SecretReport {
+		findings, removed, commits := 0, 0, 0
+		for _, data := range allAuditedCommits {
+			if len(data.Secrets) > 0 {
+				commits++
+			}
+			for _, f := range data.Secrets {
+				findings++
+				if f.RemovedIn != "" {
+					removed++
+				}
+			}
+		}
+		fmt.Printf("%d secrets were committed in %d commits, %d of them removed later in the range; see the report's Secret findings section.\n", findings, commits, removed)
+	}
+	fmt.Printf("%d commits change license, copying or NOTICE files or copyright headers; see the report's License and copyright changes section.\n", licenseChanges)
+	if suspectDates > 0 {
+		fmt.Printf("Warning: %d commits are dated in the future or before the root commit; see the report's Suspect dates section.\n", suspectDates)
+	}
+	// Time slept in pause windows, spent prefetching the blobs of a partial clone and spent
+	// waiting for an unreachable model server is reported but not counted towards the token rate.
+	runTime := time.Since(runStarted)
+	var runTimeNotes []string
+	if pauser != nil && pauser.Paused > 0 {
+		runTimeNotes = append(runTimeNotes, formatDuration(pauser.Paused)+" paused")
+	}
+	if prefetchTime > 0 {
+		runTimeNotes = append(runTimeNotes, formatDuration(prefetchTime)+" prefetching blobs")
+	}
+	if outageWait > 0 {
+		runTimeNotes = append(runTimeNotes, formatDuration(outageWait.Truncate(time.Second))+" waiting for the model server")
+	}
+	if len(runTimeNotes) > 0 {
+		fmt.Printf("Run time: %s (%s)\n", formatDuration(runTime), strings.Join(runTimeNotes, ", "))
+		if pauser != nil {
+			runTime -= pauser.Paused
+		}
+		runTime -= prefetchTime + outageWait
+	} else {
+		fmt.Printf("Run time: %s\n", formatDuration(runTime))
+	}
+	opts.Cache.RecordRun("audit")
+	if calls, usage := opts.Generator.Usage(); usage.PromptTokens+usage.OutputTokens > 0 {
+		fmt.Printf("Token usage: %s prompt + %s output tokens over %s %s calls (%s)\n", formatCount(int64(usage.PromptTokens)), formatCount(int64(usage.OutputTokens)),
+			formatCount(int64(calls)), opts.Generator.Name(), formatRate(int64(usage.OutputTokens), "tok", runTime))
+		if opts.Generator.Priced() {
+			fmt.Printf("Cost: %s\n", formatUSD(opts.Generator.Spent()))
+		}
+	}
+	if opts.Verify != nil {
+		overhead := fmt.Sprintf("%s prompt + %s output tokens", formatCount(int64(verificationUsage.PromptTokens)), formatCount(int64(verificationUsage.OutputTokens)))
+		if opts.Generator.Price != nil {
+			overhead += ", " + formatUSD(opts.Generator.Cost(verificationUsage))
+		}
+		fmt.Printf("Verification: %d commits summarized twice, %d with discrepancies; overhead %s (included above)\n", verified, disagreed, overhead)
+	}
+	if outages := breakerSummary(); outages != "" {
+		fmt.Printf("Model server outages: %s\n", outages)
+	}
+	debugf("model connections: %s", connectionSummary())
+
+	isInterrupted := stopping()
+	progress.Finish(len(retryQueueCommits), isInterrupted)
+
+	if isInterrupted {
+		if fatalErr != nil {
+			fmt.Printf("\nProcess stopped early: %v\n", fatalErr)
+		} else if pauser != nil && pauser.Stopped != nil {
+			fmt.Printf("\nProcess stopped for pause window %s; run it again afterwards to audit the pending commits.\n", pauser.Stopped)
+		} else if interrupts.Stage() == stageAbort {
+			fmt.Println("\nProcess was interrupted by a second Ctrl+C; the commit in flight was aborted and is pending.")
+		} else if interrupts.Stage() == stageFinish {
+			fmt.Println("\nProcess was interrupted by Ctrl+C after finishing the commit in flight.")
+		} else {
+			fmt.Println("\nProcess was interrupted.")
+		}
+		if len(retryQueueCommits) > 0 {
+			fmt.Printf("The following %d commits were pending processing or retry:\n", len(retryQueueCommits))
+			// Remove duplicates that might have occurred if interruption happened during list copying
+			uniquePendingCommits := make(map[string]bool)
+			var finalList []string
+			for _, commitHash := range retryQueueCommits {
+				if !uniquePendingCommits[commitHash] {
+					uniquePendingCommits[commitHash] = true
+					finalList = append(finalList, commitHash)
+				}
+			}
+			for _, commitHash := range finalList {
+				if state := commitStates[commitHash]; state != nil && state.LastError != nil {
+					fmt.Printf("%s (%d failed attempts, last error: %v)\n", commitHash, state.Failures, state.LastError)
+				} else {
+					fmt.Println(commitHash)
+				}
+			}
+		} else {
+			fmt.Println("No commits were pending retry.")
+		}
+	} else if len(unauditableCommits) == 0 && len(givenUpCommits) == 0 {
+		fmt.Println("\nAll commits processed successfully.")
+	}
+	if len(givenUpCommits) > 0 {
+		fmt.Printf("\nThe following %d commits failed after %d retries (-max-retries):\n", len(givenUpCommits), audit.MaxRetries)
+		for _, failed := range failedCommits(commitHashes, givenUpCommits, commitStates) {
+			fmt.Printf("%s (%d failed attempts, last error: %s)\n", failed.Hash, failed.Attempts, failed.Error)
+		}
+	}
+	if len(unauditableCommits) > 0 {
+		fmt.Printf("\nThe following %d commits could not be audited (%s):\n", len(unauditableCommits), missingObjectsReason)
+		for _, commitHash := range unauditableCommits {
+			fmt.Println(commitHash)
+		}
+	}
+	result := newRunResult(commitHashes, retryQueueCommits, unauditableCommits, givenUpCommits, commitStates, fatalErr, outputFailed, failOnMet)
+	stateFile.Finish(result.Err == nil && len(retryQueueCommits)+len(unauditableCommits)+len(givenUpCommits) == 0 && !outputFailed)
+	if code := result.exitCode(); code != 0 {
+		exitProcess(code)
+	}
+}
+
+// isFlagSet reports whether the named flag was given explicitly on the command line.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// exitOnPolicyViolation aborts the run when err is a -strict-policy violation. Nothing is
+// written in that case, since the environment disallows even partial processing.
+func exitOnPolicyViolation(err error) {
+	var violation *policyViolationError
+	if errors.As(err, &violation) {
+		fmt.Printf("Error: %v. Aborting because -strict-policy is set.\n", violation)
+		exitProcess(1)
+	}
+}
+
+// stopOnPermanentError stops the run like an interrupt when err cannot be fixed by retrying,
+// e.g. an invalid API key, so the commits audited so far are still written. The first such
+// error is kept in fatalErr for the final report.
+func stopOnPermanentError(err error, fatalErr *error) {
+	if !isPermanent(err) || *fatalErr != nil {
+		return
+	}
+	*fatalErr = err
+	fmt.Printf("Error: %v. Retrying cannot fix this; stopping after writing the commits audited so far.\n", err)
+	stopRun(err)
+}
+
+// auditCommit runs the full pipeline for a single commit: patch generation, the optional
+// formatting-only pre-classification, the Ollama call and the metadata lookup.
+// detail selects the degradation ladder step used to build the prompt (detailFull normally).
+// Cancelling ctx kills its git commands and fails its model requests.
+// Any error is returned wrapped with the stage that failed so callers can queue a retry.
+func auditCommit(ctx context.Context, opts *auditOptions, commitHash, detail string) (CommitAuditData, error) {
+	var extras promptExtras
+	var auditData CommitAuditData
+	target := opts.Targets[commitHash]
+
+	metadata, err := getCommitMetadata(ctx, opts.RepoPath, commitHash)
+	if err != nil {
+		return CommitAuditData{}, fmt.Errorf("failed to get metadata: %w", err)
+	}
+	// Stash entries are commits with two or three parents too, but are diffed against their base.
+	var parents []string
+	if target.DiffBase == "" {
+		parents = metadata.Parents
+	}
+	// Octopus merges and, with -first-parent, all merges are not diffed the way `git show`
+	// diffs a commit, which the formatting pre-classification relies on.
+	mergeDiff := isOctopus(parents) || (opts.FirstParent && len(parents) > 1)
+
+	// Control environment and license changes are flagged whatever produces the summary. A
+	// change group counts every path its patch sets touched.
+	patchSets := []string{commitHash}
+	if target.Group != nil {
+		patchSets = target.Group.Commits
+	}
+	var touched []string
+	var licenseLines []licenseHeaders
+	for _, hash := range patchSets {
+		base := target.DiffBase
+		if opts.FirstParent && len(parents) > 1 {
+			base = parents[0]
+		}
+		paths, err := getTouchedPaths(ctx, opts.RepoPath, hash, base, len(parents) > 1)
+		if err != nil {
+			return CommitAuditData{}, err
+		}
+		touched = append(touched, paths...)
+		headers, err := getLicenseHeaders(ctx, opts.RepoPath, hash, base, len(parents) > 1)
+		if err != nil {
+			return CommitAuditData{}, err
+		}
+		licenseLines = append(licenseLines, headers...)
+	}
+	extras = applyControlWatchlist(controlWatchlist(opts.Config), touched, extras, &auditData)
+	extras = applyLicenseCheck(touched, licenseLines, extras, &auditData)
+	// A merge compared with all its parents touches only its conflict resolutions.
+	if len(parents) < 2 || opts.FirstParent || target.DiffBase != "" {
+		auditData.ChangeCategory = opts.Categories.classify(touched)
+		if opts.CheckTests {
+			base := target.DiffBase
+			if opts.FirstParent && len(parents) > 1 {
+				base = parents[0]
+			}
+			if auditData.TestCheck, err = checkTests(ctx, opts.RepoPath, patchSets, base, opts.Pathspec, opts.Categories); err != nil {
+				return CommitAuditData{}, err
+			}
+		}
+	}
+
+	// Stash entries are diffed against their parent, so neither `git show` based pre-classification
+	// applies, and neither describes all the patch sets of a change.
+	if opts.Automation != nil && target.DiffBase == "" && target.Group == nil {
+		rule, subject, err := classifyAutomation(ctx, opts.RepoPath, commitHash, opts.Automation)
+		if err != nil {
+			fmt.Printf("Warning: automation classification failed for commit %s: %v\n", commitHash, err)
+		} else if rule != nil {
+			summary, err := automatedSummary(ctx, opts.RepoPath, commitHash, subject, rule)
+			if err != nil {
+				return CommitAuditData{}, fmt.Errorf("failed to build automated summary: %w", err)
+			}
+			auditData.Summary = summary
+			auditData.Kind = kindAutomated
+			auditData.AutomationRule = rule.Name
+		}
+	}
+	if opts.TestsAndDocs != "" && isTestsOrDocs(auditData.ChangeCategory) && auditData.Kind != kindAutomated {
+		auditData.TestsAndDocs = opts.TestsAndDocs
+		switch opts.TestsAndDocs {
+		case testsAndDocsSummarize:
+			summary, err := categorySummary(ctx, opts.RepoPath, commitHash, auditData.ChangeCategory, touched)
+			if err != nil {
+				return CommitAuditData{}, fmt.Errorf("failed to build %s summary: %w", auditData.ChangeCategory, err)
+			}
+			auditData.Summary = summary
+		case testsAndDocsSkip:
+			// Not audited: the subject stands in for the summary in the report's list.
+			message, err := getCommitMessage(ctx, opts.RepoPath, commitHash)
+			if err != nil {
+				return CommitAuditData{}, err
+			}
+			auditData.Summary = strings.TrimSpace(strings.SplitN(message, "\n", 2)[0])
+		}
+	}
+	if opts.FormatDetection && target.DiffBase == "" && target.Group == nil && !mergeDiff && auditData.Kind != kindAutomated && !auditData.categoryTemplated() {
+		classification, err := classifyFormatting(ctx, opts.RepoPath, commitHash, opts.FormatHintThreshold)
+		if err != nil {
+			// The pre-classification is only an optimisation; fall back to the normal path.
+			fmt.Printf("Warning: formatting pre-classification failed for commit %s: %v\n", commitHash, err)
+		} else if classification.FormattingOnly {
+			summary, err := formattingOnlySummary(ctx, opts.RepoPath, commitHash, classification)
+			if err != nil {
+				return CommitAuditData{}, fmt.Errorf("failed to build formatting-only summary: %w", err)
+			}
+			auditData.Summary = summary
+			auditData.FormattingOnly = true
+		} else if classification.Borderline {
+			extras.Hints = append(extras.Hints, classification.Hint())
+		}
+	}
+
+	if opts.Topology.isMerge(commitHash) {
+		hint, err := mergeHint(ctx, opts.RepoPath, opts.Topology, commitHash, opts.Privacy.SendMessage)
+		if err != nil {
+			return CommitAuditData{}, fmt.Errorf("failed to describe merged branch: %w", err)
+		}
+		if hint != "" {
+			extras.Hints = append(extras.Hints, hint)
+		}
+	}
+	if target.Group != nil {
+		extras.Hints = append(extras.Hints, changeGroupHint(target.Group))
+	}
+	if opts.FirstParent && len(parents) > 1 {
+		extras.Hints = append(extras.Hints, "This is a merge commit, shown with its diff against its first parent: everything the merged branches brought in. Their commits are not described separately; describe the merged work as a whole.")
+	}
+
+	if !auditData.FormattingOnly && auditData.Kind != kindAutomated && !auditData.categoryTemplated() {
+		if err := generateSummary(ctx, opts, commitHash, target, parents, detail, extras, &auditData); err != nil {
+			return CommitAuditData{}, err
+		}
+	}
+
+	if opts.OSV != nil && target.DiffBase == "" {
+		changes, err := getDependencyChanges(ctx, opts.RepoPath, commitHash)
+		if err == nil && len(changes) > 0 {
+			auditData.Vulnerabilities, err = opts.OSV.FixedVulnerabilities(changes)
+		}
+		if err != nil {
+			// The lookup is an annotation; the entry stands without it.
+			fmt.Printf("Warning: vulnerability lookup failed for commit %s: %v\n", commitHash, err)
+			auditData.VulnerabilityStatus = osvLookupFailed
+		}
+	}
+
+	// Merges add no changes of their own, except with -first-parent, where they stand for
+	// the commits they brought in.
+	if opts.DependencyDigest && target.DiffBase == "" && (len(parents) < 2 || opts.FirstParent) {
+		for _, hash := range patchSets {
+			changes, err := getDependencyDiff(ctx, opts.RepoPath, hash, opts.FirstParent)
+			if err != nil {
+				fmt.Printf("Warning: failed to read the dependency changes of commit %s: %v\n", hash, err)
+			}
+			for _, change := range changes {
+				if !change.Transitive {
+					auditData.Dependencies = append(auditData.Dependencies, change)
+				}
+			}
+		}
+	}
+
+	if len(parents) > 1 {
+		auditData.ParentCount = len(parents)
+		if auditData.Kind == "" {
+			auditData.Kind = kindMerge
+		}
+	}
+	if auditData.Kind == "" {
+		auditData.Kind = kindCommit
+	}
+	auditData.Hash = metadata.Hash
+	auditData.Author = metadata.Author
+	auditData.Date = metadata.date(opts.DateSource)
+	auditData.AuthorDate = metadata.AuthorDate
+	auditData.CommitDate = metadata.CommitDate
+	auditData.DateSuspect = opts.DateCheck.classify(auditData.Date)
+	if opts.Reviewers != nil {
+		// A merge has no single pre-image unless it is described against its first parent.
+		base := target.DiffBase
+		if base == "" && (len(parents) == 1 || (opts.FirstParent && len(parents) > 1)) {
+			base = parents[0]
+		}
+		reviewers, err := opts.Reviewers.suggestReviewers(ctx, opts.RepoPath, commitHash, base)
+		if err != nil {
+			fmt.Printf("Warning: no reviewer suggestions for commit %s: %v\n", commitHash, err)
+		}
+		auditData.SuggestedReviewers = reviewers
+	}
+	auditData.Ref = target.Ref
+	auditData.Unreachable = target.Unreachable
+	auditData.ChangeGroup = target.Group
+	if opts.Topology != nil {
+		auditData.Parents = opts.Topology.Parents[commitHash]
+		auditData.MergeGroup = opts.Topology.Group[commitHash]
+	}
+	opts.Hook.Enrich(&auditData)
+	return auditData, nil
+}
+
+// generateSummary builds the prompt for a commit (full patch, -message-only or, for an
+// octopus merge, per-parent diffstats, shrunk according to the detail level), applies the
+// never_send policy, calls Ollama and validates citations, storing the result on auditData.
+// parents are the commit's parents, nil for stash entries and patches.
+func generateSummary(ctx context.Context, opts *auditOptions, commitHash string, target auditTarget, parents []string, detail string, extras promptExtras, auditData *CommitAuditData) error {
+	if target.Unreachable {
+		extras.Hints = append(extras.Hints, "This commit is not reachable from any branch; it was recovered from the reflog.")
+	}
+	settings := detailSettingsFor(opts.Ladder, detail)
+	auditData.DetailLevel = detail
+
+	var prompt, patch string
+	// build renders the prompt with the given extras, for regenerating with more instructions.
+	var build func(promptExtras) string
+	var withheld []withheldFile
+	var footprint changeFootprint
+	remaining := 0
+	octopus := isOctopus(parents) && !opts.FirstParent
+	if octopus {
+		message, err := getCommitMessage(ctx, opts.RepoPath, commitHash)
+		if err != nil {
+			return fmt.Errorf("failed to read commit message: %w", err)
+		}
+		var diffs []parentDiff
+		diffs, withheld, remaining, err = getOctopusDiffs(ctx, opts.RepoPath, commitHash, parents, opts.Pathspec, opts.Config.NeverSend, opts.Privacy.SendMessage)
+		if err != nil {
+			return fmt.Errorf("failed to compute the diffstats of octopus merge: %w", err)
+		}
+		if len(withheld) > 0 {
+			extras.Hints = append(extras.Hints, policyNote(len(withheld))+"; those files are not listed below.")
+		}
+		for i, diff := range diffs {
+			fp := statsFootprint(diff.Stats)
+			footprint.Files = append(footprint.Files, fp.Files...)
+			if i == 0 {
+				// What the merge brings into the first-parent line.
+				footprint.Lines = fp.Lines
+			}
+		}
+		extras = applyPromptOverride(opts.Config.PromptOverrides, footprint.Files, extras, auditData)
+		if opts.Controls != nil {
+			extras.Instructions = append(extras.Instructions, opts.Controls.Instruction())
+		}
+		build = func(e promptExtras) string {
+			return buildOctopusPrompt(opts.Privacy.filter(opts.Privacy.SendMessage, message), diffs, e)
+		}
+		extras.Glossary = opts.Glossary.Entries(build(extras))
+		prompt = build(extras)
+		auditData.MessageOnly = true
+	} else if (opts.MessageOnly || settings.StatsOnly) && target.Patch == "" {
+		// A patch from stdin has no repository to compute stats from, so it is always sent whole.
+		message := target.Message
+		if message == "" {
+			var err error
+			message, err = getCommitMessage(ctx, opts.RepoPath, commitHash)
+			if err != nil {
+				return fmt.Errorf("failed to read commit message: %w", err)
+			}
+		}
+		base := target.DiffBase
+		if opts.FirstParent && len(parents) > 1 {
+			base = parents[0]
+		}
+		stats, err := getCommitStats(ctx, opts.RepoPath, commitHash, base, opts.Pathspec)
+		if err != nil {
+			return fmt.Errorf("failed to compute commit stats: %w", err)
+		}
+		stats.Files, withheld = filterPolicyFiles(stats.Files, opts.Config.NeverSend)
+		remaining = len(stats.Files)
+		footprint = statsFootprint(stats)
+		if len(withheld) > 0 {
+			extras.Hints = append(extras.Hints, policyNote(len(withheld))+"; those files are not listed below.")
+		}
+		extras = applyPromptOverride(opts.Config.PromptOverrides, footprint.Files, extras, auditData)
+		if opts.Controls != nil {
+			extras.Instructions = append(extras.Instructions, opts.Controls.Instruction())
+		}
+		build = func(e promptExtras) string {
+			return buildMessageOnlyPrompt(opts.Privacy.filter(opts.Privacy.SendMessage, message), stats, e)
+		}
+		extras.Glossary = opts.Glossary.Entries(build(extras))
+		prompt = build(extras)
+		auditData.MessageOnly = true
+	} else {
+		var diffArgs []string
+		if settings.ReducedContext {
+			diffArgs = append(diffArgs, fmt.Sprintf("--unified=%d", reducedContextLines))
+		}
+		if opts.FirstParent && len(parents) > 1 {
+			diffArgs = append(diffArgs, "--diff-merges=first-parent")
+		}
+		var err error
+		patch, err = getPatchForTarget(ctx, opts.RepoPath, commitHash, target, opts.Privacy, opts.Pathspec, diffArgs...)
+		if err != nil {
+			return fmt.Errorf("failed to generate patch: %w", err)
+		}
+		patch, withheld, remaining = applySendPolicy(patch, opts.Config.NeverSend)
+		footprint = patchFootprint(patch)
+		if len(withheld) > 0 {
+			extras.Hints = append(extras.Hints, policyNote(len(withheld))+"; their changes are not shown in the patch below.")
+		}
+		extras = applyPromptOverride(opts.Config.PromptOverrides, footprint.Files, extras, auditData)
+		if settings.ElideLowPriority {
+			elidedPatch, elided, kept := applySendPolicy(patch, lowPriorityGlobs)
+			// Never elide everything; a lockfile-only commit still needs something to describe.
+			if len(elided) > 0 && kept > 0 {
+				patch = elidedPatch
+				extras.Hints = append(extras.Hints, elisionNote(len(elided)))
+			}
+		}
+		if opts.Cite {
+			extras.Instructions = append(extras.Instructions, citationInstruction)
+		}
+		if opts.Controls != nil {
+			extras.Instructions = append(extras.Instructions, opts.Controls.Instruction())
+		}
+		extras.Glossary = opts.Glossary.Entries(patch)
+		if opts.Budget != nil {
+			patch, extras, auditData.Budget = opts.Budget.applyBudget(patch, extras)
+			b := auditData.Budget
+			debugf("commit %s budget: instructions %s of %s, context %s of %s, patch %s of %s", commitHash,
+				budgetAmount(b.Instructions.Kept, b.Instructions.KeptTokens), budgetAmount(b.Instructions.Size, b.Instructions.SizeTokens),
+				budgetAmount(b.Context.Kept, b.Context.KeptTokens), budgetAmount(b.Context.Size, b.Context.SizeTokens),
+				budgetAmount(b.Patch.Kept, b.Patch.KeptTokens), budgetAmount(b.Patch.Size, b.Patch.SizeTokens))
+		}
+		build = func(e promptExtras) string { return buildPrompt(patch, e) }
+		prompt = build(extras)
+	}
+
+	if len(withheld) > 0 {
+		for _, w := range withheld {
+			fmt.Printf("Policy: withholding %s from commit %s (matched never_send glob %q)\n", w.Path, commitHash, w.Glob)
+		}
+		if opts.StrictPolicy {
+			return &policyViolationError{Hash: commitHash, Withheld: withheld}
+		}
+		auditData.WithheldFiles = len(withheld)
+		if remaining == 0 {
+			fmt.Printf("Policy: every changed file in commit %s is withheld; skipping the model call.\n", commitHash)
+			auditData.PolicySkipped = true
+			auditData.Summary = fmt.Sprintf("No summary generated: all changed files in this commit (%s) are covered by the never_send policy.", policyNote(len(withheld)))
+			return nil
+		}
+	}
+
+	result, err := opts.Generator.Generate(ctx, prompt)
+	if err != nil {
+		return fmt.Errorf("failed to call %s: %w", opts.Generator.Name(), err)
+	}
+	generatedMessage := result.Text
+	usage := result.Usage
+
+	// Checked first, so that the citation and withheld-file checks see the final text.
+	if opts.CheckClaims {
+		var claimUsage tokenUsage
+		generatedMessage, claimUsage, auditData.UnverifiedClaims, err = checkClaims(commitHash, generatedMessage, prompt, func(instruction string) (generation, error) {
+			retryExtras := extras
+			retryExtras.Instructions = append(append([]string{}, extras.Instructions...), instruction)
+			return opts.Generator.Generate(ctx, build(retryExtras))
+		})
+		if err != nil {
+			return err
+		}
+		usage = usage.add(claimUsage)
+	}
+	if opts.Cite && !octopus {
+		valid, invalid := validateCitations(generatedMessage, patch)
+		if len(invalid) > 0 || len(valid) == 0 {
+			// Regenerate once with a stronger instruction before giving up on the citations.
+			if len(invalid) > 0 {
+				fmt.Printf("Commit %s: %d of %d citations could not be verified; regenerating once.\n", commitHash, len(invalid), len(valid)+len(invalid))
+			} else {
+				fmt.Printf("Commit %s: summary contains no citations; regenerating once.\n", commitHash)
+			}
+			retryExtras := extras
+			retryExtras.Instructions = append(append([]string{}, extras.Instructions...), citationRetryInstruction(invalid))
+			regenerated, err := opts.Generator.Generate(ctx, build(retryExtras))
+			if err != nil {
+				return fmt.Errorf("failed to call %s to regenerate citations: %w", opts.Generator.Name(), err)
+			}
+			generatedMessage = regenerated.Text
+			usage = usage.add(regenerated.Usage)
+			valid, invalid = validateCitations(generatedMessage, patch)
+		}
+		if len(invalid) > 0 || len(valid) == 0 {
+			auditData.CitationStatus = citationsUnverified
+		} else {
+			auditData.CitationStatus = citationsVerified
+		}
+	}
+	if opts.Config.NeverSendConfidential && len(withheld) > 0 {
+		var leakUsage tokenUsage
+		generatedMessage, leakUsage, auditData.LeakMasked, err = checkLeaks(commitHash, generatedMessage, withheld, func(instruction string) (generation, error) {
+			retryExtras := extras
+			retryExtras.Instructions = append(append([]string{}, extras.Instructions...), instruction)
+			return opts.Generator.Generate(ctx, build(retryExtras))
+		})
+		if err != nil {
+			return err
+		}
+		usage = usage.add(leakUsage)
+	}
+	if opts.Controls != nil {
+		generatedMessage, auditData.Controls = opts.Controls.Extract(generatedMessage)
+	}
+	if opts.Verify != nil {
+		if reason := opts.Verify.match(footprint, auditData.Controls); reason != "" {
+			var verifyUsage tokenUsage
+			generatedMessage, verifyUsage, err = verifySummary(ctx, opts, commitHash, reason, prompt, generatedMessage, withheld, auditData)
+			usage = usage.add(verifyUsage)
+			if err != nil {
+				return err
+			}
+			if opts.Cite && !octopus {
+				if valid, invalid := validateCitations(generatedMessage, patch); len(invalid) > 0 || len(valid) == 0 {
+					auditData.CitationStatus = citationsUnverified
+				} else {
+					auditData.CitationStatus = citationsVerified
+				}
+			}
 		}
+	}
+	if corrected, n := opts.Glossary.Correct(generatedMessage); n > 0 {
+		debugf("commit %s glossary: corrected the spelling of %d terms", commitHash, n)
+		generatedMessage = corrected
+	}
+	auditData.Summary = generatedMessage
+	auditData.Usage = &usage
+	auditData.CostUSD = opts.Generator.Cost(usage)
+	return nil
+}
 
-		commitGitHash, author, date, err := getCommitMetadata(*repoPath, commitHash)
-		if err != nil {
-			errMsg := fmt.Sprintf("Error getting metadata for commit %s: %v. Adding to retry queue.", commitHash, err)
-			fmt.Println(errMsg)
-			retryQueueCommits = append(retryQueueCommits, commitHash)
-			continue
-		}
+// describeAuditSource returns a short human-readable description of how an entry's summary was produced.
+func describeAuditSource(data CommitAuditData) string {
+	if data.Kind == kindAutomated {
+		return fmt.Sprintf("automated commit matched by rule %s, templated summary and Git metadata", data.AutomationRule)
+	}
+	if data.FormattingOnly {
+		return "formatting-only, templated summary and Git metadata"
+	}
+	if data.TestsAndDocs == testsAndDocsSummarize {
+		return data.ChangeCategory + ", templated summary and Git metadata"
+	}
+	if data.TestsAndDocs == testsAndDocsSkip {
+		return data.ChangeCategory + ", not audited with -tests-and-docs skip, Git metadata"
+	}
+	if data.PolicySkipped {
+		return "all files withheld by policy, no model call, Git metadata"
+	}
+	if data.MessageOnly {
+		return "Got model summary from message and stats, and Git metadata"
+	}
+	return "Got model summary and Git metadata"
+}
 
-		fmt.Printf("Successfully processed commit %s (Got Ollama summary and Git metadata)\n", commitHash)
-		auditData := CommitAuditData{
-			Hash:    commitGitHash,
-			Author:  author,
-			Date:    date,
-			Summary: generatedMessage,
-		}
-		allAuditedCommits = append(allAuditedCommits, auditData)
+// entrySeparator is written between two entries of a report.
+const entrySeparator = "\n---\n\n"
+
+// entryWriter writes report entries one at a time, with the separator between entries but
+// not after the last one, so that a report can be written whole or appended to as commits
+// complete.
+type entryWriter struct {
+	w io.Writer
+	// separate is set when the next entry or group header needs a separator before it: after
+	// an entry, but not after a group header, whose entries follow it directly.
+	separate bool
+}
+
+// WriteEntry writes an entry indented to depth, after a separator indented like it.
+func (e *entryWriter) WriteEntry(entry string, depth int) error {
+	if err := e.separator(depth); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(e.w, indentLines(entry, depth)); err != nil {
+		return err
 	}
+	e.separate = true
+	return nil
+}
 
-	// Retry loop
-	if len(retryQueueCommits) > 0 && !interrupted { // Check interrupted flag before starting retry loop
-		fmt.Println("\n--- Starting Retry Processing ---")
+// WriteGroupHeader writes the header line of a merge group indented to depth.
+func (e *entryWriter) WriteGroupHeader(header string, depth int) error {
+	if err := e.separator(depth); err != nil {
+		return err
 	}
-	for len(retryQueueCommits) > 0 {
-		mu.Lock()
-		if interrupted {
-			mu.Unlock()
-			fmt.Println("Interrupted during retry processing.")
-			break // Exit retry loop
-		}
-		mu.Unlock()
+	if _, err := io.WriteString(e.w, indentLines(header, depth)+"\n"); err != nil {
+		return err
+	}
+	e.separate = false
+	return nil
+}
 
-		fmt.Printf("Commits in retry queue: %d\n", len(retryQueueCommits))
-		currentFailures := 0 // To detect if all attempts in a retry pass fail
+// separator writes the separator due before the next item, indented like that item.
+func (e *entryWriter) separator(depth int) error {
+	if !e.separate {
+		return nil
+	}
+	if _, err := io.WriteString(e.w, indentLines(entrySeparator, depth)); err != nil {
+		return fmt.Errorf("failed to write separator: %w", err)
+	}
+	return nil
+}
 
-		var nextRetryQueue []string
-		for _, commitHash := range retryQueueCommits {
-			mu.Lock()
-			if interrupted {
-				mu.Unlock()
-				// Add current and remaining retry commits to nextRetryQueue to be reported as pending
-				// Find current commitHash in retryQueueCommits and add it and the rest
-				for i, h := range retryQueueCommits {
-					if h == commitHash {
-						nextRetryQueue = append(nextRetryQueue, retryQueueCommits[i:]...)
-						break
-					}
-				}
-				break // Exit inner loop for this pass
-			}
-			mu.Unlock()
+// promptExtras collects the optional pieces that features add to a commit's prompt.
+type promptExtras struct {
+	// Hints are short, pre-computed observations about the commit.
+	Hints []string
+	// Instructions are additional requirements the generated message must satisfy.
+	Instructions []string
+	// Glossary lists the glossary terms found in the commit, as "Term: definition".
+	Glossary []string
+}
 
-			fmt.Printf("Retrying commit: %s\n", commitHash)
-			patch, err := getPatchForCommit(*repoPath, commitHash)
-			if err != nil {
-				errMsg := fmt.Sprintf("Error generating patch for commit %s during retry: %v. Will retry again.", commitHash, err)
-				fmt.Println(errMsg)
-				nextRetryQueue = append(nextRetryQueue, commitHash)
-				currentFailures++
-				continue
-			}
+// render formats the extras as the block placed between the base instructions and the patch.
+// It returns an empty string when there is nothing to add so the base prompt is unchanged.
+func (e promptExtras) render() string {
+	var text string
+	if len(e.Instructions) > 0 {
+		text += "\nAdditional requirements for the message:\n- " + strings.Join(e.Instructions, "\n- ") + "\n"
+	}
+	if len(e.Hints) > 0 {
+		text += "\nAdditional observations about this commit:\n- " + strings.Join(e.Hints, "\n- ") + "\n"
+	}
+	if len(e.Glossary) > 0 {
+		text += "\nGlossary of project terms (use these spellings and meanings):\n- " + strings.Join(e.Glossary, "\n- ") + "\n"
+	}
+	return text
+}
 
-			prompt := fmt.Sprintf(`Given the following Git patch, please generate a highly detailed and descriptive Git commit message. The message should cover:
+// buildPrompt assembles the prompt sent to Ollama for a commit patch.
+func buildPrompt(patch string, extras promptExtras) string {
+	return fmt.Sprintf(`Given the following Git patch, please generate a highly detailed and descriptive Git commit message. The message should cover:
 1. A summary of the changes.
 2. The reasoning behind the changes (why they were made).
 3. Any problems that were encountered (if apparent from the patch or commit message).
 4. The intended purpose or goal of the commit.
 
 Do not include the "Patch:" prefix or any introductory phrases like "Here's a commit message:". Output only the commit message itself.
-
+%s
 Patch:
-%s`, patch)
+%s`, extras.render(), patch)
+}
 
-			generatedMessage, err := callOllama(config.OllamaEndpoint, config.OllamaModel, prompt)
-			if err != nil {
-				errMsg := fmt.Sprintf("Error calling Ollama for commit %s during retry: %v. Will retry again.", commitHash, err)
-				fmt.Println(errMsg)
-				nextRetryQueue = append(nextRetryQueue, commitHash)
-				currentFailures++
-				continue
-			}
+// writeMessagesToFile writes a list of CommitAuditData to the specified file,
+// with each entry formatted and separated by a standard delimiter. A non-empty header is
+// written above the entries. The report is written to a temporary file and renamed into
+// place, so a reader never sees a partially written report.
+func writeMessagesToFile(filename string, auditedCommits []CommitAuditData, header string) error {
+	file, err := os.CreateTemp(filepath.Dir(filename), ".gitaudit-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create file %s: %w", filename, err)
+	}
+	// CreateTemp uses 0600; the report has always been world-readable like a file from os.Create.
+	if err := file.Chmod(0o644); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return fmt.Errorf("failed to create file %s: %w", filename, err)
+	}
+	if err := writeEntries(file, auditedCommits, header); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return fmt.Errorf("failed to write file %s: %w", filename, err)
+	}
+	if err := os.Rename(file.Name(), filename); err != nil {
+		os.Remove(file.Name())
+		return fmt.Errorf("failed to replace file %s: %w", filename, err)
+	}
+	return nil
+}
 
-			commitGitHash, author, date, err := getCommitMetadata(*repoPath, commitHash)
-			if err != nil {
-				errMsg := fmt.Sprintf("Error getting metadata for commit %s during retry: %v. Will retry again.", commitHash, err)
-				fmt.Println(errMsg)
-				nextRetryQueue = append(nextRetryQueue, commitHash)
-				currentFailures++
-				continue
-			}
-			fmt.Printf("Successfully processed commit %s on retry (Got Ollama summary and Git metadata)\n", commitHash)
-			auditData := CommitAuditData{
-				Hash:    commitGitHash,
-				Author:  author,
-				Date:    date,
-				Summary: generatedMessage,
-			}
-			allAuditedCommits = append(allAuditedCommits, auditData) // Add to the main list
+// writeEntries renders the report into file.
+func writeEntries(file *os.File, auditedCommits []CommitAuditData, header string) error {
+	if header != "" {
+		if _, err := file.WriteString(header + "\n\n"); err != nil {
+			return fmt.Errorf("failed to write report header to file: %w", err)
 		}
-		retryQueueCommits = nextRetryQueue
-
-		if len(retryQueueCommits) > 0 && currentFailures == len(retryQueueCommits) && !interrupted {
-			fmt.Printf("All %d commits in the current retry pass failed. Retrying them again in the next pass.\n", currentFailures)
-			// No sleep here as per "ad infinitum" but in a real-world scenario, a small delay might be added.
+	}
+	// Control environment and license changes are listed first, whatever the order of the
+	// entries below.
+	if section := formatControlChangeSection(auditedCommits); section != "" {
+		if _, err := file.WriteString(section + "\n---\n\n"); err != nil {
+			return fmt.Errorf("failed to write control environment changes to file: %w", err)
 		}
-		// The duplicated retryQueueCommits = nextRetryQueue and the subsequent if block were simplified
-		// as the state of interrupted is checked at the beginning of the outer loop and inner loop.
 	}
-
-	// Write all successful audit data to gitaudit.txt
-	if len(allAuditedCommits) > 0 {
-		outputFileName := "gitaudit.txt"
-		err = writeMessagesToFile(outputFileName, allAuditedCommits) // Pass allAuditedCommits
-		if err != nil {
-			fmt.Printf("Error writing audited commit data to file %s: %v\n", outputFileName, err)
-		} else {
-			fmt.Printf("\nSuccessfully wrote %d audited commit entries to %s\n", len(allAuditedCommits), outputFileName)
+	if section := formatLicenseChangeSection(auditedCommits); section != "" {
+		if _, err := file.WriteString(section + "\n---\n\n"); err != nil {
+			return fmt.Errorf("failed to write license changes to file: %w", err)
 		}
-	} else {
-		fmt.Println("\nNo audited commit data was successfully generated to write to file.")
 	}
-
-	mu.Lock()
-	isInterrupted := interrupted
-	mu.Unlock()
-
-	if isInterrupted {
-		fmt.Println("\nProcess was interrupted.")
-		if len(retryQueueCommits) > 0 {
-			fmt.Printf("The following %d commits were pending processing or retry:\n", len(retryQueueCommits))
-			// Remove duplicates that might have occurred if interruption happened during list copying
-			uniquePendingCommits := make(map[string]bool)
-			var finalList []string
-			for _, commitHash := range retryQueueCommits {
-				if !uniquePendingCommits[commitHash] {
-					uniquePendingCommits[commitHash] = true
-					finalList = append(finalList, commitHash)
-				}
-			}
-			for _, commitHash := range finalList {
-				fmt.Println(commitHash)
+	// Automated commits are collapsed into a section of their own after the other entries,
+	// as are the test-only and docs-only commits -tests-and-docs groups or skips.
+	var entries, automated, grouped, skipped []CommitAuditData
+	for _, data := range auditedCommits {
+		switch {
+		case data.Kind == kindAutomated:
+			automated = append(automated, data)
+		case data.TestsAndDocs == testsAndDocsGroup:
+			grouped = append(grouped, data)
+		case data.TestsAndDocs == testsAndDocsSkip:
+			skipped = append(skipped, data)
+		default:
+			entries = append(entries, data)
+		}
+	}
+	items := arrangeByMerge(entries)
+	writer := &entryWriter{w: file}
+	for _, item := range items {
+		data := item.Entry
+		if item.GroupHeader != "" {
+			if err := writer.WriteGroupHeader(item.GroupHeader, item.Depth); err != nil {
+				return fmt.Errorf("failed to write merge group header to file: %w", err)
 			}
+			continue
+		}
+
+		var entry string
+		if data.Kind == kindTag {
+			entry = formatTagEntry(data)
 		} else {
-			fmt.Println("No commits were pending retry.")
+			entry = formatCommitEntry(data)
+		}
+		if err := writer.WriteEntry(entry, item.Depth); err != nil {
+			return fmt.Errorf("failed to write audit data to file for commit %s: %w", data.Hash, err)
+		}
+	}
+	written := len(items) > 0
+	for _, section := range []struct {
+		Entries []CommitAuditData
+		Format  func([]CommitAuditData) string
+		Name    string
+	}{
+		{grouped, formatCategoryGroup, "test and documentation changes"},
+		{automated, formatAutomatedSection, "automated changes"},
+		{skipped, formatCategorySkipped, "skipped test and documentation changes"},
+	} {
+		if len(section.Entries) == 0 {
+			continue
+		}
+		text := section.Format(section.Entries)
+		if written {
+			text = "\n---\n\n" + text
+		}
+		written = true
+		if _, err := file.WriteString(text); err != nil {
+			return fmt.Errorf("failed to write %s section to file: %w", section.Name, err)
 		}
-	} else {
-		fmt.Println("\nAll commits processed successfully.")
 	}
+	if matrix := formatControlMatrix(auditedCommits); matrix != "" {
+		if _, err := file.WriteString("\n---\n\n" + matrix); err != nil {
+			return fmt.Errorf("failed to write control matrix to file: %w", err)
+		}
+	}
+	if digest := formatDependencyDigest(auditedCommits); digest != "" {
+		if _, err := file.WriteString("\n---\n\n" + digest); err != nil {
+			return fmt.Errorf("failed to write dependency changes to file: %w", err)
+		}
+	}
+	if section := formatSuspectDatesSection(auditedCommits); section != "" {
+		if _, err := file.WriteString("\n---\n\n" + section); err != nil {
+			return fmt.Errorf("failed to write suspect dates to file: %w", err)
+		}
+	}
+	if section := formatSecretSection(auditedCommits); section != "" {
+		if _, err := file.WriteString("\n---\n\n" + section); err != nil {
+			return fmt.Errorf("failed to write secret findings to file: %w", err)
+		}
+	}
+	return nil
 }
 
-// writeMessagesToFile writes a list of CommitAuditData to the specified file,
-// with each entry formatted and separated by a standard delimiter.
-func writeMessagesToFile(filename string, auditedCommits []CommitAuditData) error {
-	file, err := os.Create(filename)
-	if err != nil {
-		return fmt.Errorf("failed to create file %s: %w", filename, err)
+// formatCommitEntry renders a commit entry for the text report: the metadata header, any
+// notes about how the summary was produced, and the summary itself.
+func formatCommitEntry(data CommitAuditData) string {
+	var notes string
+	note := func(key string, args ...any) {
+		notes += msg(key, args...) + "\n"
 	}
-	defer file.Close()
-
-	for i, data := range auditedCommits {
-		entry := fmt.Sprintf("Commit: %s\nAuthor: %s\nDate: %s\n\n%s\n",
-			data.Hash, data.Author, data.Date, data.Summary)
-		_, err := file.WriteString(entry)
-		if err != nil {
-			return fmt.Errorf("failed to write audit data to file for commit %s: %w", data.Hash, err)
+	if data.Ref != "" {
+		note("entry.ref", data.Ref)
+	}
+	// Rebased and cherry-picked commits carry two dates; show the one Date is not.
+	if data.AuthorDate != data.CommitDate {
+		if data.Date == data.CommitDate {
+			note("entry.author_date", localDate(data.AuthorDate))
+		} else {
+			note("entry.commit_date", localDate(data.CommitDate))
 		}
-
-		// Add a separator between entries, but not after the last one.
-		if i < len(auditedCommits)-1 {
-			_, err = file.WriteString("\n---\n\n") // Adjusted separator for better readability between entries
-			if err != nil {
-				return fmt.Errorf("failed to write separator to file after commit %s: %w", data.Hash, err)
-			}
+	}
+	if data.Unreachable {
+		note("entry.unreachable")
+	}
+	if data.ParentCount > 2 {
+		note("entry.octopus", data.ParentCount)
+	}
+	if data.Kind == kindAutomated {
+		note("entry.automated", data.AutomationRule)
+	}
+	if data.FormattingOnly {
+		note("entry.formatting_only")
+	}
+	if data.TestsAndDocs == testsAndDocsSummarize {
+		note("entry.category_templated", data.ChangeCategory)
+	} else if data.ChangeCategory != "" && data.ChangeCategory != categoryCode {
+		note("entry.category", data.ChangeCategory)
+	}
+	if data.TestCheck != nil {
+		if text := describeTestCheck(data.TestCheck); text != "" {
+			notes += text + "\n"
 		}
 	}
-	return nil
+	if data.MessageOnly {
+		note("entry.message_only")
+	}
+	if data.PolicySkipped {
+		note("entry.policy_skipped")
+	} else if data.WithheldFiles > 0 {
+		notes += msgCount("entry.withheld", data.WithheldFiles) + "\n"
+	}
+	if data.LeakMasked {
+		note("entry.leak_masked")
+	}
+	if data.RetryModel != "" {
+		note("entry.retry_model", data.RetryModel)
+	}
+	if len(data.UnverifiedClaims) > 0 {
+		note("entry.unverified_claims", strings.Join(data.UnverifiedClaims, ", "), claimMarker)
+	}
+	if data.DetailLevel != "" && data.DetailLevel != detailFull {
+		note("entry.detail_level", data.DetailLevel)
+	}
+	if data.ChangeGroup != nil {
+		hashes := make([]string, len(data.ChangeGroup.Commits))
+		for i, hash := range data.ChangeGroup.Commits {
+			hashes[i] = shortHash(hash)
+		}
+		note("entry.change_group", data.ChangeGroup.ChangeID, strings.Join(hashes, ", "))
+	}
+	if data.PromptProfile != "" {
+		note("entry.prompt_profile", data.PromptProfile)
+	}
+	if data.ControlChange {
+		note("entry.control_change", strings.Join(data.ControlPaths, ", "))
+	}
+	if data.LicenseChange {
+		note("entry.license_change", strings.Join(data.LicensePaths, ", "))
+		for _, change := range data.SPDXChanges {
+			note("entry.spdx", change)
+		}
+	}
+	if len(data.Secrets) > 0 {
+		note("entry.secrets", len(data.Secrets), strings.Join(secretSeverities(data.Secrets), ", "))
+	}
+	if data.DateSuspect != "" {
+		note("entry.date_suspect", describeDateSuspect(data.DateSuspect))
+	}
+	if len(data.SuggestedReviewers) > 0 {
+		note("entry.suggested_reviewers", strings.Join(data.SuggestedReviewers, ", "))
+	}
+	if data.CitationStatus != "" {
+		note("entry.citations", data.CitationStatus)
+	}
+	if data.Budget != nil && data.Budget.Patch.Kept < data.Budget.Patch.Size {
+		note("entry.budget", formatBytes(int64(data.Budget.Patch.Kept)), formatBytes(int64(data.Budget.Patch.Size)))
+	}
+	if data.CostUSD > 0 {
+		note("entry.cost", formatUSD(data.CostUSD), formatCount(int64(data.Usage.PromptTokens)), formatCount(int64(data.Usage.OutputTokens)))
+	}
+	if len(data.Controls) > 0 {
+		note("entry.controls", strings.Join(data.Controls, "] ["))
+	}
+	switch data.Verification {
+	case verificationAgreed:
+		note("entry.verified")
+	case verificationUnconfirmed:
+		note("entry.verification_unconfirmed")
+	case verificationDiscrepancies:
+		note("entry.discrepancies")
+		for _, d := range data.Discrepancies {
+			notes += "  - " + d + "\n"
+		}
+	}
+	if len(data.Vulnerabilities) > 0 {
+		notes += formatVulnerabilities(data.Vulnerabilities)
+	} else if data.VulnerabilityStatus != "" {
+		note("entry.vulnerabilities", data.VulnerabilityStatus)
+	}
+	if len(data.Extras) > 0 {
+		notes += formatExtras(data.Extras)
+	}
+	return fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n",
+		msg("entry.commit", data.Hash), msg("entry.author", data.Author), msg("entry.date", localDate(data.Date)), notes, data.Summary)
 }
 
-// callOllama sends a prompt to the Ollama API and returns the generated message.
-func callOllama(endpoint, model, promptStr string) (string, error) {
+// errEmptyResponse is returned when the model answers successfully but generates no text.
+var errEmptyResponse = errors.New("the model returned an empty response")
+
+// callOllama sends a prompt to the Ollama API, with optional model parameters, and returns
+// the generated message. Cancelling ctx fails the request.
+func callOllama(ctx context.Context, endpoint, model, promptStr string, options map[string]any) (generation, error) {
 	ollamaReq := OllamaRequest{
-		Model:  model,
-		Prompt: promptStr,
-		Stream: false, // We want a single consolidated response
+		Model:   model,
+		Prompt:  promptStr,
+		Stream:  false, // We want a single consolidated response
+		Options: options,
 	}
 
 	reqBodyBytes, err := json.Marshal(ollamaReq)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal Ollama request: %w", err)
-	}
-
-	httpClient := &http.Client{Timeout: 60 * time.Second} // Configurable timeout
-	httpReq, err := http.NewRequest("POST", endpoint, bytes.NewBuffer(reqBodyBytes))
-	if err != nil {
-		return "", fmt.Errorf("failed to create HTTP request to Ollama: %w", err)
+		return generation{}, fmt.Errorf("failed to marshal Ollama request: %w", err)
 	}
-	httpReq.Header.Set("Content-Type", "application/json")
 
-	httpResp, err := httpClient.Do(httpReq)
+	httpResp, err := postBody(ctx, ollamaClient, endpoint, nil, reqBodyBytes)
 	if err != nil {
-		return "", fmt.Errorf("failed to send request to Ollama endpoint %s: %w", endpoint, err)
+		return generation{}, fmt.Errorf("failed to send request to Ollama endpoint %s: %w", endpoint, err)
 	}
 	defer httpResp.Body.Close()
 
@@ -347,12 +2596,27 @@ func callOllama(endpoint, model, promptStr string) (string, error) {
 		// Try to read body for more error info
 		var bodyBytes []byte
 		bodyBytes, _ = io.ReadAll(httpResp.Body) // Ignore error on read, primary error is status code
-		return "", fmt.Errorf("Ollama API request failed with status %s: %s", httpResp.Status, string(bodyBytes))
+		err := &ollamaStatusError{Status: httpResp.Status, StatusCode: httpResp.StatusCode, Body: string(bodyBytes)}
+		if httpResp.StatusCode == http.StatusNotFound {
+			// Ollama answers 404 for a model that has not been pulled; retrying will not help.
+			return generation{}, &permanentError{err: err, Status: httpResp.StatusCode, Body: string(bodyBytes)}
+		}
+		return generation{}, classifyOllamaError(err, httpResp.StatusCode, string(bodyBytes))
 	}
 
+	bodyBytes, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return generation{}, fmt.Errorf("failed to read Ollama response: %w", err)
+	}
 	var ollamaResp OllamaResponse
-	if err := json.NewDecoder(httpResp.Body).Decode(&ollamaResp); err != nil {
-		return "", fmt.Errorf("failed to decode Ollama response: %w", err)
+	if err := json.Unmarshal(bodyBytes, &ollamaResp); err != nil {
+		// Proxies in front of Ollama sometimes answer 200 with an HTML maintenance page.
+		return generation{}, fmt.Errorf("failed to decode Ollama response %q: %w", bodySnippet(bodyBytes), err)
+	}
+	if ollamaResp.Error != "" && ollamaResp.Response == "" {
+		// Some gateways report errors such as a missing model with a 200 status.
+		err := fmt.Errorf("Ollama returned an error with status %s: %s", httpResp.Status, ollamaResp.Error)
+		return generation{}, classifyOllamaError(err, httpResp.StatusCode, ollamaResp.Error)
 	}
 
 	if !ollamaResp.Done {
@@ -363,57 +2627,112 @@ func callOllama(endpoint, model, promptStr string) (string, error) {
 		fmt.Println("Warning: Ollama response indicates 'done' is false for a non-streaming request.")
 	}
 
-	return strings.TrimSpace(ollamaResp.Response), nil
+	return generation{
+		Text:  strings.TrimSpace(ollamaResp.Response),
+		Usage: tokenUsage{PromptTokens: ollamaResp.PromptEvalCount, OutputTokens: ollamaResp.EvalCount},
+	}, nil
+}
+
+// classifyOllamaError marks err as permanent when Ollama's message says the model does not
+// exist; anything else (overloaded servers, gateway errors) is worth retrying.
+func classifyOllamaError(err error, status int, message string) error {
+	lower := strings.ToLower(message)
+	if strings.Contains(lower, "model") && strings.Contains(lower, "not found") {
+		return &permanentError{err: err, Status: status, Body: message}
+	}
+	return err
+}
+
+// bodySnippet shortens a response body for error messages.
+func bodySnippet(body []byte) string {
+	text := strings.Join(strings.Fields(string(body)), " ")
+	if len(text) > 200 {
+		text = text[:200] + "..."
+	}
+	return text
 }
 
 // getPatchForCommit generates a patch for a given commit hash.
-// The patch includes the original commit message and the full diff.
-func getPatchForCommit(repoPath, commitHash string) (string, error) {
-	// `git show --patch <commitHash>` or `git format-patch -1 --stdout <commitHash>`
-	// `git show` is simpler as it includes the commit message and diff directly.
-	// `git format-patch` is more for creating patch files to be applied with `git am`.
-	// For sending to an LLM, `git show --patch` which includes commit metadata is good.
-	// The problem asks for "original commit message and the full set of changes (diff)".
-	// `git show --patch <commitHash>` does exactly this.
-	// `git show --patch --pretty=fuller <commitHash>` might give more detailed metadata if needed.
-	// For now, default `git show --patch` is fine.
-
-	cmd := exec.Command("git", "-C", repoPath, "show", "--patch", commitHash)
-	patchBytes, err := cmd.Output()
+// The patch includes the commit metadata privacy allows and the full diff; with everything
+// sent it is identical to `git show --patch`. The metadata and the diff body are read
+// separately so that withheld fields never enter the prompt. A pathspec limits the diff to
+// the matching paths.
+// Extra arguments (e.g. --unified=1) are passed through to git show.
+func getPatchForCommit(ctx context.Context, repoPath, commitHash string, privacy promptPrivacy, pathspec []string, extraArgs ...string) (string, error) {
+	header, err := getPromptMetadata(ctx, repoPath, commitHash, privacy)
 	if err != nil {
-		// Attempt to get stderr for more context
-		errMsg := fmt.Sprintf("failed to execute git show for commit %s: %v", commitHash, err)
-		if ee, ok := err.(*exec.ExitError); ok {
-			errMsg = fmt.Sprintf("%s. Stderr: %s", errMsg, string(ee.Stderr))
-		}
-		return "", fmt.Errorf(errMsg)
+		return "", err
+	}
+	args := append([]string{"show", "--format=", "--patch"}, extraArgs...)
+	args = append(args, commitHash)
+	if len(pathspec) > 0 {
+		args = append(append(args, "--"), pathspec...)
+	}
+	patchBytes, err := gitRun(ctx, repoPath, args...)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute git show for commit %s: %w", commitHash, err)
 	}
-	return string(patchBytes), nil
+	return header + "\n" + string(patchBytes), nil
 }
 
-// getCommitMetadata retrieves the hash, author, and date for a given commit.
-func getCommitMetadata(repoPath, commitHash string) (hash, author, date string, err error) {
-	cmd := exec.Command("git", "-C", repoPath, "show", "-s", fmt.Sprintf("--format=%s", "%H%n%an%n%ai"), commitHash)
-	output, err := cmd.Output()
+// getCommitMetadata retrieves the hash, author, author date, commit date and parents for a given commit.
+func getCommitMetadata(ctx context.Context, repoPath, commitHash string) (commitMetadata, error) {
+	output, err := gitRun(ctx, repoPath, "show", "-s", fmt.Sprintf("--format=%s", "%H%n%an%n%ai%n%ci%n%P"), commitHash)
 	if err != nil {
-		errMsg := fmt.Sprintf("failed to execute git show for metadata on commit %s: %v", commitHash, err)
-		if ee, ok := err.(*exec.ExitError); ok {
-			errMsg = fmt.Sprintf("%s. Stderr: %s", errMsg, string(ee.Stderr))
-		}
-		return "", "", "", fmt.Errorf(errMsg)
+		return commitMetadata{}, fmt.Errorf("failed to execute git show for metadata on commit %s: %w", commitHash, err)
 	}
 
 	parts := strings.Split(strings.TrimSpace(string(output)), "\n")
-	if len(parts) < 3 {
-		return "", "", "", fmt.Errorf("unexpected format from git show for metadata on commit %s: expected 3 lines, got %d. Output: %s", commitHash, len(parts), string(output))
+	if len(parts) < 4 {
+		return commitMetadata{}, fmt.Errorf("unexpected format from git show for metadata on commit %s: expected 4 lines, got %d. Output: %s", commitHash, len(parts), string(output))
+	}
+
+	metadata := commitMetadata{Hash: parts[0], Author: parts[1], AuthorDate: parts[2], CommitDate: parts[3]}
+	if len(parts) > 4 {
+		// A root commit has an empty parent line, which TrimSpace removed.
+		metadata.Parents = strings.Fields(parts[4])
+	}
+	return metadata, nil
+}
+
+// getRepoTopLevel returns the absolute path of the working tree root containing repoPath.
+func getRepoTopLevel(ctx context.Context, repoPath string) (string, error) {
+	output, err := gitRun(ctx, repoPath, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", fmt.Errorf("failed to execute git rev-parse --show-toplevel in %s: %w", repoPath, err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// getCommitMessage returns the full original commit message (subject and body) of a commit.
+func getCommitMessage(ctx context.Context, repoPath, commitHash string) (string, error) {
+	output, err := gitRun(ctx, repoPath, "show", "-s", "--format=%B", commitHash)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute git show for message on commit %s: %w", commitHash, err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// getChangedFiles returns the paths touched by a commit, as reported by `git show --name-only`.
+func getChangedFiles(ctx context.Context, repoPath, commitHash string) ([]string, error) {
+	output, err := gitRun(ctx, repoPath, "show", "--format=", "--name-only", commitHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute git show for file list on commit %s: %w", commitHash, err)
 	}
 
-	return parts[0], parts[1], parts[2], nil
+	var files []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			files = append(files, line)
+		}
+	}
+	return files, nil
 }
 
-// getCommitHashes returns a list of commit hashes from HEAD to the specified endCommitID (inclusive)
-// in chronological order (newest to oldest).
-func getCommitHashes(repoPath, endCommitID string) ([]string, error) {
+// getCommitHashes returns a list of commit hashes from tip, the HEAD pinned at startup, to the
+// specified endCommitID (inclusive) in chronological order (newest to oldest). With
+// firstParent, only the first-parent line is walked (-first-parent).
+func getCommitHashes(ctx context.Context, repoPath, tip, endCommitID string, firstParent bool) ([]string, error) {
 	// git log --pretty=format:%H HEAD...endCommitID
 	// We need to include the endCommitID itself.
 	// The range HEAD..endCommitID (two dots) includes commits reachable from HEAD but not from endCommitID.
@@ -427,8 +2746,7 @@ func getCommitHashes(repoPath, endCommitID string) ([]string, error) {
 
 	// Validate that repoPath is a git repository.
 	// Using `git rev-parse --is-inside-work-tree` is a more robust way to check.
-	cmdCheckRepo := exec.Command("git", "-C", repoPath, "rev-parse", "--is-inside-work-tree")
-	if err := cmdCheckRepo.Run(); err != nil {
+	if _, err := gitRun(ctx, repoPath, "rev-parse", "--is-inside-work-tree"); err != nil {
 		// This command outputs "true" or "false" to stdout and exits 0 if it's a repo (even if not top-level).
 		// It exits non-zero if not a git repo path.
 		return nil, fmt.Errorf("path %s is not a git repository or git command failed: %w", repoPath, err)
@@ -436,20 +2754,22 @@ func getCommitHashes(repoPath, endCommitID string) ([]string, error) {
 
 	// Ensure endCommitID is a full SHA and exists in the repo.
 	// `git rev-parse --verify <commitID>` will error if commit doesn't exist.
-	cmdResolveEndCommit := exec.Command("git", "-C", repoPath, "rev-parse", "--verify", endCommitID)
-	resolvedEndCommitBytes, err := cmdResolveEndCommit.Output()
+	resolvedEndCommitBytes, err := gitRun(ctx, repoPath, "rev-parse", "--verify", endCommitID)
 	if err != nil {
 		// Error from git rev-parse includes the commit ID, so the message is informative.
 		return nil, fmt.Errorf("failed to resolve commit ID %s in repository %s: %w", endCommitID, repoPath, err)
 	}
 	resolvedEndCommitID := strings.TrimSpace(string(resolvedEndCommitBytes))
 
-	// Get all commit hashes from HEAD, newest first.
-	// `git rev-list HEAD` lists commit objects in reverse chronological order.
-	cmd := exec.Command("git", "-C", repoPath, "rev-list", "HEAD")
-	output, err := cmd.Output()
+	// Get all commit hashes from the tip, newest first.
+	// `git rev-list <tip>` lists commit objects in reverse chronological order.
+	revListArgs := []string{"rev-list", tip}
+	if firstParent {
+		revListArgs = append(revListArgs, "--first-parent")
+	}
+	output, err := gitRun(ctx, repoPath, revListArgs...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute git rev-list HEAD: %w\nOutput: %s", err, string(output))
+		return nil, fmt.Errorf("failed to execute git rev-list %s: %w", tip, err)
 	}
 
 	allCommits := strings.Split(strings.TrimSpace(string(output)), "\n")
@@ -468,6 +2788,9 @@ func getCommitHashes(repoPath, endCommitID string) ([]string, error) {
 	}
 
 	if !foundEndCommit {
+		if firstParent {
+			return nil, fmt.Errorf("commit ID %s is not on the first-parent line of HEAD (-first-parent); choose a commit of that line, such as the merge that brought it in", endCommitID)
+		}
 		return nil, fmt.Errorf("commit ID %s not found in the history of HEAD or is not an ancestor", endCommitID)
 	}
 
@@ -476,77 +2799,263 @@ func getCommitHashes(repoPath, endCommitID string) ([]string, error) {
 
 // Config holds the configuration settings for Git Audit
 type Config struct {
+	// Provider selects the LLM backend: "ollama" (the default), "anthropic" or "gemini".
+	Provider       string `json:"provider"`
 	OllamaEndpoint string `json:"ollama_endpoint"`
 	OllamaModel    string `json:"ollama_model"`
+	// AutoModelPreference lists model name prefixes preferred, in order, when ollama_model is
+	// not set and an installed model is chosen; AutoModelMaxParameters caps the choice, e.g.
+	// "14B" (the default).
+	AutoModelPreference    []string `json:"auto_model_preference"`
+	AutoModelMaxParameters string   `json:"auto_model_max_parameters"`
+	// Anthropic and Gemini configure the hosted providers.
+	Anthropic *providerConfig `json:"anthropic"`
+	Gemini    *providerConfig `json:"gemini"`
+	// NeverSend lists path globs whose changes must never be sent to the model.
+	NeverSend []string `json:"never_send"`
+	// NeverSendConfidential also keeps the names of never_send files out of summaries.
+	NeverSendConfidential bool `json:"never_send_confidential"`
+	// DegradationLadder overrides the steps used to shrink prompts of commits that keep
+	// failing with length-related errors.
+	DegradationLadder []string `json:"degradation_ladder"`
+	// PostProcessHook is an executable run per audited commit to enrich its entry.
+	PostProcessHook string `json:"post_process_hook"`
+	// PostProcessHookTimeout bounds each hook invocation, e.g. "10s" (the default).
+	PostProcessHookTimeout string `json:"post_process_hook_timeout"`
+	// PostProcessHookConcurrent declares the hook safe to run concurrently.
+	PostProcessHookConcurrent bool `json:"post_process_hook_concurrent"`
+	// ContextSize is the model's context window in tokens. When set, prompts are trimmed to
+	// fit it according to BudgetSplit.
+	ContextSize int `json:"context_size"`
+	// BudgetSplit overrides the percentages of the context window reserved for the
+	// instructions, injected context and patch (10/20/70 by default).
+	BudgetSplit *budgetSplit `json:"budget_split"`
+	// AutoContextSize sizes the prompt budget from the Ollama model's context window when
+	// ContextSize is not set.
+	AutoContextSize bool `json:"auto_context_size"`
+	// DefaultContextSize is assumed when the model's context window cannot be discovered.
+	DefaultContextSize int `json:"default_context_size"`
+	// ModelInfoTTL is how long Ollama model info is cached, e.g. "24h" (the default).
+	ModelInfoTTL string `json:"model_info_ttl"`
+	// GitTimeout bounds every git command, e.g. "5m" (the default); "0" disables it.
+	GitTimeout string `json:"git_timeout"`
+	// AutomationRules recognize commits made by bots and release tooling, which get
+	// templated summaries instead of model calls. They are checked before the built-in rules.
+	AutomationRules []automationRule `json:"automation_rules"`
+	// Pricing maps hosted model names to their per-million-token prices, used to report
+	// the cost of each entry and run and to enforce -budget.
+	Pricing pricingTable `json:"pricing"`
+	// ControlTaxonomy defines the -controls categories, each a name and a description given
+	// to the model; a SOC 2 style default set is used when it is empty.
+	ControlTaxonomy []controlCategory `json:"control_taxonomy"`
+	// VerifyCritical selects the commits -verify-critical summarizes twice.
+	VerifyCritical *criticalPredicate `json:"verify_critical"`
+	// OSVEndpoint is the OSV API used for vulnerability lookups, https://api.osv.dev by default.
+	OSVEndpoint string `json:"osv_endpoint"`
+	// SendOriginalMessage, SendAuthor and SendDates select the commit metadata included in
+	// prompts; each defaults to true. Entries written locally keep the full metadata.
+	SendOriginalMessage *bool `json:"send_original_message"`
+	SendAuthor          *bool `json:"send_author"`
+	SendDates           *bool `json:"send_dates"`
+	// Profiles are named sets of config keys and flags selected with -profile.
+	Profiles map[string]profileSettings `json:"profiles"`
+	// PromptOverrides add instructions to the prompts of commits whose changed files mostly
+	// fall under their paths, checked in order.
+	PromptOverrides []promptOverride `json:"prompt_overrides"`
+	// ControlWatchlist adds globs to the built-in list of paths whose changes alter the
+	// control environment.
+	ControlWatchlist []string `json:"control_watchlist"`
+	// TestPaths and DocPaths add globs to the built-in lists of test and documentation paths
+	// that classify commits as test-only or docs-only.
+	TestPaths []string `json:"test_paths"`
+	DocPaths  []string `json:"doc_paths"`
+	// RetentionClass and DocumentOwner, when set, close every report with a retention
+	// trailer that gitaudit verify checks.
+	RetentionClass string `json:"retention_class"`
+	DocumentOwner  string `json:"document_owner"`
+	// Tokenizers select how the prompt budget counts tokens, per model name: "heuristic"
+	// (the default), "ollama" or "bpe:<file or URL>".
+	Tokenizers map[string]string `json:"tokenizers"`
+	// Serve configures `gitaudit serve`: its bearer token and registered repositories.
+	Serve *serveConfig `json:"serve"`
+	// Glossary maps internal terms and codenames to short definitions for the prompt; the
+	// summaries get the terms' spelling. GlossaryFile names a JSON file of more terms, shared
+	// between repositories; Glossary entries take precedence over it.
+	Glossary     map[string]string `json:"glossary"`
+	GlossaryFile string            `json:"glossary_file"`
 }
 
-// loadConfig reads the configuration from ~/.gitaudit
-func loadConfig() (*Config, error) {
+// configFilePath returns the path of the config file, ~/.gitaudit.
+func configFilePath() (string, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get user home directory: %w", err)
+		return "", fmt.Errorf("failed to get user home directory: %w", err)
 	}
+	return fmt.Sprintf("%s/.gitaudit", homeDir), nil
+}
+
+// The environment variables that set config keys, for containers where writing ~/.gitaudit is
+// inconvenient. They take precedence over the config file and are overridden by flags.
+const (
+	envOllamaEndpoint = "GITAUDIT_OLLAMA_ENDPOINT"
+	envOllamaModel    = "GITAUDIT_OLLAMA_MODEL"
+)
+
+// configOverrides are the config values given as flags, which take precedence over the config
+// file. setConfigOverrides sets them once the flags are parsed; loadConfig applies them.
+var configOverrides struct {
+	OllamaEndpoint string
+	OllamaModel    string
+}
+
+// setConfigOverrides records the config values given as prompt flags for loadConfig.
+func (p *promptFlags) setConfigOverrides() {
+	configOverrides.OllamaEndpoint = p.OllamaEndpoint
+	configOverrides.OllamaModel = p.OllamaModel
+}
 
-	configPath := fmt.Sprintf("%s/.gitaudit", homeDir)
-	configFile, err := os.Open(configPath)
+// loadConfig reads the configuration from ~/.gitaudit, with the GITAUDIT_* environment
+// variables and then configOverrides applied. The file may be missing when either names the
+// Ollama endpoint. An unset Ollama model is left for selectModel to choose.
+func loadConfig() (*Config, error) {
+	configPath, err := configFilePath()
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, fmt.Errorf("config file not found at %s. Please create it with 'ollama_endpoint' and 'ollama_model'", configPath)
+		return nil, err
+	}
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to open config file %s: %w", configPath, err)
 		}
-		return nil, fmt.Errorf("failed to open config file %s: %w", configPath, err)
+		if configOverrides.OllamaEndpoint == "" && os.Getenv(envOllamaEndpoint) == "" {
+			return nil, fmt.Errorf("config file not found at %s. Please create it with 'ollama_endpoint' and 'ollama_model', set %s, or pass -ollama-endpoint", configPath, envOllamaEndpoint)
+		}
+		data = []byte("{}")
 	}
-	defer configFile.Close()
 
 	var config Config
-	// For simplicity, we'll use a simple key=value format for now.
-	// A more robust solution would use JSON, YAML, or TOML.
-	// Example .gitaudit file:
-	// ollama_endpoint=http://localhost:11434/api/generate
-	// ollama_model=llama2
-	// This will be improved to use JSON parsing.
-
-	// Read the file line by line for now
-	// This will be replaced by proper JSON decoding.
-	// For now, let's assume fixed values for demonstration until JSON parsing is added.
-	// This is a placeholder.
-	// TODO: Implement proper JSON parsing for the config file.
-
-	// Temporary placeholder for config loading
-	// We will replace this with actual file parsing logic.
-	// For now, we'll hardcode to allow progress, then implement JSON.
-
-	// Let's create a dummy .gitaudit file for testing in the current dir
-	// and then implement the actual JSON parsing.
-
-	// This will be replaced by proper JSON decoding from ~/.gitaudit
-	// For now, this is a placeholder to allow other parts to be built.
-	// Actual implementation will use json.Decoder
-
-	// Switching to use json.Decoder as planned.
-	// Need to import "encoding/json"
-	// The config file should be in JSON format, e.g.:
-	// {
-	//   "ollama_endpoint": "http://localhost:11434/api/generate",
-	//   "ollama_model": "llama2"
-	// }
+	if err := decodeConfigJSON(data, &config, laxConfig); err != nil {
+		return nil, fmt.Errorf("failed to decode config file %s: %w", configPath, err)
+	}
+	if err := validateProfiles(config.Profiles); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", configPath, err)
+	}
+	if overlay, err := activeProfile.configOverlay(); err != nil || overlay != nil {
+		if err == nil {
+			err = json.Unmarshal(overlay, &config)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid profile %q in config file %s: %w", activeProfile.Name, configPath, err)
+		}
+	}
 
-	// Corrected approach: Use encoding/json
-	// Need to add `import "encoding/json"`
-	// The struct tags `json:"..."` are already in place for this.
+	if value := os.Getenv(envOllamaEndpoint); value != "" {
+		config.OllamaEndpoint = value
+	}
+	if value := os.Getenv(envOllamaModel); value != "" {
+		config.OllamaModel = value
+	}
+	overridden := configOverrides.OllamaEndpoint != "" || configOverrides.OllamaModel != ""
+	if configOverrides.OllamaEndpoint != "" {
+		config.OllamaEndpoint = configOverrides.OllamaEndpoint
+	}
+	if configOverrides.OllamaModel != "" {
+		config.OllamaModel = configOverrides.OllamaModel
+	}
 
-	// The file reading part is correct, now decode it.
-	// Need to add "encoding/json" to imports.
+	switch config.Provider {
+	case "", providerOllama:
+		if config.OllamaEndpoint == "" {
+			return nil, fmt.Errorf("config file %s must contain 'ollama_endpoint', or set %s or pass -ollama-endpoint", configPath, envOllamaEndpoint)
+		}
+		if u, err := url.Parse(config.OllamaEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("Ollama endpoint %q must be an http:// or https:// URL (from -ollama-endpoint, %s or ollama_endpoint in %s)", config.OllamaEndpoint, envOllamaEndpoint, configPath)
+		}
+	case providerAnthropic, providerGemini:
+		if overridden {
+			return nil, fmt.Errorf("-ollama-endpoint and -ollama-model apply to Ollama, but config file %s selects provider %q", configPath, config.Provider)
+		}
+		block := config.Anthropic
+		if config.Provider == providerGemini {
+			block = config.Gemini
+		}
+		if block == nil || block.Model == "" || block.APIKeyEnv == "" {
+			return nil, fmt.Errorf("config file %s must contain a '%s' block with 'model' and 'api_key_env' when provider is %q", configPath, config.Provider, config.Provider)
+		}
+		if block.MaxTokens < 0 {
+			return nil, fmt.Errorf("invalid config file %s: %s.max_tokens must not be negative", configPath, config.Provider)
+		}
+	default:
+		return nil, fmt.Errorf("invalid config file %s: unknown provider %q (expected %q, %q or %q)", configPath, config.Provider, providerOllama, providerAnthropic, providerGemini)
+	}
 
-	// The file opening logic is fine. Now decode.
-	decoder := json.NewDecoder(configFile)
-	if err := decoder.Decode(&config); err != nil {
-		return nil, fmt.Errorf("failed to decode config file %s: %w. Ensure it is valid JSON", configPath, err)
+	if err := validateDegradationLadder(config.DegradationLadder); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", configPath, err)
 	}
 
-	if config.OllamaEndpoint == "" || config.OllamaModel == "" {
-		return nil, fmt.Errorf("config file %s must contain 'ollama_endpoint' and 'ollama_model'", configPath)
+	if config.ContextSize < 0 || config.DefaultContextSize < 0 {
+		return nil, fmt.Errorf("invalid config file %s: context_size and default_context_size must not be negative", configPath)
+	}
+	if config.ModelInfoTTL != "" {
+		if ttl, err := time.ParseDuration(config.ModelInfoTTL); err != nil || ttl < 0 {
+			return nil, fmt.Errorf("invalid config file %s: model_info_ttl %q must be a duration such as \"24h\"", configPath, config.ModelInfoTTL)
+		}
+	}
+	if config.AutoModelMaxParameters != "" {
+		if _, err := parseParameterCount(config.AutoModelMaxParameters); err != nil {
+			return nil, fmt.Errorf("invalid config file %s: auto_model_max_parameters: %w", configPath, err)
+		}
+	}
+	if config.GitTimeout != "" {
+		if timeout, err := time.ParseDuration(config.GitTimeout); err != nil || timeout < 0 {
+			return nil, fmt.Errorf("invalid config file %s: git_timeout %q must be a duration such as \"5m\"", configPath, config.GitTimeout)
+		}
+	}
+	if config.BudgetSplit != nil {
+		if err := config.BudgetSplit.validate(); err != nil {
+			return nil, fmt.Errorf("invalid config file %s: %w", configPath, err)
+		}
+	}
+	if _, err := newAutomationRules(config.AutomationRules); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", configPath, err)
+	}
+	if err := config.Pricing.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", configPath, err)
+	}
+	if err := validatePromptOverrides(config.PromptOverrides); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", configPath, err)
+	}
+	for model, spec := range config.Tokenizers {
+		if err := validateTokenizerSpec(model, spec); err != nil {
+			return nil, fmt.Errorf("invalid config file %s: %w", configPath, err)
+		}
+	}
+	if config.Serve != nil {
+		if err := config.Serve.validate(); err != nil {
+			return nil, fmt.Errorf("invalid config file %s: %w", configPath, err)
+		}
+	}
+	if err := validateControlWatchlist(config.ControlWatchlist); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", configPath, err)
+	}
+	if err := validateCategoryPaths("test_paths", config.TestPaths); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", configPath, err)
+	}
+	if err := validateCategoryPaths("doc_paths", config.DocPaths); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", configPath, err)
+	}
+	if err := validateRetention(config.RetentionClass, config.DocumentOwner); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", configPath, err)
+	}
+	if _, err := newControlMapper(config.ControlTaxonomy); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", configPath, err)
+	}
+	if _, err := newGlossary(&config); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", configPath, err)
+	}
+	if config.OSVEndpoint != "" && !strings.HasPrefix(config.OSVEndpoint, "http://") && !strings.HasPrefix(config.OSVEndpoint, "https://") {
+		return nil, fmt.Errorf("invalid config file %s: osv_endpoint %q must be an http:// or https:// URL", configPath, config.OSVEndpoint)
 	}
 
 	return &config, nil
 }
-
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestMain lets the integration tests run the test binary as gitaudit itself: with
+// GITAUDIT_TEST_MAIN set, it runs main instead of the tests, so a run goes through the real
+// command line, exit codes and all. Children started by serve inherit the variable.
+func TestMain(m *testing.M) {
+	if os.Getenv("GITAUDIT_TEST_MAIN") == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// fixtureRepo is a throwaway git repository whose commits get fixed, increasing dates, so
+// that runs over it are reproducible.
+type fixtureRepo struct {
+	t   *testing.T
+	Dir string
+	// When is the date of the next commit; each commit moves it an hour on.
+	When time.Time
+}
+
+// newFixtureRepo creates an empty repository on branch main.
+func newFixtureRepo(t *testing.T) *fixtureRepo {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	r := &fixtureRepo{t: t, Dir: t.TempDir(), When: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	r.git("init", "-q", "-b", "main")
+	r.git("config", "user.name", "Fixture Author")
+	r.git("config", "user.email", "author@example.com")
+	r.git("config", "commit.gpgsign", "false")
+	r.git("config", "tag.gpgsign", "false")
+	return r
+}
+
+// git runs a git command in the repository and returns its trimmed output.
+func (r *fixtureRepo) git(args ...string) string {
+	r.t.Helper()
+	return r.gitEnv(nil, args...)
+}
+
+// gitEnv is git with extra environment variables, such as GIT_AUTHOR_DATE.
+func (r *fixtureRepo) gitEnv(env []string, args ...string) string {
+	r.t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = r.Dir
+	date := r.When.Format(time.RFC3339)
+	cmd.Env = append(os.Environ(), "GIT_AUTHOR_DATE="+date, "GIT_COMMITTER_DATE="+date, "GIT_CONFIG_NOSYSTEM=1", "GIT_CONFIG_GLOBAL="+os.DevNull)
+	cmd.Env = append(cmd.Env, env...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		r.t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// write sets the content of the files of the working tree; an empty content removes the file.
+func (r *fixtureRepo) write(files map[string]string) {
+	r.t.Helper()
+	for name, content := range files {
+		path := filepath.Join(r.Dir, name)
+		if content == "" {
+			if err := os.Remove(path); err != nil {
+				r.t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			r.t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			r.t.Fatal(err)
+		}
+	}
+}
+
+// commit writes files, commits every change with message and returns the new commit's hash.
+func (r *fixtureRepo) commit(message string, files map[string]string) string {
+	r.t.Helper()
+	return r.commitEnv(nil, message, files)
+}
+
+// commitEnv is commit with extra environment variables.
+func (r *fixtureRepo) commitEnv(env []string, message string, files map[string]string) string {
+	r.t.Helper()
+	r.write(files)
+	r.gitEnv(env, "add", "-A")
+	r.gitEnv(env, "commit", "-q", "--allow-empty", "-m", message)
+	r.When = r.When.Add(time.Hour)
+	return r.git("rev-parse", "HEAD")
+}
+
+// merge merges branches into the current branch with a merge commit, even when it could
+// fast-forward, and returns its hash.
+func (r *fixtureRepo) merge(message string, branches ...string) string {
+	r.t.Helper()
+	r.git(append([]string{"merge", "-q", "--no-ff", "-m", message}, branches...)...)
+	r.When = r.When.Add(time.Hour)
+	return r.git("rev-parse", "HEAD")
+}
+
+// commits adds n commits, each appending a line to its own file, and returns their hashes,
+// oldest first.
+func (r *fixtureRepo) commits(n int) []string {
+	r.t.Helper()
+	hashes := make([]string, n)
+	for i := range hashes {
+		name := fmt.Sprintf("src/file%d.go", i%5)
+		content := fmt.Sprintf("package src\n\n// Change %d.\nvar v%d = %d\n", i, i, i)
+		if old, err := os.ReadFile(filepath.Join(r.Dir, name)); err == nil {
+			content = string(old) + fmt.Sprintf("var v%d = %d\n", i, i)
+		}
+		hashes[i] = r.commit(fmt.Sprintf("Change %d", i), map[string]string{name: content})
+	}
+	return hashes
+}
+
+// fakeOllama is an Ollama server for tests. By default every generate request succeeds with
+// a summary naming a digest of its prompt; Respond scripts other answers.
+type fakeOllama struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	prompts []string
+	models  []string
+	// Respond answers the nth generate request (counting from 1), returning the status and
+	// the body to send; a zero status falls back to the default summary.
+	Respond func(n int, prompt string) (int, string)
+	// Delay is slept before each generate request is answered.
+	Delay time.Duration
+	// Show, when set, is the body of the /api/show answers instead of a small model's.
+	Show string
+	// Tags, when set, is the body of the /api/tags answers instead of the one small model.
+	Tags string
+	// inFlight and MaxInFlight track the generate requests being answered at once.
+	inFlight    int
+	MaxInFlight int
+}
+
+// newFakeOllama starts a fake server, closed when the test ends.
+func newFakeOllama(t *testing.T) *fakeOllama {
+	t.Helper()
+	f := &fakeOllama{}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// Endpoint is the generate endpoint to configure as ollama_endpoint.
+func (f *fakeOllama) Endpoint() string {
+	return f.URL + "/api/generate"
+}
+
+// Prompts returns the prompts of the generate requests received so far.
+func (f *fakeOllama) Prompts() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.prompts...)
+}
+
+// Models returns the models of the generate requests received so far, in the order of Prompts.
+func (f *fakeOllama) Models() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.models...)
+}
+
+// fakeSummary is the default summary for prompt.
+func fakeSummary(prompt string) string {
+	sum := sha1.Sum([]byte(prompt))
+	return fmt.Sprintf("Summary %s: this commit changes the code.", hex.EncodeToString(sum[:4]))
+}
+
+func (f *fakeOllama) serve(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/api/tags":
+		f.mu.Lock()
+		tags := f.Tags
+		f.mu.Unlock()
+		if tags != "" {
+			io.WriteString(w, tags)
+			return
+		}
+		io.WriteString(w, `{"models":[{"name":"tiny:0.5b","digest":"a8b0c5157701f3b2c1d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f60718293a","size":400000000,"details":{"parameter_size":"0.5B"}}]}`)
+		return
+	case "/api/show":
+		f.mu.Lock()
+		show := f.Show
+		f.mu.Unlock()
+		if show != "" {
+			io.WriteString(w, show)
+			return
+		}
+		io.WriteString(w, `{"details":{"parameter_size":"0.5B","quantization_level":"Q4_0","family":"qwen2"},"model_info":{"general.architecture":"qwen2","qwen2.context_length":32768}}`)
+		return
+	case "/api/version":
+		io.WriteString(w, `{"version":"0.5.7"}`)
+		return
+	case "/api/generate":
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	var req struct {
+		Model  string `json:"model"`
+		Prompt string `json:"prompt"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	f.prompts = append(f.prompts, req.Prompt)
+	f.models = append(f.models, req.Model)
+	n := len(f.prompts)
+	respond := f.Respond
+	f.inFlight++
+	f.MaxInFlight = max(f.MaxInFlight, f.inFlight)
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		f.inFlight--
+		f.mu.Unlock()
+	}()
+	if f.Delay > 0 {
+		time.Sleep(f.Delay)
+	}
+	if respond != nil {
+		if status, body := respond(n, req.Prompt); status != 0 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			io.WriteString(w, body)
+			return
+		}
+	}
+	body, _ := json.Marshal(map[string]any{
+		"model": req.Model, "response": fakeSummary(req.Prompt), "done": true,
+		"prompt_eval_count": len(req.Prompt) / 4, "eval_count": 10,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// auditEnv runs gitaudit as a child process against a fake Ollama server, with a home and
+// cache directory of its own.
+type auditEnv struct {
+	t      *testing.T
+	Home   string
+	Work   string // The working directory of the runs.
+	Ollama *fakeOllama
+	// Config is written to ~/.gitaudit before each run.
+	Config map[string]any
+	// Env holds extra environment variables for the runs.
+	Env []string
+}
+
+// newAuditEnv sets up a home directory whose config points at a new fake server.
+func newAuditEnv(t *testing.T) *auditEnv {
+	t.Helper()
+	e := &auditEnv{t: t, Home: t.TempDir(), Work: t.TempDir(), Ollama: newFakeOllama(t)}
+	e.Config = map[string]any{
+		"ollama_endpoint": e.Ollama.Endpoint(),
+		"ollama_model":    "tiny:0.5b",
+		"osv_endpoint":    e.Ollama.URL + "/",
+	}
+	return e
+}
+
+// command returns the command running gitaudit with args in e.Work.
+func (e *auditEnv) command(args ...string) *exec.Cmd {
+	e.t.Helper()
+	config, err := json.MarshalIndent(e.Config, "", "  ")
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(e.Home, ".gitaudit"), config, 0o600); err != nil {
+		e.t.Fatal(err)
+	}
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Dir = e.Work
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, "GITAUDIT_") && !strings.HasPrefix(kv, "XDG_") {
+			cmd.Env = append(cmd.Env, kv)
+		}
+	}
+	cmd.Env = append(cmd.Env, "GITAUDIT_TEST_MAIN=1", "HOME="+e.Home,
+		"XDG_CACHE_HOME="+filepath.Join(e.Home, ".cache"), "XDG_DATA_HOME="+filepath.Join(e.Home, ".local", "share"),
+		"GIT_CONFIG_NOSYSTEM=1")
+	cmd.Env = append(cmd.Env, e.Env...)
+	return cmd
+}
+
+// run runs gitaudit with args and returns its combined output and exit code.
+func (e *auditEnv) run(args ...string) (string, int) {
+	e.t.Helper()
+	out, err := e.command(args...).CombinedOutput()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return string(out), 0
+	case errors.As(err, &exitErr):
+		return string(out), exitErr.ExitCode()
+	default:
+		e.t.Fatalf("failed to run gitaudit: %v", err)
+		return "", -1
+	}
+}
+
+// mustRun is run that fails the test unless gitaudit exits with status 0.
+func (e *auditEnv) mustRun(args ...string) string {
+	e.t.Helper()
+	out, code := e.run(args...)
+	if code != 0 {
+		e.t.Fatalf("gitaudit %s exited with %d:\n%s", strings.Join(args, " "), code, out)
+	}
+	return out
+}
+
+// readFile returns the content of a file, failing the test if it cannot be read.
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// TestLoadConfigSources checks where the Ollama endpoint and model come from: flags over the
+// GITAUDIT_* environment variables over the config file, which may be missing when another
+// source names the endpoint.
+func TestLoadConfigSources(t *testing.T) {
+	const (
+		fileEndpoint = "http://file:11434/api/generate"
+		envEndpoint  = "http://env:11434/api/generate"
+		flagEndpoint = "http://flag:11434/api/generate"
+	)
+	saved := configOverrides
+	t.Cleanup(func() { configOverrides = saved })
+	for _, tc := range []struct {
+		name string
+		// file is the config file's content; "" means there is none.
+		file                    string
+		envEndpoint, envModel   string
+		flagEndpoint, flagModel string
+		wantEndpoint, wantModel string
+		wantErr                 string
+	}{
+		{name: "file", file: `{"ollama_endpoint": "` + fileEndpoint + `", "ollama_model": "file:1b"}`, wantEndpoint: fileEndpoint, wantModel: "file:1b"},
+		{name: "file endpoint, environment model", file: `{"ollama_endpoint": "` + fileEndpoint + `"}`, envModel: "env:1b", wantEndpoint: fileEndpoint, wantModel: "env:1b"},
+		{name: "environment over file", file: `{"ollama_endpoint": "` + fileEndpoint + `", "ollama_model": "file:1b"}`, envEndpoint: envEndpoint, envModel: "env:1b", wantEndpoint: envEndpoint, wantModel: "env:1b"},
+		{name: "flags over environment", file: `{"ollama_endpoint": "` + fileEndpoint + `"}`, envEndpoint: envEndpoint, envModel: "env:1b", flagModel: "flag:1b", wantEndpoint: envEndpoint, wantModel: "flag:1b"},
+		{name: "environment only", envEndpoint: envEndpoint, envModel: "env:1b", wantEndpoint: envEndpoint, wantModel: "env:1b"},
+		{name: "flags only", flagEndpoint: flagEndpoint, flagModel: "flag:1b", wantEndpoint: flagEndpoint, wantModel: "flag:1b"},
+		// The model may be left for selectModel to choose.
+		{name: "no model", envEndpoint: envEndpoint, wantEndpoint: envEndpoint},
+		{name: "nothing", wantErr: "config file not found at "},
+		{name: "model without endpoint", envModel: "env:1b", flagModel: "flag:1b", wantErr: "config file not found at "},
+		{name: "file without endpoint", file: `{"ollama_model": "file:1b"}`, envModel: "env:1b", wantErr: "must contain 'ollama_endpoint', or set GITAUDIT_OLLAMA_ENDPOINT or pass -ollama-endpoint"},
+		{name: "invalid endpoint", file: `{"ollama_endpoint": "` + fileEndpoint + `"}`, envEndpoint: "localhost:11434", wantErr: `Ollama endpoint "localhost:11434" must be an http:// or https:// URL`},
+		{name: "flags with another provider", file: `{"provider": "anthropic", "anthropic": {"model": "m", "api_key_env": "K"}}`, flagModel: "flag:1b", wantErr: `selects provider "anthropic"`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			home := t.TempDir()
+			t.Setenv("HOME", home)
+			t.Setenv(envOllamaEndpoint, tc.envEndpoint)
+			t.Setenv(envOllamaModel, tc.envModel)
+			if tc.file != "" {
+				if err := os.WriteFile(filepath.Join(home, ".gitaudit"), []byte(tc.file), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			configOverrides.OllamaEndpoint, configOverrides.OllamaModel = tc.flagEndpoint, tc.flagModel
+			config, err := loadConfig()
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("error %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.OllamaEndpoint != tc.wantEndpoint || config.OllamaModel != tc.wantModel {
+				t.Errorf("endpoint %q, model %q; want %q, %q", config.OllamaEndpoint, config.OllamaModel, tc.wantEndpoint, tc.wantModel)
+			}
+		})
+	}
+}
+
+// TestCancelledContext checks that the git commands and model requests of a commit stop with
+// the context they were given, as an abort or stopRun cancels it, rather than running out.
+func TestCancelledContext(t *testing.T) {
+	repo := newFixtureRepo(t)
+	hashes := repo.commits(2)
+	ollama := newFakeOllama(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, tc := range []struct {
+		name string
+		call func() error
+	}{
+		{"getRepoTopLevel", func() error { _, err := getRepoTopLevel(ctx, repo.Dir); return err }},
+		{"getCommitMessage", func() error { _, err := getCommitMessage(ctx, repo.Dir, hashes[1]); return err }},
+		{"getChangedFiles", func() error { _, err := getChangedFiles(ctx, repo.Dir, hashes[1]); return err }},
+		{"getCommitHashes", func() error { _, err := getCommitHashes(ctx, repo.Dir, hashes[1], hashes[0], false); return err }},
+		{"gitDiffBody", func() error { _, err := gitDiffBody(ctx, repo.Dir, hashes[1], true); return err }},
+		{"getPatchSecrets", func() error { _, err := getPatchSecrets(ctx, repo.Dir, hashes[1], ""); return err }},
+		{"callOllama", func() error { _, err := callOllama(ctx, ollama.Endpoint(), "tiny:0.5b", "p", nil); return err }},
+	} {
+		if err := tc.call(); !errors.Is(err, errInterrupted) || !errors.Is(err, context.Canceled) {
+			t.Errorf("%s with a cancelled context: %v", tc.name, err)
+		}
+	}
+	if n := len(ollama.Prompts()); n != 0 {
+		t.Errorf("%d requests reached the server", n)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// roffEscape escapes text for a roff line: backslashes and dashes (which roff would render
+// as hyphens) are escaped, and a leading dot or quote is kept from being read as a request.
+func roffEscape(text string) string {
+	text = strings.ReplaceAll(text, `\`, `\e`)
+	text = strings.ReplaceAll(text, "-", `\-`)
+	if strings.HasPrefix(text, ".") || strings.HasPrefix(text, "'") {
+		text = `\&` + text
+	}
+	return text
+}
+
+// zeroDefaults are the default values the man page does not print, like PrintDefaults.
+var zeroDefaults = map[string]bool{"": true, "0": true, "false": true, "0s": true}
+
+// writeRoffFlags writes a tagged paragraph for each flag of fs that skip does not exclude.
+func writeRoffFlags(sb *strings.Builder, fs *flag.FlagSet, skip func(f *flag.Flag) bool) {
+	fs.VisitAll(func(f *flag.Flag) {
+		if skip != nil && skip(f) {
+			return
+		}
+		kind, usage := flag.UnquoteUsage(f)
+		sb.WriteString(".TP\n")
+		if kind == "" {
+			fmt.Fprintf(sb, ".B %s\n", roffEscape("-"+f.Name))
+		} else {
+			fmt.Fprintf(sb, ".BI %s \" %s\"\n", roffEscape("-"+f.Name), kind)
+		}
+		sb.WriteString(roffEscape(strings.TrimSuffix(usage, ".")))
+		if !zeroDefaults[f.DefValue] {
+			fmt.Fprintf(sb, " (default %s)", roffEscape(f.DefValue))
+		}
+		sb.WriteString(".\n")
+	})
+}
+
+// writeRoffSynopsis writes the usage lines of spec.
+func writeRoffSynopsis(sb *strings.Builder, spec *commandSpec) {
+	forms := spec.Synopsis
+	if len(forms) == 0 {
+		forms = []string{""}
+	}
+	for _, form := range forms {
+		fmt.Fprintf(sb, ".B %s\n", roffEscape(spec.title()))
+		if form != "" {
+			sb.WriteString(roffEscape(form) + "\n")
+		}
+		sb.WriteString(".br\n")
+	}
+}
+
+// manPage renders the gitaudit(1) man page from the command registry.
+func manPage() string {
+	var sb strings.Builder
+	audit := lookupCommand("")
+	auditFlags := audit.flagSet()
+
+	sb.WriteString(".TH GITAUDIT 1 \"\" gitaudit \"User Commands\"\n")
+	sb.WriteString(".SH NAME\ngitaudit \\- audit the history of a Git repository with model\\-generated commit summaries\n")
+
+	sb.WriteString(".SH SYNOPSIS\n")
+	for i := range commandRegistry {
+		writeRoffSynopsis(&sb, &commandRegistry[i])
+	}
+
+	sb.WriteString(".SH DESCRIPTION\n")
+	sb.WriteString(roffEscape(audit.Summary+".") + "\n")
+	sb.WriteString(".PP\n")
+	sb.WriteString(roffEscape("The model, its provider and the audit policy are configured in ~/.gitaudit. gitaudit only reads the audited repository; every git command it runs leaves the index, the working tree and the refs alone.") + "\n")
+
+	sb.WriteString(".SH OPTIONS\n")
+	writeRoffFlags(&sb, auditFlags, nil)
+
+	sb.WriteString(".SH COMMANDS\n")
+	for i := range commandRegistry {
+		spec := &commandRegistry[i]
+		if spec.Name == "" {
+			continue
+		}
+		fmt.Fprintf(&sb, ".SS %s\n", roffEscape(strings.TrimSpace(spec.title()+" "+strings.Join(spec.Synopsis, " "))))
+		sb.WriteString(roffEscape(spec.Summary+".") + "\n")
+		if spec.Flags == nil {
+			continue
+		}
+		// Flags described under OPTIONS are only named.
+		var shared []string
+		writeRoffFlags(&sb, spec.flagSet(), func(f *flag.Flag) bool {
+			if g := auditFlags.Lookup(f.Name); g != nil && g.Usage == f.Usage && g.DefValue == f.DefValue {
+				shared = append(shared, "-"+f.Name)
+				return true
+			}
+			return false
+		})
+		if len(shared) > 0 {
+			sb.WriteString(".PP\n")
+			sb.WriteString(roffEscape(fmt.Sprintf("%s also accepts %s, as described under OPTIONS.", spec.title(), strings.Join(shared, ", "))) + "\n")
+		}
+	}
+
+	sb.WriteString(".SH FLAG INTERACTIONS\n")
+	sb.WriteString(roffEscape("Flags are checked before any work starts. The combinations below are rejected (errors) or have no effect (warnings).") + "\n")
+	for i := range commandRegistry {
+		spec := &commandRegistry[i]
+		if len(spec.Rules) == 0 {
+			continue
+		}
+		fmt.Fprintf(&sb, ".SS %s\n", roffEscape(spec.title()))
+		for _, rule := range spec.Rules {
+			kind := "Warning"
+			if rule.Conflict {
+				kind = "Error"
+			}
+			fmt.Fprintf(&sb, ".IP \\(bu 2\n%s: %s.\n", kind, roffEscape(rule.Message))
+		}
+	}
+
+	sb.WriteString(".SH EXAMPLES\n")
+	for _, spec := range commandRegistry {
+		for _, example := range spec.Examples {
+			fmt.Fprintf(&sb, ".TP\n.B %s\n%s\n", roffEscape(example.Command), roffEscape(example.Description))
+		}
+	}
+
+	sb.WriteString(".SH FILES\n")
+	sb.WriteString(".TP\n.I ~/.gitaudit\nThe configuration file, a JSON object: the model endpoint and provider, the prompt policy and named profiles.\n")
+	sb.WriteString(".TP\n.I gitaudit.txt\n" + roffEscape("The report, written to the current directory unless -output names another path, or under $XDG_DATA_HOME/gitaudit with -no-repo-writes when the current directory is inside the audited repository.") + "\n")
+	sb.WriteString(".TP\n.I $XDG_CACHE_HOME/gitaudit/store\n" + roffEscape("The cache store of model info and OSV answers (~/.cache/gitaudit/store by default), shared by concurrent runs.") + "\n")
+
+	sb.WriteString(".SH ENVIRONMENT\n")
+	for _, env := range [][2]string{
+		{"HOME", "Locates the configuration file."},
+		{"XDG_CACHE_HOME", "Locates the cache store."},
+		{"XDG_DATA_HOME", "Locates the report with -no-repo-writes."},
+		{"NO_COLOR", "Disables the colors of gitaudit explain with -color auto."},
+	} {
+		fmt.Fprintf(&sb, ".TP\n.B %s\n%s\n", env[0], roffEscape(env[1]))
+	}
+	sb.WriteString(".PP\n" + roffEscape("Hosted providers read their API key from the variable named by api_key_env in the configuration file.") + "\n")
+
+	sb.WriteString(".SH SEE ALSO\n.BR git (1),\n.BR git\\-rev\\-parse (1),\n.BR git\\-format\\-patch (1)\n")
+	return sb.String()
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// maxBranchSubjects caps how many branch commit subjects are added to a merge commit's prompt.
+const maxBranchSubjects = 50
+
+// mergeTopology describes the branch structure of the audited range.
+type mergeTopology struct {
+	// Parents maps every audited commit to its parent hashes, first parent first.
+	Parents map[string][]string
+	// Group maps a commit brought in by a merge (i.e. not on the merge's first-parent line)
+	// to the hash of the innermost merge commit in the range that brought it in.
+	Group map[string]string
+	// Branch maps each merge commit to the audited commits it brought in, newest first.
+	Branch map[string][]string
+}
+
+// isMerge reports whether hash has more than one parent.
+func (t *mergeTopology) isMerge(hash string) bool {
+	return t != nil && len(t.Parents[hash]) > 1
+}
+
+// getMergeTopology reconstructs the merge structure of the audited commits, which are
+// expected newest first as returned by getCommitHashes.
+func getMergeTopology(repoPath string, commitHashes []string) (*mergeTopology, error) {
+	topology := &mergeTopology{
+		Parents: make(map[string][]string, len(commitHashes)),
+		Group:   make(map[string]string),
+		Branch:  make(map[string][]string),
+	}
+	if len(commitHashes) == 0 {
+		return topology, nil
+	}
+
+	output, err := gitRunInput(context.Background(), repoPath, strings.NewReader(strings.Join(commitHashes, "\n")+"\n"), "rev-list", "--parents", "--no-walk=unsorted", "--stdin")
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute git rev-list --parents: %w", err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 {
+			topology.Parents[fields[0]] = fields[1:]
+		}
+	}
+
+	inRange := make(map[string]bool, len(commitHashes))
+	for _, hash := range commitHashes {
+		inRange[hash] = true
+	}
+	// Oldest merges first, so a commit belongs to the innermost merge that brought it in
+	// (e.g. a sub-topic merged into a topic branch, which was then merged into main).
+	for i := len(commitHashes) - 1; i >= 0; i-- {
+		merge := commitHashes[i]
+		parents := topology.Parents[merge]
+		if len(parents) < 2 {
+			continue
+		}
+		branch, err := getBranchCommits(repoPath, parents)
+		if err != nil {
+			return nil, err
+		}
+		for _, hash := range branch {
+			if !inRange[hash] {
+				continue
+			}
+			topology.Branch[merge] = append(topology.Branch[merge], hash)
+			if _, grouped := topology.Group[hash]; !grouped {
+				topology.Group[hash] = merge
+			}
+		}
+	}
+	return topology, nil
+}
+
+// getBranchCommits lists the commits a merge brought in: those reachable from its other
+// parents but not from its first parent. Octopus merges simply contribute all their parents.
+func getBranchCommits(repoPath string, parents []string) ([]string, error) {
+	args := append([]string{"rev-list"}, parents[1:]...)
+	args = append(args, "--not", parents[0])
+	output, err := gitRun(context.Background(), repoPath, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list branch commits of merge parents %s: %w", strings.Join(parents, " "), err)
+	}
+	return strings.Fields(string(output)), nil
+}
+
+// dropMerges removes the commits with more than one parent from hashes, keeping their order
+// (-no-merges).
+func dropMerges(repoPath string, hashes []string) ([]string, error) {
+	if len(hashes) == 0 {
+		return hashes, nil
+	}
+	output, err := gitRunInput(context.Background(), repoPath, strings.NewReader(strings.Join(hashes, "\n")+"\n"), "rev-list", "--no-walk=unsorted", "--no-merges", "--stdin")
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute git rev-list --no-merges: %w", err)
+	}
+	return strings.Fields(string(output)), nil
+}
+
+// getCommitSubjects returns "<short hash> <subject>" lines for the given commits, in order.
+func getCommitSubjects(ctx context.Context, repoPath string, hashes []string) ([]string, error) {
+	output, err := gitRunInput(ctx, repoPath, strings.NewReader(strings.Join(hashes, "\n")+"\n"), "log", "--no-walk=unsorted", "--format=%h %s", "--stdin")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list commit subjects: %w", err)
+	}
+	return strings.Split(strings.TrimSpace(string(output)), "\n"), nil
+}
+
+// mergeHint builds the prompt hint listing the commits a merge brought in, so the model can
+// describe the merged branch as a whole. Their subjects are only listed with sendSubjects,
+// since they are part of the original commit messages.
+func mergeHint(ctx context.Context, repoPath string, topology *mergeTopology, merge string, sendSubjects bool) (string, error) {
+	branch := topology.Branch[merge]
+	if len(branch) == 0 {
+		return "", nil
+	}
+	from := "a branch"
+	if parents := topology.Parents[merge]; len(parents) > 2 {
+		from = fmt.Sprintf("%d branches (an octopus merge)", len(parents)-1)
+	}
+	if !sendSubjects {
+		return fmt.Sprintf("This is a merge commit that brings in %d commits from %s; describe the merged work as a whole.", len(branch), from), nil
+	}
+	shown := branch[:min(len(branch), maxBranchSubjects)]
+	subjects, err := getCommitSubjects(ctx, repoPath, shown)
+	if err != nil {
+		return "", err
+	}
+	hint := fmt.Sprintf("This is a merge commit that brings in %d commits from %s; describe the merged work as a whole. Their subjects, newest first:\n  %s",
+		len(branch), from, strings.Join(subjects, "\n  "))
+	if len(branch) > len(shown) {
+		hint += fmt.Sprintf("\n  (and %d more)", len(branch)-len(shown))
+	}
+	return hint, nil
+}
+
+// shortHash abbreviates a commit hash for headers.
+func shortHash(hash string) string {
+	if len(hash) > 8 {
+		return hash[:8]
+	}
+	return hash
+}
+
+// reportItem is an entry placed in the report together with its merge nesting depth.
+type reportItem struct {
+	Entry CommitAuditData
+	Depth int
+	// GroupHeader, when set, makes this item the header line introducing a merge group
+	// instead of an entry.
+	GroupHeader string
+}
+
+// arrangeByMerge orders entries so that commits brought in by a merge follow that merge's
+// entry, one level deeper. Entries without a merge group (including every entry of a linear
+// history) keep their order at depth 0, as do entries whose merge commit has no entry.
+func arrangeByMerge(entries []CommitAuditData) []reportItem {
+	present := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.Kind != kindTag {
+			present[entry.Hash] = true
+		}
+	}
+	children := make(map[string][]CommitAuditData)
+	var roots []CommitAuditData
+	for _, entry := range entries {
+		if entry.MergeGroup != "" && present[entry.MergeGroup] && entry.MergeGroup != entry.Hash {
+			children[entry.MergeGroup] = append(children[entry.MergeGroup], entry)
+		} else {
+			roots = append(roots, entry)
+		}
+	}
+
+	var items []reportItem
+	var emit func(entry CommitAuditData, depth int)
+	emit = func(entry CommitAuditData, depth int) {
+		items = append(items, reportItem{Entry: entry, Depth: depth})
+		if entry.Kind == kindTag {
+			return
+		}
+		group := children[entry.Hash]
+		delete(children, entry.Hash)
+		for i, child := range group {
+			if i == 0 {
+				items = append(items, reportItem{Depth: depth + 1, GroupHeader: mergeGroupHeader(entry, group)})
+			}
+			emit(child, depth+1)
+		}
+	}
+	for _, entry := range roots {
+		emit(entry, 0)
+	}
+	return items
+}
+
+// mergeGroupHeader renders the line introducing the commits brought in by a merge. Octopus
+// merges list all of their parents.
+func mergeGroupHeader(merge CommitAuditData, group []CommitAuditData) string {
+	count := 0
+	for _, entry := range group {
+		if entry.Kind != kindTag {
+			count++
+		}
+	}
+	commits := msgCount("count.commits", count)
+	if len(merge.Parents) > 2 {
+		parents := make([]string, len(merge.Parents))
+		for i, parent := range merge.Parents {
+			parents[i] = shortHash(parent)
+		}
+		return msg("merge.group_octopus", shortHash(merge.Hash), strings.Join(parents, ", "), commits) + "\n"
+	}
+	return msg("merge.group", shortHash(merge.Hash), commits) + "\n"
+}
+
+// indentLines indents every non-empty line of text by depth levels of four spaces.
+func indentLines(text string, depth int) string {
+	if depth == 0 {
+		return text
+	}
+	prefix := strings.Repeat("    ", depth)
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = prefix + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
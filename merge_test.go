@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// mergeFixture is a history with two topic branches merged into main, the second through a
+// nested sub-topic, and an octopus merge of two more:
+//
+//	base - a1 - a2 ------------ m1 - main1 -------------------- oct
+//	   \        /                  \    \                       /|
+//	    topicA                      t1 - (s1 merged as m2) - m3 / |
+//	                                                  b1 ------+  |
+//	                                                  c1 ---------+
+type mergeFixture struct {
+	Repo                                *fixtureRepo
+	Base, A1, A2, M1, Main1             string
+	T1, S1, M2, T2, M3, B1, C1, Octopus string
+}
+
+func newMergeFixture(t *testing.T) *mergeFixture {
+	r := newFixtureRepo(t)
+	f := &mergeFixture{Repo: r}
+	f.Base = r.commit("Initial layout", map[string]string{"README": "base\n"})
+
+	r.git("checkout", "-q", "-b", "topic-a")
+	f.A1 = r.commit("Add the tokenizer", map[string]string{"lex/token.go": "package lex\n"})
+	f.A2 = r.commit("Add the scanner", map[string]string{"lex/scan.go": "package lex\n"})
+	r.git("checkout", "-q", "main")
+	f.M1 = r.merge("Merge branch 'topic-a'", "topic-a")
+	f.Main1 = r.commit("Update the README", map[string]string{"README": "base\nmore\n"})
+
+	r.git("checkout", "-q", "-b", "topic-t")
+	f.T1 = r.commit("Start the parser", map[string]string{"parse/parse.go": "package parse\n"})
+	r.git("checkout", "-q", "-b", "sub-s")
+	f.S1 = r.commit("Parse expressions", map[string]string{"parse/expr.go": "package parse\n"})
+	r.git("checkout", "-q", "topic-t")
+	f.M2 = r.merge("Merge branch 'sub-s' into topic-t", "sub-s")
+	f.T2 = r.commit("Parse statements", map[string]string{"parse/stmt.go": "package parse\n"})
+	r.git("checkout", "-q", "main")
+	f.M3 = r.merge("Merge branch 'topic-t'", "topic-t")
+
+	r.git("checkout", "-q", "-b", "topic-b")
+	f.B1 = r.commit("Add docs", map[string]string{"docs/a.md": "a\n"})
+	r.git("checkout", "-q", "-b", "topic-c", "main")
+	f.C1 = r.commit("Add examples", map[string]string{"examples/a.go": "package examples\n"})
+	r.git("checkout", "-q", "main")
+	f.Octopus = r.merge("Merge branches 'topic-b' and 'topic-c'", "topic-b", "topic-c")
+	return f
+}
+
+func TestGetMergeTopology(t *testing.T) {
+	f := newMergeFixture(t)
+	hashes, err := getCommitHashes(context.Background(), f.Repo.Dir, f.Octopus, f.Base, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	topology, err := getMergeTopology(f.Repo.Dir, hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := topology.Parents[f.Octopus]; !reflect.DeepEqual(got, []string{f.M3, f.B1, f.C1}) {
+		t.Errorf("octopus parents = %v", got)
+	}
+	if got := topology.Parents[f.Base]; len(got) != 0 {
+		t.Errorf("root commit parents = %v", got)
+	}
+	wantGroup := map[string]string{
+		f.A1: f.M1, f.A2: f.M1,
+		// The sub-topic commit belongs to the innermost merge that brought it in.
+		f.T1: f.M3, f.S1: f.M2, f.M2: f.M3, f.T2: f.M3,
+		f.B1: f.Octopus, f.C1: f.Octopus,
+	}
+	if !reflect.DeepEqual(topology.Group, wantGroup) {
+		t.Errorf("groups = %v, want %v", topology.Group, wantGroup)
+	}
+	if got := topology.Branch[f.M3]; len(got) != 4 {
+		t.Errorf("topic-t merge brought in %v, want 4 commits", got)
+	}
+	for _, hash := range []string{f.Base, f.Main1, f.A1} {
+		if topology.isMerge(hash) {
+			t.Errorf("%s reported as a merge", hash)
+		}
+	}
+	if !topology.isMerge(f.M1) || !topology.isMerge(f.Octopus) {
+		t.Error("merge commits not reported as merges")
+	}
+}
+
+func TestArrangeByMergeLinear(t *testing.T) {
+	entries := []CommitAuditData{{Kind: kindCommit, Hash: "c3"}, {Kind: kindTag, Hash: "c2", Ref: "v1"}, {Kind: kindCommit, Hash: "c2"}, {Kind: kindCommit, Hash: "c1"}}
+	items := arrangeByMerge(entries)
+	if len(items) != len(entries) {
+		t.Fatalf("%d items for %d entries", len(items), len(entries))
+	}
+	for i, item := range items {
+		if item.Depth != 0 || item.GroupHeader != "" || !reflect.DeepEqual(item.Entry, entries[i]) {
+			t.Errorf("item %d = %+v", i, item)
+		}
+	}
+}
+
+func TestArrangeByMergeNests(t *testing.T) {
+	entries := []CommitAuditData{
+		{Kind: kindCommit, Hash: "oct", Parents: []string{"m", "b", "c"}},
+		{Kind: kindCommit, Hash: "c", MergeGroup: "oct"},
+		{Kind: kindCommit, Hash: "b", MergeGroup: "oct"},
+		{Kind: kindCommit, Hash: "m", Parents: []string{"base", "t"}},
+		{Kind: kindCommit, Hash: "t", MergeGroup: "m"},
+		{Kind: kindCommit, Hash: "s", MergeGroup: "t"},
+		// The merge that brought this one in is outside the report.
+		{Kind: kindCommit, Hash: "x", MergeGroup: "gone"},
+		{Kind: kindCommit, Hash: "base"},
+	}
+	var got []string
+	for _, item := range arrangeByMerge(entries) {
+		line := strings.Repeat(".", item.Depth)
+		if item.GroupHeader != "" {
+			line += strings.TrimSpace(item.GroupHeader)
+		} else {
+			line += item.Entry.Hash
+		}
+		got = append(got, line)
+	}
+	want := []string{
+		"oct", ".merged via oct, an octopus merge of m, b, c (2 commits):", ".c", ".b",
+		"m", ".merged via m (1 commit):", ".t", "..merged via t (1 commit):", "..s",
+		"x", "base",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("arrangement:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestMergeReport(t *testing.T) {
+	f := newMergeFixture(t)
+	env := newAuditEnv(t)
+	report := filepath.Join(env.Work, "report.txt")
+	jsonPath := filepath.Join(env.Work, "report.json")
+	env.mustRun("-repo", f.Repo.Dir, "-commit", "root", "-out", "text="+report, "-out", "json="+jsonPath)
+
+	// The merge prompts list the subjects of the commits they brought in.
+	var m1Prompt, octopusPrompt string
+	for _, prompt := range env.Ollama.Prompts() {
+		switch {
+		case strings.Contains(prompt, "Merge branch 'topic-a'"):
+			m1Prompt = prompt
+		case strings.Contains(prompt, "Merge branches 'topic-b' and 'topic-c'"):
+			octopusPrompt = prompt
+		}
+	}
+	if !strings.Contains(m1Prompt, "brings in 2 commits from a branch") || !strings.Contains(m1Prompt, f.A2[:7]+" Add the scanner") || !strings.Contains(m1Prompt, f.A1[:7]+" Add the tokenizer") {
+		t.Errorf("topic-a merge prompt lacks its branch subjects:\n%s", m1Prompt)
+	}
+	if !strings.Contains(octopusPrompt, "2 commits from 2 branches (an octopus merge)") || !strings.Contains(octopusPrompt, "Add docs") || !strings.Contains(octopusPrompt, "Add examples") {
+		t.Errorf("octopus prompt lacks its branch subjects:\n%s", octopusPrompt)
+	}
+
+	text := readFile(t, report)
+	for _, want := range []string{
+		"    merged via " + shortHash(f.M1) + " (2 commits):\n",
+		"    merged via " + shortHash(f.M3) + " (3 commits):\n",
+		"        merged via " + shortHash(f.M2) + " (1 commit):\n",
+		"    merged via " + shortHash(f.Octopus) + ", an octopus merge of " + shortHash(f.M3) + ", " + shortHash(f.B1) + ", " + shortHash(f.C1) + " (2 commits):\n",
+		"    Commit: " + f.A1,
+		"        Commit: " + f.S1,
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("report lacks %q:\n%s", want, text)
+		}
+	}
+	if !strings.Contains(text, "\nCommit: "+f.Main1) && !strings.HasPrefix(text, "Commit: "+f.Main1) {
+		t.Errorf("first-parent commit %s is indented:\n%s", f.Main1, text)
+	}
+
+	var parsed jsonReport
+	if err := json.Unmarshal([]byte(readFile(t, jsonPath)), &parsed); err != nil {
+		t.Fatal(err)
+	}
+	byHash := make(map[string]CommitAuditData)
+	for _, entry := range parsed.Commits {
+		byHash[entry.Hash] = entry
+	}
+	if e := byHash[f.Octopus]; !reflect.DeepEqual(e.Parents, []string{f.M3, f.B1, f.C1}) || e.MergeGroup != "" {
+		t.Errorf("octopus entry = parents %v, group %q", e.Parents, e.MergeGroup)
+	}
+	if e := byHash[f.S1]; e.MergeGroup != f.M2 || !reflect.DeepEqual(e.Parents, []string{f.T1}) {
+		t.Errorf("sub-topic entry = parents %v, group %q", e.Parents, e.MergeGroup)
+	}
+	if e := byHash[f.Main1]; e.MergeGroup != "" {
+		t.Errorf("first-parent entry has group %q", e.MergeGroup)
+	}
+}
+
+func TestLinearReportHasNoMergeGroups(t *testing.T) {
+	repo := newFixtureRepo(t)
+	repo.commits(4)
+	env := newAuditEnv(t)
+	report := filepath.Join(env.Work, "report.txt")
+	env.mustRun("-repo", repo.Dir, "-commit", "root", "-output", report)
+	text := readFile(t, report)
+	if strings.Contains(text, "merged via") || strings.Contains(text, "\n    Commit:") || strings.Contains(text, "brings in") {
+		t.Errorf("linear report has merge groups:\n%s", text)
+	}
+	for _, prompt := range env.Ollama.Prompts() {
+		if strings.Contains(prompt, "merge commit") {
+			t.Errorf("linear prompt mentions a merge:\n%s", prompt)
+		}
+	}
+}
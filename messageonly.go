@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// commitStats is the lightweight description of a commit used by -message-only mode.
+type commitStats struct {
+	// Summary is the final diffstat line, e.g. "3 files changed, 10 insertions(+), 2 deletions(-)".
+	Summary string
+	// Files lists the changed paths with their status letter, e.g. "M\tmain.go".
+	Files []string
+}
+
+// getCommitStats returns the diffstat summary line and name-status file list for a commit.
+// When base is non-empty the stats are computed against it instead of the commit's parent;
+// a pathspec limits them to the matching paths.
+func getCommitStats(ctx context.Context, repoPath, commitHash, base string, pathspec []string) (commitStats, error) {
+	var stats commitStats
+
+	statArgs := []string{"show", "--format=", "--shortstat", commitHash}
+	filesArgs := []string{"show", "--format=", "--name-status", commitHash}
+	if base != "" {
+		statArgs = []string{"diff", "--shortstat", base, commitHash}
+		filesArgs = []string{"diff", "--name-status", base, commitHash}
+	}
+	if len(pathspec) > 0 {
+		statArgs = append(append(statArgs, "--"), pathspec...)
+		filesArgs = append(append(filesArgs, "--"), pathspec...)
+	}
+
+	output, err := gitRun(ctx, repoPath, statArgs...)
+	if err != nil {
+		return stats, fmt.Errorf("failed to compute diffstat for commit %s: %w", commitHash, err)
+	}
+	stats.Summary = strings.TrimSpace(string(output))
+
+	output, err = gitRun(ctx, repoPath, filesArgs...)
+	if err != nil {
+		return stats, fmt.Errorf("failed to list changed files for commit %s: %w", commitHash, err)
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			stats.Files = append(stats.Files, line)
+		}
+	}
+	return stats, nil
+}
+
+// buildMessageOnlyPrompt assembles the prompt used by -message-only mode, which describes a
+// commit from its original message and diffstat without sending the diff itself.
+func buildMessageOnlyPrompt(message string, stats commitStats, extras promptExtras) string {
+	statSummary := stats.Summary
+	if statSummary == "" {
+		statSummary = "(no file changes)"
+	}
+	fileList := strings.Join(stats.Files, "\n")
+	if fileList == "" {
+		fileList = "(none)"
+	}
+
+	return fmt.Sprintf(`Given the following original Git commit message, diffstat and list of changed files, please rewrite the message as a detailed, audit-ready Git commit message. The full diff is intentionally not provided, so do not speculate about specific code changes beyond what the message and file list support. The message should cover:
+1. A summary of the changes.
+2. The reasoning behind the changes (why they were made), as far as the original message states it.
+3. The areas of the codebase affected, based on the file list.
+4. The intended purpose or goal of the commit.
+
+Do not include any introductory phrases like "Here's a commit message:". Output only the commit message itself.
+%s
+Original message:
+%s
+
+Diffstat:
+%s
+
+Changed files (status and path):
+%s`, extras.render(), message, statSummary, fileList)
+}
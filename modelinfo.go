@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultModelInfoTTL is how long cached model info is reused when model_info_ttl is unset.
+const defaultModelInfoTTL = 24 * time.Hour
+
+// defaultContextSize is the context window assumed when it cannot be discovered and the config
+// does not set default_context_size. It matches Ollama's own default num_ctx.
+const defaultContextSize = 4096
+
+// modelInfo describes the Ollama model build used for a run, as reported by /api/show and
+// /api/tags. Fields Ollama does not report are left empty.
+type modelInfo struct {
+	Model         string `json:"model"`
+	Digest        string `json:"digest,omitempty"`
+	ContextLength int    `json:"context_length,omitempty"`
+	ParameterSize string `json:"parameter_size,omitempty"`
+	Quantization  string `json:"quantization,omitempty"`
+	Family        string `json:"family,omitempty"`
+}
+
+// String renders the model build for the run header.
+func (m modelInfo) String() string {
+	var details []string
+	if m.Digest != "" {
+		details = append(details, "digest "+shortHash(m.Digest))
+	}
+	if m.ParameterSize != "" {
+		details = append(details, m.ParameterSize+" parameters")
+	}
+	if m.Quantization != "" {
+		details = append(details, m.Quantization)
+	}
+	if m.ContextLength > 0 {
+		details = append(details, "context "+formatTokens(m.ContextLength))
+	}
+	if len(details) == 0 {
+		return m.Model
+	}
+	return fmt.Sprintf("%s (%s)", m.Model, strings.Join(details, ", "))
+}
+
+// ollamaShowResponse is the subset of the /api/show response gitaudit uses.
+type ollamaShowResponse struct {
+	Parameters string `json:"parameters"`
+	Details    struct {
+		Family            string `json:"family"`
+		ParameterSize     string `json:"parameter_size"`
+		QuantizationLevel string `json:"quantization_level"`
+	} `json:"details"`
+	ModelInfo map[string]any `json:"model_info"`
+}
+
+// ollamaTagsResponse is the subset of the /api/tags response gitaudit uses.
+type ollamaTagsResponse struct {
+	Models []ollamaTag `json:"models"`
+}
+
+// ollamaTag is one installed model of an /api/tags response.
+type ollamaTag struct {
+	Name   string `json:"name"`
+	Digest string `json:"digest"`
+	// Size is the model's size on disk in bytes.
+	Size    int64 `json:"size"`
+	Details struct {
+		Family        string `json:"family"`
+		ParameterSize string `json:"parameter_size"`
+	} `json:"details"`
+}
+
+// ollamaBaseURL derives the server's base URL from the configured generate endpoint, e.g.
+// http://localhost:11434 from http://localhost:11434/api/generate (or the chat API's
+// /api/chat, a common misconfiguration).
+func ollamaBaseURL(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("invalid Ollama endpoint %q", endpoint)
+	}
+	path := strings.TrimSuffix(u.Path, "/")
+	for _, api := range []string{ollamaGeneratePath, "/api/chat"} {
+		path = strings.TrimSuffix(path, api)
+	}
+	return u.Scheme + "://" + u.Host + path, nil
+}
+
+// parseShowResponse extracts the model details from an /api/show payload. The context length
+// is the num_ctx parameter when the model sets one, since that is what Ollama actually uses,
+// and otherwise the architecture's trained context length.
+func parseShowResponse(model string, body []byte) (modelInfo, error) {
+	var show ollamaShowResponse
+	if err := json.Unmarshal(body, &show); err != nil {
+		return modelInfo{}, fmt.Errorf("failed to decode /api/show response: %w", err)
+	}
+	info := modelInfo{
+		Model:         model,
+		ParameterSize: show.Details.ParameterSize,
+		Quantization:  show.Details.QuantizationLevel,
+		Family:        show.Details.Family,
+	}
+	for _, line := range strings.Split(show.Parameters, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "num_ctx" {
+			if n, err := strconv.Atoi(fields[1]); err == nil && n > 0 {
+				info.ContextLength = n
+				return info, nil
+			}
+		}
+	}
+	if arch, ok := show.ModelInfo["general.architecture"].(string); ok {
+		if n, ok := show.ModelInfo[arch+".context_length"].(float64); ok {
+			info.ContextLength = int(n)
+			return info, nil
+		}
+	}
+	for key, value := range show.ModelInfo {
+		if n, ok := value.(float64); ok && strings.HasSuffix(key, ".context_length") {
+			info.ContextLength = int(n)
+			break
+		}
+	}
+	return info, nil
+}
+
+// fetchModelInfo queries /api/show and /api/tags for a model. A missing digest is not an error.
+func fetchModelInfo(endpoint, model string) (modelInfo, error) {
+	base, err := ollamaBaseURL(endpoint)
+	if err != nil {
+		return modelInfo{}, err
+	}
+	reqBody, err := json.Marshal(map[string]string{"model": model})
+	if err != nil {
+		return modelInfo{}, fmt.Errorf("failed to marshal /api/show request: %w", err)
+	}
+	resp, err := modelInfoClient.Post(base+"/api/show", "application/json", bytes.NewReader(reqBody))
+	if err != nil {
+		return modelInfo{}, fmt.Errorf("failed to query %s/api/show: %w", base, err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return modelInfo{}, fmt.Errorf("failed to read /api/show response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return modelInfo{}, fmt.Errorf("/api/show for model %s failed with status %s: %s", model, resp.Status, strings.TrimSpace(string(body)))
+	}
+	info, err := parseShowResponse(model, body)
+	if err != nil {
+		return modelInfo{}, err
+	}
+
+	if resp, err := modelInfoClient.Get(base + "/api/tags"); err == nil {
+		var tags ollamaTagsResponse
+		if resp.StatusCode == http.StatusOK && json.NewDecoder(resp.Body).Decode(&tags) == nil {
+			for _, m := range tags.Models {
+				if m.Name == model || m.Name == model+":latest" {
+					info.Digest = m.Digest
+					break
+				}
+			}
+		}
+		resp.Body.Close()
+	}
+	return info, nil
+}
+
+// getModelInfo returns the info for a model, from the cache store when it was fetched less
+// than ttl ago and from Ollama otherwise. A cache that cannot be read or written only costs a
+// query.
+func getModelInfo(endpoint, model string, ttl time.Duration, store *cacheStore) (modelInfo, error) {
+	key := cacheKey{Kind: cacheKindModelInfo, Model: model, Digest: endpoint}
+	if data, ok := store.Get(key, ttl); ok {
+		var cached modelInfo
+		if json.Unmarshal(data, &cached) == nil {
+			debugf("model info for %s from cache", model)
+			return cached, nil
+		}
+	}
+
+	info, err := fetchModelInfo(endpoint, model)
+	if err != nil {
+		return modelInfo{}, err
+	}
+	data, err := json.Marshal(info)
+	if err == nil {
+		err = store.Put(key, data)
+	}
+	if err != nil {
+		fmt.Printf("Warning: failed to cache model info: %v\n", err)
+	}
+	return info, nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseShowResponse(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    modelInfo
+	}{
+		// The architecture's trained context length.
+		{"llama3.1-8b.json", modelInfo{Model: "m", ContextLength: 131072, ParameterSize: "8.0B", Quantization: "Q4_K_M", Family: "llama"}},
+		// num_ctx in the Modelfile wins over the trained context length.
+		{"qwen2.5-coder-num-ctx.json", modelInfo{Model: "m", ContextLength: 16384, ParameterSize: "7.6B", Quantization: "Q4_K_M", Family: "qwen2"}},
+		// Older servers send no model_info; the context length is unknown.
+		{"codellama-old-server.json", modelInfo{Model: "m", ParameterSize: "7B", Quantization: "Q4_0", Family: "llama"}},
+		// Without general.architecture, any *.context_length will do.
+		{"unknown-architecture.json", modelInfo{Model: "m", ContextLength: 8192, ParameterSize: "9.2B", Quantization: "Q4_0", Family: "gemma2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			body, err := os.ReadFile(filepath.Join("testdata", "modelinfo", tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := parseShowResponse("m", body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("parseShowResponse = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+	if _, err := parseShowResponse("m", []byte("<html>502 Bad Gateway</html>")); err == nil {
+		t.Error("parseShowResponse accepted an HTML page")
+	}
+}
+
+func TestOllamaBaseURL(t *testing.T) {
+	tests := map[string]string{
+		"http://localhost:11434/api/generate":     "http://localhost:11434",
+		"http://localhost:11434/api/generate/":    "http://localhost:11434",
+		"http://localhost:11434/api/chat":         "http://localhost:11434",
+		"https://gpu.example/ollama/api/generate": "https://gpu.example/ollama",
+		"http://localhost:11434":                  "http://localhost:11434",
+	}
+	for endpoint, want := range tests {
+		if got, err := ollamaBaseURL(endpoint); err != nil || got != want {
+			t.Errorf("ollamaBaseURL(%q) = %q, %v; want %q", endpoint, got, err, want)
+		}
+	}
+	for _, endpoint := range []string{"localhost:11434/api/generate", "/api/generate", "://x"} {
+		if _, err := ollamaBaseURL(endpoint); err == nil {
+			t.Errorf("ollamaBaseURL(%q) accepted an invalid endpoint", endpoint)
+		}
+	}
+}
+
+func TestModelInfoString(t *testing.T) {
+	info := modelInfo{Model: "llama3.1:8b", Digest: "42182419e9508c30c4b1fe55015f06b65f4ca4b9e28a744be55008d21998a093", ParameterSize: "8.0B", Quantization: "Q4_K_M", ContextLength: 131072}
+	if got, want := info.String(), "llama3.1:8b (digest 42182419, 8.0B parameters, Q4_K_M, context 131,072 tokens)"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	if got := (modelInfo{Model: "bare"}).String(); got != "bare" {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+// modelInfoServer serves a fixture as /api/show and counts the requests per path.
+type modelInfoServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests map[string]int
+}
+
+func newModelInfoServer(t *testing.T, fixture string) *modelInfoServer {
+	t.Helper()
+	show, err := os.ReadFile(filepath.Join("testdata", "modelinfo", fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &modelInfoServer{requests: make(map[string]int)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests[r.URL.Path]++
+		s.mu.Unlock()
+		switch r.URL.Path {
+		case "/api/show":
+			w.Write(show)
+		case "/api/tags":
+			io.WriteString(w, `{"models":[{"name":"llama3.1:8b","digest":"42182419e9508c30c4b1fe55015f06b65f4ca4b9e28a744be55008d21998a093"},{"name":"other:latest","digest":"ffff"}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *modelInfoServer) count(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[path]
+}
+
+func TestGetModelInfoCaches(t *testing.T) {
+	server := newModelInfoServer(t, "llama3.1-8b.json")
+	store := &cacheStore{Dir: t.TempDir(), started: time.Now()}
+	endpoint := server.URL + "/api/generate"
+
+	for i := 0; i < 3; i++ {
+		info, err := getModelInfo(endpoint, "llama3.1:8b", time.Hour, store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.ContextLength != 131072 || info.Digest != "42182419e9508c30c4b1fe55015f06b65f4ca4b9e28a744be55008d21998a093" {
+			t.Fatalf("info = %+v", info)
+		}
+	}
+	if n := server.count("/api/show"); n != 1 {
+		t.Errorf("/api/show queried %d times for one (endpoint, model), want 1", n)
+	}
+
+	// Another model, or the same model behind another endpoint, is looked up on its own.
+	if info, err := getModelInfo(endpoint, "other", time.Hour, store); err != nil || info.Digest != "ffff" {
+		t.Errorf("other model: %+v, %v", info, err)
+	}
+	other := newModelInfoServer(t, "llama3.1-8b.json")
+	if _, err := getModelInfo(other.URL+"/api/generate", "llama3.1:8b", time.Hour, store); err != nil {
+		t.Fatal(err)
+	}
+	if server.count("/api/show") != 2 || other.count("/api/show") != 1 {
+		t.Errorf("/api/show queried %d and %d times, want 2 and 1", server.count("/api/show"), other.count("/api/show"))
+	}
+
+	// An expired entry is fetched again; without a store every call queries.
+	time.Sleep(10 * time.Millisecond)
+	getModelInfo(endpoint, "llama3.1:8b", time.Millisecond, store)
+	getModelInfo(endpoint, "llama3.1:8b", time.Hour, nil)
+	if n := server.count("/api/show"); n != 4 {
+		t.Errorf("/api/show queried %d times, want 4", n)
+	}
+}
+
+func TestGetModelInfoErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"model 'nope' not found"}`, http.StatusNotFound)
+	}))
+	defer server.Close()
+	if _, err := getModelInfo(server.URL+"/api/generate", "nope", time.Hour, nil); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("err = %v, want the server's error", err)
+	}
+}
+
+func TestModelInfoInRunHeader(t *testing.T) {
+	repo := newFixtureRepo(t)
+	repo.commits(1)
+	env := newAuditEnv(t)
+	env.Config["auto_context_size"] = true
+
+	show, err := os.ReadFile(filepath.Join("testdata", "modelinfo", "qwen2.5-coder-num-ctx.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.Ollama.Show = string(show)
+	out := env.mustRun("-repo", repo.Dir, "-commit", "root", "-output", filepath.Join(env.Work, "a.txt"))
+	if !strings.Contains(out, "Model Build: tiny:0.5b (digest a8b0c515, 7.6B parameters, Q4_K_M, context 16,384 tokens)") {
+		t.Errorf("run header lacks the model build:\n%s", out)
+	}
+	if !strings.Contains(out, "Prompt Budget: 16,384 tokens") {
+		t.Errorf("prompt budget not sized from num_ctx:\n%s", out)
+	}
+	if strings.Contains(out, "context size of model") {
+		t.Errorf("known context size reported as unknown:\n%s", out)
+	}
+
+	// A model that does not report its context falls back to default_context_size. The cached
+	// info from the first run is bypassed with a fresh cache directory.
+	show, err = os.ReadFile(filepath.Join("testdata", "modelinfo", "codellama-old-server.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.Ollama.Show = string(show)
+	env.Config["default_context_size"] = 8192
+	env.Env = []string{"XDG_CACHE_HOME=" + t.TempDir()}
+	out = env.mustRun("-repo", repo.Dir, "-commit", "root", "-output", filepath.Join(env.Work, "b.txt"))
+	if !strings.Contains(out, "Warning: the context size of model tiny:0.5b is unknown; assuming 8,192 tokens (default_context_size).") {
+		t.Errorf("no fallback warning:\n%s", out)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// notifyDesktop is the -notify value that sends desktop notifications.
+const notifyDesktop = "desktop"
+
+// notifier delivers a notification to the user. Delivery is best effort: errors are shown
+// with -debug and never affect the audit.
+type notifier interface {
+	Notify(title, message string) error
+}
+
+// progressReporter keeps the user informed about a long run: it shows the progress in the
+// terminal title when stdout is a terminal and, with -notify, sends a notification when the
+// run finishes, when it first stops on a permanent error and when no commit has completed for
+// StallAfter.
+type progressReporter struct {
+	Notifier   notifier // nil without -notify.
+	Repo       string
+	Total      int
+	StallAfter time.Duration
+
+	title   bool
+	mu      sync.Mutex
+	done    int
+	last    time.Time
+	stalled bool
+	failed  bool
+	paused  time.Time // End of the pause window the run is sleeping in, if any.
+	stop    chan struct{}
+}
+
+// newProgressReporter creates the reporter for a run of total commits in the named
+// repository and starts its stall watch.
+func newProgressReporter(mode, repo string, total int, stallAfter time.Duration) (*progressReporter, error) {
+	r := &progressReporter{Repo: repo, Total: total, StallAfter: stallAfter, last: time.Now(), stop: make(chan struct{})}
+	switch mode {
+	case "":
+	case notifyDesktop:
+		r.Notifier = newDesktopNotifier()
+	default:
+		return nil, fmt.Errorf("invalid -notify value %q: expected %q", mode, notifyDesktop)
+	}
+	if info, err := os.Stdout.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 && os.Getenv("TERM") != "dumb" {
+		r.title = true
+	}
+	if r.Notifier != nil && stallAfter > 0 {
+		go r.watchStalls()
+	}
+	r.setTitle()
+	return r, nil
+}
+
+// Update records the number of commits audited so far and, on the first permanent error
+// that stops the run, sends a notification.
+func (r *progressReporter) Update(done int, fatalErr error) {
+	r.mu.Lock()
+	if done != r.done {
+		r.done = done
+		r.last = time.Now()
+		r.stalled = false
+	}
+	notifyFailure := fatalErr != nil && !r.failed
+	if notifyFailure {
+		r.failed = true
+	}
+	r.mu.Unlock()
+
+	r.setTitle()
+	if notifyFailure {
+		r.notify("gitaudit stopped: "+r.Repo, fmt.Sprintf("%v. %d of %d commits audited; the report is written with those.", fatalErr, done, r.Total))
+	}
+}
+
+// Finish stops the stall watch and sends the completion notification.
+func (r *progressReporter) Finish(pending int, interrupted bool) {
+	close(r.stop)
+	r.mu.Lock()
+	done := r.done
+	r.mu.Unlock()
+	switch {
+	case interrupted:
+		r.notify("gitaudit interrupted: "+r.Repo, fmt.Sprintf("%d of %d commits audited, %d pending.", done, r.Total, pending))
+	default:
+		r.notify("gitaudit finished: "+r.Repo, fmt.Sprintf("%d of %d commits audited.", done, r.Total))
+	}
+}
+
+// SetPaused records that the run sleeps in a pause window until the given time, or with a
+// zero time, that it resumed. Time spent paused does not count towards a stall.
+func (r *progressReporter) SetPaused(until time.Time) {
+	r.mu.Lock()
+	r.paused = until
+	r.last = time.Now()
+	r.mu.Unlock()
+	r.setTitle()
+}
+
+// watchStalls notifies once each time no commit has completed for StallAfter.
+func (r *progressReporter) watchStalls() {
+	ticker := time.NewTicker(min(r.StallAfter/4, time.Minute))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+		}
+		r.mu.Lock()
+		idle := time.Since(r.last)
+		notifyStall := idle >= r.StallAfter && !r.stalled && r.paused.IsZero()
+		if notifyStall {
+			r.stalled = true
+		}
+		done := r.done
+		r.mu.Unlock()
+		if notifyStall {
+			r.notify("gitaudit stalled: "+r.Repo, fmt.Sprintf("No commit completed for %s; %d of %d commits audited.", formatDuration(idle.Truncate(time.Second)), done, r.Total))
+		}
+	}
+}
+
+// setTitle shows the progress in the terminal title, e.g. "gitaudit 412/1500 (27%)" or,
+// in a pause window, "gitaudit 412/1500 (27%, paused until 03:30)".
+func (r *progressReporter) setTitle() {
+	if !r.title || r.Total == 0 {
+		return
+	}
+	r.mu.Lock()
+	done, paused := r.done, r.paused
+	r.mu.Unlock()
+	state := fmt.Sprintf("%d%%", done*100/r.Total)
+	if !paused.IsZero() {
+		state += ", paused until " + paused.Format("15:04")
+	}
+	fmt.Printf("\033]0;gitaudit %d/%d (%s)\007", done, r.Total, state)
+}
+
+// notify sends a notification if -notify is set; a failure is only shown with -debug.
+func (r *progressReporter) notify(title, message string) {
+	if r.Notifier == nil {
+		return
+	}
+	if err := r.Notifier.Notify(title, message); err != nil {
+		debugf("failed to send notification: %v", err)
+	}
+}
@@ -0,0 +1,25 @@
+//go:build darwin
+
+package main
+
+import (
+	"context"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// commandNotifier sends notifications through Notification Center with osascript.
+type commandNotifier struct{}
+
+func newDesktopNotifier() notifier {
+	return commandNotifier{}
+}
+
+func (commandNotifier) Notify(title, message string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// strconv.Quote yields a valid AppleScript string literal for the text gitaudit sends.
+	script := "display notification " + strconv.Quote(message) + " with title " + strconv.Quote(title)
+	return exec.CommandContext(ctx, "osascript", "-e", script).Run()
+}
@@ -0,0 +1,23 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"os/exec"
+	"time"
+)
+
+// commandNotifier sends notifications with notify-send, which talks to the desktop's
+// notification daemon over D-Bus.
+type commandNotifier struct{}
+
+func newDesktopNotifier() notifier {
+	return commandNotifier{}
+}
+
+func (commandNotifier) Notify(title, message string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return exec.CommandContext(ctx, "notify-send", "--app-name=gitaudit", title, message).Run()
+}
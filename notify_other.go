@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+
+package main
+
+// nopNotifier is used where gitaudit has no way to show desktop notifications.
+type nopNotifier struct{}
+
+func newDesktopNotifier() notifier {
+	return nopNotifier{}
+}
+
+func (nopNotifier) Notify(title, message string) error {
+	return nil
+}
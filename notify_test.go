@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeNotifier records the notifications it is asked to send, failing them with err.
+type fakeNotifier struct {
+	mu   sync.Mutex
+	sent []string
+	err  error
+}
+
+func (f *fakeNotifier) Notify(title, message string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = append(f.sent, title+": "+message)
+	return f.err
+}
+
+func (f *fakeNotifier) Sent() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.sent...)
+}
+
+// testReporter is a progress reporter of total commits of the repository "billing" that
+// notifies fake.
+func testReporter(fake *fakeNotifier, total int, stallAfter time.Duration) *progressReporter {
+	r := &progressReporter{Notifier: fake, Repo: "billing", Total: total, StallAfter: stallAfter, last: time.Now(), stop: make(chan struct{})}
+	if stallAfter > 0 {
+		go r.watchStalls()
+	}
+	return r
+}
+
+func TestProgressNotifications(t *testing.T) {
+	fake := &fakeNotifier{}
+	r := testReporter(fake, 1500, 0)
+	r.Update(411, nil)
+	r.Update(412, errors.New("model not found"))
+	// Only the first permanent failure is notified.
+	r.Update(412, errors.New("model not found"))
+	r.Finish(1088, false)
+	want := []string{
+		"gitaudit stopped: billing: model not found. 412 of 1500 commits audited; the report is written with those.",
+		"gitaudit finished: billing: 412 of 1500 commits audited.",
+	}
+	if got := fake.Sent(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("notifications:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	fake = &fakeNotifier{}
+	r = testReporter(fake, 10, 0)
+	r.Update(4, nil)
+	r.Finish(6, true)
+	if got := fake.Sent(); len(got) != 1 || got[0] != "gitaudit interrupted: billing: 4 of 10 commits audited, 6 pending." {
+		t.Errorf("notifications of an interrupted run: %q", got)
+	}
+
+	// Without -notify nothing is sent, and a failing notifier changes nothing.
+	(&progressReporter{Repo: "billing", Total: 1, stop: make(chan struct{})}).Finish(0, false)
+	failing := &fakeNotifier{err: errors.New("no notification daemon")}
+	r = testReporter(failing, 2, 0)
+	r.Update(1, errors.New("budget exhausted"))
+	r.Finish(1, false)
+	if len(failing.Sent()) != 2 {
+		t.Errorf("failing notifier was asked %d times, want 2", len(failing.Sent()))
+	}
+}
+
+func TestStallNotification(t *testing.T) {
+	fake := &fakeNotifier{}
+	r := testReporter(fake, 3, 40*time.Millisecond)
+	r.Update(1, nil)
+	time.Sleep(150 * time.Millisecond)
+	// One notification per stall, however long it lasts.
+	sent := fake.Sent()
+	if len(sent) != 1 || !strings.HasPrefix(sent[0], "gitaudit stalled: billing: No commit completed for ") || !strings.HasSuffix(sent[0], "; 1 of 3 commits audited.") {
+		t.Fatalf("stall notifications: %q", sent)
+	}
+	// A completed commit ends the stall; the next one is notified again.
+	r.Update(2, nil)
+	time.Sleep(150 * time.Millisecond)
+	if n := len(fake.Sent()); n != 2 {
+		t.Errorf("%d notifications after the second stall, want 2", n)
+	}
+
+	// A pause window is not a stall.
+	r.SetPaused(time.Now().Add(time.Hour))
+	time.Sleep(150 * time.Millisecond)
+	r.Finish(1, false)
+	if sent := fake.Sent(); len(sent) != 3 || !strings.HasPrefix(sent[2], "gitaudit finished: ") {
+		t.Errorf("notifications while paused: %q", sent)
+	}
+}
+
+// captureStdout returns what f prints to stdout.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	read, write, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = write
+	defer func() { os.Stdout = stdout }()
+	f()
+	write.Close()
+	out, err := io.ReadAll(read)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestTerminalTitle(t *testing.T) {
+	r := &progressReporter{Repo: "billing", Total: 1500, title: true, stop: make(chan struct{})}
+	out := captureStdout(t, func() {
+		r.Update(412, nil)
+		r.SetPaused(time.Date(2024, 1, 1, 3, 30, 0, 0, time.Local))
+	})
+	if want := "\033]0;gitaudit 412/1500 (27%)\007\033]0;gitaudit 412/1500 (27%, paused until 03:30)\007"; out != want {
+		t.Errorf("titles %q, want %q", out, want)
+	}
+	// Not a terminal: no escape sequences in the output.
+	r.title = false
+	if out := captureStdout(t, func() { r.Update(413, nil) }); out != "" {
+		t.Errorf("title written to a pipe: %q", out)
+	}
+}
+
+func TestNewProgressReporterMode(t *testing.T) {
+	if _, err := newProgressReporter("email", "billing", 1, 0); err == nil || !strings.Contains(err.Error(), `invalid -notify value "email"`) {
+		t.Errorf("err = %v", err)
+	}
+	var r *progressReporter
+	captureStdout(t, func() {
+		var err error
+		r, err = newProgressReporter("", "billing", 1, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	if r.Notifier != nil || r.title {
+		t.Errorf("reporter without -notify on a pipe: %+v", r)
+	}
+	r.Finish(0, false)
+}
+
+func TestDesktopNotificationRun(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the desktop notifier of this platform does not run notify-send")
+	}
+	repo := newFixtureRepo(t)
+	repo.commits(2)
+	// A notify-send on PATH that logs its arguments, one per line, and then fails.
+	bin := t.TempDir()
+	log := filepath.Join(t.TempDir(), "notify.log")
+	script := "#!/bin/sh\nfor arg in \"$@\"; do printf '%s\\n' \"$arg\" >> " + log + "; done\nexit 1\n"
+	if err := os.WriteFile(filepath.Join(bin, "notify-send"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	env := newAuditEnv(t)
+	env.Env = append(env.Env, "PATH="+bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	env.Ollama.Delay = 300 * time.Millisecond
+
+	// The failing notifier does not fail the run.
+	out := env.mustRun("-repo", repo.Dir, "-commit", "root", "-output", filepath.Join(env.Work, "report.txt"), "-notify", "desktop", "-notify-stall", "100ms", "-debug")
+	if !strings.Contains(out, "failed to send notification: exit status 1") {
+		t.Errorf("notification failure not shown with -debug:\n%s", out)
+	}
+	sent := readFile(t, log)
+	name := filepath.Base(repo.Dir)
+	for _, want := range []string{
+		"--app-name=gitaudit\ngitaudit stalled: " + name + "\nNo commit completed for ",
+		"--app-name=gitaudit\ngitaudit finished: " + name + "\n2 of 2 commits audited.\n",
+	} {
+		if !strings.Contains(sent, want) {
+			t.Errorf("notify-send was not called with %q:\n%s", want, sent)
+		}
+	}
+	// The output is not a terminal, so it carries no title escapes.
+	if strings.Contains(out, "\033]0;") {
+		t.Errorf("title written to a pipe:\n%q", out)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// kindMerge is the kind of entries for commits with more than one parent.
+const kindMerge = "merge"
+
+// maxOctopusFiles caps how many changed files are listed per parent in an octopus merge's prompt.
+const maxOctopusFiles = 40
+
+// isOctopus reports whether a commit with these parents is an octopus merge. `git show`
+// prints no diff for those, so their prompt is built from diffstats instead.
+func isOctopus(parents []string) bool {
+	return len(parents) > 2
+}
+
+// parentDiff is the diffstat of an octopus merge against one of its parents.
+type parentDiff struct {
+	// Label names the parent: its short hash and, when messages are sent, its subject.
+	Label string
+	Stats commitStats
+	// Omitted counts the changed files left out of Stats.Files by maxOctopusFiles.
+	Omitted int
+}
+
+// getOctopusDiffs computes the diffstat of merge against each of its parents, which shows
+// what the other branches bring in relative to that parent. Files matching the never_send
+// globs are removed from the lists; it returns them, once each, and the number of distinct
+// files that remain.
+func getOctopusDiffs(ctx context.Context, repoPath, merge string, parents []string, pathspec []string, neverSend []string, sendSubjects bool) ([]parentDiff, []withheldFile, int, error) {
+	labels := make([]string, len(parents))
+	for i, parent := range parents {
+		labels[i] = shortHash(parent)
+	}
+	if sendSubjects {
+		subjects, err := getCommitSubjects(ctx, repoPath, parents)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		if len(subjects) == len(parents) {
+			labels = subjects
+		}
+	}
+
+	diffs := make([]parentDiff, len(parents))
+	var withheld []withheldFile
+	seenWithheld := make(map[string]bool)
+	remaining := make(map[string]bool)
+	for i, parent := range parents {
+		stats, err := getCommitStats(ctx, repoPath, merge, parent, pathspec)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		var w []withheldFile
+		stats.Files, w = filterPolicyFiles(stats.Files, neverSend)
+		for _, file := range w {
+			if !seenWithheld[file.Path] {
+				seenWithheld[file.Path] = true
+				withheld = append(withheld, file)
+			}
+		}
+		for _, entry := range stats.Files {
+			fields := strings.Split(entry, "\t")
+			remaining[fields[len(fields)-1]] = true
+		}
+		diffs[i] = parentDiff{Label: labels[i], Stats: stats}
+		if len(stats.Files) > maxOctopusFiles {
+			diffs[i].Omitted = len(stats.Files) - maxOctopusFiles
+			diffs[i].Stats.Files = stats.Files[:maxOctopusFiles]
+		}
+	}
+	return diffs, withheld, len(remaining), nil
+}
+
+// buildOctopusPrompt assembles the prompt for an octopus merge from its original message and
+// its diffstat against each parent, labeled as a merge of that many branches.
+func buildOctopusPrompt(message string, diffs []parentDiff, extras promptExtras) string {
+	var sb strings.Builder
+	for i, diff := range diffs {
+		role := ""
+		if i == 0 {
+			role = "first parent, "
+		}
+		summary := diff.Stats.Summary
+		if summary == "" {
+			summary = "(no file changes)"
+		}
+		fileList := strings.Join(diff.Stats.Files, "\n")
+		if fileList == "" {
+			fileList = "(none)"
+		}
+		if diff.Omitted > 0 {
+			fileList += fmt.Sprintf("\n(and %d more files)", diff.Omitted)
+		}
+		fmt.Fprintf(&sb, "\nParent %d (%s%s):\nDiffstat: %s\nChanged files (status and path):\n%s\n", i+1, role, diff.Label, summary, fileList)
+	}
+
+	return fmt.Sprintf(`Given the following original Git commit message of an octopus merge, which merges %d branches at once, and the diffstat of the merge against each of its parents, please write a detailed, audit-ready Git commit message for the merge. The diffstat against a parent shows what the other branches bring in relative to that parent. The full diff is intentionally not provided, so do not speculate about specific code changes beyond what the message and file lists support. The message should cover:
+1. A summary of what the merge brings together.
+2. The reasoning behind the merge, as far as the original message states it.
+3. The areas of the codebase each merged branch affects, based on the file lists.
+4. The intended purpose or goal of the merge.
+
+Do not include any introductory phrases like "Here's a commit message:". Output only the commit message itself.
+%s
+Original message:
+%s
+%s`, len(diffs), extras.render(), message, sb.String())
+}
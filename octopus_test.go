@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIsOctopus(t *testing.T) {
+	for n, want := range map[int]bool{0: false, 1: false, 2: false, 3: true, 5: true} {
+		if got := isOctopus(make([]string, n)); got != want {
+			t.Errorf("isOctopus of %d parents = %v", n, got)
+		}
+	}
+}
+
+func TestGetOctopusDiffs(t *testing.T) {
+	f := newMergeFixture(t)
+	parents := []string{f.M3, f.B1, f.C1}
+	diffs, withheld, remaining, err := getOctopusDiffs(context.Background(), f.Repo.Dir, f.Octopus, parents, nil, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Against each parent, the diffstat shows what the other branches bring in.
+	want := []struct {
+		label string
+		files []string
+	}{
+		{f.M3[:7] + " Merge branch 'topic-t'", []string{"A\tdocs/a.md", "A\texamples/a.go"}},
+		{f.B1[:7] + " Add docs", []string{"A\texamples/a.go"}},
+		{f.C1[:7] + " Add examples", []string{"A\tdocs/a.md"}},
+	}
+	for i, diff := range diffs {
+		if diff.Label != want[i].label || strings.Join(diff.Stats.Files, ",") != strings.Join(want[i].files, ",") || diff.Omitted != 0 {
+			t.Errorf("parent %d: %q %q, want %q %q", i+1, diff.Label, diff.Stats.Files, want[i].label, want[i].files)
+		}
+	}
+	if len(withheld) != 0 || remaining != 2 {
+		t.Errorf("withheld %v, %d files remaining", withheld, remaining)
+	}
+
+	// Without messages the parents are named by their hashes; never_send files are withheld
+	// once however many parents list them.
+	diffs, withheld, remaining, err = getOctopusDiffs(context.Background(), f.Repo.Dir, f.Octopus, parents, nil, []string{"docs/*"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diffs[1].Label != shortHash(f.B1) || len(withheld) != 1 || withheld[0].Path != "docs/a.md" || remaining != 1 {
+		t.Errorf("labels %q, withheld %v, %d remaining", diffs[1].Label, withheld, remaining)
+	}
+	for _, diff := range diffs {
+		if strings.Contains(strings.Join(diff.Stats.Files, ","), "docs/") {
+			t.Errorf("withheld file listed: %q", diff.Stats.Files)
+		}
+	}
+
+	// The file lists are bounded.
+	r := newFixtureRepo(t)
+	r.commit("Initial layout", map[string]string{"README": "base\n"})
+	for _, branch := range []string{"wide", "narrow"} {
+		r.git("checkout", "-q", "-b", branch, "main")
+		files := map[string]string{branch + "/only.go": "package " + branch + "\n"}
+		if branch == "wide" {
+			for i := 0; i < maxOctopusFiles+5; i++ {
+				files[fmt.Sprintf("wide/gen%02d.go", i)] = "package wide\n"
+			}
+		}
+		r.commit("Add "+branch, files)
+	}
+	r.git("checkout", "-q", "main")
+	r.commit("Touch the README", map[string]string{"README": "base\nmore\n"})
+	merge := r.merge("Merge branches 'wide' and 'narrow'", "wide", "narrow")
+	diffs, _, remaining, err = getOctopusDiffs(context.Background(), r.Dir, merge, strings.Fields(r.git("log", "-1", "--format=%P", merge)), nil, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs[0].Stats.Files) != maxOctopusFiles || diffs[0].Omitted != 7 || remaining != maxOctopusFiles+8 {
+		t.Errorf("first parent: %d files listed, %d omitted, %d remaining", len(diffs[0].Stats.Files), diffs[0].Omitted, remaining)
+	}
+}
+
+func TestBuildOctopusPrompt(t *testing.T) {
+	diffs := []parentDiff{
+		{Label: "Merge branch 'topic-t'", Stats: commitStats{Summary: "2 files changed, 2 insertions(+)", Files: []string{"A\tdocs/a.md", "A\texamples/a.go"}}, Omitted: 3},
+		{Label: "Add docs", Stats: commitStats{Summary: "1 file changed, 1 insertion(+)", Files: []string{"A\texamples/a.go"}}},
+		{Label: "abc1234"},
+	}
+	prompt := buildOctopusPrompt("Merge branches 'topic-b' and 'topic-c'", diffs, promptExtras{})
+	for _, want := range []string{
+		"an octopus merge, which merges 3 branches at once",
+		"The full diff is intentionally not provided",
+		"Original message:\nMerge branches 'topic-b' and 'topic-c'\n",
+		"\nParent 1 (first parent, Merge branch 'topic-t'):\nDiffstat: 2 files changed, 2 insertions(+)\nChanged files (status and path):\nA\tdocs/a.md\nA\texamples/a.go\n(and 3 more files)\n",
+		"\nParent 2 (Add docs):\nDiffstat: 1 file changed, 1 insertion(+)\nChanged files (status and path):\nA\texamples/a.go\n",
+		"\nParent 3 (abc1234):\nDiffstat: (no file changes)\nChanged files (status and path):\n(none)\n",
+	} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("prompt lacks %q:\n%s", want, prompt)
+		}
+	}
+	if strings.Contains(prompt, "diff --git") {
+		t.Errorf("prompt carries a diff:\n%s", prompt)
+	}
+}
+
+func TestOctopusRun(t *testing.T) {
+	f := newMergeFixture(t)
+	env := newAuditEnv(t)
+	// The fixture's octopus merge is a real `git merge topic-b topic-c`.
+	if parents := strings.Fields(f.Repo.git("log", "-1", "--format=%P", f.Octopus)); len(parents) != 3 {
+		t.Fatalf("octopus merge has %d parents", len(parents))
+	}
+	octopusPrompt := func(from int) (string, int) {
+		var found string
+		n := 0
+		for _, prompt := range env.Ollama.Prompts()[from:] {
+			if strings.Contains(prompt, "octopus merge, which merges") {
+				found = prompt
+				n++
+			}
+		}
+		return found, n
+	}
+
+	report := filepath.Join(env.Work, "report.json")
+	env.mustRun("-repo", f.Repo.Dir, "-commit", "root", "-format", "json", "-output", report)
+	prompt, n := octopusPrompt(0)
+	if n != 1 || !strings.Contains(prompt, "which merges 3 branches at once") || !strings.Contains(prompt, "Parent 3 ("+f.C1[:7]+" Add examples):") || !strings.Contains(prompt, "A\texamples/a.go") {
+		t.Errorf("%d octopus prompts:\n%s", n, prompt)
+	}
+	var document jsonReport
+	if err := json.Unmarshal([]byte(readFile(t, report)), &document); err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range document.Commits {
+		wantKind, wantParents := kindCommit, 0
+		if len(entry.Parents) > 1 {
+			wantKind, wantParents = kindMerge, len(entry.Parents)
+		}
+		if entry.Kind != wantKind || entry.ParentCount != wantParents {
+			t.Errorf("%s: kind %q with %d parents recorded, want %q with %d", shortHash(entry.Hash), entry.Kind, entry.ParentCount, wantKind, wantParents)
+		}
+		if entry.Hash == f.Octopus && entry.ParentCount != 3 {
+			t.Errorf("octopus entry records %d parents", entry.ParentCount)
+		}
+	}
+	text := filepath.Join(env.Work, "report.txt")
+	env.mustRun("-repo", f.Repo.Dir, "-commit", "root", "-output", text)
+	if !strings.Contains(readFile(t, text), "Merge: octopus merge of 3 branches") {
+		t.Errorf("text entry lacks the octopus note:\n%s", readFile(t, text))
+	}
+
+	// -no-merges skips it; its branches are still audited.
+	before := len(env.Ollama.Prompts())
+	env.mustRun("-repo", f.Repo.Dir, "-commit", "root", "-no-merges", "-output", text, "-force")
+	if _, n := octopusPrompt(before); n != 0 {
+		t.Error("-no-merges audited the octopus merge")
+	}
+	if sent := strings.Join(env.Ollama.Prompts()[before:], "\n"); !strings.Contains(sent, "Add docs") || !strings.Contains(sent, "Add examples") {
+		t.Error("-no-merges dropped the commits of the merged branches")
+	}
+
+	// -first-parent diffs it against its first parent, which covers both branches.
+	before = len(env.Ollama.Prompts())
+	env.mustRun("-repo", f.Repo.Dir, "-commit", "root", "-first-parent", "-output", text, "-force")
+	prompts := env.Ollama.Prompts()[before:]
+	if _, n := octopusPrompt(before); n != 0 || len(prompts) != 5 {
+		t.Errorf("-first-parent sent %d prompts, %d of them octopus prompts", len(prompts), n)
+	}
+	for _, prompt := range prompts {
+		if strings.Contains(prompt, "Merge branches 'topic-b' and 'topic-c'") && (!strings.Contains(prompt, "docs/a.md") || !strings.Contains(prompt, "examples/a.go")) {
+			t.Errorf("-first-parent octopus prompt lacks the branches' changes:\n%s", prompt)
+		}
+	}
+
+	// --help documents how both flags treat octopus merges.
+	out, _ := env.run("-help")
+	if !strings.Contains(out, "Skip merge commits, including octopus merges") || !strings.Contains(out, "each merge on it is described by its diff against the first parent") {
+		t.Errorf("-help:\n%s", out)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// foldKey is the grouping and sort key of a name or email: a simple per-character case fold,
+// the same on every machine whatever its locale.
+func foldKey(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
+
+// sortByRange puts entries into the order of the audited range, newest first, whatever order
+// they completed in: a commit that succeeded on a retry would otherwise follow the commits
+// audited after it, and the report would change with the failures of the run. Entries whose
+// hash is not in order come last, by hash.
+func sortByRange(entries []CommitAuditData, order []string) {
+	position := make(map[string]int, len(order))
+	for i, hash := range order {
+		position[hash] = i
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		pi, okI := position[entries[i].Hash]
+		pj, okJ := position[entries[j].Hash]
+		if okI != okJ {
+			return okI
+		}
+		if pi != pj {
+			return pi < pj
+		}
+		return entries[i].Hash < entries[j].Hash
+	})
+}
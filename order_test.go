@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFoldKey(t *testing.T) {
+	for in, want := range map[string]string{
+		"Alice@Example.COM": "alice@example.com",
+		"  Bob Smith \n":    "bob smith",
+		"ÉLODIE <e@x.fr>":   "élodie <e@x.fr>",
+		// The fold is the same in every locale, Turkish included.
+		"istanbul İSTANBUL": "istanbul istanbul",
+		"":                  "",
+	} {
+		if got := foldKey(in); got != want {
+			t.Errorf("foldKey(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestSortByRange(t *testing.T) {
+	entries := []CommitAuditData{{Hash: "c1"}, {Hash: "zz"}, {Hash: "c3"}, {Hash: "aa"}, {Hash: "c2"}}
+	sortByRange(entries, []string{"c3", "c2", "c1"})
+	var order []string
+	for _, entry := range entries {
+		order = append(order, entry.Hash)
+	}
+	// Entries outside the range come last, by hash.
+	if got, want := strings.Join(order, " "), "c3 c2 c1 aa zz"; got != want {
+		t.Errorf("sortByRange = %q, want %q", got, want)
+	}
+}
+
+// TestDeterministicReport audits commits made in the same second by authors whose names differ
+// only in case, ten times over, with the commits completing in a different order each run: the
+// reports are identical byte for byte.
+func TestDeterministicReport(t *testing.T) {
+	repo := newFixtureRepo(t)
+	same := []string{"GIT_AUTHOR_DATE=2024-01-01T12:00:00Z", "GIT_COMMITTER_DATE=2024-01-01T12:00:00Z"}
+	var hashes []string
+	for i, who := range []string{"bea <bea@example.com>", "Bea <BEA@example.com>", "al <al@example.com>", "Al <al2@example.com>", "AL <al3@example.com>", "bea <Bea@Example.com>"} {
+		name, email, _ := strings.Cut(strings.TrimSuffix(who, ">"), " <")
+		env := append(author(name, email), same...)
+		hashes = append(hashes, repo.commitEnv(env, fmt.Sprintf("Change %d", i), map[string]string{fmt.Sprintf("src/file%d.go", i): "package src\n"}))
+	}
+	// Two lightweight tags share their commit's date.
+	repo.git("tag", "v1-b", hashes[2])
+	repo.git("tag", "v1-a", hashes[2])
+
+	env := newAuditEnv(t)
+	// The delay follows the request count and the prompt, so each run completes the commits
+	// in another order without the handlers sharing a generator.
+	env.Ollama.Respond = func(n int, prompt string) (int, string) {
+		h := fnv.New32a()
+		fmt.Fprintf(h, "%d %s", n, prompt)
+		time.Sleep(time.Duration(h.Sum32()%20) * time.Millisecond)
+		return 0, ""
+	}
+	report := filepath.Join(env.Work, "report.txt")
+	var first string
+	for run := 0; run < 10; run++ {
+		env.mustRun("-repo", repo.Dir, "-commit", "root", "-output", report, "-force", "-concurrency", "4", "-include-tags", "-author-rollup")
+		content := readFile(t, report)
+		if run == 0 {
+			first = content
+			continue
+		}
+		if content != first {
+			t.Fatalf("run %d differs from the first:\n%s\nfirst:\n%s", run+1, content, first)
+		}
+	}
+	// The entries are in range order, newest first, and the tags by name.
+	var order []string
+	for _, line := range strings.Split(first, "\n") {
+		if hash, ok := strings.CutPrefix(line, "Commit: "); ok {
+			order = append(order, hash)
+		}
+	}
+	if len(order) != len(hashes) || order[0] != hashes[len(hashes)-1] || order[len(order)-1] != hashes[0] {
+		t.Errorf("entry order %q", order)
+	}
+	if !strings.Contains(first, "=== Tag v1-b (lightweight) at "+hashes[2]+" ===\n\n---\n\n=== Tag v1-a (lightweight) at "+hashes[2]+" ===\n") {
+		t.Errorf("tag order:\n%s", first)
+	}
+	// Bea's three commits under three casings of one email make one author, named as in the
+	// newest; the authors of one commit each follow by name, then email.
+	authors := first[strings.Index(first, "=== Authors ===\n"):]
+	var rollups []string
+	for _, line := range strings.Split(authors, "\n") {
+		if strings.Contains(line, " lines") {
+			rollups = append(rollups, line)
+		}
+	}
+	want := []string{
+		"bea <Bea@Example.com>: 3 commits, +3 -0 lines",
+		"Al <al2@example.com>: 1 commit, +1 -0 lines",
+		"AL <al3@example.com>: 1 commit, +1 -0 lines",
+		"al <al@example.com>: 1 commit, +1 -0 lines",
+	}
+	if strings.Join(rollups, "\n") != strings.Join(want, "\n") {
+		t.Errorf("author rollup:\n%s", authors)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// defaultOSVEndpoint is the OSV.dev API, overridable with osv_endpoint for a mirror.
+const defaultOSVEndpoint = "https://api.osv.dev"
+
+// osvCacheTTL is how long an OSV answer for one package version is reused. Advisories are
+// published continually, so answers are not kept for long.
+const osvCacheTTL = 24 * time.Hour
+
+// osvLookupFailed is the vulnerability status of an entry whose OSV lookup failed.
+const osvLookupFailed = "osv lookup failed"
+
+// vulnerability is a known vulnerability fixed by a commit's dependency changes.
+type vulnerability struct {
+	ID       string `json:"id"`
+	Severity string `json:"severity"`
+	Summary  string `json:"summary"`
+	// Package is the dependency change that fixes it, e.g. "lodash 4.17.20 → 4.17.21".
+	Package string `json:"package"`
+}
+
+// osvVuln is the part of an OSV vulnerability record gitaudit reads.
+type osvVuln struct {
+	ID       string `json:"id"`
+	Summary  string `json:"summary"`
+	Details  string `json:"details"`
+	Severity []struct {
+		Type  string `json:"type"`
+		Score string `json:"score"`
+	} `json:"severity"`
+	DatabaseSpecific map[string]any `json:"database_specific"`
+}
+
+// severity returns the advisory's severity rating, e.g. "HIGH". OSV records carry a CVSS
+// vector rather than a rating, so the rating comes from the advisory database (GitHub's
+// advisories have one); without it the CVSS version is named, or "unknown".
+func (v osvVuln) severity() string {
+	if rating, ok := v.DatabaseSpecific["severity"].(string); ok && rating != "" {
+		return strings.ToUpper(rating)
+	}
+	if len(v.Severity) > 0 {
+		return v.Severity[0].Type
+	}
+	return "unknown"
+}
+
+type osvQuery struct {
+	Version string `json:"version"`
+	Package struct {
+		Name      string `json:"name"`
+		Ecosystem string `json:"ecosystem"`
+	} `json:"package"`
+	PageToken string `json:"page_token,omitempty"`
+}
+
+type osvQueryResponse struct {
+	Vulns         []osvVuln `json:"vulns"`
+	NextPageToken string    `json:"next_page_token"`
+}
+
+// osvClient looks up the vulnerabilities fixed by dependency changes on OSV.dev, caching
+// each answer in the cache store.
+type osvClient struct {
+	Endpoint string
+	Cache    *cacheStore
+}
+
+// newOSVClient returns the OSV client for a run, or nil when lookups are disabled
+// (-no-osv) or the run must stay offline (-replay).
+func (p *promptFlags) newOSVClient(config *Config, store *cacheStore) *osvClient {
+	if p.NoOSV || p.Replay != "" {
+		return nil
+	}
+	endpoint := config.OSVEndpoint
+	if endpoint == "" {
+		endpoint = defaultOSVEndpoint
+	}
+	return &osvClient{Endpoint: strings.TrimRight(endpoint, "/"), Cache: store}
+}
+
+// FixedVulnerabilities returns the vulnerabilities that affect the old version of a changed
+// dependency but not the new one, for each of the commit's dependency changes.
+func (c *osvClient) FixedVulnerabilities(changes []dependencyChange) ([]vulnerability, error) {
+	var fixed []vulnerability
+	for _, change := range changes {
+		before, err := c.query(change.Ecosystem, change.Name, change.Old)
+		if err != nil {
+			return nil, err
+		}
+		if len(before) == 0 {
+			continue
+		}
+		after, err := c.query(change.Ecosystem, change.Name, change.New)
+		if err != nil {
+			return nil, err
+		}
+		remaining := make(map[string]bool)
+		for _, v := range after {
+			remaining[v.ID] = true
+		}
+		for _, v := range before {
+			if remaining[v.ID] {
+				continue
+			}
+			summary := v.Summary
+			if summary == "" {
+				summary = bodySnippet([]byte(v.Details))
+			}
+			fixed = append(fixed, vulnerability{ID: v.ID, Severity: v.severity(), Summary: summary, Package: change.String()})
+		}
+	}
+	return fixed, nil
+}
+
+// query returns the vulnerabilities affecting one version of a package, from the cache
+// when it is younger than osvCacheTTL.
+func (c *osvClient) query(ecosystem, name, version string) ([]osvVuln, error) {
+	if ecosystem == ecosystemGo {
+		// OSV's Go records use semantic versions without the module "v" prefix.
+		version = strings.TrimPrefix(version, "v")
+	}
+	key := cacheKey{Kind: cacheKindOSV, Digest: strings.Join([]string{c.Endpoint, ecosystem, name, version}, "\x00")}
+	if data, ok := c.Cache.Get(key, osvCacheTTL); ok {
+		var cached []osvVuln
+		if json.Unmarshal(data, &cached) == nil {
+			debugf("osv answer for %s %s from cache", name, version)
+			return cached, nil
+		}
+	}
+
+	vulns, err := c.fetch(ecosystem, name, version)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(vulns)
+	if err == nil {
+		err = c.Cache.Put(key, data)
+	}
+	if err != nil {
+		debugf("failed to cache osv answer: %v", err)
+	}
+	return vulns, nil
+}
+
+// fetch queries the OSV API, following result pages.
+func (c *osvClient) fetch(ecosystem, name, version string) ([]osvVuln, error) {
+	var vulns []osvVuln
+	query := osvQuery{Version: version}
+	query.Package.Name = name
+	query.Package.Ecosystem = ecosystem
+	for {
+		body, err := json.Marshal(query)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal OSV query: %w", err)
+		}
+		resp, err := lookupClient.Post(c.Endpoint+"/v1/query", "application/json", bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("failed to query OSV for %s %s: %w", name, version, err)
+		}
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read OSV response for %s %s: %w", name, version, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("OSV query for %s %s failed with status %s: %s", name, version, resp.Status, bodySnippet(data))
+		}
+		var page osvQueryResponse
+		if err := json.Unmarshal(data, &page); err != nil {
+			return nil, fmt.Errorf("failed to decode OSV response for %s %s: %w", name, version, err)
+		}
+		vulns = append(vulns, page.Vulns...)
+		if page.NextPageToken == "" {
+			return vulns, nil
+		}
+		query.PageToken = page.NextPageToken
+	}
+}
+
+// formatVulnerabilities renders an entry's Vulnerabilities note.
+func formatVulnerabilities(vulns []vulnerability) string {
+	var sb strings.Builder
+	sb.WriteString(msg("entry.vulnerabilities_fixed", len(vulns)) + "\n")
+	for _, v := range vulns {
+		fmt.Fprintf(&sb, "  - %s [%s] %s (%s)\n", v.ID, v.Severity, v.Summary, v.Package)
+	}
+	return sb.String()
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// osvServer answers OSV queries with the recorded responses in testdata/osv, named
+// <ecosystem>-<package>-<version>[.<page token>].json with the slashes of the package name
+// as underscores. A package version without a recording has no vulnerabilities.
+type osvServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	queries []string
+	// fail, when set, is the status every query is answered with.
+	fail int
+}
+
+func newOSVServer(t *testing.T) *osvServer {
+	t.Helper()
+	s := &osvServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/query" {
+			http.NotFound(w, r)
+			return
+		}
+		var query osvQuery
+		if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		name := query.Package.Ecosystem + "-" + strings.ReplaceAll(query.Package.Name, "/", "_") + "-" + query.Version
+		if query.PageToken != "" {
+			name += "." + query.PageToken
+		}
+		s.mu.Lock()
+		s.queries = append(s.queries, name)
+		fail := s.fail
+		s.mu.Unlock()
+		if fail != 0 {
+			http.Error(w, `{"code":13,"message":"internal error"}`, fail)
+			return
+		}
+		data, err := os.ReadFile(filepath.Join("testdata", "osv", name+".json"))
+		if os.IsNotExist(err) {
+			data = []byte("{}")
+		}
+		w.Write(data)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Fail makes the server answer every query with status.
+func (s *osvServer) Fail(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fail = status
+}
+
+func (s *osvServer) Queries() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.queries...)
+}
+
+func TestFixedVulnerabilities(t *testing.T) {
+	server := newOSVServer(t)
+	client := &osvClient{Endpoint: server.URL}
+	changes := []dependencyChange{
+		{Ecosystem: ecosystemNPM, Name: "lodash", Old: "4.17.20", New: "4.17.21"},
+		{Ecosystem: ecosystemGo, Name: "golang.org/x/net", Old: "v0.22.0", New: "v0.23.0"},
+		// The second page of the old version's results is still open in the new one.
+		{Ecosystem: ecosystemPyPI, Name: "requests", Old: "2.31.0", New: "2.32.0"},
+		// Nothing affects the old version: the new one is not queried.
+		{Ecosystem: ecosystemNPM, Name: "express", Old: "4.18.2", New: "4.19.2"},
+	}
+	got, err := client.FixedVulnerabilities(changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []vulnerability{
+		{ID: "GHSA-35jh-r3h4-6jhm", Severity: "HIGH", Summary: "Command Injection in lodash", Package: "lodash 4.17.20 → 4.17.21"},
+		{ID: "GHSA-29mw-wpgm-hmr9", Severity: "MODERATE", Summary: "Regular Expression Denial of Service (ReDoS) in lodash", Package: "lodash 4.17.20 → 4.17.21"},
+		// Without a summary or a rating: the start of the details, and "unknown".
+		{ID: "GO-2024-2687", Severity: "unknown", Summary: "An attacker may cause an HTTP/2 endpoint to read arbitrary amounts of header data by sending an excessive number of CONTINUATION frames.", Package: "golang.org/x/net v0.22.0 → v0.23.0"},
+		{ID: "GHSA-9wx4-h78v-vm56", Severity: "MODERATE", Summary: "Requests `Session` object does not verify requests after making first request with verify=False", Package: "requests 2.31.0 → 2.32.0"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("FixedVulnerabilities =\n%+v\nwant\n%+v", got, want)
+	}
+	wantQueries := []string{
+		"npm-lodash-4.17.20", "npm-lodash-4.17.21",
+		// Go versions are queried without the module "v".
+		"Go-golang.org_x_net-0.22.0", "Go-golang.org_x_net-0.23.0",
+		"PyPI-requests-2.31.0", "PyPI-requests-2.31.0.page-2", "PyPI-requests-2.32.0",
+		"npm-express-4.18.2",
+	}
+	if queries := server.Queries(); !reflect.DeepEqual(queries, wantQueries) {
+		t.Errorf("queries %q, want %q", queries, wantQueries)
+	}
+}
+
+func TestOSVVulnSeverity(t *testing.T) {
+	var v osvVuln
+	if err := json.Unmarshal([]byte(`{"id":"X","severity":[{"type":"CVSS_V4","score":"CVSS:4.0/AV:N"}]}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	if got := v.severity(); got != "CVSS_V4" {
+		t.Errorf("severity without a rating = %q, want the CVSS version", got)
+	}
+	v.DatabaseSpecific = map[string]any{"severity": "critical"}
+	if got := v.severity(); got != "CRITICAL" {
+		t.Errorf("severity = %q", got)
+	}
+}
+
+func TestOSVQueryCaches(t *testing.T) {
+	server := newOSVServer(t)
+	store := &cacheStore{Dir: t.TempDir(), started: time.Now()}
+	client := &osvClient{Endpoint: server.URL, Cache: store}
+	change := []dependencyChange{{Ecosystem: ecosystemNPM, Name: "lodash", Old: "4.17.20", New: "4.17.21"}}
+	for i := 0; i < 3; i++ {
+		if got, err := client.FixedVulnerabilities(change); err != nil || len(got) != 2 {
+			t.Fatalf("FixedVulnerabilities = %v, %v", got, err)
+		}
+	}
+	if n := len(server.Queries()); n != 2 {
+		t.Errorf("%d queries for three lookups of one change, want 2", n)
+	}
+	// A failing server is not cached over; the answers cached before still serve.
+	server.Fail(http.StatusInternalServerError)
+	if _, err := client.FixedVulnerabilities(change); err != nil {
+		t.Errorf("cached lookup failed: %v", err)
+	}
+	other := []dependencyChange{{Ecosystem: ecosystemPyPI, Name: "requests", Old: "2.31.0", New: "2.32.0"}}
+	if _, err := client.FixedVulnerabilities(other); err == nil || !strings.Contains(err.Error(), "OSV query for requests 2.31.0 failed with status 500") {
+		t.Errorf("err = %v, want the server's failure", err)
+	}
+}
+
+func TestVulnerabilitiesInReport(t *testing.T) {
+	repo := newFixtureRepo(t)
+	repo.commit("Add the manifest", map[string]string{"package.json": "{\n  \"dependencies\": {\n    \"lodash\": \"^4.17.20\"\n  }\n}\n"})
+	bump := repo.commit("Upgrade lodash", map[string]string{"package.json": "{\n  \"dependencies\": {\n    \"lodash\": \"^4.17.21\"\n  }\n}\n"})
+	repo.commit("Add requests", map[string]string{"requirements.txt": "requests==2.31.0\n"})
+	pip := repo.commit("Upgrade requests", map[string]string{"requirements.txt": "requests==2.32.0\n"})
+	server := newOSVServer(t)
+	env := newAuditEnv(t)
+	env.Config["osv_endpoint"] = server.URL
+
+	report := filepath.Join(env.Work, "report.txt")
+	out := env.mustRun("-repo", repo.Dir, "-commit", "root", "-output", report)
+	if !strings.Contains(out, "2 commits fix 3 known vulnerabilities in dependencies (OSV lookups failed for 0 commits).") {
+		t.Errorf("run summary:\n%s", out)
+	}
+	text := readFile(t, report)
+	entry := text[strings.Index(text, "Commit: "+bump):]
+	if !strings.Contains(entry, "Vulnerabilities fixed: 2\n  - GHSA-35jh-r3h4-6jhm [HIGH] Command Injection in lodash (lodash 4.17.20 → 4.17.21)\n") {
+		t.Errorf("entry of %s:\n%s", bump, entry)
+	}
+
+	// A failing OSV server leaves the entries standing, marked as not looked up.
+	server.Fail(http.StatusServiceUnavailable)
+	env.Env = []string{"XDG_CACHE_HOME=" + t.TempDir()}
+	report = filepath.Join(env.Work, "failed.txt")
+	out = env.mustRun("-repo", repo.Dir, "-commit", "root", "-output", report)
+	if !strings.Contains(out, "Warning: vulnerability lookup failed for commit "+pip) || !strings.Contains(out, "(OSV lookups failed for 2 commits)") {
+		t.Errorf("run output:\n%s", out)
+	}
+	text = readFile(t, report)
+	if strings.Count(text, "Vulnerabilities: osv lookup failed") != 2 || strings.Count(text, "Commit: ") != 4 {
+		t.Errorf("report:\n%s", text)
+	}
+
+	// -no-osv makes no queries.
+	queries := len(server.Queries())
+	env.mustRun("-repo", repo.Dir, "-commit", "root", "-output", filepath.Join(env.Work, "offline.txt"), "-no-osv")
+	if n := len(server.Queries()); n != queries {
+		t.Errorf("-no-osv run made %d queries", n-queries)
+	}
+}
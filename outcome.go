@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// errInterrupted is the cause of a run stopped by Ctrl+C or SIGTERM, and wraps the error of a
+// model request aborted by the second Ctrl+C.
+var errInterrupted = errors.New("interrupted")
+
+// permanentGitError is a commit git cannot produce, such as one whose objects a partial
+// clone cannot fetch. Retrying cannot help, so the commit is left unaudited.
+type permanentGitError struct {
+	Hash   string
+	Stderr string
+	Err    error
+}
+
+func (e *permanentGitError) Error() string {
+	return fmt.Sprintf("commit %s: %v", e.Hash, e.Err)
+}
+
+func (e *permanentGitError) Unwrap() error { return e.Err }
+
+// newPermanentGitError records err, a failed git command, as the permanent failure of hash.
+func newPermanentGitError(hash string, err error) *permanentGitError {
+	permanent := &permanentGitError{Hash: hash, Err: err}
+	var gitErr *gitError
+	if errors.As(err, &gitErr) {
+		permanent.Stderr = gitErr.Stderr
+	}
+	return permanent
+}
+
+// retriesExhaustedError is a commit still failing when its retries (-max-retries) or the
+// run's retry passes (-retry-passes) ran out. LastErr is the error of its latest attempt.
+type retriesExhaustedError struct {
+	Hash     string
+	Attempts int
+	LastErr  error
+}
+
+func (e *retriesExhaustedError) Error() string {
+	return fmt.Sprintf("commit %s failed %d attempts: %v", e.Hash, e.Attempts, e.LastErr)
+}
+
+func (e *retriesExhaustedError) Unwrap() error { return e.LastErr }
+
+// retryLimitError stops a run whose retry passes ran out with commits still failing. It
+// unwraps to a *retriesExhaustedError per commit.
+type retryLimitError struct {
+	Passes  int
+	Commits []*retriesExhaustedError
+}
+
+func (e *retryLimitError) Error() string {
+	return fmt.Sprintf("%d commits still failed after %d retry passes (-retry-passes)", len(e.Commits), e.Passes)
+}
+
+func (e *retryLimitError) Unwrap() []error {
+	errs := make([]error, len(e.Commits))
+	for i, commit := range e.Commits {
+		errs[i] = commit
+	}
+	return errs
+}
+
+// Dispositions of the commits of a run.
+const (
+	dispositionAudited     = "audited"
+	dispositionPending     = "pending"
+	dispositionUnauditable = "unauditable"
+	dispositionFailed      = "failed"
+)
+
+// commitDisposition is what became of one commit of the range.
+type commitDisposition struct {
+	Status string
+	// Err is why the commit was not audited: a *permanentGitError for an unauditable commit,
+	// a *retriesExhaustedError for one given up after -max-retries; for a pending one, a *retriesExhaustedError, the error of its latest attempt, or
+	// errInterrupted when it was never attempted.
+	Err error
+}
+
+// runResult is the outcome of a range audit, from which its exit status is derived.
+type runResult struct {
+	// Dispositions maps every commit of the range to what became of it.
+	Dispositions map[string]commitDisposition
+	// Err is why the run stopped early: a provider's *permanentError, a *retryLimitError, an
+	// exceeded -budget or errInterrupted; nil when it ran to completion or a pause window
+	// stopped it.
+	Err error
+	// OutputFailed is set when a report target could not be written.
+	OutputFailed bool
+	// FailOnMet is set when a -fail-on condition held.
+	FailOnMet bool
+}
+
+// count returns the number of commits with the given disposition.
+func (r *runResult) count(status string) int {
+	n := 0
+	for _, d := range r.Dispositions {
+		if d.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// exitCode maps the outcome to the process exit status: 1 for a failed run (an error other
+// than an interrupt, an unauditable or failed commit, or an unwritten report), exitInterrupted for an
+// interrupted one, exitFailOn when a -fail-on condition held, and 0 otherwise.
+func (r *runResult) exitCode() int {
+	switch {
+	case r.Err != nil && !errors.Is(r.Err, errInterrupted), r.count(dispositionUnauditable) > 0, r.count(dispositionFailed) > 0, r.OutputFailed:
+		return 1
+	case errors.Is(r.Err, errInterrupted):
+		return exitInterrupted
+	case r.FailOnMet:
+		return exitFailOn
+	}
+	return 0
+}
+
+// newRunResult classifies the commits of a range audit once its passes are over. pending are
+// the commits left in the retry queue, unauditable those git could not produce and failed
+// those given up after -max-retries; every other commit was audited. fatalErr is the error that stopped the run, if any; a run stopped
+// by Ctrl+C or SIGTERM without one gets errInterrupted.
+func newRunResult(hashes, pending, unauditable, failed []string, states map[string]*commitState, fatalErr error, outputFailed, failOnMet bool) *runResult {
+	result := &runResult{Dispositions: make(map[string]commitDisposition, len(hashes)), Err: fatalErr, OutputFailed: outputFailed, FailOnMet: failOnMet}
+	if result.Err == nil && interrupts.Stage() > 0 {
+		result.Err = errInterrupted
+	}
+	for _, hash := range hashes {
+		result.Dispositions[hash] = commitDisposition{Status: dispositionAudited}
+	}
+	exhausted := make(map[string]*retriesExhaustedError)
+	var limit *retryLimitError
+	if errors.As(fatalErr, &limit) {
+		for _, commit := range limit.Commits {
+			exhausted[commit.Hash] = commit
+		}
+	}
+	for _, hash := range pending {
+		disposition := commitDisposition{Status: dispositionPending, Err: errInterrupted}
+		if commit := exhausted[hash]; commit != nil {
+			disposition.Err = commit
+		} else if state := states[hash]; state != nil && state.LastError != nil {
+			disposition.Err = state.LastError
+		}
+		result.Dispositions[hash] = disposition
+	}
+	for _, hash := range unauditable {
+		result.Dispositions[hash] = commitDisposition{Status: dispositionUnauditable, Err: states[hash].LastError}
+	}
+	for _, hash := range failed {
+		state := states[hash]
+		result.Dispositions[hash] = commitDisposition{Status: dispositionFailed, Err: &retriesExhaustedError{Hash: hash, Attempts: state.Failures, LastErr: state.LastError}}
+	}
+	return result
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// outcomeOptions returns the default audit options for repo, with the fake server as the
+// model.
+func outcomeOptions(t *testing.T, repo *fixtureRepo, ollama *fakeOllama) *auditOptions {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	p := registerPromptFlags(fs)
+	if err := fs.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+	opts, err := p.auditOptions(repo.Dir, &Config{OllamaEndpoint: ollama.Endpoint(), OllamaModel: "tiny:0.5b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return opts
+}
+
+// TestErrorChains produces each failure class with a fixture repository and the fake
+// server, and checks that errors.Is and errors.As see it through every layer auditCommit and
+// the run wrap it in.
+func TestErrorChains(t *testing.T) {
+	repo := newFixtureRepo(t)
+	hash := repo.commits(1)[0]
+	missing := strings.Repeat("0", 40)
+
+	for _, tc := range []struct {
+		name    string
+		respond func(n int, prompt string) (int, string)
+		// audit returns the error as the run records it.
+		audit func(t *testing.T, opts *auditOptions) error
+		check func(t *testing.T, err error)
+	}{
+		{
+			name: "unknown model",
+			respond: func(int, string) (int, string) {
+				return 404, `{"error":"model \"tiny:0.5b\" not found, try pulling it first"}`
+			},
+			check: func(t *testing.T, err error) {
+				var permanent *permanentError
+				var status *ollamaStatusError
+				if !errors.As(err, &permanent) || permanent.Status != 404 || !strings.Contains(permanent.Body, "not found") || !isPermanent(err) {
+					t.Errorf("no *permanentError with the refusal: %v", err)
+				}
+				if !errors.As(err, &status) || status.StatusCode != 404 {
+					t.Errorf("no *ollamaStatusError under the *permanentError: %v", err)
+				}
+				if errors.Is(err, errInterrupted) {
+					t.Errorf("a refusal is an interrupt: %v", err)
+				}
+			},
+		},
+		{
+			name:    "model missing behind a gateway",
+			respond: func(int, string) (int, string) { return 200, `{"error":"model 'tiny:0.5b' not found"}` },
+			check: func(t *testing.T, err error) {
+				var permanent *permanentError
+				if !errors.As(err, &permanent) || permanent.Status != 200 || permanent.Body != "model 'tiny:0.5b' not found" {
+					t.Errorf("no *permanentError with the gateway's message: %v", err)
+				}
+			},
+		},
+		{
+			name:    "overloaded server",
+			respond: func(int, string) (int, string) { return 503, "busy" },
+			check: func(t *testing.T, err error) {
+				var status *ollamaStatusError
+				if !errors.As(err, &status) || status.StatusCode != 503 || status.Body != "busy" || isPermanent(err) {
+					t.Errorf("a retryable failure: %v", err)
+				}
+			},
+		},
+		{
+			name: "request aborted by the second Ctrl+C",
+			// The abort comes while the model request is in flight, so that it is what fails.
+			respond: func(int, string) (int, string) {
+				abortRequests()
+				<-requestCtx.Done()
+				return 0, ""
+			},
+			audit: func(t *testing.T, opts *auditOptions) error {
+				withFreshContexts(t)
+				_, err := auditCommit(requestCtx, opts, hash, detailFull)
+				return err
+			},
+			check: func(t *testing.T, err error) {
+				var gitErr *gitError
+				if !errors.Is(err, errInterrupted) || !errors.Is(err, context.Canceled) || isPermanent(err) || errors.As(err, &gitErr) {
+					t.Errorf("not an interrupted request: %v", err)
+				}
+				if !strings.HasPrefix(err.Error(), "failed to call Ollama: ") {
+					t.Errorf("message %q", err)
+				}
+			},
+		},
+		{
+			name: "git command cancelled",
+			audit: func(t *testing.T, opts *auditOptions) error {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				_, err := auditCommit(ctx, opts, hash, detailFull)
+				return err
+			},
+			check: func(t *testing.T, err error) {
+				var gitErr *gitError
+				if !errors.Is(err, errInterrupted) || !errors.As(err, &gitErr) {
+					t.Errorf("not an interrupted git command: %v", err)
+				}
+			},
+		},
+		{
+			name: "commit git cannot produce",
+			audit: func(t *testing.T, opts *auditOptions) error {
+				_, err := auditCommit(context.Background(), opts, missing, detailFull)
+				if err == nil {
+					t.Fatal("a missing commit was audited")
+				}
+				return newPermanentGitError(missing, err)
+			},
+			check: func(t *testing.T, err error) {
+				var permanent *permanentGitError
+				var gitErr *gitError
+				if !errors.As(err, &permanent) || permanent.Hash != missing || permanent.Stderr == "" {
+					t.Fatalf("no *permanentGitError with git's stderr: %v", err)
+				}
+				if !errors.As(err, &gitErr) || gitErr.Stderr != permanent.Stderr {
+					t.Errorf("no *gitError under the *permanentGitError: %v", err)
+				}
+				if !strings.HasPrefix(err.Error(), "commit "+missing+": failed to get metadata: ") {
+					t.Errorf("message %q", err)
+				}
+			},
+		},
+		{
+			name:    "retries exhausted",
+			respond: func(int, string) (int, string) { return 503, "busy" },
+			audit: func(t *testing.T, opts *auditOptions) error {
+				_, err := auditCommit(context.Background(), opts, hash, detailFull)
+				first := &retriesExhaustedError{Hash: hash, Attempts: 3, LastErr: err}
+				second := &retriesExhaustedError{Hash: missing, Attempts: 1, LastErr: newPermanentGitError(missing, errors.New("unreachable"))}
+				return fmt.Errorf("run stopped: %w", &retryLimitError{Passes: 2, Commits: []*retriesExhaustedError{first, second}})
+			},
+			check: func(t *testing.T, err error) {
+				var limit *retryLimitError
+				var exhausted *retriesExhaustedError
+				var status *ollamaStatusError
+				var permanent *permanentGitError
+				if !errors.As(err, &limit) || len(limit.Commits) != 2 || limit.Error() != "2 commits still failed after 2 retry passes (-retry-passes)" {
+					t.Fatalf("no *retryLimitError: %v", err)
+				}
+				// errors.As finds the first commit; each commit's cause is reachable.
+				if !errors.As(err, &exhausted) || exhausted.Hash != hash || exhausted.Attempts != 3 {
+					t.Errorf("first *retriesExhaustedError: %+v", exhausted)
+				}
+				if !errors.As(err, &status) || status.StatusCode != 503 {
+					t.Errorf("no *ollamaStatusError of the first commit: %v", err)
+				}
+				if !errors.As(err, &permanent) || permanent.Hash != missing || !errors.Is(err, limit.Commits[1].LastErr) {
+					t.Errorf("the second commit's cause is hidden: %v", err)
+				}
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ollama := newFakeOllama(t)
+			ollama.Respond = tc.respond
+			opts := outcomeOptions(t, repo, ollama)
+			audit := tc.audit
+			if audit == nil {
+				audit = func(t *testing.T, opts *auditOptions) error {
+					_, err := auditCommit(context.Background(), opts, hash, detailFull)
+					return err
+				}
+			}
+			err := audit(t, opts)
+			if err == nil {
+				t.Fatal("no error")
+			}
+			tc.check(t, err)
+		})
+	}
+}
+
+func TestRunResult(t *testing.T) {
+	hashes := []string{"aaa", "bbb", "ccc"}
+	busy := &ollamaStatusError{Status: "503 Service Unavailable", StatusCode: 503, Body: "busy"}
+	unreachable := newPermanentGitError("ccc", &gitError{Stderr: "fatal: bad object ccc", Err: errors.New("exit status 128")})
+	exhausted := &retriesExhaustedError{Hash: "bbb", Attempts: 4, LastErr: busy}
+	refused := &permanentError{err: errors.New("model not found"), Status: 404}
+	for _, tc := range []struct {
+		name                    string
+		pending, unauditable    []string
+		failed                  []string
+		states                  map[string]*commitState
+		fatalErr                error
+		outputFailed, failOnMet bool
+		wantCode                int
+		wantStatus              map[string]string
+		wantErr                 map[string]error
+	}{
+		{name: "all audited", wantCode: 0},
+		{name: "fail-on condition", failOnMet: true, wantCode: exitFailOn},
+		{name: "unwritten report", outputFailed: true, failOnMet: true, wantCode: 1},
+		{
+			name:    "interrupted",
+			pending: []string{"bbb", "ccc"}, states: map[string]*commitState{"bbb": {Failures: 1, LastError: busy}},
+			fatalErr: fmt.Errorf("stopped: %w", errInterrupted), failOnMet: true, wantCode: exitInterrupted,
+			wantStatus: map[string]string{"aaa": dispositionAudited, "bbb": dispositionPending, "ccc": dispositionPending},
+			// An attempted commit keeps its last error; one never attempted was interrupted.
+			wantErr: map[string]error{"bbb": busy, "ccc": errInterrupted},
+		},
+		{
+			name:        "interrupted with an unauditable commit",
+			unauditable: []string{"ccc"}, states: map[string]*commitState{"ccc": {Failures: 1, LastError: unreachable}},
+			fatalErr: errInterrupted, wantCode: 1,
+			wantStatus: map[string]string{"ccc": dispositionUnauditable}, wantErr: map[string]error{"ccc": unreachable},
+		},
+		{
+			name: "unknown model", pending: []string{"bbb", "ccc"}, fatalErr: fmt.Errorf("failed to call ollama: %w", refused), wantCode: 1,
+			wantStatus: map[string]string{"bbb": dispositionPending},
+		},
+		{
+			name:    "retry passes ran out",
+			pending: []string{"bbb"}, states: map[string]*commitState{"bbb": {Failures: 4, LastError: busy}},
+			fatalErr: &retryLimitError{Passes: 3, Commits: []*retriesExhaustedError{exhausted}}, wantCode: 1,
+			wantStatus: map[string]string{"bbb": dispositionPending}, wantErr: map[string]error{"bbb": exhausted},
+		},
+		{
+			name:   "given up after -max-retries",
+			failed: []string{"bbb"}, states: map[string]*commitState{"bbb": {Failures: 4, LastError: busy}},
+			wantCode:   1,
+			wantStatus: map[string]string{"aaa": dispositionAudited, "bbb": dispositionFailed},
+			wantErr:    map[string]error{"bbb": busy},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			result := newRunResult(hashes, tc.pending, tc.unauditable, tc.failed, tc.states, tc.fatalErr, tc.outputFailed, tc.failOnMet)
+			if code := result.exitCode(); code != tc.wantCode {
+				t.Errorf("exit code %d, want %d", code, tc.wantCode)
+			}
+			if len(result.Dispositions) != len(hashes) {
+				t.Errorf("%d dispositions", len(result.Dispositions))
+			}
+			for hash, status := range tc.wantStatus {
+				if got := result.Dispositions[hash].Status; got != status {
+					t.Errorf("%s is %s, want %s", hash, got, status)
+				}
+			}
+			for hash, want := range tc.wantErr {
+				if err := result.Dispositions[hash].Err; !errors.Is(err, want) {
+					t.Errorf("%s: error %v, want %v", hash, err, want)
+				}
+			}
+		})
+	}
+
+	// A commit given up after -max-retries records its attempts.
+	result := newRunResult(hashes, nil, nil, []string{"bbb"}, map[string]*commitState{"bbb": {Failures: 4, LastError: busy}}, nil, false, false)
+	var given *retriesExhaustedError
+	if !errors.As(result.Dispositions["bbb"].Err, &given) || given.Hash != "bbb" || given.Attempts != 4 || result.count(dispositionFailed) != 1 {
+		t.Errorf("given up: %v", result.Dispositions["bbb"].Err)
+	}
+}
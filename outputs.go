@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// outputTarget is one artifact of a run: a -format and the file or directory it is written to.
+type outputTarget struct {
+	Format string
+	Path   string
+}
+
+func (t outputTarget) String() string {
+	return t.Format + "=" + t.Path
+}
+
+// outputTargetsFlag collects the repeatable -out format=path flag.
+type outputTargetsFlag []outputTarget
+
+func (f *outputTargetsFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	texts := make([]string, len(*f))
+	for i, t := range *f {
+		texts[i] = t.String()
+	}
+	return strings.Join(texts, " ")
+}
+
+func (f *outputTargetsFlag) Set(value string) error {
+	format, path, ok := strings.Cut(value, "=")
+	if !ok || path == "" {
+		return fmt.Errorf("expected format=path, e.g. json=audit.json")
+	}
+	switch format {
+	case formatText, formatJSON, formatMbox, formatPatchDir:
+	default:
+		return fmt.Errorf("unknown format %q: expected %q, %q, %q or %q", format, formatText, formatJSON, formatMbox, formatPatchDir)
+	}
+	*f = append(*f, outputTarget{Format: format, Path: path})
+	return nil
+}
+
+// resolveOutputTargets checks the -out targets before any commit is audited: each path is made
+// absolute, kept out of the repository under -no-repo-writes, given its directory, and not
+// replaced without force. Two targets may not share a path.
+func resolveOutputTargets(targets []outputTarget, repoRoot string, noRepoWrites, force bool) ([]outputTarget, error) {
+	resolved := make([]outputTarget, len(targets))
+	seen := make(map[string]string)
+	for i, t := range targets {
+		if noRepoWrites {
+			if err := checkOutsideRepo(t.Path, repoRoot, "-out"); err != nil {
+				return nil, err
+			}
+		}
+		path, err := filepath.Abs(t.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve output path %s: %w", t.Path, err)
+		}
+		if other, ok := seen[path]; ok {
+			return nil, fmt.Errorf("-out %s and -out %s write the same path", other, t)
+		}
+		seen[path] = t.String()
+		if info, err := os.Stat(path); err == nil && info.IsDir() && t.Format != formatPatchDir {
+			return nil, fmt.Errorf("output path %s is a directory; -out %s names a file", path, t.Format)
+		}
+		if err := prepareOutputDir(filepath.Dir(path)); err != nil {
+			return nil, err
+		}
+		if !force {
+			if err := checkNoClobber(path); err != nil {
+				return nil, err
+			}
+		}
+		resolved[i] = outputTarget{Format: t.Format, Path: path}
+	}
+	return resolved, nil
+}
+
+// formatOutputTargets lists the targets of a run for the header of each artifact, so that
+// any one of them leads to its siblings.
+func formatOutputTargets(targets []outputTarget) []string {
+	texts := make([]string, len(targets))
+	for i, t := range targets {
+		texts[i] = t.String()
+	}
+	return texts
+}
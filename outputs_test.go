@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOutputTargetsFlag(t *testing.T) {
+	var targets outputTargetsFlag
+	for _, value := range []string{"json=out/audit.json", "text=report.txt", "mbox=a=b.mbox"} {
+		if err := targets.Set(value); err != nil {
+			t.Fatalf("Set(%q): %v", value, err)
+		}
+	}
+	// A path may hold "=", which only the first one splits.
+	want := outputTargetsFlag{{formatJSON, "out/audit.json"}, {formatText, "report.txt"}, {formatMbox, "a=b.mbox"}}
+	if !reflect.DeepEqual(targets, want) || targets.String() != "json=out/audit.json text=report.txt mbox=a=b.mbox" {
+		t.Errorf("targets %v", targets)
+	}
+	for value, err := range map[string]string{
+		"audit.json":           "expected format=path",
+		"json=":                "expected format=path",
+		"markdown=report.md":   `unknown format "markdown"`,
+		"sqlite=/warehouse.db": `unknown format "sqlite"`,
+	} {
+		if got := targets.Set(value); got == nil || !strings.Contains(got.Error(), err) {
+			t.Errorf("Set(%q) = %v, want %q", value, got, err)
+		}
+	}
+}
+
+func TestResolveOutputTargets(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing.txt")
+	if err := os.WriteFile(existing, []byte("report\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	nested := filepath.Join(dir, "a", "b", "audit.json")
+	resolved, err := resolveOutputTargets([]outputTarget{{formatJSON, nested}, {formatPatchDir, filepath.Join(dir, "patches")}}, "", false, false)
+	if err != nil || len(resolved) != 2 || resolved[0].Path != nested {
+		t.Fatalf("resolveOutputTargets = %v, %v", resolved, err)
+	}
+	if info, err := os.Stat(filepath.Dir(nested)); err != nil || !info.IsDir() {
+		t.Errorf("the directory of %s was not created: %v", nested, err)
+	}
+
+	for name, tc := range map[string]struct {
+		targets      []outputTarget
+		noRepoWrites bool
+		force        bool
+		want         string
+	}{
+		"same path":    {[]outputTarget{{formatText, existing}, {formatJSON, existing}}, false, true, "write the same path"},
+		"directory":    {[]outputTarget{{formatJSON, dir}}, false, false, "is a directory; -out json names a file"},
+		"no clobber":   {[]outputTarget{{formatText, existing}}, false, false, existing},
+		"in the repo":  {[]outputTarget{{formatText, filepath.Join(dir, "report.txt")}}, true, false, "-out"},
+		"second fails": {[]outputTarget{{formatJSON, filepath.Join(dir, "new.json")}, {formatText, existing}}, false, false, existing},
+	} {
+		if _, err := resolveOutputTargets(tc.targets, dir, tc.noRepoWrites, tc.force); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: %v, want %q", name, err, tc.want)
+		}
+	}
+	if _, err := resolveOutputTargets([]outputTarget{{formatText, existing}}, "", false, true); err != nil {
+		t.Errorf("-force: %v", err)
+	}
+}
+
+// TestMultipleOutputs writes three formats from one run, then has one writer fail while the
+// others complete.
+func TestMultipleOutputs(t *testing.T) {
+	repo := newFixtureRepo(t)
+	hashes := repo.commits(3)
+	env := newAuditEnv(t)
+	text := filepath.Join(env.Work, "wiki", "report.txt")
+	report := filepath.Join(env.Work, "pipeline", "audit.json")
+	mbox := filepath.Join(env.Work, "audit.mbox")
+	args := []string{"-repo", repo.Dir, "-commit", "root", "-out", "text=" + text, "-out", "json=" + report, "-out", "mbox=" + mbox}
+
+	out := env.mustRun(args...)
+	// The model is called once per commit, whatever the number of targets.
+	if n := len(env.Ollama.Prompts()); n != 3 {
+		t.Errorf("%d prompts for 3 commits", n)
+	}
+	siblings := "text=" + text + ", json=" + report + ", mbox=" + mbox
+	content := readFile(t, text)
+	if !strings.Contains(content, "Outputs of this run: "+siblings+"\n") || strings.Count(content, "Commit: ") != 3 {
+		t.Errorf("text report:\n%s", content)
+	}
+	var document jsonReport
+	if err := json.Unmarshal([]byte(readFile(t, report)), &document); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(document.Outputs, ", ") != siblings || len(document.Commits) != 3 || document.Partial {
+		t.Errorf("JSON report: outputs %q, %d commits, partial %v", document.Outputs, len(document.Commits), document.Partial)
+	}
+	// Every target carries the same summaries.
+	for _, entry := range document.Commits {
+		if !strings.Contains(content, entry.Summary) || !strings.Contains(readFile(t, mbox), entry.Summary) {
+			t.Errorf("the summary of %s differs between the targets", shortHash(entry.Hash))
+		}
+	}
+	if strings.Count(readFile(t, mbox), "\nFrom: ") != 3 || strings.Contains(out, "partial output") {
+		t.Errorf("output:\n%s", out)
+	}
+
+	// The JSON target turns into a directory mid-run, so its atomic rename fails; the text
+	// and mbox targets are still written in full, and the run fails naming each target.
+	env.Ollama.Respond = func(n int, prompt string) (int, string) {
+		if n == 4 {
+			os.Remove(report)
+			os.MkdirAll(filepath.Join(report, "blocker"), 0o755)
+		}
+		return 0, ""
+	}
+	out, code := env.run(append(args, "-force")...)
+	if code != 1 || !strings.Contains(out, "Error: failed to write JSON report "+report) ||
+		!strings.Contains(out, "Warning: partial output: wrote text="+text+", mbox="+mbox+"; failed to write json="+report+"\n") {
+		t.Errorf("exit %d\n%s", code, out)
+	}
+	if content := readFile(t, text); strings.Count(content, "Commit: ") != 3 || !strings.Contains(content, "Commit: "+hashes[0]) {
+		t.Errorf("text report after the JSON failure:\n%s", content)
+	}
+	if n := strings.Count(readFile(t, mbox), "\nFrom: "); n != 3 {
+		t.Errorf("the mbox has %d messages after the JSON failure", n)
+	}
+	if entries, _ := os.ReadDir(report); len(entries) != 1 {
+		t.Errorf("the JSON target directory holds %d entries; the failed write left files behind", len(entries))
+	}
+}
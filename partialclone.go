@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// missingObjectsReason is why a commit of a partial clone cannot be audited once its promisor
+// remote is out of reach: retrying would only repeat the failed fetch.
+const missingObjectsReason = "missing objects (partial clone, offline)"
+
+// gitNoLazyFetch stops git from fetching missing objects on demand. It is set when the blob
+// prefetch of a partial clone fails, so a commit whose blobs are missing fails at once
+// instead of waiting for a remote that is unreachable.
+var gitNoLazyFetch bool
+
+// promisorRemote returns the remote a partial clone fetches missing objects from, or "" when
+// repoPath is a full clone. Clones made by git before 2.24 name it in extensions.partialClone.
+func promisorRemote(repoPath string) (string, error) {
+	output, err := gitRun(context.Background(), repoPath, "config", "--list")
+	if err != nil {
+		return "", fmt.Errorf("failed to read the git configuration of %s: %w", repoPath, err)
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		key, value, _ := strings.Cut(line, "=")
+		if strings.EqualFold(key, "extensions.partialclone") && value != "" {
+			return value, nil
+		}
+		if name, ok := strings.CutPrefix(key, "remote."); ok && strings.HasSuffix(name, ".promisor") && value == "true" {
+			return strings.TrimSuffix(name, ".promisor"), nil
+		}
+	}
+	return "", nil
+}
+
+// missingBlobs lists the blobs that the diffs of hashes need and the clone does not have.
+// Trees are present in a blobless clone, so `git diff-tree` names the blobs without fetching
+// them; -m lists those of every parent of a merge.
+func missingBlobs(repoPath string, hashes []string, pathspec []string) ([]string, error) {
+	args := []string{"diff-tree", "-r", "-m", "--root", "--no-commit-id", "--stdin"}
+	if len(pathspec) > 0 {
+		args = append(append(args, "--"), pathspec...)
+	}
+	output, err := gitRunInput(context.Background(), repoPath, strings.NewReader(strings.Join(hashes, "\n")+"\n"), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the blobs of the audited range: %w", err)
+	}
+	needed := make(map[string]bool)
+	for _, line := range strings.Split(string(output), "\n") {
+		// :<old mode> <new mode> <old blob> <new blob> <status>\t<path>
+		fields := strings.Fields(line)
+		if len(fields) < 4 || !strings.HasPrefix(line, ":") {
+			continue
+		}
+		for _, blob := range fields[2:4] {
+			if strings.Trim(blob, "0") != "" {
+				needed[blob] = true
+			}
+		}
+	}
+	if len(needed) == 0 {
+		return nil, nil
+	}
+
+	// --batch-all-objects only lists local objects; it never fetches.
+	output, err = gitRun(context.Background(), repoPath, "cat-file", "--batch-all-objects", "--batch-check=%(objectname)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the objects of the clone: %w", err)
+	}
+	for _, object := range strings.Fields(string(output)) {
+		delete(needed, object)
+	}
+	missing := make([]string, 0, len(needed))
+	for blob := range needed {
+		missing = append(missing, blob)
+	}
+	sort.Strings(missing)
+	return missing, nil
+}
+
+// fetchBlobs fetches blobs from remote in one request, the way git fetches a single missing
+// object on demand: no refspec names a destination and FETCH_HEAD is not written, so only the
+// object store changes, never refs, the index or the working tree. Automatic gc and
+// maintenance are turned off, so the fetch does not repack the repository afterwards.
+func fetchBlobs(repoPath, remote string, blobs []string) error {
+	_, err := gitRunInput(context.Background(), repoPath, strings.NewReader(strings.Join(blobs, "\n")+"\n"),
+		"-c", "fetch.negotiationAlgorithm=noop", "-c", "gc.auto=0", "-c", "maintenance.auto=false", "fetch", remote,
+		"--no-tags", "--no-write-fetch-head", "--recurse-submodules=no", "--filter=blob:none", "--stdin")
+	if err != nil {
+		return fmt.Errorf("failed to fetch %d blobs from %s: %w", len(blobs), remote, err)
+	}
+	return nil
+}
+
+// prefetchPartialClone fetches the blobs a partial clone lacks for auditing hashes before the
+// audit starts. Without it, git fetches them one commit at a time as each patch is built. If
+// the prefetch fails, lazy fetching is disabled for the run and the commits whose blobs are
+// missing fail with missingObjectsReason. With noRepoWrites the object store is left alone:
+// the missing blobs are only counted. It returns the time the prefetch took, or 0 when there
+// was nothing to fetch.
+func prefetchPartialClone(repoPath string, hashes []string, pathspec []string, noRepoWrites bool) time.Duration {
+	remote, err := promisorRemote(repoPath)
+	if err != nil {
+		fmt.Printf("Warning: %v\n", err)
+		return 0
+	}
+	if remote == "" || len(hashes) == 0 {
+		return 0
+	}
+	started := time.Now()
+	blobs, err := missingBlobs(repoPath, hashes, pathspec)
+	if err != nil {
+		fmt.Printf("Warning: partial clone of %s detected, but %v; git fetches missing blobs as each commit needs them.\n", remote, err)
+		return 0
+	}
+	if len(blobs) == 0 {
+		debugf("partial clone of %s has every blob of the audited range", remote)
+		return 0
+	}
+	if noRepoWrites {
+		fmt.Printf("Warning: partial clone of %s lacks %s blobs of the audited range; -no-repo-writes skips the prefetch, which would add them to the object store, so git fetches them one commit at a time as each patch is built.\n", remote, formatCount(int64(len(blobs))))
+		return 0
+	}
+	fmt.Printf("Partial clone of %s: prefetching %s missing blobs for the audited range\n", remote, formatCount(int64(len(blobs))))
+	if err := fetchBlobs(repoPath, remote, blobs); err != nil {
+		gitNoLazyFetch = true
+		fmt.Printf("Warning: %v. Commits whose blobs are missing are reported as %s.\n", err, missingObjectsReason)
+		return time.Since(started)
+	}
+	elapsed := time.Since(started)
+	fmt.Printf("Prefetched %s blobs in %s\n", formatCount(int64(len(blobs))), formatDuration(elapsed))
+	return elapsed
+}
+
+// isMissingObjects reports whether err is a git command that failed because a partial clone
+// could not fetch an object it lacks.
+func isMissingObjects(err error) bool {
+	var gitErr *gitError
+	return errors.As(err, &gitErr) && strings.Contains(gitErr.Stderr, "from promisor remote")
+}
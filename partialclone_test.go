@@ -0,0 +1,154 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// partialClone makes a blobless clone of r without a checkout, so it has none of the blobs
+// of r's history, and returns its directory.
+func partialClone(t *testing.T, r *fixtureRepo) string {
+	t.Helper()
+	r.git("config", "uploadpack.allowFilter", "true")
+	r.git("config", "uploadpack.allowAnySHA1InWant", "true")
+	dir := filepath.Join(t.TempDir(), "clone")
+	cmd := exec.Command("git", "clone", "-q", "--filter=blob:none", "--no-checkout", "file://"+r.Dir, dir)
+	cmd.Env = append(os.Environ(), "GIT_CONFIG_NOSYSTEM=1")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Skipf("git cannot make a partial clone here: %v\n%s", err, out)
+	}
+	return dir
+}
+
+// cloneState is what a prefetch must not change: the refs and whether FETCH_HEAD exists.
+func cloneState(t *testing.T, dir string) string {
+	t.Helper()
+	clone := &fixtureRepo{t: t, Dir: dir}
+	_, err := os.Stat(filepath.Join(dir, ".git", "FETCH_HEAD"))
+	return clone.git("for-each-ref") + " FETCH_HEAD exists: " + map[bool]string{true: "yes", false: "no"}[err == nil]
+}
+
+func TestPromisorRemote(t *testing.T) {
+	repo := newFixtureRepo(t)
+	repo.commits(2)
+	if remote, err := promisorRemote(repo.Dir); err != nil || remote != "" {
+		t.Errorf("full clone: promisor remote %q, %v", remote, err)
+	}
+	if remote, err := promisorRemote(partialClone(t, repo)); err != nil || remote != "origin" {
+		t.Errorf("partial clone: promisor remote %q, %v", remote, err)
+	}
+}
+
+func TestPrefetchPartialClone(t *testing.T) {
+	repo := newFixtureRepo(t)
+	hashes := repo.commits(4)
+	clone := partialClone(t, repo)
+	defer func() { gitNoLazyFetch = false }()
+
+	// Every commit adds or changes one file: one blob each, none of them in the clone.
+	missing, err := missingBlobs(clone, hashes, nil)
+	if err != nil || len(missing) != 4 {
+		t.Fatalf("missing blobs %q, %v; want 4", missing, err)
+	}
+	// A pathspec narrows them to the blobs of the subtree.
+	if scoped, err := missingBlobs(clone, hashes, []string{":(top)src/file1.go"}); err != nil || len(scoped) != 1 {
+		t.Errorf("missing blobs of src/file1.go: %q, %v", scoped, err)
+	}
+
+	// Under -no-repo-writes the object store is left alone.
+	before := cloneState(t, clone)
+	var elapsed int64
+	out := captureStdout(t, func() { elapsed = int64(prefetchPartialClone(clone, hashes, nil, true)) })
+	if elapsed != 0 || !strings.Contains(out, "Warning: partial clone of origin lacks 4 blobs of the audited range; -no-repo-writes skips the prefetch") {
+		t.Errorf("prefetch with -no-repo-writes took %d:\n%s", elapsed, out)
+	}
+	if missing, _ := missingBlobs(clone, hashes, nil); len(missing) != 4 {
+		t.Errorf("-no-repo-writes fetched blobs: %d still missing", len(missing))
+	}
+
+	// Otherwise they are fetched in one request, adding objects only.
+	out = captureStdout(t, func() { elapsed = int64(prefetchPartialClone(clone, hashes, nil, false)) })
+	if elapsed <= 0 || !strings.Contains(out, "Partial clone of origin: prefetching 4 missing blobs for the audited range\n") || !strings.Contains(out, "Prefetched 4 blobs in ") {
+		t.Errorf("prefetch took %d:\n%s", elapsed, out)
+	}
+	if missing, err := missingBlobs(clone, hashes, nil); err != nil || len(missing) != 0 {
+		t.Errorf("after the prefetch %q are missing, %v", missing, err)
+	}
+	if after := cloneState(t, clone); after != before {
+		t.Errorf("the prefetch changed the clone:\n%s\nbefore:\n%s", after, before)
+	}
+	if gitNoLazyFetch {
+		t.Error("a successful prefetch turned off lazy fetching")
+	}
+	// Nothing is left to fetch, and a full clone has no remote to fetch from.
+	if out := captureStdout(t, func() { elapsed = int64(prefetchPartialClone(clone, hashes, nil, false)) }); elapsed != 0 || out != "" {
+		t.Errorf("second prefetch took %d:\n%s", elapsed, out)
+	}
+	if out := captureStdout(t, func() { elapsed = int64(prefetchPartialClone(repo.Dir, hashes, nil, false)) }); elapsed != 0 || out != "" {
+		t.Errorf("prefetch in a full clone took %d:\n%s", elapsed, out)
+	}
+}
+
+func TestPrefetchOffline(t *testing.T) {
+	repo := newFixtureRepo(t)
+	hashes := repo.commits(2)
+	clone := partialClone(t, repo)
+	defer func() { gitNoLazyFetch = false }()
+	// The promisor remote is gone, as when the machine is offline.
+	if err := os.Rename(repo.Dir, repo.Dir+".gone"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Rename(repo.Dir+".gone", repo.Dir)
+
+	out := captureStdout(t, func() { prefetchPartialClone(clone, hashes, nil, false) })
+	if !strings.Contains(out, "Warning: failed to fetch 2 blobs from origin") || !strings.Contains(out, "Commits whose blobs are missing are reported as missing objects (partial clone, offline).") {
+		t.Errorf("offline prefetch:\n%s", out)
+	}
+	if !gitNoLazyFetch {
+		t.Fatal("a failed prefetch left lazy fetching on")
+	}
+	// The patch fails at once and is recognized as missing objects.
+	_, err := getPatchForCommit(t.Context(), clone, hashes[0], promptPrivacy{true, true, true}, nil)
+	if err == nil || !isMissingObjects(err) {
+		t.Errorf("patch of a commit without its blobs: %v", err)
+	}
+}
+
+func TestPartialCloneRun(t *testing.T) {
+	repo := newFixtureRepo(t)
+	repo.commits(3)
+	env := newAuditEnv(t)
+	report := filepath.Join(env.Work, "report.txt")
+
+	// -repo . in the clone: -no-repo-writes is off, so the blobs are prefetched.
+	clone := partialClone(t, repo)
+	cmd := env.command("-repo", ".", "-commit", "root", "-output", report)
+	cmd.Dir = clone
+	out, err := cmd.CombinedOutput()
+	if err != nil || !strings.Contains(string(out), "Prefetched 3 blobs in ") || !strings.Contains(string(out), " prefetching blobs)") || len(env.Ollama.Prompts()) != 3 {
+		t.Errorf("run in a partial clone: %v, %d prompts:\n%s", err, len(env.Ollama.Prompts()), out)
+	}
+
+	// -repo naming another directory implies -no-repo-writes: git fetches on demand instead.
+	clone = partialClone(t, repo)
+	text := env.mustRun("-repo", clone, "-commit", "root", "-output", report, "-force")
+	if strings.Contains(text, "Prefetched") || !strings.Contains(text, "lacks 3 blobs of the audited range; -no-repo-writes skips the prefetch") || len(env.Ollama.Prompts()) != 6 {
+		t.Errorf("run with -no-repo-writes:\n%s", text)
+	}
+
+	// Offline, the commits fail at once as missing objects and the run exits with 1.
+	clone = partialClone(t, repo)
+	if err := os.Rename(repo.Dir, repo.Dir+".gone"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Rename(repo.Dir+".gone", repo.Dir)
+	cmd = env.command("-repo", ".", "-commit", "root", "-output", report, "-force")
+	cmd.Dir = clone
+	out, err = cmd.CombinedOutput()
+	if err == nil || !strings.Contains(string(out), "The following 3 commits could not be audited (missing objects (partial clone, offline)):") || len(env.Ollama.Prompts()) != 6 {
+		t.Errorf("offline run: %v:\n%s", err, out)
+	}
+}
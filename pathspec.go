@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// getRepoPrefix returns the path of repoPath relative to the working tree root, with a
+// trailing slash, or "" when repoPath is the root.
+func getRepoPrefix(repoPath string) (string, error) {
+	output, err := gitRun(context.Background(), repoPath, "rev-parse", "--show-prefix")
+	if err != nil {
+		return "", fmt.Errorf("failed to execute git rev-parse --show-prefix in %s: %w", repoPath, err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// subtreePathspec returns the pathspec that scopes an audit to the subtree at prefix. The
+// :(top) magic makes it independent of the directory git runs in.
+func subtreePathspec(prefix string) []string {
+	if prefix == "" {
+		return nil
+	}
+	return []string{":(top)" + prefix}
+}
+
+// filterByPathspec keeps the commits that change a path matched by pathspec, in their
+// original order. --full-history keeps commits on side branches whose changes a merge
+// discarded, which git's default history simplification would hide.
+func filterByPathspec(repoPath, tip string, hashes []string, pathspec []string) ([]string, error) {
+	if len(pathspec) == 0 || len(hashes) == 0 {
+		return hashes, nil
+	}
+	args := append([]string{"rev-list", "--full-history", tip, "--"}, pathspec...)
+	output, err := gitRun(context.Background(), repoPath, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the commits touching %s: %w", strings.Join(pathspec, " "), err)
+	}
+	touching := make(map[string]bool)
+	for _, hash := range strings.Fields(string(output)) {
+		touching[hash] = true
+	}
+	var kept []string
+	for _, hash := range hashes {
+		if touching[hash] {
+			kept = append(kept, hash)
+		}
+	}
+	return kept, nil
+}
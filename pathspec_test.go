@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// monorepo commits alternately to svc/api, svc/worker and web, and returns the hashes of
+// the commits touching each, oldest first.
+func monorepo(t *testing.T) (*fixtureRepo, map[string][]string) {
+	t.Helper()
+	repo := newFixtureRepo(t)
+	touched := make(map[string][]string)
+	for i, dir := range []string{"svc/api", "web", "svc/worker", "svc/api", "web"} {
+		hash := repo.commit("Change "+dir, map[string]string{dir + "/main.go": "package main // " + string(rune('a'+i)) + "\n"})
+		touched[dir] = append(touched[dir], hash)
+	}
+	// One commit touches the service and the web front end alike.
+	both := repo.commit("Rename the API", map[string]string{"svc/api/main.go": "package api\n", "web/main.go": "package web\n"})
+	touched["svc/api"] = append(touched["svc/api"], both)
+	touched["web"] = append(touched["web"], both)
+	return repo, touched
+}
+
+func TestGetRepoPrefix(t *testing.T) {
+	repo, _ := monorepo(t)
+	for dir, want := range map[string]string{
+		repo.Dir:                           "",
+		filepath.Join(repo.Dir, "svc"):     "svc/",
+		filepath.Join(repo.Dir, "svc/api"): "svc/api/",
+	} {
+		prefix, err := getRepoPrefix(dir)
+		if err != nil || prefix != want {
+			t.Errorf("getRepoPrefix(%s) = %q, %v; want %q", dir, prefix, err, want)
+		}
+	}
+	if _, err := getRepoPrefix(t.TempDir()); err == nil {
+		t.Error("getRepoPrefix outside a repository succeeded")
+	}
+	if got := subtreePathspec("svc/api/"); !reflect.DeepEqual(got, []string{":(top)svc/api/"}) {
+		t.Errorf("subtreePathspec = %q", got)
+	}
+	if got := subtreePathspec(""); got != nil {
+		t.Errorf("subtreePathspec of the root = %q", got)
+	}
+}
+
+func TestFilterByPathspec(t *testing.T) {
+	repo, touched := monorepo(t)
+	all := strings.Fields(repo.git("rev-list", "HEAD"))
+	// The pathspec is read from the top, wherever git runs: here, in another subdirectory.
+	kept, err := filterByPathspec(filepath.Join(repo.Dir, "web"), "HEAD", all, subtreePathspec("svc/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append(append([]string{}, touched["svc/api"]...), touched["svc/worker"]...)
+	if len(kept) != len(want) {
+		t.Fatalf("kept %d commits, want %d", len(kept), len(want))
+	}
+	// The order of the input is kept: newest first.
+	for i := 1; i < len(kept); i++ {
+		if indexOf(all, kept[i-1]) > indexOf(all, kept[i]) {
+			t.Errorf("commits reordered: %q", kept)
+		}
+	}
+	for _, hash := range want {
+		if indexOf(kept, hash) < 0 {
+			t.Errorf("%s touches svc/ but was dropped", hash)
+		}
+	}
+
+	// A side branch whose change the merge discarded is still listed (--full-history).
+	base := repo.git("rev-parse", "HEAD")
+	repo.git("checkout", "-q", "-b", "side")
+	discarded := repo.commit("Try another worker", map[string]string{"svc/worker/main.go": "package experiment\n"})
+	repo.git("checkout", "-q", "-")
+	repo.git("merge", "-q", "-s", "ours", "--no-edit", "side")
+	kept, err = filterByPathspec(repo.Dir, "HEAD", []string{discarded, base}, subtreePathspec("svc/worker/"))
+	if err != nil || !reflect.DeepEqual(kept, []string{discarded}) {
+		t.Errorf("side branch commit: kept %q, %v", kept, err)
+	}
+
+	if kept, err := filterByPathspec(repo.Dir, "HEAD", all, nil); err != nil || !reflect.DeepEqual(kept, all) {
+		t.Errorf("no pathspec changed the commits: %q, %v", kept, err)
+	}
+}
+
+func indexOf(list []string, s string) int {
+	for i, v := range list {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestSubdirectoryRun(t *testing.T) {
+	repo, touched := monorepo(t)
+	env := newAuditEnv(t)
+	report := filepath.Join(env.Work, "report.txt")
+
+	// audit runs gitaudit in dir with -repo repoArg and returns its output and the prompts it sent.
+	audit := func(dir, repoArg string, extra ...string) (string, []string) {
+		t.Helper()
+		before := len(env.Ollama.Prompts())
+		cmd := env.command(append([]string{"-repo", repoArg, "-commit", "root", "-output", report, "-force"}, extra...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("gitaudit -repo %s in %s: %v\n%s", repoArg, dir, err, out)
+		}
+		return string(out), env.Ollama.Prompts()[before:]
+	}
+
+	// From the root, every commit and every file.
+	out, prompts := audit(repo.Dir, ".")
+	if len(prompts) != 6 || strings.Contains(out, "Scope:") {
+		t.Errorf("from the root: %d prompts:\n%s", len(prompts), out)
+	}
+
+	for _, tt := range []struct{ dir, repoArg, scope string }{
+		// From the subdirectory itself, with -repo naming the subdirectory, and a nested path.
+		{filepath.Join(repo.Dir, "svc"), ".", "svc/"},
+		{env.Work, filepath.Join(repo.Dir, "svc"), "svc/"},
+		{repo.Dir, "svc/api", "svc/api/"},
+	} {
+		out, prompts := audit(tt.dir, tt.repoArg)
+		dir := strings.TrimSuffix(tt.scope, "/")
+		want := len(touched["svc/api"])
+		if dir == "svc" {
+			want += len(touched["svc/worker"])
+		}
+		header := fmt.Sprintf("Scope: %s only, because -repo is a subdirectory of the repository (-no-implicit-pathspec audits whole commits); %d of 6 commits touch it\n", tt.scope, want)
+		if !strings.Contains(out, header) {
+			t.Errorf("-repo %s in %s: header lacks %q:\n%s", tt.repoArg, tt.dir, header, out)
+		}
+		if len(prompts) != want {
+			t.Errorf("-repo %s in %s: %d prompts, want %d", tt.repoArg, tt.dir, len(prompts), want)
+		}
+		// Patches are scoped to the subtree: the commit touching web too sends only its svc half.
+		for _, prompt := range prompts {
+			if strings.Contains(prompt, "web/main.go") || !strings.Contains(prompt, "diff --git a/"+dir) {
+				t.Errorf("-repo %s: prompt not scoped to %s:\n%s", tt.repoArg, tt.scope, prompt)
+			}
+		}
+		if text := readFile(t, report); strings.Contains(text, "Change web") {
+			t.Errorf("-repo %s: report lists commits outside %s", tt.repoArg, tt.scope)
+		}
+	}
+
+	// -no-implicit-pathspec audits whole commits, as from the root.
+	out, prompts = audit(filepath.Join(repo.Dir, "svc"), ".", "-no-implicit-pathspec")
+	if len(prompts) != 6 || strings.Contains(out, "Scope:") {
+		t.Errorf("-no-implicit-pathspec: %d prompts:\n%s", len(prompts), out)
+	}
+	if !strings.Contains(strings.Join(prompts, "\n"), "diff --git a/web/main.go") {
+		t.Error("-no-implicit-pathspec scoped the patches")
+	}
+}
+
+func TestFileHistoryPathRelativeToRepo(t *testing.T) {
+	repo, _ := monorepo(t)
+	env := newAuditEnv(t)
+	document := filepath.Join(env.Work, "history.md")
+	// The path is relative to the subdirectory -repo names.
+	env.mustRun("file", "-repo", filepath.Join(repo.Dir, "svc"), "-output", document, "api/main.go")
+	if text := readFile(t, document); !strings.Contains(text, "All 3 commits touching the file") || !strings.Contains(text, "Path: `svc/api/main.go`") {
+		t.Errorf("history of svc/api/main.go:\n%s", text)
+	}
+	if out, code := env.run("file", "-repo", repo.Dir, "-output", document, "-force", "api/main.go"); code == 0 {
+		t.Errorf("api/main.go from the root succeeded:\n%s", out)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// pauseWindow is a daily period, e.g. a GPU host's maintenance reboot, during which no
+// commit is started. A window whose end is before its start crosses midnight.
+type pauseWindow struct {
+	Start, End time.Duration // Offsets from midnight.
+	Location   *time.Location
+	Text       string // As given on the command line.
+}
+
+// parsePauseWindow parses "HH:MM-HH:MM", optionally followed by an IANA time zone such as
+// "02:55-03:30 Europe/Berlin". Without a zone the local time zone is used.
+func parsePauseWindow(value string) (pauseWindow, error) {
+	w := pauseWindow{Location: time.Local, Text: value}
+	span, zone, hasZone := strings.Cut(strings.TrimSpace(value), " ")
+	if hasZone {
+		loc, err := time.LoadLocation(strings.TrimSpace(zone))
+		if err != nil {
+			return w, fmt.Errorf("invalid -pause-window %q: unknown time zone %q", value, zone)
+		}
+		w.Location = loc
+	}
+	from, to, ok := strings.Cut(span, "-")
+	if !ok {
+		return w, fmt.Errorf("invalid -pause-window %q: expected HH:MM-HH:MM, optionally followed by a time zone", value)
+	}
+	var err error
+	if w.Start, err = parseClock(from); err != nil {
+		return w, fmt.Errorf("invalid -pause-window %q: %w", value, err)
+	}
+	if w.End, err = parseClock(to); err != nil {
+		return w, fmt.Errorf("invalid -pause-window %q: %w", value, err)
+	}
+	if w.Start == w.End {
+		return w, fmt.Errorf("invalid -pause-window %q: the window is empty", value)
+	}
+	return w, nil
+}
+
+// parseClock parses a 24-hour "HH:MM" time of day.
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a time of day such as 02:55", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// String renders the window for the run header, e.g. "02:55-03:30 (Europe/Berlin)".
+func (w pauseWindow) String() string {
+	span, _, _ := strings.Cut(strings.TrimSpace(w.Text), " ")
+	return fmt.Sprintf("%s (%s)", span, w.Location)
+}
+
+// endAt returns the end of the occurrence of the window that contains t, or the zero time
+// when t is outside the window.
+func (w pauseWindow) endAt(t time.Time) time.Time {
+	local := t.In(w.Location)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, w.Location)
+	offset := local.Sub(midnight)
+	clock := func(day time.Time, d time.Duration) time.Time {
+		// time.Date rather than Add, so that a DST change during the day is honoured.
+		return time.Date(day.Year(), day.Month(), day.Day(), int(d/time.Hour), int(d%time.Hour/time.Minute), 0, 0, w.Location)
+	}
+	switch {
+	case w.Start < w.End && offset >= w.Start && offset < w.End:
+		return clock(midnight, w.End)
+	case w.Start > w.End && offset >= w.Start:
+		return clock(midnight.AddDate(0, 0, 1), w.End)
+	case w.Start > w.End && offset < w.End:
+		return clock(midnight, w.End)
+	}
+	return time.Time{}
+}
+
+// pauseScheduler implements -pause-window: before each commit it checks whether the run is
+// inside a window and, if so, sleeps until the window ends or, with -exit-on-pause, stops
+// the run like an interrupt so that the commits audited so far are written. A commit in
+// flight when a window starts is always completed.
+type pauseScheduler struct {
+	Windows     []pauseWindow
+	ExitOnPause bool
+	Progress    *progressReporter
+
+	// Stopped is the window that stopped the run with -exit-on-pause.
+	Stopped *pauseWindow
+	// Paused totals the time spent sleeping in windows.
+	Paused time.Duration
+
+	// now and sleepUntil are the clock, time.Now and waitUntil unless a test replaces them.
+	now        func() time.Time
+	sleepUntil func(time.Time)
+}
+
+func (s *pauseScheduler) clock() time.Time {
+	if s.now == nil {
+		return time.Now()
+	}
+	return s.now()
+}
+
+func (s *pauseScheduler) sleep(until time.Time) {
+	if s.sleepUntil == nil {
+		waitUntil(until)
+		return
+	}
+	s.sleepUntil(until)
+}
+
+// activeWindow returns the window containing now and when the pause ends. Overlapping and
+// adjacent windows are merged, so the pause lasts until no window applies; windows covering
+// the whole day pause for a day at a time.
+func (s *pauseScheduler) activeWindow(now time.Time) (*pauseWindow, time.Time) {
+	var first *pauseWindow
+	until := now
+	for changed := true; changed && until.Sub(now) < 24*time.Hour; {
+		changed = false
+		for i := range s.Windows {
+			if end := s.Windows[i].endAt(until); !end.IsZero() && end.After(until) {
+				if first == nil {
+					first = &s.Windows[i]
+				}
+				until = end
+				changed = true
+			}
+		}
+	}
+	return first, until
+}
+
+// Wait blocks while the current time is inside a pause window. It returns false when the run
+// must stop instead: after -exit-on-pause has stopped it, or when it was interrupted.
+func (s *pauseScheduler) Wait() bool {
+	if s == nil {
+		return true
+	}
+	if s.Stopped != nil {
+		return false
+	}
+	window, until := s.activeWindow(s.clock())
+	if window == nil {
+		return true
+	}
+	if s.ExitOnPause {
+		fmt.Printf("Reached pause window %s; stopping after writing the commits audited so far (-exit-on-pause).\n", window)
+		s.Stopped = window
+		stopRun(fmt.Errorf("reached pause window %s", window))
+		return false
+	}
+	fmt.Printf("Pausing for window %s until %s.\n", window, until.In(window.Location).Format("2006-01-02 15:04 MST"))
+	started := s.clock()
+	s.Progress.SetPaused(until)
+	s.sleep(until)
+	s.Progress.SetPaused(time.Time{})
+	paused := s.clock().Sub(started)
+	s.Paused += paused
+
+	if stopping() {
+		return false
+	}
+	fmt.Printf("Resuming after pause window %s (paused %s).\n", window, formatDuration(paused.Truncate(time.Second)))
+	return true
+}
+
+// pauseWindowsFlag collects the repeatable -pause-window flag.
+type pauseWindowsFlag []pauseWindow
+
+func (f *pauseWindowsFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	texts := make([]string, len(*f))
+	for i, w := range *f {
+		texts[i] = w.Text
+	}
+	return strings.Join(texts, ", ")
+}
+
+func (f *pauseWindowsFlag) Set(value string) error {
+	w, err := parsePauseWindow(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, w)
+	return nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustPauseWindow(t *testing.T, value string) pauseWindow {
+	t.Helper()
+	w, err := parsePauseWindow(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func TestParsePauseWindow(t *testing.T) {
+	w := mustPauseWindow(t, "02:55-03:30")
+	if w.Start != 2*time.Hour+55*time.Minute || w.End != 3*time.Hour+30*time.Minute || w.Location != time.Local {
+		t.Errorf("02:55-03:30 = %+v", w)
+	}
+	w = mustPauseWindow(t, "23:30-00:30 Europe/Berlin")
+	if w.Location.String() != "Europe/Berlin" || w.String() != "23:30-00:30 (Europe/Berlin)" {
+		t.Errorf("window with a zone = %+v, %s", w, w)
+	}
+	for value, want := range map[string]string{
+		"0255-0330":                `"0255" is not a time of day`,
+		"25:00-03:00":              `"25:00" is not a time of day`,
+		"02:55-3pm":                `"3pm" is not a time of day`,
+		"02:55-02:55":              "the window is empty",
+		"02:55-03:30 Mars/Olympus": `unknown time zone "Mars/Olympus"`,
+	} {
+		if _, err := parsePauseWindow(value); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("parsePauseWindow(%q) = %v, want %q", value, err, want)
+		}
+	}
+}
+
+func TestPauseWindowEndAt(t *testing.T) {
+	at := func(day, hour, minute int) time.Time { return time.Date(2024, 1, day, hour, minute, 0, 0, time.UTC) }
+	maintenance := mustPauseWindow(t, "02:55-03:30 UTC")
+	midnight := mustPauseWindow(t, "23:50-00:10 UTC")
+	tests := []struct {
+		window pauseWindow
+		t      time.Time
+		want   time.Time
+	}{
+		{maintenance, at(1, 2, 54), time.Time{}},
+		{maintenance, at(1, 2, 55), at(1, 3, 30)},
+		{maintenance, at(1, 3, 29), at(1, 3, 30)},
+		{maintenance, at(1, 3, 30), time.Time{}},
+		// Crossing midnight, from either side.
+		{midnight, at(1, 23, 49), time.Time{}},
+		{midnight, at(1, 23, 50), at(2, 0, 10)},
+		{midnight, at(2, 0, 5), at(2, 0, 10)},
+		{midnight, at(2, 0, 10), time.Time{}},
+	}
+	for _, tt := range tests {
+		if got := tt.window.endAt(tt.t); !got.Equal(tt.want) {
+			t.Errorf("%s at %s: end %s, want %s", tt.window, tt.t.Format("15:04"), got, tt.want)
+		}
+	}
+
+	// The window is in its own zone: 02:55 in Berlin is 01:55 UTC in winter.
+	berlin := mustPauseWindow(t, "02:55-03:30 Europe/Berlin")
+	if end := berlin.endAt(at(1, 2, 0)); !end.Equal(at(1, 2, 30)) {
+		t.Errorf("Berlin window at 02:00 UTC ends %s, want 02:30 UTC", end.UTC())
+	}
+}
+
+func TestActiveWindowMergesWindows(t *testing.T) {
+	at := func(hour, minute int) time.Time { return time.Date(2024, 1, 1, hour, minute, 0, 0, time.UTC) }
+	tests := []struct {
+		windows []string
+		now     time.Time
+		until   time.Time
+	}{
+		{[]string{"02:00-03:00 UTC", "03:00-04:00 UTC"}, at(2, 30), at(4, 0)},
+		{[]string{"02:30-05:00 UTC", "02:00-03:00 UTC"}, at(2, 10), at(5, 0)},
+		{[]string{"02:00-03:00 UTC", "04:00-05:00 UTC"}, at(2, 10), at(3, 0)},
+		{[]string{"02:00-03:00 UTC"}, at(3, 0), time.Time{}},
+	}
+	for _, tt := range tests {
+		s := &pauseScheduler{}
+		for _, w := range tt.windows {
+			s.Windows = append(s.Windows, mustPauseWindow(t, w))
+		}
+		window, until := s.activeWindow(tt.now)
+		if tt.until.IsZero() {
+			if window != nil {
+				t.Errorf("%v at %s: paused by %s", tt.windows, tt.now.Format("15:04"), window)
+			}
+			continue
+		}
+		if window == nil || !until.Equal(tt.until) {
+			t.Errorf("%v at %s: pause until %s, want %s", tt.windows, tt.now.Format("15:04"), until.Format("15:04"), tt.until.Format("15:04"))
+		}
+	}
+
+	// Windows covering the whole day pause a day at a time rather than forever.
+	s := &pauseScheduler{Windows: []pauseWindow{mustPauseWindow(t, "00:00-12:00 UTC"), mustPauseWindow(t, "12:00-00:00 UTC")}}
+	if _, until := s.activeWindow(at(6, 0)); until.Sub(at(6, 0)) < 24*time.Hour || until.Sub(at(6, 0)) > 48*time.Hour {
+		t.Errorf("all-day windows pause until %s", until)
+	}
+}
+
+func TestPauseSchedulerFakeClock(t *testing.T) {
+	now := time.Date(2024, 1, 1, 22, 0, 0, 0, time.UTC)
+	var sleeps []time.Time
+	s := &pauseScheduler{
+		Windows:    []pauseWindow{mustPauseWindow(t, "02:55-03:30 UTC"), mustPauseWindow(t, "23:50-00:10 UTC")},
+		Progress:   &progressReporter{Total: 60, stop: make(chan struct{})},
+		now:        func() time.Time { return now },
+		sleepUntil: func(until time.Time) { sleeps = append(sleeps, until); now = until },
+	}
+	start := now
+	var starts []time.Time
+	out := captureStdout(t, func() {
+		// Sixty commits of seven minutes each, from 22:00 through the night.
+		for i := 0; i < 60; i++ {
+			if !s.Wait() {
+				t.Fatal("Wait stopped the run without -exit-on-pause")
+			}
+			starts = append(starts, now)
+			now = now.Add(7 * time.Minute)
+		}
+	})
+
+	// No request starts inside a window; the one in flight at 02:55, started at 02:51, runs on.
+	for _, started := range starts {
+		for _, w := range s.Windows {
+			if !w.endAt(started).IsZero() {
+				t.Errorf("commit started at %s, inside %s", started.Format("15:04"), w)
+			}
+		}
+	}
+	want := []time.Time{time.Date(2024, 1, 2, 0, 10, 0, 0, time.UTC), time.Date(2024, 1, 2, 3, 30, 0, 0, time.UTC)}
+	if len(sleeps) != 2 || !sleeps[0].Equal(want[0]) || !sleeps[1].Equal(want[1]) {
+		t.Errorf("slept until %v, want %v", sleeps, want)
+	}
+	// The 23:52 commit waits 18 minutes, the 02:58 one 32: the paused time is what is taken
+	// out of the run time for the rates.
+	if s.Paused != 50*time.Minute || now.Sub(start)-s.Paused != 60*7*time.Minute {
+		t.Errorf("paused %s of %s, want 50m of the run and 7h of work", s.Paused, now.Sub(start))
+	}
+	if !strings.Contains(out, "Pausing for window 23:50-00:10 (UTC) until 2024-01-02 00:10 UTC.\n") || !strings.Contains(out, "Resuming after pause window 02:55-03:30 (UTC) (paused 32m).\n") {
+		t.Errorf("output:\n%s", out)
+	}
+
+	var none *pauseScheduler
+	if !none.Wait() {
+		t.Error("a run without -pause-window paused")
+	}
+}
+
+func TestExitOnPauseRun(t *testing.T) {
+	repo := newFixtureRepo(t)
+	repo.commits(2)
+	env := newAuditEnv(t)
+	// A window around the current minute, so the run is inside it from the start.
+	now := time.Now().UTC()
+	window := now.Add(-time.Minute).Format("15:04") + "-" + now.Add(3*time.Minute).Format("15:04") + " UTC"
+	report := filepath.Join(env.Work, "report.txt")
+	out, _ := env.run("-repo", repo.Dir, "-commit", "root", "-output", report, "-pause-window", window, "-exit-on-pause")
+	if len(env.Ollama.Prompts()) != 0 {
+		t.Errorf("%d requests started inside the pause window:\n%s", len(env.Ollama.Prompts()), out)
+	}
+	for _, want := range []string{
+		"Pause Windows: " + strings.TrimSuffix(window, " UTC") + " (UTC); stopping at the first",
+		"Reached pause window",
+		"Process stopped for pause window",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+
+	// Outside any window the run is not held up.
+	far := now.Add(6*time.Hour).Format("15:04") + "-" + now.Add(7*time.Hour).Format("15:04") + " UTC"
+	out = env.mustRun("-repo", repo.Dir, "-commit", "root", "-output", report, "-force", "-pause-window", far, "-exit-on-pause")
+	if len(env.Ollama.Prompts()) != 2 || strings.Contains(out, "Reached pause window") {
+		t.Errorf("run outside the window:\n%s", out)
+	}
+}
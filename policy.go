@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// withheldFile records a file section removed from a patch by the never_send policy.
+type withheldFile struct {
+	Path string
+	Glob string
+}
+
+// policyViolationError is returned when -strict-policy is set and a commit touches a
+// never_send path. It is fatal for the run rather than a reason to retry.
+type policyViolationError struct {
+	Hash     string
+	Withheld []withheldFile
+}
+
+func (e *policyViolationError) Error() string {
+	var paths []string
+	for _, w := range e.Withheld {
+		paths = append(paths, fmt.Sprintf("%s (matched %q)", w.Path, w.Glob))
+	}
+	return fmt.Sprintf("commit %s touches files covered by the never_send policy: %s", e.Hash, strings.Join(paths, ", "))
+}
+
+// diffSectionPath returns the path of a "diff --git a/<old> b/<new>" header line, preferring the new path.
+func diffSectionPath(header string) string {
+	rest := strings.TrimPrefix(header, "diff --git ")
+	if idx := strings.LastIndex(rest, " b/"); idx >= 0 {
+		return rest[idx+3:]
+	}
+	return strings.TrimPrefix(rest, "a/")
+}
+
+// applySendPolicy removes every file section of patch whose path matches one of the
+// never_send globs. Renames are checked against both their old and new paths. The text
+// before the first file section (the commit header and message) is always kept.
+// It returns the filtered patch, the withheld files, and the number of file sections that remain.
+func applySendPolicy(patch string, globs []string) (string, []withheldFile, int) {
+	if len(globs) == 0 {
+		return patch, nil, strings.Count(patch, "\ndiff --git ")
+	}
+
+	var kept strings.Builder
+	var withheld []withheldFile
+	remaining := 0
+	skipping := false
+	for _, line := range strings.SplitAfter(patch, "\n") {
+		if strings.HasPrefix(line, "diff --git ") {
+			header := strings.TrimRight(line, "\n")
+			path := diffSectionPath(header)
+			glob, matched := matchAnyGlob(globs, path)
+			if !matched {
+				// A rename out of a restricted directory must not leak its old path either.
+				rest := strings.TrimPrefix(header, "diff --git a/")
+				if idx := strings.LastIndex(rest, " b/"); idx >= 0 {
+					glob, matched = matchAnyGlob(globs, rest[:idx])
+				}
+			}
+			skipping = matched
+			if matched {
+				withheld = append(withheld, withheldFile{Path: path, Glob: glob})
+				continue
+			}
+			remaining++
+		}
+		if !skipping {
+			kept.WriteString(line)
+		}
+	}
+	return kept.String(), withheld, remaining
+}
+
+// filterPolicyFiles removes never_send paths from a name-status file list (as produced by
+// getCommitStats) so -message-only prompts do not name withheld files either.
+func filterPolicyFiles(files []string, globs []string) ([]string, []withheldFile) {
+	if len(globs) == 0 {
+		return files, nil
+	}
+	var kept []string
+	var withheld []withheldFile
+	for _, entry := range files {
+		// Entries look like "M\tpath" or "R100\told\tnew".
+		fields := strings.Split(entry, "\t")
+		var hit *withheldFile
+		for _, path := range fields[1:] {
+			if glob, ok := matchAnyGlob(globs, path); ok {
+				hit = &withheldFile{Path: fields[len(fields)-1], Glob: glob}
+				break
+			}
+		}
+		if hit != nil {
+			withheld = append(withheld, *hit)
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	return kept, withheld
+}
+
+// policyNote is the human-readable note used in prompts and entries for withheld files.
+func policyNote(count int) string {
+	if count == 1 {
+		return "1 file withheld by policy"
+	}
+	return fmt.Sprintf("%d files withheld by policy", count)
+}
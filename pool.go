@@ -0,0 +1,76 @@
+package main
+
+// auditPool audits up to Workers commits at the same time (-concurrency). Start and Done are
+// called on the caller's goroutine, in the order of the commits, so the entries, log lines
+// and checkpoints of a run are the same whichever request finishes first; only Audit runs on
+// the pool's goroutines. With one worker each commit is started once the previous one is
+// done, as a sequential loop would.
+type auditPool struct {
+	Workers int
+	// Start is called before a commit is audited; returning false stops the pool from
+	// starting it or any later commit.
+	Start func(hash string) bool
+	Audit func(hash string) (CommitAuditData, error)
+	// Done receives the outcome of each started commit.
+	Done func(hash string, data CommitAuditData, err error)
+}
+
+// poolResult is the outcome of one commit, valid once done is closed.
+type poolResult struct {
+	hash string
+	data CommitAuditData
+	err  error
+	done chan struct{}
+}
+
+// Run audits hashes and returns those that were never started because Start refused one.
+// The commits in flight when that happens are waited for and passed to Done first.
+func (p *auditPool) Run(hashes []string) []string {
+	workers := max(p.Workers, 1)
+	finished := make(chan struct{}, len(hashes))
+	var queue []*poolResult // Started commits not yet passed to Done, in order.
+	running := 0
+	// deliver passes the leading finished results of the queue to Done.
+	deliver := func() {
+		for len(queue) > 0 {
+			select {
+			case <-queue[0].done:
+			default:
+				return
+			}
+			result := queue[0]
+			queue = queue[1:]
+			p.Done(result.hash, result.data, result.err)
+		}
+	}
+	// wait blocks until one running commit finishes.
+	wait := func() {
+		<-finished
+		running--
+		deliver()
+	}
+
+	var unstarted []string
+	for i, hash := range hashes {
+		deliver()
+		for running >= workers {
+			wait()
+		}
+		if !p.Start(hash) {
+			unstarted = hashes[i:]
+			break
+		}
+		result := &poolResult{hash: hash, done: make(chan struct{})}
+		queue = append(queue, result)
+		running++
+		go func() {
+			result.data, result.err = p.Audit(hash)
+			close(result.done)
+			finished <- struct{}{}
+		}()
+	}
+	for running > 0 {
+		wait()
+	}
+	return unstarted
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// promptPrivacy selects which commit metadata is sent to the model, per the
+// send_original_message, send_author and send_dates config keys. Withheld fields are only
+// kept out of prompts; the entries written locally always carry the full metadata.
+type promptPrivacy struct {
+	SendMessage bool
+	SendAuthor  bool
+	SendDates   bool
+}
+
+// privacy returns the prompt privacy settings of the config; everything is sent by default.
+func (c *Config) privacy() promptPrivacy {
+	enabled := func(b *bool) bool { return b == nil || *b }
+	return promptPrivacy{
+		SendMessage: enabled(c.SendOriginalMessage),
+		SendAuthor:  enabled(c.SendAuthor),
+		SendDates:   enabled(c.SendDates),
+	}
+}
+
+// String describes the settings for the run header, e.g.
+// "original message sent, author withheld, dates withheld".
+func (p promptPrivacy) String() string {
+	state := func(sent bool) string {
+		if sent {
+			return "sent"
+		}
+		return "withheld"
+	}
+	return fmt.Sprintf("original message %s, author %s, dates %s", state(p.SendMessage), state(p.SendAuthor), state(p.SendDates))
+}
+
+// reportText describes the settings in the locale of the report, for its settings header.
+func (p promptPrivacy) reportText() string {
+	state := func(sent bool) string {
+		if sent {
+			return msg("privacy.sent")
+		}
+		return msg("privacy.withheld")
+	}
+	return msg("privacy.summary", state(p.SendMessage), state(p.SendAuthor), state(p.SendDates))
+}
+
+// filter returns value, or an empty string when the field is withheld, so that prompt
+// builders always receive every field.
+func (p promptPrivacy) filter(send bool, value string) string {
+	if !send {
+		return ""
+	}
+	return value
+}
+
+// promptMetadata is the commit metadata at the top of a commit's prompt, in the layout of
+// `git show`'s medium format. Withheld fields are empty and their lines are left out.
+type promptMetadata struct {
+	// Lines are the "commit" and, for merges, "Merge:" lines, which are always sent.
+	Lines   []string
+	Author  string
+	Date    string
+	Message string
+}
+
+// parsePromptMetadata splits the output of `git show --no-patch --format=medium`.
+func parsePromptMetadata(header string) promptMetadata {
+	var m promptMetadata
+	head, message, _ := strings.Cut(header, "\n\n")
+	for _, line := range strings.Split(head, "\n") {
+		switch {
+		case strings.HasPrefix(line, "Author: "):
+			m.Author = strings.TrimPrefix(line, "Author: ")
+		case strings.HasPrefix(line, "Date:   "):
+			m.Date = strings.TrimPrefix(line, "Date:   ")
+		default:
+			m.Lines = append(m.Lines, line)
+		}
+	}
+	m.Message = message
+	return m
+}
+
+// withhold empties the fields privacy keeps out of the prompt.
+func (m promptMetadata) withhold(privacy promptPrivacy) promptMetadata {
+	m.Author = privacy.filter(privacy.SendAuthor, m.Author)
+	m.Date = privacy.filter(privacy.SendDates, m.Date)
+	m.Message = privacy.filter(privacy.SendMessage, m.Message)
+	return m
+}
+
+// render reproduces the medium format, so that with every field sent the prompt is the same
+// as `git show --patch` output.
+func (m promptMetadata) render() string {
+	lines := append([]string{}, m.Lines...)
+	if m.Author != "" {
+		lines = append(lines, "Author: "+m.Author)
+	}
+	if m.Date != "" {
+		lines = append(lines, "Date:   "+m.Date)
+	}
+	header := strings.Join(lines, "\n") + "\n"
+	if m.Message != "" {
+		header += "\n" + m.Message
+	}
+	return header
+}
+
+// getPromptMetadata reads a commit's metadata in `git show`'s medium format and withholds
+// the fields privacy keeps out of prompts.
+func getPromptMetadata(ctx context.Context, repoPath, commitHash string, privacy promptPrivacy) (string, error) {
+	output, err := gitRun(ctx, repoPath, "show", "--no-patch", "--format=medium", commitHash)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute git show for metadata on commit %s: %w", commitHash, err)
+	}
+	return parsePromptMetadata(string(output)).withhold(privacy).render(), nil
+}
+
+// promptText returns the patch as sent to the model: for a `git format-patch` message, the
+// From and Date headers and the Subject and message body are removed when privacy withholds
+// them. A plain diff has no metadata and is returned unchanged.
+func (p stdinPatch) promptText(privacy promptPrivacy) string {
+	if p.Hash == "" || (privacy.SendMessage && privacy.SendAuthor && privacy.SendDates) {
+		return p.Text
+	}
+	lines := strings.Split(p.Text, "\n")
+	var kept []string
+	inHeaders, inMessage, dropping := true, false, false
+	for i, line := range lines {
+		switch {
+		case i == 0:
+			// The "From <hash>" delimiter.
+		case inHeaders && line == "":
+			inHeaders, inMessage, dropping = false, true, false
+		case inHeaders && (line[0] == ' ' || line[0] == '\t'):
+			if dropping {
+				continue // Continuation of a withheld header.
+			}
+		case inHeaders:
+			name, _, _ := strings.Cut(line, ":")
+			switch strings.ToLower(name) {
+			case "from":
+				dropping = !privacy.SendAuthor
+			case "date":
+				dropping = !privacy.SendDates
+			case "subject":
+				dropping = !privacy.SendMessage
+			default:
+				dropping = false
+			}
+			if dropping {
+				continue
+			}
+		case inMessage && (line == "---" || strings.HasPrefix(line, "diff --git ")):
+			inMessage = false
+		case inMessage && !privacy.SendMessage:
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return strings.Join(kept, "\n")
+}
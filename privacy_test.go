@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// privacyCombinations are every combination of the three prompt privacy switches.
+func privacyCombinations() []promptPrivacy {
+	var combinations []promptPrivacy
+	for i := 0; i < 8; i++ {
+		combinations = append(combinations, promptPrivacy{SendMessage: i&1 == 0, SendAuthor: i&2 == 0, SendDates: i&4 == 0})
+	}
+	return combinations
+}
+
+// withheldCommit commits a change by an author whose name, email, date and message must not
+// reach the model when withheld.
+func withheldCommit(repo *fixtureRepo) string {
+	return repo.commitEnv([]string{"GIT_AUTHOR_NAME=Ada Lovelace", "GIT_AUTHOR_EMAIL=ada@example.com"},
+		"Add the codename vault\n\nProject NIGHTJAR keys live here.", map[string]string{"vault.go": "package vault\n"})
+}
+
+func TestPatchPromptPrivacy(t *testing.T) {
+	repo := newFixtureRepo(t)
+	repo.commits(1)
+	hash := withheldCommit(repo)
+
+	// With everything sent, the prompt's patch is `git show --patch` byte for byte.
+	full, err := getPatchForCommit(context.Background(), repo.Dir, hash, promptPrivacy{true, true, true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := repo.git("show", "--patch", hash) + "\n"; full != want {
+		t.Fatalf("patch with everything sent:\n%q\nwant git show --patch:\n%q", full, want)
+	}
+	authorLine := "Author: Ada Lovelace <ada@example.com>\n"
+	dateLine := "Date:   Mon Jan 1 13:00:00 2024 +0000\n"
+	messageBlock := "\n    Add the codename vault\n    \n    Project NIGHTJAR keys live here.\n"
+	for _, part := range []string{authorLine, dateLine, messageBlock} {
+		if strings.Count(full, part) != 1 {
+			t.Fatalf("full patch does not contain %q once:\n%s", part, full)
+		}
+	}
+
+	// A withheld field is exactly its lines missing; nothing else changes.
+	for _, privacy := range privacyCombinations() {
+		want := full
+		if !privacy.SendAuthor {
+			want = strings.Replace(want, authorLine, "", 1)
+		}
+		if !privacy.SendDates {
+			want = strings.Replace(want, dateLine, "", 1)
+		}
+		if !privacy.SendMessage {
+			want = strings.Replace(want, messageBlock, "", 1)
+		}
+		got, err := getPatchForCommit(context.Background(), repo.Dir, hash, privacy, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%s:\n%q\nwant:\n%q", privacy, got, want)
+		}
+	}
+}
+
+func TestPromptMetadataWithhold(t *testing.T) {
+	header := "commit 1111111111111111111111111111111111111111\nMerge: aaaaaaa bbbbbbb\nAuthor: Ada Lovelace <ada@example.com>\nDate:   Mon Jan 1 12:00:00 2024 +0000\n\n    Merge the vault\n"
+	m := parsePromptMetadata(header)
+	if m.Author != "Ada Lovelace <ada@example.com>" || m.Date != "Mon Jan 1 12:00:00 2024 +0000" || m.Message != "    Merge the vault\n" || len(m.Lines) != 2 {
+		t.Fatalf("parsePromptMetadata = %+v", m)
+	}
+	if got := m.render(); got != header {
+		t.Errorf("render = %q, want the input", got)
+	}
+	// Withheld fields reach templates as empty strings; the commit and Merge lines stay.
+	withheld := m.withhold(promptPrivacy{})
+	if withheld.Author != "" || withheld.Date != "" || withheld.Message != "" {
+		t.Errorf("withheld fields are not empty: %+v", withheld)
+	}
+	if got, want := withheld.render(), "commit 1111111111111111111111111111111111111111\nMerge: aaaaaaa bbbbbbb\n"; got != want {
+		t.Errorf("render with everything withheld = %q, want %q", got, want)
+	}
+	if got := (promptPrivacy{SendDates: true}).filter(false, "x"); got != "" {
+		t.Errorf("filter of a withheld value = %q", got)
+	}
+}
+
+func TestStdinPatchPromptText(t *testing.T) {
+	from := "From 1234567890abcdef1234567890abcdef12345678 Mon Sep 17 00:00:00 2001\n"
+	author := "From: Ada Lovelace <ada@example.com>\n"
+	date := "Date: Mon, 1 Jan 2024 12:00:00 +0000\n"
+	subject := "Subject: [PATCH] Add the codename vault, with a subject\n folded onto a second line\n"
+	body := "Project NIGHTJAR keys live here.\n"
+	rest := "---\n vault.go | 1 +\n 1 file changed, 1 insertion(+)\n\ndiff --git a/vault.go b/vault.go\n+package vault\n"
+	patch := stdinPatch{Hash: "1234567890abcdef1234567890abcdef12345678", Text: from + author + date + subject + "\n" + body + rest}
+
+	for _, privacy := range privacyCombinations() {
+		want := from
+		if privacy.SendAuthor {
+			want += author
+		}
+		if privacy.SendDates {
+			want += date
+		}
+		if privacy.SendMessage {
+			want += subject
+		}
+		want += "\n"
+		if privacy.SendMessage {
+			want += body
+		}
+		want += rest
+		if got := patch.promptText(privacy); got != want {
+			t.Errorf("%s:\n%q\nwant:\n%q", privacy, got, want)
+		}
+	}
+
+	// A plain diff has no metadata to withhold.
+	plain := stdinPatch{Text: "diff --git a/x b/x\nFrom: not a header\n"}
+	if got := plain.promptText(promptPrivacy{}); got != plain.Text {
+		t.Errorf("plain diff changed: %q", got)
+	}
+}
+
+func TestPrivacyRun(t *testing.T) {
+	repo := newFixtureRepo(t)
+	repo.commits(1)
+	hash := withheldCommit(repo)
+	env := newAuditEnv(t)
+	env.Config["send_author"] = false
+	env.Config["send_dates"] = false
+	env.Config["send_original_message"] = false
+
+	report := filepath.Join(env.Work, "report.txt")
+	out := env.mustRun("-repo", repo.Dir, "-commit", hash, "-output", report)
+	prompts := env.Ollama.Prompts()
+	if len(prompts) != 1 {
+		t.Fatalf("%d prompts, want 1", len(prompts))
+	}
+	for _, withheld := range []string{"Ada", "ada@example.com", "Lovelace", "2024", "codename", "NIGHTJAR"} {
+		if strings.Contains(prompts[0], withheld) {
+			t.Errorf("prompt contains the withheld %q:\n%s", withheld, prompts[0])
+		}
+	}
+	if !strings.Contains(prompts[0], "commit "+hash+"\n") || !strings.Contains(prompts[0], "+package vault") {
+		t.Errorf("prompt lacks the commit line or the diff:\n%s", prompts[0])
+	}
+
+	// The run header records the settings; the entry keeps the full metadata.
+	if !strings.Contains(out, "Prompt Metadata: original message withheld, author withheld, dates withheld\n") {
+		t.Errorf("console header:\n%s", out)
+	}
+	text := readFile(t, report)
+	if !strings.Contains(text, "Prompt metadata: original message withheld, author withheld, dates withheld\n") {
+		t.Errorf("report header lacks the privacy settings:\n%s", text)
+	}
+	for _, kept := range []string{"Author: Ada Lovelace\n", "Date: 2024-01-01 13:00:00 +0000\n"} {
+		if !strings.Contains(text, kept) {
+			t.Errorf("report entry lacks %q:\n%s", kept, text)
+		}
+	}
+
+	// By default everything is sent, as git show has it.
+	delete(env.Config, "send_author")
+	delete(env.Config, "send_dates")
+	delete(env.Config, "send_original_message")
+	env.mustRun("-repo", repo.Dir, "-commit", hash, "-output", report, "-force")
+	prompts = env.Ollama.Prompts()
+	if full := repo.git("show", "--patch", hash); !strings.Contains(prompts[len(prompts)-1], full) {
+		t.Errorf("default prompt does not carry git show --patch:\n%s", prompts[len(prompts)-1])
+	}
+}
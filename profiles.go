@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// profileExtendsKey names the profile a profile builds on.
+const profileExtendsKey = "extends"
+
+// profileSettings is one entry of the "profiles" config key: config keys and flag names
+// (without the dash) mapped to their values, plus an optional "extends".
+type profileSettings map[string]json.RawMessage
+
+// resolvedProfile is a profile merged with the profiles it extends.
+type resolvedProfile struct {
+	Name string
+	// Chain is the profile followed by the profiles it extends, nearest first.
+	Chain []string
+	// Settings holds every key set along the chain; Origin names the profile each came from.
+	Settings map[string]json.RawMessage
+	Origin   map[string]string
+	// Flags are the flags this command took from the profile rather than the command line.
+	Flags map[string]bool
+}
+
+// activeProfile is the profile selected with -profile, or nil. applyProfile sets it;
+// loadConfig applies its config keys and the settings dump reports it as a source.
+var activeProfile *resolvedProfile
+
+// resolveProfile follows the extends chain of the named profile and merges its settings, so
+// that a profile overrides the ones it extends.
+func resolveProfile(profiles map[string]profileSettings, name string) (*resolvedProfile, error) {
+	var chain []string
+	seen := make(map[string]bool)
+	for current := name; current != ""; {
+		if seen[current] {
+			return nil, fmt.Errorf("profile %q has an extends cycle: %s", name, strings.Join(append(chain, current), " → "))
+		}
+		settings, ok := profiles[current]
+		if !ok {
+			if current == name {
+				return nil, fmt.Errorf("unknown profile %q (defined profiles: %s)", name, strings.Join(profileNames(profiles), ", "))
+			}
+			return nil, fmt.Errorf("profile %q extends unknown profile %q", chain[len(chain)-1], current)
+		}
+		seen[current] = true
+		chain = append(chain, current)
+
+		next := ""
+		if raw, ok := settings[profileExtendsKey]; ok {
+			if err := json.Unmarshal(raw, &next); err != nil || next == "" {
+				return nil, fmt.Errorf("profile %q: extends must be the name of another profile", current)
+			}
+		}
+		current = next
+	}
+
+	resolved := &resolvedProfile{
+		Name:     name,
+		Chain:    chain,
+		Settings: make(map[string]json.RawMessage),
+		Origin:   make(map[string]string),
+		Flags:    make(map[string]bool),
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		for key, value := range profiles[chain[i]] {
+			if key == profileExtendsKey {
+				continue
+			}
+			resolved.Settings[key] = value
+			resolved.Origin[key] = chain[i]
+		}
+	}
+	return resolved, nil
+}
+
+// validateProfiles checks that every profile resolves and sets no key a profile cannot.
+func validateProfiles(profiles map[string]profileSettings) error {
+	for _, name := range profileNames(profiles) {
+		for key := range profiles[name] {
+			switch key {
+			case "profiles":
+				return fmt.Errorf("profile %q cannot define profiles", name)
+			case "profile":
+				return fmt.Errorf("profile %q cannot set -profile; use %q to build on another profile", name, profileExtendsKey)
+			}
+		}
+		if _, err := resolveProfile(profiles, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// profileNames lists the defined profiles alphabetically.
+func profileNames(profiles map[string]profileSettings) []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// configFileKeys lists the JSON keys of the config file.
+func configFileKeys() []string {
+	var keys []string
+	configType := reflect.TypeOf(Config{})
+	for i := 0; i < configType.NumField(); i++ {
+		key, _, _ := strings.Cut(configType.Field(i).Tag.Get("json"), ",")
+		if key != "" && key != "-" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// readProfiles reads the "profiles" key of the config file, without validating the rest of
+// the file; loadConfig does that once the profile's flags are applied.
+func readProfiles() (map[string]profileSettings, error) {
+	configPath, err := configFilePath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %s: %w", configPath, err)
+	}
+	var file struct {
+		Profiles map[string]profileSettings `json:"profiles"`
+	}
+	if err := decodeConfigJSON(data, &file, true); err != nil {
+		return nil, fmt.Errorf("failed to decode config file %s: %w", configPath, err)
+	}
+	return file.Profiles, nil
+}
+
+// applyProfile implements -profile: it sets every flag of fs that the profile names and the
+// command line does not, and makes loadConfig apply the profile's config keys. Keys that are
+// neither config keys nor flags of this command are reported and ignored, since a profile may
+// set flags of another command (e.g. -commit, which explain does not have).
+func applyProfile(fs *flag.FlagSet, name string) error {
+	if name == "" {
+		return nil
+	}
+	profiles, err := readProfiles()
+	if err != nil {
+		return err
+	}
+	if err := validateProfiles(profiles); err != nil {
+		return err
+	}
+	profile, err := resolveProfile(profiles, name)
+	if err != nil {
+		return err
+	}
+
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	isConfigKey := make(map[string]bool)
+	for _, key := range configFileKeys() {
+		isConfigKey[key] = true
+	}
+	keys := make([]string, 0, len(profile.Settings))
+	for key := range profile.Settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		switch {
+		case isConfigKey[key]:
+			// Applied by loadConfig.
+		case fs.Lookup(key) != nil:
+			if given[key] {
+				continue // Explicit flags override the profile.
+			}
+			value := compactJSON(profile.Settings[key])
+			if err := fs.Set(key, value); err != nil {
+				return fmt.Errorf("profile %q sets -%s to %s: %w", profile.Origin[key], key, value, err)
+			}
+			profile.Flags[key] = true
+		default:
+			fmt.Printf("Warning: profile %q sets %q, which is neither a config key nor a flag of this command; ignoring it.\n", profile.Origin[key], key)
+		}
+	}
+	activeProfile = profile
+	return nil
+}
+
+// configOverlay returns the config keys the active profile sets, as a JSON object to decode
+// over the config file, or nil without a profile.
+func (p *resolvedProfile) configOverlay() ([]byte, error) {
+	if p == nil {
+		return nil, nil
+	}
+	overlay := make(map[string]json.RawMessage)
+	for _, key := range configFileKeys() {
+		if value, ok := p.Settings[key]; ok {
+			overlay[key] = value
+		}
+	}
+	if len(overlay) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(overlay)
+}
+
+// String describes the profile and its chain for the run header, e.g.
+// "weekly-security (extends base)".
+func (p *resolvedProfile) String() string {
+	if len(p.Chain) <= 1 {
+		return p.Name
+	}
+	return fmt.Sprintf("%s (extends %s)", p.Name, strings.Join(p.Chain[1:], " → "))
+}
+
+// runProfiles implements `gitaudit profiles list` and `gitaudit profiles show <name>`.
+func runProfiles(args []string) {
+	usage := func() { printSynopsis(os.Stdout, "profiles list", "profiles show") }
+	if len(args) == 0 || (args[0] == "show" && len(args) != 2) || (args[0] == "list" && len(args) != 1) {
+		usage()
+		os.Exit(1)
+	}
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Printf("Error loading configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "list":
+		if len(config.Profiles) == 0 {
+			fmt.Println("No profiles are defined; add a \"profiles\" object to the config file.")
+			return
+		}
+		for _, name := range profileNames(config.Profiles) {
+			profile, _ := resolveProfile(config.Profiles, name) // Validated by loadConfig.
+			fmt.Printf("%s\n    %s\n", profile, profileSummary(config.Profiles[name]))
+		}
+	case "show":
+		profile, err := resolveProfile(config.Profiles, args[1])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Profile %s\n", profile)
+		keys := make([]string, 0, len(profile.Settings))
+		for key := range profile.Settings {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			source := ""
+			if origin := profile.Origin[key]; origin != profile.Name {
+				source = fmt.Sprintf("(from %s)", origin)
+			}
+			line := fmt.Sprintf("  %-30s %-40s %s", key, redactURLs(compactJSON(profile.Settings[key])), source)
+			fmt.Println(strings.TrimRight(line, " "))
+		}
+	default:
+		usage()
+		os.Exit(1)
+	}
+}
+
+// profileSummary lists a profile's own settings on one line for `profiles list`, with long
+// values shortened.
+func profileSummary(settings profileSettings) string {
+	var parts []string
+	for key, value := range settings {
+		if key == profileExtendsKey {
+			continue
+		}
+		parts = append(parts, key+"="+truncateLine(redactURLs(compactJSON(value))))
+	}
+	if len(parts) == 0 {
+		return "(no settings of its own)"
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ", ")
+}
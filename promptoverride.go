@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// promptOverride is an entry of prompt_overrides: commits whose changed files mostly fall
+// under Paths are summarized with its Instructions added to the prompt, e.g. to stress
+// operational risk for infra/ or release impact for mobile/.
+type promptOverride struct {
+	Name         string   `json:"name"`
+	Paths        []string `json:"paths"`
+	Instructions []string `json:"instructions"`
+}
+
+// validatePromptOverrides checks that every override has a unique name, paths and
+// instructions.
+func validatePromptOverrides(overrides []promptOverride) error {
+	seen := make(map[string]bool)
+	for i, o := range overrides {
+		switch {
+		case strings.TrimSpace(o.Name) == "":
+			return fmt.Errorf("prompt_overrides entry %d has no name", i+1)
+		case seen[o.Name]:
+			return fmt.Errorf("prompt_overrides name %q is defined twice", o.Name)
+		case len(o.Paths) == 0:
+			return fmt.Errorf("prompt_overrides entry %q has no paths", o.Name)
+		case len(o.Instructions) == 0:
+			return fmt.Errorf("prompt_overrides entry %q has no instructions", o.Name)
+		}
+		seen[o.Name] = true
+	}
+	return nil
+}
+
+// promptArea counts the changed files of a commit that fall under an override's paths.
+type promptArea struct {
+	Override *promptOverride
+	Files    int
+}
+
+// selectPromptOverride returns the override whose paths match the most files, provided
+// that is more than half of them; overlapping overrides matching as many files are decided
+// by config order. Without a majority it returns nil, and areas lists the overrides matching
+// any file, in config order, so that the prompt can name the areas a commit spans.
+func selectPromptOverride(overrides []promptOverride, files []string) (selected *promptOverride, areas []promptArea) {
+	best := 0
+	for i := range overrides {
+		matched := 0
+		for _, file := range files {
+			if _, ok := matchAnyGlob(overrides[i].Paths, file); ok {
+				matched++
+			}
+		}
+		if matched > 0 {
+			areas = append(areas, promptArea{Override: &overrides[i], Files: matched})
+		}
+		if matched > best {
+			selected, best = &overrides[i], matched
+		}
+	}
+	if 2*best > len(files) {
+		return selected, nil
+	}
+	return nil, areas
+}
+
+// applyPromptOverride adds the instructions of the override selected for files to extras and
+// records its name on auditData. A commit that spans several areas without a majority keeps
+// the default prompt, with a hint naming the areas.
+func applyPromptOverride(overrides []promptOverride, files []string, extras promptExtras, auditData *CommitAuditData) promptExtras {
+	selected, areas := selectPromptOverride(overrides, files)
+	if selected != nil {
+		auditData.PromptProfile = selected.Name
+		extras.Instructions = append(extras.Instructions, selected.Instructions...)
+		return extras
+	}
+	if len(areas) > 1 {
+		names := make([]string, len(areas))
+		for i, area := range areas {
+			names[i] = fmt.Sprintf("%s (%d of %d files)", area.Override.Name, area.Files, len(files))
+		}
+		extras.Hints = append(extras.Hints, "This commit spans several areas of the repository: "+strings.Join(names, ", ")+".")
+	}
+	return extras
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testOverrides are an infra and a mobile persona, and a narrower one overlapping infra.
+var testOverrides = []promptOverride{
+	{Name: "infra", Paths: []string{"infra/*", "deploy/*"}, Instructions: []string{"Stress the operational risk of the change."}},
+	{Name: "mobile", Paths: []string{"mobile/*"}, Instructions: []string{"Frame the change for the app release notes."}},
+	{Name: "terraform", Paths: []string{"infra/*.tf"}, Instructions: []string{"Name the cloud resources the change affects."}},
+}
+
+func TestValidatePromptOverrides(t *testing.T) {
+	if err := validatePromptOverrides(testOverrides); err != nil {
+		t.Errorf("valid overrides: %v", err)
+	}
+	for want, overrides := range map[string][]promptOverride{
+		"prompt_overrides entry 2 has no name":             {testOverrides[0], {Paths: []string{"a/*"}, Instructions: []string{"x"}}},
+		`prompt_overrides name "infra" is defined twice`:   {testOverrides[0], testOverrides[0]},
+		`prompt_overrides entry "web" has no paths`:        {{Name: "web", Instructions: []string{"x"}}},
+		`prompt_overrides entry "web" has no instructions`: {{Name: "web", Paths: []string{"web/*"}}},
+	} {
+		if err := validatePromptOverrides(overrides); err == nil || err.Error() != want {
+			t.Errorf("validatePromptOverrides = %v, want %q", err, want)
+		}
+	}
+}
+
+func TestSelectPromptOverride(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    []string
+		selected string
+		areas    []string
+	}{
+		{"all infra", []string{"infra/main.go", "deploy/job.yaml"}, "infra", nil},
+		{"majority mobile", []string{"mobile/app.swift", "mobile/view.swift", "infra/main.go"}, "mobile", nil},
+		// infra and terraform both match both files; infra comes first in the config.
+		{"tie by config order", []string{"infra/net.tf", "infra/dns.tf"}, "infra", nil},
+		// terraform matches one of three files, infra all of them.
+		{"overlap", []string{"infra/net.tf", "infra/main.go", "deploy/job.yaml"}, "infra", nil},
+		// Exactly half is not a majority: the default prompt, with the areas named.
+		{"split evenly", []string{"infra/main.go", "mobile/app.swift"}, "", []string{"infra 1", "mobile 1"}},
+		{"no majority", []string{"infra/main.go", "mobile/app.swift", "README.md"}, "", []string{"infra 1", "mobile 1"}},
+		{"unmatched", []string{"README.md", "docs/guide.md"}, "", nil},
+		{"one area without majority", []string{"infra/main.go", "README.md", "docs/guide.md"}, "", []string{"infra 1"}},
+		{"no files", nil, "", nil},
+	}
+	for _, tt := range tests {
+		selected, areas := selectPromptOverride(testOverrides, tt.files)
+		name := ""
+		if selected != nil {
+			name = selected.Name
+		}
+		var got []string
+		for _, area := range areas {
+			got = append(got, area.Override.Name+" "+string(rune('0'+area.Files)))
+		}
+		if name != tt.selected || !reflect.DeepEqual(got, tt.areas) {
+			t.Errorf("%s: selected %q with areas %q, want %q with %q", tt.name, name, got, tt.selected, tt.areas)
+		}
+	}
+}
+
+func TestApplyPromptOverride(t *testing.T) {
+	base := promptExtras{Instructions: []string{"Mention the ticket."}}
+	var data CommitAuditData
+	extras := applyPromptOverride(testOverrides, []string{"mobile/app.swift"}, base, &data)
+	if data.PromptProfile != "mobile" || !reflect.DeepEqual(extras.Instructions, []string{"Mention the ticket.", "Frame the change for the app release notes."}) || len(extras.Hints) != 0 {
+		t.Errorf("mobile commit: profile %q, extras %+v", data.PromptProfile, extras)
+	}
+
+	data = CommitAuditData{}
+	extras = applyPromptOverride(testOverrides, []string{"infra/main.go", "mobile/app.swift", "README.md", "web/index.html"}, base, &data)
+	want := "This commit spans several areas of the repository: infra (1 of 4 files), mobile (1 of 4 files)."
+	if data.PromptProfile != "" || len(extras.Instructions) != 1 || !reflect.DeepEqual(extras.Hints, []string{want}) {
+		t.Errorf("spanning commit: profile %q, extras %+v", data.PromptProfile, extras)
+	}
+
+	// One area without a majority needs no hint.
+	extras = applyPromptOverride(testOverrides, []string{"infra/main.go", "README.md", "docs/guide.md"}, base, &data)
+	if len(extras.Hints) != 0 || data.PromptProfile != "" {
+		t.Errorf("minor infra change: %+v", extras)
+	}
+}
+
+func TestPromptOverrideRun(t *testing.T) {
+	repo := newFixtureRepo(t)
+	infra := repo.commit("Raise the replica count", map[string]string{"infra/main.tf": "replicas = 3\n", "deploy/job.yaml": "replicas: 3\n"})
+	mobile := repo.commit("Add the login view", map[string]string{"mobile/login.swift": "struct Login {}\n"})
+	both := repo.commit("Ship the login", map[string]string{"infra/login.tf": "login = true\n", "mobile/flag.swift": "let login = true\n"})
+	env := newAuditEnv(t)
+	env.Config["prompt_overrides"] = testOverrides[:2]
+
+	report := filepath.Join(env.Work, "report.json")
+	env.mustRun("-repo", repo.Dir, "-commit", "root", "-format", "json", "-output", report)
+	prompts := make(map[string]string)
+	for _, prompt := range env.Ollama.Prompts() {
+		for hash, subject := range map[string]string{infra: "Raise the replica count", mobile: "Add the login view", both: "Ship the login"} {
+			if strings.Contains(prompt, subject+"\n") {
+				prompts[hash] = prompt
+			}
+		}
+	}
+	if p := prompts[infra]; !strings.Contains(p, "Stress the operational risk") || strings.Contains(p, "app release notes") {
+		t.Errorf("infra prompt:\n%s", p)
+	}
+	if p := prompts[mobile]; !strings.Contains(p, "Frame the change for the app release notes.") || strings.Contains(p, "operational risk") {
+		t.Errorf("mobile prompt:\n%s", p)
+	}
+	if p := prompts[both]; strings.Contains(p, "operational risk") || strings.Contains(p, "app release notes") ||
+		!strings.Contains(p, "This commit spans several areas of the repository: infra (1 of 2 files), mobile (1 of 2 files).") {
+		t.Errorf("spanning prompt:\n%s", p)
+	}
+
+	var document jsonReport
+	if err := json.Unmarshal([]byte(readFile(t, report)), &document); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{infra: "infra", mobile: "mobile", both: ""}
+	for _, entry := range document.Commits {
+		if entry.PromptProfile != want[entry.Hash] {
+			t.Errorf("%s: prompt profile %q, want %q", shortHash(entry.Hash), entry.PromptProfile, want[entry.Hash])
+		}
+	}
+
+	// The text report names the persona.
+	text := filepath.Join(env.Work, "report.txt")
+	env.mustRun("-repo", repo.Dir, "-commit", "root", "-output", text)
+	if content := readFile(t, text); strings.Count(content, "Prompt profile: ") != 2 || !strings.Contains(content, "Prompt profile: infra\n") {
+		t.Errorf("text report:\n%s", content)
+	}
+
+	// Recorded answers are keyed by the prompt, persona included: rewording a persona makes
+	// the answers of its commits miss on replay.
+	recording := filepath.Join(env.Work, "recording")
+	env.mustRun("-repo", repo.Dir, "-commit", "root", "-output", text, "-force", "-record", recording)
+	env.mustRun("-repo", repo.Dir, "-commit", "root", "-output", text, "-force", "-replay", recording)
+	reworded := append([]promptOverride(nil), testOverrides[:2]...)
+	reworded[0].Instructions = []string{"Stress the blast radius of the change."}
+	env.Config["prompt_overrides"] = reworded
+	out, code := env.run("-repo", repo.Dir, "-commit", "root", "-output", text, "-force", "-replay", recording, "-max-retries", "1")
+	if code == 0 || !strings.Contains(out, "The following 1 commits were pending processing or retry:\n"+infra+" ") {
+		t.Errorf("replay with a reworded persona: exit %d\n%s", code, out)
+	}
+
+	env.Config["prompt_overrides"] = []promptOverride{{Name: "infra", Paths: []string{"infra/*"}}}
+	if out, code := env.run("-repo", repo.Dir, "-commit", "root", "-output", text, "-force"); code == 0 || !strings.Contains(out, `prompt_overrides entry "infra" has no instructions`) {
+		t.Errorf("invalid override: exit %d\n%s", code, out)
+	}
+}
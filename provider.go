@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Supported values of the "provider" config key.
+const (
+	providerOllama    = "ollama"
+	providerAnthropic = "anthropic"
+	providerGemini    = "gemini"
+)
+
+// generator produces a summary for a prompt using one LLM provider.
+type generator interface {
+	// Name identifies the provider in log and error messages, e.g. "Ollama".
+	Name() string
+	Generate(ctx context.Context, prompt string) (generation, error)
+}
+
+// seededGenerator is implemented by providers that take a sampling seed, so that one prompt
+// can be answered more than once independently (-verify-critical).
+type seededGenerator interface {
+	GenerateSeeded(ctx context.Context, prompt string, seed int) (generation, error)
+}
+
+// generateSeeded asks g for an answer sampled with seed. Providers without a seed parameter
+// sample afresh on every call, so they are simply called again.
+func generateSeeded(ctx context.Context, g generator, prompt string, seed int) (generation, error) {
+	if seeded, ok := g.(seededGenerator); ok {
+		return seeded.GenerateSeeded(ctx, prompt, seed)
+	}
+	return g.Generate(ctx, prompt)
+}
+
+// generation is a provider's answer to one prompt.
+type generation struct {
+	Text  string
+	Usage tokenUsage
+}
+
+// tokenUsage counts the tokens a provider reported for one or more calls. Providers that do
+// not report usage leave it zero.
+type tokenUsage struct {
+	PromptTokens int `json:"prompt_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// add returns the sum of two usages.
+func (u tokenUsage) add(other tokenUsage) tokenUsage {
+	return tokenUsage{PromptTokens: u.PromptTokens + other.PromptTokens, OutputTokens: u.OutputTokens + other.OutputTokens}
+}
+
+// permanentError marks a provider failure that retrying cannot fix, such as an invalid API key
+// or an unknown model. All other errors are considered retryable.
+type permanentError struct {
+	err error
+	// Status and Body are the HTTP status code and response body of a provider's refusal; zero
+	// for failures detected before or without a response, such as a missing recording.
+	Status int
+	Body   string
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// isPermanent reports whether err, or any error it wraps, is a permanent provider failure.
+func isPermanent(err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent)
+}
+
+// providerConfig is the config block of a hosted provider ("anthropic" or "gemini").
+type providerConfig struct {
+	// APIKeyEnv names the environment variable holding the API key; the key itself is never
+	// stored in the config file.
+	APIKeyEnv string `json:"api_key_env"`
+	Model     string `json:"model"`
+	MaxTokens int    `json:"max_tokens"`
+	// Endpoint overrides the provider's default API base URL, e.g. for a proxy.
+	Endpoint string `json:"endpoint"`
+}
+
+// defaultMaxTokens bounds the length of generated summaries when max_tokens is not configured.
+const defaultMaxTokens = 1024
+
+// apiKey reads the provider's API key from the configured environment variable.
+func (c *providerConfig) apiKey(provider string) (string, error) {
+	if c.APIKeyEnv == "" {
+		return "", fmt.Errorf("%s.api_key_env must name the environment variable holding the API key", provider)
+	}
+	key := os.Getenv(c.APIKeyEnv)
+	if key == "" {
+		return "", fmt.Errorf("environment variable %s (from %s.api_key_env) is not set", c.APIKeyEnv, provider)
+	}
+	return key, nil
+}
+
+// hostedProviderTimeout bounds a single request to a hosted provider. It is longer than the
+// Ollama timeout because hosted models are used for the largest diffs.
+const hostedProviderTimeout = 120 * time.Second
+
+// postJSON sends payload as JSON to url with the given extra headers and returns the status
+// code and body of the response. Cancelling ctx fails the request.
+func postJSON(ctx context.Context, url string, headers map[string]string, payload any) (int, []byte, error) {
+	reqBody, err := json.Marshal(payload)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	httpResp, err := postBody(ctx, hostedClient, url, headers, reqBody)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer httpResp.Body.Close()
+	body, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return httpResp.StatusCode, nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	return httpResp.StatusCode, body, nil
+}
+
+// meteredGenerator wraps the configured provider and totals the token usage of every call
+// for the end-of-run report.
+type meteredGenerator struct {
+	generator
+	// Price is the hosted model's configured price; nil for Ollama and unpriced models,
+	// which cost nothing.
+	Price *modelPrice
+	// Previous is the generator this one took over from (-retry-model), whose calls count
+	// toward the run's totals at their own price.
+	Previous *meteredGenerator
+
+	mu    sync.Mutex
+	calls int
+	usage tokenUsage
+}
+
+// newGenerator builds the generator selected by the "provider" config key.
+func newGenerator(config *Config) (*meteredGenerator, error) {
+	var g generator
+	var price *modelPrice
+	switch config.Provider {
+	case "", providerOllama:
+		// Local models are free; their tokens are still counted.
+		g = newOllamaGenerator(config.OllamaEndpoint, config.OllamaModel)
+	case providerAnthropic:
+		key, err := config.Anthropic.apiKey(providerAnthropic)
+		if err != nil {
+			return nil, err
+		}
+		g = newAnthropicGenerator(config.Anthropic, key)
+		price = config.Pricing.lookup(config.Anthropic.Model)
+	case providerGemini:
+		key, err := config.Gemini.apiKey(providerGemini)
+		if err != nil {
+			return nil, err
+		}
+		g = newGeminiGenerator(config.Gemini, key)
+		price = config.Pricing.lookup(config.Gemini.Model)
+	default:
+		return nil, fmt.Errorf("unknown provider %q: expected %q, %q or %q", config.Provider, providerOllama, providerAnthropic, providerGemini)
+	}
+	return &meteredGenerator{generator: g, Price: price}, nil
+}
+
+// configuredModel returns the model name of the configured provider.
+func configuredModel(config *Config) string {
+	switch config.Provider {
+	case providerAnthropic:
+		return config.Anthropic.Model
+	case providerGemini:
+		return config.Gemini.Model
+	default:
+		return config.OllamaModel
+	}
+}
+
+// Generate calls the wrapped provider and records the reported token usage. An empty
+// generation is always returned as errEmptyResponse, so no caller can store an empty summary.
+func (m *meteredGenerator) Generate(ctx context.Context, prompt string) (generation, error) {
+	result, err := m.generator.Generate(ctx, prompt)
+	return m.meter(result, err)
+}
+
+// GenerateSeeded is Generate with a sampling seed (see generateSeeded).
+func (m *meteredGenerator) GenerateSeeded(ctx context.Context, prompt string, seed int) (generation, error) {
+	result, err := generateSeeded(ctx, m.generator, prompt, seed)
+	return m.meter(result, err)
+}
+
+// meter records the usage of one call. A request the circuit breaker did not send is not one.
+func (m *meteredGenerator) meter(result generation, err error) (generation, error) {
+	m.mu.Lock()
+	if circuitOpenEndpoint(err) == "" {
+		m.calls++
+	}
+	m.usage = m.usage.add(result.Usage)
+	m.mu.Unlock()
+	if err == nil && strings.TrimSpace(result.Text) == "" {
+		err = errEmptyResponse
+	}
+	return result, err
+}
+
+// Usage returns the number of calls made and the total token usage so far, those of the
+// generator it took over from included.
+func (m *meteredGenerator) Usage() (int, tokenUsage) {
+	m.mu.Lock()
+	calls, usage := m.calls, m.usage
+	m.mu.Unlock()
+	if m.Previous != nil {
+		previousCalls, previousUsage := m.Previous.Usage()
+		calls, usage = calls+previousCalls, usage.add(previousUsage)
+	}
+	return calls, usage
+}
+
+// Priced reports whether any call of the run so far has a price.
+func (m *meteredGenerator) Priced() bool {
+	return m.Price != nil || (m.Previous != nil && m.Previous.Priced())
+}
+
+// Cost returns the cost of the given usage in US dollars.
+func (m *meteredGenerator) Cost(usage tokenUsage) float64 {
+	return m.Price.cost(usage)
+}
+
+// Spent returns the cost of every call made so far in US dollars, each at the price of the
+// model that answered it.
+func (m *meteredGenerator) Spent() float64 {
+	m.mu.Lock()
+	spent := m.Cost(m.usage)
+	m.mu.Unlock()
+	if m.Previous != nil {
+		spent += m.Previous.Spent()
+	}
+	return spent
+}
+
+// ollamaGenerator calls a local Ollama instance's /api/generate endpoint.
+type ollamaGenerator struct {
+	Endpoint string
+	Model    string
+
+	mu        sync.Mutex
+	diagnosed bool // A failure was checked for a misconfigured endpoint; done once per run.
+}
+
+// newOllamaGenerator returns the generator for endpoint, correcting the misconfigurations
+// that are evident from the URL with a warning.
+func newOllamaGenerator(endpoint, model string) *ollamaGenerator {
+	fixed, suggestion := normalizeOllamaEndpoint(endpoint)
+	if suggestion != "" {
+		fmt.Printf("Warning: %s\n", suggestion)
+	}
+	return &ollamaGenerator{Endpoint: fixed, Model: model}
+}
+
+func (g *ollamaGenerator) Name() string { return "Ollama" }
+
+// Generate calls Ollama. The first failure of the run is checked for a misconfigured
+// endpoint; when the endpoint turns out to be the server's base URL, the call is repeated
+// against its /api/generate, which is used for the rest of the run.
+func (g *ollamaGenerator) Generate(ctx context.Context, prompt string) (generation, error) {
+	return g.generate(ctx, prompt, nil)
+}
+
+// GenerateSeeded calls Ollama with the seed option.
+func (g *ollamaGenerator) GenerateSeeded(ctx context.Context, prompt string, seed int) (generation, error) {
+	return g.generate(ctx, prompt, map[string]any{"seed": seed})
+}
+
+func (g *ollamaGenerator) generate(ctx context.Context, prompt string, options map[string]any) (generation, error) {
+	g.mu.Lock()
+	endpoint := g.Endpoint
+	g.mu.Unlock()
+	result, err := callOllama(ctx, endpoint, g.Model, prompt, options)
+	if err == nil {
+		return result, nil
+	}
+
+	g.mu.Lock()
+	if g.diagnosed || g.Endpoint != endpoint {
+		// Already checked, or adapted by a concurrent call.
+		retry := g.Endpoint != endpoint
+		endpoint = g.Endpoint
+		g.mu.Unlock()
+		if retry {
+			return callOllama(ctx, endpoint, g.Model, prompt, options)
+		}
+		return result, err
+	}
+	g.diagnosed = true
+	fixed, err := diagnoseOllamaFailure(endpoint, err)
+	if fixed == "" {
+		g.mu.Unlock()
+		return result, err
+	}
+	g.Endpoint = fixed
+	g.mu.Unlock()
+	fmt.Printf("Warning: ollama_endpoint %s is not Ollama's generate API, but the server answers at %s; using it for the rest of the run. Set ollama_endpoint to it in the config file.\n", endpoint, fixed)
+	return callOllama(ctx, fixed, g.Model, prompt, options)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestClassifyOllamaError(t *testing.T) {
+	tests := []struct {
+		message   string
+		permanent bool
+	}{
+		{"model 'llama3.1:8b' not found", true},
+		{`model "llama3.1:8b" not found, try pulling it first`, true},
+		{"Model Not Found", true},
+		{"upstream connect error or disconnect/reset before headers", false},
+		{"llama runner process has terminated: signal: killed", false},
+		{"server busy, please try again. maximum pending requests exceeded", false},
+		{"not found", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		err := classifyOllamaError(errors.New("request failed"), http.StatusOK, tt.message)
+		if isPermanent(err) != tt.permanent {
+			t.Errorf("classifyOllamaError(%q): permanent = %v, want %v", tt.message, !tt.permanent, tt.permanent)
+		}
+		if err.Error() != "request failed" {
+			t.Errorf("classifyOllamaError(%q) changed the message to %q", tt.message, err)
+		}
+	}
+}
+
+func TestOllamaGenerateResponses(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		body      string
+		text      string // The generated text, when the call succeeds.
+		errText   string // A part of the error message, when it fails.
+		permanent bool
+		empty     bool // The error is errEmptyResponse.
+	}{
+		{"summary", 200, `{"model":"m","response":"  Adds the parser.\n","done":true,"prompt_eval_count":120,"eval_count":8}`, "Adds the parser.", "", false, false},
+		{"error in a 200, model not found", 200, `{"error":"model 'm' not found"}`, "", "model 'm' not found", true, false},
+		{"error in a 200, gateway", 200, `{"error":"upstream connect error or disconnect/reset before headers"}`, "", "upstream connect error", false, false},
+		{"HTML in a 200", 200, "<html><body><h1>Down for maintenance</h1></body></html>", "", "Down for maintenance", false, false},
+		{"empty generation", 200, `{"model":"m","response":"","done":true,"eval_count":0}`, "", "empty response", false, true},
+		{"whitespace generation", 200, `{"model":"m","response":" \n\t","done":true}`, "", "empty response", false, true},
+		{"404 model not found", 404, `{"error":"model \"m\" not found, try pulling it first"}`, "", "404", true, false},
+		{"500 model not found", 500, `{"error":"model 'm' not found"}`, "", "500", true, false},
+		{"500 runner crash", 500, `{"error":"llama runner process has terminated"}`, "", "500", false, false},
+		{"503 HTML", 503, "<html>Service Unavailable</html>", "", "503", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != ollamaGeneratePath {
+					http.NotFound(w, r)
+					return
+				}
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+			}))
+			defer server.Close()
+			g := &meteredGenerator{generator: newOllamaGenerator(server.URL+ollamaGeneratePath, "m")}
+			gen, err := g.Generate(context.Background(), "the prompt")
+			if tt.errText == "" {
+				if err != nil || gen.Text != tt.text {
+					t.Fatalf("Generate = %q, %v; want %q", gen.Text, err, tt.text)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Generate succeeded with %q", gen.Text)
+			}
+			if !strings.Contains(err.Error(), tt.errText) {
+				t.Errorf("error %q does not mention %q", err, tt.errText)
+			}
+			if isPermanent(err) != tt.permanent {
+				t.Errorf("isPermanent(%v) = %v", err, !tt.permanent)
+			}
+			if errors.Is(err, errEmptyResponse) != tt.empty {
+				t.Errorf("errors.Is(%v, errEmptyResponse) = %v", err, !tt.empty)
+			}
+		})
+	}
+}
+
+func TestOllamaErrorsNeverReachTheReport(t *testing.T) {
+	repo := newFixtureRepo(t)
+	hashes := repo.commits(3)
+	env := newAuditEnv(t)
+	// Each commit's first attempt gets a different bad 200 answer; the retries succeed.
+	bad := []string{
+		`{"error":"upstream connect error or disconnect/reset before headers"}`,
+		"<html><body>Down for maintenance</body></html>",
+		`{"model":"tiny:0.5b","response":"","done":true}`,
+	}
+	env.Ollama.Respond = func(n int, prompt string) (int, string) {
+		if n <= len(bad) {
+			return http.StatusOK, bad[n-1]
+		}
+		return 0, ""
+	}
+	report := filepath.Join(env.Work, "report.txt")
+	out := env.mustRun("-repo", repo.Dir, "-commit", "root", "-output", report)
+	if !strings.Contains(out, "Successfully wrote 3 audited commit entries") {
+		t.Errorf("run did not recover:\n%s", out)
+	}
+	text := readFile(t, report)
+	if n := strings.Count(text, "Summary "); n != len(hashes) {
+		t.Errorf("report has %d model summaries for %d commits:\n%s", n, len(hashes), text)
+	}
+	for _, marker := range []string{"Down for maintenance", "upstream connect", "Summary:\n\n"} {
+		if strings.Contains(text, marker) {
+			t.Errorf("report contains %q:\n%s", marker, text)
+		}
+	}
+
+	// A model that does not exist stops the run with a non-zero exit and writes no entry.
+	env.Ollama.Respond = func(n int, prompt string) (int, string) {
+		return http.StatusOK, `{"error":"model 'tiny:0.5b' not found"}`
+	}
+	report = filepath.Join(env.Work, "missing-model.txt")
+	out, code := env.run("-repo", repo.Dir, "-commit", "root", "-output", report)
+	if code == 0 || !strings.Contains(out, "not found") {
+		t.Errorf("exit %d, want a failure naming the missing model:\n%s", code, out)
+	}
+	if data, _ := os.ReadFile(report); strings.Contains(string(data), "Commit: ") {
+		t.Errorf("entries written although the model is missing:\n%s", data)
+	}
+}
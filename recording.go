@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Values of -replay-missing.
+const (
+	replayMissingError       = "error"
+	replayMissingPlaceholder = "placeholder"
+)
+
+// recording is one saved model request and response (-record), stored as
+// <dir>/<prompt digest>.json so that recordings can be inspected and committed as fixtures.
+type recording struct {
+	PromptDigest string     `json:"prompt_digest"`
+	Provider     string     `json:"provider"`
+	Model        string     `json:"model"`
+	Prompt       string     `json:"prompt"`
+	Response     string     `json:"response"`
+	Usage        tokenUsage `json:"usage"`
+	// Seed is the sampling seed of a -verify-critical second generation; 0 for plain calls.
+	Seed       int       `json:"seed,omitempty"`
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+// promptDigest keys recordings by the SHA-256 of the prompt alone, so recordings made with
+// one model can be replayed while another is configured.
+func promptDigest(prompt string) string {
+	sum := sha256.Sum256([]byte(prompt))
+	return hex.EncodeToString(sum[:])
+}
+
+// recordingDigest is the digest a recording is stored under. An answer sampled with a seed
+// is kept apart from the plain answer to the same prompt.
+func recordingDigest(prompt string, seed int) string {
+	if seed == 0 {
+		return promptDigest(prompt)
+	}
+	return promptDigest(fmt.Sprintf("%s\x00seed=%d", prompt, seed))
+}
+
+func recordingPath(dir, prompt string, seed int) string {
+	return filepath.Join(dir, recordingDigest(prompt, seed)+".json")
+}
+
+// recordingGenerator passes every prompt to the wrapped provider and saves each successful
+// response under Dir. A recording that cannot be written only produces a warning.
+type recordingGenerator struct {
+	generator
+	Dir   string
+	Model string
+}
+
+func (g *recordingGenerator) Generate(ctx context.Context, prompt string) (generation, error) {
+	result, err := g.generator.Generate(ctx, prompt)
+	if err != nil {
+		return result, err
+	}
+	g.record(prompt, 0, result)
+	return result, nil
+}
+
+func (g *recordingGenerator) GenerateSeeded(ctx context.Context, prompt string, seed int) (generation, error) {
+	result, err := generateSeeded(ctx, g.generator, prompt, seed)
+	if err != nil {
+		return result, err
+	}
+	g.record(prompt, seed, result)
+	return result, nil
+}
+
+func (g *recordingGenerator) record(prompt string, seed int, result generation) {
+	rec := recording{
+		PromptDigest: recordingDigest(prompt, seed),
+		Provider:     g.Name(),
+		Model:        g.Model,
+		Prompt:       prompt,
+		Response:     result.Text,
+		Usage:        result.Usage,
+		Seed:         seed,
+		RecordedAt:   time.Now().UTC(),
+	}
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err == nil {
+		err = os.WriteFile(recordingPath(g.Dir, prompt, seed), append(data, '\n'), 0o644)
+	}
+	if err != nil {
+		fmt.Printf("Warning: failed to record response: %v\n", err)
+	}
+}
+
+// replayGenerator answers prompts from the recordings in Dir without any network access.
+// A prompt without a recording is a permanent error, or gets a placeholder response when
+// Missing is "placeholder".
+type replayGenerator struct {
+	Dir     string
+	Missing string
+}
+
+func (g *replayGenerator) Name() string { return "replay" }
+
+func (g *replayGenerator) Generate(_ context.Context, prompt string) (generation, error) {
+	return g.replay(prompt, 0)
+}
+
+func (g *replayGenerator) GenerateSeeded(_ context.Context, prompt string, seed int) (generation, error) {
+	return g.replay(prompt, seed)
+}
+
+func (g *replayGenerator) replay(prompt string, seed int) (generation, error) {
+	path := recordingPath(g.Dir, prompt, seed)
+	digest := recordingDigest(prompt, seed)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		if g.Missing == replayMissingPlaceholder {
+			return generation{Text: fmt.Sprintf("[replay placeholder: no recording for prompt %s]", digest[:12])}, nil
+		}
+		return generation{}, &permanentError{err: fmt.Errorf("no recording for prompt %s in %s (pass -replay-missing placeholder to continue without one)", digest[:12], g.Dir)}
+	}
+	if err != nil {
+		return generation{}, &permanentError{err: fmt.Errorf("failed to read recording %s: %w", path, err)}
+	}
+	var rec recording
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return generation{}, &permanentError{err: fmt.Errorf("failed to decode recording %s: %w", path, err)}
+	}
+	return generation{Text: rec.Response, Usage: rec.Usage}, nil
+}
+
+// newGenerator builds the configured generator, applying -record or -replay. Replay never
+// touches the configured provider, so it needs neither a server nor an API key.
+func (p *promptFlags) newGenerator(config *Config) (*meteredGenerator, error) {
+	// -record with -replay is rejected by promptFlagRules.
+	if p.Replay != "" {
+		if p.ReplayMissing != replayMissingError && p.ReplayMissing != replayMissingPlaceholder {
+			return nil, fmt.Errorf("-replay-missing must be %q or %q, got %q", replayMissingError, replayMissingPlaceholder, p.ReplayMissing)
+		}
+		if info, err := os.Stat(p.Replay); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("-replay directory %s does not exist", p.Replay)
+		}
+		// Replayed calls cost nothing, whatever the configured model's price.
+		return &meteredGenerator{generator: &replayGenerator{Dir: p.Replay, Missing: p.ReplayMissing}}, nil
+	}
+
+	g, err := newGenerator(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	if p.Record != "" {
+		g.generator = &recordingGenerator{generator: g.generator, Dir: p.Record, Model: configuredModel(config)}
+	}
+	return g, nil
+}
+
+// prepareRecordDir creates the -record directory, which -no-repo-writes keeps outside the
+// repository.
+func (p *promptFlags) prepareRecordDir(repoRoot string, noRepoWrites bool) error {
+	if p.Record == "" {
+		return nil
+	}
+	if noRepoWrites {
+		if err := checkOutsideRepo(p.Record, repoRoot, "-record"); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(p.Record, 0o755); err != nil {
+		return fmt.Errorf("failed to create -record directory: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordingDigest(t *testing.T) {
+	if promptDigest("a prompt") != promptDigest("a prompt") || promptDigest("a prompt") == promptDigest("a prompt ") {
+		t.Error("digest does not follow the prompt exactly")
+	}
+	if recordingDigest("p", 0) != promptDigest("p") {
+		t.Error("a plain call is not stored under the prompt digest")
+	}
+	if recordingDigest("p", 1) == recordingDigest("p", 0) || recordingDigest("p", 1) == recordingDigest("p", 2) {
+		t.Error("seeded generations share a recording")
+	}
+}
+
+func TestReplayGenerator(t *testing.T) {
+	dir := t.TempDir()
+	rec := recording{PromptDigest: promptDigest("known"), Provider: "Ollama", Model: "m", Prompt: "known", Response: "Recorded summary.", Usage: tokenUsage{PromptTokens: 12, OutputTokens: 3}}
+	data, _ := json.Marshal(rec)
+	os.WriteFile(recordingPath(dir, "known", 0), data, 0o644)
+	os.WriteFile(recordingPath(dir, "broken", 0), []byte("{not json"), 0o644)
+
+	g := &replayGenerator{Dir: dir, Missing: replayMissingError}
+	if got, err := g.Generate(context.Background(), "known"); err != nil || got.Text != "Recorded summary." || got.Usage != rec.Usage {
+		t.Errorf("Generate(known) = %+v, %v", got, err)
+	}
+	for prompt, want := range map[string]string{"unknown": "no recording for prompt " + promptDigest("unknown")[:12], "broken": "failed to decode recording"} {
+		if _, err := g.Generate(context.Background(), prompt); err == nil || !isPermanent(err) || !strings.Contains(err.Error(), want) {
+			t.Errorf("Generate(%s) = %v, want a permanent error containing %q", prompt, err, want)
+		}
+	}
+	// The plain recording does not answer a seeded generation.
+	if _, err := g.GenerateSeeded(context.Background(), "known", 7); err == nil {
+		t.Error("seeded generation replayed the plain recording")
+	}
+
+	g.Missing = replayMissingPlaceholder
+	got, err := g.Generate(context.Background(), "unknown")
+	if err != nil || got.Text != "[replay placeholder: no recording for prompt "+promptDigest("unknown")[:12]+"]" {
+		t.Errorf("placeholder = %q, %v", got.Text, err)
+	}
+}
+
+// jsonSummaries reads the summary of each entry of a JSON report by commit.
+func jsonSummaries(t *testing.T, path string) map[string]string {
+	t.Helper()
+	var document jsonReport
+	if err := json.Unmarshal([]byte(readFile(t, path)), &document); err != nil {
+		t.Fatal(err)
+	}
+	summaries := make(map[string]string)
+	for _, entry := range document.Commits {
+		summaries[entry.Hash] = entry.Summary
+	}
+	return summaries
+}
+
+func TestRecordReplayRun(t *testing.T) {
+	repo := newFixtureRepo(t)
+	hashes := repo.commits(3)
+	env := newAuditEnv(t)
+	recordings := filepath.Join(env.Work, "recordings")
+
+	recorded := filepath.Join(env.Work, "recorded.json")
+	env.mustRun("-repo", repo.Dir, "-commit", "root", "-format", "json", "-output", recorded, "-record", recordings)
+	files, err := filepath.Glob(filepath.Join(recordings, "*.json"))
+	if err != nil || len(files) != 3 {
+		t.Fatalf("recordings %v, %v; want one per commit", files, err)
+	}
+	prompts := env.Ollama.Prompts()
+	for _, prompt := range prompts {
+		var rec recording
+		if err := json.Unmarshal([]byte(readFile(t, recordingPath(recordings, prompt, 0))), &rec); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Prompt != prompt || rec.Response != fakeSummary(prompt) || rec.Provider != "Ollama" || rec.Model != "tiny:0.5b" || rec.Usage.OutputTokens != 10 || rec.RecordedAt.IsZero() {
+			t.Errorf("recording %s = %+v", rec.PromptDigest, rec)
+		}
+	}
+
+	// Replay needs no model server.
+	env.Ollama.Close()
+	replayed := filepath.Join(env.Work, "replayed.json")
+	out := env.mustRun("-repo", repo.Dir, "-commit", "root", "-format", "json", "-output", replayed, "-replay", recordings)
+	want, got := jsonSummaries(t, recorded), jsonSummaries(t, replayed)
+	if len(got) != len(hashes) {
+		t.Fatalf("replayed report has %d entries:\n%s", len(got), out)
+	}
+	for hash, summary := range want {
+		if got[hash] != summary {
+			t.Errorf("commit %s replayed as %q, recorded as %q", hash, got[hash], summary)
+		}
+	}
+
+	// A prompt without a recording stops the run, unless a placeholder will do.
+	os.Remove(recordingPath(recordings, prompts[1], 0))
+	out, code := env.run("-repo", repo.Dir, "-commit", "root", "-format", "json", "-output", filepath.Join(env.Work, "missing.json"), "-replay", recordings)
+	if code == 0 || !strings.Contains(out, "no recording for prompt "+promptDigest(prompts[1])[:12]) {
+		t.Errorf("exit %d, want a failure naming the missing recording:\n%s", code, out)
+	}
+	placeholder := filepath.Join(env.Work, "placeholder.json")
+	env.mustRun("-repo", repo.Dir, "-commit", "root", "-format", "json", "-output", placeholder, "-replay", recordings, "-replay-missing", "placeholder")
+	got = jsonSummaries(t, placeholder)
+	placeholders := 0
+	for _, summary := range got {
+		if strings.HasPrefix(summary, "[replay placeholder: no recording for prompt ") {
+			placeholders++
+		}
+	}
+	if len(got) != 3 || placeholders != 1 {
+		t.Errorf("placeholder run has %d entries, %d placeholders; want 3 and 1", len(got), placeholders)
+	}
+
+	if out, code := env.run("-repo", repo.Dir, "-commit", "root", "-replay", recordings, "-record", recordings); code == 0 {
+		t.Errorf("-record with -replay accepted:\n%s", out)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+)
+
+// auditTarget describes how a hash in the processing queue differs from an ordinary commit
+// in the audited range. It is used by the stash and reflog recovery modes.
+type auditTarget struct {
+	// Ref is the human-readable label for the entry, e.g. "stash@{0}".
+	Ref string
+	// DiffBase, when set, is the revision the target is diffed against instead of using
+	// `git show`. Stash commits are merges, so they are diffed against their first parent.
+	DiffBase string
+	// Message replaces the commit message in the prompt (the stash message for stashes).
+	Message string
+	// Unreachable marks commits that are only reachable from a reflog, not from any branch.
+	Unreachable bool
+	// Patch, when set, is the patch itself, read from stdin by `gitaudit patch`; there is no
+	// repository to read it from.
+	Patch string
+	// Group, with -group-by change-id, lists the patch sets the commit stands for; their
+	// patches are concatenated.
+	Group *changeGroup
+}
+
+// getStashTargets lists the stash entries of a repository, newest first, and returns their
+// commit hashes along with the per-hash target description.
+func getStashTargets(repoPath string) ([]string, map[string]auditTarget, error) {
+	output, err := gitRun(context.Background(), repoPath, "stash", "list", "--format=%H%x00%gd%x00%gs")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to execute git stash list: %w", err)
+	}
+
+	var hashes []string
+	targets := make(map[string]auditTarget)
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if line == "" {
+			continue
+		}
+		parts := strings.SplitN(line, "\x00", 3)
+		if len(parts) < 3 {
+			return nil, nil, fmt.Errorf("unexpected format from git stash list: %q", line)
+		}
+		hashes = append(hashes, parts[0])
+		targets[parts[0]] = auditTarget{
+			Ref:      parts[1],
+			DiffBase: parts[0] + "^1",
+			Message:  parts[2],
+		}
+	}
+	return hashes, targets, nil
+}
+
+// getReflogTargets returns the commits reachable from the reflog of ref but not from any
+// branch, newest first. Commits whose patch-id matches an already listed commit (typically
+// the repeated results of a rebase) are dropped so the same change is only audited once.
+func getReflogTargets(repoPath, ref string) ([]string, map[string]auditTarget, error) {
+	output, err := gitRun(context.Background(), repoPath, "reflog", "show", "--format=%H", ref, "--")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read reflog for %s: %w", ref, err)
+	}
+
+	var reflogHashes []string
+	seen := make(map[string]bool)
+	for _, hash := range strings.Fields(string(output)) {
+		if !seen[hash] {
+			seen[hash] = true
+			reflogHashes = append(reflogHashes, hash)
+		}
+	}
+	if len(reflogHashes) == 0 {
+		return nil, nil, nil
+	}
+
+	// Everything reachable from the reflog entries, minus everything reachable from a branch.
+	args := append([]string{"rev-list"}, reflogHashes...)
+	args = append(args, "--not", "--branches")
+	output, err = gitRun(context.Background(), repoPath, args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list unreachable reflog commits for %s: %w", ref, err)
+	}
+
+	var hashes []string
+	targets := make(map[string]auditTarget)
+	seenPatchIDs := make(map[string]string)
+	for _, hash := range strings.Fields(string(output)) {
+		patchID, err := getPatchID(repoPath, hash)
+		if err != nil {
+			return nil, nil, err
+		}
+		if patchID != "" {
+			if original, ok := seenPatchIDs[patchID]; ok {
+				fmt.Printf("Skipping reflog commit %s: same patch as %s\n", hash, original)
+				continue
+			}
+			seenPatchIDs[patchID] = hash
+		}
+		hashes = append(hashes, hash)
+		targets[hash] = auditTarget{Unreachable: true}
+	}
+	return hashes, targets, nil
+}
+
+// getPatchID returns the stable patch-id of a commit, or an empty string for commits
+// without a diff (for which `git patch-id` prints nothing).
+func getPatchID(repoPath, commitHash string) (string, error) {
+	patch, err := gitRun(context.Background(), repoPath, "show", "--patch", commitHash)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute git show for patch-id of commit %s: %w", commitHash, err)
+	}
+	output, err := gitRunInput(context.Background(), repoPath, bytes.NewReader(patch), "patch-id", "--stable")
+	if err != nil {
+		return "", fmt.Errorf("failed to execute git patch-id for commit %s: %w", commitHash, err)
+	}
+	fields := strings.Fields(string(output))
+	if len(fields) == 0 {
+		return "", nil
+	}
+	return fields[0], nil
+}
+
+// getPatchForTarget generates the patch for a recovery target. Targets with a DiffBase are
+// rendered as their label, message and the diff against that base; everything else falls
+// back to getPatchForCommit. A pathspec limits the diff to the matching paths, and extra diff
+// arguments are passed through to git.
+func getPatchForTarget(ctx context.Context, repoPath, commitHash string, target auditTarget, privacy promptPrivacy, pathspec []string, diffArgs ...string) (string, error) {
+	if target.Patch != "" {
+		// A patch from stdin cannot be regenerated with less context.
+		return target.Patch, nil
+	}
+	if target.Group != nil {
+		return getGroupPatch(ctx, repoPath, target.Group, privacy, pathspec, diffArgs...)
+	}
+	if target.DiffBase == "" {
+		return getPatchForCommit(ctx, repoPath, commitHash, privacy, pathspec, diffArgs...)
+	}
+
+	args := append(append([]string{"diff"}, diffArgs...), target.DiffBase, commitHash)
+	if len(pathspec) > 0 {
+		args = append(append(args, "--"), pathspec...)
+	}
+	diffBytes, err := gitRun(ctx, repoPath, args...)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute git diff for %s: %w", target.Ref, err)
+	}
+	header := fmt.Sprintf("%s (%s)\n", target.Ref, commitHash)
+	if message := privacy.filter(privacy.SendMessage, target.Message); message != "" {
+		header += fmt.Sprintf("Message: %s\n", message)
+	}
+	return header + "\n" + string(diffBytes), nil
+}
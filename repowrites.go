@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultOutputFileName is the report written to the current directory when no other
+// location is chosen.
+const defaultOutputFileName = "gitaudit.txt"
+
+// Errors of an -output path, distinct from the failures of writing the report itself.
+var (
+	errOutputExists      = errors.New("output file already exists")
+	errOutputNotWritable = errors.New("output directory is not writable")
+)
+
+// xdgDataDir returns the gitaudit data directory, $XDG_DATA_HOME/gitaudit or
+// ~/.local/share/gitaudit when XDG_DATA_HOME is unset.
+func xdgDataDir() (string, error) {
+	base := os.Getenv("XDG_DATA_HOME")
+	if base == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to get user home directory: %w", err)
+		}
+		base = filepath.Join(homeDir, ".local", "share")
+	}
+	return filepath.Join(base, "gitaudit"), nil
+}
+
+// canonicalPath returns an absolute path with symlinks resolved as far as the path exists,
+// so that paths that do not exist yet (e.g. a report about to be created) can still be
+// compared against the repository location.
+func canonicalPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	existing := abs
+	var rest []string
+	for {
+		if resolved, err := filepath.EvalSymlinks(existing); err == nil {
+			return filepath.Join(append([]string{resolved}, rest...)...), nil
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return abs, nil
+		}
+		rest = append([]string{filepath.Base(existing)}, rest...)
+		existing = parent
+	}
+}
+
+// isInsideDir reports whether path is dir itself or located below it.
+func isInsideDir(path, dir string) (bool, error) {
+	canonical, err := canonicalPath(path)
+	if err != nil {
+		return false, err
+	}
+	canonicalDir, err := canonicalPath(dir)
+	if err != nil {
+		return false, err
+	}
+	rel, err := filepath.Rel(canonicalDir, canonical)
+	if err != nil {
+		return false, nil
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))), nil
+}
+
+// checkOutsideRepo returns an error when a user-supplied path lies inside the repository
+// working tree. It is used for every file gitaudit writes when -no-repo-writes is active.
+func checkOutsideRepo(path, repoRoot, description string) error {
+	inside, err := isInsideDir(path, repoRoot)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s path %s: %w", description, path, err)
+	}
+	if inside {
+		return fmt.Errorf("%s path %s is inside the repository working tree %s, which -no-repo-writes forbids", description, path, repoRoot)
+	}
+	return nil
+}
+
+// defaultOutputPath picks the report location when the user did not choose one. Normally
+// that is gitaudit.txt in the current directory; with -no-repo-writes, if the current
+// directory is inside the repository, the report goes to the XDG data directory instead.
+func defaultOutputPath(repoRoot string, noRepoWrites bool) (string, error) {
+	if !noRepoWrites {
+		return defaultOutputFileName, nil
+	}
+	inside, err := isInsideDir(defaultOutputFileName, repoRoot)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve output path: %w", err)
+	}
+	if !inside {
+		return defaultOutputFileName, nil
+	}
+
+	dataDir, err := xdgDataDir()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dataDir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create data directory %s: %w", dataDir, err)
+	}
+	path := filepath.Join(dataDir, fmt.Sprintf("%s-%s", filepath.Base(repoRoot), defaultOutputFileName))
+	// The data directory itself could live inside the repository in exotic setups.
+	if err := checkOutsideRepo(path, repoRoot, "output"); err != nil {
+		return "", err
+	}
+	fmt.Printf("Note: the current directory is inside the audited repository; writing the report to %s instead (-no-repo-writes).\n", path)
+	return path, nil
+}
+
+// outputPath returns the absolute path of the report: -output when it is given, creating its
+// directory and making sure a report can be written there, or the default location.
+func outputPath(output, repoRoot string, noRepoWrites bool) (string, error) {
+	if output == "" {
+		path, err := defaultOutputPath(repoRoot, noRepoWrites)
+		if err != nil {
+			return "", err
+		}
+		return filepath.Abs(path)
+	}
+	if noRepoWrites {
+		if err := checkOutsideRepo(output, repoRoot, "-output"); err != nil {
+			return "", err
+		}
+	}
+	path, err := filepath.Abs(output)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve output path %s: %w", output, err)
+	}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return "", fmt.Errorf("output path %s is a directory; -output names the report file", path)
+	}
+	if err := prepareOutputDir(filepath.Dir(path)); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// prepareOutputDir creates the directory of a report and makes sure it takes new files.
+// Reports are written to a temporary file in the same directory and renamed into place, so a
+// directory that takes no new file fails now rather than after the audit.
+func prepareOutputDir(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("%w: failed to create %s: %v", errOutputNotWritable, dir, err)
+	}
+	probe, err := os.CreateTemp(dir, ".gitaudit-*.tmp")
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", errOutputNotWritable, dir, err)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+	return nil
+}
+
+// checkNoClobber returns errOutputExists when path, a file or directory the run would
+// replace, already exists.
+func checkNoClobber(path string) error {
+	_, err := os.Lstat(path)
+	if err == nil {
+		return fmt.Errorf("%w: %s (pass -force to overwrite it)", errOutputExists, path)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to check output path %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// snapshotDir returns a digest of every file below dir, by path relative to it.
+func snapshotDir(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		rel, _ := filepath.Rel(dir, path)
+		files[rel] = hex.EncodeToString(sum[:])
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// compareSnapshots reports the files added, removed or changed between two snapshots.
+func compareSnapshots(t *testing.T, what string, before, after map[string]string) {
+	t.Helper()
+	for path, sum := range after {
+		if old, ok := before[path]; !ok {
+			t.Errorf("%s: %s was created", what, path)
+		} else if old != sum {
+			t.Errorf("%s: %s was modified", what, path)
+		}
+	}
+	for path := range before {
+		if _, ok := after[path]; !ok {
+			t.Errorf("%s: %s was removed", what, path)
+		}
+	}
+}
+
+func TestNoRepoWritesLeavesRepositoryUntouched(t *testing.T) {
+	tests := []struct {
+		name string
+		// inRepo runs gitaudit from the repository's working tree with -repo ., where the
+		// default report location would be inside it.
+		inRepo bool
+		args   []string
+	}{
+		{"repo elsewhere", false, nil},
+		{"run from the repository", true, []string{"-no-repo-writes"}},
+		{"with report sections", false, []string{"-author-rollup", "-heatmap", "-secret-report", "-check-tests", "-suggest-reviewers", "-include-tags"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newFixtureRepo(t)
+			repo.commits(6)
+			repo.git("tag", "-a", "v1.0", "-m", "First release")
+			repo.commit("Add tests", map[string]string{"src/file0_test.go": "package src\n"})
+			env := newAuditEnv(t)
+			args := []string{"-repo", repo.Dir, "-commit", "root"}
+			if tt.inRepo {
+				env.Work = repo.Dir
+				args = []string{"-repo", ".", "-commit", "root"}
+			}
+			args = append(args, tt.args...)
+
+			if status := repo.git("status", "--porcelain", "--ignored"); status != "" {
+				t.Fatalf("fixture is not clean before the run:\n%s", status)
+			}
+			tree := snapshotDir(t, repo.Dir)
+			out := env.mustRun(args...)
+
+			if status := repo.git("status", "--porcelain", "--ignored"); status != "" {
+				t.Errorf("git status --porcelain after the run:\n%s", status)
+			}
+			compareSnapshots(t, "repository", tree, snapshotDir(t, repo.Dir))
+			if !strings.Contains(out, "Successfully wrote ") {
+				t.Errorf("run did not write its report:\n%s", out)
+			}
+			if tt.inRepo && !strings.Contains(out, filepath.Join(env.Home, ".local", "share", "gitaudit")) {
+				t.Errorf("report was not redirected to the data directory:\n%s", out)
+			}
+		})
+	}
+}
+
+func TestNoRepoWritesRefusesPathsInsideRepository(t *testing.T) {
+	repo := newFixtureRepo(t)
+	repo.commits(2)
+	env := newAuditEnv(t)
+	for _, args := range [][]string{
+		{"-output", filepath.Join(repo.Dir, "gitaudit.txt")},
+		{"-output", filepath.Join(repo.Dir, "reports", "audit.txt")},
+		{"-out", "json=" + filepath.Join(repo.Dir, "audit.json")},
+		{"-record", filepath.Join(repo.Dir, "recordings")},
+	} {
+		out, code := env.run(append([]string{"-repo", repo.Dir, "-commit", "root"}, args...)...)
+		if code == 0 || !strings.Contains(out, "-no-repo-writes forbids") {
+			t.Errorf("%v: exit %d, want a -no-repo-writes error:\n%s", args, code, out)
+		}
+	}
+	if status := repo.git("status", "--porcelain", "--ignored"); status != "" {
+		t.Errorf("git status --porcelain after the refused runs:\n%s", status)
+	}
+	if len(env.Ollama.Prompts()) != 0 {
+		t.Errorf("refused runs sent %d prompts", len(env.Ollama.Prompts()))
+	}
+}
+
+func TestCheckOutsideRepo(t *testing.T) {
+	root := t.TempDir()
+	repo := filepath.Join(root, "repo")
+	if err := os.MkdirAll(filepath.Join(repo, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(root, "link")
+	if err := os.Symlink(filepath.Join(repo, "sub"), link); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path   string
+		inside bool
+	}{
+		{repo, true},
+		{filepath.Join(repo, "gitaudit.txt"), true},
+		{filepath.Join(repo, "sub", "new", "dir", "report.txt"), true},
+		{filepath.Join(repo, "..", "repo", "x"), true},
+		{filepath.Join(link, "report.txt"), true},
+		{filepath.Join(root, "repo2", "report.txt"), false},
+		{filepath.Join(root, "report.txt"), false},
+		{root, false},
+	}
+	for _, tt := range tests {
+		err := checkOutsideRepo(tt.path, repo, "output")
+		if (err != nil) != tt.inside {
+			t.Errorf("checkOutsideRepo(%s) = %v, want inside %v", tt.path, err, tt.inside)
+		}
+	}
+}
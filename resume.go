@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// runStateVersion is the format of the state file, raised when a record changes meaning.
+const runStateVersion = 1
+
+// runStateHeader is the first record of a state file: the audit it belongs to.
+type runStateHeader struct {
+	Version  int       `json:"version"`
+	Repo     string    `json:"repo"`
+	Head     string    `json:"head"`
+	Boundary string    `json:"boundary"`
+	Range    []string  `json:"range"`
+	Started  time.Time `json:"started"`
+	// Parameters are the settings that shape the summaries, as in shard manifests.
+	Parameters map[string]string `json:"parameters"`
+}
+
+// runStateFailure records a commit given up or found unauditable; -resume tries it again.
+type runStateFailure struct {
+	Hash  string `json:"hash"`
+	Error string `json:"error"`
+}
+
+// runStateRecord is one line of a state file; exactly one field is set.
+type runStateRecord struct {
+	Run    *runStateHeader  `json:"run,omitempty"`
+	Entry  *CommitAuditData `json:"entry,omitempty"`
+	Failed *runStateFailure `json:"failed,omitempty"`
+}
+
+// runState is the state file of a range audit, which lets -resume carry the commits audited
+// by an interrupted or crashed run over to the next one. It is JSON Lines: the header, then a
+// record per commit as it completes, appended and synced so that a crash loses nothing
+// already written. A fully successful run removes it.
+type runState struct {
+	Path string
+
+	file *os.File
+}
+
+// statePathFor returns the state file kept next to the given report path.
+func statePathFor(outputPath string) string {
+	return outputPath + ".state"
+}
+
+// resumedRun is what an earlier run's state file carries over.
+type resumedRun struct {
+	Header runStateHeader
+	// Entries are the entries of the commits the earlier run audited, by hash.
+	Entries map[string]CommitAuditData
+}
+
+// loadRunState reads the state file at path. A last line cut short by a crash is ignored.
+func loadRunState(path string) (*resumedRun, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state file: %w", err)
+	}
+	defer file.Close()
+	run := &resumedRun{Entries: make(map[string]CommitAuditData)}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 64<<20)
+	var pendingErr error
+	for line := 1; scanner.Scan(); line++ {
+		if pendingErr != nil {
+			return nil, pendingErr
+		}
+		var record runStateRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			// Only the last line may be incomplete.
+			pendingErr = fmt.Errorf("state file %s: line %d: %w", path, line, err)
+			continue
+		}
+		switch {
+		case line == 1 && record.Run == nil:
+			return nil, fmt.Errorf("state file %s does not start with the run it belongs to", path)
+		case record.Run != nil:
+			if record.Run.Version != runStateVersion {
+				return nil, fmt.Errorf("state file %s has version %d; this gitaudit reads version %d", path, record.Run.Version, runStateVersion)
+			}
+			run.Header = *record.Run
+		case record.Entry != nil:
+			run.Entries[record.Entry.Hash] = *record.Entry
+		case record.Failed != nil:
+			delete(run.Entries, record.Failed.Hash)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read state file %s: %w", path, err)
+	}
+	if run.Header.Version == 0 {
+		return nil, fmt.Errorf("state file %s is empty", path)
+	}
+	return run, nil
+}
+
+// check refuses to resume an audit of another repository or range end, and returns warnings
+// for the settings that differ from the earlier run's.
+func (r *resumedRun) check(path, repo, boundary string, parameters map[string]string) ([]string, error) {
+	if r.Header.Repo != repo {
+		return nil, fmt.Errorf("state file %s belongs to an audit of %s, not %s; remove it or pass another -output to start over", path, r.Header.Repo, repo)
+	}
+	if r.Header.Boundary != boundary {
+		return nil, fmt.Errorf("state file %s belongs to an audit back to %s, not %s; pass the same -commit, or remove it to start over", path, shortHash(r.Header.Boundary), shortHash(boundary))
+	}
+	names := make(map[string]bool)
+	for name := range r.Header.Parameters {
+		names[name] = true
+	}
+	for name := range parameters {
+		names[name] = true
+	}
+	var warnings []string
+	for name := range names {
+		if r.Header.Parameters[name] != parameters[name] {
+			warnings = append(warnings, fmt.Sprintf("the earlier run used %s %q, this one %q", name, r.Header.Parameters[name], parameters[name]))
+		}
+	}
+	sort.Strings(warnings)
+	return warnings, nil
+}
+
+// Carry returns the entries of the commits of hashes the earlier run audited, and the hashes
+// left to audit, both in the order of hashes.
+func (r *resumedRun) Carry(hashes []string) (carried []CommitAuditData, remaining []string) {
+	for _, hash := range hashes {
+		if data, ok := r.Entries[hash]; ok {
+			carried = append(carried, data)
+		} else {
+			remaining = append(remaining, hash)
+		}
+	}
+	return carried, remaining
+}
+
+// openRunState starts the state file at path with header and the entries carried over from
+// the run being resumed. The state file of an earlier run that is not resumed is moved aside
+// rather than overwritten, as it may be resumed later.
+func openRunState(path string, header runStateHeader, carried []CommitAuditData, resuming bool) (*runState, error) {
+	if _, err := os.Stat(path); err == nil && !resuming {
+		previous := path + "." + time.Now().Format("20060102-150405")
+		if err := os.Rename(path, previous); err != nil {
+			return nil, fmt.Errorf("failed to move aside the state file %s of an earlier run: %w", path, err)
+		}
+		fmt.Printf("Warning: %s is the state of an earlier run that did not finish; moved it to %s (-resume continues such a run)\n", path, previous)
+	}
+	records := []runStateRecord{{Run: &header}}
+	for i := range carried {
+		records = append(records, runStateRecord{Entry: &carried[i]})
+	}
+	var sb strings.Builder
+	for _, record := range records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode state file: %w", err)
+		}
+		sb.Write(line)
+		sb.WriteByte('\n')
+	}
+	// The carried entries are written whole before the old state is replaced.
+	if err := writeFileAtomic(path, []byte(sb.String())); err != nil {
+		return nil, fmt.Errorf("failed to write state file %s: %w", path, err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state file %s: %w", path, err)
+	}
+	return &runState{Path: path, file: file}, nil
+}
+
+// Audited records the entry of an audited commit. It is safe to call on nil.
+func (s *runState) Audited(data CommitAuditData) {
+	s.append(runStateRecord{Entry: &data})
+}
+
+// Failed records a commit given up or found unauditable. It is safe to call on nil.
+func (s *runState) Failed(hash string, err error) {
+	failure := &runStateFailure{Hash: hash}
+	if err != nil {
+		failure.Error = err.Error()
+	}
+	s.append(runStateRecord{Failed: failure})
+}
+
+// append writes one record and syncs it. A failed write is reported once and ends the
+// state file's updates; the run itself goes on.
+func (s *runState) append(record runStateRecord) {
+	if s == nil || s.file == nil {
+		return
+	}
+	line, err := json.Marshal(record)
+	if err == nil {
+		_, err = s.file.Write(append(line, '\n'))
+	}
+	if err == nil {
+		err = s.file.Sync()
+	}
+	if err != nil {
+		fmt.Printf("Warning: failed to update the state file %s; -resume will redo the commits audited from now on: %v\n", s.Path, err)
+		s.file.Close()
+		s.file = nil
+	}
+}
+
+// Finish closes the state file, and removes it when the run audited every commit of its
+// range and wrote its report, leaving nothing to resume.
+func (s *runState) Finish(complete bool) {
+	if s == nil {
+		return
+	}
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+	if complete {
+		if err := os.Remove(s.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			fmt.Printf("Warning: failed to remove the state file %s: %v\n", s.Path, err)
+		}
+		return
+	}
+	fmt.Printf("Run again with -resume to continue this audit; its state is kept in %s\n", s.Path)
+}
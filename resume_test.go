@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stateLines encodes records as the lines of a state file.
+func stateLines(t *testing.T, records ...runStateRecord) string {
+	t.Helper()
+	var sb strings.Builder
+	for _, record := range records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sb.Write(line)
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+func TestLoadRunState(t *testing.T) {
+	header := &runStateHeader{Version: runStateVersion, Repo: "/src/app", Boundary: "b0", Range: []string{"c3", "c2", "c1"}}
+	full := stateLines(t,
+		runStateRecord{Run: header},
+		runStateRecord{Entry: &CommitAuditData{Hash: "c1", Summary: "One."}},
+		runStateRecord{Entry: &CommitAuditData{Hash: "c2", Summary: "Two."}},
+	)
+	for _, tc := range []struct {
+		name, content string
+		want          []string // The hashes of the entries loaded, sorted.
+		wantErr       string
+	}{
+		{name: "complete", content: full, want: []string{"c1", "c2"}},
+		// A crash while appending leaves the last line cut short; the records before it count.
+		{name: "truncated last line", content: full + `{"entry":{"hash":"c3","summ`, want: []string{"c1", "c2"}},
+		{name: "truncated last line without entries", content: stateLines(t, runStateRecord{Run: header}) + `{"ent`, want: []string{}},
+		// A failed record takes back an entry, so that -resume tries the commit again.
+		{name: "failed after audited", content: full + stateLines(t, runStateRecord{Failed: &runStateFailure{Hash: "c1", Error: "busy"}}), want: []string{"c2"}},
+		{name: "corrupt line before the last", content: full + "not json\n" + stateLines(t, runStateRecord{Entry: &CommitAuditData{Hash: "c3"}}), wantErr: "line 4: "},
+		{name: "no header", content: stateLines(t, runStateRecord{Entry: &CommitAuditData{Hash: "c1"}}), wantErr: "does not start with the run it belongs to"},
+		{name: "other version", content: stateLines(t, runStateRecord{Run: &runStateHeader{Version: runStateVersion + 1}}), wantErr: "this gitaudit reads version 1"},
+		{name: "empty", content: "", wantErr: "is empty"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "gitaudit.txt.state")
+			if err := os.WriteFile(path, []byte(tc.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			run, err := loadRunState(path)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("error %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, hash := range []string{"c1", "c2", "c3"} {
+				if _, ok := run.Entries[hash]; ok {
+					got = append(got, hash)
+				}
+			}
+			if !reflect.DeepEqual(got, tc.want) || run.Header.Repo != "/src/app" {
+				t.Errorf("entries %q, header %+v; want %q", got, run.Header, tc.want)
+			}
+		})
+	}
+	if _, err := loadRunState(filepath.Join(t.TempDir(), "missing.state")); err == nil || !strings.HasPrefix(err.Error(), "failed to open state file: ") {
+		t.Errorf("missing state file: %v", err)
+	}
+}
+
+func TestResumedRunCheck(t *testing.T) {
+	run := &resumedRun{Header: runStateHeader{Repo: "/src/app", Boundary: strings.Repeat("a", 40), Parameters: map[string]string{"model": "tiny", "detail": "full"}}}
+	for _, tc := range []struct {
+		name, repo, boundary string
+		parameters           map[string]string
+		want                 []string
+		wantErr              string
+	}{
+		{name: "same run", repo: "/src/app", boundary: strings.Repeat("a", 40), parameters: map[string]string{"model": "tiny", "detail": "full"}},
+		{
+			name: "other settings", repo: "/src/app", boundary: strings.Repeat("a", 40), parameters: map[string]string{"model": "large", "cite": "true"},
+			want: []string{`the earlier run used cite "", this one "true"`, `the earlier run used detail "full", this one ""`, `the earlier run used model "tiny", this one "large"`},
+		},
+		{name: "other repository", repo: "/src/other", boundary: strings.Repeat("a", 40), wantErr: "belongs to an audit of /src/app, not /src/other"},
+		{name: "other range boundary", repo: "/src/app", boundary: strings.Repeat("b", 40), wantErr: "belongs to an audit back to " + shortHash(strings.Repeat("a", 40)) + ", not " + shortHash(strings.Repeat("b", 40)) + "; pass the same -commit"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			warnings, err := run.check("gitaudit.txt.state", tc.repo, tc.boundary, tc.parameters)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("error %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(warnings, tc.want) {
+				t.Errorf("warnings %q, %v; want %q", warnings, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestResumedRunCarry(t *testing.T) {
+	run := &resumedRun{Entries: map[string]CommitAuditData{"c4": {Hash: "c4"}, "c2": {Hash: "c2"}, "other": {Hash: "other"}}}
+	carried, remaining := run.Carry([]string{"c5", "c4", "c3", "c2", "c1"})
+	var hashes []string
+	for _, entry := range carried {
+		hashes = append(hashes, entry.Hash)
+	}
+	// Both keep the order of the range; entries outside it are dropped.
+	if !reflect.DeepEqual(hashes, []string{"c4", "c2"}) || !reflect.DeepEqual(remaining, []string{"c5", "c3", "c1"}) {
+		t.Errorf("carried %q, remaining %q", hashes, remaining)
+	}
+}
+
+func TestRunStateFile(t *testing.T) {
+	dir := t.TempDir()
+	path := statePathFor(filepath.Join(dir, "gitaudit.txt"))
+	header := runStateHeader{Version: runStateVersion, Repo: "/src/app", Boundary: "b0", Started: time.Now()}
+
+	var state *runState
+	out := captureStdout(t, func() {
+		var err error
+		if state, err = openRunState(path, header, []CommitAuditData{{Hash: "c1"}}, false); err != nil {
+			t.Fatal(err)
+		}
+		state.Audited(CommitAuditData{Hash: "c2", Summary: "Two."})
+		state.Failed("c3", errEmptyResponse)
+		state.Finish(false)
+	})
+	if !strings.Contains(out, "Run again with -resume to continue this audit; its state is kept in "+path+"\n") {
+		t.Errorf("an unfinished run printed %q", out)
+	}
+	run, err := loadRunState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(run.Entries) != 2 || run.Entries["c2"].Summary != "Two." {
+		t.Errorf("kept entries %+v", run.Entries)
+	}
+	if !strings.Contains(readFile(t, path), `{"failed":{"hash":"c3","error":"the model returned an empty response"}}`) {
+		t.Errorf("state file:\n%s", readFile(t, path))
+	}
+
+	// A run that does not resume moves the earlier state aside instead of overwriting it.
+	out = captureStdout(t, func() {
+		if state, err = openRunState(path, header, nil, false); err != nil {
+			t.Fatal(err)
+		}
+	})
+	aside, _ := filepath.Glob(path + ".*")
+	if len(aside) != 1 || !strings.Contains(out, "moved it to "+aside[0]) {
+		t.Fatalf("moved aside %q:\n%s", aside, out)
+	}
+	if moved, err := loadRunState(aside[0]); err != nil || len(moved.Entries) != 2 {
+		t.Errorf("the moved state: %+v, %v", moved, err)
+	}
+
+	// A resumed run rewrites the state in place with the entries it carries over.
+	captureStdout(t, func() {
+		state.Finish(false)
+		if state, err = openRunState(path, header, []CommitAuditData{{Hash: "c1"}}, true); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if again, _ := filepath.Glob(path + ".*"); len(again) != 1 {
+		t.Errorf("resuming moved the state aside: %q", again)
+	}
+
+	// A complete run removes it, silently.
+	if out := captureStdout(t, func() { state.Finish(true) }); out != "" {
+		t.Errorf("a complete run printed %q", out)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("the state file of a complete run is left: %v", err)
+	}
+	// Nil and closed states ignore updates.
+	var none *runState
+	none.Audited(CommitAuditData{Hash: "c9"})
+	none.Finish(true)
+	state.Audited(CommitAuditData{Hash: "c9"})
+}
+
+// TestResumeRun stops a run with a refusal partway through the range, then resumes it: the
+// resumed run sends only the commits left, in range order, and removes the state file once
+// the report is complete.
+func TestResumeRun(t *testing.T) {
+	repo := newFixtureRepo(t)
+	hashes := repo.commits(4)
+	env := newAuditEnv(t)
+	env.Ollama.Respond = func(n int, prompt string) (int, string) {
+		if strings.Contains(prompt, "Change 2") {
+			return 404, `{"error":"model \"tiny:0.5b\" not found, try pulling it first"}`
+		}
+		return 0, ""
+	}
+	report := filepath.Join(env.Work, "report.txt")
+	state := statePathFor(report)
+	args := []string{"-repo", repo.Dir, "-commit", "root", "-output", report}
+	if out, code := env.run(args...); code == 0 {
+		t.Fatalf("the refused run succeeded:\n%s", out)
+	}
+	run, err := loadRunState(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audited := len(run.Entries)
+	if audited == 0 || audited == 4 {
+		t.Fatalf("the stopped run audited %d commits", audited)
+	}
+	if !reflect.DeepEqual(run.Header.Range, []string{hashes[3], hashes[2], hashes[1], hashes[0]}) {
+		t.Errorf("range %q", run.Header.Range)
+	}
+
+	// The retried commits keep the order of the range.
+	env.Ollama.Respond = nil
+	before := len(env.Ollama.Prompts())
+	out := env.mustRun(append(args, "-resume")...)
+	if !strings.Contains(out, "Resuming the run started ") {
+		t.Errorf("output:\n%s", out)
+	}
+	var sent []string
+	for _, prompt := range env.Ollama.Prompts()[before:] {
+		for i := 3; i >= 0; i-- {
+			if strings.Contains(prompt, "Change "+string(rune('0'+i))) {
+				sent = append(sent, hashes[i])
+			}
+		}
+	}
+	var want []string
+	for _, hash := range run.Header.Range {
+		if _, ok := run.Entries[hash]; !ok {
+			want = append(want, hash)
+		}
+	}
+	if !reflect.DeepEqual(sent, want) {
+		t.Errorf("resumed run sent %q, want %q", sent, want)
+	}
+	content := readFile(t, report)
+	if n := strings.Count(content, "Commit: "); n != 4 || !strings.Contains(content, "Resumed: ") {
+		t.Errorf("the report has %d entries:\n%s", n, content)
+	}
+	if _, err := os.Stat(state); !os.IsNotExist(err) {
+		t.Errorf("the state file of the complete run is left: %v", err)
+	}
+
+	// A state file of another range end is refused.
+	env.Ollama.Respond = func(n int, prompt string) (int, string) { return 503, "busy" }
+	captureStdout(t, func() {
+		s, err := openRunState(state, runStateHeader{Version: runStateVersion, Repo: run.Header.Repo, Boundary: hashes[1]}, nil, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Finish(false)
+	})
+	if out, code := env.run(append(args, "-resume", "-force")...); code == 0 || !strings.Contains(out, "belongs to an audit back to "+shortHash(hashes[1])) {
+		t.Errorf("other boundary: exit %d\n%s", code, out)
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// The retention trailer is a fenced block of "key: value" lines closing a report. Its keys and
+// the header line naming the covered range are never translated, so that a records system
+// reads every report alike.
+const (
+	retentionFenceOpen   = "```gitaudit-retention"
+	retentionFenceClose  = "```"
+	retentionRangeHeader = "Retention range: "
+	retentionDigestAlgo  = "sha256:"
+)
+
+// retentionTrailer is the records-management metadata of a report. Digest covers every byte
+// of the report before the trailer, so an edited body no longer matches it.
+type retentionTrailer struct {
+	Class     string
+	Owner     string
+	Range     string
+	Generated time.Time
+	Digest    string
+}
+
+// validateRetention checks the retention_class and document_owner config keys. Each becomes
+// one trailer line, and an owner without a class would have no trailer to go in.
+func validateRetention(class, owner string) error {
+	if owner != "" && class == "" {
+		return fmt.Errorf("document_owner needs retention_class")
+	}
+	for key, value := range map[string]string{"retention_class": class, "document_owner": owner} {
+		if strings.ContainsAny(value, "\r\n") || value != strings.TrimSpace(value) {
+			return fmt.Errorf("%s %q must be one line without leading or trailing spaces", key, value)
+		}
+	}
+	return nil
+}
+
+// retentionRange describes the commits of a run, newest first as in hashes, as
+// "<oldest>..<newest>", or a single hash.
+func retentionRange(hashes []string) string {
+	switch len(hashes) {
+	case 0:
+		return ""
+	case 1:
+		return hashes[0]
+	}
+	return hashes[len(hashes)-1] + ".." + hashes[0]
+}
+
+// retentionDigest returns the digest of a report body as the trailer records it.
+func retentionDigest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return retentionDigestAlgo + hex.EncodeToString(sum[:])
+}
+
+// format renders the trailer as the block appended to a report.
+func (t retentionTrailer) format() string {
+	var sb strings.Builder
+	sb.WriteString("\n" + retentionFenceOpen + "\n")
+	fmt.Fprintf(&sb, "class: %s\n", t.Class)
+	if t.Owner != "" {
+		fmt.Fprintf(&sb, "owner: %s\n", t.Owner)
+	}
+	fmt.Fprintf(&sb, "range: %s\n", t.Range)
+	fmt.Fprintf(&sb, "generated: %s\n", t.Generated.UTC().Format(time.RFC3339))
+	fmt.Fprintf(&sb, "digest: %s\n", t.Digest)
+	sb.WriteString(retentionFenceClose + "\n")
+	return sb.String()
+}
+
+// appendRetentionTrailer closes the report at path with the trailer of config's retention
+// keys. It runs once the report is complete, after the appended sections, so that the
+// digest covers them.
+func appendRetentionTrailer(path string, config *Config, covered string) error {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read report %s for its retention trailer: %w", path, err)
+	}
+	trailer := retentionTrailer{
+		Class:     config.RetentionClass,
+		Owner:     config.DocumentOwner,
+		Range:     covered,
+		Generated: time.Now(),
+		Digest:    retentionDigest(body),
+	}
+	sealed := append(body, trailer.format()...)
+	if err := writeFileAtomic(path, sealed); err != nil {
+		return fmt.Errorf("failed to write the retention trailer of report %s: %w", path, err)
+	}
+	// writeFileAtomic uses CreateTemp's 0600; the report stays as readable as it was.
+	os.Chmod(path, 0o644)
+	return nil
+}
+
+// sealReports appends the retention trailer to each written report file, warning about
+// those that fail; the reports themselves are already complete.
+func sealReports(paths []string, config *Config, covered string) {
+	for _, path := range paths {
+		if err := appendRetentionTrailer(path, config, covered); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+	}
+}
+
+// reportFiles lists the files writeReport wrote for entries: the report, or the parts and
+// the index of a split report.
+func reportFiles(filename string, entries []CommitAuditData, policy splitPolicy) []string {
+	if policy.Mode == "" {
+		return []string{filename}
+	}
+	var paths []string
+	for _, shard := range shardReport(entries, policy, filename) {
+		paths = append(paths, shard.Path)
+	}
+	return append(paths, splitIndexPath(filename))
+}
+
+// parseRetentionTrailer splits a report into its body and trailer. It fails when the report
+// does not end with a trailer or the trailer lacks a required key.
+func parseRetentionTrailer(data []byte) ([]byte, retentionTrailer, error) {
+	var trailer retentionTrailer
+	start := bytes.LastIndex(data, []byte("\n"+retentionFenceOpen+"\n"))
+	if start < 0 {
+		return nil, trailer, fmt.Errorf("no retention trailer found")
+	}
+	block := strings.TrimRight(string(data[start+1:]), "\n")
+	lines := strings.Split(block, "\n")
+	if len(lines) < 2 || lines[len(lines)-1] != retentionFenceClose {
+		return nil, trailer, fmt.Errorf("the retention trailer is not closed, or text follows it")
+	}
+	seen := make(map[string]bool)
+	for _, line := range lines[1 : len(lines)-1] {
+		key, value, ok := strings.Cut(line, ": ")
+		if !ok {
+			return nil, trailer, fmt.Errorf("malformed retention trailer line %q", line)
+		}
+		if seen[key] {
+			return nil, trailer, fmt.Errorf("retention trailer key %q appears twice", key)
+		}
+		seen[key] = true
+		switch key {
+		case "class":
+			trailer.Class = value
+		case "owner":
+			trailer.Owner = value
+		case "range":
+			trailer.Range = value
+		case "generated":
+			generated, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return nil, trailer, fmt.Errorf("invalid retention trailer date %q: %w", value, err)
+			}
+			trailer.Generated = generated
+		case "digest":
+			trailer.Digest = value
+		default:
+			return nil, trailer, fmt.Errorf("unknown retention trailer key %q", key)
+		}
+	}
+	for _, key := range []string{"class", "range", "generated", "digest"} {
+		if !seen[key] {
+			return nil, trailer, fmt.Errorf("the retention trailer has no %s", key)
+		}
+	}
+	return data[:start], trailer, nil
+}
+
+// verifyRetention checks the retention trailer of a report: present and complete, its digest
+// that of the body before it, and its range the one the report header names.
+func verifyRetention(data []byte) (retentionTrailer, error) {
+	body, trailer, err := parseRetentionTrailer(data)
+	if err != nil {
+		return trailer, err
+	}
+	if digest := retentionDigest(body); digest != trailer.Digest {
+		return trailer, fmt.Errorf("digest mismatch: the trailer records %s but the report body hashes to %s; the report was changed after it was written", trailer.Digest, digest)
+	}
+	headerRange := ""
+	for _, line := range strings.Split(string(body), "\n") {
+		if strings.HasPrefix(line, retentionRangeHeader) {
+			headerRange = strings.TrimPrefix(line, retentionRangeHeader)
+			break
+		}
+	}
+	if headerRange == "" {
+		return trailer, fmt.Errorf("the report header has no %q line", strings.TrimSpace(retentionRangeHeader))
+	}
+	if headerRange != trailer.Range {
+		return trailer, fmt.Errorf("range mismatch: the header covers %s but the trailer records %s", headerRange, trailer.Range)
+	}
+	return trailer, nil
+}
+
+// verifyFlags are the flags of `gitaudit verify`.
+type verifyFlags struct {
+	Output string
+}
+
+// registerVerifyFlags defines the flags of `gitaudit verify` on fs.
+func registerVerifyFlags(fs *flag.FlagSet) *verifyFlags {
+	v := &verifyFlags{}
+	fs.StringVar(&v.Output, "output", defaultOutputFileName, "Path of the report to verify")
+	return v
+}
+
+// runVerify implements `gitaudit verify`: it checks the retention trailer of a report and
+// exits with a non-zero status when it is missing or inconsistent.
+func runVerify(args []string) {
+	fs := newCommandFlagSet("verify")
+	verify := registerVerifyFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fmt.Printf("Error: unexpected arguments %s; name the report with -output\n", strings.Join(fs.Args(), " "))
+		os.Exit(1)
+	}
+	data, err := os.ReadFile(verify.Output)
+	if err != nil {
+		fmt.Printf("Error: failed to read report: %v\n", err)
+		os.Exit(1)
+	}
+	trailer, err := verifyRetention(data)
+	if err != nil {
+		fmt.Printf("Error: %s: %v\n", verify.Output, err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s: retention trailer verified (class %s, range %s, generated %s)\n", verify.Output, trailer.Class, trailer.Range, trailer.Generated.Format(time.RFC3339))
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateRetention(t *testing.T) {
+	for _, tc := range []struct {
+		class, owner string
+		want         string
+	}{
+		{"", "", ""},
+		{"SOX-7Y", "", ""},
+		{"SOX-7Y", "Internal Audit <audit@example.com>", ""},
+		{"", "Internal Audit", "document_owner needs retention_class"},
+		{"SOX-7Y\nextra: line", "", `retention_class "SOX-7Y\nextra: line" must be one line`},
+		{"SOX-7Y", " Internal Audit", `document_owner " Internal Audit" must be one line`},
+	} {
+		err := validateRetention(tc.class, tc.owner)
+		if (err == nil) != (tc.want == "") || err != nil && !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("validateRetention(%q, %q) = %v, want %q", tc.class, tc.owner, err, tc.want)
+		}
+	}
+}
+
+func TestRetentionRange(t *testing.T) {
+	for _, tc := range []struct {
+		hashes []string
+		want   string
+	}{
+		{nil, ""},
+		{[]string{"c3"}, "c3"},
+		{[]string{"c3", "c2", "c1"}, "c1..c3"},
+	} {
+		if got := retentionRange(tc.hashes); got != tc.want {
+			t.Errorf("retentionRange(%q) = %q, want %q", tc.hashes, got, tc.want)
+		}
+	}
+}
+
+func TestRetentionTrailerGolden(t *testing.T) {
+	trailer := retentionTrailer{
+		Class:     "SOX-7Y",
+		Owner:     "Internal Audit <audit@example.com>",
+		Range:     "0123456789abcdef0123456789abcdef01234567..89abcdef0123456789abcdef0123456789abcdef",
+		Generated: time.Date(2024, 3, 1, 14, 30, 0, 0, time.FixedZone("CET", 3600)),
+		Digest:    retentionDigest([]byte("Git Audit Report\n")),
+	}
+	checkGolden(t, "retention/trailer.txt", trailer.format())
+	// The owner line is left out when document_owner is not set.
+	trailer.Owner = ""
+	checkGolden(t, "retention/trailer-no-owner.txt", trailer.format())
+}
+
+// sealedReport returns a report body naming covered in its header, closed by its trailer.
+func sealedReport(covered string) (string, retentionTrailer) {
+	body := "Git Audit Report\n" + retentionRangeHeader + covered + "\n\nCommit: abc\nSummary.\n"
+	trailer := retentionTrailer{Class: "SOX-7Y", Range: covered, Generated: time.Date(2024, 3, 1, 13, 30, 0, 0, time.UTC), Digest: retentionDigest([]byte(body))}
+	return body, trailer
+}
+
+func TestVerifyRetention(t *testing.T) {
+	body, trailer := sealedReport("c1..c3")
+	sealed := body + trailer.format()
+	got, err := verifyRetention([]byte(sealed))
+	if err != nil || got != trailer {
+		t.Fatalf("verifyRetention = %+v, %v; want %+v", got, err, trailer)
+	}
+
+	for name, tc := range map[string]struct {
+		report string
+		want   string
+	}{
+		"edited body":      {strings.Replace(sealed, "Summary.", "Summary!", 1), "digest mismatch: the trailer records sha256:"},
+		"appended body":    {body + "Commit: def\n" + trailer.format(), "digest mismatch"},
+		"tampered digest":  {strings.Replace(sealed, trailer.Digest, retentionDigest([]byte("forged")), 1), "digest mismatch"},
+		"truncated digest": {strings.Replace(sealed, trailer.Digest, trailer.Digest[:20], 1), "digest mismatch"},
+		"range mismatch":   {body + retentionTrailer{Class: "SOX-7Y", Range: "c2..c3", Generated: trailer.Generated, Digest: trailer.Digest}.format(), "range mismatch: the header covers c1..c3 but the trailer records c2..c3"},
+		"no header line":   {"Git Audit Report\n" + retentionTrailer{Class: "SOX-7Y", Range: "c1", Generated: trailer.Generated, Digest: retentionDigest([]byte("Git Audit Report\n"))}.format(), `the report header has no "Retention range:" line`},
+		"no trailer":       {body, "no retention trailer found"},
+		"text after":       {sealed + "Edited later.\n", "not closed, or text follows it"},
+		"unclosed":         {strings.TrimSuffix(sealed, "```\n"), "not closed, or text follows it"},
+		"duplicate key":    {strings.Replace(sealed, "class: SOX-7Y\n", "class: SOX-7Y\nclass: none\n", 1), `key "class" appears twice`},
+		"unknown key":      {strings.Replace(sealed, "class: SOX-7Y\n", "class: SOX-7Y\nexpires: never\n", 1), `unknown retention trailer key "expires"`},
+		"malformed line":   {strings.Replace(sealed, "class: SOX-7Y\n", "class SOX-7Y\n", 1), `malformed retention trailer line "class SOX-7Y"`},
+		"missing digest":   {strings.Replace(sealed, "digest: "+trailer.Digest+"\n", "", 1), "the retention trailer has no digest"},
+		"invalid date":     {strings.Replace(sealed, "2024-03-01T13:30:00Z", "yesterday", 1), `invalid retention trailer date "yesterday"`},
+	} {
+		if _, err := verifyRetention([]byte(tc.report)); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: %v, want %q", name, err, tc.want)
+		}
+	}
+}
+
+func TestRetentionRun(t *testing.T) {
+	repo := newFixtureRepo(t)
+	hashes := repo.commits(3)
+	env := newAuditEnv(t)
+	env.Config["retention_class"] = "SOX-7Y"
+	env.Config["document_owner"] = "Internal Audit"
+	report := filepath.Join(env.Work, "report.txt")
+	env.mustRun("-repo", repo.Dir, "-commit", hashes[1], "-output", report, "-heatmap")
+	content := readFile(t, report)
+	covered := hashes[1] + ".." + hashes[2]
+	if !strings.Contains(content, "\nRetention range: "+covered+"\n") {
+		t.Errorf("the header lacks the range:\n%s", content)
+	}
+	// The trailer closes the report, after the appended sections.
+	trailer := "\n```gitaudit-retention\nclass: SOX-7Y\nowner: Internal Audit\nrange: " + covered + "\ngenerated: "
+	if at := strings.LastIndex(content, trailer); at < 0 || at < strings.Index(content, "=== Change heatmap") || !strings.HasSuffix(content, "\n```\n") {
+		t.Errorf("trailer:\n%s", content)
+	}
+	if info, err := os.Stat(report); err != nil || info.Mode().Perm() != 0o644 {
+		t.Errorf("report mode %v, %v", info.Mode(), err)
+	}
+
+	out := env.mustRun("verify", "-output", report)
+	if !strings.HasPrefix(out, report+": retention trailer verified (class SOX-7Y, range "+covered+", generated ") {
+		t.Errorf("verify output:\n%s", out)
+	}
+
+	// A single edited byte of the body, or a forged digest, fails verification.
+	for name, tampered := range map[string]string{
+		"body":   strings.Replace(content, "Commit: "+hashes[2], "Commit: "+hashes[0], 1),
+		"digest": strings.Replace(content, "digest: sha256:", "digest: sha256:00", 1),
+	} {
+		path := filepath.Join(env.Work, "tampered-"+name+".txt")
+		if err := os.WriteFile(path, []byte(tampered), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if out, code := env.run("verify", "-output", path); code != 1 || !strings.Contains(out, "Error: "+path+": digest mismatch") {
+			t.Errorf("tampered %s: exit %d\n%s", name, code, out)
+		}
+	}
+
+	delete(env.Config, "retention_class")
+	delete(env.Config, "document_owner")
+	plain := filepath.Join(env.Work, "plain.txt")
+	env.mustRun("-repo", repo.Dir, "-commit", hashes[1], "-output", plain)
+	if content := readFile(t, plain); strings.Contains(content, "Retention range:") || strings.Contains(content, "gitaudit-retention") {
+		t.Errorf("a report without retention_class has a trailer:\n%s", content)
+	}
+	if out, code := env.run("verify", "-output", plain); code != 1 || !strings.Contains(out, "no retention trailer found") {
+		t.Errorf("verify without a trailer: exit %d\n%s", code, out)
+	}
+	if out, code := env.run("verify", plain); code != 1 || !strings.Contains(out, "name the report with -output") {
+		t.Errorf("verify with an argument: exit %d\n%s", code, out)
+	}
+
+	env.Config["document_owner"] = "Internal Audit"
+	if out, code := env.run("-repo", repo.Dir, "-commit", hashes[1], "-output", plain, "-force"); code == 0 || !strings.Contains(out, "document_owner needs retention_class") {
+		t.Errorf("owner without class: exit %d\n%s", code, out)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Cool-downs between a failed attempt and the next retry of the same commit. A commit that
+// timed out is likely to time out again while the model is busy, so it waits longer than one
+// that failed fast. The cool-down doubles with each failed retry, up to retryCooldownCap, so
+// that a server that is down is not hammered.
+const (
+	retryCooldown        = 2 * time.Second
+	timeoutRetryCooldown = 30 * time.Second
+	retryCooldownCap     = 5 * time.Minute
+)
+
+// defaultMaxRetries is the number of retries of a commit, with each model, before it is
+// given up (-max-retries).
+const defaultMaxRetries = 3
+
+// defaultRetryModelPasses is the number of retry passes the configured model gets before
+// -retry-model takes over, when -retry-passes is not given.
+const defaultRetryModelPasses = 3
+
+// recordRetry notes when and how the last attempt failed, during pass (0 is the initial
+// pass), and schedules the commit's next attempt after its cool-down.
+func (s *commitState) recordRetry(err error, pass int, now time.Time) {
+	s.LastError = err
+	s.LastPass = pass
+	if pass > 0 {
+		s.Retries++
+	}
+	cooldown := retryCooldown
+	if isTimeout(err) {
+		cooldown = timeoutRetryCooldown
+	}
+	s.RetryAt = now.Add(min(cooldown<<min(s.Retries, 16), retryCooldownCap))
+}
+
+// exhausted reports whether the commit has used up its maxRetries retries; 0 allows any
+// number.
+func (s *commitState) exhausted(maxRetries int) bool {
+	return maxRetries > 0 && s.Retries >= maxRetries
+}
+
+// failedCommits lists the commits of hashes given up after -max-retries, in range order.
+func failedCommits(hashes, failed []string, states map[string]*commitState) []failedCommit {
+	given := make(map[string]bool, len(failed))
+	for _, hash := range failed {
+		given[hash] = true
+	}
+	var list []failedCommit
+	for _, hash := range hashes {
+		if given[hash] {
+			state := states[hash]
+			list = append(list, failedCommit{Hash: hash, Attempts: state.Failures, Error: state.LastError.Error()})
+		}
+	}
+	return list
+}
+
+// formatFailedSection renders the commits given up after -max-retries as a report section.
+func formatFailedSection(failed []failedCommit) string {
+	var sb strings.Builder
+	sb.WriteString("\n---\n\n" + msg("section.failed", msgCount("count.commits", len(failed))) + "\n\n")
+	for _, f := range failed {
+		sb.WriteString(msg("section.failed_line", f.Hash, msgCount("count.attempts", f.Attempts), f.Error) + "\n")
+	}
+	return sb.String()
+}
+
+// writeFailedSection appends the failed commits to each text report.
+func writeFailedSection(filenames []string, failed []failedCommit) {
+	section := formatFailedSection(failed)
+	for _, filename := range filenames {
+		file, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			fmt.Printf("Warning: failed to open file %s: %v\n", filename, err)
+			continue
+		}
+		_, err = file.WriteString(section)
+		file.Close()
+		if err != nil {
+			fmt.Printf("Warning: failed to write the failed commits to file %s: %v\n", filename, err)
+			continue
+		}
+		fmt.Printf("Appended the failed commits to %s\n", filename)
+	}
+}
+
+// planRetryPass orders the commits of retry pass number pass. Commits still cooling down are
+// deferred to a later pass. The others run in this order: those that did not fail in the
+// immediately preceding pass first, then those with fewer attempts, so one commit that keeps
+// failing does not hold up the rest of the queue every round. Ties keep the queue order.
+func planRetryPass(queue []string, states map[string]*commitState, pass int, now time.Time) (due, deferred []string) {
+	for _, hash := range queue {
+		if now.Before(states[hash].RetryAt) {
+			deferred = append(deferred, hash)
+		} else {
+			due = append(due, hash)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		a, b := states[due[i]], states[due[j]]
+		if failedLastA, failedLastB := a.LastPass == pass-1, b.LastPass == pass-1; failedLastA != failedLastB {
+			return failedLastB
+		}
+		return a.Failures < b.Failures
+	})
+	return due, deferred
+}
+
+// nextRetryAt returns the earliest time any commit of queue may be retried.
+func nextRetryAt(queue []string, states map[string]*commitState) time.Time {
+	var next time.Time
+	for _, hash := range queue {
+		if at := states[hash].RetryAt; next.IsZero() || at.Before(next) {
+			next = at
+		}
+	}
+	return next
+}
+
+// waitUntil sleeps until t, returning early if the run is stopped.
+func waitUntil(t time.Time) {
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-runCtx.Done():
+	}
+}
+
+// isTimeout reports whether err is a timeout, of a model request or of a git command.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "deadline exceeded") || strings.Contains(msg, "timed out") || strings.Contains(msg, "timeout")
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordRetryCooldown(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	fast := errors.New("server busy")
+	timeout := fmt.Errorf("failed to call Ollama: %w", context.DeadlineExceeded)
+
+	s := &commitState{}
+	s.recordRetry(fast, 0, start)
+	if s.Retries != 0 || s.LastPass != 0 || s.LastError != fast || !s.RetryAt.Equal(start.Add(retryCooldown)) {
+		t.Errorf("after the initial pass: %+v", s)
+	}
+	// Each failed retry doubles the cool-down.
+	for pass, want := range []time.Duration{2 * retryCooldown, 4 * retryCooldown, 8 * retryCooldown} {
+		s.recordRetry(fast, pass+1, start)
+		if s.Retries != pass+1 || s.LastPass != pass+1 || !s.RetryAt.Equal(start.Add(want)) {
+			t.Errorf("pass %d: retries %d, retry in %s, want %s", pass+1, s.Retries, s.RetryAt.Sub(start), want)
+		}
+	}
+
+	// A timeout waits longer than a fast failure, and no cool-down exceeds the cap.
+	s = &commitState{}
+	s.recordRetry(timeout, 0, start)
+	if !s.RetryAt.Equal(start.Add(timeoutRetryCooldown)) {
+		t.Errorf("timeout cool-down %s, want %s", s.RetryAt.Sub(start), timeoutRetryCooldown)
+	}
+	for pass := 1; pass < 40; pass++ {
+		s.recordRetry(timeout, pass, start)
+	}
+	if !s.RetryAt.Equal(start.Add(retryCooldownCap)) {
+		t.Errorf("cool-down after 39 retries %s, want the cap %s", s.RetryAt.Sub(start), retryCooldownCap)
+	}
+	if !s.exhausted(3) || s.exhausted(0) {
+		t.Error("exhausted does not follow -max-retries")
+	}
+}
+
+func TestPlanRetryPass(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	states := map[string]*commitState{
+		"a": {Failures: 3, LastPass: 2},
+		"b": {Failures: 1, LastPass: 0},
+		"c": {Failures: 2, LastPass: 1},
+		"d": {Failures: 1, LastPass: 2},
+		"e": {Failures: 1, LastPass: 1},
+		"f": {Failures: 1, LastPass: 2, RetryAt: now.Add(time.Second)},
+	}
+	due, deferred := planRetryPass([]string{"a", "b", "c", "d", "e", "f"}, states, 3, now)
+	// Those that did not fail in pass 2 first, fewer attempts first; ties keep the queue order.
+	if want := []string{"b", "e", "c", "d", "a"}; !reflect.DeepEqual(due, want) {
+		t.Errorf("due %v, want %v", due, want)
+	}
+	if !reflect.DeepEqual(deferred, []string{"f"}) {
+		t.Errorf("deferred %v, want the commit still cooling down", deferred)
+	}
+	if at := nextRetryAt([]string{"f"}, states); !at.Equal(now.Add(time.Second)) {
+		t.Errorf("nextRetryAt = %s", at)
+	}
+}
+
+// retrySimulation runs the retry loop of the range audit on a fake clock. attempt is the
+// scripted client: it returns how long an attempt takes and its error.
+type retrySimulation struct {
+	now      time.Time
+	states   map[string]*commitState
+	attempts map[string]int
+	// passes lists the commits attempted in each retry pass, in order.
+	passes [][]string
+	total  int
+}
+
+func (sim *retrySimulation) run(hashes []string, attempt func(hash string, n int) (time.Duration, error)) {
+	try := func(hash string, pass int) bool {
+		sim.attempts[hash]++
+		sim.total++
+		took, err := attempt(hash, sim.attempts[hash])
+		sim.now = sim.now.Add(took)
+		if err == nil {
+			return true
+		}
+		sim.states[hash].recordFailure(hash, err, nil, 0)
+		sim.states[hash].recordRetry(err, pass, sim.now)
+		return false
+	}
+	var queue []string
+	for _, hash := range hashes {
+		sim.states[hash] = &commitState{}
+		if !try(hash, 0) {
+			queue = append(queue, hash)
+		}
+	}
+	for pass := 1; len(queue) > 0; pass++ {
+		due, deferred := planRetryPass(queue, sim.states, pass, sim.now)
+		if len(due) == 0 {
+			sim.now = nextRetryAt(deferred, sim.states)
+			pass--
+			continue
+		}
+		sim.passes = append(sim.passes, due)
+		var next []string
+		for _, hash := range due {
+			if !try(hash, pass) {
+				next = append(next, hash)
+			}
+		}
+		queue = append(next, deferred...)
+	}
+}
+
+func TestRetryPassOrder(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	sim := &retrySimulation{now: start, states: make(map[string]*commitState), attempts: make(map[string]int)}
+	// A fails fast five times; B fails fast once; C times out once, after 10s; D and E succeed.
+	// Every other attempt takes a second.
+	sim.run([]string{"A", "B", "C", "D", "E"}, func(hash string, n int) (time.Duration, error) {
+		switch {
+		case hash == "A" && n <= 5:
+			return time.Second, errors.New("server busy")
+		case hash == "B" && n == 1:
+			return time.Second, errors.New("server busy")
+		case hash == "C" && n == 1:
+			return 10 * time.Second, fmt.Errorf("failed to call Ollama: %w", context.DeadlineExceeded)
+		}
+		return time.Second, nil
+	})
+
+	// A and B fail in pass 0 and are due together, in queue order. C's 30s cool-down, from
+	// 0:12, keeps it out of the passes A keeps failing until 0:42.
+	want := [][]string{{"A", "B"}, {"A"}, {"A"}, {"C"}, {"A"}, {"A"}}
+	if !reflect.DeepEqual(sim.passes, want) {
+		t.Errorf("retry passes %v, want %v", sim.passes, want)
+	}
+	if sim.total != 12 || sim.attempts["A"] != 6 {
+		t.Errorf("%d attempts, %d of A; want 12 and 6", sim.total, sim.attempts["A"])
+	}
+	// A's cool-downs: 2s after pass 0, then 4s, 8s, 16s and 32s after each failed retry.
+	if elapsed := sim.now.Sub(start); elapsed != 79*time.Second {
+		t.Errorf("the run took %s, want 1m19s", elapsed)
+	}
+}
+
+func TestRetryPassFailingHeadGoesLast(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	sim := &retrySimulation{now: start, states: make(map[string]*commitState), attempts: make(map[string]int)}
+	// Attempts take no time. A, at the head of the queue, fails fast five times; B times out
+	// once, so its 30s cool-down ends with A's fourth, 2+4+8+16s after the start.
+	sim.run([]string{"A", "B", "C"}, func(hash string, n int) (time.Duration, error) {
+		switch {
+		case hash == "A" && n <= 5, hash == "C" && n == 1:
+			return 0, errors.New("server busy")
+		case hash == "B" && n == 1:
+			return 0, fmt.Errorf("failed to call Ollama: %w", context.DeadlineExceeded)
+		}
+		return 0, nil
+	})
+	// When B comes due, A failed in the preceding pass and goes behind it.
+	want := [][]string{{"A", "C"}, {"A"}, {"A"}, {"B", "A"}, {"A"}}
+	if !reflect.DeepEqual(sim.passes, want) {
+		t.Errorf("retry passes %v, want %v", sim.passes, want)
+	}
+	if sim.total != 10 || sim.now.Sub(start) != 62*time.Second {
+		t.Errorf("%d attempts in %s, want 10 in 1m2s", sim.total, sim.now.Sub(start))
+	}
+}
+
+func TestFailedSection(t *testing.T) {
+	states := map[string]*commitState{
+		"aaa": {Failures: 4, LastError: errors.New("server busy")},
+		"bbb": {Failures: 2, LastError: errors.New("timed out")},
+	}
+	failed := failedCommits([]string{"bbb", "ccc", "aaa"}, []string{"aaa", "bbb"}, states)
+	if want := []failedCommit{{Hash: "bbb", Attempts: 2, Error: "timed out"}, {Hash: "aaa", Attempts: 4, Error: "server busy"}}; !reflect.DeepEqual(failed, want) {
+		t.Errorf("failedCommits = %+v, want range order", failed)
+	}
+	section := formatFailedSection(failed)
+	if !strings.Contains(section, "bbb: 2 attempts, last error: timed out") || strings.Index(section, "bbb") > strings.Index(section, "aaa") {
+		t.Errorf("section:\n%s", section)
+	}
+}
+
+func TestHandOff(t *testing.T) {
+	ladder := []string{detailReducedContext, detailStatsOnly}
+	s := &commitState{Failures: 4, LengthFailures: 1, Retries: 3, RetryAt: time.Now().Add(time.Minute)}
+	s.handOff(ladder)
+	// The retry model starts one step down, at once, with its own -max-retries.
+	if s.Level != 1 || s.detailLevel(ladder) != detailReducedContext || s.Retries != 0 || s.LengthFailures != 0 || !s.RetryAt.IsZero() || s.Failures != 4 {
+		t.Errorf("after the hand-over: %+v", s)
+	}
+	// A commit already degraded keeps its step, and without a ladder nothing is degraded.
+	s = &commitState{Level: 2}
+	if s.handOff(ladder); s.Level != 2 {
+		t.Errorf("a degraded commit moved to level %d", s.Level)
+	}
+	s = &commitState{}
+	if s.handOff(nil); s.detailLevel(nil) != detailFull {
+		t.Errorf("without a ladder: %+v", s)
+	}
+}
+
+// retryModelRequests returns the models of the generate requests whose prompt contains marker,
+// in the order the fake server received them.
+func retryModelRequests(f *fakeOllama, marker string) []string {
+	var models []string
+	for i, prompt := range f.Prompts() {
+		if strings.Contains(prompt, marker) {
+			models = append(models, f.Models()[i])
+		}
+	}
+	return models
+}
+
+// TestRetryModelHandOff scripts two models behind one fake server: the configured model keeps
+// failing on one commit, and the retry model takes it over once -retry-passes ran out.
+func TestRetryModelHandOff(t *testing.T) {
+	repo := newFixtureRepo(t)
+	hashes := repo.commits(3)
+	stubborn := hashes[1]
+	env := newAuditEnv(t)
+	retryOK := true
+	env.Ollama.Respond = func(n int, prompt string) (int, string) {
+		model := env.Ollama.Models()[n-1]
+		if strings.Contains(prompt, "Change 1") && (model == "tiny:0.5b" || !retryOK) {
+			return 503, `{"error":"server busy"}`
+		}
+		return 0, ""
+	}
+
+	report := filepath.Join(env.Work, "report.txt")
+	out, code := env.run("-repo", repo.Dir, "-commit", "root", "-output", report, "-retry-model", "small:0.1b", "-retry-passes", "1", "-fail-on", "degraded_retries > 0")
+	if code != exitFailOn {
+		t.Fatalf("exit %d, want %d:\n%s", code, exitFailOn, out)
+	}
+	// The configured model gets the initial attempt and one retry pass; then the retry model
+	// takes the commit, after the configured model's last request.
+	if got := retryModelRequests(env.Ollama, "Change 1"); strings.Join(got, " ") != "tiny:0.5b tiny:0.5b small:0.1b" {
+		t.Errorf("requests for the stubborn commit: %q", got)
+	}
+	models := env.Ollama.Models()
+	if strings.Count(strings.Join(models, " "), "small:0.1b") != 1 || models[len(models)-1] != "small:0.1b" {
+		t.Errorf("requests: %q", models)
+	}
+	for _, want := range []string{
+		"Retry Model: small:0.1b\n",
+		"--- Handing 1 commits over to the retry model small:0.1b ---\n",
+		"Successfully processed commit " + stubborn + " on retry",
+		"1 entries were produced by the retry model small:0.1b after the configured model kept failing.\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+	content := readFile(t, report)
+	note := "Degraded retry: summarized by the retry model small:0.1b, with a shorter prompt, after the configured model kept failing\n"
+	entry := content[strings.Index(content, "Commit: "+stubborn):]
+	if strings.Count(content, note) != 1 || !strings.Contains(entry[:strings.Index(entry, "\n---\n")], note) {
+		t.Errorf("report:\n%s", content)
+	}
+
+	// A retry model that fails too gets its own -max-retries, then the commit is given up.
+	retryOK = false
+	out, code = env.run("-repo", repo.Dir, "-commit", "root", "-output", report, "-force", "-retry-model", "small:0.1b", "-retry-passes", "1", "-max-retries", "1")
+	if code != 1 || !strings.Contains(out, "Error processing commit "+stubborn+" during retry") || !strings.Contains(out, "Giving up after 1 retries (-max-retries).") {
+		t.Errorf("failing retry model: exit %d\n%s", code, out)
+	}
+	if got := retryModelRequests(env.Ollama, "Change 1"); strings.Join(got[3:], " ") != "tiny:0.5b tiny:0.5b small:0.1b" {
+		t.Errorf("requests for the stubborn commit: %q", got[3:])
+	}
+
+	// Without -retry-model, -retry-passes stops the run with the commit pending.
+	out, code = env.run("-repo", repo.Dir, "-commit", "root", "-output", report, "-force", "-retry-passes", "1")
+	if code != 1 || !strings.Contains(out, "1 commits still failed after 1 retry passes (-retry-passes)") {
+		t.Errorf("-retry-passes alone: exit %d\n%s", code, out)
+	}
+	if got := retryModelRequests(env.Ollama, "Change 1"); strings.Join(got[6:], " ") != "tiny:0.5b tiny:0.5b" {
+		t.Errorf("requests for the stubborn commit: %q", got[6:])
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxSuggestedReviewers is how many historical authors -suggest-reviewers names per commit.
+const maxSuggestedReviewers = 3
+
+// reviewerPolicy bounds the blame -suggest-reviewers runs for each commit.
+type reviewerPolicy struct {
+	MaxFiles int
+	MaxLines int
+}
+
+// blameRange is a range of pre-image lines of one file, as "-L start,end" takes it.
+type blameRange struct {
+	Start int
+	End   int
+}
+
+// suggestReviewers blames the pre-image lines a commit changes, compared with base, and
+// returns the authors who wrote most of them, as "Name <email>" after .mailmap, excluding
+// the commit's own author. Files the commit adds, binary files and files that cannot be
+// blamed yield no suggestions.
+func (p *reviewerPolicy) suggestReviewers(ctx context.Context, repoPath, commitHash, base string) ([]string, error) {
+	if p == nil || base == "" {
+		return nil, nil
+	}
+	files, err := getChangedRanges(ctx, repoPath, base, commitHash)
+	if err != nil {
+		return nil, err
+	}
+	self, err := gitRun(ctx, repoPath, "show", "--no-patch", "--format=%aE", commitHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the author of commit %s: %w", commitHash, err)
+	}
+	selfEmail := foldKey(string(self))
+
+	lines := make(map[string]int)
+	names := make(map[string]string)
+	budget := p.MaxLines
+	for i, file := range files {
+		if i == p.MaxFiles || budget <= 0 {
+			break
+		}
+		args := []string{"blame", "--line-porcelain"}
+		for _, r := range file.Ranges {
+			if budget <= 0 {
+				break
+			}
+			end := min(r.End, r.Start+budget-1)
+			budget -= end - r.Start + 1
+			args = append(args, "-L", fmt.Sprintf("%d,%d", r.Start, end))
+		}
+		args = append(args, base, "--", file.Path)
+		output, err := gitRun(ctx, repoPath, args...)
+		if err != nil {
+			debugf("commit %s: no blame for %s: %v", commitHash, file.Path, err)
+			continue
+		}
+		var name string
+		for _, line := range strings.Split(string(output), "\n") {
+			if value, ok := strings.CutPrefix(line, "author "); ok {
+				name = value
+			} else if value, ok := strings.CutPrefix(line, "author-mail "); ok {
+				email := foldKey(strings.Trim(value, "<>"))
+				if email == selfEmail {
+					continue
+				}
+				lines[email]++
+				if names[email] == "" {
+					names[email] = name
+				}
+			}
+		}
+	}
+
+	emails := make([]string, 0, len(lines))
+	for email := range lines {
+		emails = append(emails, email)
+	}
+	sort.Slice(emails, func(i, j int) bool {
+		if lines[emails[i]] != lines[emails[j]] {
+			return lines[emails[i]] > lines[emails[j]]
+		}
+		return emails[i] < emails[j]
+	})
+	var reviewers []string
+	for _, email := range emails[:min(len(emails), maxSuggestedReviewers)] {
+		reviewers = append(reviewers, fmt.Sprintf("%s <%s>", names[email], email))
+	}
+	return reviewers, nil
+}
+
+// changedFile is a file a commit modifies, by its pre-image path, with the pre-image line
+// ranges its hunks replace.
+type changedFile struct {
+	Path   string
+	Ranges []blameRange
+}
+
+// getChangedRanges lists the pre-image line ranges of the hunks between base and commitHash.
+// A hunk that only inserts lines yields the line above it, whose owner knows the spot best.
+// Added and binary files have no pre-image lines and are left out.
+func getChangedRanges(ctx context.Context, repoPath, base, commitHash string) ([]changedFile, error) {
+	output, err := gitRun(ctx, repoPath, "diff", "--no-color", "--no-ext-diff", "-M", "-U0", base, commitHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute git diff for reviewer suggestions: %w", err)
+	}
+	var files []changedFile
+	current := -1
+	header := false // Between "diff --git" and the first hunk, where "---" names the file.
+	for _, line := range strings.Split(string(output), "\n") {
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			current, header = -1, true
+		case header && strings.HasPrefix(line, "--- a/"):
+			// git appends a tab to the name of a path that contains a space.
+			files = append(files, changedFile{Path: strings.TrimSuffix(strings.TrimPrefix(line, "--- a/"), "\t")})
+			current = len(files) - 1
+		case strings.HasPrefix(line, "@@ -") && current >= 0:
+			header = false
+			old, _, _ := strings.Cut(strings.TrimPrefix(line, "@@ -"), " ")
+			startText, countText, hasCount := strings.Cut(old, ",")
+			start, err := strconv.Atoi(startText)
+			if err != nil {
+				continue
+			}
+			count := 1
+			if hasCount {
+				count, _ = strconv.Atoi(countText)
+			}
+			if count == 0 {
+				// An insertion after line start; start is 0 at the top of the file.
+				start, count = max(start, 1), 1
+			}
+			files[current].Ranges = append(files[current].Ranges, blameRange{Start: start, End: start + count - 1})
+		}
+	}
+	var kept []changedFile
+	for _, file := range files {
+		if len(file.Ranges) > 0 {
+			kept = append(kept, file)
+		}
+	}
+	return kept, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// author returns the environment that makes name <email> the author of a fixture commit.
+func author(name, email string) []string {
+	return []string{"GIT_AUTHOR_NAME=" + name, "GIT_AUTHOR_EMAIL=" + email}
+}
+
+// newLayeredFixture builds a file written by Ada, partly rewritten by Grace (once under an
+// old email that .mailmap canonicalizes) and by Linus, then changed throughout by Ada again.
+// It returns the repository and the hashes of the commits by name.
+func newLayeredFixture(t *testing.T) (*fixtureRepo, map[string]string) {
+	t.Helper()
+	repo := newFixtureRepo(t)
+	lines := []string{"one", "two", "three", "four", "five", "six"}
+	file := func() string { return strings.Join(lines, "\n") + "\n" }
+	hashes := make(map[string]string)
+	hashes["ada"] = repo.commitEnv(author("Ada Lovelace", "ada@example.com"), "Add the list", map[string]string{
+		"list.txt": file(),
+		".mailmap": "Grace Hopper <grace@example.com> <grace@old.example>\n",
+	})
+	lines[2], lines[3] = "THREE", "FOUR"
+	hashes["grace"] = repo.commitEnv(author("Grace Hopper", "grace@example.com"), "Shout", map[string]string{"list.txt": file()})
+	lines[4] = "FIVE"
+	hashes["grace-old"] = repo.commitEnv(author("G. Hopper", "grace@old.example"), "Shout more", map[string]string{"list.txt": file()})
+	lines[5] = "SIX"
+	hashes["linus"] = repo.commitEnv(author("Linus", "linus@example.com"), "Shout last", map[string]string{"list.txt": file()})
+	for i := range lines {
+		lines[i] = "line " + lines[i]
+	}
+	hashes["rewrite"] = repo.commitEnv(author("Ada Lovelace", "ada@example.com"), "Prefix every line", map[string]string{
+		"list.txt": file(),
+		"new.txt":  "brand new\n",
+		"logo.bin": "\x00\x01binary\x00",
+	})
+	return repo, hashes
+}
+
+func TestGetChangedRanges(t *testing.T) {
+	repo := newFixtureRepo(t)
+	base := repo.commit("Initial", map[string]string{
+		"a.txt":   "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+		"b.txt":   "x\ny\n",
+		"old.txt": "keep\nthis\nfile\n",
+		"bin.dat": "\x00\x01\x02",
+	})
+	repo.write(map[string]string{
+		// Lines 2 and 3 replaced, a line inserted after 6, line 10 deleted.
+		"a.txt": "1\nTWO\nTHREE\n4\n5\n6\nsix and a half\n7\n8\n9\n",
+		// A line inserted at the top.
+		"b.txt":   "top\nx\ny\n",
+		"bin.dat": "\x00\x01\x03",
+		"add.txt": "added\n",
+		"old.txt": "",
+		"new.txt": "keep\nthis\nfile!\n",
+	})
+	head := repo.commit("Change everything", nil)
+
+	files, err := getChangedRanges(context.Background(), repo.Dir, base, head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []changedFile{
+		{Path: "a.txt", Ranges: []blameRange{{2, 3}, {6, 6}, {10, 10}}},
+		{Path: "b.txt", Ranges: []blameRange{{1, 1}}},
+		// The renamed file is blamed under its old path.
+		{Path: "old.txt", Ranges: []blameRange{{3, 3}}},
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("getChangedRanges = %+v, want %+v", files, want)
+	}
+}
+
+func TestSuggestReviewers(t *testing.T) {
+	repo, hashes := newLayeredFixture(t)
+	rewrite := hashes["rewrite"]
+	policy := &reviewerPolicy{MaxFiles: 10, MaxLines: 400}
+	reviewers, err := policy.suggestReviewers(context.Background(), repo.Dir, rewrite, rewrite+"^")
+	// Grace wrote three lines under two emails; Ada's own two lines do not count.
+	want := []string{"Grace Hopper <grace@example.com>", "Linus <linus@example.com>"}
+	if err != nil || !reflect.DeepEqual(reviewers, want) {
+		t.Errorf("suggestReviewers = %q, %v; want %q", reviewers, err, want)
+	}
+
+	// Ada's two lines use up the budget.
+	limited := &reviewerPolicy{MaxFiles: 10, MaxLines: 2}
+	if reviewers, err := limited.suggestReviewers(context.Background(), repo.Dir, rewrite, rewrite+"^"); err != nil || reviewers != nil {
+		t.Errorf("within 2 lines: %q, %v", reviewers, err)
+	}
+	limited.MaxLines = 4
+	if reviewers, err := limited.suggestReviewers(context.Background(), repo.Dir, rewrite, rewrite+"^"); err != nil || !reflect.DeepEqual(reviewers, want[:1]) {
+		t.Errorf("within 4 lines: %q, %v", reviewers, err)
+	}
+
+	// Linus's commit changes a line that Ada wrote.
+	if reviewers, err := policy.suggestReviewers(context.Background(), repo.Dir, hashes["linus"], hashes["linus"]+"^"); err != nil || !reflect.DeepEqual(reviewers, []string{"Ada Lovelace <ada@example.com>"}) {
+		t.Errorf("linus: %q, %v", reviewers, err)
+	}
+	// A root commit, or no policy, suggests nobody.
+	if reviewers, err := policy.suggestReviewers(context.Background(), repo.Dir, hashes["ada"], ""); err != nil || reviewers != nil {
+		t.Errorf("root commit: %q, %v", reviewers, err)
+	}
+	var off *reviewerPolicy
+	if reviewers, err := off.suggestReviewers(context.Background(), repo.Dir, rewrite, rewrite+"^"); err != nil || reviewers != nil {
+		t.Errorf("off: %q, %v", reviewers, err)
+	}
+}
+
+func TestSuggestReviewersRun(t *testing.T) {
+	repo, hashes := newLayeredFixture(t)
+	env := newAuditEnv(t)
+	report := filepath.Join(env.Work, "report.json")
+	env.mustRun("-repo", repo.Dir, "-commit", "root", "-suggest-reviewers", "-format", "json", "-output", report)
+	var document jsonReport
+	if err := json.Unmarshal([]byte(readFile(t, report)), &document); err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string][]string)
+	for _, entry := range document.Commits {
+		got[entry.Hash] = entry.SuggestedReviewers
+	}
+	want := map[string][]string{
+		hashes["ada"]:       nil,
+		hashes["grace"]:     {"Ada Lovelace <ada@example.com>"},
+		hashes["grace-old"]: {"Ada Lovelace <ada@example.com>"},
+		hashes["linus"]:     {"Ada Lovelace <ada@example.com>"},
+		hashes["rewrite"]:   {"Grace Hopper <grace@example.com>", "Linus <linus@example.com>"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("suggested reviewers %q, want %q", got, want)
+	}
+
+	text := filepath.Join(env.Work, "report.txt")
+	env.mustRun("-repo", repo.Dir, "-commit", "root", "-suggest-reviewers", "-output", text)
+	if content := readFile(t, text); !strings.Contains(content, "Suggested reviewers: Grace Hopper <grace@example.com>, Linus <linus@example.com>\n") {
+		t.Errorf("text report:\n%s", content)
+	}
+	env.mustRun("-repo", repo.Dir, "-commit", "root", "-output", text, "-force")
+	if content := readFile(t, text); strings.Contains(content, "Suggested reviewers") {
+		t.Errorf("suggestions without -suggest-reviewers:\n%s", content)
+	}
+	if out, code := env.run("-repo", repo.Dir, "-commit", "root", "-suggest-reviewers", "-blame-max-files", "0", "-output", text, "-force"); code == 0 || !strings.Contains(out, "-blame-max-files and -blame-max-lines must be positive") {
+		t.Errorf("-blame-max-files 0: exit %d\n%s", code, out)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// rootRevision is the special -commit value naming the repository's first commit.
+const rootRevision = "root"
+
+// abbreviatedHash matches what git accepts as an abbreviated object name.
+var abbreviatedHash = regexp.MustCompile(`^[0-9a-fA-F]{4,39}$`)
+
+// boundaryCommit is the resolved -commit value, echoed so the user can see which commit a
+// symbolic value such as HEAD~50 or main@{2.weeks.ago} actually named.
+type boundaryCommit struct {
+	Hash    string
+	Subject string
+	Date    string
+}
+
+func (b boundaryCommit) String() string {
+	return fmt.Sprintf("%s '%s' (%s)", shortHash(b.Hash), b.Subject, b.Date)
+}
+
+// resolveBoundary resolves the -commit value to the oldest commit to audit. Besides anything
+// git rev-parse accepts, "root" names the first commit of tip's history. HEAD, HEAD~n and
+// HEAD^n are resolved against tip, the HEAD pinned at startup.
+func resolveBoundary(repoPath, tip, spec string) (boundaryCommit, error) {
+	var hash string
+	var err error
+	if spec == rootRevision {
+		hash, err = getRootCommit(repoPath, tip)
+	} else {
+		if spec == "HEAD" || strings.HasPrefix(spec, "HEAD~") || strings.HasPrefix(spec, "HEAD^") {
+			spec = tip + strings.TrimPrefix(spec, "HEAD")
+		}
+		hash, err = resolveCommit(repoPath, spec)
+	}
+	if err != nil {
+		return boundaryCommit{}, err
+	}
+
+	output, err := gitRun(context.Background(), repoPath, "log", "-1", "--format=%s%x00%ad", "--date=short", hash)
+	if err != nil {
+		return boundaryCommit{}, fmt.Errorf("failed to execute git log for commit %s: %w", hash, err)
+	}
+	subject, date, _ := strings.Cut(strings.TrimSpace(string(output)), "\x00")
+	return boundaryCommit{Hash: hash, Subject: subject, Date: date}, nil
+}
+
+// getRootCommit returns the first commit of tip's history. When the history has several
+// roots (e.g. from merging an unrelated project), it is the one rev-list reaches last, so
+// that auditing down to it covers every commit.
+func getRootCommit(repoPath, tip string) (string, error) {
+	output, err := gitRun(context.Background(), repoPath, "rev-list", "--max-parents=0", tip)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute git rev-list --max-parents=0 %s: %w", tip, err)
+	}
+	roots := strings.Fields(string(output))
+	if len(roots) == 0 {
+		return "", fmt.Errorf("%s has no root commit", tip)
+	}
+	return roots[len(roots)-1], nil
+}
+
+// resolveCommit resolves a commit-ish such as a branch, tag or abbreviated hash to a full hash.
+// An ambiguous abbreviation is reported with the list of candidate commits.
+func resolveCommit(repoPath, commitish string) (string, error) {
+	output, err := gitRun(context.Background(), repoPath, "rev-parse", "--verify", "--end-of-options", commitish+"^{commit}")
+	if err != nil {
+		if abbreviatedHash.MatchString(commitish) {
+			if candidates := getAbbreviationCandidates(repoPath, commitish); len(candidates) > 1 {
+				return "", fmt.Errorf("commit %s is ambiguous; use a longer prefix of one of:\n  %s", commitish, strings.Join(candidates, "\n  "))
+			}
+		}
+		return "", fmt.Errorf("failed to resolve commit %s: %w", commitish, err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// getAbbreviationCandidates describes every object whose name starts with prefix: commits as
+// "<hash> (<date>) <subject>", other objects as "<hash> (<type>)". It returns nil on failure,
+// leaving the caller with git's own error.
+func getAbbreviationCandidates(repoPath, prefix string) []string {
+	output, err := gitRun(context.Background(), repoPath, "rev-parse", "--disambiguate="+prefix)
+	if err != nil {
+		return nil
+	}
+	types, err := gitRunInput(context.Background(), repoPath, bytes.NewReader(output), "cat-file", "--batch-check=%(objectname) %(objecttype)")
+	if err != nil {
+		return nil
+	}
+
+	var candidates []string
+	for _, line := range strings.Split(strings.TrimSpace(string(types)), "\n") {
+		hash, objectType, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		if objectType != "commit" {
+			candidates = append(candidates, fmt.Sprintf("%s (%s)", hash, objectType))
+			continue
+		}
+		boundary, err := resolveBoundary(repoPath, "HEAD", hash)
+		if err != nil {
+			candidates = append(candidates, fmt.Sprintf("%s (commit)", hash))
+			continue
+		}
+		candidates = append(candidates, fmt.Sprintf("%s (%s) %s", hash, boundary.Date, boundary.Subject))
+	}
+	return candidates
+}
+
+// tipMovement describes how HEAD moved away from the tip pinned at startup: Added commits
+// are reachable from the new HEAD but not the tip, Dropped commits the reverse (after a
+// rewrite or a reset).
+type tipMovement struct {
+	Head    string
+	Added   int
+	Dropped int
+}
+
+// getTipMovement compares HEAD with tip. It returns nil when HEAD still points at tip.
+func getTipMovement(repoPath, tip string) (*tipMovement, error) {
+	head, err := resolveCommit(repoPath, "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	if head == tip {
+		return nil, nil
+	}
+	output, err := gitRun(context.Background(), repoPath, "rev-list", "--left-right", "--count", tip+"..."+head)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count the commits between %s and HEAD: %w", shortHash(tip), err)
+	}
+	m := &tipMovement{Head: head}
+	if _, err := fmt.Sscan(string(output), &m.Dropped, &m.Added); err != nil {
+		return nil, fmt.Errorf("failed to parse git rev-list --count output %q: %w", strings.TrimSpace(string(output)), err)
+	}
+	return m, nil
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResolveBoundaryRelative(t *testing.T) {
+	repo := newFixtureRepo(t)
+	hashes := repo.commits(6)
+	repo.git("tag", "-a", "v1.0", "-m", "First release", hashes[2])
+	tip := hashes[5]
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"HEAD", hashes[5]},
+		{"HEAD~3", hashes[2]},
+		{"HEAD^", hashes[4]},
+		{"HEAD^^", hashes[3]},
+		{"main~5", hashes[0]},
+		{"v1.0", hashes[2]},
+		{hashes[1][:10], hashes[1]},
+		{"root", hashes[0]},
+	}
+	for _, tt := range tests {
+		got, err := resolveBoundary(repo.Dir, tip, tt.spec)
+		if err != nil || got.Hash != tt.want {
+			t.Errorf("resolveBoundary(%q) = %s, %v; want %s", tt.spec, got.Hash, err, tt.want)
+		}
+	}
+
+	// HEAD~N counts from the tip pinned at startup, not from where HEAD is now.
+	later := repo.commits(2)
+	if got, err := resolveBoundary(repo.Dir, tip, "HEAD~1"); err != nil || got.Hash != hashes[4] {
+		t.Errorf("HEAD~1 with HEAD moved to %s = %s, %v; want %s", later[1], got.Hash, err, hashes[4])
+	}
+
+	got, err := resolveBoundary(repo.Dir, tip, "HEAD~3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := shortHash(hashes[2]) + " 'Change 2' (2024-01-01)"; got.String() != want {
+		t.Errorf("boundary = %q, want %q", got, want)
+	}
+	for _, spec := range []string{"HEAD~40", "no-such-branch", "main@{99}"} {
+		if _, err := resolveBoundary(repo.Dir, tip, spec); err == nil {
+			t.Errorf("resolveBoundary(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestResolveBoundaryReflog(t *testing.T) {
+	repo := newFixtureRepo(t)
+	hashes := repo.commits(4)
+	// Rewinding main leaves the dropped commits in its reflog only.
+	repo.git("reset", "-q", "--hard", hashes[1])
+	extra := repo.commit("Replacement", map[string]string{"other.go": "package other\n"})
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"main@{0}", extra},
+		{"main@{1}", hashes[1]},
+		{"main@{2}", hashes[3]},
+		{"main@{3}", hashes[2]},
+		{"@{1}", hashes[1]},
+		{"HEAD@{2}", hashes[3]},
+	}
+	for _, tt := range tests {
+		got, err := resolveBoundary(repo.Dir, extra, tt.spec)
+		if err != nil || got.Hash != tt.want {
+			t.Errorf("resolveBoundary(%q) = %s, %v; want %s", tt.spec, got.Hash, err, tt.want)
+		}
+	}
+}
+
+func TestResolveBoundaryDate(t *testing.T) {
+	repo := newFixtureRepo(t)
+	// The reflog records the committer dates, so the commits are spread over the last month.
+	now := time.Now().UTC().Truncate(time.Second)
+	var hashes []string
+	for _, daysAgo := range []int{30, 20, 10, 1} {
+		repo.When = now.AddDate(0, 0, -daysAgo)
+		hashes = append(hashes, repo.commit(fmt.Sprintf("%d days ago", daysAgo), map[string]string{"file.txt": fmt.Sprint(daysAgo)}))
+	}
+	tip := hashes[3]
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"@{2.weeks.ago}", hashes[1]},
+		{"main@{2.weeks.ago}", hashes[1]},
+		{"main@{3.weeks.ago}", hashes[0]},
+		{"main@{5.days.ago}", hashes[2]},
+		{"main@{" + now.AddDate(0, 0, -15).Format("2006-01-02 15:04:05") + "}", hashes[1]},
+	}
+	for _, tt := range tests {
+		got, err := resolveBoundary(repo.Dir, tip, tt.spec)
+		if err != nil || got.Hash != tt.want {
+			t.Errorf("resolveBoundary(%q) = %s, %v; want %s", tt.spec, got.Hash, err, tt.want)
+		}
+	}
+	got, _ := resolveBoundary(repo.Dir, tip, "main@{2.weeks.ago}")
+	if want := now.AddDate(0, 0, -20).Format("2006-01-02"); got.Date != want || got.Subject != "20 days ago" {
+		t.Errorf("boundary = %+v, want subject \"20 days ago\" dated %s", got, want)
+	}
+}
+
+func TestResolveBoundarySeveralRoots(t *testing.T) {
+	repo := newFixtureRepo(t)
+	mainRoot := repo.commit("Main root", map[string]string{"main.txt": "main\n"})
+	repo.commits(2)
+	// A project with a history of its own is merged in; its root is younger than main's.
+	repo.git("checkout", "-q", "--orphan", "imported")
+	repo.git("rm", "-q", "-rf", ".")
+	importedRoot := repo.commit("Imported root", map[string]string{"imported.txt": "imported\n"})
+	repo.commit("Imported change", map[string]string{"imported.txt": "imported\nmore\n"})
+	repo.git("checkout", "-q", "main")
+	repo.git("merge", "-q", "--allow-unrelated-histories", "-m", "Merge the imported project", "imported")
+	repo.When = repo.When.Add(time.Hour)
+	tip := repo.git("rev-parse", "HEAD")
+
+	roots := strings.Fields(repo.git("rev-list", "--max-parents=0", tip))
+	if len(roots) != 2 {
+		t.Fatalf("fixture has roots %v, want 2", roots)
+	}
+	got, err := resolveBoundary(repo.Dir, tip, "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Hash != mainRoot || got.Hash == importedRoot {
+		t.Errorf("root = %s (%s), want main's root %s", got.Hash, got.Subject, mainRoot)
+	}
+
+	// Auditing down to root covers the commits of both histories.
+	hashes, err := getCommitHashes(context.Background(), repo.Dir, tip, got.Hash, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if all := repo.git("rev-list", "--count", tip); fmt.Sprint(len(hashes)) != all {
+		t.Errorf("range down to root has %d commits, want all %s", len(hashes), all)
+	}
+}
+
+// writeCommitObject writes a commit object with the given tree and message and fixed
+// identities, and returns its hash.
+func writeCommitObject(t *testing.T, repo *fixtureRepo, tree, message string) string {
+	t.Helper()
+	content := commitObject(tree, message)
+	cmd := exec.Command("git", "hash-object", "-t", "commit", "-w", "--stdin")
+	cmd.Dir = repo.Dir
+	cmd.Stdin = strings.NewReader(content)
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("git hash-object: %v", err)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// commitObject is the content of a parentless commit object.
+func commitObject(tree, message string) string {
+	const ident = "Fixture Author <author@example.com> 1704110400 +0000"
+	return fmt.Sprintf("tree %s\nauthor %s\ncommitter %s\n\n%s\n", tree, ident, ident, message)
+}
+
+func TestResolveCommitAmbiguous(t *testing.T) {
+	repo := newFixtureRepo(t)
+	repo.commits(1)
+	tree := repo.git("rev-parse", "HEAD^{tree}")
+
+	// Find two commit messages whose commits share a 4-character prefix.
+	seen := make(map[string]string)
+	var prefix, first, second string
+	for i := 0; prefix == ""; i++ {
+		message := fmt.Sprintf("Candidate %d", i)
+		content := commitObject(tree, message)
+		sum := sha1.Sum([]byte(fmt.Sprintf("commit %d\x00%s", len(content), content)))
+		p := hex.EncodeToString(sum[:])[:4]
+		if other, ok := seen[p]; ok {
+			prefix, first, second = p, other, message
+		}
+		seen[p] = message
+	}
+	hashes := []string{writeCommitObject(t, repo, tree, first), writeCommitObject(t, repo, tree, second)}
+	if !strings.HasPrefix(hashes[0], prefix) || !strings.HasPrefix(hashes[1], prefix) {
+		t.Fatalf("commits %v do not share the prefix %s", hashes, prefix)
+	}
+
+	_, err := resolveCommit(repo.Dir, prefix)
+	if err == nil {
+		t.Fatalf("ambiguous prefix %s resolved", prefix)
+	}
+	if !strings.Contains(err.Error(), "commit "+prefix+" is ambiguous") {
+		t.Errorf("error is not an ambiguity report: %v", err)
+	}
+	for i, message := range []string{first, second} {
+		if want := hashes[i] + " (2024-01-01) " + message; !strings.Contains(err.Error(), want) {
+			t.Errorf("error lacks candidate %q:\n%v", want, err)
+		}
+	}
+	candidates := getAbbreviationCandidates(repo.Dir, prefix)
+	if len(candidates) < 2 {
+		t.Errorf("candidates = %v", candidates)
+	}
+
+	// A longer prefix picks one of them.
+	for _, hash := range hashes {
+		if got, err := resolveCommit(repo.Dir, hash[:12]); err != nil || got != hash {
+			t.Errorf("resolveCommit(%s) = %s, %v", hash[:12], got, err)
+		}
+	}
+	// A prefix nothing matches is git's own error, without candidates.
+	if _, err := resolveCommit(repo.Dir, "0000000000"); err == nil || strings.Contains(err.Error(), "ambiguous") {
+		t.Errorf("unknown prefix: %v", err)
+	}
+}
+
+func TestBoundaryEchoedInRun(t *testing.T) {
+	repo := newFixtureRepo(t)
+	hashes := repo.commits(4)
+	env := newAuditEnv(t)
+	out := env.mustRun("-repo", repo.Dir, "-commit", "HEAD~2", "-output", filepath.Join(env.Work, "report.txt"))
+	if want := "Auditing " + shortHash(hashes[3]) + " back to " + shortHash(hashes[1]) + " 'Change 1' (2024-01-01)"; !strings.Contains(out, want) {
+		t.Errorf("run output lacks %q:\n%s", want, out)
+	}
+	if len(env.Ollama.Prompts()) != 3 {
+		t.Errorf("%d commits audited, want 3", len(env.Ollama.Prompts()))
+	}
+}
+
+func TestGetTipMovement(t *testing.T) {
+	repo := newFixtureRepo(t)
+	hashes := repo.commits(3)
+	tip := hashes[2]
+	if moved, err := getTipMovement(repo.Dir, tip); err != nil || moved != nil {
+		t.Errorf("unmoved HEAD: %+v, %v", moved, err)
+	}
+	repo.commits(2)
+	head := repo.git("rev-parse", "HEAD")
+	if moved, err := getTipMovement(repo.Dir, tip); err != nil || moved == nil || *moved != (tipMovement{Head: head, Added: 2}) {
+		t.Errorf("advanced HEAD: %+v, %v", moved, err)
+	}
+	// Resetting below the tip and committing again rewrites the audited history.
+	repo.git("reset", "-q", "--hard", hashes[0])
+	rewritten := repo.commit("Rewrite", map[string]string{"src/new.go": "package src\n"})
+	if moved, err := getTipMovement(repo.Dir, tip); err != nil || moved == nil || *moved != (tipMovement{Head: rewritten, Added: 1, Dropped: 2}) {
+		t.Errorf("rewritten HEAD: %+v, %v", moved, err)
+	}
+}
+
+// commitDuringRun makes a commit in dir with plain git, for use from the fake server while
+// gitaudit runs; the fixture's helpers must not fail the test from another goroutine.
+func commitDuringRun(dir, message string) error {
+	cmd := exec.Command("git", "commit", "-q", "--allow-empty", "-m", message)
+	cmd.Dir = dir
+	cmd.Env = append(cmd.Environ(), "GIT_CONFIG_NOSYSTEM=1", "GIT_CONFIG_GLOBAL=/dev/null")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git commit: %v\n%s", err, out)
+	}
+	return nil
+}
+
+func TestTipPinnedDuringRun(t *testing.T) {
+	repo := newFixtureRepo(t)
+	hashes := repo.commits(4)
+	tip := hashes[3]
+	env := newAuditEnv(t)
+	// Two commits land on the audited branch between the first and the second model call.
+	var commitErr error
+	env.Ollama.Respond = func(n int, prompt string) (int, string) {
+		if n == 1 {
+			for i := 0; i < 2 && commitErr == nil; i++ {
+				commitErr = commitDuringRun(repo.Dir, fmt.Sprintf("Pushed during the audit %d", i))
+			}
+		}
+		return 0, ""
+	}
+
+	report := filepath.Join(env.Work, "report.json")
+	out := env.mustRun("-repo", repo.Dir, "-commit", "root", "-format", "json", "-output", report)
+	if commitErr != nil {
+		t.Fatal(commitErr)
+	}
+	head := repo.git("rev-parse", "HEAD")
+	note := "HEAD advanced by 2 commits to " + shortHash(head) + " during the audit; they are not in this report"
+	if !strings.Contains(out, "Warning: "+note) {
+		t.Errorf("run output lacks %q:\n%s", note, out)
+	}
+	// Exactly the range at startup was audited: no prompt for the new commits.
+	if prompts := env.Ollama.Prompts(); len(prompts) != 4 || strings.Contains(strings.Join(prompts, "\n"), "Pushed during the audit") {
+		t.Errorf("%d prompts sent", len(prompts))
+	}
+	var document jsonReport
+	if err := json.Unmarshal([]byte(readFile(t, report)), &document); err != nil {
+		t.Fatal(err)
+	}
+	if document.Head != tip || len(document.Range) != 4 || document.Range[0] != tip || len(document.Commits) != 4 {
+		t.Errorf("JSON head %s, range %q, %d entries", shortHash(document.Head), document.Range, len(document.Commits))
+	}
+
+	// The text report records the pinned tip and the note in its header.
+	text := filepath.Join(env.Work, "report.txt")
+	repo.git("reset", "-q", "--hard", tip)
+	env.Ollama.Respond = func(n int, prompt string) (int, string) {
+		if n == 5 {
+			// The history is rewritten: the audited tip is dropped for another commit.
+			cmd := exec.Command("git", "reset", "-q", "--hard", hashes[2])
+			cmd.Dir = repo.Dir
+			if out, err := cmd.CombinedOutput(); err != nil {
+				commitErr = fmt.Errorf("git reset: %v\n%s", err, out)
+			} else {
+				commitErr = commitDuringRun(repo.Dir, "Amended tip")
+			}
+		}
+		return 0, ""
+	}
+	env.mustRun("-repo", repo.Dir, "-commit", "root", "-output", text)
+	if commitErr != nil {
+		t.Fatal(commitErr)
+	}
+	content := readFile(t, text)
+	rewritten := "HEAD moved to " + shortHash(repo.git("rev-parse", "HEAD")) + " during the audit, adding 1 commits and dropping 1 audited ones; the report covers the history as it was at startup"
+	if !strings.Contains(content, "Audited tip: "+tip) || !strings.Contains(content, rewritten) || strings.Count(content, "Commit: ") != 4 {
+		t.Errorf("text report:\n%s", content)
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Severities of secret findings, most severe first.
+const (
+	severityCritical = "critical"
+	severityHigh     = "high"
+	severityMedium   = "medium"
+	severityLow      = "low"
+)
+
+// secretExcerptLimit bounds the masked line shown for a finding, in runes.
+const secretExcerptLimit = 120
+
+// secretRule recognizes one kind of secret. The secret is the whole match, or the "secret"
+// group when the pattern has one, so that a key name or quotes around it stay readable.
+type secretRule struct {
+	Name     string
+	Severity string
+	Pattern  *regexp.Regexp
+}
+
+// secretRules are the patterns -secret-report looks for in added and removed lines.
+var secretRules = []secretRule{
+	{"private-key", severityCritical, regexp.MustCompile(`-----BEGIN (?:[A-Z0-9]+ )*PRIVATE KEY(?: BLOCK)?-----`)},
+	{"aws-access-key-id", severityHigh, regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"github-token", severityHigh, regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,255}|github_pat_[A-Za-z0-9_]{60,255})\b`)},
+	{"gitlab-token", severityHigh, regexp.MustCompile(`\bglpat-[A-Za-z0-9_-]{20,}`)},
+	{"slack-token", severityHigh, regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}`)},
+	{"stripe-live-key", severityHigh, regexp.MustCompile(`\b[rs]k_live_[A-Za-z0-9]{20,}`)},
+	{"anthropic-api-key", severityHigh, regexp.MustCompile(`\bsk-ant-[A-Za-z0-9_-]{20,}`)},
+	{"google-api-key", severityMedium, regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+	{"password-assignment", severityLow, regexp.MustCompile(`(?i)\b(?:password|passwd|pwd|secret|api[_-]?key|access[_-]?token|auth[_-]?token)\b["']?\s*[:=]\s*["'](?P<secret>[^"'\s$<>{}]{8,})["']`)},
+}
+
+// secretFinding is a secret added by a commit. It holds no byte of the secret itself: the
+// excerpt has every secret masked, and the fingerprint is a truncated hash.
+type secretFinding struct {
+	File        string `json:"file"`
+	Rule        string `json:"rule"`
+	Severity    string `json:"severity"`
+	Excerpt     string `json:"excerpt"`
+	Fingerprint string `json:"fingerprint"`
+	// RemovedIn is the later commit of the range that removed the secret again, if any.
+	RemovedIn string `json:"removed_in,omitempty"`
+}
+
+// secretMatch is a secret found in one line of a patch.
+type secretMatch struct {
+	Rule        *secretRule
+	Fingerprint string
+}
+
+// secretFingerprint identifies a secret value across commits without revealing it.
+func secretFingerprint(rule, value string) string {
+	sum := sha256.Sum256([]byte(rule + "\x00" + value))
+	return hex.EncodeToString(sum[:6])
+}
+
+// findSecrets returns the secrets of line and the line with each of them masked as
+// [rule fingerprint]. Overlapping matches of later rules are left to the earlier rule.
+func findSecrets(line string) ([]secretMatch, string) {
+	type span struct {
+		start, end int
+		match      secretMatch
+	}
+	var spans []span
+	for i := range secretRules {
+		rule := &secretRules[i]
+		group := rule.Pattern.SubexpIndex("secret")
+		for _, loc := range rule.Pattern.FindAllStringSubmatchIndex(line, -1) {
+			start, end := loc[0], loc[1]
+			if group > 0 && loc[2*group] >= 0 {
+				start, end = loc[2*group], loc[2*group+1]
+			}
+			overlaps := false
+			for _, s := range spans {
+				if start < s.end && s.start < end {
+					overlaps = true
+					break
+				}
+			}
+			if !overlaps {
+				spans = append(spans, span{start, end, secretMatch{rule, secretFingerprint(rule.Name, line[start:end])}})
+			}
+		}
+	}
+	if len(spans) == 0 {
+		return nil, line
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	var masked strings.Builder
+	var matches []secretMatch
+	last := 0
+	for _, s := range spans {
+		masked.WriteString(line[last:s.start])
+		fmt.Fprintf(&masked, "[%s %s]", s.match.Rule.Name, s.match.Fingerprint)
+		last = s.end
+		matches = append(matches, s.match)
+	}
+	masked.WriteString(line[last:])
+	return matches, masked.String()
+}
+
+// maskedExcerpt trims a masked line for display. It is cut only after masking, so a secret
+// is never shown in part.
+func maskedExcerpt(masked string) string {
+	masked = strings.TrimSpace(masked)
+	if runes := []rune(masked); len(runes) > secretExcerptLimit {
+		return string(runes[:secretExcerptLimit]) + "…"
+	}
+	return masked
+}
+
+// patchSecrets are the secrets a patch adds and removes.
+type patchSecrets struct {
+	Added   []secretFinding
+	Removed map[string]bool // Fingerprints.
+}
+
+// scanPatchSecrets finds the secrets on the added and removed lines of a unified diff, one
+// finding per file and secret.
+func scanPatchSecrets(patch string) patchSecrets {
+	result := patchSecrets{Removed: make(map[string]bool)}
+	seen := make(map[string]bool)
+	path := ""
+	for _, line := range strings.Split(patch, "\n") {
+		if strings.HasPrefix(line, "diff --git ") {
+			path = diffSectionPath(line)
+			continue
+		}
+		if path == "" || len(line) == 0 || (line[0] != '+' && line[0] != '-') || strings.HasPrefix(line, "+++ ") || strings.HasPrefix(line, "--- ") {
+			continue
+		}
+		matches, masked := findSecrets(line[1:])
+		for _, m := range matches {
+			if line[0] == '-' {
+				result.Removed[m.Fingerprint] = true
+				continue
+			}
+			if key := path + "\x00" + m.Fingerprint; !seen[key] {
+				seen[key] = true
+				result.Added = append(result.Added, secretFinding{File: path, Rule: m.Rule.Name, Severity: m.Rule.Severity, Excerpt: maskedExcerpt(masked), Fingerprint: m.Fingerprint})
+			}
+		}
+	}
+	return result
+}
+
+// getPatchSecrets scans the diff of a commit against base, or its first parent, for secrets.
+// Every file is scanned, never_send ones included, since nothing here reaches the model.
+func getPatchSecrets(ctx context.Context, repoPath, commitHash, base string) (patchSecrets, error) {
+	args := []string{"diff-tree", "-p", "-U0", "--no-color", "--no-ext-diff", "--no-commit-id", "-M"}
+	if base != "" {
+		args = append(args, base, commitHash)
+	} else {
+		// A merge is compared with its first parent only by -m; without it diff-tree prints
+		// nothing, and the commits it brought in are scanned on their own.
+		args = append(args, "--root", commitHash)
+	}
+	output, err := gitRun(ctx, repoPath, args...)
+	if err != nil {
+		return patchSecrets{}, fmt.Errorf("failed to read the diff of commit %s for secrets: %w", commitHash, err)
+	}
+	return scanPatchSecrets(string(output)), nil
+}
+
+// scanSecrets scans the commits of a range, newest first as in hashes, and attaches the
+// secrets each adds to its entry. Commits are walked oldest first so that a later commit
+// removing a secret marks the finding as removed; a secret removed and added back in one
+// commit, e.g. moved to another file, stays present. Commits without an entry, such as those
+// of other shards, are scanned for removals but get no findings. It returns the number of
+// commits that could not be scanned.
+func scanSecrets(ctx context.Context, repoPath string, hashes []string, targets map[string]auditTarget, entries []CommitAuditData) int {
+	index := make(map[string]int, len(entries))
+	for i, entry := range entries {
+		index[entry.Hash] = i
+	}
+	open := make(map[string][]*secretFinding) // Fingerprint -> findings not removed yet.
+	findings := make(map[string][]secretFinding)
+	failures := 0
+	for i := len(hashes) - 1; i >= 0; i-- {
+		hash := hashes[i]
+		target := targets[hash]
+		patchSets := []string{hash}
+		if target.Group != nil {
+			patchSets = target.Group.Commits
+		}
+		for _, set := range patchSets {
+			scanned, err := getPatchSecrets(ctx, repoPath, set, target.DiffBase)
+			if err != nil {
+				fmt.Printf("Warning: %v\n", err)
+				failures++
+				continue
+			}
+			added := make(map[string]bool)
+			for _, f := range scanned.Added {
+				added[f.Fingerprint] = true
+			}
+			for fingerprint := range scanned.Removed {
+				if added[fingerprint] {
+					continue
+				}
+				for _, f := range open[fingerprint] {
+					f.RemovedIn = hash
+				}
+				delete(open, fingerprint)
+			}
+			for _, f := range scanned.Added {
+				if scanned.Removed[f.Fingerprint] && len(open[f.Fingerprint]) > 0 {
+					continue // Moved, not newly committed.
+				}
+				if !containsFinding(findings[hash], f) {
+					// The patch sets of a change group repeat each other's secrets.
+					findings[hash] = append(findings[hash], f)
+				}
+			}
+		}
+		// Pointers are taken once the commit's findings are complete, so appends do not move them.
+		for j := range findings[hash] {
+			f := &findings[hash][j]
+			open[f.Fingerprint] = append(open[f.Fingerprint], f)
+		}
+	}
+	for hash, list := range findings {
+		if i, ok := index[hash]; ok {
+			entries[i].Secrets = list
+		}
+	}
+	return failures
+}
+
+// containsFinding reports whether findings has f's secret in f's file.
+func containsFinding(findings []secretFinding, f secretFinding) bool {
+	for _, existing := range findings {
+		if existing.File == f.File && existing.Fingerprint == f.Fingerprint {
+			return true
+		}
+	}
+	return false
+}
+
+// formatSecretSection lists the secret findings of entries as the report's appendix, or
+// returns "" when there are none.
+func formatSecretSection(entries []CommitAuditData) string {
+	var sb strings.Builder
+	count := 0
+	for _, entry := range entries {
+		for _, f := range entry.Secrets {
+			count++
+			status := msg("section.secrets_present")
+			if f.RemovedIn != "" {
+				status = msg("section.secrets_removed", shortHash(f.RemovedIn))
+			}
+			fmt.Fprintf(&sb, "%s %s [%s, %s] %s (%s)\n", shortHash(entry.Hash), f.File, f.Rule, f.Severity, f.Excerpt, status)
+		}
+	}
+	if count == 0 {
+		return ""
+	}
+	return msg("section.secrets", count) + "\n" + sb.String()
+}
+
+// secretSeverities lists the distinct severities of an entry's findings.
+func secretSeverities(findings []secretFinding) []string {
+	seen := make(map[string]bool)
+	var severities []string
+	for _, f := range findings {
+		if !seen[f.Severity] {
+			seen[f.Severity] = true
+			severities = append(severities, f.Severity)
+		}
+	}
+	sort.Strings(severities)
+	return severities
+}
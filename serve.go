@@ -0,0 +1,603 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Statuses of a served audit run.
+const (
+	serveQueued      = "queued"
+	serveRunning     = "running"
+	serveCancelling  = "cancelling"
+	serveDone        = "done"
+	serveFailed      = "failed"
+	serveCancelled   = "cancelled"
+	serveInterrupted = "interrupted"
+)
+
+// serveJSONReport is the -format json report each run writes next to its text report, for
+// GET /audits/{id}/report?format=json.
+const serveJSONReport = "gitaudit.json"
+
+// serveQueueSize bounds the runs waiting for a worker; POST /audits fails with 503 beyond it.
+const serveQueueSize = 256
+
+// serveConfig is the "serve" block of the config file.
+type serveConfig struct {
+	// TokenEnv names the environment variable holding the bearer token clients must send;
+	// the token itself is never stored in the config file. Unset, the API is open.
+	TokenEnv string `json:"token_env"`
+	// Repos maps the names clients use to repository paths on the server.
+	Repos map[string]string `json:"repos"`
+}
+
+// validate checks the registered repositories.
+func (c *serveConfig) validate() error {
+	for name, path := range c.Repos {
+		if name == "" || strings.ContainsAny(name, "/\\") {
+			return fmt.Errorf("serve.repos: invalid name %q", name)
+		}
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("serve.repos: path %q of %s must be absolute", path, name)
+		}
+	}
+	return nil
+}
+
+// serveFlags are the flags of `gitaudit serve`.
+type serveFlags struct {
+	Addr    string
+	Workdir string
+	Workers int
+}
+
+// registerServeFlags defines the flags of `gitaudit serve` on fs.
+func registerServeFlags(fs *flag.FlagSet) *serveFlags {
+	s := &serveFlags{}
+	fs.StringVar(&s.Addr, "addr", ":8080", "Address to listen on")
+	fs.StringVar(&s.Workdir, "workdir", "", "Directory holding the state and reports of every run (default: the serve directory of the gitaudit data directory)")
+	fs.IntVar(&s.Workers, "workers", 1, "Number of audits run at the same time; further requests wait in a queue")
+	return s
+}
+
+// serveRequest is the body of POST /audits. Repo names a registered repository; with only
+// URL, the repository is the registered one that has the URL's project as a remote.
+type serveRequest struct {
+	Repo    string `json:"repo"`
+	Commit  string `json:"commit"`
+	URL     string `json:"url"`
+	Since   string `json:"since"`
+	Until   string `json:"until"`
+	Profile string `json:"profile"`
+}
+
+// serveRun is the state of one run, persisted as run.json in its directory.
+type serveRun struct {
+	ID       string        `json:"id"`
+	Request  serveRequest  `json:"request"`
+	Status   string        `json:"status"`
+	Created  time.Time     `json:"created"`
+	Started  *time.Time    `json:"started,omitempty"`
+	Finished *time.Time    `json:"finished,omitempty"`
+	ExitCode *int          `json:"exit_code,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Progress serveProgress `json:"progress"`
+}
+
+// serveProgress counts the commits of a run, read from its state file.
+type serveProgress struct {
+	Total   int `json:"total"`
+	Audited int `json:"audited"`
+}
+
+// finished reports whether the run has reached a final status.
+func (r *serveRun) finished() bool {
+	switch r.Status {
+	case serveDone, serveFailed, serveCancelled, serveInterrupted:
+		return true
+	}
+	return false
+}
+
+// auditServer runs the audits requested over HTTP, each as a gitaudit child process in its
+// own directory under Workdir, so a run is exactly what the command line would produce.
+type auditServer struct {
+	Workdir string
+	Config  *serveConfig
+	Token   string
+	Exe     string
+
+	mu       sync.Mutex
+	runs     map[string]*serveRun
+	procs    map[string]*os.Process
+	queue    chan string
+	stopping bool
+	workers  sync.WaitGroup
+}
+
+// runServe implements `gitaudit serve`.
+func runServe(args []string) {
+	fs := newCommandFlagSet("serve")
+	flags := registerServeFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() > 0 || flags.Workers < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Printf("Error loading configuration: %v\n", err)
+		os.Exit(1)
+	}
+	server, err := newAuditServer(config, flags.Workdir)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if server.Token == "" {
+		fmt.Println("Warning: no serve.token_env is configured; anyone who can reach the server can start audits.")
+	}
+	requeued := server.restore()
+	fmt.Printf("Serving audits of %d registered repositories on %s with %d workers (state in %s)\n", len(server.Config.Repos), flags.Addr, flags.Workers, server.Workdir)
+	if requeued > 0 {
+		fmt.Printf("Requeued %d runs left queued by the previous server\n", requeued)
+	}
+	for range flags.Workers {
+		server.workers.Add(1)
+		go server.work()
+	}
+
+	httpServer := &http.Server{Addr: flags.Addr, Handler: server.handler(), ReadHeaderTimeout: 10 * time.Second}
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigChan
+		fmt.Printf("\n%s received: interrupting the running audits, which write the commits audited so far; queued ones resume when the server restarts.\n", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		httpServer.Shutdown(ctx)
+	}()
+	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	server.stop()
+}
+
+// newAuditServer prepares workdir, which must lie outside every registered repository.
+func newAuditServer(config *Config, workdir string) (*auditServer, error) {
+	s := &auditServer{Config: config.Serve, runs: make(map[string]*serveRun), procs: make(map[string]*os.Process), queue: make(chan string, serveQueueSize)}
+	if s.Config == nil {
+		s.Config = &serveConfig{}
+	}
+	if s.Config.TokenEnv != "" {
+		if s.Token = os.Getenv(s.Config.TokenEnv); s.Token == "" {
+			return nil, fmt.Errorf("environment variable %s (from serve.token_env) is not set", s.Config.TokenEnv)
+		}
+	}
+	if workdir == "" {
+		dataDir, err := xdgDataDir()
+		if err != nil {
+			return nil, err
+		}
+		workdir = filepath.Join(dataDir, "serve")
+	}
+	var err error
+	if s.Workdir, err = filepath.Abs(workdir); err != nil {
+		return nil, fmt.Errorf("failed to resolve workdir %s: %w", workdir, err)
+	}
+	for name, path := range s.Config.Repos {
+		// Runs use -no-repo-writes; a workdir inside a repository would put reports there.
+		if err := checkOutsideRepo(s.Workdir, path, "workdir"); err != nil {
+			return nil, fmt.Errorf("repository %s: %w", name, err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(s.Workdir, "runs"), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create workdir %s: %w", s.Workdir, err)
+	}
+	if s.Exe, err = os.Executable(); err != nil {
+		return nil, fmt.Errorf("failed to locate the gitaudit executable: %w", err)
+	}
+	return s, nil
+}
+
+// restore loads the runs of a previous server from the workdir. Runs it left queued are
+// queued again; runs it left running were stopped with it and are marked interrupted.
+func (s *auditServer) restore() int {
+	dirs, _ := os.ReadDir(filepath.Join(s.Workdir, "runs"))
+	var queued []*serveRun
+	for _, dir := range dirs {
+		data, err := os.ReadFile(filepath.Join(s.Workdir, "runs", dir.Name(), "run.json"))
+		if err != nil {
+			continue
+		}
+		var run serveRun
+		if err := json.Unmarshal(data, &run); err != nil || run.ID != dir.Name() {
+			fmt.Printf("Warning: ignoring run %s: unreadable run.json\n", dir.Name())
+			continue
+		}
+		s.runs[run.ID] = &run
+		switch run.Status {
+		case serveQueued:
+			queued = append(queued, &run)
+		case serveRunning, serveCancelling:
+			run.Status = serveInterrupted
+			run.Error = "the server stopped during the run"
+			s.save(&run)
+		}
+	}
+	sort.Slice(queued, func(i, j int) bool { return queued[i].Created.Before(queued[j].Created) })
+	for _, run := range queued {
+		select {
+		case s.queue <- run.ID:
+		default:
+			run.Status, run.Error = serveFailed, "the queue was full when the server restarted"
+			s.save(run)
+		}
+	}
+	return len(queued)
+}
+
+// handler routes the HTTP API.
+func (s *auditServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /audits", s.handleCreate)
+	mux.HandleFunc("GET /audits/{id}", s.handleStatus)
+	mux.HandleFunc("GET /audits/{id}/report", s.handleReport)
+	mux.HandleFunc("DELETE /audits/{id}", s.handleCancel)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.Token != "" {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="gitaudit"`)
+				writeServeError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+				return
+			}
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func (s *auditServer) handleCreate(w http.ResponseWriter, r *http.Request) {
+	var req serveRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeServeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+	if err := s.resolveRequest(&req); err != nil {
+		writeServeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	run := &serveRun{ID: newServeRunID(), Request: req, Status: serveQueued, Created: time.Now().UTC()}
+	s.mu.Lock()
+	select {
+	case s.queue <- run.ID:
+	default:
+		s.mu.Unlock()
+		writeServeError(w, http.StatusServiceUnavailable, "too many audits are queued; try again later")
+		return
+	}
+	s.runs[run.ID] = run
+	s.save(run)
+	snapshot := *run
+	s.mu.Unlock()
+	w.Header().Set("Location", "/audits/"+run.ID)
+	writeServeJSON(w, http.StatusAccepted, snapshot)
+}
+
+// resolveRequest checks req and fills in the repository of a request that gives only a URL.
+func (s *auditServer) resolveRequest(req *serveRequest) error {
+	switch {
+	case req.URL != "" && req.Commit != "":
+		return errors.New("give either commit or url, not both")
+	case req.URL == "" && req.Commit == "" && req.Since == "" && req.Until == "":
+		return errors.New("give commit, url, or since/until")
+	}
+	var target *compareTarget
+	if req.URL != "" {
+		var err error
+		if target, err = parseCompareURL(req.URL); err != nil {
+			return err
+		}
+	}
+	if req.Repo == "" {
+		if target == nil {
+			return errors.New("give repo, the name of a registered repository")
+		}
+		names := make([]string, 0, len(s.Config.Repos))
+		for name := range s.Config.Repos {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if _, err := target.findRemote(s.Config.Repos[name]); err == nil {
+				req.Repo = name
+				return nil
+			}
+		}
+		return fmt.Errorf("no registered repository has %s/%s as a remote", target.Host, target.Project)
+	}
+	if _, ok := s.Config.Repos[req.Repo]; !ok {
+		return fmt.Errorf("unknown repository %q; register it under serve.repos in the config file", req.Repo)
+	}
+	return nil
+}
+
+func (s *auditServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	run, ok := s.runs[r.PathValue("id")]
+	var snapshot serveRun
+	if ok {
+		snapshot = *run
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeServeError(w, http.StatusNotFound, "no such audit")
+		return
+	}
+	writeServeJSON(w, http.StatusOK, snapshot)
+}
+
+func (s *auditServer) handleReport(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	s.mu.Lock()
+	run, ok := s.runs[id]
+	status := ""
+	if ok {
+		status = run.Status
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeServeError(w, http.StatusNotFound, "no such audit")
+		return
+	}
+	name, contentType := defaultOutputFileName, "text/plain; charset=utf-8"
+	switch format := r.URL.Query().Get("format"); format {
+	case "", formatText:
+	case formatJSON:
+		name, contentType = serveJSONReport, "application/json"
+	default:
+		writeServeError(w, http.StatusBadRequest, fmt.Sprintf("unsupported format %q: reports are available as %q and %q", format, formatText, formatJSON))
+		return
+	}
+	// Checkpoints and interrupted runs leave partial reports, marked as such in their header
+	// or by "partial" in the JSON document.
+	data, err := os.ReadFile(filepath.Join(s.runDir(id), name))
+	if err != nil {
+		writeServeError(w, http.StatusConflict, fmt.Sprintf("the audit is %s and has no report yet", status))
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(data)
+}
+
+// handleCancel cancels a queued run, or interrupts a running one as Ctrl+C would: the commit
+// in flight completes and the commits audited so far are written.
+func (s *auditServer) handleCancel(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run, ok := s.runs[id]
+	switch {
+	case !ok:
+		writeServeError(w, http.StatusNotFound, "no such audit")
+		return
+	case run.finished():
+		writeServeError(w, http.StatusConflict, fmt.Sprintf("the audit is already %s", run.Status))
+		return
+	case run.Status == serveQueued:
+		now := time.Now().UTC()
+		run.Status, run.Finished = serveCancelled, &now
+	case run.Status == serveRunning:
+		run.Status = serveCancelling
+		if proc := s.procs[id]; proc != nil {
+			if err := proc.Signal(os.Interrupt); err != nil {
+				proc.Kill()
+			}
+		}
+	}
+	s.save(run)
+	writeServeJSON(w, http.StatusAccepted, run)
+}
+
+// work runs queued audits until the queue is closed.
+func (s *auditServer) work() {
+	defer s.workers.Done()
+	for id := range s.queue {
+		s.mu.Lock()
+		run := s.runs[id]
+		if run.Status != serveQueued || s.stopping {
+			// Cancelled while queued, or left queued on disk for the next server.
+			s.mu.Unlock()
+			continue
+		}
+		now := time.Now().UTC()
+		run.Status, run.Started = serveRunning, &now
+		s.save(run)
+		s.mu.Unlock()
+		s.execute(run)
+	}
+}
+
+// execute runs one audit as a child process and records its outcome.
+func (s *auditServer) execute(run *serveRun) {
+	dir := s.runDir(run.ID)
+	// The text report comes first, so that the state file followed for progress is its own.
+	args := []string{"-repo=" + s.Config.Repos[run.Request.Repo], "-no-repo-writes",
+		"-out=" + formatText + "=" + defaultOutputFileName, "-out=" + formatJSON + "=" + serveJSONReport}
+	for _, flag := range [][2]string{{"commit", run.Request.Commit}, {"url", run.Request.URL}, {"since", run.Request.Since}, {"until", run.Request.Until}, {"profile", run.Request.Profile}} {
+		if flag[1] != "" {
+			args = append(args, "-"+flag[0]+"="+flag[1])
+		}
+	}
+	err := s.startAndWait(run, dir, args)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.procs, run.ID)
+	now := time.Now().UTC()
+	run.Finished = &now
+	var exitErr *exec.ExitError
+	if err == nil {
+		code := 0
+		run.ExitCode = &code
+	} else if errors.As(err, &exitErr) {
+		code := exitErr.ExitCode()
+		run.ExitCode = &code
+	}
+	switch {
+	case run.Status == serveCancelling:
+		run.Status = serveCancelled
+	case err == nil:
+		run.Status = serveDone
+	case run.ExitCode != nil && (*run.ExitCode == exitInterrupted || *run.ExitCode == -1):
+		run.Status, run.Error = serveInterrupted, "the audit was interrupted"
+	case run.ExitCode != nil:
+		run.Status, run.Error = serveFailed, fmt.Sprintf("gitaudit exited with status %d; see output.log in %s", *run.ExitCode, dir)
+	default:
+		run.Status, run.Error = serveFailed, err.Error()
+	}
+	s.save(run)
+}
+
+// serveProgressInterval is how often the progress of a running audit is read from its state
+// file.
+var serveProgressInterval = time.Second
+
+// startAndWait starts the child in dir, follows its state file for progress and waits for it
+// to exit.
+func (s *auditServer) startAndWait(run *serveRun, dir string, args []string) error {
+	logFile, err := os.Create(filepath.Join(dir, "output.log"))
+	if err != nil {
+		return fmt.Errorf("failed to create the run log: %w", err)
+	}
+	defer logFile.Close()
+	cmd := exec.Command(s.Exe, args...)
+	cmd.Dir = dir
+	cmd.Stdout, cmd.Stderr = logFile, logFile
+	s.mu.Lock()
+	if run.Status == serveCancelling {
+		s.mu.Unlock()
+		return errors.New("cancelled before it started")
+	}
+	if err := cmd.Start(); err != nil {
+		s.mu.Unlock()
+		return fmt.Errorf("failed to start gitaudit: %w", err)
+	}
+	s.procs[run.ID] = cmd.Process
+	s.mu.Unlock()
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	statePath := statePathFor(filepath.Join(dir, defaultOutputFileName))
+	ticker := time.NewTicker(serveProgressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-done:
+			s.readProgress(run, statePath, err == nil)
+			return err
+		case <-ticker.C:
+			s.readProgress(run, statePath, false)
+		}
+	}
+}
+
+// readProgress updates the progress of run from the state file the child keeps for -resume:
+// the range it resolved and the commits audited so far, carried-over ones included. A run
+// that completes removes its state file; its JSON report then gives the final count.
+func (s *auditServer) readProgress(run *serveRun, statePath string, completed bool) {
+	state, err := loadRunState(statePath)
+	var report jsonReport
+	if err != nil && completed {
+		data, readErr := os.ReadFile(filepath.Join(filepath.Dir(statePath), serveJSONReport))
+		if readErr == nil {
+			readErr = json.Unmarshal(data, &report)
+		}
+		if readErr != nil {
+			completed = false
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case err == nil:
+		run.Progress = serveProgress{Total: len(state.Header.Range), Audited: len(state.Entries)}
+	case completed:
+		run.Progress = serveProgress{Total: len(report.Range)}
+		for _, entry := range report.Commits {
+			// Tag entries of -include-tags are not commits of the range.
+			if entry.Kind != kindTag {
+				run.Progress.Audited++
+			}
+		}
+	}
+}
+
+// stop interrupts the running audits and waits for them to write their reports. Runs still
+// queued stay queued on disk for the next server.
+func (s *auditServer) stop() {
+	s.mu.Lock()
+	s.stopping = true
+	for _, proc := range s.procs {
+		if err := proc.Signal(os.Interrupt); err != nil {
+			proc.Kill()
+		}
+	}
+	close(s.queue)
+	s.mu.Unlock()
+	s.workers.Wait()
+}
+
+// runDir is the directory of a run: its run.json, console output and report.
+func (s *auditServer) runDir(id string) string {
+	return filepath.Join(s.Workdir, "runs", id)
+}
+
+// save writes run.json; a failure is printed, since the run itself can go on. The caller
+// holds s.mu.
+func (s *auditServer) save(run *serveRun) {
+	data, err := json.MarshalIndent(run, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(filepath.Join(s.runDir(run.ID), "run.json"), data)
+	}
+	if err != nil {
+		fmt.Printf("Warning: failed to save the state of run %s: %v\n", run.ID, err)
+	}
+}
+
+// newServeRunID returns a sortable, unguessable run id.
+func newServeRunID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(b)
+}
+
+func writeServeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeServeError(w http.ResponseWriter, status int, message string) {
+	writeServeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// serveFixture is an audit server over a fixture repository registered as "app", with its
+// children running gitaudit against the fake model server of Env.
+type serveFixture struct {
+	t       *testing.T
+	Repo    *fixtureRepo
+	Hashes  []string
+	Env     *auditEnv
+	Workdir string
+	Server  *auditServer
+	HTTP    *httptest.Server
+	// Stop stops the server; it is also called when the test ends.
+	Stop func()
+}
+
+// newServeFixture starts a server with workers over a repository of three commits. The
+// children are this test binary, run as gitaudit with the test's home directory.
+func newServeFixture(t *testing.T, token string) *serveFixture {
+	t.Helper()
+	f := &serveFixture{t: t, Repo: newFixtureRepo(t), Env: newAuditEnv(t), Workdir: t.TempDir()}
+	f.Hashes = f.Repo.commits(3)
+	config, err := json.Marshal(f.Env.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(f.Env.Home, ".gitaudit"), config, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GITAUDIT_TEST_MAIN", "1")
+	t.Setenv("HOME", f.Env.Home)
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(f.Env.Home, ".cache"))
+	t.Setenv("XDG_DATA_HOME", filepath.Join(f.Env.Home, ".local", "share"))
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	if token != "" {
+		t.Setenv("GITAUDIT_TEST_SERVE_TOKEN", token)
+	}
+	saved := serveProgressInterval
+	serveProgressInterval = 20 * time.Millisecond
+	t.Cleanup(func() { serveProgressInterval = saved })
+	f.start(token != "")
+	return f
+}
+
+// start starts a server on the fixture's workdir, as a restarted server would, and returns
+// the number of runs it requeued.
+func (f *serveFixture) start(withToken bool) int {
+	f.t.Helper()
+	serve := &serveConfig{Repos: map[string]string{"app": f.Repo.Dir}}
+	if withToken {
+		serve.TokenEnv = "GITAUDIT_TEST_SERVE_TOKEN"
+	}
+	server, err := newAuditServer(&Config{Serve: serve}, f.Workdir)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	server.Exe = os.Args[0]
+	requeued := server.restore()
+	server.workers.Add(1)
+	go server.work()
+	f.Server, f.HTTP = server, httptest.NewServer(server.handler())
+	httpServer := f.HTTP
+	var once sync.Once
+	f.Stop = func() {
+		once.Do(func() {
+			httpServer.Close()
+			server.stop()
+		})
+	}
+	f.t.Cleanup(f.Stop)
+	return requeued
+}
+
+// do sends a request to the API with a JSON body and decodes the JSON answer into result.
+func (f *serveFixture) do(method, path, body, token string, result any) int {
+	f.t.Helper()
+	req, err := http.NewRequest(method, f.HTTP.URL+path, strings.NewReader(body))
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if result != nil {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			f.t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// create queues an audit and returns its run.
+func (f *serveFixture) create(body string) serveRun {
+	f.t.Helper()
+	var run serveRun
+	if code := f.do("POST", "/audits", body, "", &run); code != http.StatusAccepted {
+		f.t.Fatalf("POST /audits %s: status %d", body, code)
+	}
+	return run
+}
+
+// wait polls the run until check accepts it, and returns it.
+func (f *serveFixture) wait(id string, check func(serveRun) bool) serveRun {
+	f.t.Helper()
+	deadline := time.Now().Add(20 * time.Second)
+	for {
+		var run serveRun
+		if code := f.do("GET", "/audits/"+id, "", "", &run); code != http.StatusOK {
+			f.t.Fatalf("GET /audits/%s: status %d", id, code)
+		}
+		if check(run) {
+			return run
+		}
+		if time.Now().After(deadline) {
+			f.t.Fatalf("run %s stuck at %+v", id, run)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// report fetches the report of a run.
+func (f *serveFixture) report(id, query string) (int, string) {
+	f.t.Helper()
+	resp, err := http.Get(f.HTTP.URL + "/audits/" + id + "/report" + query)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestServeValidation(t *testing.T) {
+	f := newServeFixture(t, "")
+	for body, want := range map[string]string{
+		`{"repo": "app"}`:                                      "give commit, url, or since/until",
+		`{"commit": "root"}`:                                   "give repo, the name of a registered repository",
+		`{"repo": "other", "commit": "root"}`:                  `unknown repository "other"`,
+		`{"repo": "app", "commit": "x", "url": "y"}`:           "give either commit or url, not both",
+		`{"repo": "app", "branch": "main"}`:                    `unknown field "branch"`,
+		`{"url": "https://example.com/nowhere"}`:               "unsupported URL",
+		`{"url": "https://github.com/org/repo/compare/a...b"}`: "no registered repository has github.com/org/repo as a remote",
+		`not json`: "invalid request body",
+	} {
+		var answer map[string]string
+		if code := f.do("POST", "/audits", body, "", &answer); code != http.StatusBadRequest || !strings.Contains(answer["error"], want) {
+			t.Errorf("POST %s: status %d, %q; want %q", body, code, answer["error"], want)
+		}
+	}
+	for _, path := range []string{"/audits/nope", "/audits/nope/report"} {
+		if code := f.do("GET", path, "", "", nil); code != http.StatusNotFound {
+			t.Errorf("GET %s: status %d", path, code)
+		}
+	}
+	if code := f.do("DELETE", "/audits/nope", "", "", nil); code != http.StatusNotFound {
+		t.Errorf("DELETE of an unknown run: status %d", code)
+	}
+}
+
+func TestServeAudit(t *testing.T) {
+	f := newServeFixture(t, "")
+	// Each commit takes long enough for the progress to be seen between commits.
+	f.Env.Ollama.Delay = 150 * time.Millisecond
+	run := f.create(`{"repo": "app", "commit": "root"}`)
+	if run.Status != serveQueued || run.ID == "" {
+		t.Fatalf("created run %+v", run)
+	}
+
+	// The progress comes from the run's state file while it runs.
+	during := f.wait(run.ID, func(r serveRun) bool { return r.finished() || (r.Progress.Total == 3 && r.Progress.Audited > 0) })
+	if during.finished() || during.Status != serveRunning || during.Started == nil || during.Progress.Audited == 3 {
+		t.Errorf("progress during the run: %+v", during)
+	}
+	done := f.wait(run.ID, func(r serveRun) bool { return r.finished() })
+	if done.Status != serveDone || done.ExitCode == nil || *done.ExitCode != 0 || done.Progress != (serveProgress{Total: 3, Audited: 3}) || done.Finished == nil {
+		t.Errorf("finished run %+v", done)
+	}
+	code, report := f.report(run.ID, "")
+	if code != http.StatusOK || strings.Count(report, "Commit: ") != 3 || !strings.Contains(report, "Commit: "+f.Hashes[2]) {
+		t.Errorf("report: status %d\n%s", code, report)
+	}
+	code, report = f.report(run.ID, "?format=json")
+	var document jsonReport
+	if err := json.Unmarshal([]byte(report), &document); code != http.StatusOK || err != nil || len(document.Range) != 3 || len(document.Commits) != 3 || document.Partial {
+		t.Errorf("JSON report: status %d, %v\n%s", code, err, report)
+	}
+	resp, err := http.Get(f.HTTP.URL + "/audits/" + run.ID + "/report?format=json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if contentType := resp.Header.Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("JSON report content type %q", contentType)
+	}
+	if code, body := f.report(run.ID, "?format=pdf"); code != http.StatusBadRequest || !strings.Contains(body, `reports are available as \"text\" and \"json\"`) {
+		t.Errorf("?format=pdf: status %d\n%s", code, body)
+	}
+	// The run's files are in its directory, and nothing was written to the repository.
+	for _, name := range []string{"run.json", "output.log", defaultOutputFileName, serveJSONReport} {
+		if _, err := os.Stat(filepath.Join(f.Server.runDir(run.ID), name)); err != nil {
+			t.Error(err)
+		}
+	}
+	if status := f.Repo.git("status", "--porcelain", "--ignored"); status != "" {
+		t.Errorf("the repository was written to:\n%s", status)
+	}
+
+	// A restarted server serves the completed run from the workdir.
+	f.Stop()
+	f.start(false)
+	if restored := f.wait(run.ID, func(serveRun) bool { return true }); restored.Status != serveDone || restored.Progress != done.Progress {
+		t.Errorf("restored run %+v", restored)
+	}
+}
+
+func TestServeCancel(t *testing.T) {
+	f := newServeFixture(t, "")
+	release := make(chan struct{})
+	var once sync.Once
+	unblock := func() { once.Do(func() { close(release) }) }
+	t.Cleanup(unblock)
+	// The first commit is answered; the second waits until released.
+	f.Env.Ollama.Respond = func(n int, prompt string) (int, string) {
+		if n > 1 {
+			<-release
+		}
+		return 0, ""
+	}
+	running := f.create(`{"repo": "app", "commit": "root"}`)
+	// With one worker, the second run waits in the queue.
+	queued := f.create(`{"repo": "app", "commit": "root"}`)
+	f.wait(running.ID, func(r serveRun) bool { return r.Progress.Audited == 1 })
+
+	var cancelled serveRun
+	if code := f.do("DELETE", "/audits/"+queued.ID, "", "", &cancelled); code != http.StatusAccepted || cancelled.Status != serveCancelled {
+		t.Errorf("DELETE of a queued run: status %d, %+v", code, cancelled)
+	}
+	if code := f.do("DELETE", "/audits/"+queued.ID, "", "", nil); code != http.StatusConflict {
+		t.Errorf("DELETE of a cancelled run: status %d", code)
+	}
+	if code, _ := f.report(queued.ID, ""); code != http.StatusConflict {
+		t.Errorf("report of a cancelled run: status %d", code)
+	}
+
+	// Cancelling the running run interrupts it as Ctrl+C would: the commit in flight completes.
+	var cancelling serveRun
+	if code := f.do("DELETE", "/audits/"+running.ID, "", "", &cancelling); code != http.StatusAccepted || cancelling.Status != serveCancelling {
+		t.Errorf("DELETE of a running run: status %d, %+v", code, cancelling)
+	}
+	time.Sleep(100 * time.Millisecond)
+	unblock()
+	final := f.wait(running.ID, func(r serveRun) bool { return r.finished() })
+	if final.Status != serveCancelled || final.Progress != (serveProgress{Total: 3, Audited: 2}) {
+		t.Errorf("cancelled run %+v", final)
+	}
+	if code, report := f.report(running.ID, ""); code != http.StatusOK || strings.Count(report, "Commit: ") != 2 {
+		t.Errorf("report of the cancelled run: status %d\n%s", code, report)
+	}
+	var document jsonReport
+	if code, report := f.report(running.ID, "?format=json"); code != http.StatusOK || json.Unmarshal([]byte(report), &document) != nil || !document.Partial || len(document.Commits) != 2 {
+		t.Errorf("JSON report of the cancelled run: status %d\n%s", code, report)
+	}
+}
+
+func TestServeToken(t *testing.T) {
+	f := newServeFixture(t, "s3cret")
+	for token, want := range map[string]int{"": http.StatusUnauthorized, "wrong": http.StatusUnauthorized, "s3cret": http.StatusNotFound} {
+		if code := f.do("GET", "/audits/nope", "", token, nil); code != want {
+			t.Errorf("token %q: status %d, want %d", token, code, want)
+		}
+	}
+}
+
+func TestServeRestore(t *testing.T) {
+	f := newServeFixture(t, "")
+	f.Stop()
+	// Runs left behind by a server that stopped: one queued, one running.
+	write := func(run serveRun) {
+		if err := os.MkdirAll(f.Server.runDir(run.ID), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		f.Server.save(&run)
+	}
+	created := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	write(serveRun{ID: "queued", Request: serveRequest{Repo: "app", Commit: "root"}, Status: serveQueued, Created: created})
+	write(serveRun{ID: "running", Request: serveRequest{Repo: "app", Commit: "root"}, Status: serveRunning, Created: created})
+	if err := os.MkdirAll(f.Server.runDir("broken"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(f.Server.runDir("broken"), "run.json"), []byte("{"), 0o644)
+
+	var requeued int
+	out := captureStdout(t, func() { requeued = f.start(false) })
+	if requeued != 1 || !strings.Contains(out, "Warning: ignoring run broken: unreadable run.json") {
+		t.Errorf("requeued %d\n%s", requeued, out)
+	}
+	if run := f.wait("running", func(serveRun) bool { return true }); run.Status != serveInterrupted || run.Error != "the server stopped during the run" {
+		t.Errorf("interrupted run %+v", run)
+	}
+	if run := f.wait("queued", func(r serveRun) bool { return r.finished() }); run.Status != serveDone || run.Progress.Audited != 3 {
+		t.Errorf("requeued run %+v", run)
+	}
+}
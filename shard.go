@@ -0,0 +1,339 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// shardManifestVersion is the format version of the shard manifests; merge-shards refuses
+// manifests of another version.
+const shardManifestVersion = 1
+
+// shardSpec is the -shard i/N selection: this machine audits shard Index (1-based) of Count.
+type shardSpec struct {
+	Index, Count int
+}
+
+// parseShardSpec parses -shard "i/N". An empty value disables sharding.
+func parseShardSpec(value string) (shardSpec, error) {
+	if value == "" {
+		return shardSpec{}, nil
+	}
+	i, n, ok := strings.Cut(value, "/")
+	index, err1 := strconv.Atoi(strings.TrimSpace(i))
+	count, err2 := strconv.Atoi(strings.TrimSpace(n))
+	if !ok || err1 != nil || err2 != nil || count < 1 || index < 1 || index > count {
+		return shardSpec{}, fmt.Errorf("invalid -shard value %q: expected i/N with 1 <= i <= N, e.g. 2/3", value)
+	}
+	return shardSpec{Index: index, Count: count}, nil
+}
+
+func (s shardSpec) String() string {
+	return fmt.Sprintf("%d of %d", s.Index, s.Count)
+}
+
+// shardOf returns the 1-based shard of count that a commit belongs to. It depends on the
+// commit id alone, so every machine assigns the commits of a range alike.
+func shardOf(hash string, count int) int {
+	h := fnv.New64a()
+	h.Write([]byte(hash))
+	return int(h.Sum64()%uint64(count)) + 1
+}
+
+// filter keeps the commits of hashes that belong to this shard, in their original order.
+func (s shardSpec) filter(hashes []string) []string {
+	var kept []string
+	for _, hash := range hashes {
+		if shardOf(hash, s.Count) == s.Index {
+			kept = append(kept, hash)
+		}
+	}
+	return kept
+}
+
+// reportPath returns the shard-labeled name of the report, e.g. gitaudit-shard-2-of-3.txt,
+// so that the shards of one audit can be collected into one directory.
+func (s shardSpec) reportPath(filename string) string {
+	return fmt.Sprintf("%s-shard-%d-of-%d%s", strings.TrimSuffix(filename, filepath.Ext(filename)), s.Index, s.Count, filepath.Ext(filename))
+}
+
+// manifestPath returns the coverage manifest written next to a shard's report.
+func (s shardSpec) manifestPath(reportFile string) string {
+	return strings.TrimSuffix(reportFile, filepath.Ext(reportFile)) + ".json"
+}
+
+// shardManifest is the coverage manifest of a sharded run, the input of merge-shards. Range
+// is the whole resolved range, newest first, so that merge-shards can check the shards cover
+// it; Parameters are the settings that shape the summaries, compared across shards.
+type shardManifest struct {
+	Version    int               `json:"version"`
+	Shard      int               `json:"shard"`
+	Shards     int               `json:"shards"`
+	Head       string            `json:"head"`
+	Boundary   string            `json:"boundary"`
+	Range      []string          `json:"range"`
+	Assigned   []string          `json:"assigned"`
+	Pending    []string          `json:"pending,omitempty"`
+	Parameters map[string]string `json:"parameters"`
+	Settings   string            `json:"settings"`
+	Entries    []CommitAuditData `json:"entries"`
+}
+
+// shardParameters collects the settings whose difference between shards makes their summaries
+// incomparable: the model and the prompt flags. The endpoint, paths and operational flags
+// legitimately differ between machines and are left out.
+func shardParameters(fs *flag.FlagSet, config *Config) map[string]string {
+	provider := config.Provider
+	if provider == "" {
+		provider = providerOllama
+	}
+	params := map[string]string{
+		"model":           provider + " " + configuredModel(config),
+		"prompt metadata": config.privacy().String(),
+		"never_send":      strings.Join(config.NeverSend, ","),
+	}
+	// Validated by loadConfig; the digest covers the shared glossary_file too.
+	if g, _ := newGlossary(config); g != nil {
+		params["glossary"] = g.Digest
+	}
+	machineLocal := map[string]bool{"record": true, "profile": true, "explain-flags": true, "debug": true, "compress-requests": true, "locale-file": true}
+	for _, name := range promptFlagNames {
+		if !machineLocal[name] {
+			params["-"+name] = fs.Lookup(name).Value.String()
+		}
+	}
+	return params
+}
+
+// promptFlagNames are the names of the prompt flags. They are collected before the command
+// line is parsed: defining the flags again would reset the globals that -debug,
+// -compress-requests and -lax-config set.
+var promptFlagNames = func() []string {
+	fs := flag.NewFlagSet("prompt", flag.ContinueOnError)
+	registerPromptFlags(fs)
+	var names []string
+	fs.VisitAll(func(f *flag.Flag) { names = append(names, f.Name) })
+	return names
+}()
+
+// writeShardManifest writes the manifest atomically and as readable as the report.
+func writeShardManifest(path string, manifest shardManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode shard manifest: %w", err)
+	}
+	if err := writeFileAtomic(path, append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write shard manifest %s: %w", path, err)
+	}
+	os.Chmod(path, 0o644)
+	return nil
+}
+
+// readShardManifest reads a manifest written by a -shard run.
+func readShardManifest(path string) (shardManifest, error) {
+	var manifest shardManifest
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return manifest, fmt.Errorf("failed to read shard manifest: %w", err)
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, fmt.Errorf("failed to parse shard manifest %s: %w", path, err)
+	}
+	if manifest.Version != shardManifestVersion {
+		return manifest, fmt.Errorf("%s is not a gitaudit shard manifest of version %d", path, shardManifestVersion)
+	}
+	return manifest, nil
+}
+
+// mergeShards validates that the manifests are the shards of one audit and merges their
+// entries into range order. Differences in the range or the shard layout are errors, as are
+// commits covered twice or not at all (unless allowIncomplete); differences in parameters
+// are returned as warnings for the merged header.
+func mergeShards(paths []string, manifests []shardManifest, allowIncomplete bool) ([]CommitAuditData, []string, []string, error) {
+	first := manifests[0]
+	seenShard := make(map[int]string)
+	for i, m := range manifests {
+		switch {
+		case m.Shards != first.Shards:
+			return nil, nil, nil, fmt.Errorf("%s is shard %d of %d, but %s is shard %d of %d", paths[i], m.Shard, m.Shards, paths[0], first.Shard, first.Shards)
+		case m.Head != first.Head || m.Boundary != first.Boundary || strings.Join(m.Range, " ") != strings.Join(first.Range, " "):
+			return nil, nil, nil, fmt.Errorf("%s audited a different range (%s back to %s, %d commits) than %s (%s back to %s, %d commits)",
+				paths[i], shortHash(m.Head), shortHash(m.Boundary), len(m.Range), paths[0], shortHash(first.Head), shortHash(first.Boundary), len(first.Range))
+		case seenShard[m.Shard] != "":
+			return nil, nil, nil, fmt.Errorf("%s and %s are both shard %d of %d", seenShard[m.Shard], paths[i], m.Shard, m.Shards)
+		}
+		seenShard[m.Shard] = paths[i]
+	}
+	var missingShards []string
+	for i := 1; i <= first.Shards; i++ {
+		if seenShard[i] == "" {
+			missingShards = append(missingShards, strconv.Itoa(i))
+		}
+	}
+	if len(missingShards) > 0 && !allowIncomplete {
+		return nil, nil, nil, fmt.Errorf("the manifests of shards %s of %d are missing", strings.Join(missingShards, ", "), first.Shards)
+	}
+
+	var warnings []string
+	for i, m := range manifests[1:] {
+		var names []string
+		for name := range first.Parameters {
+			names = append(names, name)
+		}
+		for name := range m.Parameters {
+			if _, ok := first.Parameters[name]; !ok {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if m.Parameters[name] != first.Parameters[name] {
+				warnings = append(warnings, fmt.Sprintf("shard %d used %s %q, but shard %d used %q (%s)", m.Shard, name, m.Parameters[name], first.Shard, first.Parameters[name], paths[i+1]))
+			}
+		}
+	}
+
+	position := make(map[string]int, len(first.Range))
+	for i, hash := range first.Range {
+		position[hash] = i
+	}
+	type keyed struct {
+		Entry CommitAuditData
+		Pos   int
+	}
+	var merged []keyed
+	coveredBy := make(map[string]string)
+	for i, m := range manifests {
+		for _, data := range m.Entries {
+			pos, inRange := position[data.Hash]
+			if !inRange {
+				return nil, nil, nil, fmt.Errorf("%s has an entry for %s, which is not in the range", paths[i], shortHash(data.Hash))
+			}
+			if data.Kind != kindTag {
+				if shardOf(data.Hash, m.Shards) != m.Shard {
+					return nil, nil, nil, fmt.Errorf("%s has an entry for %s, which belongs to shard %d", paths[i], shortHash(data.Hash), shardOf(data.Hash, m.Shards))
+				}
+				if other := coveredBy[data.Hash]; other != "" {
+					return nil, nil, nil, fmt.Errorf("commit %s is covered by both %s and %s", shortHash(data.Hash), other, paths[i])
+				}
+				coveredBy[data.Hash] = paths[i]
+			}
+			merged = append(merged, keyed{Entry: data, Pos: pos})
+		}
+	}
+	var missing []string
+	for _, hash := range first.Range {
+		if coveredBy[hash] == "" {
+			missing = append(missing, hash)
+		}
+	}
+	if len(missing) > 0 && !allowIncomplete {
+		return nil, nil, nil, fmt.Errorf("%d commits of the range have no entry in any shard, e.g. %s (pending in the shard's run, or a shard is missing); rerun those shards or pass -allow-incomplete", len(missing), shortHash(missing[0]))
+	}
+
+	// Tags go before the entry of their commit, as in an unsharded report.
+	sort.SliceStable(merged, func(i, j int) bool {
+		if merged[i].Pos != merged[j].Pos {
+			return merged[i].Pos < merged[j].Pos
+		}
+		return merged[i].Entry.Kind == kindTag && merged[j].Entry.Kind != kindTag
+	})
+	entries := make([]CommitAuditData, len(merged))
+	for i, k := range merged {
+		entries[i] = k.Entry
+	}
+	return entries, missing, warnings, nil
+}
+
+// mergeShardsFlags are the flags of `gitaudit merge-shards`.
+type mergeShardsFlags struct {
+	Output          string
+	AllowIncomplete bool
+	Locale          string
+	LocaleFile      string
+}
+
+// registerMergeShardsFlags defines the flags of `gitaudit merge-shards` on fs.
+func registerMergeShardsFlags(fs *flag.FlagSet) *mergeShardsFlags {
+	m := &mergeShardsFlags{}
+	fs.StringVar(&m.Output, "output", defaultOutputFileName, "Path of the merged report")
+	fs.BoolVar(&m.AllowIncomplete, "allow-incomplete", false, "Write the merged report even when shards or commits are missing, listing the missing commits in its header")
+	fs.StringVar(&m.Locale, "locale", defaultLocale, "Language of the merged report's labels and headings, e.g. \"fr\"")
+	fs.StringVar(&m.LocaleFile, "locale-file", "", "JSON file of report messages layered on -locale, to adjust a built-in locale or add one")
+	return m
+}
+
+// runMergeShards implements `gitaudit merge-shards`: it combines the manifests of a -shard
+// audit into one report.
+func runMergeShards(args []string) {
+	fs := newCommandFlagSet("merge-shards")
+	merge := registerMergeShardsFlags(fs)
+	// Flags may follow the manifests, as in `merge-shards a.json b.json -output all.txt`.
+	var paths []string
+	for {
+		fs.Parse(args)
+		if fs.NArg() == 0 {
+			break
+		}
+		paths = append(paths, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(paths) == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	if err := setReportLocale(merge.Locale, merge.LocaleFile); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	manifests := make([]shardManifest, len(paths))
+	for i, path := range paths {
+		var err error
+		if manifests[i], err = readShardManifest(path); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	entries, missing, warnings, err := mergeShards(paths, manifests, merge.AllowIncomplete)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	first := manifests[0]
+	var header strings.Builder
+	header.WriteString(msg("header.merged", len(manifests), first.Shards, shortHash(first.Head), shortHash(first.Boundary), msgCount("count.commits", len(first.Range))) + "\n")
+	for i, m := range manifests {
+		line := msg("header.merged_shard", m.Shard, filepath.Base(paths[i]), msgCount("count.entries", len(m.Entries)))
+		if len(m.Pending) > 0 {
+			line += ", " + msg("header.merged_pending", len(m.Pending))
+		}
+		header.WriteString(line + "\n")
+	}
+	for _, warning := range warnings {
+		fmt.Printf("Warning: %s\n", warning)
+		header.WriteString(msg("header.warning", warning) + "\n")
+	}
+	if len(missing) > 0 {
+		fmt.Printf("Warning: %d commits of the range have no entry (-allow-incomplete).\n", len(missing))
+		header.WriteString(msg("header.missing", msgCount("count.commits", len(missing)), strings.Join(missing, " ")) + "\n")
+	}
+	if first.Settings != "" {
+		fmt.Fprintf(&header, "\n%s\n%s", msg("header.shard_settings", first.Shard), first.Settings)
+	}
+
+	if err := writeMessagesToFile(merge.Output, entries, strings.TrimRight(header.String(), "\n")); err != nil {
+		fmt.Printf("Error writing merged report: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Merged %d entries from %d shards into %s\n", len(entries), len(manifests), merge.Output)
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseShardSpec(t *testing.T) {
+	for value, want := range map[string]shardSpec{"": {}, "1/1": {1, 1}, "2/3": {2, 3}, " 3 / 3 ": {3, 3}} {
+		if got, err := parseShardSpec(value); err != nil || got != want {
+			t.Errorf("parseShardSpec(%q) = %v, %v; want %v", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"3", "0/3", "4/3", "1/0", "-1/2", "a/b", "1/2/3"} {
+		if _, err := parseShardSpec(value); err == nil || !strings.Contains(err.Error(), "expected i/N with 1 <= i <= N") {
+			t.Errorf("parseShardSpec(%q) = %v", value, err)
+		}
+	}
+	if s := (shardSpec{2, 3}); s.reportPath("out/audit.txt") != "out/audit-shard-2-of-3.txt" || s.manifestPath("out/audit-shard-2-of-3.txt") != "out/audit-shard-2-of-3.json" {
+		t.Errorf("paths %s, %s", s.reportPath("out/audit.txt"), s.manifestPath("out/audit-shard-2-of-3.txt"))
+	}
+}
+
+func TestShardAssignment(t *testing.T) {
+	var hashes []string
+	for i := 0; i < 300; i++ {
+		hashes = append(hashes, fmt.Sprintf("%040x", i*7919))
+	}
+	// Every commit is in exactly one shard, whatever the order the range is listed in, and the
+	// shards are of similar size.
+	seen := make(map[string]int)
+	for index := 1; index <= 3; index++ {
+		kept := shardSpec{index, 3}.filter(hashes)
+		if len(kept) < 70 || len(kept) > 130 {
+			t.Errorf("shard %d of 3 has %d of 300 commits", index, len(kept))
+		}
+		for _, hash := range kept {
+			seen[hash]++
+			if shardOf(hash, 3) != index {
+				t.Errorf("%s filtered into shard %d but assigned to %d", hash, index, shardOf(hash, 3))
+			}
+		}
+	}
+	for _, hash := range hashes {
+		if seen[hash] != 1 {
+			t.Errorf("%s is in %d shards", hash, seen[hash])
+		}
+	}
+	// Known values pin the hash function: changing it would split a range differently on
+	// machines running different versions.
+	for hash, want := range map[string][2]int{
+		"6b034178fade41a7854d7ee2b9e3888941f72270": {1, 4},
+		"20703b4b3cb8548e768599a1e5be0203662371f9": {2, 2},
+		"32a427d2b21008953b1c14a8ff6bbf0c12f0f7ca": {2, 1},
+	} {
+		if got := [2]int{shardOf(hash, 3), shardOf(hash, 7)}; got != want {
+			t.Errorf("shardOf(%s) of 3 and 7 = %v, want %v", hash, got, want)
+		}
+	}
+}
+
+// testManifests shards the range r, of commits with their own hash as summary, n ways.
+func testManifests(r []string, n int) ([]string, []shardManifest) {
+	var paths []string
+	var manifests []shardManifest
+	for index := 1; index <= n; index++ {
+		s := shardSpec{index, n}
+		m := shardManifest{Version: shardManifestVersion, Shard: index, Shards: n, Head: r[0], Boundary: "root", Range: r,
+			Assigned: s.filter(r), Parameters: map[string]string{"model": "ollama tiny:0.5b"}}
+		for _, hash := range m.Assigned {
+			m.Entries = append(m.Entries, CommitAuditData{Hash: hash, Summary: "Summary of " + hash})
+		}
+		paths = append(paths, s.reportPath("audit.json"))
+		manifests = append(manifests, m)
+	}
+	return paths, manifests
+}
+
+func TestMergeShards(t *testing.T) {
+	var r []string
+	for i := 0; i < 20; i++ {
+		r = append(r, fmt.Sprintf("%040x", i+1))
+	}
+	paths, manifests := testManifests(r, 3)
+	entries, missing, warnings, err := mergeShards(paths, manifests, false)
+	if err != nil || len(missing) != 0 || len(warnings) != 0 {
+		t.Fatalf("mergeShards: %v, missing %q, warnings %q", err, missing, warnings)
+	}
+	var merged []string
+	for _, e := range entries {
+		merged = append(merged, e.Hash)
+	}
+	if !reflect.DeepEqual(merged, r) {
+		t.Errorf("merged entries are not in range order:\n%q", merged)
+	}
+
+	// Tags go before the entry of their commit.
+	withTag := append([]shardManifest(nil), manifests...)
+	tagged := shardOf(r[5], 3) - 1
+	withTag[tagged].Entries = append(append([]CommitAuditData(nil), withTag[tagged].Entries...), CommitAuditData{Hash: r[5], Kind: kindTag, Summary: "v1.0"})
+	if entries, _, _, err := mergeShards(paths, withTag, false); err != nil || entries[5].Kind != kindTag || entries[6].Hash != r[5] {
+		t.Errorf("tag entry misplaced: %v", err)
+	}
+
+	// A different model is a warning for the header, not an error.
+	changed := append([]shardManifest(nil), manifests...)
+	changed[2].Parameters = map[string]string{"model": "ollama llama3:8b", "-cite": "true"}
+	_, _, warnings, err = mergeShards(paths, changed, false)
+	want := []string{
+		`shard 3 used -cite "true", but shard 1 used "" (audit-shard-3-of-3.json)`,
+		`shard 3 used model "ollama llama3:8b", but shard 1 used "ollama tiny:0.5b" (audit-shard-3-of-3.json)`,
+	}
+	if err != nil || !reflect.DeepEqual(warnings, want) {
+		t.Errorf("parameter warnings %q, %v", warnings, err)
+	}
+
+	broken := func(edit func(m []shardManifest) []shardManifest, allowIncomplete bool, wantErr string) {
+		t.Helper()
+		copies := make([]shardManifest, len(manifests))
+		for i, m := range manifests {
+			copies[i] = m
+			copies[i].Entries = append([]CommitAuditData(nil), m.Entries...)
+		}
+		copies = edit(copies)
+		_, _, _, err := mergeShards(paths[:len(copies)], copies, allowIncomplete)
+		if err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("mergeShards = %v, want %q", err, wantErr)
+		}
+	}
+	broken(func(m []shardManifest) []shardManifest { m[1].Shards = 4; return m }, false, "is shard 2 of 4, but audit-shard-1-of-3.json is shard 1 of 3")
+	broken(func(m []shardManifest) []shardManifest { m[1].Range = r[1:]; return m }, false, "audited a different range")
+	broken(func(m []shardManifest) []shardManifest { m[2].Shard = 1; return m }, false, "are both shard 1 of 3")
+	broken(func(m []shardManifest) []shardManifest { return m[:2] }, false, "the manifests of shards 3 of 3 are missing")
+	broken(func(m []shardManifest) []shardManifest {
+		m[0].Entries = append(m[0].Entries, CommitAuditData{Hash: strings.Repeat("f", 40)})
+		return m
+	}, false, "which is not in the range")
+	broken(func(m []shardManifest) []shardManifest {
+		m[0].Entries = append(m[0].Entries, m[1].Entries[0])
+		return m
+	}, false, "which belongs to shard 2")
+	broken(func(m []shardManifest) []shardManifest { m[1].Entries = m[1].Entries[1:]; return m }, false, "1 commits of the range have no entry in any shard")
+
+	// -allow-incomplete merges what there is and returns the rest as missing.
+	entries, missing, _, err = mergeShards(paths[:2], manifests[:2], true)
+	if err != nil || len(entries)+len(missing) != len(r) || !reflect.DeepEqual(missing, manifests[2].Assigned) {
+		t.Errorf("incomplete merge: %d entries, missing %q, %v", len(entries), missing, err)
+	}
+}
+
+func TestShardedRunMatchesUnsharded(t *testing.T) {
+	repo := newFixtureRepo(t)
+	repo.commits(20)
+	env := newAuditEnv(t)
+
+	// The unsharded run, as a JSON document and a text report.
+	unshardedJSON := filepath.Join(env.Work, "unsharded.json")
+	env.mustRun("-repo", repo.Dir, "-commit", "root", "-format", "json", "-output", unshardedJSON)
+	var document jsonReport
+	if err := json.Unmarshal([]byte(readFile(t, unshardedJSON)), &document); err != nil {
+		t.Fatal(err)
+	}
+	unshardedText := filepath.Join(env.Work, "unsharded.txt")
+	env.mustRun("-repo", repo.Dir, "-commit", "root", "-output", unshardedText)
+	prompts := len(env.Ollama.Prompts())
+
+	// Three machines, each with its own output directory.
+	var manifestPaths []string
+	assigned := 0
+	for index := 1; index <= 3; index++ {
+		dir := filepath.Join(env.Work, fmt.Sprintf("machine%d", index))
+		out := env.mustRun("-repo", repo.Dir, "-commit", "root", "-shard", fmt.Sprintf("%d/3", index), "-output", filepath.Join(dir, "gitaudit.txt"))
+		manifest := filepath.Join(dir, fmt.Sprintf("gitaudit-shard-%d-of-3.json", index))
+		m, err := readShardManifest(manifest)
+		if err != nil {
+			t.Fatalf("shard %d: %v\n%s", index, err, out)
+		}
+		if !strings.Contains(out, fmt.Sprintf("Shard %d of 3: %d of 20 commits in the range\n", index, len(m.Assigned))) {
+			t.Errorf("shard %d header:\n%s", index, out)
+		}
+		if _, err := os.Stat(filepath.Join(dir, fmt.Sprintf("gitaudit-shard-%d-of-3.txt", index))); err != nil {
+			t.Errorf("shard %d report: %v", index, err)
+		}
+		assigned += len(m.Assigned)
+		manifestPaths = append(manifestPaths, manifest)
+	}
+	// Each commit was sent to the model by one machine only.
+	if assigned != 20 || len(env.Ollama.Prompts())-prompts != 20 {
+		t.Errorf("%d commits assigned and %d prompts sent by the shards, want 20", assigned, len(env.Ollama.Prompts())-prompts)
+	}
+
+	combined := filepath.Join(env.Work, "combined.txt")
+	out := env.mustRun(append(append([]string{"merge-shards"}, manifestPaths...), "-output", combined)...)
+	if !strings.Contains(out, "Merged 20 entries from 3 shards into "+combined) || strings.Contains(out, "Warning:") {
+		t.Errorf("merge-shards output:\n%s", out)
+	}
+
+	// The merged entries are the unsharded run's, in the same order.
+	manifests := make([]shardManifest, len(manifestPaths))
+	for i, path := range manifestPaths {
+		manifests[i], _ = readShardManifest(path)
+	}
+	entries, _, _, err := mergeShards(manifestPaths, manifests, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(document.Commits) {
+		t.Fatalf("%d merged entries, %d unsharded", len(entries), len(document.Commits))
+	}
+	for i, e := range entries {
+		u := document.Commits[i]
+		if e.Hash != u.Hash || e.Summary != u.Summary || e.Author != u.Author || e.Date != u.Date {
+			t.Errorf("entry %d: merged %s %q, unsharded %s %q", i, shortHash(e.Hash), e.Summary, shortHash(u.Hash), u.Summary)
+		}
+	}
+	if got, want := reportEntries(t, readFile(t, combined)), reportEntries(t, readFile(t, unshardedText)); got != want {
+		t.Errorf("merged report entries:\n%s\nwant the unsharded report's:\n%s", got, want)
+	}
+	if !strings.Contains(readFile(t, combined), "=== Merged report: 3 of 3 shards") {
+		t.Errorf("merged report header:\n%s", readFile(t, combined))
+	}
+
+	// A shard run with another model is merged with a warning in the header.
+	env.Config["ollama_model"] = "other:1b"
+	env.mustRun("-repo", repo.Dir, "-commit", "root", "-shard", "3/3", "-force", "-output", filepath.Join(env.Work, "machine3", "gitaudit.txt"))
+	out = env.mustRun(append(append([]string{"merge-shards"}, manifestPaths...), "-output", combined)...)
+	if !strings.Contains(out, `Warning: shard 3 used model "ollama other:1b", but shard 1 used "ollama tiny:0.5b"`) ||
+		!strings.Contains(readFile(t, combined), `shard 3 used model "ollama other:1b"`) {
+		t.Errorf("model mismatch not warned about:\n%s", out)
+	}
+}
+
+// reportEntries returns the entries of a text report, from the first "Commit:" line on.
+func reportEntries(t *testing.T, text string) string {
+	t.Helper()
+	i := strings.Index(text, "\nCommit: ")
+	if i < 0 {
+		t.Fatalf("report without entries:\n%s", text)
+	}
+	return text[i+1:]
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Modes of -split-by.
+const (
+	splitByMonth = "month"
+	splitByWeek  = "week"
+	splitByCount = "count"
+)
+
+// splitPolicy says how -split-by shards the report: by the month or ISO week of each entry's
+// date, or into files of Count entries. The zero policy writes a single report.
+type splitPolicy struct {
+	Mode  string
+	Count int
+}
+
+// parseSplitPolicy parses -split-by: "month", "week" or "count:N". An empty value disables
+// splitting.
+func parseSplitPolicy(value string) (splitPolicy, error) {
+	switch {
+	case value == "":
+		return splitPolicy{}, nil
+	case value == splitByMonth || value == splitByWeek:
+		return splitPolicy{Mode: value}, nil
+	case strings.HasPrefix(value, splitByCount+":"):
+		n, err := strconv.Atoi(strings.TrimPrefix(value, splitByCount+":"))
+		if err != nil || n <= 0 {
+			return splitPolicy{}, fmt.Errorf("-split-by count needs a positive number of entries per file, e.g. count:500, got %q", value)
+		}
+		return splitPolicy{Mode: splitByCount, Count: n}, nil
+	}
+	return splitPolicy{}, fmt.Errorf("invalid -split-by value %q: expected \"month\", \"week\" or \"count:N\"", value)
+}
+
+// String describes the policy for the index header in the locale of the report, e.g.
+// "month" or "500 entries per file".
+func (p splitPolicy) String() string {
+	switch p.Mode {
+	case splitByCount:
+		return msg("split.count", msgCount("count.entries", p.Count))
+	case splitByWeek:
+		return msg("split.week")
+	}
+	return msg("split.month")
+}
+
+// splitUndated labels the shard of entries without a plausible date.
+const splitUndated = "undated"
+
+// reportShard is one file of a split report.
+type reportShard struct {
+	Label   string // "2024-06", "2024-W23" or "part-003".
+	Path    string
+	Entries []CommitAuditData
+}
+
+// entryDateLayout is the layout of git's %ai and %ci dates, which entries carry.
+const entryDateLayout = "2006-01-02 15:04:05 -0700"
+
+// shardReport assigns the entries to shards, keeping their report order within each shard.
+// Shards are listed in the order of their first entry, so a newest-first report yields
+// newest-first shards; a month or week without entries has no shard. An entry without a
+// usable date (a lightweight tag marker) stays in the shard of the entry before it. Entries
+// whose date is suspect (see dateCheck) would open a month or week of their own far from the
+// others, and go to the "undated" shard instead.
+func shardReport(entries []CommitAuditData, policy splitPolicy, filename string) []reportShard {
+	labels := make([]string, len(entries))
+	for i, data := range entries {
+		switch {
+		case policy.Mode == splitByCount:
+			labels[i] = fmt.Sprintf("part-%03d", i/policy.Count+1)
+		case data.DateSuspect != "":
+			labels[i] = splitUndated
+		default:
+			t, err := time.Parse(entryDateLayout, strings.TrimSpace(data.Date))
+			if err != nil {
+				continue
+			}
+			if policy.Mode == splitByWeek {
+				year, week := t.ISOWeek()
+				labels[i] = fmt.Sprintf("%d-W%02d", year, week)
+			} else {
+				labels[i] = t.Format("2006-01")
+			}
+		}
+	}
+	for i := range labels {
+		if labels[i] == "" && i > 0 {
+			labels[i] = labels[i-1]
+		}
+	}
+	for i := len(labels) - 1; i >= 0; i-- {
+		if labels[i] == "" && i+1 < len(labels) {
+			labels[i] = labels[i+1]
+		}
+	}
+
+	base := strings.TrimSuffix(filename, filepath.Ext(filename))
+	ext := filepath.Ext(filename)
+	var shards []reportShard
+	index := make(map[string]int)
+	for i, data := range entries {
+		label := labels[i]
+		if label == "" {
+			label = splitUndated // No entry has a date at all.
+		}
+		n, ok := index[label]
+		if !ok {
+			n = len(shards)
+			index[label] = n
+			shards = append(shards, reportShard{Label: label, Path: base + "-" + label + ext})
+		}
+		shards[n].Entries = append(shards[n].Entries, data)
+	}
+	return shards
+}
+
+// splitIndexPath returns the index file of a split report, e.g. gitaudit-index.txt.
+func splitIndexPath(filename string) string {
+	return strings.TrimSuffix(filename, filepath.Ext(filename)) + "-index" + filepath.Ext(filename)
+}
+
+// commitRange describes the commits of a shard as "<oldest>..<newest>" short hashes, or a
+// single hash. Entries are in report order, newest first.
+func (s reportShard) commitRange() string {
+	var hashes []string
+	for _, data := range s.Entries {
+		if data.Hash != "" && data.Kind != kindTag {
+			hashes = append(hashes, shortHash(data.Hash))
+		}
+	}
+	switch len(hashes) {
+	case 0:
+		return "-"
+	case 1:
+		return hashes[0]
+	}
+	return hashes[len(hashes)-1] + ".." + hashes[0]
+}
+
+// writeReport writes the report to filename, or with a split policy, to one file per shard
+// plus an index file. Every file is written atomically, so a checkpoint or an interrupted run
+// leaves complete shards. It returns the file the author rollup belongs in: the report, or
+// the index of a split report.
+func writeReport(filename string, entries []CommitAuditData, header string, policy splitPolicy) (string, error) {
+	if policy.Mode == "" {
+		return filename, writeMessagesToFile(filename, entries, header)
+	}
+	shards := shardReport(entries, policy, filename)
+	indexPath := splitIndexPath(filename)
+	var index strings.Builder
+	index.WriteString(msg("header.index", msgCount("count.entries", len(entries)), msgCount("count.files", len(shards)), policy) + "\n\n")
+	if header != "" {
+		index.WriteString(header + "\n\n")
+	}
+	for i, shard := range shards {
+		shardHeader := msg("header.part", shard.Label, i+1, len(shards), filepath.Base(indexPath))
+		if header != "" {
+			shardHeader += "\n\n" + header
+		}
+		if err := writeMessagesToFile(shard.Path, shard.Entries, shardHeader); err != nil {
+			return indexPath, err
+		}
+		fmt.Fprintf(&index, "%-30s %-10s %15s %s\n", filepath.Base(shard.Path), shard.Label, msgCount("count.entries", len(shard.Entries)), shard.commitRange())
+	}
+	stale := staleShards(indexPath, shards)
+	if err := writeFileAtomic(indexPath, []byte(index.String())); err != nil {
+		return indexPath, fmt.Errorf("failed to write report index %s: %w", indexPath, err)
+	}
+	for _, path := range stale {
+		os.Remove(path)
+	}
+	// writeFileAtomic uses CreateTemp's 0600; the index is as readable as the shards.
+	os.Chmod(indexPath, 0o644)
+	return indexPath, nil
+}
+
+// staleShards lists the shards named by the previous index at indexPath that the new report
+// does not have, e.g. after switching from -split-by month to week, so that the directory
+// holds no parts that no index refers to.
+func staleShards(indexPath string, shards []reportShard) []string {
+	data, err := os.ReadFile(indexPath)
+	if err != nil {
+		return nil
+	}
+	current := make(map[string]bool)
+	for _, shard := range shards {
+		current[filepath.Base(shard.Path)] = true
+	}
+	prefix := strings.TrimSuffix(filepath.Base(indexPath), "-index"+filepath.Ext(indexPath)) + "-"
+	var stale []string
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !strings.HasPrefix(fields[0], prefix) || filepath.Ext(fields[0]) != filepath.Ext(indexPath) || current[fields[0]] {
+			continue
+		}
+		stale = append(stale, filepath.Join(filepath.Dir(indexPath), fields[0]))
+	}
+	return stale
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// kindPatch marks entries for patches read from stdin by `gitaudit patch`.
+const kindPatch = "patch"
+
+// mboxDelimiter matches the line that starts each message of `git format-patch` output,
+// e.g. "From 3f2a91c0... Mon Sep 17 00:00:00 2001".
+var mboxDelimiter = regexp.MustCompile(`^From ([0-9a-f]{40}) `)
+
+// hunkHeader matches a unified diff hunk header and captures its line ranges.
+var hunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// stdinPatch is one patch read from stdin, with the metadata its mail headers carried.
+type stdinPatch struct {
+	// Line is the line of the input the patch starts on.
+	Line    int
+	Hash    string
+	Author  string
+	Date    string
+	Subject string
+	// Text is the whole patch, headers and message included, as sent to the model.
+	Text string
+}
+
+// registerPatchFlags defines the flags of `gitaudit patch` besides the prompt flags on fs and
+// returns -retries.
+func registerPatchFlags(fs *flag.FlagSet) *int {
+	return fs.Int("retries", 2, "Number of additional attempts after a failed generation before giving up")
+}
+
+// runPatch implements `gitaudit patch [flags] < file`: it summarizes each patch of a unified
+// diff or `git format-patch` mbox read from stdin and prints the entries, without a repository.
+func runPatch(args []string) {
+	fs := newCommandFlagSet("patch")
+	retries := registerPatchFlags(fs)
+	prompt := registerPromptFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fmt.Printf("Error: unexpected arguments: %s (the patch is read from stdin)\n", strings.Join(fs.Args(), " "))
+		fs.Usage()
+		os.Exit(1)
+	}
+	if err := applyProfile(fs, prompt.Profile); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	prompt.setConfigOverrides()
+	validateFlags(fs, lookupCommand("patch").Rules)
+	if prompt.ExplainFlags {
+		explainFlags(fs)
+		return
+	}
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		fmt.Println("Error: pipe a patch into gitaudit patch, e.g. gitaudit patch < fix.patch")
+		os.Exit(1)
+	}
+
+	patches, err := parsePatches(os.Stdin)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Printf("Error loading configuration: %v\n", err)
+		os.Exit(1)
+	}
+	if err := prompt.selectModel(config); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	opts, err := prompt.auditOptions("", config)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	// There is no repository to keep the caches and recordings out of.
+	opts.Cache = openCacheStore("", false)
+	if err := prompt.discoverModelInfo(opts); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := prompt.prepareRecordDir("", false); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	for i, patch := range patches {
+		label := fmt.Sprintf("stdin patch %d of %d (line %d)", i+1, len(patches), patch.Line)
+		target := auditTarget{Ref: label, Message: patch.Subject, Patch: patch.promptText(opts.Privacy)}
+		state := &commitState{}
+		var auditData CommitAuditData
+		for attempt := 0; ; attempt++ {
+			auditData = CommitAuditData{}
+			extras := applyControlWatchlist(controlWatchlist(config), patchHeaderPaths(patch.Text), promptExtras{}, &auditData)
+			extras = applyLicenseCheck(patchHeaderPaths(patch.Text), scanLicenseHeaders(patch.Text), extras, &auditData)
+			auditData.ChangeCategory = opts.Categories.classify(patchHeaderPaths(patch.Text))
+			err = generateSummary(requestCtx, opts, label, target, nil, state.detailLevel(opts.Ladder), extras, &auditData)
+			if err == nil {
+				break
+			}
+			exitOnPolicyViolation(err)
+			if attempt >= *retries || isPermanent(err) {
+				fmt.Printf("Error: failed to summarize %s after %d attempts: %v\n", label, attempt+1, err)
+				os.Exit(1)
+			}
+			fmt.Printf("Attempt %d for %s failed: %v. Retrying.\n", attempt+1, label, err)
+			state.recordFailure(label, err, opts.Ladder, opts.DegradeAfter)
+		}
+		auditData.Kind = kindPatch
+		auditData.Hash = patch.Hash
+		auditData.Author = patch.Author
+		auditData.Date = patch.Date
+		auditData.Ref = label
+		opts.Hook.Enrich(&auditData)
+
+		if i > 0 {
+			fmt.Println("---")
+		}
+		// A plain diff carries no metadata; say so rather than print empty header lines.
+		shown := auditData
+		for _, field := range []*string{&shown.Hash, &shown.Author, &shown.Date} {
+			if *field == "" {
+				*field = "(not in patch)"
+			}
+		}
+		fmt.Print(formatCommitEntry(shown))
+	}
+	opts.Cache.RecordRun("patch")
+}
+
+// parsePatches splits input into patches on the "From <hash>" lines that start each message
+// of an mbox, reads the From, Date and Subject headers of each, and checks that every hunk is
+// well formed. Input without mbox delimiters is a single plain diff.
+func parsePatches(r io.Reader) ([]stdinPatch, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read the patch from stdin: %w", err)
+	}
+
+	var starts []int
+	for i, line := range lines {
+		if mboxDelimiter.MatchString(line) {
+			starts = append(starts, i)
+		}
+	}
+	if len(starts) == 0 || strings.TrimSpace(strings.Join(lines[:starts[0]], "")) != "" {
+		// A plain diff, or text before the first message that is a patch of its own.
+		starts = append([]int{0}, starts...)
+	}
+
+	var patches []stdinPatch
+	for n, start := range starts {
+		end := len(lines)
+		if n+1 < len(starts) {
+			end = starts[n+1]
+		}
+		patch, err := parsePatch(lines[start:end], start+1)
+		if err != nil {
+			return nil, err
+		}
+		patches = append(patches, patch)
+	}
+	if len(patches) == 0 {
+		return nil, fmt.Errorf("no patch on stdin")
+	}
+	return patches, nil
+}
+
+// parsePatch reads one patch, whose first line is line firstLine of the input.
+func parsePatch(lines []string, firstLine int) (stdinPatch, error) {
+	patch := stdinPatch{Line: firstLine, Text: strings.Join(lines, "\n") + "\n"}
+	if strings.TrimSpace(patch.Text) == "" {
+		return patch, fmt.Errorf("line %d: empty patch", firstLine)
+	}
+
+	body := 0
+	if m := mboxDelimiter.FindStringSubmatch(lines[0]); m != nil {
+		patch.Hash = m[1]
+		body = parseMailHeaders(lines, &patch)
+	}
+	if err := validateHunks(lines[body:], firstLine+body); err != nil {
+		return patch, err
+	}
+	return patch, nil
+}
+
+// parseMailHeaders reads the From, Date and Subject headers following an mbox delimiter,
+// joining folded continuation lines, and returns the index of the first line of the body.
+func parseMailHeaders(lines []string, patch *stdinPatch) int {
+	var last *string
+	for i := 1; i < len(lines); i++ {
+		line := lines[i]
+		if line == "" {
+			return i + 1
+		}
+		if (line[0] == ' ' || line[0] == '\t') && last != nil {
+			*last += " " + strings.TrimSpace(line)
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return i // Not a header; the body starts here.
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(name) {
+		case "from":
+			patch.Author, last = value, &patch.Author
+		case "date":
+			patch.Date, last = value, &patch.Date
+		case "subject":
+			patch.Subject, last = strings.TrimSpace(strings.TrimPrefix(value, "[PATCH]")), &patch.Subject
+		default:
+			last = nil
+		}
+	}
+	return len(lines)
+}
+
+// validateHunks checks that every hunk has as many lines as its header announces, so that a
+// truncated or hand-mangled patch is reported instead of summarized. firstLine is the input
+// line number of lines[0].
+func validateHunks(lines []string, firstLine int) error {
+	hunks, files := 0, 0
+	oldLeft, newLeft := 0, 0
+	for i, line := range lines {
+		lineNo := firstLine + i
+		if oldLeft > 0 || newLeft > 0 {
+			switch {
+			case line == "" || line[0] == ' ':
+				// Some mailers strip the space of empty context lines.
+				oldLeft--
+				newLeft--
+			case line[0] == '-':
+				oldLeft--
+			case line[0] == '+':
+				newLeft--
+			case line[0] == '\\':
+				// "\ No newline at end of file"
+			default:
+				return fmt.Errorf("line %d: unexpected %q inside a hunk that still expects %d old and %d new lines", lineNo, truncateLine(line), max(oldLeft, 0), max(newLeft, 0))
+			}
+			if oldLeft < 0 || newLeft < 0 {
+				return fmt.Errorf("line %d: hunk has more lines than its header announces", lineNo)
+			}
+			continue
+		}
+		switch {
+		case strings.HasPrefix(line, "diff --git "), strings.HasPrefix(line, "--- "):
+			files++
+		case strings.HasPrefix(line, "@@"):
+			m := hunkHeader.FindStringSubmatch(line)
+			if m == nil {
+				return fmt.Errorf("line %d: malformed hunk header %q", lineNo, truncateLine(line))
+			}
+			oldLeft, newLeft = hunkLength(m[2]), hunkLength(m[4])
+			hunks++
+		}
+	}
+	if oldLeft > 0 || newLeft > 0 {
+		return fmt.Errorf("line %d: patch ends inside a hunk that still expects %d old and %d new lines", firstLine+len(lines)-1, oldLeft, newLeft)
+	}
+	if hunks == 0 && files == 0 {
+		return fmt.Errorf("line %d: no diff found in the patch starting here", firstLine)
+	}
+	return nil
+}
+
+// hunkLength parses the line count of a hunk range, which defaults to 1 when omitted.
+func hunkLength(count string) int {
+	if count == "" {
+		return 1
+	}
+	n, _ := strconv.Atoi(count) // The header regexp only matches digits.
+	return n
+}
+
+// truncateLine shortens a line quoted in a parse error.
+func truncateLine(line string) string {
+	if len(line) > 60 {
+		return line[:60] + "..."
+	}
+	return line
+}
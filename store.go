@@ -0,0 +1,599 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The cache store keeps everything gitaudit caches between runs in one content-addressable
+// layout under $XDG_CACHE_HOME/gitaudit/store:
+//
+//	blobs/<2 hex>/<sha256>  the cached data, immutable and shared by equal contents
+//	index/<2 hex>.json      cache keys mapped to blob ids, sharded by the key's digest
+//	index/<2 hex>.lock      serializes the updates of one shard
+//	runs.json               the hit and miss counts of the last runs
+//
+// Every file is written to a temporary file and renamed into place, so a reader never sees a
+// partial file; a reader that loses a blob to a concurrent gc sees a cache miss.
+
+const (
+	// storeRunHistory is how many runs' hit rates `gitaudit cache stats` reports.
+	storeRunHistory = 10
+	// storeInUseGrace protects entries used this recently, and unreferenced blobs written this
+	// recently, from gc: they may belong to a run still in progress.
+	storeInUseGrace = time.Hour
+	// storeLockTimeout bounds the wait for a shard lock.
+	storeLockTimeout = 10 * time.Second
+)
+
+// Kinds of cache entries.
+const (
+	cacheKindModelInfo = "model-info"
+	cacheKindOSV       = "osv"
+	cacheKindTokenizer = "tokenizer"
+)
+
+// cacheKey identifies a cache entry. Fields that do not apply to a kind are empty; Digest
+// identifies the cached question within its kind (e.g. a prompt digest, or the package
+// version of an OSV query).
+type cacheKey struct {
+	Kind   string
+	Repo   string
+	Commit string
+	Model  string
+	Digest string
+}
+
+// id returns the digest of the key, which also selects its index shard.
+func (k cacheKey) id() string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{k.Kind, k.Repo, k.Commit, k.Model, k.Digest}, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// storeEntry is one key of an index shard.
+type storeEntry struct {
+	Kind     string    `json:"kind"`
+	Blob     string    `json:"blob"`
+	Size     int64     `json:"size"`
+	Created  time.Time `json:"created"`
+	Accessed time.Time `json:"accessed"`
+}
+
+// storeRun is the cache activity of one run.
+type storeRun struct {
+	Started time.Time `json:"started"`
+	Command string    `json:"command"`
+	Hits    int       `json:"hits"`
+	Misses  int       `json:"misses"`
+}
+
+// cacheStore is the on-disk cache shared by the cache consumers (model info, OSV answers,
+// BPE encodings). A nil store caches nothing, so consumers need no special case when
+// caching is unavailable.
+type cacheStore struct {
+	Dir string
+
+	mu      sync.Mutex // Serializes shard updates within the process; the lockfile covers other processes.
+	started time.Time
+	hits    int
+	misses  int
+}
+
+// defaultStoreDir returns $XDG_CACHE_HOME/gitaudit/store.
+func defaultStoreDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate cache directory: %w", err)
+	}
+	return filepath.Join(dir, "gitaudit", "store"), nil
+}
+
+// openCacheStore returns the cache store for a run, or nil with a warning when there is no
+// cache directory or it would be inside the audited repository under -no-repo-writes.
+func openCacheStore(repoRoot string, noRepoWrites bool) *cacheStore {
+	dir, err := defaultStoreDir()
+	if err != nil {
+		fmt.Printf("Warning: caching disabled: %v\n", err)
+		return nil
+	}
+	if noRepoWrites {
+		if err := checkOutsideRepo(dir, repoRoot, "cache store"); err != nil {
+			fmt.Printf("Warning: caching disabled: %v\n", err)
+			return nil
+		}
+	}
+	return &cacheStore{Dir: dir, started: time.Now()}
+}
+
+// Get returns the data cached under key if it was stored less than maxAge ago (any age when
+// maxAge is 0), and marks the entry as used.
+func (s *cacheStore) Get(key cacheKey, maxAge time.Duration) ([]byte, bool) {
+	if s == nil {
+		return nil, false
+	}
+	id := key.id()
+	var entry storeEntry
+	found := false
+	err := s.updateShard(id[:2], func(entries map[string]storeEntry) bool {
+		entry, found = entries[id]
+		if !found || (maxAge > 0 && time.Since(entry.Created) >= maxAge) {
+			found = false
+			return false
+		}
+		entry.Accessed = time.Now()
+		entries[id] = entry
+		return true
+	})
+	if err != nil {
+		debugf("cache lookup failed: %v", err)
+	}
+	if found {
+		if data, err := os.ReadFile(s.blobPath(entry.Blob)); err == nil {
+			s.count(true)
+			return data, true
+		}
+	}
+	s.count(false)
+	return nil, false
+}
+
+// Put stores data under key, replacing any earlier entry.
+func (s *cacheStore) Put(key cacheKey, data []byte) error {
+	if s == nil {
+		return nil
+	}
+	sum := sha256.Sum256(data)
+	blob := hex.EncodeToString(sum[:])
+	path := s.blobPath(blob)
+	if _, err := os.Stat(path); err != nil {
+		if err := writeFileAtomic(path, data); err != nil {
+			return fmt.Errorf("failed to write cache blob: %w", err)
+		}
+	} else {
+		// Refresh the blob so that gc does not take it for a stale unreferenced one.
+		now := time.Now()
+		os.Chtimes(path, now, now)
+	}
+	id := key.id()
+	now := time.Now()
+	return s.updateShard(id[:2], func(entries map[string]storeEntry) bool {
+		entries[id] = storeEntry{Kind: key.Kind, Blob: blob, Size: int64(len(data)), Created: now, Accessed: now}
+		return true
+	})
+}
+
+// count records a lookup for the run's hit rate.
+func (s *cacheStore) count(hit bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if hit {
+		s.hits++
+	} else {
+		s.misses++
+	}
+}
+
+// RecordRun appends this run's hits and misses to the history shown by `gitaudit cache stats`.
+// Runs without lookups are not recorded.
+func (s *cacheStore) RecordRun(command string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	run := storeRun{Started: s.started, Command: command, Hits: s.hits, Misses: s.misses}
+	s.mu.Unlock()
+	if run.Hits+run.Misses == 0 {
+		return
+	}
+	err := s.withLock("runs", func() error {
+		runs := s.readRuns()
+		runs = append(runs, run)
+		if len(runs) > storeRunHistory {
+			runs = runs[len(runs)-storeRunHistory:]
+		}
+		data, err := json.MarshalIndent(runs, "", "  ")
+		if err != nil {
+			return err
+		}
+		return writeFileAtomic(filepath.Join(s.Dir, "runs.json"), data)
+	})
+	if err != nil {
+		debugf("failed to record cache statistics: %v", err)
+	}
+}
+
+// readRuns returns the recorded run history, oldest first.
+func (s *cacheStore) readRuns() []storeRun {
+	var runs []storeRun
+	if data, err := os.ReadFile(filepath.Join(s.Dir, "runs.json")); err == nil {
+		json.Unmarshal(data, &runs) // A damaged history only loses the statistics.
+	}
+	return runs
+}
+
+func (s *cacheStore) blobPath(blob string) string {
+	return filepath.Join(s.Dir, "blobs", blob[:2], blob)
+}
+
+func (s *cacheStore) shardPath(shard string) string {
+	return filepath.Join(s.Dir, "index", shard+".json")
+}
+
+// readShard loads an index shard; a missing or damaged shard is empty.
+func (s *cacheStore) readShard(shard string) map[string]storeEntry {
+	entries := make(map[string]storeEntry)
+	if data, err := os.ReadFile(s.shardPath(shard)); err == nil {
+		if json.Unmarshal(data, &entries) != nil {
+			entries = make(map[string]storeEntry)
+		}
+	}
+	return entries
+}
+
+// updateShard runs update on the entries of a shard under its lock and writes them back
+// when update reports a change.
+func (s *cacheStore) updateShard(shard string, update func(map[string]storeEntry) bool) error {
+	return s.withLock(shard, func() error {
+		entries := s.readShard(shard)
+		if !update(entries) {
+			return nil
+		}
+		data, err := json.Marshal(entries)
+		if err != nil {
+			return err
+		}
+		return writeFileAtomic(s.shardPath(shard), data)
+	})
+}
+
+// withLock runs fn holding the named lock of the store, in this process and across processes.
+func (s *cacheStore) withLock(name string, fn func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := filepath.Join(s.Dir, "index", name+".lock")
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close() // Closing releases the flock.
+	deadline := time.Now().Add(storeLockTimeout)
+	for flockExclusive(file) != nil {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for cache lock %s", path)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return fn()
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it into place.
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// storeStats summarizes the store for `gitaudit cache stats`.
+type storeStats struct {
+	Entries map[string]int // By kind.
+	Blobs   int
+	Bytes   int64
+}
+
+// Stats counts the entries of every shard and the blobs on disk.
+func (s *cacheStore) Stats() (storeStats, error) {
+	stats := storeStats{Entries: make(map[string]int)}
+	shards, _ := filepath.Glob(filepath.Join(s.Dir, "index", "*.json"))
+	for _, path := range shards {
+		for _, entry := range s.readShard(strings.TrimSuffix(filepath.Base(path), ".json")) {
+			stats.Entries[entry.Kind]++
+		}
+	}
+	err := filepath.WalkDir(filepath.Join(s.Dir, "blobs"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.Type().IsRegular() && !strings.HasPrefix(d.Name(), ".") {
+			if info, err := d.Info(); err == nil {
+				stats.Blobs++
+				stats.Bytes += info.Size()
+			}
+		}
+		return nil
+	})
+	return stats, err
+}
+
+// gcResult reports what a gc removed.
+type gcResult struct {
+	Entries int
+	Blobs   int
+	Bytes   int64
+}
+
+// GC evicts entries not used for maxAge (0: no age limit) and then, least recently used
+// first, entries until the blobs fit in maxSize bytes (0: no size limit). Entries used within
+// storeInUseGrace are kept regardless. Blobs no entry refers to any more are deleted.
+func (s *cacheStore) GC(maxSize int64, maxAge time.Duration) (gcResult, error) {
+	var result gcResult
+	type candidate struct {
+		shard, id string
+		entry     storeEntry
+	}
+	now := time.Now()
+	shardPaths, _ := filepath.Glob(filepath.Join(s.Dir, "index", "*.json"))
+	var shards []string
+	for _, path := range shardPaths {
+		shards = append(shards, strings.TrimSuffix(filepath.Base(path), ".json"))
+	}
+
+	// Choose the evictions from a snapshot of the index.
+	var candidates []candidate
+	blobSize := make(map[string]int64)
+	for _, shard := range shards {
+		for id, entry := range s.readShard(shard) {
+			candidates = append(candidates, candidate{shard, id, entry})
+			blobSize[entry.Blob] = entry.Size
+		}
+	}
+	var total int64
+	for _, size := range blobSize {
+		total += size
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if !candidates[i].entry.Accessed.Equal(candidates[j].entry.Accessed) {
+			return candidates[i].entry.Accessed.Before(candidates[j].entry.Accessed)
+		}
+		if candidates[i].shard != candidates[j].shard {
+			return candidates[i].shard < candidates[j].shard
+		}
+		return candidates[i].id < candidates[j].id
+	})
+	evict := make(map[string]map[string]time.Time) // shard -> id -> Accessed at selection
+	users := make(map[string]int)
+	for _, c := range candidates {
+		users[c.entry.Blob]++
+	}
+	for _, c := range candidates {
+		idle := now.Sub(c.entry.Accessed)
+		if idle < storeInUseGrace {
+			continue
+		}
+		if (maxAge > 0 && idle >= maxAge) || (maxSize > 0 && total > maxSize) {
+			if evict[c.shard] == nil {
+				evict[c.shard] = make(map[string]time.Time)
+			}
+			evict[c.shard][c.id] = c.entry.Accessed
+			if users[c.entry.Blob]--; users[c.entry.Blob] == 0 {
+				total -= c.entry.Size
+			}
+		}
+	}
+
+	// Remove the chosen entries under each shard's lock, skipping any that a concurrent run
+	// used or replaced since the snapshot.
+	for shard, ids := range evict {
+		err := s.updateShard(shard, func(entries map[string]storeEntry) bool {
+			changed := false
+			for id, accessed := range ids {
+				if entry, ok := entries[id]; ok && entry.Accessed.Equal(accessed) {
+					delete(entries, id)
+					result.Entries++
+					changed = true
+				}
+			}
+			return changed
+		})
+		if err != nil {
+			return result, err
+		}
+	}
+
+	// Delete the blobs nothing refers to, unless they are recent enough to belong to an entry
+	// that a concurrent run is about to add.
+	referenced := make(map[string]bool)
+	for _, shard := range shards {
+		for _, entry := range s.readShard(shard) {
+			referenced[entry.Blob] = true
+		}
+	}
+	err := filepath.WalkDir(filepath.Join(s.Dir, "blobs"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.Type().IsRegular() || referenced[d.Name()] {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || now.Sub(info.ModTime()) < storeInUseGrace {
+			return nil
+		}
+		if os.Remove(path) == nil {
+			result.Blobs++
+			result.Bytes += info.Size()
+		}
+		return nil
+	})
+	return result, err
+}
+
+// removeLegacyCaches deletes the cache files used before the store existed.
+func removeLegacyCaches(storeDir string) {
+	base := filepath.Dir(storeDir)
+	os.Remove(filepath.Join(base, "model-info.json"))
+	os.RemoveAll(filepath.Join(base, "osv"))
+}
+
+// cacheGCFlags are the flags of `gitaudit cache gc`.
+type cacheGCFlags struct {
+	MaxSize string
+	MaxAge  string
+}
+
+// registerCacheGCFlags defines the flags of `gitaudit cache gc` on fs.
+func registerCacheGCFlags(fs *flag.FlagSet) *cacheGCFlags {
+	c := &cacheGCFlags{}
+	fs.StringVar(&c.MaxSize, "max-size", "", "Evict least recently used entries until the cache fits in this size, e.g. 2GB or 500MB")
+	fs.StringVar(&c.MaxAge, "max-age", "", "Evict entries not used for this long, e.g. 90d or 12h")
+	return c
+}
+
+// runCache implements `gitaudit cache stats` and `gitaudit cache gc`.
+func runCache(args []string) {
+	usage := func() { printSynopsis(os.Stdout, "cache stats", "cache gc") }
+	if len(args) == 0 {
+		usage()
+		os.Exit(1)
+	}
+	dir, err := defaultStoreDir()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	store := &cacheStore{Dir: dir}
+
+	switch args[0] {
+	case "stats":
+		stats, err := store.Stats()
+		if err != nil {
+			fmt.Printf("Error: failed to read the cache store %s: %v\n", dir, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Cache store: %s\n", dir)
+		total := 0
+		kinds := make([]string, 0, len(stats.Entries))
+		for kind, n := range stats.Entries {
+			kinds = append(kinds, kind)
+			total += n
+		}
+		sort.Strings(kinds)
+		fmt.Printf("Entries: %s\n", formatCount(int64(total)))
+		for _, kind := range kinds {
+			fmt.Printf("  %-12s %s\n", kind, formatCount(int64(stats.Entries[kind])))
+		}
+		fmt.Printf("Size: %s in %s blobs\n", formatBytes(stats.Bytes), formatCount(int64(stats.Blobs)))
+		runs := store.readRuns()
+		if len(runs) == 0 {
+			fmt.Println("No runs have used the cache yet.")
+			return
+		}
+		fmt.Println("Recent runs:")
+		hits, lookups := 0, 0
+		for _, run := range runs {
+			fmt.Printf("  %s  %-8s %s hits, %s misses (%d%%)\n", run.Started.Format("2006-01-02 15:04"), run.Command,
+				formatCount(int64(run.Hits)), formatCount(int64(run.Misses)), run.Hits*100/(run.Hits+run.Misses))
+			hits += run.Hits
+			lookups += run.Hits + run.Misses
+		}
+		fmt.Printf("Hit rate over the last %d runs: %d%%\n", len(runs), hits*100/lookups)
+	case "gc":
+		fs := newCommandFlagSet("cache gc")
+		gc := registerCacheGCFlags(fs)
+		fs.Parse(args[1:])
+		size, err := parseByteSize(gc.MaxSize)
+		if err != nil {
+			fmt.Printf("Error: invalid -max-size: %v\n", err)
+			os.Exit(1)
+		}
+		age, err := parseAge(gc.MaxAge)
+		if err != nil {
+			fmt.Printf("Error: invalid -max-age: %v\n", err)
+			os.Exit(1)
+		}
+		result, err := store.GC(size, age)
+		if err != nil {
+			fmt.Printf("Error: cache gc failed: %v\n", err)
+			os.Exit(1)
+		}
+		removeLegacyCaches(dir)
+		fmt.Printf("Removed %s entries and %s blobs (%s).\n", formatCount(int64(result.Entries)), formatCount(int64(result.Blobs)), formatBytes(result.Bytes))
+	default:
+		usage()
+		os.Exit(1)
+	}
+}
+
+// parseByteSize parses a size such as "2GB", "500MB" or "1024" (bytes), in the decimal units
+// formatBytes prints. An empty string means no limit.
+func parseByteSize(s string) (int64, error) {
+	s = strings.TrimSpace(strings.ToUpper(s))
+	if s == "" {
+		return 0, nil
+	}
+	number := strings.TrimRight(s, "KMGTPEB ")
+	unit := strings.TrimSpace(s[len(number):])
+	multiplier := int64(1)
+	for i, u := range byteUnits {
+		if unit == u || (unit != "" && unit+"B" == u) {
+			for j := 0; j < i; j++ {
+				multiplier *= 1000
+			}
+			unit = ""
+			break
+		}
+	}
+	if unit != "" {
+		return 0, fmt.Errorf("unknown unit in %q (use B, KB, MB, GB or TB)", s)
+	}
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("%q is not a positive size", s)
+	}
+	return int64(value * float64(multiplier)), nil
+}
+
+// parseAge parses a Go duration, also accepting whole days such as "90d". An empty string
+// means no limit.
+func parseAge(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("%q is not a positive number of days", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%q is not a positive duration such as 90d or 12h", s)
+	}
+	return d, nil
+}
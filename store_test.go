@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T) *cacheStore {
+	t.Helper()
+	return &cacheStore{Dir: t.TempDir(), started: time.Now()}
+}
+
+// backdate makes the entry of key last used, and its blob written, ago.
+func backdate(t *testing.T, s *cacheStore, key cacheKey, ago time.Duration) {
+	t.Helper()
+	id := key.id()
+	then := time.Now().Add(-ago)
+	var blob string
+	err := s.updateShard(id[:2], func(entries map[string]storeEntry) bool {
+		entry := entries[id]
+		entry.Created, entry.Accessed = then, then
+		entries[id] = entry
+		blob = entry.Blob
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(s.blobPath(blob), then, then); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCacheStorePutGet(t *testing.T) {
+	s := newTestStore(t)
+	key := cacheKey{Kind: cacheKindOSV, Digest: "npm-lodash-4.17.20"}
+	if _, ok := s.Get(key, 0); ok {
+		t.Fatal("hit in an empty store")
+	}
+	if err := s.Put(key, []byte(`{"vulns":[]}`)); err != nil {
+		t.Fatal(err)
+	}
+	if data, ok := s.Get(key, 0); !ok || string(data) != `{"vulns":[]}` {
+		t.Errorf("Get = %q, %v", data, ok)
+	}
+	// Each field of the key tells entries apart.
+	for _, other := range []cacheKey{
+		{Kind: cacheKindModelInfo, Digest: key.Digest},
+		{Kind: key.Kind, Repo: "r", Digest: key.Digest},
+		{Kind: key.Kind, Commit: "c", Digest: key.Digest},
+		{Kind: key.Kind, Model: "m", Digest: key.Digest},
+		{Kind: key.Kind, Digest: "npm-lodash-4.17.21"},
+	} {
+		if _, ok := s.Get(other, 0); ok {
+			t.Errorf("%+v hits the entry of %+v", other, key)
+		}
+	}
+
+	// Equal contents share a blob.
+	s.Put(cacheKey{Kind: cacheKindOSV, Digest: "npm-lodash-4.17.21"}, []byte(`{"vulns":[]}`))
+	stats, err := s.Stats()
+	if err != nil || stats.Entries[cacheKindOSV] != 2 || stats.Blobs != 1 || stats.Bytes != int64(len(`{"vulns":[]}`)) {
+		t.Errorf("Stats = %+v, %v", stats, err)
+	}
+
+	// Entries older than maxAge miss.
+	backdate(t, s, key, 2*time.Hour)
+	if _, ok := s.Get(key, time.Hour); ok {
+		t.Error("an entry older than maxAge hit")
+	}
+	if _, ok := s.Get(key, 3*time.Hour); !ok {
+		t.Error("an entry younger than maxAge missed")
+	}
+
+	// A lost blob is a miss, not an error.
+	id := key.id()
+	os.Remove(s.blobPath(s.readShard(id[:2])[id].Blob))
+	if _, ok := s.Get(key, 0); ok {
+		t.Error("hit without a blob")
+	}
+
+	var none *cacheStore
+	if _, ok := none.Get(key, 0); ok || none.Put(key, []byte("x")) != nil {
+		t.Error("a nil store cached")
+	}
+}
+
+func TestCacheStoreRunHistory(t *testing.T) {
+	s := newTestStore(t)
+	key := cacheKey{Kind: cacheKindTokenizer, Digest: "d"}
+	s.Get(key, 0)
+	s.Put(key, []byte("x"))
+	s.Get(key, 0)
+	s.Get(key, 0)
+	s.RecordRun("audit")
+	runs := s.readRuns()
+	if len(runs) != 1 || runs[0].Hits != 2 || runs[0].Misses != 1 || runs[0].Command != "audit" {
+		t.Fatalf("runs = %+v", runs)
+	}
+	// Runs without lookups are left out; the history keeps the latest runs.
+	(&cacheStore{Dir: s.Dir}).RecordRun("explain")
+	for i := 0; i < storeRunHistory+2; i++ {
+		run := &cacheStore{Dir: s.Dir, started: time.Now(), hits: i, misses: 1}
+		run.RecordRun(fmt.Sprintf("run%d", i))
+	}
+	runs = s.readRuns()
+	if len(runs) != storeRunHistory || runs[0].Command != "run2" || runs[len(runs)-1].Command != fmt.Sprintf("run%d", storeRunHistory+1) {
+		t.Errorf("history of %d runs from %s to %s", len(runs), runs[0].Command, runs[len(runs)-1].Command)
+	}
+}
+
+func TestCacheStoreConcurrentAccess(t *testing.T) {
+	dir := t.TempDir()
+	// Two stores on one directory stand for two processes: they share only the lockfiles.
+	stores := []*cacheStore{{Dir: dir, started: time.Now()}, {Dir: dir, started: time.Now()}}
+	value := func(i int) []byte { return bytes.Repeat([]byte(fmt.Sprintf("value %d;", i)), 100) }
+	const keys = 200
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*keys)
+	for g, s := range stores {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Both write every key, in opposite orders, and read back what either wrote.
+			for n := 0; n < keys; n++ {
+				i := n
+				if g == 1 {
+					i = keys - 1 - n
+				}
+				key := cacheKey{Kind: cacheKindOSV, Digest: fmt.Sprint(i)}
+				if err := s.Put(key, value(i)); err != nil {
+					errs <- err
+					return
+				}
+				if data, ok := s.Get(key, 0); !ok || !bytes.Equal(data, value(i)) {
+					errs <- fmt.Errorf("key %d read back %q, %v", i, data, ok)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	// No update was lost and every shard is intact.
+	shards, _ := filepath.Glob(filepath.Join(dir, "index", "*.json"))
+	total := 0
+	for _, path := range shards {
+		var entries map[string]storeEntry
+		if err := json.Unmarshal([]byte(readFile(t, path)), &entries); err != nil {
+			t.Errorf("shard %s is damaged: %v", path, err)
+		}
+		total += len(entries)
+	}
+	if total != keys {
+		t.Errorf("%d entries after concurrent puts of %d keys", total, keys)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(dir, "*", "*", ".tmp-*")); len(leftovers) > 0 {
+		t.Errorf("temporary files left behind: %v", leftovers)
+	}
+}
+
+func TestCacheStoreGC(t *testing.T) {
+	s := newTestStore(t)
+	put := func(digest string, size int, ago time.Duration) cacheKey {
+		key := cacheKey{Kind: cacheKindOSV, Digest: digest}
+		if err := s.Put(key, bytes.Repeat([]byte(digest[:1]), size)); err != nil {
+			t.Fatal(err)
+		}
+		if ago > 0 {
+			backdate(t, s, key, ago)
+		}
+		return key
+	}
+	oldest := put("a", 1000, 200*24*time.Hour)
+	old := put("b", 1000, 100*24*time.Hour)
+	recent := put("c", 1000, 10*24*time.Hour)
+	// Used within the grace period: a run may be using it now.
+	inUse := put("d", 1000, 0)
+	// Two entries of one blob count its size once.
+	shared := put("e-1", 500, 5*24*time.Hour)
+	put("e-2", 500, 5*24*time.Hour)
+
+	// By age: only what was not used for 90 days.
+	result, err := s.GC(0, 90*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Entries != 2 || result.Blobs != 2 || result.Bytes != 2000 {
+		t.Errorf("gc by age = %+v, want 2 entries and 2 blobs of 2000 bytes", result)
+	}
+	for key, want := range map[cacheKey]bool{oldest: false, old: false, recent: true, inUse: true, shared: true} {
+		if _, ok := s.Get(key, 0); ok != want {
+			t.Errorf("%s kept %v, want %v", key.Digest, ok, want)
+		}
+	}
+
+	// By size: least recently used first, never what is in use. The Gets above used every
+	// remaining entry, so backdate them again.
+	backdate(t, s, recent, 10*24*time.Hour)
+	backdate(t, s, shared, 5*24*time.Hour)
+	backdate(t, s, cacheKey{Kind: cacheKindOSV, Digest: "e-2"}, 4*24*time.Hour)
+	result, err = s.GC(1200, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Entries != 3 || result.Blobs != 2 {
+		t.Errorf("gc by size = %+v, want 3 entries and 2 blobs", result)
+	}
+	if _, ok := s.Get(inUse, 0); !ok {
+		t.Error("gc evicted an entry in use")
+	}
+
+	// Even a limit nothing fits in keeps the entries in use.
+	result, err = s.GC(1, time.Nanosecond)
+	if err != nil || result.Entries != 0 {
+		t.Errorf("gc evicted %d entries in use: %v", result.Entries, err)
+	}
+
+	// An unreferenced blob is deleted only once it is too old to belong to a run in progress.
+	fresh, stale := s.blobPath(strings.Repeat("1", 64)), s.blobPath(strings.Repeat("2", 64))
+	writeFileAtomic(fresh, []byte("fresh"))
+	writeFileAtomic(stale, []byte("stale"))
+	then := time.Now().Add(-2 * storeInUseGrace)
+	os.Chtimes(stale, then, then)
+	if result, err = s.GC(0, 0); err != nil || result.Blobs != 1 {
+		t.Errorf("gc of unreferenced blobs = %+v, %v", result, err)
+	}
+	if _, err := os.Stat(fresh); err != nil {
+		t.Error("gc deleted a blob that may be about to be referenced")
+	}
+}
+
+func TestCacheStoreGCWithConcurrentReader(t *testing.T) {
+	s := newTestStore(t)
+	const keys = 50
+	for i := 0; i < keys; i++ {
+		key := cacheKey{Kind: cacheKindOSV, Digest: fmt.Sprint(i)}
+		s.Put(key, []byte(fmt.Sprintf("value %d", i)))
+		backdate(t, s, key, 100*24*time.Hour)
+	}
+	reader := &cacheStore{Dir: s.Dir, started: time.Now()}
+	done := make(chan error)
+	go func() {
+		// A reader sees either the whole value or a miss while gc runs.
+		for round := 0; round < 5; round++ {
+			for i := 0; i < keys; i++ {
+				data, ok := reader.Get(cacheKey{Kind: cacheKindOSV, Digest: fmt.Sprint(i)}, 0)
+				if ok && string(data) != fmt.Sprintf("value %d", i) {
+					done <- fmt.Errorf("key %d read %q during gc", i, data)
+					return
+				}
+			}
+		}
+		done <- nil
+	}()
+	if _, err := s.GC(0, 90*24*time.Hour); err != nil {
+		t.Error(err)
+	}
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+	// What the reader touched during gc was in use and survives; the rest is gone.
+	stats, err := s.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Entries[cacheKindOSV] > keys {
+		t.Errorf("%d entries after gc", stats.Entries[cacheKindOSV])
+	}
+	for i := 0; i < keys; i++ {
+		if _, ok := s.Get(cacheKey{Kind: cacheKindOSV, Digest: fmt.Sprint(i)}, 0); !ok {
+			continue
+		}
+		// A surviving entry has its blob.
+		id := cacheKey{Kind: cacheKindOSV, Digest: fmt.Sprint(i)}.id()
+		if _, err := os.Stat(s.blobPath(s.readShard(id[:2])[id].Blob)); err != nil {
+			t.Errorf("entry %d survived without its blob", i)
+		}
+	}
+}
+
+func TestParseByteSizeAndAge(t *testing.T) {
+	sizes := map[string]int64{"": 0, "1024": 1024, "2GB": 2e9, "500MB": 500e6, "1.5kb": 1500, "3 T": 3e12, "10B": 10}
+	for in, want := range sizes {
+		if got, err := parseByteSize(in); err != nil || got != want {
+			t.Errorf("parseByteSize(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"2XB", "-5MB", "0", "MB"} {
+		if _, err := parseByteSize(in); err == nil {
+			t.Errorf("parseByteSize(%q) accepted", in)
+		}
+	}
+	ages := map[string]time.Duration{"": 0, "90d": 90 * 24 * time.Hour, "12h": 12 * time.Hour, "1h30m": 90 * time.Minute}
+	for in, want := range ages {
+		if got, err := parseAge(in); err != nil || got != want {
+			t.Errorf("parseAge(%q) = %s, %v; want %s", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"0d", "-1d", "xd", "soon", "-5h"} {
+		if _, err := parseAge(in); err == nil {
+			t.Errorf("parseAge(%q) accepted", in)
+		}
+	}
+}
+
+func TestCacheCommands(t *testing.T) {
+	repo := newFixtureRepo(t)
+	repo.commits(1)
+	env := newAuditEnv(t)
+	out := env.mustRun("cache", "stats")
+	if !strings.Contains(out, "Entries: 0\n") || !strings.Contains(out, "No runs have used the cache yet.") {
+		t.Errorf("stats of an empty store:\n%s", out)
+	}
+
+	// Two runs: the model info is looked up, then found.
+	for i := 0; i < 2; i++ {
+		env.mustRun("-repo", repo.Dir, "-commit", "root", "-output", filepath.Join(env.Work, fmt.Sprintf("report%d.txt", i)))
+	}
+	out = env.mustRun("cache", "stats")
+	if !strings.Contains(out, "Cache store: "+filepath.Join(env.Home, ".cache", "gitaudit", "store")) ||
+		!strings.Contains(out, "  "+cacheKindModelInfo) || !strings.Contains(out, "Recent runs:") || !strings.Contains(out, "Hit rate over the last 2 runs: ") {
+		t.Errorf("stats after two runs:\n%s", out)
+	}
+
+	// Everything was used just now, so gc keeps it all.
+	if out := env.mustRun("cache", "gc", "-max-size", "1B", "-max-age", "1d"); !strings.Contains(out, "Removed 0 entries and 0 blobs (0 B).") {
+		t.Errorf("gc:\n%s", out)
+	}
+	if out, code := env.run("cache", "gc", "-max-size", "lots"); code != 1 || !strings.Contains(out, "Error: invalid -max-size") {
+		t.Errorf("gc with a bad size exited %d:\n%s", code, out)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Entry kinds recorded on CommitAuditData.Kind.
+const (
+	kindCommit = "commit"
+	kindTag    = "tag"
+)
+
+// tagInfo describes a tag pointing at a commit inside the audited range.
+type tagInfo struct {
+	Name      string
+	Commit    string
+	Annotated bool
+	Tagger    string
+	Date      string
+	Message   string
+}
+
+// getRangeTags lists the tags merged into tip, oldest first by creation date, keeping only
+// those that point at one of the audited commits.
+func getRangeTags(repoPath, tip string, commitHashes []string) ([]tagInfo, error) {
+	inRange := make(map[string]bool, len(commitHashes))
+	for _, hash := range commitHashes {
+		inRange[hash] = true
+	}
+
+	output, err := gitRun(context.Background(), repoPath, "tag", "--merged", tip, "--sort=refname", "--sort=creatordate",
+		"--format=%(refname:strip=2)%00%(objecttype)%00%(objectname)%00%(*objectname)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute git tag --merged %s: %w", tip, err)
+	}
+
+	var tags []tagInfo
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		parts := strings.Split(line, "\x00")
+		if len(parts) < 4 {
+			continue
+		}
+		tag := tagInfo{Name: parts[0], Commit: parts[2]}
+		if parts[1] == "tag" {
+			tag.Annotated = true
+			tag.Commit = parts[3]
+		}
+		if !inRange[tag.Commit] {
+			continue
+		}
+		if tag.Annotated {
+			if err := loadTagAnnotation(repoPath, &tag); err != nil {
+				return nil, err
+			}
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+// annotatedTags returns the annotated tags of tags, in order.
+func annotatedTags(tags []tagInfo) []tagInfo {
+	var annotated []tagInfo
+	for _, tag := range tags {
+		if tag.Annotated {
+			annotated = append(annotated, tag)
+		}
+	}
+	return annotated
+}
+
+// loadTagAnnotation fills in the tagger, date and message of an annotated tag.
+func loadTagAnnotation(repoPath string, tag *tagInfo) error {
+	output, err := gitRun(context.Background(), repoPath, "tag", "-l", "--format=%(taggername) <%(taggeremail:trim)>%00%(taggerdate:iso)%00%(contents)", tag.Name)
+	if err != nil {
+		return fmt.Errorf("failed to read annotation of tag %s: %w", tag.Name, err)
+	}
+	parts := strings.SplitN(string(output), "\x00", 3)
+	if len(parts) < 3 {
+		return fmt.Errorf("unexpected format from git tag for %s: %q", tag.Name, string(output))
+	}
+	tag.Tagger = parts[0]
+	tag.Date = parts[1]
+	tag.Message = strings.TrimSpace(parts[2])
+	return nil
+}
+
+// getSubjectsBetween returns "<short hash> <subject>" lines for the commits reachable from
+// to but not from from, newest first. An empty from lists the history of to. At most limit
+// subjects are returned.
+func getSubjectsBetween(repoPath, from, to string, limit int) ([]string, error) {
+	rev := to
+	if from != "" {
+		rev = from + ".." + to
+	}
+	output, err := gitRun(context.Background(), repoPath, "log", fmt.Sprintf("--max-count=%d", limit), "--format=%h %s", rev)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list commit subjects for %s: %w", rev, err)
+	}
+	var subjects []string
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if line != "" {
+			subjects = append(subjects, line)
+		}
+	}
+	return subjects, nil
+}
+
+// buildTagContextPrompt asks the model to put a release tag's annotation into the context of
+// the commits made since the previous tag.
+func buildTagContextPrompt(tag tagInfo, previousTag string, subjects []string) string {
+	since := "the start of the history"
+	if previousTag != "" {
+		since = "the previous tag " + previousTag
+	}
+	return fmt.Sprintf(`The following is the annotation message of the Git release tag %s, followed by the subjects of the commits made since %s. Write a short paragraph that puts this release into context: what it contains overall and how the commits relate to the tag message. Output only the paragraph itself.
+
+Tag message:
+%s
+
+Commits since %s:
+%s`, tag.Name, since, tag.Message, since, strings.Join(subjects, "\n"))
+}
+
+// buildTagEntries turns the tags found in the range into audit entries. When withContext is
+// set, annotated tags get an additional model-generated paragraph relating them to the commits
+// since the previous tag; a failure there only loses the paragraph, not the entry.
+func buildTagEntries(opts *auditOptions, tags []tagInfo, withContext bool) []CommitAuditData {
+	var entries []CommitAuditData
+	previousTag := ""
+	for _, tag := range tags {
+		entry := CommitAuditData{
+			Kind: kindTag,
+			Hash: tag.Commit,
+			Ref:  tag.Name,
+		}
+		if opts.Topology != nil {
+			// Keep the tag next to its commit when that commit is grouped under a merge.
+			entry.MergeGroup = opts.Topology.Group[tag.Commit]
+		}
+		if tag.Annotated {
+			entry.Author = tag.Tagger
+			entry.Date = tag.Date
+			entry.Summary = tag.Message
+			if withContext {
+				subjects, err := getSubjectsBetween(opts.RepoPath, previousTag, tag.Name, 100)
+				if !opts.Privacy.SendMessage {
+					subjects = nil // Commit subjects are part of the withheld messages.
+				}
+				if err == nil {
+					var context generation
+					context, err = opts.Generator.Generate(requestCtx, buildTagContextPrompt(tag, previousTag, subjects))
+					if err == nil && context.Text != "" {
+						entry.Summary += "\n\nContext: " + context.Text
+					}
+				}
+				if err != nil {
+					fmt.Printf("Warning: failed to generate context for tag %s: %v\n", tag.Name, err)
+				}
+			}
+		}
+		entries = append(entries, entry)
+		previousTag = tag.Name
+	}
+	return entries
+}
+
+// insertTagEntries places each tag entry directly before the entry of the commit it points
+// at, so tags read as section markers in the newest-to-oldest report.
+func insertTagEntries(entries, tagEntries []CommitAuditData) []CommitAuditData {
+	if len(tagEntries) == 0 {
+		return entries
+	}
+	byCommit := make(map[string][]CommitAuditData)
+	for _, tag := range tagEntries {
+		// Tags were discovered oldest first; the newest tag should come first in the report.
+		byCommit[tag.Hash] = append([]CommitAuditData{tag}, byCommit[tag.Hash]...)
+	}
+	var result []CommitAuditData
+	for _, entry := range entries {
+		if entry.Kind != kindTag {
+			result = append(result, byCommit[entry.Hash]...)
+			delete(byCommit, entry.Hash)
+		}
+		result = append(result, entry)
+	}
+	// Tags on commits that have no entry (e.g. they failed) still belong in the report.
+	for i := len(tagEntries) - 1; i >= 0; i-- {
+		if _, pending := byCommit[tagEntries[i].Hash]; pending {
+			result = append(result, tagEntries[i])
+		}
+	}
+	return result
+}
+
+// formatTagEntry renders a tag entry for the text report. Lightweight tags carry no message
+// and only contribute a marker line.
+func formatTagEntry(data CommitAuditData) string {
+	if data.Author == "" && data.Summary == "" {
+		return msg("tag.lightweight", data.Ref, data.Hash) + "\n"
+	}
+	return fmt.Sprintf("%s\n%s\n%s\n%s\n\n%s\n",
+		msg("tag.header", data.Ref), msg("entry.commit", data.Hash), msg("tag.tagger", data.Author), msg("entry.date", localDate(data.Date)), data.Summary)
+}
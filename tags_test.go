@@ -0,0 +1,88 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGetRangeTags(t *testing.T) {
+	repo := newFixtureRepo(t)
+	hashes := repo.commits(4)
+	repo.gitEnv([]string{"GIT_COMMITTER_DATE=2024-02-01T00:00:00Z"}, "tag", "-a", "v1.0", "-m", "First release\n\nWith notes.", hashes[1])
+	repo.git("tag", "light", hashes[2])
+	repo.gitEnv([]string{"GIT_COMMITTER_DATE=2024-03-01T00:00:00Z"}, "tag", "-a", "v2.0", "-m", "Second release", hashes[3])
+	repo.gitEnv([]string{"GIT_COMMITTER_DATE=2024-01-15T00:00:00Z"}, "tag", "-a", "v0.9", "-m", "Before the range", hashes[0])
+
+	// The range leaves out the oldest commit, and with it v0.9.
+	tags, err := getRangeTags(repo.Dir, hashes[3], hashes[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, tag := range tags {
+		names = append(names, tag.Name)
+	}
+	// A lightweight tag is dated by its commit, older than the annotations here.
+	if got, want := strings.Join(names, " "), "light v1.0 v2.0"; got != want {
+		t.Fatalf("tags = %q, want %q", got, want)
+	}
+	for _, tag := range tags {
+		switch tag.Name {
+		case "v1.0":
+			if !tag.Annotated || tag.Commit != hashes[1] || tag.Message != "First release\n\nWith notes." || tag.Tagger != "Fixture Author <author@example.com>" {
+				t.Errorf("v1.0 = %+v", tag)
+			}
+		case "light":
+			if tag.Annotated || tag.Commit != hashes[2] || tag.Message != "" {
+				t.Errorf("light = %+v", tag)
+			}
+		}
+	}
+	annotated := annotatedTags(tags)
+	if len(annotated) != 2 || annotated[0].Name != "v1.0" || annotated[1].Name != "v2.0" {
+		t.Errorf("annotatedTags = %+v", annotated)
+	}
+}
+
+func TestInsertTagEntries(t *testing.T) {
+	entries := []CommitAuditData{{Kind: kindCommit, Hash: "c3"}, {Kind: kindCommit, Hash: "c2"}, {Kind: kindCommit, Hash: "c1"}}
+	tags := []CommitAuditData{
+		{Kind: kindTag, Hash: "c1", Ref: "v1"},
+		{Kind: kindTag, Hash: "c3", Ref: "v2"},
+		{Kind: kindTag, Hash: "c3", Ref: "v2.0.1"},
+		{Kind: kindTag, Hash: "gone", Ref: "orphan"},
+	}
+	var order []string
+	for _, e := range insertTagEntries(entries, tags) {
+		order = append(order, e.Hash+":"+e.Ref)
+	}
+	if got, want := strings.Join(order, " "), "c3:v2.0.1 c3:v2 c3: c2: c1:v1 c1: gone:orphan"; got != want {
+		t.Errorf("order = %q, want %q", got, want)
+	}
+}
+
+func TestAnnotatedTagsReportedByDefault(t *testing.T) {
+	repo := newFixtureRepo(t)
+	hashes := repo.commits(3)
+	repo.git("tag", "-a", "v1.0", "-m", "Release notes for 1.0", hashes[1])
+	repo.git("tag", "nightly", hashes[2])
+	env := newAuditEnv(t)
+
+	report := filepath.Join(env.Work, "default.txt")
+	env.mustRun("-repo", repo.Dir, "-commit", "root", "-output", report)
+	text := readFile(t, report)
+	if !strings.Contains(text, "=== Tag v1.0 ===") || !strings.Contains(text, "Release notes for 1.0") {
+		t.Errorf("annotated tag missing from the default report:\n%s", text)
+	}
+	if strings.Contains(text, "nightly") {
+		t.Errorf("lightweight tag reported without -include-tags:\n%s", text)
+	}
+
+	report = filepath.Join(env.Work, "all.txt")
+	env.mustRun("-repo", repo.Dir, "-commit", "root", "-output", report, "-include-tags")
+	text = readFile(t, report)
+	if !strings.Contains(text, "=== Tag v1.0 ===") || !strings.Contains(text, "=== Tag nightly (lightweight) at "+hashes[2]) {
+		t.Errorf("-include-tags report lacks a tag:\n%s", text)
+	}
+}
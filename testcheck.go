@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// testCheck is the -check-tests annotation of a commit: whether the change came with tests,
+// read from its diff alone. Nothing is built or run.
+type testCheck struct {
+	// TestsTouched is set when the commit changes a test path (see test_paths), a renamed
+	// test file included.
+	TestsTouched bool `json:"tests_touched"`
+	// TestLines and SourceLines count the lines added and deleted in test paths and in the
+	// other paths; documentation and binary files count for neither.
+	TestLines   int `json:"test_lines"`
+	SourceLines int `json:"source_lines"`
+	// TestRatio is TestLines per source line; absent when no source line changed.
+	TestRatio *float64 `json:"test_ratio,omitempty"`
+	// UntestedAdditions are the exported Go functions, methods and types the commit adds
+	// while it changes no test path, as "path: Name".
+	UntestedAdditions []string `json:"untested_additions,omitempty"`
+}
+
+// ratio returns TestRatio, or +Inf for a commit changing no source line, which has nothing
+// left untested; -fail-on compares it.
+func (c *testCheck) ratio() float64 {
+	if c == nil || c.TestRatio == nil {
+		return math.Inf(1)
+	}
+	return *c.TestRatio
+}
+
+// goExportedDecl matches a Go function, method or type declaration with an exported name.
+var goExportedDecl = regexp.MustCompile(`^(?:func\s+(?:\([^)]*\)\s*)?|type\s+)([A-Z]\w*)`)
+
+// checkTests computes the -check-tests annotation of the patch sets of a commit, compared
+// with base when it is set.
+func checkTests(ctx context.Context, repoPath string, patchSets []string, base string, pathspec []string, categories changeCategories) (*testCheck, error) {
+	check := &testCheck{}
+	var additions []string
+	for _, hash := range patchSets {
+		lines, err := getNumstat(ctx, repoPath, hash, base, pathspec)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range lines {
+			if l.Binary {
+				continue
+			}
+			if categories.isTest(l.Path) || (l.OldPath != "" && categories.isTest(l.OldPath)) {
+				check.TestsTouched = true
+				check.TestLines += l.Added + l.Deleted
+			} else if _, ok := matchAnyGlob(categories.Docs, l.Path); !ok {
+				check.SourceLines += l.Added + l.Deleted
+			}
+		}
+		added, err := getGoAdditions(ctx, repoPath, hash, base, pathspec, categories)
+		if err != nil {
+			return nil, err
+		}
+		additions = append(additions, added...)
+	}
+	if check.SourceLines > 0 {
+		ratio := math.Round(float64(check.TestLines)/float64(check.SourceLines)*100) / 100
+		check.TestRatio = &ratio
+	}
+	if !check.TestsTouched {
+		check.UntestedAdditions = additions
+	}
+	return check, nil
+}
+
+// isTest reports whether path is a test path.
+func (c changeCategories) isTest(path string) bool {
+	_, ok := matchAnyGlob(c.Tests, path)
+	return ok
+}
+
+// numstatLine is one file of `git diff --numstat`.
+type numstatLine struct {
+	Path    string
+	OldPath string // The path before a rename or copy; empty otherwise.
+	Added   int
+	Deleted int
+	Binary  bool
+}
+
+// diffTreeArgs returns the git arguments that diff commitHash against base, or against its
+// parent when base is empty, with renames detected.
+func diffTreeArgs(commitHash, base string, extra ...string) []string {
+	args := append([]string{"diff-tree", "-r", "--no-commit-id", "-M"}, extra...)
+	if base != "" {
+		return append(args, base, commitHash)
+	}
+	return append(args, "--root", commitHash)
+}
+
+// getNumstat lists the lines added and deleted per file by a commit.
+func getNumstat(ctx context.Context, repoPath, commitHash, base string, pathspec []string) ([]numstatLine, error) {
+	args := diffTreeArgs(commitHash, base, "--numstat", "-z")
+	if len(pathspec) > 0 {
+		args = append(append(args, "--"), pathspec...)
+	}
+	output, err := gitRun(ctx, repoPath, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count the changed lines of commit %s: %w", commitHash, err)
+	}
+	// Entries are "<added>\t<deleted>\t<path>\x00", or "<added>\t<deleted>\t\x00<old>\x00<new>\x00"
+	// for renames and copies; binary files count "-" lines.
+	fields := strings.Split(string(output), "\x00")
+	var lines []numstatLine
+	for i := 0; i < len(fields); i++ {
+		parts := strings.SplitN(fields[i], "\t", 3)
+		if len(parts) < 3 {
+			continue
+		}
+		line := numstatLine{Path: parts[2], Binary: parts[0] == "-"}
+		line.Added, _ = strconv.Atoi(parts[0])
+		line.Deleted, _ = strconv.Atoi(parts[1])
+		if line.Path == "" && i+2 < len(fields) {
+			line.OldPath, line.Path = fields[i+1], fields[i+2]
+			i += 2
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+// getGoAdditions lists the exported Go declarations a commit adds outside test paths, as
+// "path: Name". A declaration the same file loses in the commit, e.g. a changed signature,
+// is not new.
+func getGoAdditions(ctx context.Context, repoPath, commitHash, base string, pathspec []string, categories changeCategories) ([]string, error) {
+	args := diffTreeArgs(commitHash, base, "-p", "--unified=0", "--no-color", "--no-ext-diff")
+	args = append(args, "--")
+	if len(pathspec) > 0 {
+		args = append(args, pathspec...)
+	} else {
+		args = append(args, "*.go")
+	}
+	output, err := gitRun(ctx, repoPath, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the Go declarations of commit %s: %w", commitHash, err)
+	}
+	added := make(map[string]map[string]bool)
+	removed := make(map[string]map[string]bool)
+	var path string
+	for _, line := range strings.Split(string(output), "\n") {
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			path = ""
+		case strings.HasPrefix(line, "+++ b/"):
+			path = strings.TrimPrefix(line, "+++ b/")
+			if !strings.HasSuffix(path, ".go") || categories.isTest(path) {
+				path = ""
+			}
+		case path == "" || strings.HasPrefix(line, "+++") || strings.HasPrefix(line, "---"):
+		case strings.HasPrefix(line, "+"), strings.HasPrefix(line, "-"):
+			m := goExportedDecl.FindStringSubmatch(line[1:])
+			if m == nil {
+				continue
+			}
+			names := added
+			if line[0] == '-' {
+				names = removed
+			}
+			if names[path] == nil {
+				names[path] = make(map[string]bool)
+			}
+			names[path][m[1]] = true
+		}
+	}
+	var additions []string
+	for file, names := range added {
+		for name := range names {
+			if !removed[file][name] {
+				additions = append(additions, file+": "+name)
+			}
+		}
+	}
+	sort.Strings(additions)
+	return additions, nil
+}
+
+// describeTestCheck renders the annotation as a report note, or "" when the commit changes
+// neither tests nor source.
+func describeTestCheck(c *testCheck) string {
+	switch {
+	case c.TestsTouched && c.TestRatio != nil:
+		return msg("entry.tests_ratio", formatCount(int64(c.TestLines)), formatCount(int64(c.SourceLines)), *c.TestRatio)
+	case c.TestsTouched:
+		return msg("entry.tests_only", formatCount(int64(c.TestLines)))
+	case c.SourceLines > 0 && len(c.UntestedAdditions) > 0:
+		return msg("entry.tests_untested", formatCount(int64(c.SourceLines)), strings.Join(c.UntestedAdditions, ", "))
+	case c.SourceLines > 0:
+		return msg("entry.tests_none", formatCount(int64(c.SourceLines)))
+	}
+	return ""
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGoExportedDecl(t *testing.T) {
+	for _, tc := range []struct {
+		line, want string
+	}{
+		{"func Parse(s string) error {", "Parse"},
+		{"func (c *Client) Retry(ctx context.Context) error {", "Retry"},
+		{"func (Client) Close() {", "Close"},
+		{"type Config struct {", "Config"},
+		{"type Option func(*Config)", "Option"},
+		{"func parse(s string) error {", ""},
+		{"type config struct {", ""},
+		{"\tfunc Nested() {}", ""},
+		{"// func Commented() {}", ""},
+		{"var Exported = 1", ""},
+	} {
+		got := ""
+		if m := goExportedDecl.FindStringSubmatch(tc.line); m != nil {
+			got = m[1]
+		}
+		if got != tc.want {
+			t.Errorf("%q declares %q, want %q", tc.line, got, tc.want)
+		}
+	}
+}
+
+func TestCheckTests(t *testing.T) {
+	repo := newFixtureRepo(t)
+	repo.commit("Initial", map[string]string{
+		"pkg/client.go":       "package pkg\n\nfunc (c *Client) Retry() error {\n\treturn nil\n}\n",
+		"pkg/client_test.go":  "package pkg\n\nfunc TestRetry(t *testing.T) {\n\tcheck(t)\n\tcheck(t)\n}\n",
+		"web/app.js":          "export const a = 1;\n",
+		"tests/test_cli.py":   "def test_cli():\n    pass\n",
+		"docs/guide.md":       "# Guide\n",
+		"assets/logo.png.bin": "\x00\x01\x02",
+	})
+	ratio := func(r float64) *float64 { return &r }
+	for _, tc := range []struct {
+		name  string
+		files map[string]string
+		want  testCheck
+	}{
+		{
+			// Three source lines added, three test lines added.
+			name: "mixed",
+			files: map[string]string{
+				"pkg/server.go":      "package pkg\n\nfunc Serve() {}\n",
+				"pkg/client_test.go": "package pkg\n\nfunc TestRetry(t *testing.T) {\n\tcheck(t)\n\tcheck(t)\n\tcheck(t)\n\tcheck(t)\n\tcheck(t)\n}\n",
+			},
+			want: testCheck{TestsTouched: true, TestLines: 3, SourceLines: 3, TestRatio: ratio(1)},
+		},
+		{
+			name: "test-only in three languages",
+			files: map[string]string{
+				"pkg/server_test.go": "package pkg\n",
+				"tests/test_cli.py":  "def test_cli():\n    assert True\n",
+				"web/app.spec.js":    "test('a', () => {});\n",
+			},
+			want: testCheck{TestsTouched: true, TestLines: 4},
+		},
+		{
+			// A renamed test file is a test change, though its new name alone would not be.
+			name: "renamed test file",
+			files: map[string]string{
+				"pkg/client_test.go":  "",
+				"pkg/client_check.go": "package pkg\n\nfunc TestRetry(t *testing.T) {\n\tcheck(t)\n\tcheck(t)\n\tcheck(t)\n\tcheck(t)\n\tcheck(t)\n\tcheck(t)\n\tcheck(t)\n}\n",
+			},
+			want: testCheck{TestsTouched: true, TestLines: 2},
+		},
+		{
+			// A changed signature is not a new declaration; documentation and binary files
+			// count for neither side.
+			name: "untested additions",
+			files: map[string]string{
+				"pkg/client.go":       "package pkg\n\nfunc (c *Client) Retry(n int) error {\n\treturn nil\n}\n\ntype Backoff struct{}\n\nfunc helper() {}\n",
+				"web/app.js":          "export const a = 2;\n",
+				"docs/guide.md":       "# Guide\n\nMore.\n",
+				"assets/logo.png.bin": "\x00\x01\x02\x03",
+			},
+			want: testCheck{SourceLines: 8, TestRatio: ratio(0), UntestedAdditions: []string{"pkg/client.go: Backoff"}},
+		},
+		{
+			name:  "documentation only",
+			files: map[string]string{"docs/guide.md": "# Guide\n\nEven more.\n"},
+			want:  testCheck{},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			hash := repo.commit(tc.name, tc.files)
+			got, err := checkTests(context.Background(), repo.Dir, []string{hash}, "", nil, newChangeCategories(&Config{}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*got, tc.want) {
+				t.Errorf("checkTests = %+v (ratio %v), want %+v (ratio %v)", *got, got.TestRatio, tc.want, tc.want.TestRatio)
+			}
+		})
+	}
+
+	// test_paths extends the test paths, and a pathspec narrows the diff.
+	hash := repo.commit("QA", map[string]string{"qa/smoke.sh": "true\n", "pkg/extra.go": "package pkg\n\nfunc Extra() {}\n"})
+	got, err := checkTests(context.Background(), repo.Dir, []string{hash}, "", nil, newChangeCategories(&Config{TestPaths: []string{"qa/"}}))
+	if err != nil || !got.TestsTouched || got.TestLines != 1 || got.SourceLines != 3 || got.UntestedAdditions != nil {
+		t.Errorf("with test_paths: %+v, %v", got, err)
+	}
+	got, err = checkTests(context.Background(), repo.Dir, []string{hash}, "", []string{"pkg"}, newChangeCategories(&Config{TestPaths: []string{"qa/"}}))
+	if err != nil || got.TestsTouched || !reflect.DeepEqual(got.UntestedAdditions, []string{"pkg/extra.go: Extra"}) {
+		t.Errorf("with a pathspec: %+v, %v", got, err)
+	}
+}
+
+func TestDescribeTestCheck(t *testing.T) {
+	half := 0.5
+	zero := 0.0
+	for _, tc := range []struct {
+		check testCheck
+		want  string
+	}{
+		{testCheck{TestsTouched: true, TestLines: 10, SourceLines: 20, TestRatio: &half}, "Tests: 10 test lines for 20 source lines (ratio 0.50)"},
+		{testCheck{TestsTouched: true, TestLines: 4}, "Tests: 4 test lines, no source changes"},
+		{testCheck{SourceLines: 7, TestRatio: &zero}, "Tests: none changed for 7 source lines"},
+		{testCheck{SourceLines: 7, TestRatio: &zero, UntestedAdditions: []string{"a.go: A", "b.go: B"}}, "Tests: none changed for 7 source lines; untested additions: a.go: A, b.go: B"},
+		{testCheck{}, ""},
+	} {
+		if got := describeTestCheck(&tc.check); got != tc.want {
+			t.Errorf("describeTestCheck(%+v) = %q, want %q", tc.check, got, tc.want)
+		}
+	}
+	var none *testCheck
+	if none.ratio() <= 1e9 || (&testCheck{TestRatio: &half}).ratio() != 0.5 {
+		t.Error("ratio without source lines is not infinite")
+	}
+}
+
+func TestCheckTestsRun(t *testing.T) {
+	repo := newFixtureRepo(t)
+	repo.commit("Add the client", map[string]string{"client.go": "package client\n\nfunc Dial() {}\n"})
+	repo.commit("Test the client", map[string]string{"client.go": "package client\n\nfunc Dial() {}\n\nfunc Close() {}\n", "client_test.go": "package client\n"})
+	env := newAuditEnv(t)
+	report := filepath.Join(env.Work, "report.txt")
+	env.mustRun("-repo", repo.Dir, "-commit", "root", "-output", report, "-check-tests")
+	content := readFile(t, report)
+	for _, want := range []string{
+		"Tests: none changed for 3 source lines; untested additions: client.go: Dial\n",
+		"Tests: 1 test lines for 2 source lines (ratio 0.50)\n",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("report lacks %q:\n%s", want, content)
+		}
+	}
+	if _, code := env.run("-repo", repo.Dir, "-commit", "root", "-output", report, "-force", "-check-tests", "-fail-on", "untested_additions > 0"); code != exitFailOn {
+		t.Errorf("-fail-on untested_additions: exit %d", code)
+	}
+	if _, code := env.run("-repo", repo.Dir, "-commit", "root", "-output", report, "-force", "-check-tests", "-fail-on", "tests_touched = true AND test_ratio < 0.25"); code != 0 {
+		t.Errorf("-fail-on test_ratio: exit %d", code)
+	}
+}
@@ -0,0 +1,23 @@
+# bash completion for gitaudit. Load it with: source <(gitaudit completion bash)
+_gitaudit() {
+	local line=${COMP_LINE:0:COMP_POINT}
+	local -a words
+	read -ra words <<<"$line"
+	[[ $line == *[[:space:]] ]] && words+=("")
+	local cur=${words[${#words[@]}-1]}
+	local out
+	out=$("${words[0]}" __complete "${words[@]:1}" 2>/dev/null) || return
+	if [[ $out == ":files" ]]; then
+		compopt -o filenames 2>/dev/null
+		COMPREPLY=($(compgen -f -- "${COMP_WORDS[COMP_CWORD]}"))
+		return
+	fi
+	local IFS=$'\n'
+	COMPREPLY=($(cut -f1 <<<"$out"))
+	# bash splits words at : and =, so only the part after the last one is replaced.
+	if [[ $cur == *[:=]* ]]; then
+		local prefix=${cur%"${cur##*[:=]}"}
+		COMPREPLY=("${COMPREPLY[@]#"$prefix"}")
+	fi
+}
+complete -F _gitaudit gitaudit
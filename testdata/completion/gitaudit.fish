@@ -0,0 +1,13 @@
+# fish completion for gitaudit. Load it with: gitaudit completion fish | source
+function __gitaudit_complete
+	set -l words (commandline -opc)
+	set -l current (commandline -ct)
+	set -q current[1]; or set current ""
+	set -l out ($words[1] __complete $words[2..-1] $current 2>/dev/null)
+	if test "$out[1]" = ":files"
+		__fish_complete_path $current
+		return
+	end
+	printf '%s\n' $out
+end
+complete -c gitaudit -f -a '(__gitaudit_complete)'
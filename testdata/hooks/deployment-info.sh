@@ -0,0 +1,20 @@
+#!/bin/sh
+# Example post_process_hook for gitaudit.
+#
+# gitaudit runs the hook once per audited commit with the entry as a JSON object on stdin,
+# e.g. {"kind":"commit","hash":"3f2a91c...","author":"...","date":"...","summary":"..."}.
+# Whatever JSON object the hook prints on stdout is merged into the entry's extras and
+# rendered beneath the entry header. Exit non-zero (or print nothing) to add nothing.
+#
+# A real hook would query a deployment or incident system for the commit; this example
+# only derives a fake deployment label from the hash.
+
+input=$(cat)
+hash=$(printf '%s' "$input" | sed -n 's/.*"hash":"\([0-9a-f]*\)".*/\1/p')
+if [ -z "$hash" ]; then
+	echo "no hash in input" >&2
+	exit 1
+fi
+
+short=$(printf '%s' "$hash" | cut -c1-7)
+printf '{"deployment":"release-%s","incidents":[]}\n' "$short"
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Values of -timeline-bucket.
+const (
+	timelineAuto  = "auto"
+	timelineDay   = "day"
+	timelineWeek  = "week"
+	timelineMonth = "month"
+)
+
+// timelineWidth is how many buckets one row of the timeline's sparklines holds; longer
+// timelines wrap onto further rows.
+const timelineWidth = 60
+
+// timelineQuietMin is the number of consecutive buckets without commits that makes a quiet
+// period worth listing, and timelineQuietTop how many of the longest are listed.
+const (
+	timelineQuietMin = 3
+	timelineQuietTop = 3
+)
+
+// timelineLevels are the sparkline characters, from the least to the most activity. A bucket
+// without activity is a space, so that quiet periods stand out as gaps.
+var timelineLevels = []rune("▁▂▃▄▅▆▇█")
+
+// activityTimeline is the activity of the audited range bucketed by day, week or month, the
+// series behind the report's timeline section and the "timeline" field of -format json.
+type activityTimeline struct {
+	// Bucket is "day", "week" or "month".
+	Bucket  string           `json:"bucket"`
+	Buckets []timelineBucket `json:"buckets"`
+	// SuspectDates counts the commits left out because their date is suspect (see dateCheck):
+	// placing them would stretch the timeline to their wrong date.
+	SuspectDates int `json:"suspect_dates,omitempty"`
+}
+
+// timelineBucket is one day, week or month of the timeline. Empty buckets are kept, so that
+// the series has no gaps.
+type timelineBucket struct {
+	// Start is the first day of the bucket, YYYY-MM-DD; Label names it as -split-by does,
+	// e.g. 2024-06-03, 2024-W23 or 2024-06.
+	Start   string `json:"start"`
+	Label   string `json:"label"`
+	Commits int    `json:"commits"`
+	Added   int    `json:"added"`
+	Deleted int    `json:"deleted"`
+	// Tags are the tags pointing at the bucket's commits, and Flagged counts its commits
+	// that change the control environment or add a secret.
+	Tags    []string `json:"tags,omitempty"`
+	Flagged int      `json:"flagged,omitempty"`
+}
+
+// validTimelineBucket reports whether value is a -timeline-bucket value.
+func validTimelineBucket(value string) bool {
+	switch value {
+	case timelineAuto, timelineDay, timelineWeek, timelineMonth:
+		return true
+	}
+	return false
+}
+
+// timelineGranularity resolves "auto" from the span of the range: days up to two months,
+// weeks up to two years, and months beyond.
+func timelineGranularity(requested string, first, last time.Time) string {
+	if requested != timelineAuto {
+		return requested
+	}
+	switch span := last.Sub(first); {
+	case span <= 60*24*time.Hour:
+		return timelineDay
+	case span <= 2*365*24*time.Hour:
+		return timelineWeek
+	}
+	return timelineMonth
+}
+
+// timelineDate returns the calendar day of t, in t's own time zone, as midnight UTC, so that
+// a commit counts on the day its author saw and days can be stepped through without
+// daylight saving surprises.
+func timelineDate(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// timelineBucketStart returns the first day of the bucket holding day.
+func timelineBucketStart(day time.Time, granularity string) time.Time {
+	switch granularity {
+	case timelineWeek:
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case timelineMonth:
+		return time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return day
+}
+
+// timelineNext returns the first day of the bucket after the one starting at start.
+func timelineNext(start time.Time, granularity string) time.Time {
+	switch granularity {
+	case timelineWeek:
+		return start.AddDate(0, 0, 7)
+	case timelineMonth:
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// timelineLabel names the bucket starting at start.
+func timelineLabel(start time.Time, granularity string) string {
+	switch granularity {
+	case timelineWeek:
+		year, week := start.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	case timelineMonth:
+		return start.Format("2006-01")
+	}
+	return start.Format("2006-01-02")
+}
+
+// buildTimeline buckets the commits of entries by the date -date-source selects, with their
+// changed lines, the tags of the range pointing at them (when tip is known) and whether they
+// are flagged. Tag entries are not counted, and entries with a suspect date are left out.
+func buildTimeline(opts *auditOptions, entries []CommitAuditData, tip, requested string) (*activityTimeline, error) {
+	timeline := &activityTimeline{}
+	days := make(map[string]time.Time)
+	var hashes []string
+	var first, last time.Time
+	for _, entry := range entries {
+		if _, seen := days[entry.Hash]; seen || entry.Kind == kindTag {
+			continue
+		}
+		if entry.DateSuspect != "" {
+			timeline.SuspectDates++
+			continue
+		}
+		t, err := time.Parse(entryDateLayout, strings.TrimSpace(entry.Date))
+		if err != nil {
+			continue
+		}
+		day := timelineDate(t)
+		days[entry.Hash] = day
+		hashes = append(hashes, entry.Hash)
+		if first.IsZero() || day.Before(first) {
+			first = day
+		}
+		if day.After(last) {
+			last = day
+		}
+	}
+	if len(hashes) == 0 {
+		return nil, nil
+	}
+	stats, err := getChangeStats(opts.RepoPath, hashes, opts.Pathspec)
+	if err != nil {
+		return nil, err
+	}
+	var tags []tagInfo
+	if tip != "" {
+		if tags, err = getRangeTags(opts.RepoPath, tip, hashes); err != nil {
+			return nil, err
+		}
+	}
+
+	granularity := timelineGranularity(requested, first, last)
+	timeline.Bucket = granularity
+	index := make(map[time.Time]int)
+	for start := timelineBucketStart(first, granularity); !start.After(last); start = timelineNext(start, granularity) {
+		index[start] = len(timeline.Buckets)
+		timeline.Buckets = append(timeline.Buckets, timelineBucket{Start: start.Format("2006-01-02"), Label: timelineLabel(start, granularity)})
+	}
+	counted := make(map[string]bool)
+	for _, entry := range entries {
+		day, ok := days[entry.Hash]
+		if !ok || entry.Kind == kindTag || counted[entry.Hash] {
+			continue
+		}
+		counted[entry.Hash] = true
+		bucket := &timeline.Buckets[index[timelineBucketStart(day, granularity)]]
+		bucket.Commits++
+		bucket.Added += stats[entry.Hash].Added
+		bucket.Deleted += stats[entry.Hash].Deleted
+		if entry.ControlChange || len(entry.Secrets) > 0 {
+			bucket.Flagged++
+		}
+	}
+	for _, tag := range tags {
+		if day, ok := days[tag.Commit]; ok {
+			bucket := &timeline.Buckets[index[timelineBucketStart(day, granularity)]]
+			bucket.Tags = append(bucket.Tags, tag.Name)
+		}
+	}
+	return timeline, nil
+}
+
+// timelineSparkline draws values as one character each, scaled so that peak is a full block.
+// Any activity gets at least the lowest level, so that no active bucket looks idle.
+func timelineSparkline(values []int, peak int) string {
+	var sb strings.Builder
+	for _, v := range values {
+		if v == 0 || peak == 0 {
+			sb.WriteRune(' ')
+			continue
+		}
+		level := (v*len(timelineLevels) + peak - 1) / peak
+		sb.WriteRune(timelineLevels[level-1])
+	}
+	return sb.String()
+}
+
+// timelineMarker is the marker of a bucket: T for a release tag, ! for a flagged commit, *
+// for both.
+func timelineMarker(bucket timelineBucket) rune {
+	switch {
+	case len(bucket.Tags) > 0 && bucket.Flagged > 0:
+		return '*'
+	case len(bucket.Tags) > 0:
+		return 'T'
+	case bucket.Flagged > 0:
+		return '!'
+	}
+	return ' '
+}
+
+// formatTimelineSection renders the timeline as the report's section: sparklines of the
+// commits and changed lines per bucket with a marker row, wrapped every timelineWidth
+// buckets, then the peaks, the longest quiet periods and the tags.
+func formatTimelineSection(timeline *activityTimeline) string {
+	buckets := timeline.Buckets
+	peakCommits, peakLines := 0, 0
+	peakCommitsAt, peakLinesAt := "", ""
+	var marked bool
+	var tags []string
+	for _, b := range buckets {
+		if b.Commits > peakCommits {
+			peakCommits, peakCommitsAt = b.Commits, b.Label
+		}
+		if lines := b.Added + b.Deleted; lines > peakLines {
+			peakLines, peakLinesAt = lines, b.Label
+		}
+		marked = marked || timelineMarker(b) != ' '
+		for _, tag := range b.Tags {
+			tags = append(tags, fmt.Sprintf("%s (%s)", tag, b.Label))
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString("\n---\n\n" + msg("section.timeline", msg("timeline."+timeline.Bucket), buckets[0].Label, buckets[len(buckets)-1].Label) + "\n\n")
+	rowLabels := []string{msg("timeline.commits"), msg("timeline.lines"), msg("timeline.markers")}
+	rowWidth := 0
+	for _, label := range rowLabels {
+		rowWidth = max(rowWidth, len([]rune(label)))
+	}
+	labelWidth := len(buckets[0].Label)
+	for from := 0; from < len(buckets); from += timelineWidth {
+		row := buckets[from:min(from+timelineWidth, len(buckets))]
+		commits := make([]int, len(row))
+		lines := make([]int, len(row))
+		markers := make([]rune, len(row))
+		for i, b := range row {
+			commits[i] = b.Commits
+			lines[i] = b.Added + b.Deleted
+			markers[i] = timelineMarker(b)
+		}
+		rows := []string{timelineSparkline(commits, peakCommits), timelineSparkline(lines, peakLines), string(markers)}
+		if !marked {
+			rows = rows[:2]
+		}
+		for i, spark := range rows {
+			prefix := ""
+			if i == 0 {
+				prefix = row[0].Label
+			}
+			// Widths count runes, so accented row labels align.
+			line := fmt.Sprintf("%-*s  %-*s  %s", labelWidth, prefix, rowWidth, rowLabels[i], spark)
+			sb.WriteString(strings.TrimRight(line, " ") + "\n")
+		}
+	}
+
+	sb.WriteString("\n" + msg("timeline.peak_commits", msgCount("count.commits", peakCommits), peakCommitsAt) + "\n")
+	if peakLines > 0 {
+		sb.WriteString(msg("timeline.peak_lines", formatCount(int64(peakLines)), peakLinesAt) + "\n")
+	}
+	for _, quiet := range timelineQuietPeriods(buckets) {
+		sb.WriteString(msg("timeline.quiet", buckets[quiet[0]].Label, buckets[quiet[1]].Label, msgCount("count."+timeline.Bucket+"s", quiet[1]-quiet[0]+1)) + "\n")
+	}
+	if marked {
+		sb.WriteString(msg("timeline.legend") + "\n")
+	}
+	if len(tags) > 0 {
+		sb.WriteString(msg("timeline.tags", strings.Join(tags, ", ")) + "\n")
+	}
+	if timeline.SuspectDates > 0 {
+		sb.WriteString(msg("timeline.suspect", msgCount("count.commits", timeline.SuspectDates)) + "\n")
+	}
+	return sb.String()
+}
+
+// timelineQuietPeriods returns the first and last bucket of the timelineQuietTop longest runs
+// of at least timelineQuietMin buckets without commits, in the order of the timeline.
+func timelineQuietPeriods(buckets []timelineBucket) [][2]int {
+	var runs [][2]int
+	for i := 0; i < len(buckets); i++ {
+		if buckets[i].Commits > 0 {
+			continue
+		}
+		j := i
+		for j+1 < len(buckets) && buckets[j+1].Commits == 0 {
+			j++
+		}
+		if j-i+1 >= timelineQuietMin {
+			runs = append(runs, [2]int{i, j})
+		}
+		i = j
+	}
+	for len(runs) > timelineQuietTop {
+		// Drop the shortest run, the latest of equally short ones.
+		shortest := len(runs) - 1
+		for k := len(runs) - 1; k >= 0; k-- {
+			if runs[k][1]-runs[k][0] < runs[shortest][1]-runs[shortest][0] {
+				shortest = k
+			}
+		}
+		runs = append(runs[:shortest], runs[shortest+1:]...)
+	}
+	return runs
+}
+
+// writeTimeline appends the rendered timeline to each report. A failure is reported as a
+// warning; the per-commit entries are already written by then.
+func writeTimeline(filenames []string, timeline *activityTimeline) {
+	section := formatTimelineSection(timeline)
+	for _, filename := range filenames {
+		if err := appendTimelineSection(filename, section); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+			continue
+		}
+		fmt.Printf("Appended the activity timeline to %s\n", filename)
+	}
+}
+
+// appendTimelineSection appends the rendered timeline to the report.
+func appendTimelineSection(filename, section string) error {
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open file %s: %w", filename, err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(section); err != nil {
+		return fmt.Errorf("failed to write the activity timeline to file %s: %w", filename, err)
+	}
+	return nil
+}
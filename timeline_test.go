@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimelineGranularity(t *testing.T) {
+	day := func(s string) time.Time {
+		d, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	for _, tc := range []struct {
+		requested, first, last string
+		want                   string
+	}{
+		{timelineAuto, "2024-01-01", "2024-01-01", timelineDay},
+		{timelineAuto, "2024-01-01", "2024-03-01", timelineDay},
+		{timelineAuto, "2024-01-01", "2024-03-02", timelineWeek},
+		{timelineAuto, "2024-01-01", "2025-12-31", timelineWeek},
+		{timelineAuto, "2024-01-01", "2026-01-01", timelineMonth},
+		// An explicit bucket wins over the span.
+		{timelineDay, "2020-01-01", "2026-01-01", timelineDay},
+		{timelineMonth, "2024-01-01", "2024-01-01", timelineMonth},
+	} {
+		if got := timelineGranularity(tc.requested, day(tc.first), day(tc.last)); got != tc.want {
+			t.Errorf("timelineGranularity(%q, %s, %s) = %q, want %q", tc.requested, tc.first, tc.last, got, tc.want)
+		}
+	}
+}
+
+func TestTimelineBuckets(t *testing.T) {
+	for _, tc := range []struct {
+		day, granularity string
+		start, label     string
+		next             string
+	}{
+		{"2024-06-05", timelineDay, "2024-06-05", "2024-06-05", "2024-06-06"},
+		{"2024-06-05", timelineWeek, "2024-06-03", "2024-W23", "2024-06-10"},
+		// Weeks start on Monday, and a Sunday belongs to the week before.
+		{"2024-06-09", timelineWeek, "2024-06-03", "2024-W23", "2024-06-10"},
+		// ISO weeks cross the year boundary: the week of 2024-12-30 is the first of 2025.
+		{"2025-01-01", timelineWeek, "2024-12-30", "2025-W01", "2025-01-06"},
+		{"2021-01-02", timelineWeek, "2020-12-28", "2020-W53", "2021-01-04"},
+		{"2024-02-29", timelineMonth, "2024-02-01", "2024-02", "2024-03-01"},
+		{"2024-12-31", timelineMonth, "2024-12-01", "2024-12", "2025-01-01"},
+	} {
+		day, _ := time.Parse("2006-01-02", tc.day)
+		start := timelineBucketStart(day, tc.granularity)
+		if got := start.Format("2006-01-02"); got != tc.start {
+			t.Errorf("timelineBucketStart(%s, %s) = %s, want %s", tc.day, tc.granularity, got, tc.start)
+		}
+		if got := timelineLabel(start, tc.granularity); got != tc.label {
+			t.Errorf("timelineLabel(%s, %s) = %s, want %s", tc.start, tc.granularity, got, tc.label)
+		}
+		if got := timelineNext(start, tc.granularity).Format("2006-01-02"); got != tc.next {
+			t.Errorf("timelineNext(%s, %s) = %s, want %s", tc.start, tc.granularity, got, tc.next)
+		}
+	}
+
+	// A commit counts on the day of its own time zone, not the UTC day.
+	late, _ := time.Parse(entryDateLayout, "2024-01-03 23:30:00 -0800")
+	if got := timelineDate(late).Format("2006-01-02"); got != "2024-01-03" {
+		t.Errorf("timelineDate(%s) = %s", late, got)
+	}
+}
+
+func TestTimelineSparkline(t *testing.T) {
+	for _, tc := range []struct {
+		values []int
+		peak   int
+		want   string
+	}{
+		{[]int{0, 8, 4, 1}, 8, " █▄▁"},
+		// Any activity shows, however small against the peak.
+		{[]int{1, 1000}, 1000, "▁█"},
+		{[]int{0, 0}, 0, "  "},
+		{nil, 5, ""},
+	} {
+		if got := timelineSparkline(tc.values, tc.peak); got != tc.want {
+			t.Errorf("timelineSparkline(%v, %d) = %q, want %q", tc.values, tc.peak, got, tc.want)
+		}
+	}
+}
+
+func TestTimelineQuietPeriods(t *testing.T) {
+	buckets := func(commits ...int) []timelineBucket {
+		var bs []timelineBucket
+		for _, c := range commits {
+			bs = append(bs, timelineBucket{Commits: c})
+		}
+		return bs
+	}
+	for _, tc := range []struct {
+		buckets []timelineBucket
+		want    [][2]int
+	}{
+		{buckets(1, 0, 0, 1), nil},
+		{buckets(1, 0, 0, 0, 1), [][2]int{{1, 3}}},
+		// The three longest runs are kept in timeline order; of equally short runs, the
+		// latest is dropped.
+		{buckets(1, 0, 0, 0, 1, 0, 0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0, 0, 0, 1), [][2]int{{1, 3}, {5, 8}, {14, 18}}},
+	} {
+		got := timelineQuietPeriods(tc.buckets)
+		if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("timelineQuietPeriods = %v, want %v", got, tc.want)
+		}
+	}
+}
+
+// timelineCommit is a commit of a timeline fixture: its date, the lines it adds, a tag to
+// point at it and whether it is flagged.
+type timelineCommit struct {
+	When    string
+	Lines   int
+	Tag     string
+	Flagged bool
+}
+
+// timelineGolden commits commits to a new repository, builds their timeline with the
+// requested bucket plus suspect entries with a suspect date, and compares the section with
+// testdata/timeline/name. It returns the timeline.
+func timelineGolden(t *testing.T, requested string, commits []timelineCommit, suspect int, name string) *activityTimeline {
+	t.Helper()
+	repo := newFixtureRepo(t)
+	var entries []CommitAuditData
+	var tip string
+	for i, c := range commits {
+		when, err := time.Parse(entryDateLayout, c.When)
+		if err != nil {
+			t.Fatal(err)
+		}
+		repo.When = when
+		tip = repo.commit(fmt.Sprintf("Change %d", i), map[string]string{fmt.Sprintf("f%d.txt", i): heatmapLines(c.Lines)})
+		if c.Tag != "" {
+			repo.git("tag", c.Tag, tip)
+		}
+		entries = append(entries, CommitAuditData{Hash: tip, Date: repo.git("log", "-1", "--format=%ai", tip), ControlChange: c.Flagged})
+	}
+	for i := 0; i < suspect; i++ {
+		repo.When = time.Date(2099, 1, 1, 0, 0, 0, 0, time.UTC)
+		hash := repo.commit(fmt.Sprintf("Suspect %d", i), nil)
+		entries = append(entries, CommitAuditData{Hash: hash, Date: repo.git("log", "-1", "--format=%ai", hash), DateSuspect: dateSuspectFuture})
+	}
+	timeline, err := buildTimeline(&auditOptions{RepoPath: repo.Dir, Config: &Config{}}, entries, tip, requested)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "timeline/"+name, formatTimelineSection(timeline))
+	return timeline
+}
+
+func TestTimelineGolden(t *testing.T) {
+	// Days: a quiet stretch, a tag, a flagged commit, a bucket with both, a commit late in
+	// the evening west of UTC, and a commit left out for its suspect date.
+	days := timelineGolden(t, timelineAuto, []timelineCommit{
+		{When: "2024-01-01 09:00:00 +0000", Lines: 10},
+		{When: "2024-01-01 17:00:00 +0000", Lines: 30},
+		{When: "2024-01-02 10:00:00 +0000", Lines: 5, Flagged: true},
+		{When: "2024-01-03 23:30:00 -0800", Lines: 2},
+		{When: "2024-01-08 11:00:00 +0000", Lines: 80, Tag: "v1.0"},
+		{When: "2024-01-09 11:00:00 +0000", Lines: 1, Tag: "v1.0.1", Flagged: true},
+		{When: "2024-01-12 11:00:00 +0000", Lines: 12},
+	}, 1, "day.txt")
+	if days.Bucket != timelineDay || len(days.Buckets) != 12 || days.SuspectDates != 1 || days.Buckets[2].Commits != 1 {
+		t.Errorf("day timeline: %+v", days)
+	}
+
+	// Weeks: five months across a year boundary, with empty weeks in between.
+	weeks := timelineGolden(t, timelineAuto, []timelineCommit{
+		{When: "2023-11-06 09:00:00 +0000", Lines: 4},
+		{When: "2023-11-08 09:00:00 +0000", Lines: 6},
+		{When: "2023-12-29 09:00:00 +0000", Lines: 20, Tag: "v2.0"},
+		{When: "2024-01-01 09:00:00 +0000", Lines: 3},
+		{When: "2024-02-14 09:00:00 +0000", Lines: 9, Flagged: true},
+		{When: "2024-03-31 09:00:00 +0000", Lines: 1},
+	}, 0, "week.txt")
+	if weeks.Bucket != timelineWeek || weeks.Buckets[0].Label != "2023-W45" || weeks.Buckets[len(weeks.Buckets)-1].Label != "2024-W13" {
+		t.Errorf("week timeline: %+v", weeks)
+	}
+
+	// Months: more than two years, without markers, so no marker row or legend.
+	months := timelineGolden(t, timelineAuto, []timelineCommit{
+		{When: "2021-03-15 09:00:00 +0000", Lines: 50},
+		{When: "2021-04-01 09:00:00 +0000", Lines: 5},
+		{When: "2022-01-20 09:00:00 +0000", Lines: 15},
+		{When: "2023-06-30 09:00:00 +0000", Lines: 25},
+		{When: "2023-07-01 09:00:00 +0000", Lines: 25},
+	}, 0, "month.txt")
+	if months.Bucket != timelineMonth || len(months.Buckets) != 29 {
+		t.Errorf("month timeline: %s, %d buckets", months.Bucket, len(months.Buckets))
+	}
+
+	// A single day: one bucket, no quiet periods.
+	single := timelineGolden(t, timelineAuto, []timelineCommit{
+		{When: "2024-05-05 08:00:00 +0200", Lines: 3},
+		{When: "2024-05-05 19:00:00 +0200", Lines: 7, Tag: "v0.1"},
+	}, 0, "single-day.txt")
+	if len(single.Buckets) != 1 || single.Buckets[0].Commits != 2 {
+		t.Errorf("single-day timeline: %+v", single)
+	}
+
+	// Days requested over three months: the rows wrap every timelineWidth buckets.
+	wrapped := timelineGolden(t, timelineDay, []timelineCommit{
+		{When: "2024-01-01 09:00:00 +0000", Lines: 2},
+		{When: "2024-02-15 09:00:00 +0000", Lines: 4},
+		{When: "2024-03-05 09:00:00 +0000", Lines: 8, Flagged: true},
+		{When: "2024-03-31 09:00:00 +0000", Lines: 1},
+	}, 0, "wrapped.txt")
+	if len(wrapped.Buckets) != 91 {
+		t.Errorf("wrapped timeline has %d buckets", len(wrapped.Buckets))
+	}
+
+	// Month buckets requested over a few days.
+	timelineGolden(t, timelineMonth, []timelineCommit{
+		{When: "2024-01-30 09:00:00 +0000", Lines: 2},
+		{When: "2024-02-02 09:00:00 +0000", Lines: 6},
+	}, 0, "month-requested.txt")
+}
+
+func TestTimelineNoDates(t *testing.T) {
+	// Only tag and suspect entries: no timeline.
+	timeline, err := buildTimeline(&auditOptions{Config: &Config{}}, []CommitAuditData{
+		{Hash: "a", Kind: kindTag, Date: "2024-01-01 09:00:00 +0000"},
+		{Hash: "b", DateSuspect: dateSuspectBeforeRoot, Date: "1970-01-01 00:00:00 +0000"},
+	}, "", timelineAuto)
+	if err != nil || timeline != nil {
+		t.Errorf("timeline %+v, %v", timeline, err)
+	}
+}
+
+func TestTimelineRun(t *testing.T) {
+	repo := newFixtureRepo(t)
+	repo.commits(3)
+	repo.git("tag", "v1.0")
+	env := newAuditEnv(t)
+	text, report := filepath.Join(env.Work, "report.txt"), filepath.Join(env.Work, "report.json")
+	out := env.mustRun("-repo", repo.Dir, "-commit", "root", "-out", "text="+text, "-out", "json="+report, "-timeline")
+	if !strings.Contains(out, "Appended the activity timeline to "+text) {
+		t.Errorf("output:\n%s", out)
+	}
+	content := readFile(t, text)
+	section := "=== Activity timeline (per day, 2024-01-01 to 2024-01-01) ===\n\n"
+	if idx := strings.Index(content, section); idx < 0 || idx < strings.LastIndex(content, "Commit: ") {
+		t.Fatalf("the timeline is not after the entries:\n%s", content)
+	}
+	if !strings.Contains(content, "Tags: v1.0 (2024-01-01)\n") {
+		t.Errorf("report lacks the tag:\n%s", content)
+	}
+	var document jsonReport
+	if err := json.Unmarshal([]byte(readFile(t, report)), &document); err != nil {
+		t.Fatal(err)
+	}
+	if document.Timeline == nil || document.Timeline.Bucket != timelineDay || len(document.Timeline.Buckets) != 1 || document.Timeline.Buckets[0].Commits != 3 {
+		t.Errorf("json timeline: %+v", document.Timeline)
+	}
+
+	if out, code := env.run("-repo", repo.Dir, "-commit", "root", "-output", text, "-force", "-timeline", "-timeline-bucket", "year"); code == 0 || !strings.Contains(out, `invalid -timeline-bucket value "year"`) {
+		t.Errorf("-timeline-bucket year: exit %d\n%s", code, out)
+	}
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode"
+	"unicode/utf8"
+)
+
+// tokenizer counts the tokens a model sees in a text. The prompt budget measures and trims
+// prompt components with it.
+type tokenizer interface {
+	// Name describes the tokenizer for the run header.
+	Name() string
+	Count(text string) int
+}
+
+// Values of the tokenizers config key, besides "bpe:<file or URL>".
+const (
+	tokenizerHeuristic = "heuristic"
+	tokenizerOllama    = "ollama"
+	tokenizerBPEPrefix = "bpe:"
+)
+
+// validateTokenizerSpec checks a value of the tokenizers config key.
+func validateTokenizerSpec(model, spec string) error {
+	switch {
+	case spec == tokenizerHeuristic || spec == tokenizerOllama:
+		return nil
+	case strings.HasPrefix(spec, tokenizerBPEPrefix) && strings.TrimPrefix(spec, tokenizerBPEPrefix) != "":
+		return nil
+	}
+	return fmt.Errorf("invalid tokenizer %q for model %s: expected %q, %q or \"bpe:<file or URL>\"", spec, model, tokenizerHeuristic, tokenizerOllama)
+}
+
+// newTokenizer returns the tokenizer the tokenizers config key selects for the configured
+// model, or the heuristic when it names none. A BPE encoding given by URL is downloaded
+// once into the cache store.
+func newTokenizer(config *Config, store *cacheStore) (tokenizer, error) {
+	model := configuredModel(config)
+	spec := config.Tokenizers[model]
+	switch {
+	case spec == "" || spec == tokenizerHeuristic:
+		return heuristicTokenizer{}, nil
+	case spec == tokenizerOllama:
+		if config.Provider != "" && config.Provider != providerOllama {
+			return nil, fmt.Errorf("tokenizer %q for model %s needs the Ollama provider", spec, model)
+		}
+		base, err := ollamaBaseURL(config.OllamaEndpoint)
+		if err != nil {
+			return nil, err
+		}
+		return &ollamaTokenizer{Base: base, Model: model}, nil
+	}
+	return loadBPETokenizer(strings.TrimPrefix(spec, tokenizerBPEPrefix), store)
+}
+
+// heuristicTokenizer estimates bytesPerToken bytes per token. It needs nothing but
+// over-counts code, whose identifiers and indentation encode densely.
+type heuristicTokenizer struct{}
+
+func (heuristicTokenizer) Name() string {
+	return fmt.Sprintf("heuristic (%d bytes per token)", bytesPerToken)
+}
+
+func (heuristicTokenizer) Count(text string) int {
+	return (len(text) + bytesPerToken - 1) / bytesPerToken
+}
+
+// ollamaEmbedChunk bounds the text of one /api/embed request, so that a chunk fits the
+// embedding context of even small models.
+const ollamaEmbedChunk = 4096
+
+// ollamaTokenizer asks the Ollama server to count with the model's own vocabulary:
+// /api/tokenize where the server has it, otherwise the prompt_eval_count of /api/embed
+// requests. After the first failure it warns and uses the heuristic for the rest of the run.
+type ollamaTokenizer struct {
+	Base  string
+	Model string
+	// embed is set once /api/tokenize turned out to be missing, failed once counting failed.
+	embed  atomic.Bool
+	failed atomic.Bool
+	warn   sync.Once
+}
+
+func (t *ollamaTokenizer) Name() string {
+	return "ollama (" + t.Model + ")"
+}
+
+func (t *ollamaTokenizer) Count(text string) int {
+	if text == "" {
+		return 0
+	}
+	if !t.failed.Load() {
+		n, err := t.count(text)
+		if err == nil {
+			return n
+		}
+		t.failed.Store(true)
+		t.warn.Do(func() {
+			fmt.Printf("Warning: failed to count tokens with Ollama: %v; using the heuristic for the rest of the run.\n", err)
+		})
+	}
+	return heuristicTokenizer{}.Count(text)
+}
+
+// count asks /api/tokenize, then falls back to /api/embed when the server does not know it.
+func (t *ollamaTokenizer) count(text string) (int, error) {
+	if !t.embed.Load() {
+		var resp struct {
+			Tokens []int `json:"tokens"`
+		}
+		status, err := t.post("/api/tokenize", map[string]any{"model": t.Model, "content": text}, &resp)
+		if status != http.StatusNotFound {
+			return len(resp.Tokens), err
+		}
+		debugf("%s/api/tokenize is missing; counting tokens with /api/embed", t.Base)
+		t.embed.Store(true)
+	}
+	total := 0
+	for text != "" {
+		chunk := text
+		if len(chunk) > ollamaEmbedChunk {
+			chunk = chunk[:ollamaEmbedChunk]
+			if idx := strings.LastIndex(chunk, "\n"); idx > 0 {
+				chunk = chunk[:idx+1]
+			}
+		}
+		text = text[len(chunk):]
+		var resp struct {
+			PromptEvalCount int `json:"prompt_eval_count"`
+		}
+		if _, err := t.post("/api/embed", map[string]any{"model": t.Model, "input": chunk}, &resp); err != nil {
+			return 0, err
+		}
+		total += resp.PromptEvalCount
+	}
+	return total, nil
+}
+
+// post sends a JSON request to an Ollama API and decodes its answer into out. The status is
+// returned along with any error so that a missing API can be told apart.
+func (t *ollamaTokenizer) post(api string, request any, out any) (int, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal %s request: %w", api, err)
+	}
+	resp, err := modelInfoClient.Post(t.Base+api, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("failed to query %s%s: %w", t.Base, api, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, fmt.Errorf("failed to read %s response: %w", api, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, fmt.Errorf("%s for model %s failed with status %s: %s", api, t.Model, resp.Status, strings.TrimSpace(string(data)))
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return resp.StatusCode, fmt.Errorf("failed to decode %s response: %w", api, err)
+	}
+	return resp.StatusCode, nil
+}
+
+// The pre-tokenization patterns of OpenAI's encodings, which split text into the pieces
+// BPE merges within. Go's regexp has no lookahead, so their "\s+(?!\S)" alternative is
+// emulated by bpeTokenizer.pieces.
+var (
+	cl100kPattern = regexp.MustCompile(`(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+`)
+	o200kPattern  = regexp.MustCompile(`[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]*[\p{Ll}\p{Lm}\p{Lo}\p{M}]+(?i:'s|'t|'re|'ve|'m|'ll|'d)?|[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]+[\p{Ll}\p{Lm}\p{Lo}\p{M}]*(?i:'s|'t|'re|'ve|'m|'ll|'d)?|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n/]*|\s*[\r\n]+|\s+`)
+)
+
+// bpeTokenizer counts tokens with a tiktoken-style byte pair encoding, as OpenAI models use.
+// The encoding is a .tiktoken file, one base64 token and its rank per line, such as
+// cl100k_base.tiktoken or o200k_base.tiktoken; the file name selects the pre-tokenization
+// pattern.
+type bpeTokenizer struct {
+	Source  string
+	ranks   map[string]int
+	pattern *regexp.Regexp
+}
+
+// loadBPETokenizer reads a .tiktoken file from a path, or from an http(s) URL through the
+// cache store.
+func loadBPETokenizer(source string, store *cacheStore) (*bpeTokenizer, error) {
+	var data []byte
+	if strings.HasPrefix(source, "https://") || strings.HasPrefix(source, "http://") {
+		key := cacheKey{Kind: cacheKindTokenizer, Digest: source}
+		cached, ok := store.Get(key, 0)
+		if ok {
+			data = cached
+		} else {
+			fmt.Printf("Downloading BPE encoding %s\n", source)
+			resp, err := lookupClient.Get(source)
+			if err != nil {
+				return nil, fmt.Errorf("failed to download BPE encoding %s: %w", source, err)
+			}
+			data, err = io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				return nil, fmt.Errorf("failed to download BPE encoding %s: %w", source, err)
+			}
+			if resp.StatusCode != http.StatusOK {
+				return nil, fmt.Errorf("failed to download BPE encoding %s: status %s", source, resp.Status)
+			}
+			if err := store.Put(key, data); err != nil {
+				fmt.Printf("Warning: failed to cache BPE encoding: %v\n", err)
+			}
+		}
+	} else {
+		var err error
+		data, err = os.ReadFile(source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read BPE encoding: %w", err)
+		}
+	}
+
+	t := &bpeTokenizer{Source: source, ranks: make(map[string]int), pattern: cl100kPattern}
+	if strings.Contains(path.Base(source), "o200k") {
+		t.pattern = o200kPattern
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid BPE encoding %s: line %d is not \"<base64 token> <rank>\"", source, line)
+		}
+		token, err := base64.StdEncoding.DecodeString(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid BPE encoding %s: line %d: %w", source, line, err)
+		}
+		rank, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid BPE encoding %s: line %d: %w", source, line, err)
+		}
+		t.ranks[string(token)] = rank
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read BPE encoding %s: %w", source, err)
+	}
+	if len(t.ranks) < 256 {
+		return nil, fmt.Errorf("invalid BPE encoding %s: %d tokens do not cover every byte", source, len(t.ranks))
+	}
+	return t, nil
+}
+
+func (t *bpeTokenizer) Name() string {
+	return fmt.Sprintf("bpe (%s, %d tokens)", path.Base(t.Source), len(t.ranks))
+}
+
+func (t *bpeTokenizer) Count(text string) int {
+	count := 0
+	for _, piece := range t.pieces(text) {
+		count += t.countPiece(piece)
+	}
+	return count
+}
+
+// pieces splits text with the encoding's pattern. A run of whitespace followed by a word
+// leaves its last character to the word, as "\s+(?!\S)" does.
+func (t *bpeTokenizer) pieces(text string) []string {
+	var pieces []string
+	for text != "" {
+		loc := t.pattern.FindStringIndex(text)
+		if loc == nil {
+			return append(pieces, text)
+		}
+		if loc[0] > 0 {
+			pieces = append(pieces, text[:loc[0]])
+		}
+		end := loc[1]
+		piece := text[loc[0]:end]
+		if last, size := utf8.DecodeLastRuneInString(piece); end < len(text) && size < len(piece) &&
+			last != '\r' && last != '\n' && strings.TrimSpace(piece) == "" {
+			if next, _ := utf8.DecodeRuneInString(text[end:]); !unicode.IsSpace(next) {
+				end -= size
+				piece = piece[:len(piece)-size]
+			}
+		}
+		pieces = append(pieces, piece)
+		text = text[end:]
+	}
+	return pieces
+}
+
+// countPiece merges the bytes of a piece, lowest-ranked pair first, until no pair is a token.
+func (t *bpeTokenizer) countPiece(piece string) int {
+	if _, ok := t.ranks[piece]; ok {
+		return 1
+	}
+	parts := make([]string, len(piece))
+	for i := 0; i < len(piece); i++ {
+		parts[i] = piece[i : i+1]
+	}
+	for len(parts) > 1 {
+		best, bestRank := -1, 0
+		for i := 0; i+1 < len(parts); i++ {
+			if rank, ok := t.ranks[parts[i]+parts[i+1]]; ok && (best < 0 || rank < bestRank) {
+				best, bestRank = i, rank
+			}
+		}
+		if best < 0 {
+			break
+		}
+		parts[best] += parts[best+1]
+		parts = append(parts[:best+1], parts[best+2:]...)
+	}
+	return len(parts)
+}
+
+// truncateTokens cuts text, which counts size tokens, to at most limit tokens like
+// truncateText. The byte limit is estimated from the text's own bytes per token, then
+// lowered until the tokenizer agrees, since the density of a patch varies along it.
+func truncateTokens(t tokenizer, text string, size, limit int, what string) string {
+	if size <= limit {
+		return text
+	}
+	byteLimit := int(int64(len(text)) * int64(limit) / int64(size))
+	for attempt := 0; ; attempt++ {
+		cut := truncateText(text, byteLimit, what)
+		n := t.Count(cut)
+		if n <= limit || attempt == 3 || byteLimit == 0 {
+			return cut
+		}
+		byteLimit = min(byteLimit-1, int(int64(byteLimit)*int64(limit)/int64(n)))
+	}
+}
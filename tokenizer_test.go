@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// writeEncoding writes a .tiktoken encoding of every byte followed by tokens, in rank order,
+// and returns its path.
+func writeEncoding(t *testing.T, name string, tokens []string) string {
+	t.Helper()
+	var sb strings.Builder
+	for i := 0; i < 256; i++ {
+		fmt.Fprintf(&sb, "%s %d\n", base64.StdEncoding.EncodeToString([]byte{byte(i)}), i)
+	}
+	for i, token := range tokens {
+		fmt.Fprintf(&sb, "%s %d\n", base64.StdEncoding.EncodeToString([]byte(token)), 256+i)
+	}
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(sb.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestValidateTokenizerSpec(t *testing.T) {
+	for _, spec := range []string{"heuristic", "ollama", "bpe:cl100k_base.tiktoken", "bpe:https://example.com/o200k_base.tiktoken"} {
+		if err := validateTokenizerSpec("m", spec); err != nil {
+			t.Errorf("%q: %v", spec, err)
+		}
+	}
+	for _, spec := range []string{"", "bpe:", "tiktoken", "Heuristic"} {
+		if err := validateTokenizerSpec("m", spec); err == nil || !strings.Contains(err.Error(), fmt.Sprintf("invalid tokenizer %q for model m", spec)) {
+			t.Errorf("%q: %v", spec, err)
+		}
+	}
+}
+
+func TestHeuristicTokenizer(t *testing.T) {
+	for text, want := range map[string]int{"": 0, "a": 1, "abcd": 1, "abcde": 2, strings.Repeat("x", 400): 100} {
+		if got := (heuristicTokenizer{}).Count(text); got != want {
+			t.Errorf("Count(%d bytes) = %d, want %d", len(text), got, want)
+		}
+	}
+}
+
+func TestBPETokenizer(t *testing.T) {
+	// "th" and "he" both merge; "th" ranks first, so "the" is built as "th"+"e".
+	path := writeEncoding(t, "cl100k_base.tiktoken", []string{"th", "he", "the", " the", "in", " in", "ing", "func"})
+	bpe, err := loadBPETokenizer(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bpe.Name() != "bpe (cl100k_base.tiktoken, 264 tokens)" || bpe.pattern != cl100kPattern {
+		t.Errorf("tokenizer %s", bpe.Name())
+	}
+	for piece, want := range map[string]int{"the": 1, " the": 1, "thing": 2, "hex": 2, "func": 1, "xyz": 3, "": 0} {
+		if got := bpe.countPiece(piece); got != want && piece != "" {
+			t.Errorf("countPiece(%q) = %d, want %d", piece, got, want)
+		}
+	}
+	for text, want := range map[string][]string{
+		"the thing":     {"the", " thing"},
+		"a  b":          {"a", " ", " b"},
+		"x = 10000\n":   {"x", " =", " ", "100", "00", "\n"},
+		"it's  \n\nok":  {"it", "'s", "  \n\n", "ok"},
+		"trailing   ":   {"trailing", "   "},
+		"func f() {}\n": {"func", " f", "()", " {}\n"},
+	} {
+		if got := bpe.pieces(text); strings.Join(got, "|") != strings.Join(want, "|") {
+			t.Errorf("pieces(%q) = %q, want %q", text, got, want)
+		}
+	}
+	// " thing" merges to " ", "th", "ing". "func" is a token, but no pair of " func" merges
+	// towards it, so that piece stays five bytes.
+	if got := bpe.Count("the thing in the func"); got != 1+3+1+1+5 {
+		t.Errorf("Count = %d", got)
+	}
+
+	o200k, err := loadBPETokenizer(writeEncoding(t, "o200k_base.tiktoken", nil), nil)
+	if err != nil || o200k.pattern != o200kPattern {
+		t.Errorf("o200k encoding: %v", err)
+	}
+
+	for name, content := range map[string]string{
+		"short.tiktoken":  "YQ== 0\n",
+		"fields.tiktoken": "YQ==\n",
+		"base64.tiktoken": "!!! 0\n",
+		"rank.tiktoken":   "YQ== first\n",
+	} {
+		file := filepath.Join(t.TempDir(), name)
+		os.WriteFile(file, []byte(content), 0o644)
+		if _, err := loadBPETokenizer(file, nil); err == nil || !strings.HasPrefix(err.Error(), "invalid BPE encoding ") {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if _, err := loadBPETokenizer(filepath.Join(t.TempDir(), "missing.tiktoken"), nil); err == nil || !strings.HasPrefix(err.Error(), "failed to read BPE encoding: ") {
+		t.Errorf("missing encoding: %v", err)
+	}
+}
+
+func TestBPEDownload(t *testing.T) {
+	data, err := os.ReadFile(writeEncoding(t, "enc.tiktoken", []string{"the"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var downloads atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/cl100k_base.tiktoken" {
+			http.NotFound(w, r)
+			return
+		}
+		downloads.Add(1)
+		w.Write(data)
+	}))
+	defer server.Close()
+	store := &cacheStore{Dir: t.TempDir()}
+	for i := 0; i < 2; i++ {
+		var bpe *bpeTokenizer
+		captureStdout(t, func() { bpe, err = loadBPETokenizer(server.URL+"/cl100k_base.tiktoken", store) })
+		if err != nil || bpe.Count("the") != 1 {
+			t.Fatalf("load %d: %v", i+1, err)
+		}
+	}
+	if n := downloads.Load(); n != 1 {
+		t.Errorf("the encoding was downloaded %d times, want once", n)
+	}
+	captureStdout(t, func() { _, err = loadBPETokenizer(server.URL+"/missing.tiktoken", store) })
+	if err == nil || !strings.Contains(err.Error(), "status 404") {
+		t.Errorf("missing download: %v", err)
+	}
+}
+
+// fakeTokenizeServer answers /api/tokenize, unless embedOnly, and /api/embed with the counts
+// of counter, and records the size of each embed input.
+func fakeTokenizeServer(t *testing.T, counter tokenizer, embedOnly bool, chunks *[]int) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model   string `json:"model"`
+			Content string `json:"content"`
+			Input   string `json:"input"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		switch {
+		case r.URL.Path == "/api/tokenize" && !embedOnly:
+			json.NewEncoder(w).Encode(map[string]any{"tokens": make([]int, counter.Count(req.Content))})
+		case r.URL.Path == "/api/embed":
+			*chunks = append(*chunks, len(req.Input))
+			json.NewEncoder(w).Encode(map[string]any{"prompt_eval_count": counter.Count(req.Input)})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestOllamaTokenizer(t *testing.T) {
+	bpe, err := loadBPETokenizer(writeEncoding(t, "cl100k_base.tiktoken", []string{"the", " the"}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := strings.Repeat("the the the\n", 1000)
+
+	var chunks []int
+	tokenize := &ollamaTokenizer{Base: fakeTokenizeServer(t, bpe, false, &chunks).URL, Model: "tiny:0.5b"}
+	if got, want := tokenize.Count(text), bpe.Count(text); got != want || len(chunks) != 0 {
+		t.Errorf("/api/tokenize count %d, want %d", got, want)
+	}
+	if tokenize.Name() != "ollama (tiny:0.5b)" || tokenize.Count("") != 0 {
+		t.Errorf("tokenizer %s", tokenize.Name())
+	}
+
+	// Without /api/tokenize, the text is embedded in chunks that end at line breaks.
+	embed := &ollamaTokenizer{Base: fakeTokenizeServer(t, bpe, true, &chunks).URL, Model: "tiny:0.5b"}
+	out := captureStdout(t, func() {
+		if got, want := embed.Count(text), bpe.Count(text); got != want {
+			t.Errorf("/api/embed count %d, want %d", got, want)
+		}
+	})
+	total := 0
+	for _, n := range chunks {
+		total += n
+		if n > ollamaEmbedChunk || n%len("the the the\n") != 0 {
+			t.Errorf("embed chunk of %d bytes", n)
+		}
+	}
+	if len(chunks) < 3 || total != len(text) || out != "" {
+		t.Errorf("%d chunks of %d bytes in all\n%s", len(chunks), total, out)
+	}
+
+	// A server that cannot count leaves the heuristic, with a single warning.
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "model not loaded", http.StatusInternalServerError)
+	}))
+	defer down.Close()
+	failing := &ollamaTokenizer{Base: down.URL, Model: "tiny:0.5b"}
+	out = captureStdout(t, func() {
+		for i := 0; i < 3; i++ {
+			if got := failing.Count("abcdefgh"); got != 2 {
+				t.Errorf("fallback count %d", got)
+			}
+		}
+	})
+	if strings.Count(out, "Warning: failed to count tokens with Ollama: ") != 1 || !strings.Contains(out, "model not loaded") {
+		t.Errorf("output:\n%s", out)
+	}
+}
+
+func TestNewTokenizer(t *testing.T) {
+	path := writeEncoding(t, "cl100k_base.tiktoken", nil)
+	config := &Config{OllamaEndpoint: "http://localhost:11434/api/generate", OllamaModel: "llama3", Tokenizers: map[string]string{"other": "ollama"}}
+	if tok, err := newTokenizer(config, nil); err != nil || tok.Name() != "heuristic (4 bytes per token)" {
+		t.Errorf("unlisted model: %v, %v", tok, err)
+	}
+	config.Tokenizers["llama3"] = "ollama"
+	if tok, err := newTokenizer(config, nil); err != nil || tok.(*ollamaTokenizer).Base != "http://localhost:11434" {
+		t.Errorf("ollama: %v, %v", tok, err)
+	}
+	config.Tokenizers["llama3"] = "bpe:" + path
+	if tok, err := newTokenizer(config, nil); err != nil || !strings.HasPrefix(tok.Name(), "bpe (cl100k_base.tiktoken") {
+		t.Errorf("bpe: %v, %v", tok, err)
+	}
+	anthropic := &Config{Provider: providerAnthropic, Anthropic: &providerConfig{Model: "claude"}, Tokenizers: map[string]string{"claude": "ollama"}}
+	if _, err := newTokenizer(anthropic, nil); err == nil || err.Error() != `tokenizer "ollama" for model claude needs the Ollama provider` {
+		t.Errorf("ollama tokenizer for another provider: %v", err)
+	}
+}
+
+// TestTokenizerAccuracy counts the eval fixture patches with each tokenizer. Byte by byte,
+// BPE counts every byte; with a vocabulary holding every piece of the patches it counts the
+// pieces, and an Ollama server with the same vocabulary agrees with it exactly.
+func TestTokenizerAccuracy(t *testing.T) {
+	cases, err := loadEvalCases(filepath.Join("testdata", "eval"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	splitter := &bpeTokenizer{pattern: cl100kPattern}
+	seen := make(map[string]bool)
+	var vocabulary []string
+	for _, c := range cases {
+		for _, piece := range splitter.pieces(c.Patch.Text) {
+			if len(piece) > 1 && !seen[piece] {
+				seen[piece] = true
+				vocabulary = append(vocabulary, piece)
+			}
+		}
+	}
+	bpe, err := loadBPETokenizer(writeEncoding(t, "cl100k_base.tiktoken", vocabulary), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bytewise, err := loadBPETokenizer(writeEncoding(t, "cl100k_base.tiktoken", nil), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var chunks []int
+	ollama := &ollamaTokenizer{Base: fakeTokenizeServer(t, bpe, false, &chunks).URL, Model: "tiny:0.5b"}
+	for _, c := range cases {
+		text := c.Patch.Text
+		pieces := len(splitter.pieces(text))
+		heuristic, counted, served := heuristicTokenizer{}.Count(text), bpe.Count(text), ollama.Count(text)
+		if counted != pieces || served != counted {
+			t.Errorf("%s: bpe %d, ollama %d, want %d pieces", c.Name, counted, served, pieces)
+		}
+		if n := bytewise.Count(text); n != len(text) {
+			t.Errorf("%s: byte encoding counts %d tokens for %d bytes", c.Name, n, len(text))
+		}
+		if heuristic != (len(text)+bytesPerToken-1)/bytesPerToken {
+			t.Errorf("%s: heuristic %d for %d bytes", c.Name, heuristic, len(text))
+		}
+		t.Logf("%s: %d bytes, heuristic %d, bpe %d, ollama %d", c.Name, len(text), heuristic, counted, served)
+	}
+}
+
+func TestTruncateTokens(t *testing.T) {
+	bpe, err := loadBPETokenizer(writeEncoding(t, "cl100k_base.tiktoken", []string{"line", " of", " code", "\n"}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Dense text at the start and sparse text at the end defeat a single byte estimate.
+	text := strings.Repeat("line of code\n", 200) + strings.Repeat("x", 2000)
+	size := bpe.Count(text)
+	if got := truncateTokens(bpe, text, size, size, "patch"); got != text {
+		t.Error("text within the limit was cut")
+	}
+	for _, limit := range []int{1000, 500, 100} {
+		cut := truncateTokens(bpe, text, size, limit, "patch")
+		if n := bpe.Count(cut); n > limit || !strings.Contains(cut, "truncated") {
+			t.Errorf("limit %d: %d tokens", limit, n)
+		}
+	}
+}
+
+func TestTokenizerRun(t *testing.T) {
+	repo := newFixtureRepo(t)
+	repo.commits(2)
+	env := newAuditEnv(t)
+	// The tokenizer measures the prompt budget, so it needs a context size.
+	env.Config["context_size"] = 8192
+	env.Config["tokenizers"] = map[string]string{"tiny:0.5b": "bpe:" + writeEncoding(t, "cl100k_base.tiktoken", []string{"package"})}
+	out := env.mustRun("-repo", repo.Dir, "-commit", "root", "-output", filepath.Join(env.Work, "report.txt"))
+	if !strings.Contains(out, "Tokenizer: bpe (cl100k_base.tiktoken, 257 tokens)\n") {
+		t.Errorf("output:\n%s", out)
+	}
+	env.Config["tokenizers"] = map[string]string{"tiny:0.5b": "sentencepiece"}
+	if out, code := env.run("-repo", repo.Dir, "-commit", "root", "-output", filepath.Join(env.Work, "report.txt"), "-force"); code == 0 || !strings.Contains(out, `invalid tokenizer "sentencepiece" for model tiny:0.5b`) {
+		t.Errorf("invalid tokenizer: exit %d\n%s", code, out)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Verification results recorded on CommitAuditData.Verification with -verify-critical.
+const (
+	verificationAgreed        = "agreed"
+	verificationDiscrepancies = "discrepancies"
+	// verificationUnconfirmed is recorded when the reconciliation did not say whether the
+	// two summaries agree; its consolidated text is kept, but nothing was confirmed.
+	verificationUnconfirmed = "unconfirmed"
+)
+
+// verificationSeed samples the second summary of a commit. A fixed seed keeps -record and
+// -replay deterministic; the first summary is sampled as usual.
+const verificationSeed = 7919
+
+// criticalPredicate is the "verify_critical" config block selecting the commits that
+// -verify-critical generates twice. A commit qualifies when it meets any criterion that is set.
+type criticalPredicate struct {
+	// Paths are globs, like never_send; a commit changing a matching file qualifies.
+	Paths []string `json:"paths"`
+	// MinLines qualifies commits that add and remove at least this many lines in total.
+	MinLines int `json:"min_lines"`
+	// Controls qualifies commits that -controls maps to one of these categories.
+	Controls []string `json:"controls"`
+}
+
+// validate checks the predicate against the run's -controls taxonomy, nil without -controls.
+func (c *criticalPredicate) validate(controls *controlMapper) error {
+	if c == nil || (len(c.Paths) == 0 && c.MinLines <= 0 && len(c.Controls) == 0) {
+		return fmt.Errorf("-verify-critical needs verify_critical in the config file to set paths, min_lines or controls")
+	}
+	if len(c.Controls) == 0 {
+		return nil
+	}
+	if controls == nil {
+		return fmt.Errorf("verify_critical.controls needs -controls, which assigns the categories")
+	}
+	for _, name := range c.Controls {
+		if !controls.known[strings.ToLower(strings.TrimSpace(name))] {
+			return fmt.Errorf("verify_critical.controls names %q, which control_taxonomy does not define", name)
+		}
+	}
+	return nil
+}
+
+// changeFootprint is what the predicate looks at: the changed files the model was shown and
+// the number of lines they add and remove.
+type changeFootprint struct {
+	Files []string
+	Lines int
+}
+
+// patchFootprint reads the footprint of a patch.
+func patchFootprint(patch string) changeFootprint {
+	var fp changeFootprint
+	for _, line := range strings.Split(patch, "\n") {
+		if strings.HasPrefix(line, "diff --git ") {
+			fp.Files = append(fp.Files, diffSectionPath(line))
+		}
+	}
+	fp.Lines = countChangedLines(patch)
+	return fp
+}
+
+// shortstatCounts matches the insertions and deletions of a `git diff --shortstat` line.
+var shortstatCounts = regexp.MustCompile(`(\d+) (?:insertion|deletion)s?\([+-]\)`)
+
+// statsFootprint reads the footprint of a diffstat and name-status list.
+func statsFootprint(stats commitStats) changeFootprint {
+	var fp changeFootprint
+	for _, entry := range stats.Files {
+		fields := strings.Split(entry, "\t")
+		fp.Files = append(fp.Files, fields[len(fields)-1])
+	}
+	for _, match := range shortstatCounts.FindAllStringSubmatch(stats.Summary, -1) {
+		n, _ := strconv.Atoi(match[1])
+		fp.Lines += n
+	}
+	return fp
+}
+
+// match returns why a commit with this footprint and these control categories qualifies
+// for verification, or "" when it does not.
+func (c *criticalPredicate) match(fp changeFootprint, controls []string) string {
+	for _, file := range fp.Files {
+		if glob, ok := matchAnyGlob(c.Paths, file); ok {
+			return fmt.Sprintf("%s matches verify_critical path %q", file, glob)
+		}
+	}
+	if c.MinLines > 0 && fp.Lines >= c.MinLines {
+		return fmt.Sprintf("%d changed lines reach verify_critical.min_lines (%d)", fp.Lines, c.MinLines)
+	}
+	for _, category := range controls {
+		for _, name := range c.Controls {
+			if strings.EqualFold(strings.TrimSpace(name), category) {
+				return fmt.Sprintf("mapped to control %s", category)
+			}
+		}
+	}
+	return ""
+}
+
+// buildReconcilePrompt asks the model to compare two independently generated summaries
+// against the material they were written from (the original prompt), and to consolidate them.
+func buildReconcilePrompt(first, second, material string, cite bool) string {
+	citations := ""
+	if cite {
+		citations = "\nKeep the citations of the claims you keep, exactly as they appear in the descriptions."
+	}
+	return fmt.Sprintf(`Two descriptions were generated independently for the same Git commit, from the material shown at the end. Do these two descriptions agree on the facts: what changed, where, and why?
+
+Write one consolidated, audit-ready description that keeps only what the material supports. Then end your answer with the line "Discrepancies: none" if the descriptions agree, or with the line "Discrepancies:" followed by one line starting with "- " for each material disagreement: a claim one description makes that the other contradicts, or that the material does not support. Ignore differences in wording, order or level of detail.%s
+
+Do not include any introductory phrases like "Here is the consolidated description:".
+
+Description A:
+%s
+
+Description B:
+%s
+
+Material:
+%s`, citations, first, second, material)
+}
+
+// discrepanciesLine finds the "Discrepancies:" line that ends a reconciliation.
+var discrepanciesLine = regexp.MustCompile(`(?im)^\s*\**discrepancies\**:\**[ \t]*(.*)$`)
+
+// parseReconciliation splits a reconciliation into the consolidated summary and the listed
+// discrepancies. found is false when the answer has no "Discrepancies:" line.
+func parseReconciliation(text string) (summary string, discrepancies []string, found bool) {
+	locs := discrepanciesLine.FindAllStringSubmatchIndex(text, -1)
+	if len(locs) == 0 {
+		return strings.TrimSpace(text), nil, false
+	}
+	last := locs[len(locs)-1]
+	summary = strings.TrimSpace(text[:last[0]])
+	rest := strings.TrimSpace(text[last[2]:last[3]]) + "\n" + text[last[1]:]
+	for _, line := range strings.Split(rest, "\n") {
+		line = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "-*•"))
+		if line == "" || strings.EqualFold(strings.Trim(line, "."), "none") {
+			continue
+		}
+		discrepancies = append(discrepancies, line)
+	}
+	return summary, discrepancies, true
+}
+
+// verifySummary implements -verify-critical for a qualifying commit: it samples a second
+// summary from prompt with a different seed and has the model reconcile it with first. It
+// returns the consolidated summary and the usage of the two extra calls, and records the
+// result on auditData.
+func verifySummary(ctx context.Context, opts *auditOptions, commitHash, reason, prompt, first string, withheld []withheldFile, auditData *CommitAuditData) (string, tokenUsage, error) {
+	fmt.Printf("Commit %s: %s; generating a second summary to verify it.\n", commitHash, reason)
+	second, err := opts.Generator.GenerateSeeded(ctx, prompt, verificationSeed)
+	if err != nil {
+		return "", tokenUsage{}, fmt.Errorf("failed to call %s for the verification summary: %w", opts.Generator.Name(), err)
+	}
+	usage := second.Usage
+	text := second.Text
+	if opts.Controls != nil {
+		text, _ = opts.Controls.Extract(text)
+	}
+	confidential := opts.Config.NeverSendConfidential && len(withheld) > 0
+	if confidential {
+		// Masked right away: the reconciliation would send the names back to the model.
+		text = maskLeaks(text, findLeaks(text, withheld))
+	}
+
+	reconciled, err := opts.Generator.Generate(ctx, buildReconcilePrompt(first, text, prompt, opts.Cite))
+	usage = usage.add(reconciled.Usage)
+	if err != nil {
+		return "", usage, fmt.Errorf("failed to call %s to reconcile the verification summaries: %w", opts.Generator.Name(), err)
+	}
+	summary, discrepancies, found := parseReconciliation(reconciled.Text)
+	if opts.Controls != nil {
+		// The categories come from the first summary; the material asks for them again.
+		summary, _ = opts.Controls.Extract(summary)
+	}
+	if summary == "" {
+		return "", usage, fmt.Errorf("the reconciliation of the verification summaries contains no description")
+	}
+	if confidential {
+		if leaks := findLeaks(summary, withheld); len(leaks) > 0 {
+			summary = maskLeaks(summary, leaks)
+			auditData.LeakMasked = true
+		}
+		for i, d := range discrepancies {
+			discrepancies[i] = maskLeaks(d, findLeaks(d, withheld))
+		}
+	}
+	switch {
+	case !found:
+		auditData.Verification = verificationUnconfirmed
+		fmt.Printf("Commit %s: the reconciliation did not list discrepancies; the verification is unconfirmed.\n", commitHash)
+	case len(discrepancies) > 0:
+		auditData.Verification = verificationDiscrepancies
+		auditData.Discrepancies = discrepancies
+		fmt.Printf("Commit %s: the two summaries disagree on %d points.\n", commitHash, len(discrepancies))
+	default:
+		auditData.Verification = verificationAgreed
+	}
+	auditData.VerificationUsage = &usage
+	return summary, usage, nil
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// scriptedGenerator answers each prompt with Answer(prompt, seed), seed being -1 for calls
+// without one, and records the seeds it was called with.
+type scriptedGenerator struct {
+	Answer func(prompt string, seed int) string
+	seeds  []int
+}
+
+func (g *scriptedGenerator) Name() string { return "Scripted" }
+
+func (g *scriptedGenerator) Generate(ctx context.Context, prompt string) (generation, error) {
+	return g.GenerateSeeded(ctx, prompt, -1)
+}
+
+func (g *scriptedGenerator) GenerateSeeded(_ context.Context, prompt string, seed int) (generation, error) {
+	g.seeds = append(g.seeds, seed)
+	return generation{Text: g.Answer(prompt, seed), Usage: tokenUsage{PromptTokens: 100, OutputTokens: 10}}, nil
+}
+
+func TestCriticalPredicateValidate(t *testing.T) {
+	controls := &controlMapper{known: map[string]bool{"authn": true}}
+	tests := []struct {
+		predicate *criticalPredicate
+		controls  *controlMapper
+		want      string
+	}{
+		{nil, nil, "-verify-critical needs verify_critical in the config file"},
+		{&criticalPredicate{MinLines: 0}, nil, "-verify-critical needs verify_critical in the config file"},
+		{&criticalPredicate{Paths: []string{"auth/*"}}, nil, ""},
+		{&criticalPredicate{Controls: []string{"AuthN"}}, nil, "verify_critical.controls needs -controls"},
+		{&criticalPredicate{Controls: []string{"AuthN"}}, controls, ""},
+		{&criticalPredicate{Controls: []string{"crypto"}}, controls, `verify_critical.controls names "crypto", which control_taxonomy does not define`},
+	}
+	for _, tt := range tests {
+		err := tt.predicate.validate(tt.controls)
+		if tt.want == "" && err != nil || tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+			t.Errorf("validate(%+v) = %v, want %q", tt.predicate, err, tt.want)
+		}
+	}
+}
+
+func TestCriticalPredicateMatch(t *testing.T) {
+	predicate := &criticalPredicate{Paths: []string{"auth/*"}, MinLines: 50, Controls: []string{"AuthN"}}
+	tests := []struct {
+		fp       changeFootprint
+		controls []string
+		want     string
+	}{
+		{changeFootprint{Files: []string{"README.md", "auth/login.go"}, Lines: 3}, nil, `auth/login.go matches verify_critical path "auth/*"`},
+		{changeFootprint{Files: []string{"src/big.go"}, Lines: 50}, nil, "50 changed lines reach verify_critical.min_lines (50)"},
+		{changeFootprint{Files: []string{"src/big.go"}, Lines: 49}, []string{"authn"}, "mapped to control authn"},
+		{changeFootprint{Files: []string{"src/big.go"}, Lines: 49}, []string{"logging"}, ""},
+	}
+	for _, tt := range tests {
+		if got := predicate.match(tt.fp, tt.controls); got != tt.want {
+			t.Errorf("match(%v, %q) = %q, want %q", tt.fp, tt.controls, got, tt.want)
+		}
+	}
+	// An unset size criterion never qualifies a commit.
+	if got := (&criticalPredicate{Paths: []string{"auth/*"}}).match(changeFootprint{Lines: 10000}, nil); got != "" {
+		t.Errorf("min_lines 0 matched: %q", got)
+	}
+}
+
+func TestFootprints(t *testing.T) {
+	patch := "diff --git a/src/a.go b/src/a.go\n--- a/src/a.go\n+++ b/src/a.go\n@@ -1,2 +1,2 @@\n-old\n+new\n+more\n diff --git a/docs/b.md b/docs/b.md\n"
+	if fp := patchFootprint(patch); !reflect.DeepEqual(fp.Files, []string{"src/a.go"}) || fp.Lines != 3 {
+		t.Errorf("patchFootprint = %+v", fp)
+	}
+	stats := commitStats{Summary: "2 files changed, 7 insertions(+), 1 deletion(-)", Files: []string{"M\tsrc/a.go", "R100\told/b.go\tnew/b.go"}}
+	if fp := statsFootprint(stats); !reflect.DeepEqual(fp.Files, []string{"src/a.go", "new/b.go"}) || fp.Lines != 8 {
+		t.Errorf("statsFootprint = %+v", fp)
+	}
+}
+
+func TestParseReconciliation(t *testing.T) {
+	tests := []struct {
+		text, summary string
+		discrepancies []string
+		found         bool
+	}{
+		{"Adds a login check.\n\nDiscrepancies: none", "Adds a login check.", nil, true},
+		{"Adds a login check.\nDiscrepancies: None.", "Adds a login check.", nil, true},
+		{"Adds a login check.\n\n**Discrepancies:**\n- A says the check is in auth/, B in web/\n* B claims a new flag\n", "Adds a login check.", []string{"A says the check is in auth/, B in web/", "B claims a new flag"}, true},
+		{"Adds a login check.\nDiscrepancies: A names the wrong file", "Adds a login check.", []string{"A names the wrong file"}, true},
+		// Only the last such line ends the description.
+		{"Discrepancies: were resolved in the code.\nAdds a login check.\nDiscrepancies: none", "Discrepancies: were resolved in the code.\nAdds a login check.", nil, true},
+		{"  Adds a login check.  ", "Adds a login check.", nil, false},
+	}
+	for _, tt := range tests {
+		summary, discrepancies, found := parseReconciliation(tt.text)
+		if summary != tt.summary || !reflect.DeepEqual(discrepancies, tt.discrepancies) || found != tt.found {
+			t.Errorf("parseReconciliation(%q) = %q, %q, %v", tt.text, summary, discrepancies, found)
+		}
+	}
+}
+
+func TestVerifySummary(t *testing.T) {
+	tests := []struct {
+		name, reconciliation string
+		verification         string
+		discrepancies        []string
+	}{
+		{"agreeing", "Adds a login check to auth/login.go.\nDiscrepancies: none", verificationAgreed, nil},
+		{"conflicting", "Adds a login check.\nDiscrepancies:\n- A says auth/login.go, B says web/login.go\n- B claims a rate limit the patch lacks", verificationDiscrepancies,
+			[]string{"A says auth/login.go, B says web/login.go", "B claims a rate limit the patch lacks"}},
+		{"unconfirmed", "Adds a login check.", verificationUnconfirmed, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reconcilePrompt string
+			g := &scriptedGenerator{Answer: func(prompt string, seed int) string {
+				if strings.HasPrefix(prompt, "Two descriptions were generated independently") {
+					reconcilePrompt = prompt
+					return tt.reconciliation
+				}
+				return "Adds a login check to web/login.go."
+			}}
+			opts := &auditOptions{Generator: &meteredGenerator{generator: g}, Config: &Config{}}
+			var data CommitAuditData
+			var summary string
+			var usage tokenUsage
+			out := captureStdout(t, func() {
+				var err error
+				summary, usage, err = verifySummary(context.Background(), opts, "abc123", "auth/login.go matches", "the prompt", "Adds a login check to auth/login.go.", nil, &data)
+				if err != nil {
+					t.Fatal(err)
+				}
+			})
+			// The second summary is sampled with the verification seed, the reconciliation as usual.
+			if !reflect.DeepEqual(g.seeds, []int{verificationSeed, -1}) {
+				t.Errorf("seeds %v", g.seeds)
+			}
+			for _, want := range []string{"Description A:\nAdds a login check to auth/login.go.\n", "Description B:\nAdds a login check to web/login.go.\n", "Material:\nthe prompt"} {
+				if !strings.Contains(reconcilePrompt, want) {
+					t.Errorf("reconciliation prompt lacks %q:\n%s", want, reconcilePrompt)
+				}
+			}
+			if !strings.HasPrefix(summary, "Adds a login check") || strings.Contains(summary, "Discrepancies") {
+				t.Errorf("summary %q", summary)
+			}
+			if data.Verification != tt.verification || !reflect.DeepEqual(data.Discrepancies, tt.discrepancies) {
+				t.Errorf("recorded %q %q", data.Verification, data.Discrepancies)
+			}
+			want := tokenUsage{PromptTokens: 200, OutputTokens: 20}
+			if usage != want || data.VerificationUsage == nil || *data.VerificationUsage != want {
+				t.Errorf("usage %+v, recorded %+v", usage, data.VerificationUsage)
+			}
+			if !strings.Contains(out, "Commit abc123: auth/login.go matches; generating a second summary to verify it.") {
+				t.Errorf("output:\n%s", out)
+			}
+		})
+	}
+
+	// Withheld file names are masked in the second summary before it is sent back, and in
+	// the consolidated text and the discrepancies.
+	g := &scriptedGenerator{Answer: func(prompt string, seed int) string {
+		if strings.HasPrefix(prompt, "Two descriptions") {
+			if strings.Contains(prompt, "secrets/prod.env") {
+				t.Error("the reconciliation prompt names a withheld file")
+			}
+			return "Rotates secrets/prod.env.\nDiscrepancies:\n- only B mentions secrets/prod.env"
+		}
+		return "Rotates the key in secrets/prod.env."
+	}}
+	opts := &auditOptions{Generator: &meteredGenerator{generator: g}, Config: &Config{NeverSendConfidential: true}}
+	var data CommitAuditData
+	withheld := []withheldFile{{Path: "secrets/prod.env"}}
+	var summary string
+	captureStdout(t, func() {
+		summary, _, _ = verifySummary(context.Background(), opts, "abc123", "reason", "the prompt", "Rotates a key.", withheld, &data)
+	})
+	if strings.Contains(summary, "secrets/prod.env") || strings.Contains(strings.Join(data.Discrepancies, "\n"), "secrets/prod.env") || !data.LeakMasked {
+		t.Errorf("withheld name leaked: %q %q", summary, data.Discrepancies)
+	}
+}
+
+func TestVerifyCriticalRun(t *testing.T) {
+	repo := newFixtureRepo(t)
+	hashes := repo.commits(4)
+	env := newAuditEnv(t)
+	env.Config["verify_critical"] = criticalPredicate{Paths: []string{"src/file1.go", "src/file3.go"}}
+	// The second summary of file3's commit disagrees with the first; file1's agrees.
+	seen := make(map[string]bool)
+	env.Ollama.Respond = func(n int, prompt string) (int, string) {
+		answer := ""
+		switch {
+		case strings.HasPrefix(prompt, "Two descriptions were generated independently"):
+			answer = "Changes src/file1.go.\nDiscrepancies: none"
+			if strings.Contains(prompt, "src/file3.go") {
+				answer = "Changes src/file3.go.\nDiscrepancies:\n- B says the change removes a variable"
+			}
+		case seen[prompt]:
+			answer = "A second take on " + fakeSummary(prompt)
+		default:
+			seen[prompt] = true
+			return 0, ""
+		}
+		body, _ := json.Marshal(map[string]any{"response": answer, "done": true, "prompt_eval_count": 100, "eval_count": 10})
+		return 200, string(body)
+	}
+
+	report := filepath.Join(env.Work, "report.json")
+	out := env.mustRun("-repo", repo.Dir, "-commit", "root", "-verify-critical", "-format", "json", "-output", report)
+	// Two prompts for each qualifying commit on top of the four summaries.
+	if prompts := env.Ollama.Prompts(); len(prompts) != 8 {
+		t.Errorf("%d prompts sent, want 8", len(prompts))
+	}
+	if !strings.Contains(out, "Verification: 2 commits summarized twice, 1 with discrepancies; overhead ") {
+		t.Errorf("metrics lack the verification overhead:\n%s", out)
+	}
+	var document jsonReport
+	if err := json.Unmarshal([]byte(readFile(t, report)), &document); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]struct {
+		verification, summary string
+		discrepancies         []string
+	}{
+		hashes[0]: {"", "", nil},
+		hashes[1]: {verificationAgreed, "Changes src/file1.go.", nil},
+		hashes[2]: {"", "", nil},
+		hashes[3]: {verificationDiscrepancies, "Changes src/file3.go.", []string{"B says the change removes a variable"}},
+	}
+	for _, entry := range document.Commits {
+		w := want[entry.Hash]
+		if entry.Verification != w.verification || !reflect.DeepEqual(entry.Discrepancies, w.discrepancies) {
+			t.Errorf("%s: verification %q %q, want %q %q", shortHash(entry.Hash), entry.Verification, entry.Discrepancies, w.verification, w.discrepancies)
+		}
+		if w.verification == "" && entry.VerificationUsage != nil || w.verification != "" && (entry.Summary != w.summary || entry.VerificationUsage == nil) {
+			t.Errorf("%s: summary %q, verification usage %v", shortHash(entry.Hash), entry.Summary, entry.VerificationUsage)
+		}
+	}
+
+	// The text report marks the verified entries.
+	text := filepath.Join(env.Work, "report.txt")
+	env.mustRun("-repo", repo.Dir, "-commit", "root", "-verify-critical", "-output", text)
+	content := readFile(t, text)
+	if strings.Count(content, "Verification: summarized twice; the two summaries agree\n") != 1 ||
+		!strings.Contains(content, "Verification: summarized twice; the two summaries disagree on:\n  - B says the change removes a variable\n") {
+		t.Errorf("text report:\n%s", content)
+	}
+
+	// Without verify_critical in the config the flag is refused.
+	delete(env.Config, "verify_critical")
+	if out, code := env.run("-repo", repo.Dir, "-commit", "root", "-verify-critical", "-output", text, "-force"); code == 0 || !strings.Contains(out, "-verify-critical needs verify_critical") {
+		t.Errorf("-verify-critical without a predicate: exit %d\n%s", code, out)
+	}
+}